@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/audit"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var verifyAuditCmd = &cobra.Command{
+	Use:   "verify-audit [file]",
+	Short: "checks that the audit log was not edited",
+	Long:  "checks the hash chain of the audit log and reports the first record that was edited, removed or inserted",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := audit.Path()
+		if len(args) == 1 {
+			path = args[0]
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Printf("failed to open audit log: %v\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		line, err := audit.Verify(file)
+		if err != nil {
+			fmt.Printf("failed to read audit log: %v\n", err)
+			os.Exit(1)
+		}
+		if line != 0 {
+			pterm.Error.Printf("❌ %s was modified, line %d doesn't follow from the line before it\n", path, line)
+			os.Exit(1)
+		}
+		pterm.Success.Printf("✅ %s is intact\n", path)
+	},
+}
@@ -3,8 +3,12 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
+	"github.com/mark-humane/gh-migrate-packages/internal/credentials"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -12,7 +16,20 @@ import (
 func GetFlagOrEnv(cmd *cobra.Command, flags map[string]bool) map[string]string {
 	values := make(map[string]string)
 	var missing []string
-	var isTokenValid bool
+	tokens := make(map[string]string)
+
+	// Credentials of the config file replace the tokens of the organizations
+	// and hosts they name
+	for _, side := range []string{"source", "target"} {
+		organization, _ := cmd.Flags().GetString(side + "-organization")
+		if organization == "" {
+			organization = viper.GetString("GHMPKG_" + strings.ToUpper(side) + "_ORGANIZATION")
+		}
+		if hostname, _ := cmd.Flags().GetString(side + "-hostname"); hostname != "" {
+			viper.Set("GHMPKG_"+strings.ToUpper(side)+"_HOSTNAME", hostname)
+		}
+		credentials.Use(side, organization)
+	}
 
 	for name, required := range flags {
 		// For CLI flags, strip GHMPKG_ prefix if present
@@ -42,22 +59,35 @@ func GetFlagOrEnv(cmd *cobra.Command, flags map[string]bool) map[string]string {
 			viper.Set(envName, value)
 			values[name] = value
 		} else if required {
-			missing = append(missing, flagName)
+			missing = append(missing, envName)
 		}
 
-		//if flagname contains `-token` or envName container `TOKEN`check if token is valid
-		if strings.Contains(flagName, "token") || strings.Contains(envName, "TOKEN") {
-			isTokenValid = checkToken(value)
+		//if flagname contains `-token` or envName container `TOKEN`check if token is valid,
+		//optional tokens that are not set are not requested
+		if (value != "" || required) && (strings.Contains(flagName, "token") || strings.Contains(envName, "TOKEN")) {
+			tokens[envName] = value
 		}
 	}
 
+	// Checked once all values are set, the registry settings decide which
+	// tokens must be GitHub tokens
+	isTokenValid := tokensValid(tokens)
+
+	// Registries like CodeArtifact authenticate with their own credentials,
+	// and credentials of the config file can cover every organization
+	missing = slices.DeleteFunc(missing, func(envName string) bool {
+		return strings.HasSuffix(envName, "_TOKEN") && (registries.TokenOptional(registryOf(envName)) || credentials.Covers(sideOf(envName)))
+	})
 	if len(missing) > 0 {
+		for i, envName := range missing {
+			missing[i] = strings.ReplaceAll(strings.TrimPrefix(strings.ToLower(envName), "ghmpkg_"), "_", "-")
+		}
 		fmt.Fprintf(os.Stderr, "Error: missing required values: %s\n", strings.Join(missing, ", "))
 		os.Exit(1)
 	}
 
 	if !isTokenValid {
-		fmt.Println("Error: token must be a GitHub Personal Access Token.")
+		fmt.Println("Error: token must be a GitHub personal access, OAuth or GitHub App token.")
 		os.Exit(1)
 	}
 
@@ -67,6 +97,15 @@ func GetFlagOrEnv(cmd *cobra.Command, flags map[string]bool) map[string]string {
 func ShowConnectionStatus(actionType string) {
 	var endpoint string
 
+	if (actionType == "export" || actionType == "pull") && registries.SourceName() != registries.GitHub {
+		showRegistry(registries.SourceName(), viper.GetString("GHMPKG_SOURCE_HOSTNAME"))
+		return
+	}
+	if actionType == "sync" && registries.TargetName() != registries.GitHub {
+		showRegistry(registries.TargetName(), viper.GetString("GHMPKG_TARGET_HOSTNAME"))
+		return
+	}
+
 	switch actionType {
 	case "export", "pull":
 		endpoint = "source-hostname"
@@ -80,6 +119,16 @@ func ShowConnectionStatus(actionType string) {
 	//fmt.Println(getProxyStatus())
 }
 
+// showRegistry prints a registry other than GitHub, some like npmjs have a
+// default hostname
+func showRegistry(name, hostname string) {
+	if hostname == "" {
+		fmt.Printf("\n📦 Using: %s\n", name)
+		return
+	}
+	fmt.Printf("\n📦 Using: %s: %s\n", name, hostname)
+}
+
 func getNormalizedEndpoint(key string) string {
 	hostname := viper.GetString(key)
 	if hostname != "" {
@@ -108,6 +157,85 @@ func getProxyStatus() string {
 	return "❎ Proxy: Not configured\n"
 }
 
+// usesGitHub reports whether a token setting belongs to GitHub, tokens of
+// other registries have their own formats
+func usesGitHub(tokenKey string) bool {
+	return registryOf(tokenKey) == registries.GitHub
+}
+
+// registryOf returns the registry a source or target setting belongs to
+func registryOf(key string) string {
+	if strings.HasPrefix(key, "GHMPKG_SOURCE_") {
+		return registries.SourceName()
+	}
+	if strings.HasPrefix(key, "GHMPKG_TARGET_") {
+		return registries.TargetName()
+	}
+	return registries.GitHub
+}
+
+// sideOf returns whether a setting belongs to the source or the target
+func sideOf(key string) string {
+	if strings.HasPrefix(key, "GHMPKG_TARGET_") {
+		return "target"
+	}
+	return "source"
+}
+
+// tokensValid reports whether every token is valid for its registry, a
+// command that takes no token has nothing to check
+func tokensValid(tokens map[string]string) bool {
+	valid := true
+	for envName, value := range tokens {
+		valid = valid && (checkToken(value) || !usesGitHub(envName) || (value == "" && credentials.Covers(sideOf(envName))))
+	}
+	return valid
+}
+
+// checkToken reports whether a token is a GitHub token: a personal access
+// token, an OAuth token or a GitHub App user or installation token
 func checkToken(token string) bool {
-	return strings.HasPrefix(token, "ghp_") || strings.HasPrefix(token, "github_pat_")
+	for _, prefix := range []string{"ghp_", "github_pat_", "gho_", "ghu_", "ghs_"} {
+		if strings.HasPrefix(token, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// exitCode is the process exit code, set from the outcome of the phase a
+// command ran
+var exitCode = common.ExitCodeSuccess
+
+// setExitCode records the outcome of a phase, a phase that returned an
+// error stopped before completing
+func setExitCode(result *common.PhaseSummary, err error) {
+	switch {
+	case err != nil:
+		exitCode = common.ExitCodeAborted
+	case result != nil:
+		exitCode = common.ExitCode(result.ExitStatus)
+	}
+}
+
+// workItemArgs accepts - as the only argument, for reading the packages to
+// handle from stdin
+func workItemArgs(cmd *cobra.Command, args []string) error {
+	if len(args) > 1 || len(args) == 1 && args[0] != "-" {
+		return fmt.Errorf("only - is accepted as an argument, to read work items from stdin")
+	}
+	return nil
+}
+
+// readWorkItems reads the work items from stdin when - was given
+func readWorkItems(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	items, err := common.ReadWorkItems(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read work items from stdin: %w", err)
+	}
+	common.SetWorkItems(items)
+	return nil
 }
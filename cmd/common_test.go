@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestTokensValid(t *testing.T) {
+	defer viper.Set("GHMPKG_TARGET_REGISTRY", "")
+	tests := []struct {
+		name           string
+		targetRegistry string
+		tokens         map[string]string
+		want           bool
+	}{
+		{"no tokens", "", map[string]string{}, true},
+		{"both valid", "", map[string]string{"GHMPKG_SOURCE_TOKEN": "ghp_source", "GHMPKG_TARGET_TOKEN": "github_pat_target"}, true},
+		{"app and oauth tokens", "", map[string]string{"GHMPKG_SOURCE_TOKEN": "gho_source", "GHMPKG_TARGET_TOKEN": "ghs_installation"}, true},
+		{"app user token", "", map[string]string{"GHMPKG_TARGET_TOKEN": "ghu_user"}, true},
+		{"bad source token", "", map[string]string{"GHMPKG_SOURCE_TOKEN": "secret", "GHMPKG_TARGET_TOKEN": "ghp_target"}, false},
+		{"target token not set", "", map[string]string{"GHMPKG_SOURCE_TOKEN": "ghp_source", "GHMPKG_TARGET_TOKEN": ""}, false},
+		{"bad target token", "", map[string]string{"GHMPKG_SOURCE_TOKEN": "ghp_source", "GHMPKG_TARGET_TOKEN": "secret"}, false},
+		{"target of another registry", "artifactory", map[string]string{"GHMPKG_SOURCE_TOKEN": "ghp_source", "GHMPKG_TARGET_TOKEN": "AKCp8secret"}, true},
+		{"bad source with another target registry", "artifactory", map[string]string{"GHMPKG_SOURCE_TOKEN": "secret", "GHMPKG_TARGET_TOKEN": "AKCp8secret"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("GHMPKG_TARGET_REGISTRY", tt.targetRegistry)
+			// Map order is random, a result depending on it shows up
+			// within a few calls
+			for range 20 {
+				if got := tokensValid(tt.tokens); got != tt.want {
+					t.Fatalf("tokensValid(%v) = %v, want %v", tt.tokens, got, tt.want)
+				}
+			}
+		})
+	}
+}
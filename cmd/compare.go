@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+
+	"github.com/mark-humane/gh-migrate-packages/pkg/compare"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var compareCmd = &cobra.Command{
+	Use:     "compare",
+	Aliases: []string{"verify"},
+	Short:   "compares the content of exported packages in the source and target organizations",
+	Long:    "compares the sha256 digest of every exported file, and the manifest digest of every container image, in the source and target organizations and reports the files whose content drifted",
+	Run: func(cmd *cobra.Command, args []string) {
+		GetFlagOrEnv(cmd, map[string]bool{
+			"GHMPKG_SOURCE_HOSTNAME":     false,
+			"GHMPKG_SOURCE_ORGANIZATION": true,
+			"GHMPKG_SOURCE_TOKEN":        true,
+			"GHMPKG_TARGET_HOSTNAME":     false,
+			"GHMPKG_TARGET_ORGANIZATION": true,
+			"GHMPKG_TARGET_TOKEN":        true,
+			"GHMPKG_RETAG":               false,
+			"GHMPKG_PACKAGE_MAPPING":     false,
+		})
+
+		sample, err := compare.ParseSample(viper.GetString("GHMPKG_COMPARE_SAMPLE"))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		// Without a seed every sample picks other versions
+		seed := viper.GetUint64("GHMPKG_COMPARE_SEED")
+		if seed == 0 {
+			seed = rand.Uint64()
+		}
+
+		summary, err := compare.Run(zap.L(), compare.Options{
+			PackageType: viper.GetString("GHMPKG_COMPARE_PACKAGE_TYPE"),
+			Output:      viper.GetString("GHMPKG_COMPARE_OUTPUT"),
+			Sample:      sample,
+			Seed:        seed,
+		})
+		if err != nil {
+			fmt.Printf("failed to compare packages: %v\n", err)
+			os.Exit(1)
+		}
+		if summary.Drift() > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	compareCmd.Flags().String("source-hostname", "", "GitHub Enterprise Server hostname URL of the source (optional)")
+	compareCmd.Flags().String("source-organization", "", "Organization (required)")
+	compareCmd.Flags().String("source-token", "", "GitHub token of the source (required)")
+	compareCmd.Flags().String("target-hostname", "", "GitHub Enterprise Server hostname URL of the target (optional)")
+	compareCmd.Flags().String("target-organization", "", "Organization (required)")
+	compareCmd.Flags().String("target-token", "", "GitHub token of the target (required)")
+	compareCmd.Flags().String("package-type", "", "Package type to compare (optional)")
+	compareCmd.Flags().String("retag", "", "Retag rules the containers were synced with (optional)")
+	compareCmd.Flags().String("package-mapping", "", "Package mapping the NuGet packages were synced with (optional)")
+	compareCmd.Flags().String("output", "", "Path of the report to write (optional, default migration-packages/reports/<timestamp>_compare.csv)")
+	compareCmd.Flags().String("sample", "", "Percentage of versions picked at random to compare, e.g. 5% (optional, default all versions)")
+	compareCmd.Flags().Uint64("seed", 0, "Seed of the sample, to compare the same versions again (optional)")
+
+	viper.BindPFlag("GHMPKG_COMPARE_PACKAGE_TYPE", compareCmd.Flags().Lookup("package-type"))
+	viper.BindPFlag("GHMPKG_COMPARE_OUTPUT", compareCmd.Flags().Lookup("output"))
+	viper.BindPFlag("GHMPKG_COMPARE_SAMPLE", compareCmd.Flags().Lookup("sample"))
+	viper.BindPFlag("GHMPKG_COMPARE_SEED", compareCmd.Flags().Lookup("seed"))
+}
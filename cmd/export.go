@@ -2,8 +2,11 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"strings"
 
-	"github.com/mark-humane/gh-migrate-packages/pkg/export"
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/pkg/migrate"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
@@ -14,29 +17,86 @@ var exportCmd = &cobra.Command{
 	Short: "Exports a list of package data to a CSV file",
 	Long:  "Exports a list of package data to a CSV file",
 	Run: func(cmd *cobra.Command, args []string) {
+		viper.BindPFlag("GHMPKG_NO_CACHE", cmd.Flags().Lookup("no-cache"))
+		viper.BindPFlag("GHMPKG_CONCURRENCY", cmd.Flags().Lookup("concurrency"))
+		// --source-organization can be repeated to export several organizations
+		if organizations, _ := cmd.Flags().GetStringArray("source-organization"); len(organizations) > 0 {
+			viper.Set("GHMPKG_SOURCE_ORGANIZATION", strings.Join(organizations, ","))
+		}
 		GetFlagOrEnv(cmd, map[string]bool{
-			"GHMPKG_SOURCE_HOSTNAME":     false,
-			"GHMPKG_SOURCE_ORGANIZATION": true,
-			"GHMPKG_SOURCE_TOKEN":        true,
-			"GHMPKG_PACKAGE_TYPE":        false,
+			"GHMPKG_SOURCE_HOSTNAME":      false,
+			"GHMPKG_SOURCE_ORGANIZATION":  viper.GetString("GHMPKG_SOURCE_ORGANIZATIONS_FILE") == "",
+			"GHMPKG_SOURCE_TOKEN":         true,
+			"GHMPKG_PACKAGE_TYPE":         false,
+			"GHMPKG_BUNDLE":               false,
+			"GHMPKG_SOURCE_REGISTRY":      false,
+			"GHMPKG_SOURCE_USERNAME":      false,
+			"GHMPKG_SOURCE_REPOSITORIES":  false,
+			"GHMPKG_REPOSITORY":           false,
+			"GHMPKG_SOURCE_PACKAGES":      false,
+			"GHMPKG_SOURCE_PACKAGES_FILE": false,
+			"GHMPKG_INCLUDE_TAGS":         false,
+			"GHMPKG_EXCLUDE_TAGS":         false,
+			"GHMPKG_UNTAGGED":             false,
 		})
+		if _, err := providers.UntaggedPolicy(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 
-		logger := zap.L()
+		exporter := migrate.NewExporter(migrate.Config{Logger: zap.L()})
 		ShowConnectionStatus("export")
-		if err := export.Export(logger); err != nil {
+		result, err := exporter.Export()
+		setExitCode(result, err)
+		if err != nil {
 			fmt.Printf("failed to export packages: %v\n", err)
+			return
+		}
+
+		if bundlePath := viper.GetString("GHMPKG_BUNDLE"); bundlePath != "" {
+			if err := exporter.ExportBundle(bundlePath); err != nil {
+				fmt.Printf("failed to create bundle: %v\n", err)
+				setExitCode(nil, err)
+			}
 		}
 	},
 }
 
 func init() {
 	exportCmd.Flags().StringP("source-hostname", "n", "", "GitHub Enterprise Server hostname URL (optional)")
-	exportCmd.Flags().StringP("source-organization", "o", "", "Organization (required)")
+	exportCmd.Flags().StringArrayP("source-organization", "o", []string{}, "Organization, repeat or separate with commas to export several (required)")
+	exportCmd.Flags().String("source-organizations-file", "", "File listing source organizations to export, one per line (optional)")
 	exportCmd.Flags().StringP("source-token", "t", "", "GitHub token (required)")
 	exportCmd.Flags().StringSliceP("package-types", "p", []string{}, "Package type(s) to process (can be specified multiple times)")
+	exportCmd.Flags().String("bundle", "", "Pull all exported packages and write them to a single tar archive for air-gapped transfer (optional)")
+	exportCmd.Flags().String("source-registry", "", "Registry to export from: github, artifactory, nexus, azure, gitlab or npmjs (optional, default github)")
+	exportCmd.Flags().String("source-username", "", "Username for registries using basic authentication (optional)")
+	exportCmd.Flags().String("source-repositories", "", "Comma separated source registry repositories to export (optional, default all local repositories)")
+	exportCmd.Flags().String("repository", "", "GitHub repository to publish packages exported from other registries to (optional)")
+	exportCmd.Flags().String("source-packages", "", "Comma separated npm packages to mirror with the npmjs registry, e.g. lodash@4.17.21,react@18.x (optional)")
+	exportCmd.Flags().String("source-packages-file", "", "File listing npm packages to mirror with the npmjs registry, one per line (optional)")
+	exportCmd.Flags().String("include-tags", "", "Only export container tags matching this regular expression, e.g. '^v[0-9]+' (optional)")
+	exportCmd.Flags().Int("concurrency", 5, "Packages whose versions are listed at the same time (optional)")
+	exportCmd.Flags().Bool("no-cache", false, "Don't cache API responses between exports (optional)")
+	exportCmd.Flags().Bool("file-sizes", false, "Record the size of every file with a HEAD request, for pull disk space and progress estimates (optional)")
+	exportCmd.Flags().Bool("graphql", false, "List maven, rubygems and nuget versions with the GraphQL API, fewer calls for organizations with many packages (optional)")
+	exportCmd.Flags().String("exclude-tags", "", "Skip container tags matching this regular expression, e.g. '^sha-' (optional)")
+	exportCmd.Flags().String("untagged", "", "Untagged container versions to export: skip, include or referenced by an exported tag (optional, default skip)")
 
 	viper.BindPFlag("GHMPKG_SOURCE_HOSTNAME", exportCmd.Flags().Lookup("source-hostname"))
-	viper.BindPFlag("GHMPKG_SOURCE_ORGANIZATION", exportCmd.Flags().Lookup("source-organization"))
+	viper.BindPFlag("GHMPKG_SOURCE_ORGANIZATIONS_FILE", exportCmd.Flags().Lookup("source-organizations-file"))
 	viper.BindPFlag("GHMPKG_SOURCE_TOKEN", exportCmd.Flags().Lookup("source-token"))
 	viper.BindPFlag("GHMPKG_PACKAGE_TYPES", exportCmd.Flags().Lookup("package-types"))
+	viper.BindPFlag("GHMPKG_BUNDLE", exportCmd.Flags().Lookup("bundle"))
+	viper.BindPFlag("GHMPKG_SOURCE_REGISTRY", exportCmd.Flags().Lookup("source-registry"))
+	viper.BindPFlag("GHMPKG_SOURCE_USERNAME", exportCmd.Flags().Lookup("source-username"))
+	viper.BindPFlag("GHMPKG_SOURCE_REPOSITORIES", exportCmd.Flags().Lookup("source-repositories"))
+	viper.BindPFlag("GHMPKG_REPOSITORY", exportCmd.Flags().Lookup("repository"))
+	viper.BindPFlag("GHMPKG_SOURCE_PACKAGES", exportCmd.Flags().Lookup("source-packages"))
+	viper.BindPFlag("GHMPKG_SOURCE_PACKAGES_FILE", exportCmd.Flags().Lookup("source-packages-file"))
+	viper.BindPFlag("GHMPKG_INCLUDE_TAGS", exportCmd.Flags().Lookup("include-tags"))
+	viper.BindPFlag("GHMPKG_FILE_SIZES", exportCmd.Flags().Lookup("file-sizes"))
+	viper.BindPFlag("GHMPKG_GRAPHQL", exportCmd.Flags().Lookup("graphql"))
+	viper.BindPFlag("GHMPKG_EXCLUDE_TAGS", exportCmd.Flags().Lookup("exclude-tags"))
+	viper.BindPFlag("GHMPKG_UNTAGGED", exportCmd.Flags().Lookup("untagged"))
 }
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mark-humane/gh-migrate-packages/pkg/wizard"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "creates a config file profile interactively",
+	Long:  "asks for the source and target organizations, hostnames, authentication and package types, checks the tokens can reach the organizations and writes them as a profile to the config file",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := wizard.Run(zap.L(), viper.GetString("GHMPKG_CONFIG_FILE"), viper.GetString("GHMPKG_INIT_NAME")); err != nil {
+			fmt.Printf("failed to write profile: %v\n", err)
+			setExitCode(nil, err)
+		}
+	},
+}
+
+func init() {
+	initCmd.Flags().String("name", "", "Name of the profile to write (optional, asked when not set)")
+
+	viper.BindPFlag("GHMPKG_INIT_NAME", initCmd.Flags().Lookup("name"))
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mark-humane/gh-migrate-packages/pkg/manifest"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var manifestCmd = &cobra.Command{
+	Use:   "manifest",
+	Short: "writes a CycloneDX manifest of migrated packages",
+	Long:  "writes a CycloneDX JSON manifest of every file published by the latest sync and migrate runs, with its digest, source URL and target URL",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := zap.L()
+		if _, err := manifest.Generate(logger,
+			viper.GetString("GHMPKG_MANIFEST_PHASE"),
+			viper.GetString("GHMPKG_MANIFEST_STATE_FILE"),
+			viper.GetString("GHMPKG_MANIFEST_OUTPUT")); err != nil {
+			fmt.Printf("failed to generate manifest: %v\n", err)
+		}
+	},
+}
+
+func init() {
+	manifestCmd.Flags().String("phase", "", "Only include runs of this phase: sync or migrate (optional)")
+	manifestCmd.Flags().String("state-file", "", "Describe the run of this state file instead of the latest runs (optional)")
+	manifestCmd.Flags().String("output", "", "Path of the manifest to write (optional, default migration-packages/reports/<timestamp>_manifest.cdx.json)")
+
+	viper.BindPFlag("GHMPKG_MANIFEST_PHASE", manifestCmd.Flags().Lookup("phase"))
+	viper.BindPFlag("GHMPKG_MANIFEST_STATE_FILE", manifestCmd.Flags().Lookup("state-file"))
+	viper.BindPFlag("GHMPKG_MANIFEST_OUTPUT", manifestCmd.Flags().Lookup("output"))
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mark-humane/gh-migrate-packages/pkg/merge"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var mergeResultsCmd = &cobra.Command{
+	Use:   "merge-results [state or results files...]",
+	Short: "merges the results of several runs into one",
+	Long:  "merges the results of sharded or retried pull, sync and migrate runs into a new run with one row per file, the most recent outcome of each file wins. Without arguments every run of --phase is merged.",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := zap.L()
+		_, err := merge.Results(logger, viper.GetString("GHMPKG_MERGE_PHASE"), args)
+		setExitCode(nil, err)
+		if err != nil {
+			fmt.Printf("failed to merge results: %v\n", err)
+		}
+	},
+}
+
+func init() {
+	mergeResultsCmd.Flags().String("phase", "", "Only merge runs of this phase: pull, sync or migrate (optional)")
+
+	viper.BindPFlag("GHMPKG_MERGE_PHASE", mergeResultsCmd.Flags().Lookup("phase"))
+}
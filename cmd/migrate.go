@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mark-humane/gh-migrate-packages/pkg/migrate"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "downloads and publishes packages version by version without staging them locally",
+	Long:  "downloads each exported package version from the source organization and publishes it to the target organization in a single pass, so only one version at a time needs local storage",
+	Run: func(cmd *cobra.Command, args []string) {
+		GetFlagOrEnv(cmd, map[string]bool{
+			"GHMPKG_SOURCE_HOSTNAME":     false,
+			"GHMPKG_SOURCE_ORGANIZATION": true,
+			"GHMPKG_SOURCE_TOKEN":        true,
+			"GHMPKG_TARGET_HOSTNAME":     false,
+			"GHMPKG_TARGET_ORGANIZATION": true,
+			"GHMPKG_TARGET_TOKEN":        true,
+			"GHMPKG_PACKAGE_TYPE":        false,
+			"GHMPKG_SOURCE_REGISTRY":     false,
+			"GHMPKG_SOURCE_USERNAME":     false,
+			"GHMPKG_TARGET_REGISTRY":     false,
+			"GHMPKG_TARGET_USERNAME":     false,
+			"GHMPKG_TARGET_REPOSITORIES": false,
+			"GHMPKG_RETAG":               false,
+			"GHMPKG_PACKAGE_MAPPING":     false,
+			"GHMPKG_NPM_SCOPES":          false,
+			"GHMPKG_SIGNATURE_POLICY":    false,
+			"GHMPKG_GPG_KEYRING":         false,
+			"GHMPKG_COSIGN_KEY":          false,
+			"GHMPKG_COSIGN_IDENTITY":     false,
+			"GHMPKG_COSIGN_ISSUER":       false,
+			"GHMPKG_DELETE_SOURCE":       false,
+			"GHMPKG_MAX_FILE_SIZE":       false,
+			"GHMPKG_CHUNK_SIZE":          false,
+			"GHMPKG_NUGET_CONFLICT":      false,
+			"GHMPKG_STORAGE_BUDGET":      false,
+			"GHMPKG_START_AFTER":         false,
+		})
+
+		// Bound when the command runs, sync and pull share these settings
+		viper.BindPFlag("GHMPKG_INCLUDE_REFERRERS", cmd.Flags().Lookup("include-referrers"))
+		viper.BindPFlag("GHMPKG_CONCURRENCY", cmd.Flags().Lookup("concurrency"))
+		viper.BindPFlag("GHMPKG_CONFIRM_DELETE", cmd.Flags().Lookup("confirm"))
+		viper.BindPFlag("GHMPKG_NPM_DEPENDENCY_ORDER", cmd.Flags().Lookup("npm-dependency-order"))
+		viper.BindPFlag("GHMPKG_REWRITE_IMAGE_SOURCE", cmd.Flags().Lookup("rewrite-image-source"))
+		viper.BindPFlag("GHMPKG_SKIP_MAVEN_METADATA", cmd.Flags().Lookup("skip-maven-metadata"))
+		viper.BindPFlag("GHMPKG_STORAGE_BUDGET_ABORT", cmd.Flags().Lookup("storage-budget-abort"))
+		viper.BindPFlag("GHMPKG_BREAK_LOCK", cmd.Flags().Lookup("break-lock"))
+		viper.BindPFlag("GHMPKG_FORCE", cmd.Flags().Lookup("force"))
+		viper.BindPFlag("GHMPKG_CONFIRM_FORCE", cmd.Flags().Lookup("confirm-force"))
+		viper.BindPFlag("GHMPKG_SKIP_INVALID_NAMES", cmd.Flags().Lookup("skip-invalid-names"))
+
+		migrator := migrate.NewMigrator(migrate.Config{Logger: zap.L()})
+		ShowConnectionStatus("export")
+		ShowConnectionStatus("sync")
+		result, err := migrator.Migrate()
+		setExitCode(result, err)
+		if err != nil {
+			fmt.Printf("failed to migrate packages: %v\n", err)
+		}
+	},
+}
+
+func init() {
+	migrateCmd.Flags().String("source-hostname", "", "GitHub Enterprise Server source hostname URL (optional)")
+	migrateCmd.Flags().StringP("source-organization", "o", "", "Source organization (required)")
+	migrateCmd.Flags().String("source-token", "", "Source GitHub token (required)")
+	migrateCmd.Flags().String("target-hostname", "", "GitHub Enterprise Server target hostname URL (optional)")
+	migrateCmd.Flags().String("target-organization", "", "Target organization (required)")
+	migrateCmd.Flags().String("target-token", "", "Target GitHub token (required)")
+	migrateCmd.Flags().StringP("package-type", "p", "", "Package type to migrate (optional)")
+	migrateCmd.Flags().String("source-registry", "", "Registry the packages were exported from: github, artifactory, nexus, azure, gitlab or npmjs (optional, default github)")
+	migrateCmd.Flags().String("source-username", "", "Username for registries using basic authentication (optional)")
+	migrateCmd.Flags().String("target-registry", "", "Registry to publish to: github, artifactory, azure, codeartifact or artifactregistry (optional, default github)")
+	migrateCmd.Flags().String("target-username", "", "Username for target registries using basic authentication (optional)")
+	migrateCmd.Flags().String("target-repositories", "", "Target registry repository, or per type list like npm=npm-local,maven=libs-release (optional)")
+	migrateCmd.Flags().String("retag", "", "Space separated rules renaming container tags, e.g. '(.*)=legacy-${1}' (optional)")
+	migrateCmd.Flags().String("package-mapping", "", "File of source=target package renames, applied to NuGet package and dependency IDs (optional)")
+	migrateCmd.Flags().String("npm-scopes", "", "Comma separated npm scope renames like @legacy=@acme,@shared=@shared, the source organization scope maps to the target organization by default (optional)")
+	migrateCmd.Flags().String("signature-policy", "", "Verify maven GPG and container cosign signatures before publishing: off, warn, fail or quarantine (optional, default off)")
+	migrateCmd.Flags().String("gpg-keyring", "", "GPG keyring holding the keys maven signatures are verified with (optional, default keyring)")
+	migrateCmd.Flags().String("cosign-key", "", "Public key container signatures are verified with (optional)")
+	migrateCmd.Flags().String("cosign-identity", "", "Regular expression of the keyless signing identity, instead of --cosign-key (optional)")
+	migrateCmd.Flags().String("cosign-issuer", "", "OIDC issuer of the keyless signing identity, e.g. https://token.actions.githubusercontent.com (optional)")
+	migrateCmd.Flags().String("delete-source", "", "Comma separated package types whose migrated versions are deleted from the source organization, e.g. npm,maven (optional)")
+	migrateCmd.Flags().Bool("confirm", false, "Delete from the source instead of logging what would be deleted")
+	migrateCmd.Flags().Bool("npm-dependency-order", false, "Publish npm packages after the packages of the organization they depend on (optional)")
+	migrateCmd.Flags().Bool("skip-maven-metadata", false, "Leave maven-metadata.xml of maven packages as it is instead of regenerating it from the target versions (optional)")
+	migrateCmd.Flags().Bool("rewrite-image-source", false, "Rewrite org.opencontainers.image.source and url labels of container images from the source organization to the target (optional)")
+	migrateCmd.Flags().String("nuget-conflict", "", "What to do with NuGet versions the target already has: skip those with the same content and fail the others, or fail (optional, default skip)")
+	migrateCmd.Flags().String("max-file-size", "", "Skip files larger than this size instead of publishing them, e.g. 2GB (optional)")
+	migrateCmd.Flags().String("chunk-size", "", "Upload container layers larger than this in chunks resumed after dropped connections, e.g. 50MB, 0 for one request (optional, default 100MiB)")
+	migrateCmd.Flags().String("storage-budget", "", "Packages storage the target organization may use, warn when the migration would exceed it, e.g. 50GB (optional)")
+	migrateCmd.Flags().String("start-after", "", "Continue the work list after this package or package@version, e.g. where an earlier run stopped (optional)")
+	migrateCmd.Flags().Bool("storage-budget-abort", false, "Stop before publishing when the storage budget would be exceeded (optional)")
+	migrateCmd.Flags().Bool("skip-invalid-names", false, "Leave out packages whose names GitHub Packages rejects instead of stopping (optional)")
+	migrateCmd.Flags().Bool("force", false, "Delete versions that exist in the target and publish them again, e.g. to replace uploads of a faulty run (optional)")
+	migrateCmd.Flags().Bool("confirm-force", false, "Confirm that --force deletes versions from the target")
+	migrateCmd.Flags().Bool("break-lock", false, "Take over the lock of the target organization held by another run that is no longer running (optional)")
+	migrateCmd.Flags().Bool("include-referrers", false, "Copy cosign signatures, attestations and OCI referrers with container images (optional)")
+	migrateCmd.Flags().Int("concurrency", 5, "Files of a package version downloaded at the same time (optional)")
+}
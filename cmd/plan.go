@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mark-humane/gh-migrate-packages/pkg/sync"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan [-]",
+	Short: "writes the exact list of files a sync would publish",
+	Long:  "resolves the exported packages selected by the filters, or listed on stdin with -, to a JSON plan of the exact files, sizes and target names, for sync --plan to publish, and shows which versions it creates in the target",
+	Args:  workItemArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := readWorkItems(args); err != nil {
+			fmt.Println(err)
+			setExitCode(nil, err)
+			return
+		}
+		GetFlagOrEnv(cmd, map[string]bool{
+			"GHMPKG_SOURCE_ORGANIZATION": true,
+			"GHMPKG_TARGET_HOSTNAME":     false,
+			"GHMPKG_TARGET_ORGANIZATION": true,
+			"GHMPKG_TARGET_REGISTRY":     false,
+			"GHMPKG_TARGET_TOKEN":        false,
+			"GHMPKG_PACKAGE_TYPE":        false,
+			"GHMPKG_PACKAGE_NAME":        false,
+			"GHMPKG_PACKAGE_VERSION":     false,
+			"GHMPKG_RETAG":               false,
+			"GHMPKG_PACKAGE_MAPPING":     false,
+			"GHMPKG_PLAN_SECRET":         false,
+		})
+
+		// Bound when the command runs, sync and migrate share the setting
+		viper.BindPFlag("GHMPKG_SKIP_INVALID_NAMES", cmd.Flags().Lookup("skip-invalid-names"))
+
+		plan, err := sync.WritePlan(zap.L(), viper.GetString("GHMPKG_PLAN_OUTPUT"))
+		setExitCode(nil, err)
+		if err != nil {
+			fmt.Printf("failed to write plan: %v\n", err)
+			return
+		}
+		output := viper.GetString("GHMPKG_PLAN_OUTPUT")
+		if output == "" {
+			output = sync.DefaultPlanFile
+		}
+		if viper.GetString("GHMPKG_TARGET_TOKEN") == "" {
+			pterm.Info.Println("No target token, versions already in the target are shown as created")
+		}
+		fmt.Println()
+		sync.PrintDiff(sync.DiffPlan(zap.L(), plan))
+		fmt.Println()
+
+		signed := "unsigned"
+		if plan.Signature != "" {
+			signed = "signed"
+		}
+		pterm.Success.Printf("📋 Wrote %s plan of %d files to %s\n", signed, len(plan.Items), output)
+		fmt.Printf("Digest: %s\n", plan.Digest)
+	},
+}
+
+func init() {
+	planCmd.Flags().String("source-organization", "", "Organization (required)")
+	planCmd.Flags().String("target-hostname", "", "GitHub Enterprise Server hostname URL (optional)")
+	planCmd.Flags().String("target-organization", "", "Organization (required)")
+	planCmd.Flags().String("target-registry", "", "Registry to publish to: github, artifactory, azure, codeartifact or artifactregistry (optional, default github)")
+	planCmd.Flags().String("package-type", "", "Package type to plan (optional)")
+	planCmd.Flags().String("package-name", "", "Only plan this package of the export (optional)")
+	planCmd.Flags().String("package-version", "", "Only plan this version of --package-name, a tag for container images (optional)")
+	planCmd.Flags().String("retag", "", "Space separated rules renaming container tags, e.g. '(.*)=legacy-${1}' (optional)")
+	planCmd.Flags().String("package-mapping", "", "File of source=target package renames, applied to NuGet package and dependency IDs (optional)")
+	planCmd.Flags().String("target-token", "", "GitHub token to look up the versions already in the target (optional)")
+	planCmd.Flags().String("plan-secret", "", "Secret the plan is signed with, sync --plan needs the same secret (optional)")
+	planCmd.Flags().Bool("skip-invalid-names", false, "Leave out packages whose names GitHub Packages rejects instead of failing (optional)")
+	planCmd.Flags().String("output", "", "Path of the plan to write (optional, default migration-packages/plan.json)")
+
+	viper.BindPFlag("GHMPKG_PLAN_OUTPUT", planCmd.Flags().Lookup("output"))
+}
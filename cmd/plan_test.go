@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/credentials"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/mark-humane/gh-migrate-packages/pkg/sync"
+	"github.com/spf13/viper"
+)
+
+// writeExport writes an npm export of acme to the export directory below
+// the current directory
+func writeExport(t *testing.T) {
+	t.Helper()
+	path := filepath.Join("migration-packages", "export", "npm", "2026-01-02_03-04-05_acme_npm_packages.csv")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := "organization,repository,package_type,package_name,package_version,filename,size\n" +
+		"acme,web,npm,ui,1.0.0,ui-1.0.0.tgz,120\n" +
+		"acme,web,npm,ui,1.1.0,ui-1.1.0.tgz,130\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// resetTarget clears the target flags and settings an earlier run of a
+// command left behind
+func resetTarget() {
+	planCmd.Flags().Set("target-token", "")
+	planCmd.Flags().Set("target-hostname", "")
+	rootCmd.PersistentFlags().Set("target-token-from", "")
+	for _, key := range []string{"GHMPKG_TARGET_TOKEN", "target-token", "GHMPKG_TARGET_HOSTNAME", "target-hostname"} {
+		viper.Set(key, "")
+	}
+	credentials.SetReader("target", nil)
+}
+
+func TestPlanCommand(t *testing.T) {
+	defer func() {
+		resetTarget()
+		exitCode = common.ExitCodeSuccess
+	}()
+
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		// The installation token expired before the plan looked up the target
+		if authorization == "Bearer ghs_expired" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v3/orgs/acme-new/packages/npm/ui":
+			w.Write([]byte(`{"id": 1, "name": "ui", "package_type": "npm"}`))
+		case "/api/v3/orgs/acme-new/packages/npm/ui/versions":
+			w.Write([]byte(`[{"id": 2, "name": "1.0.0"}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name          string
+		args          []string
+		authorization string
+	}{
+		{"without a target token", nil, ""},
+		{"with a target token", []string{"--target-token", "ghp_target", "--target-hostname", server.URL}, "Bearer ghp_target"},
+		{"with a refreshed installation token", []string{"--target-token-from", "command:./mint-installation-token.sh", "--target-hostname", server.URL}, "Bearer ghs_fresh"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wd, _ := os.Getwd()
+			if err := os.Chdir(t.TempDir()); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Chdir(wd)
+			writeExport(t)
+			// Mints an expired installation token first and a fresh one when
+			// it is read again
+			script := "#!/bin/sh\nif [ -f minted ]; then echo ghs_fresh; else touch minted; echo ghs_expired; fi\n"
+			if err := os.WriteFile("mint-installation-token.sh", []byte(script), 0755); err != nil {
+				t.Fatal(err)
+			}
+			resetTarget()
+			authorization = ""
+			exitCode = common.ExitCodeSuccess
+
+			rootCmd.SetArgs(append([]string{"plan", "--source-organization", "acme", "--target-organization", "acme-new", "--package-type", "npm", "--output", "plan.json"}, tt.args...))
+			if err := rootCmd.Execute(); err != nil {
+				t.Fatalf("plan failed: %v", err)
+			}
+			if exitCode != common.ExitCodeSuccess {
+				t.Errorf("exit code = %d, want %d", exitCode, common.ExitCodeSuccess)
+			}
+			plan, err := sync.LoadPlan("plan.json")
+			if err != nil {
+				t.Fatalf("plan was not written: %v", err)
+			}
+			var versions []string
+			for _, item := range plan.Items {
+				versions = append(versions, item.TargetName+"@"+item.TargetVersion)
+			}
+			if got := strings.Join(versions, " "); got != "ui@1.0.0 ui@1.1.0" {
+				t.Errorf("plan items = %s, want ui@1.0.0 ui@1.1.0", got)
+			}
+			if authorization != tt.authorization {
+				t.Errorf("target was looked up with %q, want %q", authorization, tt.authorization)
+			}
+		})
+	}
+}
@@ -3,26 +3,47 @@ package cmd
 import (
 	"fmt"
 
-	"github.com/mark-humane/gh-migrate-packages/pkg/pull"
+	"github.com/mark-humane/gh-migrate-packages/pkg/migrate"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
 var pullCmd = &cobra.Command{
-	Use:   "pull",
+	Use:   "pull [-]",
 	Short: "pulls packages locally from the source organization",
-	Long:  "pulls packages locally from the source organization",
+	Long:  "pulls packages locally from the source organization, or the packages listed on stdin with -",
+	Args:  workItemArgs,
 	Run: func(cmd *cobra.Command, args []string) {
+		if err := readWorkItems(args); err != nil {
+			fmt.Println(err)
+			setExitCode(nil, err)
+			return
+		}
 		GetFlagOrEnv(cmd, map[string]bool{
 			"GHMPKG_SOURCE_HOSTNAME":     false,
 			"GHMPKG_SOURCE_ORGANIZATION": true,
 			"GHMPKG_SOURCE_TOKEN":        true,
+			"GHMPKG_STORAGE_BACKEND":     false,
+			"GHMPKG_STORAGE_BUCKET":      false,
+			"GHMPKG_STORAGE_PREFIX":      false,
+			"GHMPKG_SOURCE_REGISTRY":     false,
+			"GHMPKG_SOURCE_USERNAME":     false,
+			"GHMPKG_PACKAGE_TYPE":        false,
+			"GHMPKG_PACKAGE_NAME":        false,
+			"GHMPKG_PACKAGE_VERSION":     false,
+			"GHMPKG_START_AFTER":         false,
 		})
 
-		logger := zap.L()
+		// Bound when the command runs, pull and migrate share the setting
+		viper.BindPFlag("GHMPKG_INCLUDE_REFERRERS", cmd.Flags().Lookup("include-referrers"))
+		viper.BindPFlag("GHMPKG_CONCURRENCY", cmd.Flags().Lookup("concurrency"))
+
+		puller := migrate.NewPuller(migrate.Config{Logger: zap.L()})
 		ShowConnectionStatus("pull")
-		if err := pull.Pull(logger); err != nil {
+		result, err := puller.Pull()
+		setExitCode(result, err)
+		if err != nil {
 			fmt.Printf("failed to pull packages: %v\n", err)
 		}
 	},
@@ -32,8 +53,34 @@ func init() {
 	pullCmd.Flags().StringP("source-hostname", "n", "", "GitHub Enterprise Server hostname URL (optional)")
 	pullCmd.Flags().StringP("source-organization", "o", "", "Organization (required)")
 	pullCmd.Flags().StringP("source-token", "t", "", "GitHub token (required)")
+	pullCmd.Flags().Bool("skip-space-check", false, "Skip the disk space check before pulling (optional)")
+	pullCmd.Flags().String("min-free-space", "1GB", "Stop pulling when less than this much disk space would remain (optional)")
+	pullCmd.Flags().Bool("no-cache", false, "Disable the content-addressable download cache (optional)")
+	pullCmd.Flags().String("storage-backend", "", "Storage backend for pulled packages: local or gcs (optional, default local)")
+	pullCmd.Flags().String("storage-bucket", "", "Bucket name when using a remote storage backend (optional)")
+	pullCmd.Flags().String("storage-prefix", "", "Object prefix within the storage bucket (optional)")
+	pullCmd.Flags().String("source-registry", "", "Registry the packages were exported from: github, artifactory, nexus, azure, gitlab or npmjs (optional, default github)")
+	pullCmd.Flags().String("source-username", "", "Username for registries using basic authentication (optional)")
+	pullCmd.Flags().Bool("include-referrers", false, "Copy cosign signatures, attestations and OCI referrers with container images (optional)")
+	pullCmd.Flags().String("package-type", "", "Package type to pull (optional)")
+	pullCmd.Flags().String("package-name", "", "Only pull this package of the export (optional)")
+	pullCmd.Flags().String("package-version", "", "Only pull this version of --package-name, a tag for container images (optional)")
+	pullCmd.Flags().String("start-after", "", "Continue the work list after this package or package@version, e.g. where an earlier run stopped (optional)")
+	pullCmd.Flags().Int("concurrency", 5, "Files of a package version downloaded at the same time (optional)")
 
 	viper.BindPFlag("GHMPKG_SOURCE_HOSTNAME", pullCmd.Flags().Lookup("source-hostname"))
 	viper.BindPFlag("GHMPKG_SOURCE_ORGANIZATION", pullCmd.Flags().Lookup("source-organization"))
 	viper.BindPFlag("GHMPKG_SOURCE_TOKEN", pullCmd.Flags().Lookup("source-token"))
+	viper.BindPFlag("GHMPKG_SKIP_SPACE_CHECK", pullCmd.Flags().Lookup("skip-space-check"))
+	viper.BindPFlag("GHMPKG_MIN_FREE_SPACE", pullCmd.Flags().Lookup("min-free-space"))
+	viper.BindPFlag("GHMPKG_NO_CACHE", pullCmd.Flags().Lookup("no-cache"))
+	viper.BindPFlag("GHMPKG_STORAGE_BACKEND", pullCmd.Flags().Lookup("storage-backend"))
+	viper.BindPFlag("GHMPKG_STORAGE_BUCKET", pullCmd.Flags().Lookup("storage-bucket"))
+	viper.BindPFlag("GHMPKG_STORAGE_PREFIX", pullCmd.Flags().Lookup("storage-prefix"))
+	viper.BindPFlag("GHMPKG_SOURCE_REGISTRY", pullCmd.Flags().Lookup("source-registry"))
+	viper.BindPFlag("GHMPKG_SOURCE_USERNAME", pullCmd.Flags().Lookup("source-username"))
+	viper.BindPFlag("GHMPKG_PACKAGE_TYPE", pullCmd.Flags().Lookup("package-type"))
+	viper.BindPFlag("GHMPKG_PACKAGE_NAME", pullCmd.Flags().Lookup("package-name"))
+	viper.BindPFlag("GHMPKG_PACKAGE_VERSION", pullCmd.Flags().Lookup("package-version"))
+	viper.BindPFlag("GHMPKG_START_AFTER", pullCmd.Flags().Lookup("start-after"))
 }
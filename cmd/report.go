@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mark-humane/gh-migrate-packages/pkg/report"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "generates an HTML report of migration runs",
+	Long:  "generates a standalone HTML report from the results of pull, sync and migrate runs with per-type summaries, failures grouped by error and the largest packages",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := zap.L()
+		if _, err := report.Generate(logger,
+			viper.GetString("GHMPKG_REPORT_PHASE"),
+			viper.GetString("GHMPKG_REPORT_STATE_FILE"),
+			viper.GetString("GHMPKG_REPORT_OUTPUT")); err != nil {
+			fmt.Printf("failed to generate report: %v\n", err)
+		}
+	},
+}
+
+func init() {
+	reportCmd.Flags().String("phase", "", "Only report on runs of this phase: pull, sync or migrate (optional)")
+	reportCmd.Flags().String("state-file", "", "Report on the run described by this state file instead of the latest runs (optional)")
+	reportCmd.Flags().String("output", "", "Path of the HTML file to write (optional, default migration-packages/reports/<timestamp>_report.html)")
+
+	viper.BindPFlag("GHMPKG_REPORT_PHASE", reportCmd.Flags().Lookup("phase"))
+	viper.BindPFlag("GHMPKG_REPORT_STATE_FILE", reportCmd.Flags().Lookup("state-file"))
+	viper.BindPFlag("GHMPKG_REPORT_OUTPUT", reportCmd.Flags().Lookup("output"))
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/mark-humane/gh-migrate-packages/pkg/rollback"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback <state or results file>",
+	Short: "deletes the package versions a run published from the target organization",
+	Long:  "deletes from the target organization exactly the package versions recorded as uploaded in the results of a sync or migrate run, so a wave can be undone before it is retried. Without --confirm the versions are only listed.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		GetFlagOrEnv(cmd, map[string]bool{
+			"GHMPKG_TARGET_HOSTNAME":     false,
+			"GHMPKG_TARGET_ORGANIZATION": false,
+			"GHMPKG_TARGET_TOKEN":        true,
+		})
+		summary, err := rollback.Run(zap.L(), args[0], viper.GetBool("GHMPKG_ROLLBACK_CONFIRM"))
+		setExitCode(nil, err)
+		if err != nil {
+			fmt.Printf("failed to roll back: %v\n", err)
+			return
+		}
+		// Versions left in the target mean the wave can't be retried yet
+		if summary.Failed > 0 {
+			exitCode = common.ExitCodePartial
+		}
+	},
+}
+
+func init() {
+	rollbackCmd.Flags().String("target-hostname", "", "GitHub Enterprise Server hostname URL, for results files without a state file (optional)")
+	rollbackCmd.Flags().String("target-organization", "", "Organization to delete from, must match the target of the run when a state file is given (optional)")
+	rollbackCmd.Flags().StringP("target-token", "t", "", "GitHub token with the delete:packages scope (required)")
+	rollbackCmd.Flags().Bool("confirm", false, "Delete the versions instead of listing them")
+
+	viper.BindPFlag("GHMPKG_ROLLBACK_CONFIRM", rollbackCmd.Flags().Lookup("confirm"))
+}
@@ -2,9 +2,22 @@ package cmd
 
 import (
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/mark-humane/gh-migrate-packages/internal/actions"
+	"github.com/mark-humane/gh-migrate-packages/internal/api"
+	"github.com/mark-humane/gh-migrate-packages/internal/credentials"
+	"github.com/mark-humane/gh-migrate-packages/internal/httpdebug"
+	"github.com/mark-humane/gh-migrate-packages/internal/profile"
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/runlog"
+	"github.com/mark-humane/gh-migrate-packages/internal/tracing"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
@@ -17,8 +30,19 @@ var rootCmd = &cobra.Command{
 	Long:  "gh cli extension to migrate packages between organizations",
 }
 
+// shutdownTracing flushes spans once the command has finished
+var shutdownTracing = func() {}
+
 func Execute() error {
-	return rootCmd.Execute()
+	err := rootCmd.Execute()
+	shutdownTracing()
+	if err != nil {
+		exitCode = common.ExitCodeAborted
+	}
+	if exitCode != common.ExitCodeSuccess {
+		os.Exit(exitCode)
+	}
+	return nil
 }
 
 func init() {
@@ -28,20 +52,104 @@ func init() {
 	// rootCmd.PersistentFlags().String("http-proxy", "", "HTTP proxy")
 	// rootCmd.PersistentFlags().String("https-proxy", "", "HTTPS proxy")
 	// rootCmd.PersistentFlags().String("no-proxy", "", "No proxy list")
+	rootCmd.PersistentFlags().String("profile", "", "Profile of the config file to use (optional, default the default_profile of the file)")
+	rootCmd.PersistentFlags().String("config-file", "", "Path of the config file with profiles (optional, default .gh-migrate-packages.yaml)")
+	rootCmd.PersistentFlags().String("source-token-from", "", "Read the source token from env:NAME, file:PATH, command:COMMAND, keychain:SERVICE[/ACCOUNT] or git-credential:HOST (optional)")
+	rootCmd.PersistentFlags().String("target-token-from", "", "Read the target token from env:NAME, file:PATH, command:COMMAND, keychain:SERVICE[/ACCOUNT] or git-credential:HOST (optional)")
+	rootCmd.PersistentFlags().String("token-refresh-interval", "0s", "Read tokens from their token_from again this often, e.g. 50m for installation tokens, 0s only when rejected or about to expire")
+	rootCmd.PersistentFlags().Int("fail-fast", 0, "Stop after this many package versions failed in a row, 0 never stops (optional)")
 	rootCmd.PersistentFlags().Int("retry-max", 3, "Maximum retry attempts")
 	rootCmd.PersistentFlags().String("retry-delay", "1s", "Delay between retries")
+	rootCmd.PersistentFlags().String("http-timeout", "0s", "Limit for a whole HTTP request including its body, 0s for no limit")
+	rootCmd.PersistentFlags().Int("http-max-idle-conns", 100, "Idle connections kept open per host for reuse")
+	rootCmd.PersistentFlags().Bool("http-disable-http2", false, "Use HTTP/1.1 only, for proxies or registries with broken HTTP/2 support")
+	rootCmd.PersistentFlags().Int("api-rate-limit", 0, "GitHub API calls per hour to spread REST calls over, e.g. 5000, 0 for no limit (optional)")
+	rootCmd.PersistentFlags().Int("api-rate-headroom", api.DefaultRateHeadroom, "Percent of the API rate limit left to other automation")
+	rootCmd.PersistentFlags().String("max-bandwidth", "", "Maximum transfer rate for downloads and uploads, e.g. 50MB/s (optional)")
+	rootCmd.PersistentFlags().Bool("no-progress", false, "Disable the live progress bar")
+	rootCmd.PersistentFlags().String("progress-interval", "30s", "How often progress is printed when not attached to a terminal")
+	rootCmd.PersistentFlags().String("projection-interval", "5m", "How often the remaining bytes and projected completion time are logged")
+	rootCmd.PersistentFlags().String("webhook-url", "", "Slack, Teams or generic webhook URL(s) to notify on run start, completion and failures (optional)")
+	rootCmd.PersistentFlags().String("webhook-format", "", "Webhook payload format: slack, teams or generic (optional, detected from the URL)")
+	rootCmd.PersistentFlags().String("webhook-failure-threshold", "", "Notify when this many versions, or this share of versions (e.g. 10%), have failed (optional)")
+	rootCmd.PersistentFlags().String("webhook-template", "", "Path to a Go text/template file for webhook messages (optional)")
+	rootCmd.PersistentFlags().String("hook-pre-download", "", "Command run before each package version is downloaded, a non-zero exit skips the version (optional)")
+	rootCmd.PersistentFlags().String("hook-pre-publish", "", "Command run before each package version is published, a non-zero exit skips the version (optional)")
+	rootCmd.PersistentFlags().String("hook-post-publish", "", "Command run after each package version is published (optional)")
+	rootCmd.PersistentFlags().String("metrics-addr", "", "Serve Prometheus metrics on this address while running, e.g. :9090 (optional)")
+	rootCmd.PersistentFlags().String("log-format", "json", "Log file format: json or console")
+	rootCmd.PersistentFlags().Int("log-max-size", 100, "Rotate the log file once it reaches this many megabytes")
+	rootCmd.PersistentFlags().Int("log-retain-runs", 20, "Number of run log directories to keep, 0 keeps all")
+	rootCmd.PersistentFlags().Bool("debug-http", false, "Log method, URL, status, timing and request IDs of every HTTP call, with credentials redacted")
+	rootCmd.PersistentFlags().String("otel-endpoint", "", "OTLP/HTTP endpoint to export traces to, e.g. http://localhost:4318 (optional)")
+	rootCmd.PersistentFlags().String("work-dir", "", "Directory for scratch files written while packages are rewritten (optional, default migration-packages/work)")
+	rootCmd.PersistentFlags().Bool("keep-artifacts", false, "Keep scratch files and the staged files of published versions instead of removing them")
+	rootCmd.PersistentFlags().Bool("exclude-prereleases", false, "Skip alpha, beta, rc and other prerelease versions of npm, NuGet, maven and RubyGems packages")
+	rootCmd.PersistentFlags().Bool("include-prereleases", false, "Keep prerelease versions, overriding exclude-prereleases of the environment or config file")
+	rootCmd.PersistentFlags().StringSlice("repositories", []string{}, "Only migrate packages linked to these repositories, name or owner/name, repeat or separate with commas (optional)")
+	rootCmd.PersistentFlags().String("repositories-file", "", "CSV file listing the repositories whose packages are migrated in its first column (optional)")
+	rootCmd.PersistentFlags().String("shard", "", "Only process the part i/n of the packages, to split pull, sync and migrate across n runners (optional)")
+	rootCmd.PersistentFlags().String("summary-file", "", "Path of the machine-readable run summary (optional, default migration-packages/summary.json)")
+	rootCmd.PersistentFlags().String("audit-log", "", "Path of the append-only audit log (optional, default migration-packages/audit.jsonl)")
 
 	// Bind flags to viper
 	// viper.BindPFlag("HTTP_PROXY", rootCmd.PersistentFlags().Lookup("http-proxy"))
 	// viper.BindPFlag("HTTPS_PROXY", rootCmd.PersistentFlags().Lookup("https-proxy"))
 	// viper.BindPFlag("NO_PROXY", rootCmd.PersistentFlags().Lookup("no-proxy"))
+	viper.BindPFlag("GHMPKG_PROFILE", rootCmd.PersistentFlags().Lookup("profile"))
+	viper.BindPFlag("GHMPKG_CONFIG_FILE", rootCmd.PersistentFlags().Lookup("config-file"))
+	viper.BindPFlag("GHMPKG_SOURCE_TOKEN_FROM", rootCmd.PersistentFlags().Lookup("source-token-from"))
+	viper.BindPFlag("GHMPKG_TARGET_TOKEN_FROM", rootCmd.PersistentFlags().Lookup("target-token-from"))
+	viper.BindPFlag("GHMPKG_TOKEN_REFRESH_INTERVAL", rootCmd.PersistentFlags().Lookup("token-refresh-interval"))
+	viper.BindPFlag("GHMPKG_FAIL_FAST", rootCmd.PersistentFlags().Lookup("fail-fast"))
 	viper.BindPFlag("RETRY_MAX", rootCmd.PersistentFlags().Lookup("retry-max"))
 	viper.BindPFlag("RETRY_DELAY", rootCmd.PersistentFlags().Lookup("retry-delay"))
+	viper.BindPFlag("GHMPKG_HTTP_TIMEOUT", rootCmd.PersistentFlags().Lookup("http-timeout"))
+	viper.BindPFlag("GHMPKG_HTTP_MAX_IDLE_CONNS", rootCmd.PersistentFlags().Lookup("http-max-idle-conns"))
+	viper.BindPFlag("GHMPKG_HTTP_DISABLE_HTTP2", rootCmd.PersistentFlags().Lookup("http-disable-http2"))
+	viper.BindPFlag("GHMPKG_API_RATE_LIMIT", rootCmd.PersistentFlags().Lookup("api-rate-limit"))
+	viper.BindPFlag("GHMPKG_API_RATE_HEADROOM", rootCmd.PersistentFlags().Lookup("api-rate-headroom"))
+	viper.BindPFlag("GHMPKG_MAX_BANDWIDTH", rootCmd.PersistentFlags().Lookup("max-bandwidth"))
+	viper.BindPFlag("GHMPKG_NO_PROGRESS", rootCmd.PersistentFlags().Lookup("no-progress"))
+	viper.BindPFlag("GHMPKG_PROGRESS_INTERVAL", rootCmd.PersistentFlags().Lookup("progress-interval"))
+	viper.BindPFlag("GHMPKG_PROJECTION_INTERVAL", rootCmd.PersistentFlags().Lookup("projection-interval"))
+	viper.BindPFlag("GHMPKG_WEBHOOK_URL", rootCmd.PersistentFlags().Lookup("webhook-url"))
+	viper.BindPFlag("GHMPKG_WEBHOOK_FORMAT", rootCmd.PersistentFlags().Lookup("webhook-format"))
+	viper.BindPFlag("GHMPKG_WEBHOOK_FAILURE_THRESHOLD", rootCmd.PersistentFlags().Lookup("webhook-failure-threshold"))
+	viper.BindPFlag("GHMPKG_WEBHOOK_TEMPLATE", rootCmd.PersistentFlags().Lookup("webhook-template"))
+	viper.BindPFlag("GHMPKG_HOOK_PRE_DOWNLOAD", rootCmd.PersistentFlags().Lookup("hook-pre-download"))
+	viper.BindPFlag("GHMPKG_HOOK_PRE_PUBLISH", rootCmd.PersistentFlags().Lookup("hook-pre-publish"))
+	viper.BindPFlag("GHMPKG_HOOK_POST_PUBLISH", rootCmd.PersistentFlags().Lookup("hook-post-publish"))
+	viper.BindPFlag("GHMPKG_METRICS_ADDR", rootCmd.PersistentFlags().Lookup("metrics-addr"))
+	viper.BindPFlag("GHMPKG_LOG_FORMAT", rootCmd.PersistentFlags().Lookup("log-format"))
+	viper.BindPFlag("GHMPKG_LOG_MAX_SIZE", rootCmd.PersistentFlags().Lookup("log-max-size"))
+	viper.BindPFlag("GHMPKG_LOG_RETAIN_RUNS", rootCmd.PersistentFlags().Lookup("log-retain-runs"))
+	viper.BindPFlag("GHMPKG_DEBUG_HTTP", rootCmd.PersistentFlags().Lookup("debug-http"))
+	viper.BindPFlag("GHMPKG_OTEL_ENDPOINT", rootCmd.PersistentFlags().Lookup("otel-endpoint"))
+	viper.BindPFlag("GHMPKG_WORK_DIR", rootCmd.PersistentFlags().Lookup("work-dir"))
+	viper.BindPFlag("GHMPKG_KEEP_ARTIFACTS", rootCmd.PersistentFlags().Lookup("keep-artifacts"))
+	viper.BindPFlag("GHMPKG_EXCLUDE_PRERELEASES", rootCmd.PersistentFlags().Lookup("exclude-prereleases"))
+	viper.BindPFlag("GHMPKG_INCLUDE_PRERELEASES", rootCmd.PersistentFlags().Lookup("include-prereleases"))
+	viper.BindPFlag("GHMPKG_REPOSITORIES", rootCmd.PersistentFlags().Lookup("repositories"))
+	viper.BindPFlag("GHMPKG_REPOSITORIES_FILE", rootCmd.PersistentFlags().Lookup("repositories-file"))
+	viper.BindPFlag("GHMPKG_SHARD", rootCmd.PersistentFlags().Lookup("shard"))
+	viper.BindPFlag("GHMPKG_SUMMARY_FILE", rootCmd.PersistentFlags().Lookup("summary-file"))
+	viper.BindPFlag("GHMPKG_AUDIT_LOG", rootCmd.PersistentFlags().Lookup("audit-log"))
 
 	// Add subcommands
+	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(exportCmd)
 	rootCmd.AddCommand(pullCmd)
 	rootCmd.AddCommand(syncCmd)
+	rootCmd.AddCommand(planCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(reportCmd)
+	rootCmd.AddCommand(mergeResultsCmd)
+	rootCmd.AddCommand(manifestCmd)
+	rootCmd.AddCommand(verifyAuditCmd)
+	rootCmd.AddCommand(rollbackCmd)
+	rootCmd.AddCommand(compareCmd)
 
 	// hide -h, --help from global/proxy flags
 	rootCmd.Flags().BoolP("help", "h", false, "")
@@ -64,23 +172,37 @@ func initConfig() {
 	// Read from environment
 	viper.AutomaticEnv()
 
-	// Create a timestamp for the log file name
-	timestamp := time.Now().Format("2006-01-02T15-04-05")
+	// Tokens can be read from a keychain or helper instead of the environment
+	if err := profile.ReadTokenSettings(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 
-	// Define the log directory and file path
-	logDir := "./migration-packages/logs"
-	logFilePath := fmt.Sprintf("%s/%s.log", logDir, timestamp)
+	// A profile of the config file fills in the settings not set otherwise
+	profileName, err := profile.Apply(viper.GetString("GHMPKG_CONFIG_FILE"), viper.GetString("GHMPKG_PROFILE"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to load profile: %v\n", err)
+		os.Exit(1)
+	}
 
-	// Create log directory if it doesn't exist
-	if err := os.MkdirAll(logDir, 0755); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create log directory: %v\n", err)
+	// Registry URLs set per package type replace the derived ones
+	if err := providers.CheckRegistryUrls(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Create the log file
-	logFile, err := os.Create(logFilePath)
+	// Tokens read from flags, files or commands never show up in job logs
+	for _, key := range []string{"GHMPKG_SOURCE_TOKEN", "GHMPKG_TARGET_TOKEN", "GHMPKG_PLAN_SECRET"} {
+		actions.Mask(viper.GetString(key))
+	}
+	for _, token := range credentials.Tokens() {
+		actions.Mask(token)
+	}
+
+	// Every run logs to its own directory, see runlog for the layout
+	logFile, err := runlog.Init()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create log file: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Failed to create log directory: %v\n", err)
 		os.Exit(1)
 	}
 
@@ -88,13 +210,57 @@ func initConfig() {
 	encoderConfig := zap.NewProductionEncoderConfig()
 	encoderConfig.TimeKey = "timestamp"
 	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	var encoder zapcore.Encoder
+	switch format := viper.GetString("GHMPKG_LOG_FORMAT"); format {
+	case "", "json":
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	case "console":
+		encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unsupported log format %q, use json or console\n", format)
+		os.Exit(1)
+	}
 	core := zapcore.NewCore(
-		zapcore.NewJSONEncoder(encoderConfig),
+		encoder,
 		zapcore.AddSync(logFile),
 		zap.InfoLevel,
 	)
-	logger := zap.New(core)
+	logger := zap.New(core).With(zap.String("run_id", utils.RunID()))
 
 	// Replace the global logger with the configured one
 	zap.ReplaceGlobals(logger)
+	if profileName != "" {
+		logger.Info("Using profile", zap.String("profile", profileName))
+	}
+
+	// Every HTTP client shares the configured connection pool settings, and
+	// rejected or expiring tokens are read again
+	http.DefaultTransport = credentials.RefreshTransport(utils.NewTransport(nil))
+	if interval, err := time.ParseDuration(viper.GetString("GHMPKG_TOKEN_REFRESH_INTERVAL")); err == nil {
+		credentials.StartRefresh(interval)
+	}
+	shutdownTracing = tracing.Init(logger)
+
+	go stopOnSignal(logger)
+
+	// Wrapping the default transport covers the API clients and downloads
+	http.DefaultTransport = utils.TrackTransfers(http.DefaultTransport)
+	if httpdebug.Enabled() {
+		http.DefaultTransport = httpdebug.Transport(http.DefaultTransport)
+		logger.Info("HTTP debug logging enabled")
+	}
+	utils.SetupHTTPClient()
+}
+
+// stopOnSignal records the runs in progress as interrupted before the
+// process exits on Ctrl-C or SIGTERM, rows already written are kept
+func stopOnSignal(logger *zap.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	logger.Warn("Stopping", zap.String("signal", sig.String()))
+	common.InterruptRuns(logger)
+	shutdownTracing()
+	os.Exit(130)
 }
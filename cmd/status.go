@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mark-humane/gh-migrate-packages/pkg/status"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "shows the progress of in-flight and completed runs",
+	Long:  "reads the state and results files of pull, sync and migrate runs and shows how many packages, versions and files are pending, succeeded, failed and skipped per package type",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := zap.L()
+		if err := status.Status(logger, viper.GetString("GHMPKG_STATUS_PHASE"), viper.GetString("GHMPKG_STATUS_STATE_FILE")); err != nil {
+			fmt.Printf("failed to show status: %v\n", err)
+		}
+	},
+}
+
+func init() {
+	statusCmd.Flags().String("phase", "", "Only show runs of this phase: pull, sync or migrate (optional)")
+	statusCmd.Flags().String("state-file", "", "Show the run described by this state file instead of the latest runs (optional)")
+
+	viper.BindPFlag("GHMPKG_STATUS_PHASE", statusCmd.Flags().Lookup("phase"))
+	viper.BindPFlag("GHMPKG_STATUS_STATE_FILE", statusCmd.Flags().Lookup("state-file"))
+}
@@ -3,26 +3,66 @@ package cmd
 import (
 	"fmt"
 
-	"github.com/mark-humane/gh-migrate-packages/pkg/sync"
+	"github.com/mark-humane/gh-migrate-packages/pkg/migrate"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
 var syncCmd = &cobra.Command{
-	Use:   "sync",
+	Use:   "sync [-]",
 	Short: "syncs packages to the target organization",
-	Long:  "syncs packages to the target organization",
+	Long:  "syncs packages to the target organization, or the packages listed on stdin with -",
+	Args:  workItemArgs,
 	Run: func(cmd *cobra.Command, args []string) {
+		if err := readWorkItems(args); err != nil {
+			fmt.Println(err)
+			setExitCode(nil, err)
+			return
+		}
 		GetFlagOrEnv(cmd, map[string]bool{
 			"GHMPKG_TARGET_HOSTNAME":     true,
 			"GHMPKG_TARGET_ORGANIZATION": true,
 			"GHMPKG_TARGET_TOKEN":        true,
+			"GHMPKG_STORAGE_BACKEND":     false,
+			"GHMPKG_STORAGE_BUCKET":      false,
+			"GHMPKG_STORAGE_PREFIX":      false,
+			"GHMPKG_FROM_BUNDLE":         false,
+			"GHMPKG_TARGET_REGISTRY":     false,
+			"GHMPKG_TARGET_USERNAME":     false,
+			"GHMPKG_TARGET_REPOSITORIES": false,
+			"GHMPKG_RETAG":               false,
+			"GHMPKG_PACKAGE_MAPPING":     false,
+			"GHMPKG_NPM_SCOPES":          false,
+			"GHMPKG_SIGNATURE_POLICY":    false,
+			"GHMPKG_GPG_KEYRING":         false,
+			"GHMPKG_COSIGN_KEY":          false,
+			"GHMPKG_COSIGN_IDENTITY":     false,
+			"GHMPKG_COSIGN_ISSUER":       false,
+			"GHMPKG_DELETE_SOURCE":       false,
+			"GHMPKG_MAX_FILE_SIZE":       false,
+			"GHMPKG_CHUNK_SIZE":          false,
+			"GHMPKG_STORAGE_BUDGET":      false,
+			"GHMPKG_PACKAGE_TYPE":        false,
+			"GHMPKG_PACKAGE_NAME":        false,
+			"GHMPKG_PACKAGE_VERSION":     false,
+			"GHMPKG_START_AFTER":         false,
+			"GHMPKG_PLAN":                false,
+			"GHMPKG_PLAN_SECRET":         false,
 		})
 
-		logger := zap.L()
+		syncer := migrate.NewSyncer(migrate.Config{Logger: zap.L()})
 		ShowConnectionStatus("sync")
-		if err := sync.Sync(logger); err != nil {
+		if bundlePath := viper.GetString("GHMPKG_FROM_BUNDLE"); bundlePath != "" {
+			if err := syncer.ExtractBundle(bundlePath); err != nil {
+				fmt.Printf("failed to extract bundle: %v\n", err)
+				setExitCode(nil, err)
+				return
+			}
+		}
+		result, err := syncer.Sync()
+		setExitCode(result, err)
+		if err != nil {
 			fmt.Printf("failed to sync packages: %v\n", err)
 		}
 	},
@@ -33,9 +73,77 @@ func init() {
 	syncCmd.Flags().StringP("source-organization", "o", "", "Organization (required)")
 	syncCmd.Flags().StringP("target-organization", "p", "", "Organization (required)")
 	syncCmd.Flags().StringP("target-token", "t", "", "GitHub token (required)")
+	syncCmd.Flags().String("from-bundle", "", "Publish packages from a bundle created with export --bundle (optional)")
+	syncCmd.Flags().String("storage-backend", "", "Storage backend for pulled packages: local or gcs (optional, default local)")
+	syncCmd.Flags().String("storage-bucket", "", "Bucket name when using a remote storage backend (optional)")
+	syncCmd.Flags().String("storage-prefix", "", "Object prefix within the storage bucket (optional)")
+	syncCmd.Flags().String("target-registry", "", "Registry to publish to: github, artifactory, azure, codeartifact or artifactregistry (optional, default github)")
+	syncCmd.Flags().String("target-username", "", "Username for target registries using basic authentication (optional)")
+	syncCmd.Flags().String("target-repositories", "", "Target registry repository, or per type list like npm=npm-local,maven=libs-release (optional)")
+	syncCmd.Flags().String("retag", "", "Space separated rules renaming container tags, e.g. '(.*)=legacy-${1}' (optional)")
+	syncCmd.Flags().String("package-mapping", "", "File of source=target package renames, applied to NuGet package and dependency IDs (optional)")
+	syncCmd.Flags().String("npm-scopes", "", "Comma separated npm scope renames like @legacy=@acme,@shared=@shared, the source organization scope maps to the target organization by default (optional)")
+	syncCmd.Flags().String("signature-policy", "", "Verify maven GPG and container cosign signatures before publishing: off, warn, fail or quarantine (optional, default off)")
+	syncCmd.Flags().String("gpg-keyring", "", "GPG keyring holding the keys maven signatures are verified with (optional, default keyring)")
+	syncCmd.Flags().String("cosign-key", "", "Public key container signatures are verified with (optional)")
+	syncCmd.Flags().String("cosign-identity", "", "Regular expression of the keyless signing identity, instead of --cosign-key (optional)")
+	syncCmd.Flags().String("cosign-issuer", "", "OIDC issuer of the keyless signing identity, e.g. https://token.actions.githubusercontent.com (optional)")
+	syncCmd.Flags().String("delete-source", "", "Comma separated package types whose migrated versions are deleted from the source organization, e.g. npm,maven (optional)")
+	syncCmd.Flags().Bool("confirm", false, "Delete from the source instead of logging what would be deleted")
+	syncCmd.Flags().Bool("npm-dependency-order", false, "Publish npm packages after the packages of the organization they depend on (optional)")
+	syncCmd.Flags().Bool("rewrite-image-source", false, "Rewrite org.opencontainers.image.source and url labels of container images from the source organization to the target (optional)")
+	syncCmd.Flags().Bool("skip-maven-metadata", false, "Leave maven-metadata.xml of maven packages as it is instead of regenerating it from the target versions (optional)")
+	syncCmd.Flags().String("nuget-conflict", "", "What to do with NuGet versions the target already has: skip those with the same content and fail the others, or fail (optional, default skip)")
+	syncCmd.Flags().String("max-file-size", "", "Skip files larger than this size instead of publishing them, e.g. 2GB (optional)")
+	syncCmd.Flags().String("chunk-size", "", "Upload container layers larger than this in chunks resumed after dropped connections, e.g. 50MB, 0 for one request (optional, default 100MiB)")
+	syncCmd.Flags().String("storage-budget", "", "Packages storage the target organization may use, warn when the migration would exceed it, e.g. 50GB (optional)")
+	syncCmd.Flags().String("package-type", "", "Package type to sync (optional)")
+	syncCmd.Flags().String("package-name", "", "Only sync this package of the export (optional)")
+	syncCmd.Flags().String("package-version", "", "Only sync this version of --package-name, a tag for container images (optional)")
+	syncCmd.Flags().String("start-after", "", "Continue the work list after this package or package@version, e.g. where an earlier run stopped (optional)")
+	syncCmd.Flags().String("plan", "", "Publish exactly the files of a plan written by the plan command, refusing when the settings drifted (optional)")
+	syncCmd.Flags().String("plan-secret", "", "Secret the plan was signed with (optional)")
+	syncCmd.Flags().Bool("storage-budget-abort", false, "Stop before publishing when the storage budget would be exceeded (optional)")
+	syncCmd.Flags().Bool("skip-invalid-names", false, "Leave out packages whose names GitHub Packages rejects instead of stopping (optional)")
+	syncCmd.Flags().Bool("force", false, "Delete versions that exist in the target and publish them again, e.g. to replace uploads of a faulty run (optional)")
+	syncCmd.Flags().Bool("confirm-force", false, "Confirm that --force deletes versions from the target")
+	syncCmd.Flags().Bool("break-lock", false, "Take over the lock of the target organization held by another run that is no longer running (optional)")
 
 	viper.BindPFlag("GHMPKG_TARGET_HOSTNAME", syncCmd.Flags().Lookup("target-hostname"))
 	viper.BindPFlag("GHMPKG_SOURCE_ORGANIZATION", syncCmd.Flags().Lookup("source-organization"))
 	viper.BindPFlag("GHMPKG_TARGET_ORGANIZATION", syncCmd.Flags().Lookup("target-organization"))
 	viper.BindPFlag("GHMPKG_TARGET_TOKEN", syncCmd.Flags().Lookup("target-token"))
+	viper.BindPFlag("GHMPKG_FROM_BUNDLE", syncCmd.Flags().Lookup("from-bundle"))
+	viper.BindPFlag("GHMPKG_STORAGE_BACKEND", syncCmd.Flags().Lookup("storage-backend"))
+	viper.BindPFlag("GHMPKG_STORAGE_BUCKET", syncCmd.Flags().Lookup("storage-bucket"))
+	viper.BindPFlag("GHMPKG_STORAGE_PREFIX", syncCmd.Flags().Lookup("storage-prefix"))
+	viper.BindPFlag("GHMPKG_TARGET_REGISTRY", syncCmd.Flags().Lookup("target-registry"))
+	viper.BindPFlag("GHMPKG_TARGET_USERNAME", syncCmd.Flags().Lookup("target-username"))
+	viper.BindPFlag("GHMPKG_TARGET_REPOSITORIES", syncCmd.Flags().Lookup("target-repositories"))
+	viper.BindPFlag("GHMPKG_RETAG", syncCmd.Flags().Lookup("retag"))
+	viper.BindPFlag("GHMPKG_PACKAGE_MAPPING", syncCmd.Flags().Lookup("package-mapping"))
+	viper.BindPFlag("GHMPKG_NPM_SCOPES", syncCmd.Flags().Lookup("npm-scopes"))
+	viper.BindPFlag("GHMPKG_SIGNATURE_POLICY", syncCmd.Flags().Lookup("signature-policy"))
+	viper.BindPFlag("GHMPKG_GPG_KEYRING", syncCmd.Flags().Lookup("gpg-keyring"))
+	viper.BindPFlag("GHMPKG_COSIGN_KEY", syncCmd.Flags().Lookup("cosign-key"))
+	viper.BindPFlag("GHMPKG_COSIGN_IDENTITY", syncCmd.Flags().Lookup("cosign-identity"))
+	viper.BindPFlag("GHMPKG_COSIGN_ISSUER", syncCmd.Flags().Lookup("cosign-issuer"))
+	viper.BindPFlag("GHMPKG_DELETE_SOURCE", syncCmd.Flags().Lookup("delete-source"))
+	viper.BindPFlag("GHMPKG_CONFIRM_DELETE", syncCmd.Flags().Lookup("confirm"))
+	viper.BindPFlag("GHMPKG_NPM_DEPENDENCY_ORDER", syncCmd.Flags().Lookup("npm-dependency-order"))
+	viper.BindPFlag("GHMPKG_REWRITE_IMAGE_SOURCE", syncCmd.Flags().Lookup("rewrite-image-source"))
+	viper.BindPFlag("GHMPKG_SKIP_MAVEN_METADATA", syncCmd.Flags().Lookup("skip-maven-metadata"))
+	viper.BindPFlag("GHMPKG_NUGET_CONFLICT", syncCmd.Flags().Lookup("nuget-conflict"))
+	viper.BindPFlag("GHMPKG_MAX_FILE_SIZE", syncCmd.Flags().Lookup("max-file-size"))
+	viper.BindPFlag("GHMPKG_CHUNK_SIZE", syncCmd.Flags().Lookup("chunk-size"))
+	viper.BindPFlag("GHMPKG_STORAGE_BUDGET", syncCmd.Flags().Lookup("storage-budget"))
+	viper.BindPFlag("GHMPKG_STORAGE_BUDGET_ABORT", syncCmd.Flags().Lookup("storage-budget-abort"))
+	viper.BindPFlag("GHMPKG_BREAK_LOCK", syncCmd.Flags().Lookup("break-lock"))
+	viper.BindPFlag("GHMPKG_FORCE", syncCmd.Flags().Lookup("force"))
+	viper.BindPFlag("GHMPKG_CONFIRM_FORCE", syncCmd.Flags().Lookup("confirm-force"))
+	viper.BindPFlag("GHMPKG_SKIP_INVALID_NAMES", syncCmd.Flags().Lookup("skip-invalid-names"))
+	viper.BindPFlag("GHMPKG_PACKAGE_TYPE", syncCmd.Flags().Lookup("package-type"))
+	viper.BindPFlag("GHMPKG_PACKAGE_NAME", syncCmd.Flags().Lookup("package-name"))
+	viper.BindPFlag("GHMPKG_PACKAGE_VERSION", syncCmd.Flags().Lookup("package-version"))
+	viper.BindPFlag("GHMPKG_START_AFTER", syncCmd.Flags().Lookup("start-after"))
 }
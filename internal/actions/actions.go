@@ -0,0 +1,61 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Enabled reports whether the tool runs in a GitHub Actions job
+func Enabled() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true"
+}
+
+var (
+	dataEscaper     = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	propertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+)
+
+// command formats a workflow command, the runner reads them from stdout
+func command(name, title, message string) string {
+	if title != "" {
+		return fmt.Sprintf("::%s title=%s::%s", name, propertyEscaper.Replace(title), dataEscaper.Replace(message))
+	}
+	return fmt.Sprintf("::%s::%s", name, dataEscaper.Replace(message))
+}
+
+// Mask hides a secret in the log of the job
+func Mask(value string) {
+	if Enabled() && value != "" {
+		fmt.Println(command("add-mask", "", value))
+	}
+}
+
+// Error adds an error annotation to the job
+func Error(title, message string) {
+	if Enabled() {
+		fmt.Println(command("error", title, message))
+	}
+}
+
+// Warning adds a warning annotation to the job
+func Warning(title, message string) {
+	if Enabled() {
+		fmt.Println(command("warning", title, message))
+	}
+}
+
+// WriteSummary appends Markdown to the summary of the job step
+func WriteSummary(markdown string) error {
+	path := os.Getenv("GITHUB_STEP_SUMMARY")
+	if !Enabled() || path == "" {
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(markdown)
+	return err
+}
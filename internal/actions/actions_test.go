@@ -0,0 +1,18 @@
+package actions
+
+import "testing"
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		name, title, message, expected string
+	}{
+		{"add-mask", "", "ghp_secret", "::add-mask::ghp_secret"},
+		{"error", "sync: timeout", "3 files failed\n100% retried", "::error title=sync%3A timeout::3 files failed%0A100%25 retried"},
+		{"warning", "a, b", "x", "::warning title=a%2C b::x"},
+	}
+	for _, test := range tests {
+		if actual := command(test.name, test.title, test.message); actual != test.expected {
+			t.Errorf("command(%q, %q, %q) = %q, expected %q", test.name, test.title, test.message, actual, test.expected)
+		}
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/google/go-github/v62/github"
+	"github.com/mark-humane/gh-migrate-packages/internal/credentials"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
 	"github.com/spf13/viper"
 	"golang.org/x/oauth2"
 )
@@ -68,33 +70,38 @@ func newGitHubClientWithProxy(token string, proxyConfig *ProxyConfig) (*github.C
 		&oauth2.Token{AccessToken: token},
 	)
 
-	transport := &http.Transport{
-		Proxy: func(req *http.Request) (*url.URL, error) {
-			if proxyConfig != nil && proxyConfig.NoProxy != "" {
-				noProxyURLs := strings.Split(proxyConfig.NoProxy, ",")
-				reqHost := req.URL.Host
-				for _, noProxy := range noProxyURLs {
-					if strings.TrimSpace(noProxy) == reqHost {
-						return nil, nil
-					}
+	// Clients are created per call, their connections are pooled by proxy
+	// settings
+	var key ProxyConfig
+	if proxyConfig != nil {
+		key = *proxyConfig
+	}
+	transport := utils.SharedTransport(fmt.Sprintf("api %+v", key), func(req *http.Request) (*url.URL, error) {
+		if proxyConfig != nil && proxyConfig.NoProxy != "" {
+			noProxyURLs := strings.Split(proxyConfig.NoProxy, ",")
+			reqHost := req.URL.Host
+			for _, noProxy := range noProxyURLs {
+				if strings.TrimSpace(noProxy) == reqHost {
+					return nil, nil
 				}
 			}
+		}
 
-			if proxyConfig != nil {
-				if req.URL.Scheme == "https" && proxyConfig.HTTPSProxy != "" {
-					return url.Parse(proxyConfig.HTTPSProxy)
-				}
-				if req.URL.Scheme == "http" && proxyConfig.HTTPProxy != "" {
-					return url.Parse(proxyConfig.HTTPProxy)
-				}
+		if proxyConfig != nil {
+			if req.URL.Scheme == "https" && proxyConfig.HTTPSProxy != "" {
+				return url.Parse(proxyConfig.HTTPSProxy)
 			}
-			return nil, nil
-		},
-	}
+			if req.URL.Scheme == "http" && proxyConfig.HTTPProxy != "" {
+				return url.Parse(proxyConfig.HTTPProxy)
+			}
+		}
+		return nil, nil
+	})
 
 	tc := oauth2.NewClient(ctx, ts)
+	tc.Timeout = utils.HTTPTimeout()
 	tc.Transport = &oauth2.Transport{
-		Base:   transport,
+		Base:   rateLimited(token, &etagTransport{base: credentials.RefreshTransport(transport)}),
 		Source: ts,
 	}
 
@@ -242,3 +249,110 @@ func PackageExists(packageName, packageType string) (bool, error) {
 
 	return true, nil
 }
+
+// AuthenticatedUser returns the login a token belongs to
+func AuthenticatedUser(token, hostname string) (string, error) {
+	client, err := newGitHubClientWithHostname(token, hostname)
+	if err != nil {
+		return "", err
+	}
+	user, _, err := client.Users.Get(context.Background(), "")
+	if err != nil {
+		return "", err
+	}
+	return user.GetLogin(), nil
+}
+
+// Organization returns the login of an organization the token can see
+func Organization(token, hostname, owner string) (string, error) {
+	client, err := newGitHubClientWithHostname(token, hostname)
+	if err != nil {
+		return "", err
+	}
+	org, _, err := client.Organizations.Get(context.Background(), owner)
+	if err != nil {
+		return "", err
+	}
+	return org.GetLogin(), nil
+}
+
+// GetPackage returns a package of an organization
+func GetPackage(token, hostname, owner, packageType, packageName string) (*github.Package, error) {
+	client, err := newGitHubClientWithHostname(token, hostname)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.WithValue(context.Background(), github.SleepUntilPrimaryRateLimitResetWhenRateLimited, true)
+	var pkg *github.Package
+	err = retryOperation(func() error {
+		var err error
+		pkg, _, err = client.Organizations.GetPackage(ctx, owner, packageType, packageName)
+		return err
+	})
+	return pkg, err
+}
+
+// StorageUsed returns the shared Actions and Packages storage an
+// organization is estimated to use this month, in GB
+func StorageUsed(token, hostname, owner string) (float64, error) {
+	client, err := newGitHubClientWithHostname(token, hostname)
+	if err != nil {
+		return 0, err
+	}
+	billing, _, err := client.Billing.GetStorageBillingOrg(context.Background(), owner)
+	if err != nil {
+		return 0, err
+	}
+	return billing.EstimatedStorageForMonth, nil
+}
+
+// PackageVersions lists the active versions of a package in an organization
+func PackageVersions(token, hostname, owner, packageType, packageName string) ([]*github.PackageVersion, error) {
+	client, err := newGitHubClientWithHostname(token, hostname)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.WithValue(context.Background(), github.SleepUntilPrimaryRateLimitResetWhenRateLimited, true)
+	state := "active"
+	var versions []*github.PackageVersion
+	err = retryOperation(func() error {
+		versions = nil
+		opts := &github.PackageListOptions{
+			PackageType: &packageType,
+			State:       &state,
+			ListOptions: github.ListOptions{PerPage: 100, Page: 1},
+		}
+		for {
+			page, response, err := client.Organizations.PackageGetAllVersions(ctx, owner, packageType, packageName, opts)
+			if err != nil {
+				return err
+			}
+			versions = append(versions, page...)
+			if response.NextPage == 0 {
+				return nil
+			}
+			opts.Page = response.NextPage
+		}
+	})
+	return versions, err
+}
+
+// DeletePackageVersion deletes a version of a package in an organization.
+// GitHub refuses to delete the last version of a package, so the package
+// itself is deleted when last is set.
+func DeletePackageVersion(token, hostname, owner, packageType, packageName string, versionID int64, last bool) error {
+	client, err := newGitHubClientWithHostname(token, hostname)
+	if err != nil {
+		return err
+	}
+	ctx := context.WithValue(context.Background(), github.SleepUntilPrimaryRateLimitResetWhenRateLimited, true)
+	return retryOperation(func() error {
+		var err error
+		if last {
+			_, err = client.Organizations.DeletePackage(ctx, owner, packageType, packageName)
+		} else {
+			_, err = client.Organizations.PackageDeleteVersion(ctx, owner, packageType, packageName, versionID)
+		}
+		return err
+	})
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// ETagCacheDir is where export keeps API responses between runs
+const ETagCacheDir = "migration-packages/export/.etag-cache"
+
+var (
+	etagMu      sync.RWMutex
+	etagDir     string
+	etagHits    atomic.Int64
+	etagHeaders = []string{"X-Ratelimit-Limit", "X-Ratelimit-Remaining", "X-Ratelimit-Reset", "X-Ratelimit-Used", "X-Ratelimit-Resource", "Date"}
+)
+
+// SetETagCache caches the responses of GET calls in dir and revalidates
+// them with conditional requests, an empty dir turns the cache off.
+// Unchanged responses are answered with 304 Not Modified, which GitHub
+// doesn't count against the rate limit.
+func SetETagCache(dir string) {
+	etagMu.Lock()
+	defer etagMu.Unlock()
+	etagDir = dir
+	etagHits.Store(0)
+}
+
+// ETagCacheHits returns the number of responses served from the cache since
+// it was set
+func ETagCacheHits() int64 {
+	return etagHits.Load()
+}
+
+// etagEntry is a cached response
+type etagEntry struct {
+	ETag   string      `json:"etag"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// etagPath returns the cache file of a request. Responses depend on what
+// the token may see, so the credentials are part of the key.
+func etagPath(dir string, req *http.Request) string {
+	hash := sha256.Sum256([]byte(req.Header.Get("Authorization") + "\x00" + req.URL.String()))
+	sum := hex.EncodeToString(hash[:])
+	return filepath.Join(dir, sum[:2], sum+".json")
+}
+
+// cachedResponse answers a request with a cached response, taking the rate
+// limit headers from the 304 response that revalidated it
+func cachedResponse(req *http.Request, entry *etagEntry, notModified *http.Response) *http.Response {
+	header := entry.Header.Clone()
+	for _, name := range etagHeaders {
+		if value := notModified.Header.Get(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         notModified.Proto,
+		ProtoMajor:    notModified.ProtoMajor,
+		ProtoMinor:    notModified.ProtoMinor,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(entry.Body)),
+		ContentLength: int64(len(entry.Body)),
+		Request:       req,
+	}
+}
+
+// etagTransport serves unchanged GET responses from the ETag cache
+type etagTransport struct {
+	base http.RoundTripper
+}
+
+func (t *etagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	etagMu.RLock()
+	dir := etagDir
+	etagMu.RUnlock()
+	if dir == "" || req.Method != http.MethodGet {
+		return t.base.RoundTrip(req)
+	}
+
+	path := etagPath(dir, req)
+	var entry *etagEntry
+	if content, err := os.ReadFile(path); err == nil {
+		entry = &etagEntry{}
+		if err := json.Unmarshal(content, entry); err != nil || entry.ETag == "" {
+			entry = nil
+		}
+	}
+	if entry != nil {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", entry.ETag)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified && entry != nil {
+		resp.Body.Close()
+		etagHits.Add(1)
+		return cachedResponse(req, entry, resp), nil
+	}
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" || strings.HasPrefix(resp.Header.Get("Content-Type"), "application/octet-stream") {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	content, err := json.Marshal(etagEntry{ETag: etag, Header: resp.Header, Body: body})
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(path), 0700); err == nil {
+			err = os.WriteFile(path, content, 0600)
+		}
+	}
+	if err != nil {
+		zap.L().Debug("Failed to cache API response", zap.String("url", req.URL.String()), zap.Error(err))
+	}
+	return resp, nil
+}
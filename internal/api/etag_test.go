@@ -0,0 +1,42 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestETagTransport(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-RateLimit-Remaining", "4999")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Link", `<https://api.github.com/orgs/widgets/packages?page=2>; rel="next"`)
+		w.Write([]byte(`[{"name": "widgets"}]`))
+	}))
+	defer server.Close()
+
+	SetETagCache(t.TempDir())
+	defer SetETagCache("")
+	client := &http.Client{Transport: &etagTransport{base: http.DefaultTransport}}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != `[{"name": "widgets"}]` || resp.Header.Get("Link") == "" {
+			t.Errorf("request %d = %d %s %v", i, resp.StatusCode, body, resp.Header)
+		}
+	}
+	if calls != 2 || ETagCacheHits() != 1 {
+		t.Errorf("calls = %d, hits = %d, expected 2 calls and 1 hit", calls, ETagCacheHits())
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// DefaultRateHeadroom is the share of the hourly rate limit, in percent,
+// left to other automation of the organization
+const DefaultRateHeadroom = 20
+
+var (
+	rateLimitersMu sync.Mutex
+	rateLimiters   = map[string]*rateLimiter{}
+)
+
+// rateLimiter spreads the REST API calls made with a token over the hour.
+// Primary rate limits are counted per user, every client of a token shares
+// one limiter.
+type rateLimiter struct {
+	limiter  *rate.Limiter
+	headroom int
+
+	mu         sync.Mutex
+	pauseUntil time.Time
+}
+
+// hourlyRate returns the rate and burst of a bucket using the hourly limit
+// without its headroom. A minute of calls can be made at once.
+func hourlyRate(perHour, headroom int) (rate.Limit, int) {
+	budget := float64(perHour) * float64(100-headroom) / 100
+	burst := int(budget / 60)
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.Limit(budget / 3600), burst
+}
+
+// reserveReached returns when the rate limit resets once the remaining
+// calls of a response are down to the headroom. Only the core limit the
+// REST API counts against is considered.
+func reserveReached(header http.Header, headroom int) (time.Time, bool) {
+	if resource := header.Get("X-RateLimit-Resource"); resource != "" && resource != "core" {
+		return time.Time{}, false
+	}
+	limit, err := strconv.Atoi(header.Get("X-RateLimit-Limit"))
+	if err != nil {
+		return time.Time{}, false
+	}
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil || remaining > limit*headroom/100 {
+		return time.Time{}, false
+	}
+	reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(reset, 0), true
+}
+
+// rateLimiterFor returns the limiter of a token, or nil when
+// GHMPKG_API_RATE_LIMIT is not set
+func rateLimiterFor(token string) *rateLimiter {
+	perHour := viper.GetInt("GHMPKG_API_RATE_LIMIT")
+	if perHour <= 0 {
+		return nil
+	}
+	headroom := DefaultRateHeadroom
+	if viper.GetString("GHMPKG_API_RATE_HEADROOM") != "" {
+		headroom = viper.GetInt("GHMPKG_API_RATE_HEADROOM")
+	}
+	headroom = min(max(headroom, 0), 99)
+
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+	if limiter, ok := rateLimiters[token]; ok {
+		return limiter
+	}
+	limit, burst := hourlyRate(perHour, headroom)
+	limiter := &rateLimiter{limiter: rate.NewLimiter(limit, burst), headroom: headroom}
+	rateLimiters[token] = limiter
+	return limiter
+}
+
+// wait blocks until a call may be made
+func (l *rateLimiter) wait(req *http.Request) error {
+	l.mu.Lock()
+	pause := time.Until(l.pauseUntil)
+	l.mu.Unlock()
+	if pause > 0 {
+		select {
+		case <-time.After(pause):
+		case <-req.Context().Done():
+			return req.Context().Err()
+		}
+	}
+	return l.limiter.Wait(req.Context())
+}
+
+// observe pauses the calls until the rate limit resets once a response
+// shows the remaining calls are down to the headroom
+func (l *rateLimiter) observe(header http.Header) {
+	reset, ok := reserveReached(header, l.headroom)
+	if !ok {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if reset.After(l.pauseUntil) {
+		l.pauseUntil = reset
+		zap.L().Warn("API rate limit headroom reached, pausing until the limit resets",
+			zap.String("remaining", header.Get("X-RateLimit-Remaining")),
+			zap.String("limit", header.Get("X-RateLimit-Limit")),
+			zap.Time("reset", reset))
+	}
+}
+
+// rateLimitedTransport waits for the limiter of its token before every call
+type rateLimitedTransport struct {
+	base    http.RoundTripper
+	limiter *rateLimiter
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.wait(req); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		t.limiter.observe(resp.Header)
+	}
+	return resp, err
+}
+
+// rateLimited wraps a transport with the limiter of a token when a rate
+// limit is configured
+func rateLimited(token string, base http.RoundTripper) http.RoundTripper {
+	limiter := rateLimiterFor(token)
+	if limiter == nil {
+		return base
+	}
+	return &rateLimitedTransport{base: base, limiter: limiter}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHourlyRate(t *testing.T) {
+	limit, burst := hourlyRate(5000, 20)
+	if perHour := float64(limit) * 3600; perHour < 3999 || perHour > 4001 {
+		t.Errorf("hourlyRate(5000, 20) = %v calls per hour, expected 4000", perHour)
+	}
+	if burst != 66 {
+		t.Errorf("hourlyRate(5000, 20) burst = %d, expected 66", burst)
+	}
+	if _, burst := hourlyRate(10, 50); burst != 1 {
+		t.Errorf("hourlyRate(10, 50) burst = %d, expected 1", burst)
+	}
+}
+
+func TestReserveReached(t *testing.T) {
+	header := func(resource, limit, remaining string) http.Header {
+		h := http.Header{}
+		h.Set("X-RateLimit-Resource", resource)
+		h.Set("X-RateLimit-Limit", limit)
+		h.Set("X-RateLimit-Remaining", remaining)
+		h.Set("X-RateLimit-Reset", "1767225600")
+		return h
+	}
+	tests := []struct {
+		header   http.Header
+		expected bool
+	}{
+		{header("core", "5000", "1001"), false},
+		{header("core", "5000", "1000"), true},
+		{header("", "5000", "12"), true},
+		{header("graphql", "5000", "12"), false},
+		{http.Header{}, false},
+	}
+	for _, test := range tests {
+		reset, reached := reserveReached(test.header, 20)
+		if reached != test.expected {
+			t.Errorf("reserveReached(%v) = %v, expected %v", test.header, reached, test.expected)
+		}
+		if reached && !reset.Equal(time.Unix(1767225600, 0)) {
+			t.Errorf("reserveReached(%v) reset = %v", test.header, reset)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+// Package audit writes an append-only JSON lines log of who ran the tool,
+// with which settings, and of every artifact it copied. Every record holds
+// the hash of the record before it so removed or edited lines are evident.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/spf13/viper"
+)
+
+// DefaultFile is where the audit log is written unless GHMPKG_AUDIT_LOG is set
+const DefaultFile = "migration-packages/audit.jsonl"
+
+// Events of the audit log
+const (
+	RunStarted    = "run_started"
+	Artifact      = "artifact"
+	SourceDeleted = "source_deleted"
+	RolledBack    = "rolled_back"
+	Replaced      = "replaced"
+	RunFinished   = "run_finished"
+)
+
+// settings are recorded with every run, they decide what is migrated
+var settings = []string{
+	"GHMPKG_SOURCE_HOSTNAME",
+	"GHMPKG_SOURCE_ORGANIZATION",
+	"GHMPKG_SOURCE_ORGANIZATIONS_FILE",
+	"GHMPKG_SOURCE_REGISTRY",
+	"GHMPKG_TARGET_HOSTNAME",
+	"GHMPKG_TARGET_ORGANIZATION",
+	"GHMPKG_TARGET_REGISTRY",
+	"GHMPKG_SOURCE_NPM_REGISTRY_URL",
+	"GHMPKG_SOURCE_MAVEN_REGISTRY_URL",
+	"GHMPKG_SOURCE_NUGET_REGISTRY_URL",
+	"GHMPKG_SOURCE_RUBYGEMS_REGISTRY_URL",
+	"GHMPKG_SOURCE_CONTAINER_REGISTRY_URL",
+	"GHMPKG_TARGET_NPM_REGISTRY_URL",
+	"GHMPKG_TARGET_MAVEN_REGISTRY_URL",
+	"GHMPKG_TARGET_NUGET_REGISTRY_URL",
+	"GHMPKG_TARGET_RUBYGEMS_REGISTRY_URL",
+	"GHMPKG_TARGET_CONTAINER_REGISTRY_URL",
+	"GHMPKG_TARGET_REPOSITORIES",
+	"GHMPKG_PACKAGE_TYPE",
+	"GHMPKG_PACKAGE_TYPES",
+	"GHMPKG_REPOSITORY",
+	"GHMPKG_INCLUDE_TAGS",
+	"GHMPKG_UNTAGGED",
+	"GHMPKG_EXCLUDE_TAGS",
+	"GHMPKG_RETAG",
+	"GHMPKG_PACKAGE_MAPPING",
+	"GHMPKG_NPM_SCOPES",
+	"GHMPKG_FROM_BUNDLE",
+	"GHMPKG_DELETE_SOURCE",
+	"GHMPKG_CONFIRM_DELETE",
+	"GHMPKG_NPM_DEPENDENCY_ORDER",
+	"GHMPKG_REWRITE_IMAGE_SOURCE",
+	"GHMPKG_SKIP_MAVEN_METADATA",
+	"GHMPKG_NUGET_CONFLICT",
+	"GHMPKG_MAX_FILE_SIZE",
+	"GHMPKG_STORAGE_BUDGET",
+	"GHMPKG_STORAGE_BUDGET_ABORT",
+	"GHMPKG_FORCE",
+	"GHMPKG_CONFIRM_FORCE",
+	"GHMPKG_SKIP_INVALID_NAMES",
+	"GHMPKG_EXCLUDE_PRERELEASES",
+	"GHMPKG_INCLUDE_PRERELEASES",
+	"GHMPKG_REPOSITORIES",
+	"GHMPKG_REPOSITORIES_FILE",
+	"GHMPKG_SHARD",
+	"GHMPKG_START_AFTER",
+	"GHMPKG_PLAN",
+	"GHMPKG_FAIL_FAST",
+	"GHMPKG_PROFILE",
+}
+
+// Actor is who ran the tool
+type Actor struct {
+	User        string `json:"user"`
+	Host        string `json:"host"`
+	SourceLogin string `json:"source_login,omitempty"`
+	TargetLogin string `json:"target_login,omitempty"`
+}
+
+// Record is one line of the audit log
+type Record struct {
+	Time     time.Time         `json:"time"`
+	Event    string            `json:"event"`
+	RunID    string            `json:"run_id"`
+	Phase    string            `json:"phase"`
+	Actor    *Actor            `json:"actor,omitempty"`
+	Command  []string          `json:"command,omitempty"`
+	Settings map[string]string `json:"settings,omitempty"`
+
+	Organization string `json:"organization,omitempty"`
+	Repository   string `json:"repository,omitempty"`
+	PackageType  string `json:"package_type,omitempty"`
+	PackageName  string `json:"package_name,omitempty"`
+	Version      string `json:"package_version,omitempty"`
+	Filename     string `json:"package_filename,omitempty"`
+	SHA256       string `json:"sha256,omitempty"`
+	Bytes        int64  `json:"bytes,omitempty"`
+	Target       string `json:"target,omitempty"`
+
+	ExitStatus string         `json:"exit_status,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Counts     map[string]int `json:"counts,omitempty"`
+
+	// Previous is the SHA-256 of the line before this one
+	Previous string `json:"previous"`
+}
+
+var (
+	mu       sync.Mutex
+	previous *string
+)
+
+// Path returns where the audit log is written
+func Path() string {
+	if path := viper.GetString("GHMPKG_AUDIT_LOG"); path != "" {
+		return path
+	}
+	return DefaultFile
+}
+
+// Settings returns the settings that select what is migrated, leaving out
+// the ones not set
+func Settings() map[string]string {
+	values := make(map[string]string)
+	for _, key := range settings {
+		value := viper.GetString(key)
+		// Settings given as repeated flags are lists
+		if value == "" {
+			value = strings.Join(viper.GetStringSlice(key), ",")
+		}
+		if value != "" {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// Started records the start of a phase with the actor, the command line
+// and the settings that select what is migrated
+func Started(phase string, actor Actor) error {
+	return Write(Record{
+		Event:    RunStarted,
+		Phase:    phase,
+		Actor:    &actor,
+		Command:  Redact(os.Args),
+		Settings: Settings(),
+	})
+}
+
+// CurrentActor identifies the local user, the logins of the tokens are
+// added by the caller as they need the API
+func CurrentActor() Actor {
+	actor := Actor{}
+	if current, err := user.Current(); err == nil {
+		actor.User = current.Username
+	}
+	actor.Host, _ = os.Hostname()
+	return actor
+}
+
+// Write appends a record to the audit log and syncs it to disk
+func Write(record Record) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	path := Path()
+	if previous == nil {
+		last, err := lastLine(path)
+		if err != nil {
+			return err
+		}
+		previous = &last
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now().UTC()
+	}
+	record.RunID = utils.RunID()
+	record.Previous = *previous
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if err := utils.EnsureDirExists(path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	hash := hashLine(line)
+	previous = &hash
+	return nil
+}
+
+// Verify checks the hash chain of an audit log and returns the number of the
+// first line that doesn't follow from the line before it, zero when intact
+func Verify(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	expected := ""
+	for number := 1; scanner.Scan(); number++ {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil || record.Previous != expected {
+			return number, nil
+		}
+		expected = hashLine(scanner.Bytes())
+	}
+	return 0, scanner.Err()
+}
+
+func hashLine(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// lastLine returns the hash of the last record of an existing log
+func lastLine(path string) (string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	var last []byte
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			last = append(last[:0], scanner.Bytes()...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if last == nil {
+		return "", nil
+	}
+	return hashLine(last), nil
+}
+
+// Redact hides the values of flags that carry credentials
+func Redact(args []string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		out[i] = arg
+		if name, _, ok := strings.Cut(arg, "="); ok && secret(name) {
+			out[i] = name + "=***"
+		} else if i > 0 && secret(args[i-1]) && !strings.Contains(args[i-1], "=") {
+			out[i] = "***"
+		}
+	}
+	return out
+}
+
+func secret(flag string) bool {
+	if !strings.HasPrefix(flag, "-") {
+		return false
+	}
+	flag = strings.ToLower(flag)
+	// -t is the shorthand of --target-token and --source-token
+	return flag == "-t" || strings.Contains(flag, "token") || strings.Contains(flag, "password") || strings.Contains(flag, "secret")
+}
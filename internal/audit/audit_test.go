@@ -0,0 +1,47 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestWriteChainsRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	viper.Set("GHMPKG_AUDIT_LOG", path)
+	defer viper.Set("GHMPKG_AUDIT_LOG", "")
+	previous = nil
+
+	for _, name := range []string{"a", "b", "c"} {
+		if err := Write(Record{Event: Artifact, Phase: "sync", PackageName: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A new process continues the chain of the existing log
+	previous = nil
+	if err := Write(Record{Event: RunFinished, Phase: "sync"}); err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := os.ReadFile(path)
+	if line, err := Verify(bytes.NewReader(content)); err != nil || line != 0 {
+		t.Fatalf("intact log failed verification at line %d: %v", line, err)
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	tampered := lines[0] + lines[2] + lines[3]
+	if line, _ := Verify(strings.NewReader(tampered)); line != 2 {
+		t.Errorf("removed line detected at %d, want 2", line)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	got := Redact([]string{"gh-migrate-packages", "sync", "-t", "ghp_secret", "--source-token=ghp_other", "-o", "acme"})
+	want := "gh-migrate-packages sync -t *** --source-token=*** -o acme"
+	if strings.Join(got, " ") != want {
+		t.Errorf("Redact = %v", got)
+	}
+}
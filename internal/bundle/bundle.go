@@ -0,0 +1,252 @@
+package bundle
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ManifestName is the name of the first entry of every bundle
+const ManifestName = "manifest.json"
+
+// ManifestVersion is bumped whenever the bundle layout changes
+const ManifestVersion = 1
+
+// Manifest describes the content of a bundle so that it can be verified and
+// published on the far side of an air gap without any other context.
+type Manifest struct {
+	Version            int       `json:"version"`
+	CreatedAt          time.Time `json:"created_at"`
+	SourceHostname     string    `json:"source_hostname,omitempty"`
+	SourceOrganization string    `json:"source_organization"`
+	PackageTypes       []string  `json:"package_types"`
+	Files              []File    `json:"files"`
+}
+
+// File is a single entry of a bundle, paths are relative to the bundle root
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// Create writes a tar archive containing the manifest followed by every
+// file found below the given paths. Paths are stored relative to baseDir.
+func Create(logger *zap.Logger, bundlePath, baseDir string, paths []string, manifest Manifest) (*Manifest, error) {
+	manifest.Version = ManifestVersion
+	manifest.CreatedAt = time.Now().UTC()
+	manifest.Files = nil
+
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(baseDir, path)
+			if err != nil {
+				return err
+			}
+			size, sum, err := hashFile(path)
+			if err != nil {
+				return err
+			}
+			manifest.Files = append(manifest.Files, File{Path: filepath.ToSlash(rel), Size: size, Sha256: sum})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to collect bundle files from %s: %w", root, err)
+		}
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+
+	if err := os.MkdirAll(filepath.Dir(bundlePath), 0755); err != nil {
+		return nil, err
+	}
+	out, err := os.Create(bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer out.Close()
+
+	tw := tar.NewWriter(out)
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    ManifestName,
+		Mode:    0644,
+		Size:    int64(len(manifestBytes)),
+		ModTime: manifest.CreatedAt,
+	}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(manifestBytes); err != nil {
+		return nil, err
+	}
+
+	for _, file := range manifest.Files {
+		if err := addFile(tw, filepath.Join(baseDir, filepath.FromSlash(file.Path)), file); err != nil {
+			return nil, fmt.Errorf("failed to add %s to bundle: %w", file.Path, err)
+		}
+		logger.Debug("Added file to bundle", zap.String("path", file.Path))
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &manifest, out.Close()
+}
+
+// Extract unpacks a bundle into destDir, verifying every file against the
+// manifest. It fails if a file is missing, unexpected or corrupted.
+func Extract(logger *zap.Logger, bundlePath, destDir string) (*Manifest, error) {
+	in, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer in.Close()
+
+	tr := tar.NewReader(in)
+	header, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	if header.Name != ManifestName {
+		return nil, fmt.Errorf("invalid bundle: first entry is %s, expected %s", header.Name, ManifestName)
+	}
+	var manifest Manifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid bundle manifest: %w", err)
+	}
+	if manifest.Version > ManifestVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", manifest.Version)
+	}
+
+	expected := make(map[string]File, len(manifest.Files))
+	for _, file := range manifest.Files {
+		expected[file.Path] = file
+	}
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		file, ok := expected[header.Name]
+		if !ok {
+			return nil, fmt.Errorf("bundle contains unexpected file: %s", header.Name)
+		}
+		target, err := safeJoin(destDir, header.Name)
+		if err != nil {
+			return nil, err
+		}
+		if err := extractFile(tr, target, file); err != nil {
+			return nil, err
+		}
+		delete(expected, header.Name)
+		logger.Debug("Extracted file from bundle", zap.String("path", header.Name))
+	}
+
+	if len(expected) > 0 {
+		missing := make([]string, 0, len(expected))
+		for path := range expected {
+			missing = append(missing, path)
+		}
+		sort.Strings(missing)
+		return nil, fmt.Errorf("bundle is missing %d file(s): %s", len(missing), strings.Join(missing, ", "))
+	}
+
+	return &manifest, nil
+}
+
+func addFile(tw *tar.Writer, path string, file File) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    file.Path,
+		Mode:    0644,
+		Size:    file.Size,
+		ModTime: time.Now().UTC(),
+	}); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, in, file.Size)
+	return err
+}
+
+func extractFile(r io.Reader, target string, file File) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), r)
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", file.Path, err)
+	}
+	if size != file.Size {
+		return fmt.Errorf("size mismatch for %s: expected %d, got %d", file.Path, file.Size, size)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != file.Sha256 {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", file.Path, file.Sha256, sum)
+	}
+	return nil
+}
+
+func hashFile(path string) (int64, string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer in.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, in)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// safeJoin guards against entries escaping the destination directory
+func safeJoin(destDir, name string) (string, error) {
+	target := filepath.Join(destDir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(destDir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid path in bundle: %s", name)
+	}
+	return target, nil
+}
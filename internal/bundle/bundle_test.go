@@ -0,0 +1,83 @@
+package bundle_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/bundle"
+	"go.uber.org/zap"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+}
+
+func TestCreateAndExtract(t *testing.T) {
+	srcDir := t.TempDir()
+	writeFile(t, filepath.Join(srcDir, "export", "npm", "packages.csv"), "organization,repository\n")
+	writeFile(t, filepath.Join(srcDir, "packages", "org", "npm", "pkg", "1.0.0", "pkg-1.0.0.tgz"), "tarball")
+
+	bundlePath := filepath.Join(t.TempDir(), "bundle.tar")
+	manifest, err := bundle.Create(zap.NewNop(), bundlePath, srcDir,
+		[]string{filepath.Join(srcDir, "export"), filepath.Join(srcDir, "packages")},
+		bundle.Manifest{SourceOrganization: "org", PackageTypes: []string{"npm"}})
+	if err != nil {
+		t.Fatalf("Create returned an error: %v", err)
+	}
+	if len(manifest.Files) != 2 {
+		t.Errorf("Expected 2 files in manifest, got %d", len(manifest.Files))
+	}
+
+	destDir := t.TempDir()
+	extracted, err := bundle.Extract(zap.NewNop(), bundlePath, destDir)
+	if err != nil {
+		t.Fatalf("Extract returned an error: %v", err)
+	}
+	if extracted.SourceOrganization != "org" {
+		t.Errorf("Expected source organization org, got %s", extracted.SourceOrganization)
+	}
+
+	content, err := os.ReadFile(filepath.Join(destDir, "packages", "org", "npm", "pkg", "1.0.0", "pkg-1.0.0.tgz"))
+	if err != nil {
+		t.Fatalf("Extracted file missing: %v", err)
+	}
+	if string(content) != "tarball" {
+		t.Errorf("Unexpected extracted content: %s", content)
+	}
+}
+
+func TestExtractDetectsCorruption(t *testing.T) {
+	srcDir := t.TempDir()
+	writeFile(t, filepath.Join(srcDir, "packages", "file.bin"), "original content")
+
+	bundlePath := filepath.Join(t.TempDir(), "bundle.tar")
+	if _, err := bundle.Create(zap.NewNop(), bundlePath, srcDir, []string{filepath.Join(srcDir, "packages")}, bundle.Manifest{}); err != nil {
+		t.Fatalf("Create returned an error: %v", err)
+	}
+
+	// Flip the file content inside the archive without changing its size
+	data, err := os.ReadFile(bundlePath)
+	if err != nil {
+		t.Fatalf("Failed to read bundle: %v", err)
+	}
+	for i := len(data) - len("original content"); i > 0; i-- {
+		if string(data[i:i+len("original content")]) == "original content" {
+			copy(data[i:], "tampered content")
+			break
+		}
+	}
+	if err := os.WriteFile(bundlePath, data, 0644); err != nil {
+		t.Fatalf("Failed to write bundle: %v", err)
+	}
+
+	if _, err := bundle.Extract(zap.NewNop(), bundlePath, t.TempDir()); err == nil {
+		t.Errorf("Extract did not detect a corrupted file")
+	}
+}
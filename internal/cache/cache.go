@@ -0,0 +1,200 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultRoot is where downloaded content is cached between runs
+const DefaultRoot = "migration-packages/cache"
+
+// Cache is a content-addressable store of downloaded files. Objects are kept
+// under their sha256 and hard-linked into package directories, so identical
+// artifacts published under several versions only occupy disk once. A small
+// index maps download URLs to content hashes so repeated downloads of the
+// same URL are served locally. Objects are hashed when they are stored and
+// hashed again only when their size or modification time changed since.
+type Cache struct {
+	root string
+}
+
+// New creates a cache rooted at the given directory
+func New(root string) *Cache {
+	return &Cache{root: root}
+}
+
+func (c *Cache) objectPath(sum string) string {
+	return filepath.Join(c.root, "objects", "sha256", sum[:2], sum)
+}
+
+func (c *Cache) verifiedPath(sum string) string {
+	return filepath.Join(c.root, "verified", sum[:2], sum)
+}
+
+func (c *Cache) urlPath(url string) string {
+	hash := sha256.Sum256([]byte(url))
+	sum := hex.EncodeToString(hash[:])
+	return filepath.Join(c.root, "urls", sum[:2], sum)
+}
+
+// Lookup returns the hash of the cached content previously downloaded from
+// url, if the object is still present
+func (c *Cache) Lookup(url string) (string, bool) {
+	content, err := os.ReadFile(c.urlPath(url))
+	if err != nil {
+		return "", false
+	}
+	sum := strings.TrimSpace(string(content))
+	if len(sum) != sha256.Size*2 {
+		return "", false
+	}
+	// An object changed on disk, e.g. by editing a linked package file, is
+	// not served again
+	if !c.intact(sum) {
+		return "", false
+	}
+	return sum, true
+}
+
+// stamp identifies the state of an object on disk when it was verified
+func stamp(info os.FileInfo) string {
+	return fmt.Sprintf("%d %d\n", info.Size(), info.ModTime().UnixNano())
+}
+
+// intact reports whether the object with the given hash still has that
+// hash. Objects whose size and modification time are unchanged since they
+// were last verified are not hashed again.
+func (c *Cache) intact(sum string) bool {
+	objectPath := c.objectPath(sum)
+	info, err := os.Stat(objectPath)
+	if err != nil {
+		return false
+	}
+	if verified, err := os.ReadFile(c.verifiedPath(sum)); err == nil && string(verified) == stamp(info) {
+		return true
+	}
+	if actual, err := hashFile(objectPath); err != nil || actual != sum {
+		return false
+	}
+	// Failing to record the verification only costs hashing it again
+	_ = writeAtomic(c.verifiedPath(sum), []byte(stamp(info)))
+	return true
+}
+
+// LinkTo places the cached object with the given hash at outputPath
+func (c *Cache) LinkTo(sum, outputPath string) error {
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return err
+	}
+	return linkOrCopy(c.objectPath(sum), outputPath)
+}
+
+// Store adds a freshly downloaded file to the cache and records the URL it
+// came from. If identical content is already cached, the file is replaced by
+// a link to the existing object. It returns the sha256 of the content.
+func (c *Cache) Store(url, path string) (string, error) {
+	sum, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	objectPath := c.objectPath(sum)
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
+		return "", err
+	}
+
+	// A corrupt object is replaced by the download
+	if _, err := os.Stat(objectPath); err == nil && !c.intact(sum) {
+		if err := os.Remove(objectPath); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := os.Stat(objectPath); err == nil {
+		// Deduplicate, swap the download for a link to the existing object
+		tmpPath := path + ".dedup"
+		if err := linkOrCopy(objectPath, tmpPath); err != nil {
+			return "", err
+		}
+		if err := os.Rename(tmpPath, path); err != nil {
+			os.Remove(tmpPath)
+			return "", err
+		}
+	} else if err := linkOrCopy(path, objectPath); err != nil {
+		return "", err
+	} else if info, err := os.Stat(objectPath); err == nil {
+		// The object was hashed above, record it as verified
+		if err := writeAtomic(c.verifiedPath(sum), []byte(stamp(info))); err != nil {
+			return "", err
+		}
+	}
+
+	if err := writeAtomic(c.urlPath(url), []byte(sum+"\n")); err != nil {
+		return "", err
+	}
+	return sum, nil
+}
+
+func hashFile(path string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, in); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// link creates hard links, replaced in tests to cover the copy fallback
+var link = os.Link
+
+// linkOrCopy hard links src to dst, copying when linking is not possible
+// (e.g. across filesystems)
+func linkOrCopy(src, dst string) error {
+	err := link(src, dst)
+	if err == nil || os.IsExist(err) {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmpPath := dst + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, dst)
+}
+
+func writeAtomic(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
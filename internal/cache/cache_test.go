@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeDownload(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func objects(t *testing.T, root string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(root, "objects", "sha256", "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func sameFile(t *testing.T, a, b string) bool {
+	t.Helper()
+	infoA, err := os.Stat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+func TestStoreDeduplicates(t *testing.T) {
+	dir := t.TempDir()
+	c := New(filepath.Join(dir, "cache"))
+	first := filepath.Join(dir, "packages", "ui", "1.0.0", "ui-1.0.0.tgz")
+	second := filepath.Join(dir, "packages", "ui", "1.0.1", "ui-1.0.1.tgz")
+	writeDownload(t, first, "tarball")
+	writeDownload(t, second, "tarball")
+
+	firstSum, err := c.Store("https://registry/ui-1.0.0.tgz", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondSum, err := c.Store("https://registry/ui-1.0.1.tgz", second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if firstSum != secondSum {
+		t.Errorf("Store() = %s and %s for the same content", firstSum, secondSum)
+	}
+	if paths := objects(t, c.root); len(paths) != 1 {
+		t.Fatalf("objects = %v, want one entry", paths)
+	}
+	if !sameFile(t, first, second) || !sameFile(t, first, c.objectPath(firstSum)) {
+		t.Error("Store() did not hard link the downloads to the cached object")
+	}
+
+	sum, ok := c.Lookup("https://registry/ui-1.0.1.tgz")
+	if !ok || sum != firstSum {
+		t.Errorf("Lookup() = %s, %v, want %s", sum, ok, firstSum)
+	}
+	linked := filepath.Join(dir, "packages", "ui", "1.0.2", "ui-1.0.2.tgz")
+	if err := c.LinkTo(sum, linked); err != nil {
+		t.Fatal(err)
+	}
+	if !sameFile(t, first, linked) {
+		t.Error("LinkTo() did not hard link the cached object")
+	}
+}
+
+func TestStoreCopiesWhenLinkingFails(t *testing.T) {
+	link = func(src, dst string) error { return errors.New("cross-device link") }
+	defer func() { link = os.Link }()
+
+	dir := t.TempDir()
+	c := New(filepath.Join(dir, "cache"))
+	first := filepath.Join(dir, "packages", "ui-1.0.0.tgz")
+	second := filepath.Join(dir, "packages", "ui-1.0.1.tgz")
+	writeDownload(t, first, "tarball")
+	writeDownload(t, second, "tarball")
+
+	sum, err := c.Store("https://registry/ui-1.0.0.tgz", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Store("https://registry/ui-1.0.1.tgz", second); err != nil {
+		t.Fatal(err)
+	}
+	if sameFile(t, first, c.objectPath(sum)) || sameFile(t, first, second) {
+		t.Error("Store() linked files although linking fails")
+	}
+	for _, path := range []string{c.objectPath(sum), second} {
+		if content, err := os.ReadFile(path); err != nil || string(content) != "tarball" {
+			t.Errorf("%s = %q, %v, want a copy of the download", path, content, err)
+		}
+	}
+}
+
+func TestStoreReplacesCorruptObject(t *testing.T) {
+	dir := t.TempDir()
+	c := New(filepath.Join(dir, "cache"))
+	first := filepath.Join(dir, "packages", "ui-1.0.0.tgz")
+	writeDownload(t, first, "tarball")
+	sum, err := c.Store("https://registry/ui-1.0.0.tgz", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Editing the linked package file corrupts the object
+	if err := os.WriteFile(first, []byte("rewritten"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Lookup("https://registry/ui-1.0.0.tgz"); ok {
+		t.Error("Lookup() served a corrupt object")
+	}
+
+	second := filepath.Join(dir, "packages", "ui-1.0.1.tgz")
+	writeDownload(t, second, "tarball")
+	if _, err := c.Store("https://registry/ui-1.0.1.tgz", second); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(second); err != nil || string(content) != "tarball" {
+		t.Errorf("download = %q, %v, want it kept over the corrupt object", content, err)
+	}
+	if content, err := os.ReadFile(c.objectPath(sum)); err != nil || string(content) != "tarball" {
+		t.Errorf("object = %q, %v, want it replaced by the download", content, err)
+	}
+	if _, ok := c.Lookup("https://registry/ui-1.0.0.tgz"); !ok {
+		t.Error("Lookup() missed the replaced object")
+	}
+}
+
+func TestLookupVerifiesChangedObjects(t *testing.T) {
+	dir := t.TempDir()
+	c := New(filepath.Join(dir, "cache"))
+	first := filepath.Join(dir, "packages", "ui-1.0.0.tgz")
+	writeDownload(t, first, "tarball")
+	sum, err := c.Store("https://registry/ui-1.0.0.tgz", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objectPath := c.objectPath(sum)
+	info, err := os.Stat(objectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An object unchanged since it was stored is not hashed again, content
+	// swapped in place under the same size and time goes unnoticed
+	if err := os.WriteFile(objectPath, []byte("TARBALL"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(objectPath, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Lookup("https://registry/ui-1.0.0.tgz"); !ok {
+		t.Error("Lookup() hashed an object that did not change since it was stored")
+	}
+
+	// A newer modification time gets the object hashed again
+	later := info.ModTime().Add(time.Minute)
+	if err := os.Chtimes(objectPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Lookup("https://registry/ui-1.0.0.tgz"); ok {
+		t.Error("Lookup() served an object changed since it was stored")
+	}
+
+	// Restoring the content verifies the object again
+	if err := os.WriteFile(objectPath, []byte("tarball"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Lookup("https://registry/ui-1.0.0.tgz"); !ok {
+		t.Error("Lookup() missed an object with its original content")
+	}
+}
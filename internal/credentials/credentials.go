@@ -0,0 +1,159 @@
+package credentials
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// Credential is the login of a registry host, for one organization or for
+// every organization when Organization is empty
+type Credential struct {
+	Host         string
+	Organization string
+	Username     string
+	Token        string
+	// Read reads the token again when it is refreshed
+	Read func() (string, error)
+}
+
+// Authorization returns the Authorization header value of the credential,
+// basic authentication when it has a username
+func (c Credential) Authorization() string {
+	if c.Username == "" {
+		return c.Token
+	}
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(c.Username+":"+c.Token))
+}
+
+var (
+	mu         sync.RWMutex
+	configured []Credential
+	// defaults are the tokens and usernames of the source and target set
+	// without a credential, used for organizations without one
+	defaults = map[string][2]string{}
+)
+
+// Set configures the credentials of the run. The source and target tokens
+// set so far are kept for hosts and organizations without a credential.
+func Set(list []Credential) {
+	mu.Lock()
+	defer mu.Unlock()
+	configured = nil
+	for _, credential := range list {
+		credential.Host = Host(credential.Host)
+		configured = append(configured, credential)
+	}
+	for _, side := range []string{"SOURCE", "TARGET"} {
+		defaults[side] = [2]string{viper.GetString("GHMPKG_" + side + "_TOKEN"), viper.GetString("GHMPKG_" + side + "_USERNAME")}
+	}
+}
+
+// Tokens returns the tokens of the credentials
+func Tokens() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	var tokens []string
+	for _, credential := range configured {
+		tokens = append(tokens, credential.Token)
+	}
+	return tokens
+}
+
+// Host returns the lowercase host of a hostname setting or URL,
+// github.com when it is empty
+func Host(hostname string) string {
+	hostname = strings.TrimSpace(hostname)
+	if hostname == "" {
+		return "github.com"
+	}
+	if strings.Contains(hostname, "://") {
+		if parsed, err := url.Parse(hostname); err == nil && parsed.Host != "" {
+			hostname = parsed.Host
+		}
+	}
+	return strings.ToLower(strings.TrimSuffix(hostname, "/"))
+}
+
+// Lookup returns the credential of a host and organization. A credential
+// for the organization wins over one for every organization of the host.
+func Lookup(host, organization string) (Credential, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return lookup(configured, Host(host), organization)
+}
+
+func lookup(list []Credential, host, organization string) (Credential, bool) {
+	var found *Credential
+	for i, credential := range list {
+		if credential.Host != host {
+			continue
+		}
+		if organization != "" && strings.EqualFold(credential.Organization, organization) {
+			return credential, true
+		}
+		if credential.Organization == "" && found == nil {
+			found = &list[i]
+		}
+	}
+	if found == nil {
+		return Credential{}, false
+	}
+	return *found, true
+}
+
+// Use sets the token and username of the source or target to the
+// credential of its hostname and an organization, or back to the ones set
+// without a credential. Without credentials nothing is changed.
+func Use(side, organization string) {
+	mu.RLock()
+	none := len(configured) == 0
+	fallback := defaults[strings.ToUpper(side)]
+	mu.RUnlock()
+	if none {
+		return
+	}
+	prefix := "GHMPKG_" + strings.ToUpper(side) + "_"
+	token, username := fallback[0], fallback[1]
+	if credential, ok := Lookup(viper.GetString(prefix+"HOSTNAME"), organization); ok {
+		token, username = credential.Token, credential.Username
+	}
+	viper.Set(prefix+"TOKEN", token)
+	viper.Set(prefix+"USERNAME", username)
+}
+
+// ForURL returns the Authorization of a request to a host other than the
+// source and target hostnames, whose credentials depend on the organization
+// and are set with Use. The authorization passed in is kept for hosts
+// without a credential.
+func ForURL(rawURL, authorization string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return authorization
+	}
+	host := strings.ToLower(parsed.Host)
+	if host == Host(viper.GetString("GHMPKG_SOURCE_HOSTNAME")) || host == Host(viper.GetString("GHMPKG_TARGET_HOSTNAME")) {
+		return authorization
+	}
+	if credential, ok := Lookup(host, ""); ok {
+		return credential.Authorization()
+	}
+	return authorization
+}
+
+// Covers reports whether there are credentials for the hostname of the
+// source or target, whose token may then be left unset
+func Covers(side string) bool {
+	host := Host(viper.GetString("GHMPKG_" + strings.ToUpper(side) + "_HOSTNAME"))
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, credential := range configured {
+		if credential.Host == host {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package credentials
+
+import "testing"
+
+func TestLookup(t *testing.T) {
+	list := []Credential{
+		{Host: "github.com", Token: "ghp_default"},
+		{Host: "github.com", Organization: "Legacy", Token: "ghp_legacy"},
+		{Host: "artifactory.example.com", Username: "svc", Token: "secret"},
+	}
+	for _, test := range []struct {
+		host, organization, expected string
+	}{
+		{"github.com", "legacy", "ghp_legacy"},
+		{"github.com", "acme", "ghp_default"},
+		{"github.com", "", "ghp_default"},
+		{"artifactory.example.com", "acme", "secret"},
+		{"ghes.example.com", "acme", ""},
+	} {
+		credential, _ := lookup(list, test.host, test.organization)
+		if credential.Token != test.expected {
+			t.Errorf("lookup(%s, %s) = %q, expected %q", test.host, test.organization, credential.Token, test.expected)
+		}
+	}
+}
+
+func TestHost(t *testing.T) {
+	for hostname, expected := range map[string]string{
+		"":                           "github.com",
+		"https://GHES.example.com/":  "ghes.example.com",
+		"artifactory.example.com":    "artifactory.example.com",
+		"http://nexus.internal:8081": "nexus.internal:8081",
+	} {
+		if actual := Host(hostname); actual != expected {
+			t.Errorf("Host(%q) = %q, expected %q", hostname, actual, expected)
+		}
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	if actual := (Credential{Token: "ghp_x"}).Authorization(); actual != "ghp_x" {
+		t.Errorf("Authorization() = %q, expected the token", actual)
+	}
+	if actual := (Credential{Username: "svc", Token: "secret"}).Authorization(); actual != "Basic c3ZjOnNlY3JldA==" {
+		t.Errorf("Authorization() = %q, expected basic authentication", actual)
+	}
+}
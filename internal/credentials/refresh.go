@@ -0,0 +1,261 @@
+package credentials
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/actions"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// expiryMargin is how long before its expiry a token is read again
+const expiryMargin = 5 * time.Minute
+
+var (
+	refreshMu sync.Mutex
+	// readers read the source and target tokens again
+	readers = map[string]func() (string, error){}
+	// replaced maps refreshed tokens to the tokens that replaced them
+	replaced = map[string]string{}
+	// expiries are the expiry times GitHub reported for tokens
+	expiries = map[string]time.Time{}
+)
+
+// SetReader sets how the token of the source or target is read again once
+// it is rejected or about to expire, e.g. a command minting an installation
+// token
+func SetReader(side string, read func() (string, error)) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+	readers[strings.ToUpper(side)] = read
+}
+
+// Refresh reads a token again after it was rejected or when it is about to
+// expire, and sets the new token wherever the old one was set. A token
+// another request already refreshed is replaced right away. It returns
+// false when the token can't be read again or didn't change.
+func Refresh(stale string) (string, bool) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+	if fresh, ok := latest(stale); ok {
+		return fresh, true
+	}
+
+	var fresh string
+	for side, read := range readers {
+		key := "GHMPKG_" + side + "_TOKEN"
+		if read == nil || viper.GetString(key) != stale {
+			continue
+		}
+		token, err := read()
+		if err != nil {
+			zap.L().Warn("Failed to refresh token", zap.String("setting", key), zap.Error(err))
+			continue
+		}
+		if token = strings.TrimSpace(token); token == "" || token == stale {
+			continue
+		}
+		viper.Set(key, token)
+		fresh = token
+		zap.L().Info("Refreshed token", zap.String("setting", key))
+	}
+
+	mu.Lock()
+	for i, credential := range configured {
+		if credential.Token != stale || credential.Read == nil {
+			continue
+		}
+		token, err := credential.Read()
+		if err != nil {
+			zap.L().Warn("Failed to refresh token", zap.String("host", credential.Host), zap.String("organization", credential.Organization), zap.Error(err))
+			continue
+		}
+		if token = strings.TrimSpace(token); token != "" && token != stale {
+			configured[i].Token = token
+			fresh = token
+			zap.L().Info("Refreshed token", zap.String("host", credential.Host), zap.String("organization", credential.Organization))
+		}
+	}
+	if fresh != "" {
+		for side, fallback := range defaults {
+			if fallback[0] == stale {
+				defaults[side] = [2]string{fresh, fallback[1]}
+			}
+		}
+	}
+	mu.Unlock()
+
+	if fresh == "" {
+		return "", false
+	}
+	actions.Mask(fresh)
+	replaced[stale] = fresh
+	return fresh, true
+}
+
+// latest returns the token that last replaced a refreshed token
+func latest(token string) (string, bool) {
+	fresh, ok := replaced[token]
+	if !ok {
+		return "", false
+	}
+	for {
+		next, ok := replaced[fresh]
+		if !ok {
+			return fresh, true
+		}
+		fresh = next
+	}
+}
+
+// refreshable returns the tokens that can be read again
+func refreshable() []string {
+	var tokens []string
+	refreshMu.Lock()
+	for side, read := range readers {
+		if token := viper.GetString("GHMPKG_" + side + "_TOKEN"); read != nil && token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	refreshMu.Unlock()
+	mu.RLock()
+	for _, credential := range configured {
+		if credential.Read != nil && credential.Token != "" {
+			tokens = append(tokens, credential.Token)
+		}
+	}
+	mu.RUnlock()
+	return tokens
+}
+
+// parseExpiry parses the GitHub-Authentication-Token-Expiration header
+func parseExpiry(value string) (time.Time, bool) {
+	for _, layout := range []string{"2006-01-02 15:04:05 MST", "2006-01-02 15:04:05 -0700"} {
+		if expiry, err := time.Parse(layout, value); err == nil {
+			return expiry, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// recordExpiry keeps the expiry GitHub reports for a token
+func recordExpiry(token, header string) {
+	if header == "" {
+		return
+	}
+	if expiry, ok := parseExpiry(header); ok {
+		refreshMu.Lock()
+		expiries[token] = expiry
+		refreshMu.Unlock()
+	}
+}
+
+// expiring reports whether a token expires within the margin
+func expiring(token string, now time.Time) bool {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+	expiry, ok := expiries[token]
+	return ok && expiry.Sub(now) < expiryMargin
+}
+
+// requestToken returns the scheme and token of the Authorization header of
+// a request, the scheme of basic authentication includes the username
+func requestToken(req *http.Request) (string, string, bool) {
+	authorization := req.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || token == "" {
+		return "", "", false
+	}
+	if !strings.EqualFold(scheme, "Basic") {
+		return scheme, token, true
+	}
+	decoded, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return "", "", false
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok || password == "" {
+		return "", "", false
+	}
+	return "Basic " + username, password, true
+}
+
+// withToken returns a copy of a request sending another token with the same
+// scheme
+func withToken(req *http.Request, scheme, token string) *http.Request {
+	req = req.Clone(req.Context())
+	if username, ok := strings.CutPrefix(scheme, "Basic "); ok {
+		req.SetBasicAuth(username, token)
+	} else {
+		req.Header.Set("Authorization", scheme+" "+token)
+	}
+	return req
+}
+
+// refreshTransport refreshes the token of requests that are rejected with
+// 401 Unauthorized and sends them again, tokens about to expire are
+// refreshed before they are sent
+type refreshTransport struct {
+	base http.RoundTripper
+}
+
+// RefreshTransport wraps a transport so rejected and expiring tokens are
+// read again, see Refresh
+func RefreshTransport(base http.RoundTripper) http.RoundTripper {
+	return &refreshTransport{base: base}
+}
+
+func (t *refreshTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	scheme, token, ok := requestToken(req)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	if expiring(token, time.Now()) {
+		if fresh, refreshed := Refresh(token); refreshed {
+			req, token = withToken(req, scheme, fresh), fresh
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	recordExpiry(token, resp.Header.Get("GitHub-Authentication-Token-Expiration"))
+	// Requests whose body was sent can only be sent again when it can be
+	// read again
+	if resp.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		return resp, nil
+	}
+	fresh, refreshed := Refresh(token)
+	if !refreshed {
+		return resp, nil
+	}
+	retry := withToken(req, scheme, fresh)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	return t.base.RoundTrip(retry)
+}
+
+// StartRefresh reads the source and target tokens again every interval, for
+// tokens that expire without GitHub reporting when, like installation
+// tokens
+func StartRefresh(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			for _, token := range refreshable() {
+				Refresh(token)
+			}
+		}
+	}()
+}
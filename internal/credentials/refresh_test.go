@@ -0,0 +1,69 @@
+package credentials
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestRefreshTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer ghs_new" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	viper.Set("GHMPKG_SOURCE_TOKEN", "ghs_old")
+	defer viper.Set("GHMPKG_SOURCE_TOKEN", "")
+	reads := 0
+	SetReader("source", func() (string, error) {
+		reads++
+		return "ghs_new\n", nil
+	})
+	defer SetReader("source", nil)
+
+	client := &http.Client{Transport: RefreshTransport(http.DefaultTransport)}
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req.Header.Set("Authorization", "Bearer ghs_old")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("request %d returned %d, expected the refreshed token to be sent again", i, resp.StatusCode)
+		}
+	}
+	if token := viper.GetString("GHMPKG_SOURCE_TOKEN"); token != "ghs_new" {
+		t.Errorf("GHMPKG_SOURCE_TOKEN = %q, expected the refreshed token", token)
+	}
+	if reads != 1 {
+		t.Errorf("the token was read %d times, expected once", reads)
+	}
+}
+
+func TestRequestToken(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	req.SetBasicAuth("svc", "secret")
+	scheme, token, ok := requestToken(req)
+	if !ok || scheme != "Basic svc" || token != "secret" {
+		t.Fatalf("requestToken() = %q, %q, %v", scheme, token, ok)
+	}
+	if username, password, _ := withToken(req, scheme, "rotated").BasicAuth(); username != "svc" || password != "rotated" {
+		t.Errorf("withToken() sends %s:%s, expected svc:rotated", username, password)
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	expiry, ok := parseExpiry("2026-10-17 12:00:00 UTC")
+	if !ok || !expiry.Equal(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("parseExpiry() = %v, %v", expiry, ok)
+	}
+	if _, ok := parseExpiry("tomorrow"); ok {
+		t.Error("parseExpiry(tomorrow) succeeded")
+	}
+}
@@ -0,0 +1,127 @@
+// Package failures classifies the errors of failed files so results can be
+// triaged by cause instead of by message
+package failures
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v62/github"
+	"github.com/mark-humane/gh-migrate-packages/internal/signatures"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+)
+
+// Classes of failures
+const (
+	Auth        = "auth"
+	NotFound    = "not_found"
+	Conflict    = "conflict"
+	RateLimited = "rate_limited"
+	Timeout     = "timeout"
+	Network     = "network"
+	Validation  = "validation"
+	Server      = "server"
+	DiskSpace   = "disk_space"
+	Tool        = "tool"
+	Unknown     = "error"
+)
+
+// outputError is implemented by errors of external tools, the output of the
+// tool tells why it failed
+type outputError interface {
+	Output() string
+}
+
+// statusPattern finds the HTTP status in messages like "GET ... failed,
+// status: 404 Not Found" and in the npm, gem and dotnet output
+var statusPattern = regexp.MustCompile(`(?:status:? |\bE|HTTP/[0-9.]+ |\()([1-5][0-9]{2})\b`)
+
+// messages that identify a class when no status is given
+var messages = []struct {
+	class string
+	texts []string
+}{
+	{RateLimited, []string{"rate limit", "too many requests", "secondary rate"}},
+	{Conflict, []string{"already exists", "cannot publish over", "conflict"}},
+	{Auth, []string{"unauthorized", "forbidden", "permission denied", "bad credentials", "authentication"}},
+	{NotFound, []string{"not found", "no such host"}},
+	{Validation, []string{"mismatch", "invalid", "malformed"}},
+	{Timeout, []string{"timeout", "timed out", "deadline exceeded"}},
+	{Network, []string{"connection refused", "connection reset", "broken pipe", "unexpected eof"}},
+}
+
+// Classify returns the class of a failure
+func Classify(err error) string {
+	if err == nil {
+		return Unknown
+	}
+
+	var rateLimit *github.RateLimitError
+	var abuse *github.AbuseRateLimitError
+	var response *github.ErrorResponse
+	var netErr net.Error
+	switch {
+	case errors.Is(err, utils.ErrLowDiskSpace):
+		return DiskSpace
+	case errors.Is(err, signatures.ErrUnverified):
+		return Validation
+	case errors.As(err, &rateLimit), errors.As(err, &abuse):
+		return RateLimited
+	case errors.As(err, &response) && response.Response != nil:
+		return fromStatus(response.Response.StatusCode)
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return Timeout
+	}
+
+	message := err.Error()
+	var output outputError
+	if errors.As(err, &output) {
+		message = output.Output() + "\n" + message
+	}
+	// Exit statuses like "exit status 128" match too and have no class
+	for _, match := range statusPattern.FindAllStringSubmatch(message, -1) {
+		status, _ := strconv.Atoi(match[1])
+		if class := fromStatus(status); class != Unknown {
+			return class
+		}
+	}
+	lower := strings.ToLower(message)
+	for _, candidate := range messages {
+		for _, text := range candidate.texts {
+			if strings.Contains(lower, text) {
+				return candidate.class
+			}
+		}
+	}
+	if errors.As(err, &netErr) {
+		return Network
+	}
+	if output != nil {
+		return Tool
+	}
+	return Unknown
+}
+
+func fromStatus(status int) string {
+	switch {
+	case status == 401 || status == 403:
+		return Auth
+	case status == 404 || status == 410:
+		return NotFound
+	case status == 409:
+		return Conflict
+	case status == 429:
+		return RateLimited
+	case status == 400 || status == 413 || status == 422:
+		return Validation
+	case status == 408 || status == 504:
+		return Timeout
+	case status >= 500:
+		return Server
+	}
+	return Unknown
+}
@@ -0,0 +1,38 @@
+package failures
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v62/github"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+)
+
+type toolError struct{ output string }
+
+func (e toolError) Error() string  { return "npm-publish failed, see npm-publish.log: exit status 1" }
+func (e toolError) Output() string { return e.output }
+
+func TestClassify(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("failed to upload: %w", utils.ErrLowDiskSpace), DiskSpace},
+		{&github.ErrorResponse{Response: &http.Response{StatusCode: 401}}, Auth},
+		{&github.RateLimitError{}, RateLimited},
+		{errors.New("GET https://maven.pkg.github.com/org/repo/a.jar failed, status: 404 Not Found"), NotFound},
+		{toolError{"npm error code E409\nnpm error 409 Conflict - PUT https://npm.pkg.github.com/@org%2fa"}, Conflict},
+		{toolError{"error: Response status code does not indicate success: 403 (Forbidden)."}, Auth},
+		{toolError{"gem push failed with exit status 128"}, Tool},
+		{errors.New("checksum mismatch for a-1.0.0.tgz"), Validation},
+		{errors.New("dial tcp: i/o timeout"), Timeout},
+		{errors.New("something else"), Unknown},
+	} {
+		if got := Classify(test.err); got != test.want {
+			t.Errorf("Classify(%v) = %s, want %s", test.err, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/runlog"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Hook points
+const (
+	PreDownload = "pre-download"
+	PrePublish  = "pre-publish"
+	PostPublish = "post-publish"
+)
+
+// configKeys maps a hook point to the setting holding its command
+var configKeys = map[string]string{
+	PreDownload: "GHMPKG_HOOK_PRE_DOWNLOAD",
+	PrePublish:  "GHMPKG_HOOK_PRE_PUBLISH",
+	PostPublish: "GHMPKG_HOOK_POST_PUBLISH",
+}
+
+// Event describes the package version a hook runs for. It is written to the
+// hook command's stdin as JSON and passed to Go callbacks.
+type Event struct {
+	Hook               string   `json:"hook"`
+	SourceOrganization string   `json:"source_organization"`
+	TargetOrganization string   `json:"target_organization,omitempty"`
+	Repository         string   `json:"repository"`
+	PackageType        string   `json:"package_type"`
+	PackageName        string   `json:"package_name"`
+	PackageVersion     string   `json:"package_version"`
+	Files              []string `json:"files"`
+	// Dir holds the local files of the version, empty before download and
+	// for container images which live in the docker daemon
+	Dir string `json:"dir,omitempty"`
+	// Result is set for post-publish: success, skipped or failed
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Func is a hook implemented in Go, returning an error from a pre hook
+// fails the package version
+type Func func(Event) error
+
+var (
+	mu        sync.RWMutex
+	callbacks = map[string]Func{}
+)
+
+// SetCallbacks replaces the Go callbacks run in addition to the configured
+// hook commands
+func SetCallbacks(fns map[string]Func) {
+	mu.Lock()
+	defer mu.Unlock()
+	callbacks = map[string]Func{}
+	for hook, fn := range fns {
+		callbacks[hook] = fn
+	}
+}
+
+// Run runs the callback and command configured for a hook point. A failing
+// pre hook is returned as an error so the version is not transferred, a
+// failing post hook is only logged.
+func Run(logger *zap.Logger, event Event) error {
+	mu.RLock()
+	fn := callbacks[event.Hook]
+	mu.RUnlock()
+	command := viper.GetString(configKeys[event.Hook])
+	if fn == nil && command == "" {
+		return nil
+	}
+
+	err := run(logger, fn, command, event)
+	if err == nil {
+		return nil
+	}
+	if event.Hook == PostPublish {
+		logger.Warn("Post-publish hook failed", zap.Error(err))
+		return nil
+	}
+	return fmt.Errorf("%s hook rejected %s@%s: %w", event.Hook, event.PackageName, event.PackageVersion, err)
+}
+
+func run(logger *zap.Logger, fn Func, command string, event Event) error {
+	if fn != nil {
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	if command == "" {
+		return nil
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	cmd := shell(command)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(), environment(event)...)
+	return runlog.Run(logger, cmd, event.PackageType, event.PackageName, event.PackageVersion, "hook-"+event.Hook)
+}
+
+func shell(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+// environment exposes the event to hook commands that don't read stdin
+func environment(event Event) []string {
+	return []string{
+		"GHMPKG_HOOK=" + event.Hook,
+		"GHMPKG_HOOK_SOURCE_ORGANIZATION=" + event.SourceOrganization,
+		"GHMPKG_HOOK_TARGET_ORGANIZATION=" + event.TargetOrganization,
+		"GHMPKG_HOOK_REPOSITORY=" + event.Repository,
+		"GHMPKG_HOOK_PACKAGE_TYPE=" + event.PackageType,
+		"GHMPKG_HOOK_PACKAGE_NAME=" + event.PackageName,
+		"GHMPKG_HOOK_PACKAGE_VERSION=" + event.PackageVersion,
+		"GHMPKG_HOOK_FILES=" + strings.Join(event.Files, "\n"),
+		"GHMPKG_HOOK_DIR=" + event.Dir,
+		"GHMPKG_HOOK_RESULT=" + event.Result,
+		"GHMPKG_HOOK_ERROR=" + event.Error,
+	}
+}
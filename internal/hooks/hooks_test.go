@@ -0,0 +1,49 @@
+package hooks
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestRunCallbacks(t *testing.T) {
+	var seen []string
+	rejected := errors.New("license not allowed")
+	SetCallbacks(map[string]Func{
+		PrePublish: func(event Event) error {
+			seen = append(seen, event.Hook+" "+event.PackageName)
+			return rejected
+		},
+		PostPublish: func(event Event) error {
+			seen = append(seen, event.Hook+" "+event.Result)
+			return errors.New("scanner unavailable")
+		},
+	})
+	defer SetCallbacks(nil)
+
+	logger := zap.NewNop()
+	event := Event{PackageType: "npm", PackageName: "left-pad", PackageVersion: "1.0.0"}
+
+	event.Hook = PreDownload
+	if err := Run(logger, event); err != nil {
+		t.Errorf("hook without callback or command: %v", err)
+	}
+
+	event.Hook = PrePublish
+	if err := Run(logger, event); !errors.Is(err, rejected) {
+		t.Errorf("pre-publish error = %v, want %v", err, rejected)
+	}
+
+	// Post hooks can't undo a publish, their failures are only logged
+	event.Hook = PostPublish
+	event.Result = "success"
+	if err := Run(logger, event); err != nil {
+		t.Errorf("post-publish error = %v, want nil", err)
+	}
+
+	want := []string{"pre-publish left-pad", "post-publish success"}
+	if len(seen) != len(want) || seen[0] != want[0] || seen[1] != want[1] {
+		t.Errorf("callbacks ran as %v, want %v", seen, want)
+	}
+}
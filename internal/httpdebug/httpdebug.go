@@ -0,0 +1,123 @@
+package httpdebug
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const redacted = "REDACTED"
+
+// sensitiveHeaders never have their values logged
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+	"X-Nuget-Apikey":      true,
+	"X-Jfrog-Art-Api":     true,
+	"Private-Token":       true,
+	"Job-Token":           true,
+}
+
+// sensitiveParams are query parameters that carry credentials, e.g. in
+// pre-signed blob URLs
+var sensitiveParams = []string{"token", "access_token", "sig", "signature", "x-amz-signature", "x-amz-credential", "x-amz-security-token", "x-goog-signature", "x-goog-credential"}
+
+// requestIDHeaders are the headers registries use to identify a request,
+// quoting them makes support cases actionable
+var requestIDHeaders = []string{"X-Github-Request-Id", "X-Request-Id", "X-Amz-Request-Id", "X-Amz-Cf-Id", "X-Artifactory-Id", "X-Ms-Request-Id"}
+
+// Enabled reports whether --debug-http is set
+func Enabled() bool {
+	return viper.GetBool("GHMPKG_DEBUG_HTTP")
+}
+
+// Transport wraps an HTTP transport so the metadata of every request and
+// response is logged with credentials redacted
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{next: base}
+}
+
+type transport struct {
+	next http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	logger := zap.L().Named("http")
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	fields := []zap.Field{
+		zap.String("method", req.Method),
+		zap.String("url", RedactURL(req.URL)),
+		zap.Int64("durationMs", time.Since(start).Milliseconds()),
+		zap.Any("requestHeaders", RedactHeaders(req.Header)),
+	}
+	if req.ContentLength > 0 {
+		fields = append(fields, zap.Int64("requestBytes", req.ContentLength))
+	}
+	if err != nil {
+		logger.Info("HTTP request failed", append(fields, zap.Error(err))...)
+		return resp, err
+	}
+
+	fields = append(fields,
+		zap.Int("status", resp.StatusCode),
+		zap.Int64("responseBytes", resp.ContentLength),
+		zap.Any("responseHeaders", RedactHeaders(resp.Header)),
+	)
+	for _, name := range requestIDHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			fields = append(fields, zap.String("requestId", value))
+			break
+		}
+	}
+	logger.Info("HTTP request", fields...)
+	return resp, nil
+}
+
+// RedactHeaders copies headers with the values of credential headers
+// replaced
+func RedactHeaders(header http.Header) map[string]string {
+	out := make(map[string]string, len(header))
+	for name, values := range header {
+		if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			out[name] = redacted
+			continue
+		}
+		out[name] = strings.Join(values, ", ")
+	}
+	return out
+}
+
+// RedactURL removes user info and credential query parameters from a URL
+func RedactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	clean := *u
+	if clean.User != nil {
+		clean.User = url.User(redacted)
+	}
+	if clean.RawQuery != "" {
+		query := clean.Query()
+		for name := range query {
+			for _, sensitive := range sensitiveParams {
+				if strings.EqualFold(name, sensitive) {
+					query.Set(name, redacted)
+				}
+			}
+		}
+		clean.RawQuery = query.Encode()
+	}
+	return clean.String()
+}
@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/failures"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const namespace = "ghmpkg"
+
+var registry = prometheus.NewRegistry()
+
+var (
+	filesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "files_total",
+		Help:      "Files processed, by phase, package type and result",
+	}, []string{"phase", "package_type", "result"})
+
+	failuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "failures_total",
+		Help:      "Failed files, by phase, package type and reason",
+	}, []string{"phase", "package_type", "reason"})
+
+	inFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "inflight_workers",
+		Help:      "Transfers currently in progress, by direction",
+	}, []string{"direction"})
+
+	versionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "version_transfer_duration_seconds",
+		Help:      "Time taken to transfer all files of a package version",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 14),
+	}, []string{"phase", "package_type"})
+)
+
+func init() {
+	registry.MustRegister(
+		filesTotal,
+		failuresTotal,
+		inFlight,
+		versionDuration,
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "downloaded_bytes_total",
+			Help:      "Bytes downloaded by the tool",
+		}, func() float64 { return float64(utils.BytesTransferred(utils.Download)) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "uploaded_bytes_total",
+			Help:      "Bytes uploaded by the tool",
+		}, func() float64 { return float64(utils.BytesTransferred(utils.Upload)) }),
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+}
+
+// ObserveFile counts the result of a single file, failures are also counted
+// by reason
+func ObserveFile(phase, packageType, result string, err error) {
+	filesTotal.WithLabelValues(phase, packageType, result).Inc()
+	if result == "Failed" {
+		failuresTotal.WithLabelValues(phase, packageType, Reason(err)).Inc()
+	}
+}
+
+// ObserveVersion records how long a package version took
+func ObserveVersion(phase, packageType string, duration time.Duration) {
+	versionDuration.WithLabelValues(phase, packageType).Observe(duration.Seconds())
+}
+
+// TrackInFlight marks a transfer in the given direction ("download" or
+// "upload") as started, call the returned function when it is done
+func TrackInFlight(direction string) func() {
+	gauge := inFlight.WithLabelValues(direction)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+// Reason gives a coarse, low-cardinality reason for a failure
+func Reason(err error) string {
+	if err == nil {
+		return "unknown"
+	}
+	return failures.Classify(err)
+}
+
+// handler serves the metrics of the registry on /metrics
+func handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+// Serve exposes /metrics on GHMPKG_METRICS_ADDR when it is set. The returned
+// function shuts the listener down.
+func Serve(logger *zap.Logger) func() {
+	addr := viper.GetString("GHMPKG_METRICS_ADDR")
+	if addr == "" {
+		return func() {}
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Warn("Failed to start metrics listener", zap.String("addr", addr), zap.Error(err))
+		return func() {}
+	}
+
+	server := &http.Server{Handler: handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Warn("Metrics listener stopped", zap.Error(err))
+		}
+	}()
+	logger.Info("Serving metrics", zap.String("addr", listener.Addr().String()))
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}
+}
@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/failures"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// value returns the value of the counter, gauge or histogram sample count
+// of a metric with the given labels
+func value(t *testing.T, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != namespace+"_"+name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if labels[label.GetName()] != label.GetValue() {
+					continue metrics
+				}
+			}
+			switch {
+			case metric.GetCounter() != nil:
+				return metric.GetCounter().GetValue()
+			case metric.GetGauge() != nil:
+				return metric.GetGauge().GetValue()
+			case metric.GetHistogram() != nil:
+				return float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return 0
+}
+
+func TestObserveFile(t *testing.T) {
+	ObserveFile("sync", "npm", "Success", nil)
+	ObserveFile("sync", "npm", "Success", nil)
+	ObserveFile("sync", "npm", "Skipped", nil)
+	ObserveFile("sync", "npm", "Failed", fmt.Errorf("failed to upload: %w", utils.ErrLowDiskSpace))
+	ObserveFile("sync", "npm", "Failed", nil)
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"files_total", map[string]string{"phase": "sync", "package_type": "npm", "result": "Success"}, 2},
+		{"files_total", map[string]string{"phase": "sync", "package_type": "npm", "result": "Skipped"}, 1},
+		{"files_total", map[string]string{"phase": "sync", "package_type": "npm", "result": "Failed"}, 2},
+		{"failures_total", map[string]string{"phase": "sync", "package_type": "npm", "reason": failures.DiskSpace}, 1},
+		{"failures_total", map[string]string{"phase": "sync", "package_type": "npm", "reason": "unknown"}, 1},
+	}
+	for _, tt := range tests {
+		if got := value(t, tt.name, tt.labels); got != tt.want {
+			t.Errorf("%s%v = %v, want %v", tt.name, tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestObserveVersion(t *testing.T) {
+	ObserveVersion("pull", "maven", 3*time.Second)
+	ObserveVersion("pull", "maven", time.Minute)
+	if got := value(t, "version_transfer_duration_seconds", map[string]string{"phase": "pull", "package_type": "maven"}); got != 2 {
+		t.Errorf("version durations observed = %v, want 2", got)
+	}
+}
+
+func TestTrackInFlight(t *testing.T) {
+	labels := map[string]string{"direction": "upload"}
+	first := TrackInFlight("upload")
+	second := TrackInFlight("upload")
+	if got := value(t, "inflight_workers", labels); got != 2 {
+		t.Errorf("in flight = %v, want 2", got)
+	}
+	first()
+	second()
+	if got := value(t, "inflight_workers", labels); got != 0 {
+		t.Errorf("in flight after both finished = %v, want 0", got)
+	}
+}
+
+func TestReason(t *testing.T) {
+	if reason := Reason(nil); reason != "unknown" {
+		t.Errorf("Reason(nil) = %q, want unknown", reason)
+	}
+	if reason := Reason(errors.New("checksum mismatch for a-1.0.0.tgz")); reason != failures.Validation {
+		t.Errorf("Reason() = %q, want %q", reason, failures.Validation)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	ObserveFile("migrate", "rubygems", "Success", nil)
+	ObserveFile("migrate", "rubygems", "Failed", errors.New("checksum mismatch for a-1.0.0.gem"))
+
+	recorder := httptest.NewRecorder()
+	handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d", recorder.Code)
+	}
+	body, _ := io.ReadAll(recorder.Body)
+	for _, want := range []string{
+		`ghmpkg_files_total{package_type="rubygems",phase="migrate",result="Success"} 1`,
+		`ghmpkg_files_total{package_type="rubygems",phase="migrate",result="Failed"} 1`,
+		`ghmpkg_failures_total{package_type="rubygems",phase="migrate",reason="validation"} 1`,
+		"# TYPE ghmpkg_downloaded_bytes_total counter",
+		"# TYPE ghmpkg_uploaded_bytes_total counter",
+		"# TYPE ghmpkg_version_transfer_duration_seconds histogram",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics are missing %s", want)
+		}
+	}
+}
+
+func TestServeDisabled(t *testing.T) {
+	viper.Set("GHMPKG_METRICS_ADDR", "")
+	// Without an address nothing listens, stopping is a no-op
+	Serve(zap.NewNop())()
+}
@@ -0,0 +1,278 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Event types
+const (
+	Started          = "started"
+	Completed        = "completed"
+	FailureThreshold = "failure_threshold"
+)
+
+// Webhook formats
+const (
+	FormatSlack   = "slack"
+	FormatTeams   = "teams"
+	FormatGeneric = "generic"
+)
+
+const sendTimeout = 10 * time.Second
+
+// minVersionsForPercent avoids a percentage threshold tripping on the very
+// first failed version
+const minVersionsForPercent = 10
+
+// Counts holds the outcomes at one level
+type Counts struct {
+	Success int `json:"success"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+}
+
+// Event is what a notification is about, it is also the data passed to the
+// message template
+type Event struct {
+	Type               string `json:"type"`
+	RunID              string `json:"run_id"`
+	Phase              string `json:"phase"`
+	Organization       string `json:"organization"`
+	TargetOrganization string `json:"target_organization,omitempty"`
+	Hostname           string `json:"hostname"`
+	ExitStatus         string `json:"exit_status,omitempty"`
+	Error              string `json:"error,omitempty"`
+	Duration           string `json:"duration,omitempty"`
+	Packages           Counts `json:"packages"`
+	Versions           Counts `json:"versions"`
+	Files              Counts `json:"files"`
+	Threshold          string `json:"threshold,omitempty"`
+}
+
+const defaultTemplate = `{{ if eq .Type "started" -}}
+:rocket: {{ .Phase }} started for {{ .Organization }}{{ if .TargetOrganization }} → {{ .TargetOrganization }}{{ end }} on {{ .Hostname }} (run {{ .RunID }})
+{{- else if eq .Type "failure_threshold" -}}
+:warning: {{ .Phase }} for {{ .Organization }} crossed the failure threshold ({{ .Threshold }}): {{ .Versions.Failed }} versions failed, {{ .Versions.Success }} succeeded so far
+{{- else -}}
+{{ if eq .ExitStatus "success" }}:white_check_mark:{{ else if eq .ExitStatus "partial" }}:warning:{{ else }}:x:{{ end }} {{ .Phase }} {{ .ExitStatus }} for {{ .Organization }}{{ if .TargetOrganization }} → {{ .TargetOrganization }}{{ end }} in {{ .Duration }}
+Packages: {{ .Packages.Success }} succeeded, {{ .Packages.Skipped }} skipped, {{ .Packages.Failed }} failed
+Versions: {{ .Versions.Success }} succeeded, {{ .Versions.Skipped }} skipped, {{ .Versions.Failed }} failed
+Files: {{ .Files.Success }} succeeded, {{ .Files.Skipped }} skipped, {{ .Files.Failed }} failed
+Run: {{ .RunID }}
+{{- if .Error }}
+Error: {{ .Error }}
+{{- end }}
+{{- end }}`
+
+// Enabled reports whether any webhook is configured
+func Enabled() bool {
+	return len(webhookURLs()) > 0
+}
+
+// Send posts an event to every configured webhook. Delivery problems are
+// logged, a notification never fails the run.
+func Send(logger *zap.Logger, event Event) {
+	urls := webhookURLs()
+	if len(urls) == 0 {
+		return
+	}
+	if event.Hostname == "" {
+		event.Hostname = hostname()
+	}
+	if event.RunID == "" {
+		event.RunID = utils.RunID()
+	}
+
+	text, err := render(event)
+	if err != nil {
+		logger.Warn("Failed to render webhook message", zap.Error(err))
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, webhook := range urls {
+		wg.Add(1)
+		go func(webhook string) {
+			defer wg.Done()
+			if err := post(webhook, event, text); err != nil {
+				logger.Warn("Failed to send webhook notification",
+					zap.String("event", event.Type),
+					zap.String("host", redact(webhook)),
+					zap.Error(err))
+				return
+			}
+			logger.Info("Sent webhook notification", zap.String("event", event.Type), zap.String("host", redact(webhook)))
+		}(webhook)
+	}
+	wg.Wait()
+}
+
+// Threshold tracks the failure threshold of a run so it is only reported once
+type Threshold struct {
+	limit   int
+	percent float64
+	tripped bool
+}
+
+// NewThreshold reads GHMPKG_WEBHOOK_FAILURE_THRESHOLD, either a number of
+// failed versions ("25") or a share of processed versions ("10%"). It returns
+// nil when no threshold or no webhook is configured.
+func NewThreshold() (*Threshold, error) {
+	value := strings.TrimSpace(viper.GetString("GHMPKG_WEBHOOK_FAILURE_THRESHOLD"))
+	if value == "" || !Enabled() {
+		return nil, nil
+	}
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return nil, fmt.Errorf("invalid failure threshold %q", value)
+		}
+		return &Threshold{percent: percent}, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return nil, fmt.Errorf("invalid failure threshold %q", value)
+	}
+	return &Threshold{limit: limit}, nil
+}
+
+// Breached reports whether the threshold was crossed for the first time
+func (t *Threshold) Breached(failed, processed int) bool {
+	if t == nil || t.tripped || failed == 0 {
+		return false
+	}
+	if t.limit > 0 {
+		t.tripped = failed >= t.limit
+	} else if processed >= minVersionsForPercent {
+		t.tripped = float64(failed)*100/float64(processed) >= t.percent
+	}
+	return t.tripped
+}
+
+// String returns the threshold as configured
+func (t *Threshold) String() string {
+	if t.limit > 0 {
+		return fmt.Sprintf("%d failed versions", t.limit)
+	}
+	return fmt.Sprintf("%g%% of versions failed", t.percent)
+}
+
+// render builds the message text, GHMPKG_WEBHOOK_TEMPLATE may point to a
+// file with a custom text/template
+func render(event Event) (string, error) {
+	text := defaultTemplate
+	if path := viper.GetString("GHMPKG_WEBHOOK_TEMPLATE"); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		text = string(content)
+	}
+	tmpl, err := template.New("webhook").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func post(webhook string, event Event, text string) error {
+	var payload interface{}
+	switch format(webhook) {
+	case FormatSlack:
+		payload = map[string]string{"text": text}
+	case FormatTeams:
+		payload = map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  fmt.Sprintf("%s %s", event.Phase, event.Type),
+			"text":     strings.ReplaceAll(text, "\n", "<br>"),
+		}
+	default:
+		payload = struct {
+			Event
+			Text string `json:"text"`
+		}{event, text}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := utils.HTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// format picks the payload format, GHMPKG_WEBHOOK_FORMAT wins over detection
+// from the webhook host
+func format(webhook string) string {
+	if configured := strings.ToLower(viper.GetString("GHMPKG_WEBHOOK_FORMAT")); configured != "" {
+		return configured
+	}
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return FormatGeneric
+	}
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case host == "hooks.slack.com":
+		return FormatSlack
+	case strings.HasSuffix(host, ".webhook.office.com"), strings.HasSuffix(host, ".logic.azure.com"):
+		return FormatTeams
+	}
+	return FormatGeneric
+}
+
+func webhookURLs() []string {
+	return strings.FieldsFunc(viper.GetString("GHMPKG_WEBHOOK_URL"), func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// redact keeps webhook secrets, which live in the path, out of the logs
+func redact(webhook string) string {
+	if u, err := url.Parse(webhook); err == nil {
+		return u.Host
+	}
+	return "invalid url"
+}
+
+func hostname() string {
+	if host, err := os.Hostname(); err == nil {
+		return host
+	}
+	return "unknown host"
+}
@@ -0,0 +1,64 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+func TestThreshold(t *testing.T) {
+	absolute := &Threshold{limit: 3}
+	if absolute.Breached(2, 2) {
+		t.Error("absolute threshold tripped early")
+	}
+	if !absolute.Breached(3, 3) {
+		t.Error("absolute threshold not tripped")
+	}
+	if absolute.Breached(4, 4) {
+		t.Error("threshold should only trip once")
+	}
+
+	percent := &Threshold{percent: 10}
+	if percent.Breached(1, 1) {
+		t.Error("percent threshold tripped before enough versions were processed")
+	}
+	if !percent.Breached(2, 20) {
+		t.Error("percent threshold not tripped")
+	}
+
+	var none *Threshold
+	if none.Breached(100, 100) {
+		t.Error("nil threshold tripped")
+	}
+}
+
+func TestSendGeneric(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	viper.Set("GHMPKG_WEBHOOK_URL", server.URL)
+	defer viper.Set("GHMPKG_WEBHOOK_URL", "")
+
+	Send(zap.NewNop(), Event{
+		Type:         Completed,
+		Phase:        "sync",
+		Organization: "mona",
+		ExitStatus:   "partial",
+		Versions:     Counts{Success: 9, Failed: 1},
+	})
+
+	if received["type"] != Completed {
+		t.Fatalf("unexpected payload: %v", received)
+	}
+	if text, _ := received["text"].(string); !strings.Contains(text, "Versions: 9 succeeded, 0 skipped, 1 failed") {
+		t.Errorf("unexpected text: %q", text)
+	}
+}
@@ -0,0 +1,140 @@
+package oci
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"go.uber.org/zap"
+)
+
+// chunkAttempts is how often a chunk is sent before the upload fails, a
+// chunk that moves the upload forward starts the count again
+const chunkAttempts = 5
+
+// chunkRetryDelay is waited before an interrupted upload is resumed
+var chunkRetryDelay = 2 * time.Second
+
+// errChunksRejected is returned when the registry doesn't take chunks of
+// blobs, which are then uploaded in one request
+var errChunksRejected = errors.New("registry does not accept chunked uploads")
+
+// pushChunks sends a blob to an upload session in chunks of the chunk size
+// of the client and returns the location the session is completed at. An
+// interrupted chunk is resumed from the offset the registry reports for
+// the session, so a dropped connection only sends one chunk again rather
+// than the whole blob.
+func (r *Repository) pushChunks(logger *zap.Logger, descriptor Descriptor, location *url.URL, content func() (io.ReadSeeker, error)) (*url.URL, error) {
+	var offset int64
+	failed := 0
+	for offset < descriptor.Size {
+		end := min(offset+r.client.ChunkSize, descriptor.Size)
+		next, received, err := r.patchChunk(location, content, offset, end)
+		if err == nil && received > offset {
+			location, offset, failed = next, received, 0
+			continue
+		}
+		if err == nil {
+			err = fmt.Errorf("registry received no bytes of chunk %d-%d", offset, end-1)
+		}
+		if errors.Is(err, errChunksRejected) && offset == 0 {
+			return nil, err
+		}
+		failed++
+		if failed >= chunkAttempts {
+			return nil, fmt.Errorf("upload of %s stopped at %s of %s: %w", descriptor.Digest, utils.FormatBytes(offset), utils.FormatBytes(descriptor.Size), err)
+		}
+		time.Sleep(chunkRetryDelay)
+		// The registry may have kept part of the interrupted chunk
+		fields := []zap.Field{zap.String("digest", descriptor.Digest), zap.Int64("offset", offset), zap.Error(err)}
+		if next, received, statusErr := r.uploadStatus(location); statusErr != nil {
+			logger.Warn("Failed to get the status of the blob upload, sending the chunk again", append(fields, zap.NamedError("statusError", statusErr))...)
+		} else {
+			location, offset = next, received
+			logger.Info("Resuming blob upload", append(fields, zap.Int64("received", received))...)
+		}
+	}
+	return location, nil
+}
+
+// patchChunk sends the bytes of a blob from offset up to end to an upload
+// session. It returns the location of the session and how many bytes the
+// registry has received.
+func (r *Repository) patchChunk(location *url.URL, content func() (io.ReadSeeker, error), offset, end int64) (*url.URL, int64, error) {
+	resp, err := r.client.do(r.pushScope(), func() (*http.Request, error) {
+		reader, err := content()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest(http.MethodPatch, location.String(), utils.MeterReader(io.LimitReader(reader, end-offset), utils.Upload))
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = end - offset
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, end-1))
+		return req, nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		return uploadState(resp, location, end)
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return nil, 0, fmt.Errorf("%w, status: %s", errChunksRejected, resp.Status)
+	}
+	return nil, 0, fmt.Errorf("upload of chunk %d-%d failed, status: %s", offset, end-1, resp.Status)
+}
+
+// uploadStatus asks the registry how much of a blob an upload session has
+// received
+func (r *Repository) uploadStatus(location *url.URL) (*url.URL, int64, error) {
+	resp, err := r.client.do(r.pushScope(), func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, location.String(), nil)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("upload status request failed, status: %s", resp.Status)
+	}
+	return uploadState(resp, location, 0)
+}
+
+// uploadState reads the location and the received bytes of an upload
+// session from a response, the Range header holds the last byte received
+// and 0-0 an empty session. received is returned when the registry leaves
+// the header out.
+func uploadState(resp *http.Response, location *url.URL, received int64) (*url.URL, int64, error) {
+	if header := resp.Header.Get("Location"); header != "" {
+		next, err := resp.Request.URL.Parse(header)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid upload location: %w", err)
+		}
+		location = next
+	}
+	if header := resp.Header.Get("Range"); header != "" {
+		_, last, found := strings.Cut(strings.TrimPrefix(header, "bytes="), "-")
+		end, err := strconv.ParseInt(last, 10, 64)
+		if !found || err != nil {
+			return nil, 0, fmt.Errorf("invalid upload range %q", header)
+		}
+		received = end + 1
+		if header == "0-0" || header == "bytes=0-0" {
+			received = 0
+		}
+	}
+	return location, received, nil
+}
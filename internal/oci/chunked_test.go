@@ -0,0 +1,134 @@
+package oci
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// chunkRegistry keeps blob upload sessions. It drops the connection in the
+// middle of the chunk starting at dropAt, once, keeping the bytes it read,
+// and rejects PATCH requests unless chunked is set.
+type chunkRegistry struct {
+	mu       sync.Mutex
+	chunked  bool
+	dropAt   int64
+	dropped  bool
+	session  bytes.Buffer
+	received int64
+	blob     []byte
+	patches  int
+}
+
+func (reg *chunkRegistry) serve(w http.ResponseWriter, r *http.Request) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	switch {
+	case r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodPost:
+		reg.session.Reset()
+		w.Header().Set("Location", "/v2/octo/api/blobs/uploads/session")
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPatch && !reg.chunked:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	case r.Method == http.MethodPatch:
+		reg.patches++
+		if r.Header.Get("Content-Range") != fmt.Sprintf("%d-%d", reg.session.Len(), int64(reg.session.Len())+r.ContentLength-1) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if !reg.dropped && int64(reg.session.Len()) == reg.dropAt {
+			reg.dropped = true
+			n, _ := io.CopyN(&reg.session, r.Body, r.ContentLength/2)
+			reg.received += n
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		n, _ := io.Copy(&reg.session, r.Body)
+		reg.received += n
+		w.Header().Set("Location", fmt.Sprintf("/v2/octo/api/blobs/uploads/session?part=%d", reg.patches))
+		w.Header().Set("Range", fmt.Sprintf("0-%d", reg.session.Len()-1))
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodGet:
+		w.Header().Set("Range", fmt.Sprintf("0-%d", reg.session.Len()-1))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		n, _ := io.Copy(&reg.session, r.Body)
+		reg.received += n
+		if Digest(reg.session.Bytes()) != r.URL.Query().Get("digest") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reg.blob = bytes.Clone(reg.session.Bytes())
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func TestPushBlobResumesChunks(t *testing.T) {
+	chunkRetryDelay = 0
+	const size, chunk = 8 << 20, 1 << 20
+	content := bytes.Repeat([]byte("layer"), size/5+1)[:size]
+	descriptor := Descriptor{Digest: Digest(content), Size: size}
+	open := func() (io.ReadSeeker, error) { return bytes.NewReader(content), nil }
+
+	for _, test := range []struct {
+		name     string
+		registry *chunkRegistry
+	}{
+		{"resumed", &chunkRegistry{chunked: true, dropAt: 7 * chunk}},
+		{"monolithic", &chunkRegistry{chunked: false, dropAt: -1}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(test.registry.serve))
+			defer server.Close()
+			client := NewClientWithAuthorization(server.URL, "Bearer token")
+			client.ChunkSize = chunk
+
+			if err := client.Repository("octo/api").PushBlob(zap.NewNop(), descriptor, open); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(test.registry.blob, content) {
+				t.Errorf("registry stored %d bytes, want the %d bytes of the blob", len(test.registry.blob), size)
+			}
+			// The bytes received before the connection dropped are not sent again
+			if test.registry.received != size {
+				t.Errorf("registry received %d bytes, want %d", test.registry.received, size)
+			}
+			if test.registry.chunked && !test.registry.dropped {
+				t.Error("the connection was never dropped")
+			}
+		})
+	}
+}
+
+func TestUploadState(t *testing.T) {
+	location, _ := http.NewRequest(http.MethodGet, "https://ghcr.io/v2/octo/api/blobs/uploads/1", nil)
+	for _, test := range []struct {
+		header   string
+		received int64
+	}{
+		{"0-1048575", 1 << 20},
+		{"bytes=0-99", 100},
+		{"0-0", 0},
+		{"", 42},
+	} {
+		resp := &http.Response{Header: http.Header{}, Request: location}
+		if test.header != "" {
+			resp.Header.Set("Range", test.header)
+		}
+		resp.Header.Set("Location", "/v2/octo/api/blobs/uploads/2")
+		next, received, err := uploadState(resp, location.URL, 42)
+		if err != nil || received != test.received || !strings.HasSuffix(next.String(), "/uploads/2") {
+			t.Errorf("Range %q: received = %d, location = %v, err = %v, want %d", test.header, received, next, err, test.received)
+		}
+	}
+}
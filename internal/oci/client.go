@@ -0,0 +1,575 @@
+// Package oci copies container images between registries with the OCI
+// distribution API. Manifests are kept byte for byte, so image digests,
+// multi-platform indexes and signatures stay valid on the target.
+package oci
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"go.uber.org/zap"
+)
+
+// Manifest media types, Docker's and their OCI equivalents
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeManifest           = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeIndex              = "application/vnd.oci.image.index.v1+json"
+)
+
+// maxManifestSize bounds the manifests read into memory, the size
+// registries are required to accept. Layers are always streamed.
+const maxManifestSize = 4 << 20
+
+var manifestTypes = []string{MediaTypeIndex, MediaTypeDockerManifestList, MediaTypeManifest, MediaTypeDockerManifest}
+
+// Descriptor points at a blob or manifest by digest
+type Descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	URLs         []string          `json:"urls,omitempty"`
+	Platform     *Platform         `json:"platform,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// Platform of an image in an index
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (p *Platform) String() string {
+	if p == nil {
+		return ""
+	}
+	if p.Variant != "" {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Architecture
+}
+
+// manifest holds the fields of image manifests and indexes needed to walk
+// them, the original bytes are what gets copied
+type manifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    *Descriptor  `json:"config"`
+	Layers    []Descriptor `json:"layers"`
+	Manifests []Descriptor `json:"manifests"`
+	Subject   *Descriptor  `json:"subject"`
+}
+
+// IsIndex reports whether a media type is a manifest list or OCI index
+func IsIndex(mediaType string) bool {
+	return mediaType == MediaTypeIndex || mediaType == MediaTypeDockerManifestList
+}
+
+// Digest returns the sha256 digest of content
+func Digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Client talks to a registry, answering Bearer token challenges with the
+// configured credentials. It is safe for concurrent use.
+type Client struct {
+	baseURL       string
+	username      string
+	password      string
+	authorization string
+
+	mu     sync.Mutex
+	tokens map[string]string
+	// blobs records a repository holding each blob pushed, so other
+	// repositories can mount it instead of uploading it again
+	blobs map[string]string
+
+	// ChunkSize uploads blobs larger than it in chunks of this size that
+	// are resumed when interrupted, zero uploads them in one request
+	ChunkSize int64
+}
+
+// NewClient creates a client for a registry, e.g. https://ghcr.io, that
+// logs in with a username and token
+func NewClient(registryURL, username, password string) *Client {
+	return &Client{
+		baseURL:  registryBaseURL(registryURL),
+		username: username,
+		password: password,
+		tokens:   make(map[string]string),
+		blobs:    make(map[string]string),
+	}
+}
+
+// NewClientWithAuthorization creates a client that sends a fixed
+// Authorization header, e.g. a cloud provider access token
+func NewClientWithAuthorization(registryURL, authorization string) *Client {
+	return &Client{
+		baseURL:       registryBaseURL(registryURL),
+		authorization: authorization,
+		tokens:        make(map[string]string),
+		blobs:         make(map[string]string),
+	}
+}
+
+func registryBaseURL(registryURL string) string {
+	if !strings.Contains(registryURL, "://") {
+		registryURL = "https://" + registryURL
+	}
+	if parsed, err := url.Parse(registryURL); err == nil {
+		return parsed.Scheme + "://" + parsed.Host
+	}
+	return strings.TrimSuffix(registryURL, "/")
+}
+
+// Host returns the registry host, as used in image references
+func (c *Client) Host() string {
+	return strings.SplitN(c.baseURL, "://", 2)[1]
+}
+
+// Repository returns a repository of the registry, e.g. acme/api
+func (c *Client) Repository(name string) *Repository {
+	return &Repository{client: c, name: strings.ToLower(name)}
+}
+
+// do sends a request, requesting a token for the scope and retrying once
+// when the registry asks for one. newRequest is called again for the
+// retry so bodies can be sent twice.
+func (c *Client) do(scope string, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		c.authorize(req, scope)
+		resp, err := utils.HTTPClient().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 || c.authorization != "" {
+			return resp, nil
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := c.login(challenge, scope); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) authorize(req *http.Request, scope string) {
+	if c.authorization != "" {
+		req.Header.Set("Authorization", c.authorization)
+		return
+	}
+	c.mu.Lock()
+	token, ok := c.tokens[scope]
+	c.mu.Unlock()
+	if ok && token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if ok {
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
+
+// login answers a WWW-Authenticate challenge. Registries asking for Basic
+// credentials get them directly, Bearer challenges are exchanged for a
+// token at the realm.
+func (c *Client) login(challenge, scope string) error {
+	scheme, params := parseChallenge(challenge)
+	if strings.EqualFold(scheme, "basic") {
+		c.mu.Lock()
+		c.tokens[scope] = ""
+		c.mu.Unlock()
+		return nil
+	}
+	if !strings.EqualFold(scheme, "bearer") || params["realm"] == "" {
+		return fmt.Errorf("unsupported registry authentication challenge %q", challenge)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil {
+		return err
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	// Cross-repository mounts need a token for both repositories
+	for _, field := range strings.Fields(scope) {
+		query.Add("scope", field)
+	}
+	realm.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := utils.HTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry token request for %s failed, status: %s", scope, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("failed to decode registry token: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	c.mu.Lock()
+	c.tokens[scope] = token.Token
+	c.mu.Unlock()
+	return nil
+}
+
+// parseChallenge splits a WWW-Authenticate header like
+// Bearer realm="https://ghcr.io/token",service="ghcr.io"
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := make(map[string]string)
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return scheme, params
+}
+
+// Repository is an image repository of a registry
+type Repository struct {
+	client *Client
+	name   string
+}
+
+// Reference returns the image reference of a tag or digest
+func (r *Repository) Reference(reference string) string {
+	if strings.HasPrefix(reference, "sha256:") {
+		return r.client.Host() + "/" + r.name + "@" + reference
+	}
+	return r.client.Host() + "/" + r.name + ":" + reference
+}
+
+func (r *Repository) url(kind, reference string) string {
+	return fmt.Sprintf("%s/v2/%s/%s/%s", r.client.baseURL, r.name, kind, reference)
+}
+
+func (r *Repository) pullScope() string {
+	return "repository:" + r.name + ":pull"
+}
+
+func (r *Repository) pushScope() string {
+	return "repository:" + r.name + ":pull,push"
+}
+
+// Manifest fetches a manifest or index by tag or digest and verifies its
+// digest
+func (r *Repository) Manifest(reference string) (Descriptor, []byte, error) {
+	resp, err := r.client.do(r.pullScope(), func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, r.url("manifests", reference), nil)
+		if err == nil {
+			req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+		}
+		return req, err
+	})
+	if err != nil {
+		return Descriptor{}, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return Descriptor{}, nil, fmt.Errorf("GET manifest %s failed, status: %s: %w", r.Reference(reference), resp.Status, utils.ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Descriptor{}, nil, fmt.Errorf("GET manifest %s failed, status: %s", r.Reference(reference), resp.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return Descriptor{}, nil, err
+	}
+	if len(content) > maxManifestSize {
+		return Descriptor{}, nil, fmt.Errorf("manifest %s is larger than %s", r.Reference(reference), utils.FormatBytes(maxManifestSize))
+	}
+
+	descriptor := Descriptor{MediaType: resp.Header.Get("Content-Type"), Digest: Digest(content), Size: int64(len(content))}
+	if expected := resp.Header.Get("Docker-Content-Digest"); expected != "" && expected != descriptor.Digest {
+		return Descriptor{}, nil, fmt.Errorf("manifest %s has digest %s, the registry reported %s", r.Reference(reference), descriptor.Digest, expected)
+	}
+	if strings.HasPrefix(reference, "sha256:") && reference != descriptor.Digest {
+		return Descriptor{}, nil, fmt.Errorf("manifest %s has digest %s", r.Reference(reference), descriptor.Digest)
+	}
+	var parsed manifest
+	if err := json.Unmarshal(content, &parsed); err != nil {
+		return Descriptor{}, nil, fmt.Errorf("failed to decode manifest %s: %w", r.Reference(reference), err)
+	}
+	if parsed.MediaType != "" {
+		descriptor.MediaType = parsed.MediaType
+	}
+	return descriptor, content, nil
+}
+
+// ManifestDigest returns the digest of a tag, found is false when the
+// repository has no such tag
+func (r *Repository) ManifestDigest(reference string) (string, bool, error) {
+	resp, err := r.client.do(r.pullScope(), func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodHead, r.url("manifests", reference), nil)
+		if err == nil {
+			req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+		}
+		return req, err
+	})
+	if err != nil {
+		return "", false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("HEAD manifest %s failed, status: %s", r.Reference(reference), resp.Status)
+	}
+	return resp.Header.Get("Docker-Content-Digest"), true, nil
+}
+
+// Referrers returns the artifacts referring to a manifest, e.g. signatures
+// and SBOMs. Registries without the referrers API are read through the
+// referrers tag schema, supported is false for them.
+func (r *Repository) Referrers(digest string) ([]Descriptor, bool, error) {
+	resp, err := r.client.do(r.pullScope(), func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, r.url("referrers", digest), nil)
+		if err == nil {
+			req.Header.Set("Accept", MediaTypeIndex)
+		}
+		return req, err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		var index manifest
+		if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+			return nil, true, fmt.Errorf("failed to decode referrers of %s: %w", r.Reference(digest), err)
+		}
+		return index.Manifests, true, nil
+	}
+	if resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusBadRequest {
+		return nil, false, fmt.Errorf("GET referrers of %s failed, status: %s", r.Reference(digest), resp.Status)
+	}
+
+	_, found, err := r.ManifestDigest(ReferrersTag(digest))
+	if err != nil || !found {
+		return nil, false, err
+	}
+	_, content, err := r.Manifest(ReferrersTag(digest))
+	if err != nil {
+		return nil, false, err
+	}
+	var index manifest
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil, false, err
+	}
+	return index.Manifests, false, nil
+}
+
+// ReferrersTag returns the tag of the referrers tag schema, sha256-<hex>
+func ReferrersTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1)
+}
+
+// Blob opens a blob for reading
+func (r *Repository) Blob(digest string) (io.ReadCloser, error) {
+	resp, err := r.client.do(r.pullScope(), func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, r.url("blobs", digest), nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET blob %s failed, status: %s", r.Reference(digest), resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// BlobExists reports whether the repository has a blob
+func (r *Repository) BlobExists(digest string) (bool, error) {
+	resp, err := r.client.do(r.pushScope(), func() (*http.Request, error) {
+		return http.NewRequest(http.MethodHead, r.url("blobs", digest), nil)
+	})
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// PushBlob uploads a blob unless the repository already has it. Blobs
+// pushed to another repository of the registry before are mounted from
+// there instead. Blobs larger than the chunk size of the client are
+// uploaded in chunks, others with a monolithic upload. content is opened
+// again when the upload is retried or resumed.
+func (r *Repository) PushBlob(logger *zap.Logger, descriptor Descriptor, content func() (io.ReadSeeker, error)) error {
+	if found, err := r.BlobExists(descriptor.Digest); err != nil {
+		return err
+	} else if found {
+		r.client.recordBlob(descriptor.Digest, r.name)
+		return nil
+	}
+
+	location, mounted, err := r.startUpload(descriptor.Digest)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		r.client.recordBlob(descriptor.Digest, r.name)
+		return nil
+	}
+
+	if r.client.ChunkSize > 0 && descriptor.Size > r.client.ChunkSize {
+		next, err := r.pushChunks(logger, descriptor, location, content)
+		if err == nil {
+			return r.finishUpload(next, descriptor, 0, nil)
+		}
+		if !errors.Is(err, errChunksRejected) {
+			return err
+		}
+		// The rejected session is abandoned for a new one
+		logger.Info("Registry rejected the chunked upload, uploading the blob in one request", zap.String("digest", descriptor.Digest), zap.Error(err))
+		if location, _, err = r.startUpload(descriptor.Digest); err != nil {
+			return err
+		}
+	}
+	return r.finishUpload(location, descriptor, descriptor.Size, content)
+}
+
+// finishUpload completes an upload session with the digest of the blob,
+// sending the last size bytes of the blob with it
+func (r *Repository) finishUpload(location *url.URL, descriptor Descriptor, size int64, content func() (io.ReadSeeker, error)) error {
+	target := *location
+	query := target.Query()
+	query.Set("digest", descriptor.Digest)
+	target.RawQuery = query.Encode()
+
+	resp, err := r.client.do(r.pushScope(), func() (*http.Request, error) {
+		var body io.Reader = http.NoBody
+		if size > 0 {
+			reader, err := content()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := reader.Seek(descriptor.Size-size, io.SeekStart); err != nil {
+				return nil, err
+			}
+			body = utils.MeterReader(io.LimitReader(reader, size), utils.Upload)
+		}
+		req, err := http.NewRequest(http.MethodPut, target.String(), body)
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/octet-stream")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("upload of %s failed, status: %s", descriptor.Digest, resp.Status)
+	}
+	r.client.recordBlob(descriptor.Digest, r.name)
+	return nil
+}
+
+// startUpload starts a blob upload, asking the registry to mount the blob
+// from a repository known to hold it. mounted is true when the registry did,
+// otherwise the upload location is returned.
+func (r *Repository) startUpload(digest string) (*url.URL, bool, error) {
+	scope, uploadURL := r.pushScope(), r.url("blobs", "uploads/")
+	from := r.client.blobRepository(digest)
+	if from != "" && from != r.name {
+		scope += " repository:" + from + ":pull"
+		uploadURL += "?" + url.Values{"mount": {digest}, "from": {from}}.Encode()
+	}
+	resp, err := r.client.do(scope, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodPost, uploadURL, nil)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated && from != "" {
+		return nil, true, nil
+	}
+	// Registries that cannot mount the blob start a regular upload
+	if resp.StatusCode != http.StatusAccepted {
+		return nil, false, fmt.Errorf("starting upload of %s failed, status: %s", digest, resp.Status)
+	}
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid upload location: %w", err)
+	}
+	return location, false, nil
+}
+
+func (c *Client) recordBlob(digest, repository string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blobs[digest] = repository
+}
+
+func (c *Client) blobRepository(digest string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.blobs[digest]
+}
+
+// PushManifest uploads a manifest under a tag or its digest and returns the
+// digest the registry stored it with
+func (r *Repository) PushManifest(reference, mediaType string, content []byte) (string, error) {
+	resp, err := r.client.do(r.pushScope(), func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPut, r.url("manifests", reference), bytes.NewReader(content))
+		if err == nil {
+			req.Header.Set("Content-Type", mediaType)
+		}
+		return req, err
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("pushing manifest %s failed, status: %s %s", r.Reference(reference), resp.Status, strings.TrimSpace(string(message)))
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	return Digest(content), nil
+}
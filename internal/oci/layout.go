@@ -0,0 +1,496 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"go.uber.org/zap"
+)
+
+// Files of an OCI image layout, see
+// https://github.com/opencontainers/image-spec/blob/main/image-layout.md
+const (
+	layoutFile     = "oci-layout"
+	indexFile      = "index.json"
+	blobsDir       = "blobs"
+	refNameKey     = "org.opencontainers.image.ref.name"
+	layoutContents = `{"imageLayoutVersion":"1.0.0"}`
+)
+
+// Tag suffixes cosign attaches signatures, attestations and SBOMs with
+var cosignSuffixes = []string{".sig", ".att", ".sbom"}
+
+var artifactTag = regexp.MustCompile(`^sha256-[0-9a-f]{64}(\.sig|\.att|\.sbom)?$`)
+
+// IsArtifactTag reports whether a tag is a cosign or referrers tag schema
+// tag, these are copied along with the image they refer to
+func IsArtifactTag(tag string) bool {
+	return artifactTag.MatchString(tag)
+}
+
+type layoutIndex struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+func blobPath(digest string) string {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	return path.Join(blobsDir, algorithm, hex)
+}
+
+// foreign reports whether a layer is not stored in the registry, e.g.
+// Windows base layers, these are referenced by URL and not copied
+func foreign(descriptor Descriptor) bool {
+	return len(descriptor.URLs) > 0 || strings.Contains(descriptor.MediaType, "foreign")
+}
+
+// children returns the manifests of an index or the config and layers of an
+// image manifest
+func children(content []byte) ([]Descriptor, []Descriptor, error) {
+	var parsed manifest
+	if err := json.Unmarshal(content, &parsed); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	var blobs []Descriptor
+	if parsed.Config != nil {
+		blobs = append(blobs, *parsed.Config)
+	}
+	for _, layer := range parsed.Layers {
+		if !foreign(layer) {
+			blobs = append(blobs, layer)
+		}
+	}
+	return parsed.Manifests, blobs, nil
+}
+
+// IndexedDigests returns the digests of the manifests an index lists, and
+// of the manifests of nested indexes. Image manifests list none.
+func (r *Repository) IndexedDigests(reference string) ([]string, error) {
+	_, content, err := r.Manifest(reference)
+	if err != nil {
+		return nil, err
+	}
+	manifests, _, err := children(content)
+	if err != nil {
+		return nil, err
+	}
+	var digests []string
+	for _, child := range manifests {
+		digests = append(digests, child.Digest)
+		if IsIndex(child.MediaType) {
+			nested, err := r.IndexedDigests(child.Digest)
+			if err != nil {
+				return nil, err
+			}
+			digests = append(digests, nested...)
+		}
+	}
+	return digests, nil
+}
+
+// layoutWriter writes each blob of an image once
+type layoutWriter struct {
+	writer    *tar.Writer
+	seen      map[string]bool
+	manifests []string
+}
+
+func (w *layoutWriter) write(name string, size int64, content io.Reader) error {
+	if err := w.writer.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+	_, err := io.Copy(w.writer, content)
+	return err
+}
+
+// Pull writes an image as an OCI image layout tar. Manifest lists and
+// indexes are copied with every platform image, manifests are stored as
+// fetched so the digest of the tag is preserved. With referrers the
+// signatures, attestations and other artifacts of the image are included.
+func Pull(logger *zap.Logger, repository *Repository, tag string, out io.Writer, referrers bool) (Descriptor, error) {
+	top, content, err := repository.Manifest(tag)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	layout := &layoutWriter{writer: tar.NewWriter(out), seen: make(map[string]bool)}
+	if err := layout.write(layoutFile, int64(len(layoutContents)), strings.NewReader(layoutContents)); err != nil {
+		return Descriptor{}, err
+	}
+	if err := pullManifest(logger, repository, layout, top, content); err != nil {
+		return Descriptor{}, err
+	}
+
+	top.Annotations = map[string]string{refNameKey: tag}
+	entries := []Descriptor{top}
+	if referrers {
+		artifacts, err := pullReferrers(logger, repository, layout)
+		if err != nil {
+			return Descriptor{}, err
+		}
+		if len(artifacts) > 0 {
+			logger.Info("Pulled image artifacts", zap.String("image", repository.Reference(tag)), zap.Int("artifacts", len(artifacts)))
+		}
+		entries = append(entries, artifacts...)
+	}
+	index, err := json.Marshal(layoutIndex{SchemaVersion: 2, MediaType: MediaTypeIndex, Manifests: entries})
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if err := layout.write(indexFile, int64(len(index)), bytes.NewReader(index)); err != nil {
+		return Descriptor{}, err
+	}
+	return top, layout.writer.Close()
+}
+
+func pullManifest(logger *zap.Logger, repository *Repository, layout *layoutWriter, descriptor Descriptor, content []byte) error {
+	manifests, blobs, err := children(content)
+	if err != nil {
+		return err
+	}
+	for _, child := range manifests {
+		if layout.seen[child.Digest] {
+			continue
+		}
+		logger.Debug("Pulling manifest", zap.String("digest", child.Digest), zap.String("platform", child.Platform.String()))
+		childDescriptor, childContent, err := repository.Manifest(child.Digest)
+		if err != nil {
+			return err
+		}
+		if err := pullManifest(logger, repository, layout, childDescriptor, childContent); err != nil {
+			return err
+		}
+	}
+	for _, blob := range blobs {
+		if layout.seen[blob.Digest] {
+			continue
+		}
+		logger.Debug("Pulling blob", zap.String("digest", blob.Digest), zap.Int64("size", blob.Size))
+		if err := pullBlob(repository, layout, blob); err != nil {
+			return err
+		}
+	}
+	layout.seen[descriptor.Digest] = true
+	layout.manifests = append(layout.manifests, descriptor.Digest)
+	return layout.write(blobPath(descriptor.Digest), descriptor.Size, bytes.NewReader(content))
+}
+
+// pullReferrers pulls the cosign tags and referrers of every manifest in the
+// layout, and of the artifacts found, and returns their layout entries.
+// Cosign tags keep their tag, referrers are pushed by digest.
+func pullReferrers(logger *zap.Logger, repository *Repository, layout *layoutWriter) ([]Descriptor, error) {
+	var entries []Descriptor
+	for i := 0; i < len(layout.manifests); i++ {
+		subject := layout.manifests[i]
+		for _, suffix := range cosignSuffixes {
+			tag := ReferrersTag(subject) + suffix
+			if _, found, err := repository.ManifestDigest(tag); err != nil {
+				return nil, err
+			} else if !found {
+				continue
+			}
+			descriptor, content, err := repository.Manifest(tag)
+			if err != nil {
+				return nil, err
+			}
+			logger.Debug("Pulling cosign artifact", zap.String("tag", tag), zap.String("digest", descriptor.Digest))
+			if !layout.seen[descriptor.Digest] {
+				if err := pullManifest(logger, repository, layout, descriptor, content); err != nil {
+					return nil, err
+				}
+			}
+			descriptor.Annotations = map[string]string{refNameKey: tag}
+			entries = append(entries, descriptor)
+		}
+
+		referrers, _, err := repository.Referrers(subject)
+		if err != nil {
+			return nil, err
+		}
+		for _, referrer := range referrers {
+			if layout.seen[referrer.Digest] {
+				continue
+			}
+			logger.Debug("Pulling referrer", zap.String("subject", subject), zap.String("digest", referrer.Digest), zap.String("artifactType", referrer.ArtifactType))
+			_, content, err := repository.Manifest(referrer.Digest)
+			if err != nil {
+				return nil, err
+			}
+			referrer.Size = int64(len(content))
+			if err := pullManifest(logger, repository, layout, referrer, content); err != nil {
+				return nil, err
+			}
+			entries = append(entries, referrer)
+		}
+	}
+	return entries, nil
+}
+
+// pullBlob streams a blob into the layout, verifying its digest
+func pullBlob(repository *Repository, layout *layoutWriter, blob Descriptor) error {
+	body, err := repository.Blob(blob.Digest)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	hash := sha256.New()
+	if err := layout.write(blobPath(blob.Digest), blob.Size, io.TeeReader(utils.MeterReader(io.LimitReader(body, blob.Size), utils.Download), hash)); err != nil {
+		return fmt.Errorf("failed to pull blob %s: %w", blob.Digest, err)
+	}
+	if digest := "sha256:" + hex.EncodeToString(hash.Sum(nil)); digest != blob.Digest {
+		return fmt.Errorf("blob %s was downloaded with digest %s", blob.Digest, digest)
+	}
+	layout.seen[blob.Digest] = true
+	return nil
+}
+
+// Archive gives random access to the blobs of an OCI image layout tar
+type Archive struct {
+	file    *os.File
+	entries map[string]*io.SectionReader
+	// Manifests of index.json, the tags of the archive
+	Manifests []Descriptor
+	// overlay holds manifests and configs rewritten by RewriteLabels
+	overlay   map[string][]byte
+	rewritten bool
+}
+
+// countingReader tracks the offset of tar entries in the archive
+type countingReader struct {
+	reader io.Reader
+	offset int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+// OpenArchive indexes an OCI image layout tar written by Pull
+func OpenArchive(archivePath string) (*Archive, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	archive := &Archive{file: file, entries: make(map[string]*io.SectionReader)}
+	counter := &countingReader{reader: file}
+	reader := tar.NewReader(counter)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read %s: %w", archivePath, err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			archive.entries[path.Clean(header.Name)] = io.NewSectionReader(file, counter.offset, header.Size)
+		}
+	}
+
+	if _, ok := archive.entries[layoutFile]; !ok {
+		file.Close()
+		return nil, fmt.Errorf("%s is not an OCI image layout, pull the image again", archivePath)
+	}
+	content, err := archive.read(indexFile)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	var index layoutIndex
+	if err := json.Unmarshal(content, &index); err != nil || len(index.Manifests) == 0 {
+		file.Close()
+		return nil, fmt.Errorf("failed to read the image index of %s: %v", archivePath, err)
+	}
+	archive.Manifests = index.Manifests
+	return archive, nil
+}
+
+func (a *Archive) Close() error {
+	return a.file.Close()
+}
+
+// entry returns a fresh reader of an archive file
+func (a *Archive) entry(name string) (*io.SectionReader, error) {
+	if content, ok := a.overlay[path.Clean(name)]; ok {
+		return io.NewSectionReader(bytes.NewReader(content), 0, int64(len(content))), nil
+	}
+	section, ok := a.entries[path.Clean(name)]
+	if !ok {
+		return nil, fmt.Errorf("%s is missing from the image archive", name)
+	}
+	return io.NewSectionReader(section, 0, section.Size()), nil
+}
+
+func (a *Archive) read(name string) ([]byte, error) {
+	section, err := a.entry(name)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(section)
+}
+
+// Tag returns the tag the image was pulled with
+func (a *Archive) Tag() string {
+	return a.Manifests[0].Annotations[refNameKey]
+}
+
+// Push uploads the image of an archive to a tag, blobs first, then the
+// platform manifests by digest and the tag last. The digest the target
+// stored the tag with must match the source digest. Artifacts pulled with
+// the image are pushed after it. It returns false when the tag already
+// exists with the same digest.
+func Push(logger *zap.Logger, repository *Repository, archive *Archive, tag string) (bool, error) {
+	top := archive.Manifests[0]
+	pushed := make(map[string]bool)
+	digest, found, err := repository.ManifestDigest(tag)
+	if err != nil {
+		return false, err
+	}
+	copied := !found || digest != top.Digest
+	if copied {
+		if err := pushManifest(logger, repository, archive, top, tag, pushed); err != nil {
+			return false, err
+		}
+		logger.Info("Pushed image", zap.String("image", repository.Reference(tag)), zap.String("digest", top.Digest))
+	} else {
+		logger.Info("Image tag already exists", zap.String("image", repository.Reference(tag)), zap.String("digest", digest))
+	}
+	if err := pushArtifacts(logger, repository, archive, pushed); err != nil {
+		return false, err
+	}
+	return copied, nil
+}
+
+// pushArtifacts pushes the cosign tags and referrers of an archive. The
+// referrers tag schema index of each subject is updated on registries
+// without the referrers API.
+func pushArtifacts(logger *zap.Logger, repository *Repository, archive *Archive, pushed map[string]bool) error {
+	// Artifacts refer to the digests of the image before its labels were
+	// rewritten, and signatures no longer match it
+	if archive.rewritten && len(archive.Manifests) > 1 {
+		logger.Warn("Skipping the artifacts of an image whose labels were rewritten", zap.String("image", repository.Reference(archive.Tag())), zap.Int("artifacts", len(archive.Manifests)-1))
+		return nil
+	}
+	subjects := make(map[string][]Descriptor)
+	var order []string
+	for _, entry := range archive.Manifests[1:] {
+		reference := entry.Digest
+		if tag := entry.Annotations[refNameKey]; tag != "" {
+			reference = tag
+		}
+		logger.Debug("Pushing artifact", zap.String("reference", reference), zap.String("artifactType", entry.ArtifactType))
+		if err := pushManifest(logger, repository, archive, entry, reference, pushed); err != nil {
+			return err
+		}
+
+		content, err := archive.read(blobPath(entry.Digest))
+		if err != nil {
+			return err
+		}
+		var parsed manifest
+		if err := json.Unmarshal(content, &parsed); err != nil {
+			return err
+		}
+		if parsed.Subject != nil {
+			if _, ok := subjects[parsed.Subject.Digest]; !ok {
+				order = append(order, parsed.Subject.Digest)
+			}
+			subjects[parsed.Subject.Digest] = append(subjects[parsed.Subject.Digest], entry)
+		}
+	}
+
+	for _, subject := range order {
+		existing, supported, err := repository.Referrers(subject)
+		if err != nil {
+			return err
+		}
+		if supported {
+			continue
+		}
+		index := layoutIndex{SchemaVersion: 2, MediaType: MediaTypeIndex, Manifests: existing}
+		listed := make(map[string]bool)
+		for _, descriptor := range existing {
+			listed[descriptor.Digest] = true
+		}
+		for _, referrer := range subjects[subject] {
+			if !listed[referrer.Digest] {
+				index.Manifests = append(index.Manifests, referrer)
+			}
+		}
+		if len(index.Manifests) == len(existing) {
+			continue
+		}
+		content, err := json.Marshal(index)
+		if err != nil {
+			return err
+		}
+		logger.Debug("Updating referrers tag", zap.String("tag", ReferrersTag(subject)), zap.Int("referrers", len(index.Manifests)))
+		if _, err := repository.PushManifest(ReferrersTag(subject), MediaTypeIndex, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushManifest pushes the blobs and child manifests of a manifest, then the
+// manifest itself under reference, verifying the digest the target reports
+func pushManifest(logger *zap.Logger, repository *Repository, archive *Archive, descriptor Descriptor, reference string, pushed map[string]bool) error {
+	content, err := archive.read(blobPath(descriptor.Digest))
+	if err != nil {
+		return err
+	}
+	if digest := Digest(content); digest != descriptor.Digest {
+		return fmt.Errorf("manifest %s in the archive has digest %s", descriptor.Digest, digest)
+	}
+	manifests, blobs, err := children(content)
+	if err != nil {
+		return err
+	}
+	if pushed[descriptor.Digest] && reference == descriptor.Digest {
+		return nil
+	}
+	for _, blob := range blobs {
+		if pushed[blob.Digest] {
+			continue
+		}
+		logger.Debug("Pushing blob", zap.String("digest", blob.Digest), zap.Int64("size", blob.Size))
+		err := repository.PushBlob(logger, blob, func() (io.ReadSeeker, error) {
+			return archive.entry(blobPath(blob.Digest))
+		})
+		if err != nil {
+			return err
+		}
+		pushed[blob.Digest] = true
+	}
+	for _, child := range manifests {
+		if pushed[child.Digest] {
+			continue
+		}
+		logger.Debug("Pushing manifest", zap.String("digest", child.Digest), zap.String("platform", child.Platform.String()))
+		if err := pushManifest(logger, repository, archive, child, child.Digest, pushed); err != nil {
+			return err
+		}
+	}
+	digest, err := repository.PushManifest(reference, descriptor.MediaType, content)
+	if err != nil {
+		return err
+	}
+	if digest != descriptor.Digest {
+		return fmt.Errorf("digest mismatch for %s, source %s, target %s", repository.Reference(reference), descriptor.Digest, digest)
+	}
+	pushed[descriptor.Digest] = true
+	return nil
+}
@@ -0,0 +1,362 @@
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"go.uber.org/zap"
+)
+
+var registryPath = regexp.MustCompile(`^/v2/(.+)/(manifests|blobs|referrers)/(.+)$`)
+
+// testRegistry is an in-memory registry that requires a token and rejects
+// manifests whose blobs or child manifests are missing
+type testRegistry struct {
+	t            *testing.T
+	referrersAPI bool
+	mu           sync.Mutex
+	blobs        map[string][]byte
+	manifests    map[string][]byte
+	types        map[string]string
+	uploads      int
+	mounts       int
+}
+
+func newTestRegistry(t *testing.T) (*testRegistry, *httptest.Server) {
+	registry := &testRegistry{t: t, blobs: map[string][]byte{}, manifests: map[string][]byte{}, types: map[string]string{}}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if username, password, _ := r.BasicAuth(); username != "acme" || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token":"registry-token"}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer registry-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		registry.serve(w, r)
+	}))
+	return registry, server
+}
+
+func (reg *testRegistry) serve(w http.ResponseWriter, r *http.Request) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if r.Method == http.MethodPut && r.URL.Path == "/upload" {
+		content, _ := io.ReadAll(r.Body)
+		if Digest(content) != r.URL.Query().Get("digest") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reg.blobs[r.URL.Query().Get("repository")+"@"+Digest(content)] = content
+		reg.uploads++
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	match := registryPath.FindStringSubmatch(r.URL.Path)
+	if match == nil {
+		reg.t.Errorf("unexpected %s %s", r.Method, r.URL)
+		return
+	}
+	name, kind, reference := match[1], match[2], match[3]
+	switch {
+	case kind == "referrers":
+		if !reg.referrersAPI {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		index := layoutIndex{SchemaVersion: 2, MediaType: MediaTypeIndex, Manifests: []Descriptor{}}
+		for key, content := range reg.manifests {
+			var parsed struct {
+				manifest
+				ArtifactType string `json:"artifactType"`
+			}
+			json.Unmarshal(content, &parsed)
+			if strings.HasPrefix(key, name+"@") && parsed.Subject != nil && parsed.Subject.Digest == reference {
+				index.Manifests = append(index.Manifests, Descriptor{MediaType: reg.types[key], ArtifactType: parsed.ArtifactType, Digest: Digest(content), Size: int64(len(content))})
+			}
+		}
+		json.NewEncoder(w).Encode(index)
+	case kind == "blobs" && r.Method == http.MethodPost && reference == "uploads/":
+		query := r.URL.Query()
+		if content, ok := reg.blobs[query.Get("from")+"@"+query.Get("mount")]; ok {
+			reg.blobs[name+"@"+query.Get("mount")] = content
+			reg.mounts++
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Header().Set("Location", "/upload?repository="+name)
+		w.WriteHeader(http.StatusAccepted)
+	case kind == "blobs":
+		content, ok := reg.blobs[name+"@"+reference]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(content)
+	case r.Method == http.MethodPut:
+		content, _ := io.ReadAll(r.Body)
+		manifests, blobs, _ := children(content)
+		for _, blob := range blobs {
+			if _, ok := reg.blobs[name+"@"+blob.Digest]; !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		for _, child := range manifests {
+			if _, ok := reg.manifests[name+"@"+child.Digest]; !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		digest := Digest(content)
+		for _, key := range []string{name + "@" + digest, name + ":" + reference} {
+			reg.manifests[key], reg.types[key] = content, r.Header.Get("Content-Type")
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		key := name + ":" + reference
+		if strings.HasPrefix(reference, "sha256:") {
+			key = name + "@" + reference
+		}
+		content, ok := reg.manifests[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", reg.types[key])
+		w.Header().Set("Docker-Content-Digest", Digest(content))
+		if r.Method == http.MethodGet {
+			w.Write(content)
+		}
+	}
+}
+
+// add stores a blob or manifest, manifests are indented so any re-encoding
+// would change their digest
+func (reg *testRegistry) add(name, tag, mediaType string, value interface{}) Descriptor {
+	content, _ := json.MarshalIndent(value, "", "   ")
+	if mediaType == "" {
+		content = []byte(value.(string))
+		reg.blobs[name+"@"+Digest(content)] = content
+		return Descriptor{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: Digest(content), Size: int64(len(content))}
+	}
+	for _, key := range []string{name + "@" + Digest(content), name + ":" + tag} {
+		reg.manifests[key], reg.types[key] = content, mediaType
+	}
+	return Descriptor{MediaType: mediaType, Digest: Digest(content), Size: int64(len(content))}
+}
+
+func TestCopyMultiPlatformImage(t *testing.T) {
+	source, sourceServer := newTestRegistry(t)
+	defer sourceServer.Close()
+	var platforms []Descriptor
+	for _, arch := range []string{"amd64", "arm64"} {
+		config := source.add("acme/api", "", "", fmt.Sprintf(`{"architecture":%q,"os":"linux"}`, arch))
+		config.MediaType = "application/vnd.oci.image.config.v1+json"
+		layer := source.add("acme/api", "", "", "layer "+arch)
+		image := source.add("acme/api", "", MediaTypeManifest, map[string]interface{}{
+			"schemaVersion": 2, "mediaType": MediaTypeManifest, "config": config, "layers": []Descriptor{layer},
+		})
+		image.Platform = &Platform{Architecture: arch, OS: "linux"}
+		platforms = append(platforms, image)
+	}
+	index := source.add("acme/api", "1.0", MediaTypeIndex, map[string]interface{}{
+		"schemaVersion": 2, "mediaType": MediaTypeIndex, "manifests": platforms,
+	})
+
+	archivePath := filepath.Join(t.TempDir(), "api-1.0.tar")
+	file, _ := os.Create(archivePath)
+	pulled, err := Pull(zap.NewNop(), NewClient(sourceServer.URL, "acme", "secret").Repository("acme/api"), "1.0", file, false)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pulled.Digest != index.Digest || pulled.MediaType != MediaTypeIndex {
+		t.Errorf("pulled %+v, want %s", pulled, index.Digest)
+	}
+
+	target, targetServer := newTestRegistry(t)
+	defer targetServer.Close()
+	archive, err := OpenArchive(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	if archive.Tag() != "1.0" {
+		t.Errorf("archive tag = %q", archive.Tag())
+	}
+	repository := NewClient(targetServer.URL, "acme", "secret").Repository("octo/api")
+	for i, want := range []bool{true, false} {
+		if pushed, err := Push(zap.NewNop(), repository, archive, "1.0"); err != nil || pushed != want {
+			t.Fatalf("push %d: pushed = %v, err = %v, want %v", i, pushed, err, want)
+		}
+	}
+	if digest, found, err := repository.ManifestDigest("1.0"); err != nil || !found || digest != index.Digest {
+		t.Errorf("target digest = %s, want %s (%v)", digest, index.Digest, err)
+	}
+	for _, platform := range platforms {
+		if _, ok := target.manifests["octo/api@"+platform.Digest]; !ok {
+			t.Errorf("%s image %s was not copied", platform.Platform, platform.Digest)
+		}
+	}
+	if len(target.blobs) != 4 {
+		t.Errorf("copied %d blobs, want 4", len(target.blobs))
+	}
+
+	// Another image with the same layers mounts them instead
+	if _, err := Push(zap.NewNop(), repository.client.Repository("octo/web"), archive, "1.0"); err != nil {
+		t.Fatal(err)
+	}
+	if target.uploads != 4 || target.mounts != 4 {
+		t.Errorf("uploads = %d, mounts = %d, want 4 and 4", target.uploads, target.mounts)
+	}
+}
+
+func TestPullStreamsLargeLayers(t *testing.T) {
+	const size = 128 << 20
+	source, sourceServer := newTestRegistry(t)
+	defer sourceServer.Close()
+	content := make([]byte, size)
+	copy(content[size-3:], "end")
+	source.blobs["acme/api@"+Digest(content)] = content
+	layer := Descriptor{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: Digest(content), Size: size}
+	config := source.add("acme/api", "", "", `{"architecture":"amd64","os":"linux"}`)
+	source.add("acme/api", "1.0", MediaTypeManifest, map[string]interface{}{
+		"schemaVersion": 2, "mediaType": MediaTypeManifest, "config": config, "layers": []Descriptor{layer},
+	})
+	source.add("acme/api", "huge", MediaTypeManifest, map[string]interface{}{
+		"schemaVersion": 2, "mediaType": MediaTypeManifest, "config": config,
+		"annotations": map[string]string{"padding": strings.Repeat("x", maxManifestSize)},
+	})
+	repository := NewClient(sourceServer.URL, "acme", "secret").Repository("acme/api")
+
+	archivePath := filepath.Join(t.TempDir(), "api-1.0.tar")
+	file, _ := os.Create(archivePath)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := Pull(zap.NewNop(), repository, "1.0", file, false)
+	runtime.ReadMemStats(&after)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size/8 {
+		t.Errorf("pulling a layer of %s allocated %s, the layer was not streamed", utils.FormatBytes(size), utils.FormatBytes(int64(allocated)))
+	}
+	archive, err := OpenArchive(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	if section, err := archive.entry(blobPath(layer.Digest)); err != nil || section.Size() != size {
+		t.Errorf("archived layer = %v, want %d bytes", err, size)
+	}
+
+	if _, _, err := repository.Manifest("huge"); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("expected an oversized manifest to be rejected, got %v", err)
+	}
+}
+
+func TestCopyReferrers(t *testing.T) {
+	source, sourceServer := newTestRegistry(t)
+	defer sourceServer.Close()
+	source.referrersAPI = true
+	config := source.add("acme/api", "", "", `{"architecture":"amd64","os":"linux"}`)
+	layer := source.add("acme/api", "", "", "layer")
+	image := source.add("acme/api", "1.0", MediaTypeManifest, map[string]interface{}{
+		"schemaVersion": 2, "mediaType": MediaTypeManifest, "config": config, "layers": []Descriptor{layer},
+	})
+	signature := source.add("acme/api", ReferrersTag(image.Digest)+".sig", MediaTypeManifest, map[string]interface{}{
+		"schemaVersion": 2, "mediaType": MediaTypeManifest, "config": config, "layers": []Descriptor{source.add("acme/api", "", "", "signature")},
+	})
+	sbom := source.add("acme/api", "", MediaTypeManifest, map[string]interface{}{
+		"schemaVersion": 2, "mediaType": MediaTypeManifest, "artifactType": "application/spdx+json",
+		"config": config, "layers": []Descriptor{source.add("acme/api", "", "", "sbom")}, "subject": image,
+	})
+
+	archivePath := filepath.Join(t.TempDir(), "api-1.0.tar")
+	file, _ := os.Create(archivePath)
+	_, err := Pull(zap.NewNop(), NewClient(sourceServer.URL, "acme", "secret").Repository("acme/api"), "1.0", file, true)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := OpenArchive(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	if len(archive.Manifests) != 3 {
+		t.Fatalf("archive has %d manifests, want image, signature and SBOM", len(archive.Manifests))
+	}
+
+	// The target has no referrers API, the SBOM is listed in the referrers
+	// tag schema index instead
+	target, targetServer := newTestRegistry(t)
+	defer targetServer.Close()
+	if _, err := Push(zap.NewNop(), NewClient(targetServer.URL, "acme", "secret").Repository("octo/api"), archive, "1.0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := target.manifests["octo/api:"+ReferrersTag(image.Digest)+".sig"]; !ok || target.manifests["octo/api@"+signature.Digest] == nil {
+		t.Errorf("signature tag was not copied")
+	}
+	var index layoutIndex
+	json.Unmarshal(target.manifests["octo/api:"+ReferrersTag(image.Digest)], &index)
+	if len(index.Manifests) != 1 || index.Manifests[0].Digest != sbom.Digest || index.Manifests[0].ArtifactType != "application/spdx+json" {
+		t.Errorf("referrers tag index = %+v", index)
+	}
+
+	for tag, want := range map[string]bool{
+		ReferrersTag(image.Digest):          true,
+		ReferrersTag(image.Digest) + ".att": true,
+		"sha256-1.0":                        false,
+		"1.0":                               false,
+	} {
+		if IsArtifactTag(tag) != want {
+			t.Errorf("IsArtifactTag(%q) = %v, want %v", tag, !want, want)
+		}
+	}
+}
+
+func TestRetagRules(t *testing.T) {
+	rules, err := ParseRetagRules(`^v(\d+\.\d+\.\d+)$=${1} (.*)=legacy-${1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tag, want := range map[string]string{
+		"v1.2.3":  "1.2.3",
+		"latest":  "legacy-latest",
+		"v1.2.3a": "legacy-v1.2.3a",
+	} {
+		if got, err := rules.Apply(tag); err != nil || got != want {
+			t.Errorf("Apply(%q) = %q, %v, want %q", tag, got, err, want)
+		}
+	}
+
+	if rules, _ := ParseRetagRules("latest=-bad"); rules != nil {
+		if _, err := rules.Apply("latest"); err == nil {
+			t.Error("expected an error for an invalid target tag")
+		}
+	}
+	if _, err := ParseRetagRules("no-replacement"); err == nil {
+		t.Error("expected an error for a rule without replacement")
+	}
+}
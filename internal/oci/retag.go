@@ -0,0 +1,54 @@
+package oci
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// retagRule renames tags matching a pattern, the replacement can use the
+// pattern's groups like $1
+type retagRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// RetagRules map source tags to target tags
+type RetagRules []retagRule
+
+// validTag is the tag grammar of the OCI distribution spec
+var validTag = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+
+// ParseRetagRules parses space separated pattern=replacement rules, e.g.
+// ^(.*)$=legacy-$1. Patterns match the whole tag.
+func ParseRetagRules(value string) (RetagRules, error) {
+	var rules RetagRules
+	for _, entry := range strings.Fields(value) {
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid retag rule %q, expected pattern=replacement", entry)
+		}
+		pattern, err := regexp.Compile("^(?:" + strings.TrimSuffix(strings.TrimPrefix(entry[:i], "^"), "$") + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid retag rule %q: %w", entry, err)
+		}
+		rules = append(rules, retagRule{pattern: pattern, replacement: entry[i+1:]})
+	}
+	return rules, nil
+}
+
+// Apply returns the target tag of a source tag, the first matching rule
+// wins and tags matching no rule are kept
+func (r RetagRules) Apply(tag string) (string, error) {
+	for _, rule := range r {
+		if !rule.pattern.MatchString(tag) {
+			continue
+		}
+		retagged := rule.pattern.ReplaceAllString(tag, rule.replacement)
+		if !validTag.MatchString(retagged) {
+			return "", fmt.Errorf("tag %s is retagged to the invalid tag %q", tag, retagged)
+		}
+		return retagged, nil
+	}
+	return tag, nil
+}
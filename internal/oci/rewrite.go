@@ -0,0 +1,164 @@
+package oci
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// SourceLabels are the labels and annotations GitHub links container images
+// to their repository with
+var SourceLabels = []string{"org.opencontainers.image.source", "org.opencontainers.image.url"}
+
+// RewriteFunc returns the new value of a source label, false keeps the value
+type RewriteFunc func(value string) (string, bool)
+
+// rewriter rewrites the source labels of the images of an archive. Changed
+// manifests and configs are kept in the overlay of the archive under their
+// new digests.
+type rewriter struct {
+	archive *Archive
+	rewrite RewriteFunc
+	// done maps the digests of rewritten manifests to their descriptors
+	done map[string]Descriptor
+}
+
+// RewriteLabels rewrites the source labels of the image configs and the
+// source annotations of the manifests of the tag of an archive, which is
+// then pushed with a new digest. It returns false when no label was
+// rewritten.
+func (a *Archive) RewriteLabels(rewrite RewriteFunc) (bool, error) {
+	r := &rewriter{archive: a, rewrite: rewrite, done: make(map[string]Descriptor)}
+	top, err := r.manifest(a.Manifests[0])
+	if err != nil {
+		return false, err
+	}
+	if top.Digest == a.Manifests[0].Digest {
+		return false, nil
+	}
+	top.Annotations = a.Manifests[0].Annotations
+	a.Manifests[0] = top
+	a.rewritten = true
+	return true, nil
+}
+
+// decode parses JSON keeping numbers as they are
+func decode(content []byte) (map[string]any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var parsed map[string]any
+	if err := decoder.Decode(&parsed); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// labels rewrites the source labels of a label or annotation map
+func (r *rewriter) labels(value any) bool {
+	labels, ok := value.(map[string]any)
+	if !ok {
+		return false
+	}
+	changed := false
+	for _, key := range SourceLabels {
+		if current, ok := labels[key].(string); ok {
+			if rewritten, ok := r.rewrite(current); ok && rewritten != current {
+				labels[key] = rewritten
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// store adds rewritten content to the overlay of the archive
+func (r *rewriter) store(descriptor Descriptor, parsed map[string]any) (Descriptor, error) {
+	content, err := json.Marshal(parsed)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	descriptor.Digest = Digest(content)
+	descriptor.Size = int64(len(content))
+	if r.archive.overlay == nil {
+		r.archive.overlay = make(map[string][]byte)
+	}
+	r.archive.overlay[blobPath(descriptor.Digest)] = content
+	return descriptor, nil
+}
+
+// descriptorOf updates a descriptor in a parsed manifest
+func descriptorOf(value any, descriptor Descriptor) {
+	if fields, ok := value.(map[string]any); ok {
+		fields["digest"] = descriptor.Digest
+		fields["size"] = descriptor.Size
+	}
+}
+
+func (r *rewriter) manifest(descriptor Descriptor) (Descriptor, error) {
+	if rewritten, ok := r.done[descriptor.Digest]; ok {
+		return rewritten, nil
+	}
+	content, err := r.archive.read(blobPath(descriptor.Digest))
+	if err != nil {
+		return Descriptor{}, err
+	}
+	parsed, err := decode(content)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("failed to decode manifest %s: %w", descriptor.Digest, err)
+	}
+	manifests, blobs, err := children(content)
+	if err != nil {
+		return Descriptor{}, err
+	}
+
+	changed := r.labels(parsed["annotations"])
+	if list, ok := parsed["manifests"].([]any); ok && len(list) == len(manifests) {
+		for i, child := range manifests {
+			rewritten, err := r.manifest(child)
+			if err != nil {
+				return Descriptor{}, err
+			}
+			if rewritten.Digest != child.Digest {
+				descriptorOf(list[i], rewritten)
+				changed = true
+			}
+		}
+	}
+	if config, ok := parsed["config"]; ok && len(blobs) > 0 {
+		rewritten, err := r.config(blobs[0])
+		if err != nil {
+			return Descriptor{}, err
+		}
+		if rewritten.Digest != blobs[0].Digest {
+			descriptorOf(config, rewritten)
+			changed = true
+		}
+	}
+
+	rewritten := descriptor
+	if changed {
+		if rewritten, err = r.store(descriptor, parsed); err != nil {
+			return Descriptor{}, err
+		}
+	}
+	r.done[descriptor.Digest] = rewritten
+	return rewritten, nil
+}
+
+// config rewrites the labels of an image config, configs of other artifacts
+// are kept
+func (r *rewriter) config(descriptor Descriptor) (Descriptor, error) {
+	content, err := r.archive.read(blobPath(descriptor.Digest))
+	if err != nil {
+		return descriptor, err
+	}
+	parsed, err := decode(content)
+	if err != nil {
+		return descriptor, nil
+	}
+	fields, ok := parsed["config"].(map[string]any)
+	if !ok || !r.labels(fields["Labels"]) {
+		return descriptor, nil
+	}
+	return r.store(descriptor, parsed)
+}
@@ -0,0 +1,76 @@
+package oci
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestCopyRewrittenLabels(t *testing.T) {
+	source, sourceServer := newTestRegistry(t)
+	defer sourceServer.Close()
+	config := source.add("acme/api", "", "", `{"architecture":"amd64","os":"linux","config":{"Labels":{"org.opencontainers.image.source":"https://github.com/acme/api","maintainer":"acme"}}}`)
+	config.MediaType = "application/vnd.oci.image.config.v1+json"
+	layer := source.add("acme/api", "", "", "layer")
+	image := source.add("acme/api", "", MediaTypeManifest, map[string]interface{}{
+		"schemaVersion": 2, "mediaType": MediaTypeManifest, "config": config, "layers": []Descriptor{layer},
+	})
+	image.Platform = &Platform{Architecture: "amd64", OS: "linux"}
+	index := source.add("acme/api", "1.0", MediaTypeIndex, map[string]interface{}{
+		"schemaVersion": 2, "mediaType": MediaTypeIndex, "manifests": []Descriptor{image},
+		"annotations": map[string]string{"org.opencontainers.image.url": "https://github.com/acme/api"},
+	})
+
+	archivePath := filepath.Join(t.TempDir(), "api-1.0.tar")
+	file, _ := os.Create(archivePath)
+	_, err := Pull(zap.NewNop(), NewClient(sourceServer.URL, "acme", "secret").Repository("acme/api"), "1.0", file, false)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := OpenArchive(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	rewritten, err := archive.RewriteLabels(func(value string) (string, bool) {
+		return strings.Replace(value, "/acme/", "/octo/", 1), true
+	})
+	if err != nil || !rewritten {
+		t.Fatalf("rewritten = %v, err = %v", rewritten, err)
+	}
+	if archive.Manifests[0].Digest == index.Digest || archive.Tag() != "1.0" {
+		t.Errorf("expected a new digest with the tag kept, got %+v", archive.Manifests[0])
+	}
+
+	target, targetServer := newTestRegistry(t)
+	defer targetServer.Close()
+	repository := NewClient(targetServer.URL, "acme", "secret").Repository("octo/api")
+	if pushed, err := Push(zap.NewNop(), repository, archive, "1.0"); err != nil || !pushed {
+		t.Fatalf("pushed = %v, err = %v", pushed, err)
+	}
+	var pushedIndex, pushedImage, pushedConfig struct {
+		Annotations map[string]string
+		Manifests   []Descriptor
+		Config      json.RawMessage
+	}
+	json.Unmarshal(target.manifests["octo/api:1.0"], &pushedIndex)
+	if pushedIndex.Annotations["org.opencontainers.image.url"] != "https://github.com/octo/api" || len(pushedIndex.Manifests) != 1 || pushedIndex.Manifests[0].Platform == nil {
+		t.Fatalf("unexpected index %+v", pushedIndex)
+	}
+	json.Unmarshal(target.manifests["octo/api@"+pushedIndex.Manifests[0].Digest], &pushedImage)
+	var configDescriptor Descriptor
+	json.Unmarshal(pushedImage.Config, &configDescriptor)
+	json.Unmarshal(target.blobs["octo/api@"+configDescriptor.Digest], &pushedConfig)
+	if !strings.Contains(string(pushedConfig.Config), `"org.opencontainers.image.source":"https://github.com/octo/api"`) || !strings.Contains(string(pushedConfig.Config), `"maintainer":"acme"`) {
+		t.Errorf("unexpected config %s", pushedConfig.Config)
+	}
+	if _, ok := target.blobs["octo/api@"+layer.Digest]; !ok {
+		t.Errorf("layer %s was not copied", layer.Digest)
+	}
+}
@@ -0,0 +1,77 @@
+package profile
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// readKeychain reads a token from the keychain of the OS, source is the
+// service optionally followed by /ACCOUNT. macOS reads the login keychain
+// with security, Linux the Secret Service (GNOME Keyring, KWallet) with
+// secret-tool.
+func readKeychain(source string) (string, error) {
+	service, account := source, ""
+	if i := strings.LastIndex(source, "/"); i > 0 {
+		service, account = source[:i], source[i+1:]
+	}
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		args := []string{"find-generic-password", "-s", service, "-w"}
+		if account != "" {
+			args = append(args, "-a", account)
+		}
+		cmd = exec.Command("security", args...)
+	case "windows":
+		return "", fmt.Errorf("the Windows Credential Manager can't be read directly, use git-credential:HOST with Git Credential Manager instead")
+	default:
+		args := []string{"lookup", "service", service}
+		if account != "" {
+			args = append(args, "account", account)
+		}
+		cmd = exec.Command("secret-tool", args...)
+	}
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from the keychain: %w", source, err)
+	}
+	return string(output), nil
+}
+
+// readGitCredential asks the git credential helpers for the password of a
+// host, e.g. Git Credential Manager or the gh credential helper. Git is
+// told not to prompt, a host without stored credentials is an error.
+func readGitCredential(host string) (string, error) {
+	protocol := "https"
+	if scheme, rest, ok := strings.Cut(host, "://"); ok {
+		protocol, host = scheme, rest
+	}
+	host = strings.TrimSuffix(host, "/")
+	cmd := exec.Command("git", "credential", "fill")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=%s\nhost=%s\n\n", protocol, host))
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git has no credentials for %s: %w", host, err)
+	}
+	return parseGitCredential(output, host)
+}
+
+// parseGitCredential returns the password of the output of git credential
+// fill
+func parseGitCredential(output []byte, host string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if password, ok := strings.CutPrefix(scanner.Text(), "password="); ok && password != "" {
+			return password, nil
+		}
+	}
+	return "", fmt.Errorf("git has no password for %s", host)
+}
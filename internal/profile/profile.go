@@ -0,0 +1,292 @@
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/credentials"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFile is the config file read from the working directory
+const DefaultFile = ".gh-migrate-packages.yaml"
+
+// Side is the source or target of a profile
+type Side struct {
+	Hostname     string `yaml:"hostname,omitempty"`
+	Organization string `yaml:"organization,omitempty"`
+	Registry     string `yaml:"registry,omitempty"`
+	Username     string `yaml:"username,omitempty"`
+	// TokenFrom names where the token is read from: env:NAME, file:PATH or
+	// command:COMMAND. Tokens are never stored in the file itself.
+	TokenFrom string `yaml:"token_from,omitempty"`
+	// RegistryURLs replaces the registry URL derived from the hostname per
+	// package type, e.g. npm: https://artifactory.example.com/api/npm/npm
+	RegistryURLs map[string]string `yaml:"registry_urls,omitempty"`
+}
+
+// HostCredential is the login of a registry host, for one organization or
+// for every organization of the host
+type HostCredential struct {
+	Host         string `yaml:"host"`
+	Organization string `yaml:"organization,omitempty"`
+	Username     string `yaml:"username,omitempty"`
+	TokenFrom    string `yaml:"token_from"`
+}
+
+// Profile holds the settings of one migration
+type Profile struct {
+	Source      Side   `yaml:"source,omitempty"`
+	Target      Side   `yaml:"target,omitempty"`
+	PackageType string `yaml:"package_type,omitempty"`
+	Repository  string `yaml:"repository,omitempty"`
+	IncludeTags string `yaml:"include_tags,omitempty"`
+	ExcludeTags string `yaml:"exclude_tags,omitempty"`
+	Untagged    string `yaml:"untagged,omitempty"`
+	Concurrency int    `yaml:"concurrency,omitempty"`
+	// Credentials are used instead of the source and target tokens for the
+	// hosts and organizations they name
+	Credentials []HostCredential `yaml:"credentials,omitempty"`
+	// Settings sets any other option by its environment variable name
+	Settings map[string]string `yaml:"settings,omitempty"`
+}
+
+// File is the content of the config file
+type File struct {
+	DefaultProfile string             `yaml:"default_profile,omitempty"`
+	Profiles       map[string]Profile `yaml:"profiles,omitempty"`
+}
+
+// Load reads a config file
+func Load(path string) (*File, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file File
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &file, nil
+}
+
+// Save writes the config file, readable by the owner only
+func (f *File) Save(path string) error {
+	content, err := yaml.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0600)
+}
+
+// Select returns the named profile, or the default profile when name is
+// empty. It returns an empty name when there is no default.
+func (f *File) Select(name string) (string, *Profile, error) {
+	if name == "" {
+		name = f.DefaultProfile
+	}
+	if name == "" {
+		return "", nil, nil
+	}
+	profile, ok := f.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(f.Profiles))
+		for known := range f.Profiles {
+			names = append(names, known)
+		}
+		sort.Strings(names)
+		return "", nil, fmt.Errorf("unknown profile %q, known profiles: %s", name, strings.Join(names, ", "))
+	}
+	return name, &profile, nil
+}
+
+// Values returns the settings of the profile by environment variable name,
+// tokens are resolved from where the profile reads them
+func (p *Profile) Values() (map[string]string, error) {
+	values := map[string]string{}
+	for key, value := range p.Settings {
+		values[strings.ToUpper(key)] = value
+	}
+	for prefix, side := range map[string]Side{"GHMPKG_SOURCE_": p.Source, "GHMPKG_TARGET_": p.Target} {
+		set(values, prefix+"HOSTNAME", side.Hostname)
+		set(values, prefix+"ORGANIZATION", side.Organization)
+		set(values, prefix+"REGISTRY", side.Registry)
+		set(values, prefix+"USERNAME", side.Username)
+		for packageType, registryURL := range side.RegistryURLs {
+			set(values, prefix+strings.ToUpper(packageType)+"_REGISTRY_URL", registryURL)
+		}
+		if side.TokenFrom == "" {
+			continue
+		}
+		token, err := ReadToken(side.TokenFrom)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %sTOKEN: %w", prefix, err)
+		}
+		set(values, prefix+"TOKEN", token)
+	}
+	// Pull reads the plural key, sync and migrate the singular one
+	set(values, "GHMPKG_PACKAGE_TYPE", p.PackageType)
+	set(values, "GHMPKG_PACKAGE_TYPES", p.PackageType)
+	set(values, "GHMPKG_REPOSITORY", p.Repository)
+	set(values, "GHMPKG_INCLUDE_TAGS", p.IncludeTags)
+	set(values, "GHMPKG_EXCLUDE_TAGS", p.ExcludeTags)
+	set(values, "GHMPKG_UNTAGGED", p.Untagged)
+	if p.Concurrency > 0 {
+		values["GHMPKG_CONCURRENCY"] = strconv.Itoa(p.Concurrency)
+	}
+	return values, nil
+}
+
+// ResolveCredentials reads the tokens of the credentials of the profile
+func (p *Profile) ResolveCredentials() ([]credentials.Credential, error) {
+	var resolved []credentials.Credential
+	for _, credential := range p.Credentials {
+		if credential.Host == "" {
+			return nil, fmt.Errorf("credential without a host")
+		}
+		token, err := ReadToken(credential.TokenFrom)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the token of %s: %w", credential.Host, err)
+		}
+		resolved = append(resolved, credentials.Credential{
+			Host:         credential.Host,
+			Organization: credential.Organization,
+			Username:     credential.Username,
+			Token:        token,
+			Read:         readerOf(credential.TokenFrom),
+		})
+	}
+	return resolved, nil
+}
+
+func set(values map[string]string, key, value string) {
+	if value != "" {
+		values[key] = value
+	}
+}
+
+// tokenSources are the forms of token_from
+const tokenSources = "env:NAME, file:PATH, command:COMMAND, keychain:SERVICE[/ACCOUNT] or git-credential:HOST"
+
+// ReadToken reads a token from env:NAME, file:PATH, command:COMMAND, the
+// OS keychain with keychain:SERVICE[/ACCOUNT] or the git credential helpers
+// with git-credential:HOST
+func ReadToken(from string) (string, error) {
+	kind, source, ok := strings.Cut(from, ":")
+	if !ok || source == "" {
+		return "", fmt.Errorf("invalid token_from %q, use %s", from, tokenSources)
+	}
+	var token string
+	switch kind {
+	case "env":
+		token = os.Getenv(source)
+		if token == "" {
+			return "", fmt.Errorf("environment variable %s is not set", source)
+		}
+	case "file":
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return "", err
+		}
+		token = string(content)
+	case "command":
+		cmd := exec.Command("sh", "-c", source)
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/C", source)
+		}
+		cmd.Stderr = os.Stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("command %q failed: %w", source, err)
+		}
+		token = string(output)
+	case "keychain":
+		var err error
+		if token, err = readKeychain(source); err != nil {
+			return "", err
+		}
+	case "git-credential":
+		var err error
+		if token, err = readGitCredential(source); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("invalid token_from %q, use %s", from, tokenSources)
+	}
+	return strings.TrimSpace(token), nil
+}
+
+// readerOf returns a function reading a token again from where it was read
+func readerOf(from string) func() (string, error) {
+	return func() (string, error) { return ReadToken(from) }
+}
+
+// ReadTokenSettings reads the source and target tokens from where
+// GHMPKG_SOURCE_TOKEN_FROM and GHMPKG_TARGET_TOKEN_FROM point, like the
+// token_from of a profile. A token set directly is kept.
+func ReadTokenSettings() error {
+	for _, prefix := range []string{"GHMPKG_SOURCE_", "GHMPKG_TARGET_"} {
+		from := viper.GetString(prefix + "TOKEN_FROM")
+		if from == "" || viper.GetString(prefix+"TOKEN") != "" {
+			continue
+		}
+		token, err := ReadToken(from)
+		if err != nil {
+			return fmt.Errorf("failed to read %sTOKEN: %w", prefix, err)
+		}
+		viper.Set(prefix+"TOKEN", token)
+		credentials.SetReader(strings.TrimSuffix(strings.TrimPrefix(prefix, "GHMPKG_"), "_"), readerOf(from))
+	}
+	return nil
+}
+
+// Apply reads the config file and uses the settings of the selected
+// profile as defaults, flags, environment variables and the .env file
+// take precedence. A missing file is only an error when a profile is
+// requested. It returns the name of the applied profile.
+func Apply(path, name string) (string, error) {
+	explicit := path != ""
+	if path == "" {
+		path = DefaultFile
+	}
+	file, err := Load(path)
+	if errors.Is(err, os.ErrNotExist) && name == "" && !explicit {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	selected, profile, err := file.Select(name)
+	if err != nil || profile == nil {
+		return "", err
+	}
+	values, err := profile.Values()
+	if err != nil {
+		return "", fmt.Errorf("profile %s: %w", selected, err)
+	}
+	for key, value := range values {
+		viper.SetDefault(key, value)
+	}
+	// Tokens of the profile are read again when they expire, unless a token
+	// set otherwise is used
+	for side, from := range map[string]string{"SOURCE": profile.Source.TokenFrom, "TARGET": profile.Target.TokenFrom} {
+		if from != "" && viper.GetString("GHMPKG_"+side+"_TOKEN") == values["GHMPKG_"+side+"_TOKEN"] {
+			credentials.SetReader(side, readerOf(from))
+		}
+	}
+	resolved, err := profile.ResolveCredentials()
+	if err != nil {
+		return "", fmt.Errorf("profile %s: %w", selected, err)
+	}
+	if len(resolved) > 0 {
+		credentials.Set(resolved)
+	}
+	return selected, nil
+}
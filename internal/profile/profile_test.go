@@ -0,0 +1,80 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestValues(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(tokenFile, []byte("ghp_target\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PROFILE_TEST_SOURCE_TOKEN", "ghp_source")
+	config := filepath.Join(dir, DefaultFile)
+	if err := os.WriteFile(config, []byte(`
+default_profile: prod
+profiles:
+  prod:
+    source:
+      organization: mark-humane
+      token_from: env:PROFILE_TEST_SOURCE_TOKEN
+    target:
+      hostname: https://ghes.example.com
+      organization: mona-emu
+      token_from: file:`+tokenFile+`
+      registry_urls:
+        container: https://containers.ghes.example.com
+    package_type: npm
+    concurrency: 10
+    settings:
+      ghmpkg_retag: "(.*)=legacy-${1}"
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := Load(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, profile, err := file.Select("")
+	if err != nil || name != "prod" {
+		t.Fatalf("Select returned %q, %v, expected the default profile", name, err)
+	}
+	values, err := profile.Values()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"GHMPKG_SOURCE_ORGANIZATION":           "mark-humane",
+		"GHMPKG_SOURCE_TOKEN":                  "ghp_source",
+		"GHMPKG_TARGET_HOSTNAME":               "https://ghes.example.com",
+		"GHMPKG_TARGET_ORGANIZATION":           "mona-emu",
+		"GHMPKG_TARGET_TOKEN":                  "ghp_target",
+		"GHMPKG_TARGET_CONTAINER_REGISTRY_URL": "https://containers.ghes.example.com",
+		"GHMPKG_PACKAGE_TYPE":                  "npm",
+		"GHMPKG_PACKAGE_TYPES":                 "npm",
+		"GHMPKG_CONCURRENCY":                   "10",
+		"GHMPKG_RETAG":                         "(.*)=legacy-${1}",
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Values() = %v, expected %v", values, expected)
+	}
+
+	if _, _, err := file.Select("staging"); err == nil {
+		t.Error("Select of an unknown profile did not return an error")
+	}
+}
+
+func TestParseGitCredential(t *testing.T) {
+	output := []byte("protocol=https\nhost=github.com\nusername=mona\npassword=gho_secret\n")
+	if password, err := parseGitCredential(output, "github.com"); err != nil || password != "gho_secret" {
+		t.Errorf("parseGitCredential() = %q, %v, expected the password", password, err)
+	}
+	if _, err := parseGitCredential([]byte("protocol=https\nhost=github.com\n"), "github.com"); err == nil {
+		t.Error("parseGitCredential() without a password succeeded")
+	}
+}
@@ -0,0 +1,303 @@
+package progress
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"golang.org/x/term"
+)
+
+const (
+	defaultInterval = 30 * time.Second
+	// defaultProjectionInterval is how often the projected completion is
+	// logged
+	defaultProjectionInterval = 5 * time.Minute
+	// rollingWindow is how far back the rolling transfer rate looks, so
+	// projections follow the current rate rather than the average of the run
+	rollingWindow = 5 * time.Minute
+	// sampleInterval is how often the bytes transferred are sampled for the
+	// rolling rate
+	sampleInterval = 10 * time.Second
+)
+
+// sample is the number of bytes transferred at a point in time
+type sample struct {
+	at    time.Time
+	bytes int64
+}
+
+// Tracker reports the progress of a phase. On a terminal it renders a live
+// progress bar, otherwise it prints a plain-text summary periodically so
+// that CI logs still show how far a run has come.
+type Tracker struct {
+	mu         sync.Mutex
+	phase      string
+	total      int
+	done       int
+	current    string
+	start      time.Time
+	startBytes int64
+	expected   int64
+	samples    []sample
+	bar        *pterm.ProgressbarPrinter
+	stop       chan struct{}
+	stopped    sync.WaitGroup
+}
+
+// IsTerminal reports whether stdout is an interactive terminal
+func IsTerminal() bool {
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// Start begins tracking a phase made up of total work items
+func Start(phase string, total int) *Tracker {
+	t := &Tracker{
+		phase:      phase,
+		total:      total,
+		start:      time.Now(),
+		startBytes: totalBytes(),
+		stop:       make(chan struct{}),
+	}
+	t.samples = []sample{{at: t.start}}
+
+	interval, err := time.ParseDuration(viper.GetString("GHMPKG_PROJECTION_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = defaultProjectionInterval
+	}
+	t.stopped.Add(1)
+	go t.project(interval)
+
+	if IsTerminal() && !viper.GetBool("GHMPKG_NO_PROGRESS") {
+		t.bar, _ = pterm.DefaultProgressbar.
+			WithTotal(max(total, 1)).
+			WithTitle(t.title()).
+			WithRemoveWhenDone(true).
+			Start()
+		t.stopped.Add(1)
+		go t.refresh(time.Second, func() { t.bar.UpdateTitle(t.title()) })
+	} else {
+		interval, err := time.ParseDuration(viper.GetString("GHMPKG_PROGRESS_INTERVAL"))
+		if err != nil || interval <= 0 {
+			interval = defaultInterval
+		}
+		t.stopped.Add(1)
+		go t.refresh(interval, func() { fmt.Println(t.Summary()) })
+	}
+	return t
+}
+
+func (t *Tracker) refresh(interval time.Duration, fn func()) {
+	defer t.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			fn()
+		}
+	}
+}
+
+// project samples the bytes transferred for the rolling rate and logs the
+// projected completion every interval
+func (t *Tracker) project(interval time.Duration) {
+	defer t.stopped.Done()
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+	logged := time.Now()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case now := <-ticker.C:
+			t.sample(now, totalBytes()-t.startBytes)
+			if now.Sub(logged) >= interval {
+				t.logProjection(now)
+				logged = now
+			}
+		}
+	}
+}
+
+// sample records the bytes transferred and drops the samples the rolling
+// window no longer needs, the oldest kept sample is at or before the start
+// of the window
+func (t *Tracker) sample(now time.Time, transferred int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.samples = append(t.samples, sample{at: now, bytes: transferred})
+	for len(t.samples) > 2 && !t.samples[1].at.After(now.Add(-rollingWindow)) {
+		t.samples = t.samples[1:]
+	}
+}
+
+// rollingRate returns the bytes per second between the oldest and newest
+// samples
+func rollingRate(samples []sample) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	elapsed := last.at.Sub(first.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(last.bytes-first.bytes) / elapsed
+}
+
+// RollingRate returns the transfer rate of the last few minutes in bytes per
+// second, the average rate until the first samples are taken
+func (t *Tracker) RollingRate() float64 {
+	t.mu.Lock()
+	rate := rollingRate(t.samples)
+	t.mu.Unlock()
+	if rate <= 0 {
+		return t.Rate()
+	}
+	return rate
+}
+
+// Remaining returns the bytes left to transfer, false when the expected
+// bytes are unknown
+func (t *Tracker) Remaining() (int64, bool) {
+	t.mu.Lock()
+	expected := t.expected
+	t.mu.Unlock()
+	if expected <= 0 {
+		return 0, false
+	}
+	return max(expected-(totalBytes()-t.startBytes), 0), true
+}
+
+// logProjection logs the bytes left and when the phase is projected to
+// complete at the rolling rate
+func (t *Tracker) logProjection(now time.Time) {
+	fields := []zap.Field{zap.String("phase", t.phase), zap.String("rate", utils.FormatBytes(int64(t.RollingRate()))+"/s")}
+	if remaining, ok := t.Remaining(); ok {
+		fields = append(fields, zap.String("remaining", utils.FormatBytes(remaining)))
+	}
+	if eta := t.ETA(); eta > 0 {
+		fields = append(fields, zap.Duration("eta", eta), zap.Time("completesAt", now.Add(eta)))
+	}
+	zap.L().Info("Projected completion", fields...)
+}
+
+// SetCurrent records the work item currently being processed
+func (t *Tracker) SetCurrent(packageType, packageName, version string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.current = fmt.Sprintf("%s/%s@%s", packageType, packageName, version)
+}
+
+// SetExpectedBytes sets how many bytes the phase is expected to transfer,
+// zero when unknown
+func (t *Tracker) SetExpectedBytes(expected int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expected = expected
+}
+
+// Increment marks one work item as finished
+func (t *Tracker) Increment() {
+	t.mu.Lock()
+	t.done++
+	t.mu.Unlock()
+	if t.bar != nil {
+		t.bar.UpdateTitle(t.title())
+		t.bar.Increment()
+	}
+}
+
+// Stop ends tracking and prints a final summary
+func (t *Tracker) Stop() {
+	close(t.stop)
+	t.stopped.Wait()
+	if t.bar != nil {
+		t.bar.Stop()
+	}
+	pterm.Info.Println(t.Summary())
+}
+
+// Rate returns the average transfer rate in bytes per second
+func (t *Tracker) Rate() float64 {
+	elapsed := time.Since(t.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(totalBytes()-t.startBytes) / elapsed
+}
+
+// ETA estimates the remaining time from the bytes left at the rolling rate,
+// or from the average time per work item when the bytes are unknown
+func (t *Tracker) ETA() time.Duration {
+	t.mu.Lock()
+	done, total := t.done, t.total
+	t.mu.Unlock()
+	if done >= total {
+		return 0
+	}
+	if remaining, ok := t.Remaining(); ok {
+		if rate := t.RollingRate(); rate > 0 {
+			return time.Duration(float64(remaining) / rate * float64(time.Second)).Round(time.Second)
+		}
+	}
+	if done == 0 {
+		return 0
+	}
+	perItem := time.Since(t.start) / time.Duration(done)
+	return (perItem * time.Duration(total-done)).Round(time.Second)
+}
+
+// Summary renders the current state as a single line of plain text
+func (t *Tracker) Summary() string {
+	t.mu.Lock()
+	done, total, current, expected := t.done, t.total, t.current, t.expected
+	t.mu.Unlock()
+
+	percent := 0
+	if total > 0 {
+		percent = done * 100 / total
+	}
+	transferred := utils.FormatBytes(totalBytes() - t.startBytes)
+	if expected > 0 {
+		transferred += " of " + utils.FormatBytes(expected)
+	}
+	line := fmt.Sprintf("%s: %d/%d versions (%d%%), %s transferred at %s/s, elapsed %s",
+		t.phase, done, total, percent,
+		transferred,
+		utils.FormatBytes(int64(t.RollingRate())),
+		time.Since(t.start).Round(time.Second))
+	if eta := t.ETA(); eta > 0 {
+		line += fmt.Sprintf(", ETA %s (%s)", eta, time.Now().Add(eta).Format("15:04"))
+	}
+	if current != "" && done < total {
+		line += fmt.Sprintf(", current %s", current)
+	}
+	return line
+}
+
+func (t *Tracker) title() string {
+	t.mu.Lock()
+	current := t.current
+	t.mu.Unlock()
+	title := fmt.Sprintf("%s %s/s", t.phase, utils.FormatBytes(int64(t.RollingRate())))
+	if eta := t.ETA(); eta > 0 {
+		title += fmt.Sprintf(" ETA %s", eta)
+	}
+	if current != "" {
+		title += " " + current
+	}
+	return title
+}
+
+func totalBytes() int64 {
+	return utils.BytesTransferred(utils.Download) + utils.BytesTransferred(utils.Upload)
+}
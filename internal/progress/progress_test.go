@@ -0,0 +1,26 @@
+package progress
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRollingRate(t *testing.T) {
+	start := time.Now()
+	tracker := &Tracker{samples: []sample{{at: start}}}
+	// A slow start is left out of the rolling rate once it leaves the window
+	tracker.sample(start.Add(time.Minute), 60)
+	for i := 2; i <= 10; i++ {
+		tracker.sample(start.Add(time.Duration(i)*time.Minute), 60+int64(i-1)*6000)
+	}
+
+	if first := tracker.samples[0].at; first != start.Add(5*time.Minute) {
+		t.Errorf("expected the window to start 5 minutes back, got %s", first.Sub(start))
+	}
+	if rate := rollingRate(tracker.samples); rate != 100 {
+		t.Errorf("expected 100 bytes/s, got %v", rate)
+	}
+	if rate := rollingRate(tracker.samples[:1]); rate != 0 {
+		t.Errorf("expected no rate from a single sample, got %v", rate)
+	}
+}
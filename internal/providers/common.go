@@ -9,8 +9,14 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/google/go-github/v62/github"
+	"github.com/mark-humane/gh-migrate-packages/internal/cache"
+	"github.com/mark-humane/gh-migrate-packages/internal/credentials"
+	"github.com/mark-humane/gh-migrate-packages/internal/httpdebug"
+	"github.com/mark-humane/gh-migrate-packages/internal/storage"
+	"github.com/mark-humane/gh-migrate-packages/internal/tracing"
 	"github.com/mark-humane/gh-migrate-packages/internal/utils"
 	"github.com/shurcooL/githubv4"
 	"github.com/spf13/viper"
@@ -20,38 +26,70 @@ import (
 
 type DownloadCallback func(string, string) error
 
-var providerLookup = map[string]func(*zap.Logger, string) Provider{
-	"container": NewContainerProvider,
-	"maven":     NewMavenProvider,
-	"npm":       NewNPMProvider,
-	"rubygems":  NewRubyGemsProvider,
-	"nuget":     NewNugetProvider,
+// Factory creates the provider for a package type
+type Factory func(*zap.Logger, string) Provider
+
+var (
+	providerMu     sync.RWMutex
+	providerLookup = map[string]Factory{
+		"container": NewContainerProvider,
+		"maven":     NewMavenProvider,
+		"npm":       NewNPMProvider,
+		"rubygems":  NewRubyGemsProvider,
+		"nuget":     NewNugetProvider,
+	}
+)
+
+// Register sets the provider factory for a package type, replacing the
+// built-in provider when one exists
+func Register(packageType string, factory Factory) {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	providerLookup[packageType] = factory
 }
 
 func NewProvider(logger *zap.Logger, packageType string) (Provider, error) {
-	if providerFunc, ok := providerLookup[packageType]; !ok {
+	providerMu.RLock()
+	providerFunc, ok := providerLookup[packageType]
+	providerMu.RUnlock()
+	if !ok {
 		return nil, errors.New(fmt.Sprintf("provider not found: %s", packageType))
-	} else {
-		return providerFunc(logger, packageType), nil
 	}
+	return providerFunc(logger, packageType), nil
 }
 
+// newHTTPClient returns a client of the connection pool of a proxy, shared
+// by the clients of every GraphQL call
 func newHTTPClient(proxyURL string) (*http.Client, error) {
-	transport := &http.Transport{}
+	var proxy func(*http.Request) (*url.URL, error)
 	if proxyURL != "" {
-		proxy, err := url.Parse(proxyURL)
+		parsed, err := url.Parse(proxyURL)
 		if err != nil {
 			return nil, err
 		}
-		transport.Proxy = http.ProxyURL(proxy)
+		proxy = http.ProxyURL(parsed)
+	}
+	transport := utils.SharedTransport("graphql "+proxyURL, proxy)
+	roundTripper := credentials.RefreshTransport(transport)
+	if tracing.Enabled() {
+		roundTripper = tracing.Transport(roundTripper)
+	}
+	if httpdebug.Enabled() {
+		roundTripper = httpdebug.Transport(roundTripper)
 	}
-	return &http.Client{Transport: transport}, nil
+	return &http.Client{Transport: roundTripper, Timeout: utils.HTTPTimeout()}, nil
 }
 
-func FetchFromGraphQL(logger *zap.Logger, owner, token, packageType string) ([]PackageNode, ResultState, error) {
-	logger.Info("Loading package files from GitHub GraphQL API")
-	var allPackages []PackageNode
-	packagesAfter := (*githubv4.String)(nil)
+// newGraphQLClient creates a GitHub GraphQL client that waits out primary
+// rate limits
+func newGraphQLClient(token string) (*githubv4.Client, context.Context, error) {
+	return newGraphQLClientWithHostname(token, "")
+}
+
+// newGraphQLClientWithHostname creates a GraphQL client like
+// newGraphQLClient for the GitHub Enterprise Server at hostname, GitHub.com
+// without one
+func newGraphQLClientWithHostname(token, hostname string) (*githubv4.Client, context.Context, error) {
 	tokenSource := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: token},
 	)
@@ -61,12 +99,26 @@ func FetchFromGraphQL(logger *zap.Logger, owner, token, packageType string) ([]P
 	}
 	httpClient, err := newHTTPClient(viper.GetString("HTTPS_PROXY"))
 	if err != nil {
-		return nil, Failed, err
+		return nil, nil, err
 	}
 	oauth2Ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
 	oauth2Client := oauth2.NewClient(oauth2Ctx, tokenSource)
 	client := githubv4.NewClient(oauth2Client)
+	if hostname != "" {
+		client = githubv4.NewEnterpriseClient(graphQLURL(hostname), oauth2Client)
+	}
 	ctx := context.WithValue(context.Background(), github.SleepUntilPrimaryRateLimitResetWhenRateLimited, true)
+	return client, ctx, nil
+}
+
+func FetchFromGraphQL(logger *zap.Logger, owner, token, packageType string) ([]PackageNode, ResultState, error) {
+	logger.Info("Loading package files from GitHub GraphQL API")
+	var allPackages []PackageNode
+	packagesAfter := (*githubv4.String)(nil)
+	client, ctx, err := newGraphQLClient(token)
+	if err != nil {
+		return nil, Failed, err
+	}
 
 	for {
 		var query Query
@@ -161,6 +213,154 @@ func FetchFromGraphQL(logger *zap.Logger, owner, token, packageType string) ([]P
 	return allPackages, Success, nil
 }
 
+// FetchVersionFiles lists every file of a single package version with the
+// package version files API, including classifier artifacts like
+// -sources.jar and -javadoc.jar
+func FetchVersionFiles(logger *zap.Logger, owner, token, packageType, packageName, version string) ([]string, error) {
+	client, ctx, err := newGraphQLClient(token)
+	if err != nil {
+		return nil, err
+	}
+	var filenames []string
+	filesAfter := (*githubv4.String)(nil)
+	for {
+		var query VersionFilesQuery
+		variables := map[string]interface{}{
+			"owner":       githubv4.String(owner),
+			"packageType": githubv4.PackageType(strings.ToUpper(packageType)),
+			"packageName": githubv4.String(packageName),
+			"version":     githubv4.String(version),
+			"filesFirst":  githubv4.Int(100),
+			"filesAfter":  filesAfter,
+		}
+		if err := client.Query(ctx, &query, variables); err != nil {
+			return nil, fmt.Errorf("error querying files of %s %s: %w", packageName, version, err)
+		}
+		if len(query.Organization.Packages.Nodes) == 0 {
+			return nil, nil
+		}
+		files := query.Organization.Packages.Nodes[0].Version.Files
+		for _, file := range files.Nodes {
+			filenames = append(filenames, string(file.Name))
+		}
+		if !files.PageInfo.HasNextPage {
+			break
+		}
+		filesAfter = &files.PageInfo.EndCursor
+	}
+	logger.Debug("Loaded version files", zap.String("package_name", packageName), zap.String("package_version", version), zap.Int("files", len(filenames)))
+	return filenames, nil
+}
+
+// graphQLURL returns the GraphQL endpoint of a GitHub Enterprise Server,
+// hostnames may be given with the /api/v3 path of the REST API
+func graphQLURL(hostname string) string {
+	hostname = strings.TrimSuffix(strings.TrimSuffix(hostname, "/"), "/api/v3")
+	return hostname + "/api/graphql"
+}
+
+// PackageFile is a file of a package version as GitHub Packages lists it,
+// Size is -1 when GitHub doesn't report it
+type PackageFile struct {
+	Name string
+	Size int64
+}
+
+// FetchVersionFileSizes lists the files of a package version with their
+// sizes, on the GitHub Enterprise Server at hostname or GitHub.com without
+// one. It returns no files for versions that don't exist.
+func FetchVersionFileSizes(logger *zap.Logger, hostname, owner, token, packageType, packageName, version string) ([]PackageFile, error) {
+	client, ctx, err := newGraphQLClientWithHostname(token, hostname)
+	if err != nil {
+		return nil, err
+	}
+	var files []PackageFile
+	filesAfter := (*githubv4.String)(nil)
+	for {
+		var query VersionFileSizesQuery
+		variables := map[string]interface{}{
+			"owner":       githubv4.String(owner),
+			"packageType": githubv4.PackageType(strings.ToUpper(packageType)),
+			"packageName": githubv4.String(packageName),
+			"version":     githubv4.String(version),
+			"filesFirst":  githubv4.Int(100),
+			"filesAfter":  filesAfter,
+		}
+		if err := client.Query(ctx, &query, variables); err != nil {
+			return nil, fmt.Errorf("error querying files of %s %s: %w", packageName, version, err)
+		}
+		if len(query.Organization.Packages.Nodes) == 0 {
+			return nil, nil
+		}
+		page := query.Organization.Packages.Nodes[0].Version.Files
+		for _, file := range page.Nodes {
+			size := int64(-1)
+			if file.Size != nil {
+				size = *file.Size
+			}
+			files = append(files, PackageFile{Name: string(file.Name), Size: size})
+		}
+		if !page.PageInfo.HasNextPage {
+			break
+		}
+		filesAfter = &page.PageInfo.EndCursor
+	}
+	logger.Debug("Loaded version file sizes", zap.String("package_name", packageName), zap.String("package_version", version), zap.Int("files", len(files)))
+	return files, nil
+}
+
+// versionFiles holds the files of every package version of an organization,
+// loaded once with the GraphQL API
+type versionFiles struct {
+	mu       sync.Mutex
+	owner    string
+	packages []PackageNode
+}
+
+// VersionFiles lists the files a package version has on GitHub Packages, so
+// odd filenames and extra assets are exported instead of the names the
+// registry conventions suggest. The files of all packages of the type are
+// loaded on the first call, versions published since are listed on their
+// own. It returns no files for versions without any.
+func (p *BaseProvider) VersionFiles(logger *zap.Logger, owner, packageName, version string) ([]string, error) {
+	token := viper.GetString("GHMPKG_SOURCE_TOKEN")
+	if p.files == nil {
+		return FetchVersionFiles(logger, owner, token, p.PackageType, packageName, version)
+	}
+
+	p.files.mu.Lock()
+	if p.files.packages == nil || p.files.owner != owner {
+		packages, _, err := FetchFromGraphQL(logger, owner, token, p.PackageType)
+		if err != nil {
+			p.files.mu.Unlock()
+			return nil, err
+		}
+		p.files.owner, p.files.packages = owner, packages
+	}
+	var filenames []string
+	found := false
+	for _, pkg := range p.files.packages {
+		if string(pkg.Name) != packageName {
+			continue
+		}
+		for _, cachedVersion := range pkg.Versions.Nodes {
+			if string(cachedVersion.Version) != version {
+				continue
+			}
+			found = true
+			for _, file := range cachedVersion.Files.Nodes {
+				filenames = append(filenames, string(file.Name))
+			}
+		}
+	}
+	p.files.mu.Unlock()
+
+	if !found || len(filenames) == 0 {
+		return FetchVersionFiles(logger, owner, token, p.PackageType, packageName, version)
+	}
+	return filenames, nil
+}
+
 func (p *BaseProvider) downloadPackage(
 	logger *zap.Logger,
 	owner, repository, packageType, packageName, version, filename string,
@@ -173,15 +373,26 @@ func (p *BaseProvider) downloadPackage(
 	}
 	outputPath := filepath.Join("migration-packages", "packages", owner, packageType, packageName, version, *downloadedFilename)
 
-	if utils.FileExists(outputPath) {
+	exists, err := storage.Exists(context.Background(), logger, outputPath)
+	if err != nil {
+		logger.Error("Failed to check staged file",
+			zap.String("outputPath", outputPath),
+			zap.Error(err))
+		return Failed, err
+	}
+	if exists {
 		logger.Warn("File already exists", zap.String("outputPath", outputPath))
 		return Skipped, nil
 	}
 
 	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
 		logger.Error("Failed to create directories",
-			zap.String("package", packageName),
-			zap.String("version", version),
+			zap.Error(err))
+		return Failed, err
+	}
+
+	if err := utils.CheckFreeSpace(filepath.Dir(outputPath), 0); err != nil {
+		logger.Error("Not enough disk space to download",
 			zap.Error(err))
 		return Failed, err
 	}
@@ -189,18 +400,24 @@ func (p *BaseProvider) downloadPackage(
 	downloadUrl, err := getUrl()
 	if err != nil {
 		logger.Error("Error getting download URL",
-			zap.String("package", packageName),
-			zap.String("version", version),
 			zap.Error(err))
 		return Failed, err
 	}
 
+	contentCache := downloadCache(packageType)
+	if contentCache != nil {
+		if sum, ok := contentCache.Lookup(downloadUrl); ok {
+			if err := contentCache.LinkTo(sum, outputPath); err == nil {
+				logger.Info("Using cached file", zap.String("url", downloadUrl), zap.String("sha256", sum))
+				return Success, storage.Stage(context.Background(), logger, outputPath)
+			}
+		}
+	}
+
 	logger.Info("Downloading file", zap.String("url", downloadUrl))
 	result, err := download(downloadUrl, outputPath)
 	if err != nil {
 		logger.Error("Error downloading file",
-			zap.String("package", packageName),
-			zap.String("version", version),
 			zap.Error(err))
 		return Failed, err
 	}
@@ -209,10 +426,35 @@ func (p *BaseProvider) downloadPackage(
 		logger.Info("File already exists", zap.String("outputPath", outputPath))
 	} else {
 		logger.Info("Successfully downloaded file", zap.String("outputPath", outputPath))
+		if contentCache != nil {
+			if sum, err := contentCache.Store(downloadUrl, outputPath); err != nil {
+				logger.Warn("Failed to cache file", zap.String("outputPath", outputPath), zap.Error(err))
+			} else {
+				logger.Info("Cached file", zap.String("outputPath", outputPath), zap.String("sha256", sum))
+			}
+		}
+		if err := storage.Stage(context.Background(), logger, outputPath); err != nil {
+			logger.Error("Error staging file",
+				zap.Error(err))
+			return Failed, err
+		}
 	}
 	return result, nil
 }
 
+// downloadCache returns the content-addressable download cache, or nil when
+// caching is disabled. Container images are addressed by mutable tags and
+// remote storage backends should not keep local copies, so neither is cached.
+func downloadCache(packageType string) *cache.Cache {
+	if packageType == "container" || viper.GetBool("GHMPKG_NO_CACHE") {
+		return nil
+	}
+	if backend := viper.GetString("GHMPKG_STORAGE_BACKEND"); backend != "" && backend != "local" {
+		return nil
+	}
+	return cache.New(cache.DefaultRoot)
+}
+
 func (p *BaseProvider) uploadPackage(
 	logger *zap.Logger,
 	owner, repository, packageType, packageName, version, filename string,
@@ -223,7 +465,8 @@ func (p *BaseProvider) uploadPackage(
 	if packageType == "container" {
 		parts := strings.Split(filename, ":")
 		tag := parts[1]
-		packageDir = filepath.Join("migration-packages", "packages", viper.GetString("GHMPKG_SOURCE_ORGANIZATION"), packageType, packageName, tag)
+		// Images are pulled under the lowercase owner
+		packageDir = filepath.Join("migration-packages", "packages", strings.ToLower(viper.GetString("GHMPKG_SOURCE_ORGANIZATION")), packageType, packageName, tag)
 	} else {
 		packageDir = filepath.Join("migration-packages", "packages", viper.GetString("GHMPKG_SOURCE_ORGANIZATION"), packageType, packageName, version)
 	}
@@ -232,6 +475,8 @@ func (p *BaseProvider) uploadPackage(
 		logger.Warn("Package directory does not exist", zap.String("packageDir", packageDir))
 		return Skipped, nil
 	}
+	// Scratch files are only needed while the file is published
+	defer storage.Clean(logger, storage.WorkDir(packageDir))
 
 	uploadUrl, err := getUrl()
 	if err != nil {
@@ -289,10 +534,11 @@ func NewBaseProvider(packageType, sourceHostname, targetHostname string, isConta
 
 	return BaseProvider{
 		PackageType:       packageType,
-		SourceRegistryUrl: utils.ParseUrl(sourceRegistryUrl),
-		TargetRegistryUrl: utils.ParseUrl(targetRegistryUrl),
+		SourceRegistryUrl: registryUrl("source", packageType, sourceRegistryUrl),
+		TargetRegistryUrl: registryUrl("target", packageType, targetRegistryUrl),
 		SourceHostnameUrl: utils.ParseUrl(fmt.Sprintf("https://%s/", sourceHost)),
 		TargetHostnameUrl: utils.ParseUrl(fmt.Sprintf("https://%s/", targetHost)),
+		files:             &versionFiles{},
 	}
 }
 
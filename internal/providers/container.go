@@ -1,88 +1,107 @@
 package providers
 
 import (
-	"context"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
 
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/image"
-	"github.com/docker/docker/api/types/registry"
-	"github.com/docker/docker/client"
 	"github.com/google/go-github/v62/github"
+	"github.com/mark-humane/gh-migrate-packages/internal/oci"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
 // Package providers implements different package type handlers for container registries.
 
-// ContainerProvider handles container image operations between Docker registries.
-// It supports pulling images from a source registry and pushing them to a target registry,
-// while managing authentication and image metadata.
+// ContainerProvider copies container images between registries with the
+// registry API. Images are copied intact, manifest lists and OCI indexes
+// keep every platform image and the digest of each tag is preserved.
 type ContainerProvider struct {
 	BaseProvider
-	ctx           context.Context
-	client        *client.Client
-	sourceAuthStr string
-	targetAuthStr string
-	recreatedShas map[string]string
+	source *oci.Client
+	target *oci.Client
 }
 
-// Constructor
-// ----------
+// Policies for untagged container versions, GHMPKG_UNTAGGED
+const (
+	UntaggedSkip       = "skip"
+	UntaggedInclude    = "include"
+	UntaggedReferenced = "referenced"
+)
 
-// NewContainerProvider creates a new ContainerProvider instance.
-func NewContainerProvider(logger *zap.Logger, packageType string) Provider {
-	return &ContainerProvider{
-		BaseProvider:  NewBaseProvider(packageType, "", "", true),
-		recreatedShas: make(map[string]string),
+// UntaggedPolicy returns whether untagged container versions are exported,
+// skipped by default
+func UntaggedPolicy() (string, error) {
+	switch policy := strings.ToLower(viper.GetString("GHMPKG_UNTAGGED")); policy {
+	case "":
+		return UntaggedSkip, nil
+	case UntaggedSkip, UntaggedInclude, UntaggedReferenced:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid untagged policy %q, expected skip, include or referenced", policy)
 	}
 }
 
-// Authentication
-// -------------
+// digestTag is the tag part of the file of an untagged version, @ followed
+// by its digest with a dash, e.g. @sha256-0a1b. Tags can't contain @, and
+// the file can still be used as a path.
+func digestTag(digest string) string {
+	return "@" + strings.Replace(digest, ":", "-", 1)
+}
 
-// encodeAuthToBase64 converts Docker registry authentication config to base64 encoded string.
-func encodeAuthToBase64(auth registry.AuthConfig) (string, error) {
-	authBytes, err := json.Marshal(auth)
-	if err != nil {
-		return "", err
+// ImageReference returns the reference the image of an exported file is
+// pulled and pushed with, its tag or the digest of an untagged version
+func ImageReference(filename string) (string, bool) {
+	tag := filename[strings.LastIndex(filename, ":")+1:]
+	if digest, ok := strings.CutPrefix(tag, "@"); ok {
+		return strings.Replace(digest, "-", ":", 1), true
 	}
-	return base64.URLEncoding.EncodeToString(authBytes), nil
+	return tag, false
 }
 
-// login authenticates with a Docker registry and returns the encoded auth string.
-func (p *ContainerProvider) login(logger *zap.Logger, addr, username, password string) (string, error) {
-	auth := registry.AuthConfig{
-		Username:      username,
-		Password:      password,
-		ServerAddress: addr,
-	}
-	authStr, err := encodeAuthToBase64(auth)
-	if err != nil {
-		return "", err
+// ReferencedDigests returns the digests of the images listed by the indexes
+// of the exported tags of a package, e.g. the platform images of multi-arch
+// images, which are untagged versions of their own
+func ReferencedDigests(owner, packageName string, filenames []string) (map[string]bool, error) {
+	base := NewBaseProvider("container", "", "", true)
+	client := oci.NewClient(base.SourceRegistryUrl.String(), owner, viper.GetString("GHMPKG_SOURCE_TOKEN"))
+	repository := client.Repository(path.Join(strings.ToLower(owner), strings.ToLower(packageName)))
+	referenced := map[string]bool{}
+	for _, filename := range filenames {
+		reference, byDigest := ImageReference(filename)
+		if byDigest {
+			continue
+		}
+		digests, err := repository.IndexedDigests(reference)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the manifest of %s: %w", filename, err)
+		}
+		for _, digest := range digests {
+			referenced[digest] = true
+		}
 	}
+	return referenced, nil
+}
 
-	loginResp, err := p.client.RegistryLogin(p.ctx, auth)
-	if err != nil {
-		logger.Error("Failed to login to registry", zap.String("addr", addr), zap.Error(err))
-		return "", err
-	}
+// Constructor
+// ----------
 
-	if loginResp.Status != "Login Succeeded" {
-		logger.Error("Failed to login to registry", zap.String("addr", addr), zap.String("status", loginResp.Status))
-		return "", fmt.Errorf("failed to login to registry: %s", loginResp.Status)
+// NewContainerProvider creates a new ContainerProvider instance.
+func NewContainerProvider(logger *zap.Logger, packageType string) Provider {
+	return &ContainerProvider{
+		BaseProvider: NewBaseProvider(packageType, "", "", true),
 	}
-
-	return authStr, nil
 }
 
-// Connect initializes the Docker client and authenticates with both source and target registries.
+// Authentication
+// -------------
+
+// Connect creates the registry clients of the source and target registries.
 func (p *ContainerProvider) Connect(logger *zap.Logger) error {
 	// Add validation for required environment variables
 	sourceOrg := viper.GetString("GHMPKG_SOURCE_ORGANIZATION")
@@ -91,37 +110,21 @@ func (p *ContainerProvider) Connect(logger *zap.Logger) error {
 	if sourceOrg == "" || sourceToken == "" {
 		return fmt.Errorf("missing required environment variables: GHMPKG_SOURCE_ORGANIZATION and/or GHMPKG_SOURCE_TOKEN")
 	}
+	p.source = oci.NewClient(p.SourceRegistryUrl.String(), sourceOrg, sourceToken)
 
-	ctx := context.Background()
-
-	// Create Docker client
-	client, err := client.NewClientWithOpts(client.FromEnv)
-	if err != nil {
-		logger.Error("Failed to create docker client", zap.Error(err))
-		return err
-	}
-
-	p.ctx = ctx
-	p.client = client
-
-	if sourceOrg != "" && sourceToken != "" {
-		sourceAuthStr, err := p.login(logger, p.SourceRegistryUrl.String(), sourceOrg, sourceToken)
-		if err != nil {
-			logger.Error("Failed to login to source registry", zap.Error(err))
-			return err
-		}
-		p.sourceAuthStr = sourceAuthStr
+	// Other target registries push the pulled archives themselves
+	if registries.TargetName() != registries.GitHub {
+		return nil
 	}
-
 	targetOrg := viper.GetString("GHMPKG_TARGET_ORGANIZATION")
 	targetToken := viper.GetString("GHMPKG_TARGET_TOKEN")
 	if targetOrg != "" && targetToken != "" { //if targetOrg and token are empty, we don't need to login
-		targetAuthStr, err := p.login(logger, p.TargetRegistryUrl.String(), targetOrg, targetToken)
+		chunkSize, err := utils.ChunkSize()
 		if err != nil {
-			logger.Error("Failed to login to target registry", zap.Error(err))
 			return err
 		}
-		p.targetAuthStr = targetAuthStr
+		p.target = oci.NewClient(p.TargetRegistryUrl.String(), targetOrg, targetToken)
+		p.target.ChunkSize = chunkSize
 	}
 
 	return nil
@@ -131,9 +134,30 @@ func (p *ContainerProvider) Connect(logger *zap.Logger) error {
 // --------------
 
 // FetchPackageFiles retrieves the list of container image tags for a package.
+// Tags are filtered with GHMPKG_INCLUDE_TAGS and GHMPKG_EXCLUDE_TAGS.
+// Untagged versions have a file of their digest unless GHMPKG_UNTAGGED
+// skips them.
 func (p *ContainerProvider) FetchPackageFiles(logger *zap.Logger, owner, repository, packageType, packageName, version string, metadata *github.PackageMetadata) ([]string, ResultState, error) {
+	include, exclude, err := tagFilters()
+	if err != nil {
+		return nil, Failed, err
+	}
 	filenames := []string{}
+	if len(metadata.GetContainer().Tags) == 0 && strings.HasPrefix(version, "sha256:") {
+		policy, err := UntaggedPolicy()
+		if err != nil {
+			return nil, Failed, err
+		}
+		if policy != UntaggedSkip {
+			filenames = append(filenames, fmt.Sprintf("%s:%s", packageName, digestTag(version)))
+		}
+		return filenames, Success, nil
+	}
 	for _, tag := range metadata.Container.Tags {
+		if (include != nil && !include.MatchString(tag)) || (exclude != nil && exclude.MatchString(tag)) {
+			logger.Debug("Skipping filtered tag", zap.String("tag", tag))
+			continue
+		}
 		filenames = append(filenames, fmt.Sprintf("%s:%s", packageName, tag))
 	}
 	// Reverse the slice to upload the latest version last
@@ -144,6 +168,21 @@ func (p *ContainerProvider) FetchPackageFiles(logger *zap.Logger, owner, reposit
 	return filenames, Success, nil
 }
 
+// tagFilters compiles the tag include and exclude patterns, nil when unset
+func tagFilters() (*regexp.Regexp, *regexp.Regexp, error) {
+	var filters [2]*regexp.Regexp
+	for i, key := range []string{"GHMPKG_INCLUDE_TAGS", "GHMPKG_EXCLUDE_TAGS"} {
+		if pattern := viper.GetString(key); pattern != "" {
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid %s pattern: %w", key, err)
+			}
+			filters[i] = compiled
+		}
+	}
+	return filters[0], filters[1], nil
+}
+
 // Download pulls a container image from the source registry and saves it locally.
 func (p *ContainerProvider) Download(logger *zap.Logger, owner, repository, packageType, packageName, version, filename string) (ResultState, error) {
 	// Normalize names for container images
@@ -151,6 +190,12 @@ func (p *ContainerProvider) Download(logger *zap.Logger, owner, repository, pack
 
 	parts := strings.Split(filename, ":")
 	tag := parts[1]
+	reference, _ := ImageReference(filename)
+	referrers := viper.GetBool("GHMPKG_INCLUDE_REFERRERS")
+	if referrers && oci.IsArtifactTag(tag) {
+		logger.Info("Skipping artifact tag, it is copied with its image", zap.String("tag", tag))
+		return Skipped, nil
+	}
 	downloadedFilename := fmt.Sprintf("%s-%s.tar", packageName, tag)
 
 	return p.downloadPackage(
@@ -161,138 +206,41 @@ func (p *ContainerProvider) Download(logger *zap.Logger, owner, repository, pack
 		},
 		// Download function
 		func(downloadUrl, outputPath string) (ResultState, error) {
-			pullResp, err := p.client.ImagePull(p.ctx, downloadUrl, image.PullOptions{
-				RegistryAuth: p.sourceAuthStr,
-			})
-			if err != nil {
-				logger.Error("Failed to pull image",
-					zap.String("package", packageName),
-					zap.String("version", version),
-					zap.String("image", downloadUrl),
-					zap.Error(err))
-				return Failed, err
-			}
-			defer pullResp.Close()
+			image := p.source.Repository(path.Join(owner, packageName))
 
-			// Must read the response to complete the pull
-			if _, err = io.Copy(io.Discard, pullResp); err != nil {
-				logger.Error("Failed to read pull response",
-					zap.String("image", downloadUrl),
+			// Create a partial output file, renamed once the image is complete
+			partPath := outputPath + ".part"
+			outputFile, err := os.Create(partPath)
+			if err != nil {
+				logger.Error("Failed to create output file",
+					zap.String("path", partPath),
 					zap.Error(err))
 				return Failed, err
 			}
+			defer outputFile.Close()
 
-			// Save image to file
-			saveResp, err := p.client.ImageSave(p.ctx, []string{downloadUrl})
+			descriptor, err := oci.Pull(logger, image, reference, outputFile, referrers)
 			if err != nil {
-				logger.Error("Failed to save image",
+				logger.Error("Failed to pull image",
 					zap.String("image", downloadUrl),
-					zap.String("output", outputPath),
 					zap.Error(err))
 				return Failed, err
 			}
-			defer saveResp.Close()
-
-			// Create output file
-			outputFile, err := os.Create(outputPath)
-			if err != nil {
-				logger.Error("Failed to create output file",
-					zap.String("path", outputPath),
-					zap.Error(err))
+			if err := outputFile.Close(); err != nil {
 				return Failed, err
 			}
-			defer outputFile.Close()
-
-			// Copy image to file
-			if _, err = io.Copy(outputFile, saveResp); err != nil {
-				logger.Error("Failed to write image to file",
-					zap.String("path", outputPath),
-					zap.String("image", downloadUrl),
-					zap.Error(err))
+			if err := os.Rename(partPath, outputPath); err != nil {
 				return Failed, err
 			}
+			logger.Info("Pulled image",
+				zap.String("image", downloadUrl),
+				zap.String("digest", descriptor.Digest),
+				zap.String("mediaType", descriptor.MediaType))
 			return Success, nil
 		},
 	)
 }
 
-// Rename creates a new image with updated metadata for the target registry.
-func (p *ContainerProvider) Rename(logger *zap.Logger, owner, repository, packageName, version, filename string) error {
-	// Skip if source and target organizations are the same
-	if p.CheckOrganizationsMatch(logger) {
-		return nil
-	}
-
-	// Tag image for target registry
-	sourceOrg := viper.GetString("GHMPKG_SOURCE_ORGANIZATION")
-	targetOrg := viper.GetString("GHMPKG_TARGET_ORGANIZATION")
-	sourceRef, err := p.GetDownloadUrl(logger, sourceOrg, repository, packageName, version, filename)
-	if err != nil {
-		logger.Error("Failed to get download URL", zap.Error(err))
-		return err
-	}
-	targetRef, err := p.GetUploadUrl(logger, targetOrg, repository, packageName, version, filename)
-	if err != nil {
-		logger.Error("Failed to get upload URL", zap.Error(err))
-		return err
-	}
-
-	// Get existing image details
-	inspect, _, err := p.client.ImageInspectWithRaw(p.ctx, sourceRef)
-	if err != nil {
-		return fmt.Errorf("failed to inspect image: %w", err)
-	}
-
-	// check if inspect.ID is in recreatedShas
-	if origTargetRef, ok := p.recreatedShas[inspect.ID]; ok {
-		err = p.client.ImageTag(p.ctx, origTargetRef, targetRef)
-		if err != nil {
-			logger.Error("Failed to tag image", zap.Error(err))
-			return err
-		}
-		return nil
-	}
-
-	// Create new labels map, copying existing labels
-	newLabels := make(map[string]string)
-	for k, v := range inspect.Config.Labels {
-		newLabels[k] = v
-	}
-
-	// Update the specific label
-	newLabels["org.opencontainers.image.source"] = strings.Replace(
-		newLabels["org.opencontainers.image.source"],
-		sourceOrg,
-		targetOrg,
-		1,
-	)
-
-	// Create a container with the new labels
-	resp, err := p.client.ContainerCreate(p.ctx, &container.Config{
-		Image:  sourceRef,
-		Labels: newLabels,
-	}, nil, nil, nil, "")
-	if err != nil {
-		return fmt.Errorf("failed to create container: %w", err)
-	}
-	defer p.client.ContainerRemove(p.ctx, resp.ID, container.RemoveOptions{})
-
-	// Commit the container as a new image
-	_, err = p.client.ContainerCommit(p.ctx, resp.ID, container.CommitOptions{
-		Reference: targetRef,
-		Config: &container.Config{
-			Labels: newLabels,
-		},
-	})
-	if err != nil {
-		return fmt.Errorf("failed to commit container: %w", err)
-	}
-
-	p.recreatedShas[inspect.ID] = targetRef
-
-	return nil
-}
-
 // Upload pushes a container image to the target registry.
 func (p *ContainerProvider) Upload(logger *zap.Logger, owner, repository, packageType, packageName, version, filename string) (ResultState, error) {
 	// Normalize names for container images
@@ -304,37 +252,84 @@ func (p *ContainerProvider) Upload(logger *zap.Logger, owner, repository, packag
 			return p.GetUploadUrl(logger, owner, repository, packageName, version, filename)
 		},
 		func(uploadUrl, packageDir string) (ResultState, error) {
-			if err := p.Rename(logger, owner, repository, packageName, version, filename); err != nil {
-
-				logger.Error("Failed to rename image", zap.Error(err))
-				return Failed, err
+			if p.target == nil {
+				return Failed, fmt.Errorf("not connected to the target registry")
 			}
-			targetRef, err := p.GetUploadUrl(logger, viper.GetString("GHMPKG_TARGET_ORGANIZATION"), repository, packageName, version, filename)
+			archivePath, err := p.Prepare(logger, packageDir, packageName, version, filename)
 			if err != nil {
-				logger.Error("Failed to get upload URL", zap.Error(err))
 				return Failed, err
 			}
-			// Push image to target registry
-			pushResp, err := p.client.ImagePush(p.ctx, targetRef, image.PushOptions{
-				RegistryAuth: p.targetAuthStr,
-			})
+			archive, err := oci.OpenArchive(archivePath)
 			if err != nil {
-				logger.Error("Failed to push image", zap.Error(err))
+				logger.Error("Failed to open image archive", zap.String("archive", archivePath), zap.Error(err))
 				return Failed, err
 			}
-			defer pushResp.Close()
-
-			// Must read the response to complete the push
-			_, err = io.Copy(io.Discard, pushResp)
+			defer archive.Close()
+
+			// Images keep their digests unless their source labels are
+			// rewritten, which invalidates their signatures. Untagged
+			// versions are pushed by their digest, which must not change.
+			tag, byDigest := ImageReference(filename)
+			if viper.GetBool("GHMPKG_REWRITE_IMAGE_SOURCE") && !byDigest {
+				rewritten, err := archive.RewriteLabels(imageSourceRewriter(
+					hostnameOf("GHMPKG_SOURCE_HOSTNAME"), viper.GetString("GHMPKG_SOURCE_ORGANIZATION"),
+					hostnameOf("GHMPKG_TARGET_HOSTNAME"), viper.GetString("GHMPKG_TARGET_ORGANIZATION")))
+				if err != nil {
+					logger.Error("Failed to rewrite image labels", zap.String("archive", archivePath), zap.Error(err))
+					return Failed, err
+				}
+				if rewritten {
+					logger.Info("Rewrote image source labels", zap.String("digest", archive.Manifests[0].Digest))
+				}
+			}
+			if !byDigest {
+				rules, err := oci.ParseRetagRules(viper.GetString("GHMPKG_RETAG"))
+				if err != nil {
+					return Failed, err
+				}
+				if tag, err = rules.Apply(tag); err != nil {
+					return Failed, err
+				}
+			}
+			targetOrg := strings.ToLower(viper.GetString("GHMPKG_TARGET_ORGANIZATION"))
+			image := p.target.Repository(path.Join(targetOrg, TargetPackageName(packageType, packageName)))
+			pushed, err := oci.Push(logger, image, archive, tag)
 			if err != nil {
-				logger.Error("Failed to read push response", zap.Error(err))
+				logger.Error("Failed to push image", zap.String("image", uploadUrl), zap.Error(err))
 				return Failed, err
 			}
+			if !pushed {
+				return Skipped, nil
+			}
 			return Success, nil
 		},
 	)
 }
 
+// imageSourceRewriter rewrites image source labels pointing at repositories
+// of the source organization to the same repositories in the target
+// organization, GitHub links images to the repository of the label
+func imageSourceRewriter(sourceHost, sourceOrg, targetHost, targetOrg string) oci.RewriteFunc {
+	prefix := strings.ToLower(fmt.Sprintf("https://%s/%s/", sourceHost, sourceOrg))
+	return func(value string) (string, bool) {
+		trimmed := strings.TrimSpace(value)
+		if !strings.HasPrefix(strings.ToLower(trimmed), prefix) {
+			return value, false
+		}
+		return fmt.Sprintf("https://%s/%s/", targetHost, targetOrg) + trimmed[len(prefix):], true
+	}
+}
+
+// Prepare returns the image archive written by pull, an OCI image layout
+func (p *ContainerProvider) Prepare(logger *zap.Logger, packageDir, packageName, version, filename string) (string, error) {
+	_, _, packageName = p.normalizeNames("", "", packageName)
+	parts := strings.Split(filename, ":")
+	if len(parts) < 2 {
+		return "", fmt.Errorf("invalid image reference %s", filename)
+	}
+	return filepath.Join(packageDir, fmt.Sprintf("%s-%s.tar", packageName, parts[1])), nil
+}
+
 // URL Generation
 // -------------
 
@@ -350,6 +345,10 @@ func (p *ContainerProvider) GetDownloadUrl(logger *zap.Logger, owner, repository
 	// Normalize names for container images
 	owner, repository, packageName = p.normalizeNames(owner, repository, packageName)
 
+	// Untagged versions are referenced by digest
+	if reference, byDigest := ImageReference(filename); byDigest {
+		filename = packageName + "@" + reference
+	}
 	downloadUrl := *p.SourceRegistryUrl
 	downloadUrl.Path = path.Join(downloadUrl.Path, owner, filename)
 	return downloadUrl.String(), nil
@@ -360,6 +359,10 @@ func (p *ContainerProvider) GetUploadUrl(logger *zap.Logger, owner, repository,
 	// Normalize names for container images
 	owner, repository, packageName = p.normalizeNames(owner, repository, packageName)
 
+	// Untagged versions are referenced by digest
+	if reference, byDigest := ImageReference(filename); byDigest {
+		filename = packageName + "@" + reference
+	}
 	uploadUrl := *p.TargetRegistryUrl
 	uploadUrl.Path = path.Join(uploadUrl.Path, owner, filename)
 	return uploadUrl.String(), nil
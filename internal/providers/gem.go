@@ -1,14 +1,23 @@
 package providers
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/google/go-github/v62/github"
+	"github.com/mark-humane/gh-migrate-packages/internal/runlog"
 	"github.com/mark-humane/gh-migrate-packages/internal/utils"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
@@ -32,10 +41,22 @@ func (p *RubyGemsProvider) Connect(logger *zap.Logger) error {
 	return nil
 }
 
-// FetchPackageFiles returns the expected filenames for a given package version
+// FetchPackageFiles returns the gems of a version, platform gems like
+// name-1.0-x86_64-linux.gem are published next to the plain gem
 func (p *RubyGemsProvider) FetchPackageFiles(logger *zap.Logger, owner, repository, packageType, packageName, version string, metadata *github.PackageMetadata) ([]string, ResultState, error) {
-	filenames := []string{
-		fmt.Sprintf("%s-%s.gem", packageName, version),
+	files, err := p.VersionFiles(logger, owner, packageName, version)
+	if err != nil {
+		logger.Warn("Failed to list version files, migrating the plain gem only",
+			zap.String("package_name", packageName), zap.String("package_version", version), zap.Error(err))
+	}
+	var filenames []string
+	for _, file := range files {
+		if strings.HasSuffix(file, ".gem") {
+			filenames = append(filenames, file)
+		}
+	}
+	if len(filenames) == 0 {
+		filenames = []string{fmt.Sprintf("%s-%s.gem", packageName, version)}
 	}
 	return filenames, Success, nil
 }
@@ -63,24 +84,197 @@ func (p *RubyGemsProvider) Download(logger *zap.Logger, owner, repository, packa
 	)
 }
 
-func (p *RubyGemsProvider) Rename(logger *zap.Logger, repository, filename string) error {
-	// Skip if source and target organizations are the same
-	if p.CheckOrganizationsMatch(logger) {
+// gemMetadataFields are the gemspec fields pointing at the repository or
+// the registry of a gem
+var gemMetadataFields = regexp.MustCompile(`(?m)^(homepage|  source_code_uri|  github_repo|  allowed_push_host): (.*)$`)
+
+// gemReplacer replaces repository, clone and rubygems registry URLs of the
+// source organization with the target's
+func gemReplacer(sourceHost, sourceOrg, targetHost, targetOrg string) *strings.Replacer {
+	var pairs []string
+	for _, format := range []string{
+		"https://rubygems.pkg.%s/%s/",
+		"https://%s/_registry/rubygems/%s/",
+		"https://%s/%s/",
+		"http://%s/%s/",
+		"ssh://%s/%s/",
+		"ssh://git@%s/%s/",
+		"git@%s:%s/",
+	} {
+		pairs = append(pairs, fmt.Sprintf(format, sourceHost, sourceOrg), fmt.Sprintf(format, targetHost, targetOrg))
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// rewriteGemspec points homepage, source_code_uri, github_repo and
+// allowed_push_host of a YAML gemspec at the target organization
+func rewriteGemspec(spec []byte, replacer *strings.Replacer) []byte {
+	return gemMetadataFields.ReplaceAllFunc(spec, func(field []byte) []byte {
+		groups := gemMetadataFields.FindSubmatch(field)
+		value := string(groups[2])
+		quote := ""
+		if len(value) > 1 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			quote, value = value[:1], value[1:len(value)-1]
+		}
+		// URLs like https://rubygems.pkg.github.com/acme end with the
+		// organization, the slash keeps acme from matching acme-labs
+		value = strings.TrimSuffix(replacer.Replace(value+"/"), "/")
+		return []byte(fmt.Sprintf("%s: %s%s%s", groups[1], quote, value, quote))
+	})
+}
+
+// Rename rewrites the gemspec inside a .gem archive for the target
+// organization and hostname, the checksums of the archive are regenerated
+func (p *RubyGemsProvider) Rename(logger *zap.Logger, filename string) error {
+	sourceHost := hostnameOf("GHMPKG_SOURCE_HOSTNAME")
+	targetHost := hostnameOf("GHMPKG_TARGET_HOSTNAME")
+	// Skip if source and target organizations and hosts are the same
+	if p.CheckOrganizationsMatch(logger) && sourceHost == targetHost {
 		return nil
 	}
+	replacer := gemReplacer(sourceHost, viper.GetString("GHMPKG_SOURCE_ORGANIZATION"), targetHost, viper.GetString("GHMPKG_TARGET_ORGANIZATION"))
 
-	// Replace the organization name in the content
-	sourceHostname := utils.ParseUrl(viper.GetString("GHMPKG_SOURCE_HOSTNAME"))
-	targetHostname := utils.ParseUrl(viper.GetString("GHMPKG_TARGET_HOSTNAME"))
-	sourceHostname.Path = path.Join(sourceHostname.Path, viper.GetString("GHMPKG_SOURCE_ORGANIZATION"))
-	targetHostname.Path = path.Join(targetHostname.Path, viper.GetString("GHMPKG_TARGET_ORGANIZATION"))
-	if err := utils.RenameFileOccurances(filename, sourceHostname.String(), targetHostname.String(), -1); err != nil {
+	content, err := os.ReadFile(filename)
+	if err != nil {
 		return err
 	}
-	if err := utils.RenameFileOccurances(filename, p.SourceRegistryUrl.String(), p.TargetRegistryUrl.String(), -1); err != nil {
-		return err
+	rewritten, signed, err := rewriteGem(content, replacer)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite %s: %w", filename, err)
 	}
-	return nil
+	if rewritten == nil {
+		logger.Debug("Gemspec has no source organization URLs", zap.String("filename", filename))
+		return nil
+	}
+	if signed {
+		logger.Warn("Removed the signatures of a rewritten gem", zap.String("filename", filename))
+	}
+	return utils.ReplaceFile(filename, rewritten)
+}
+
+// rewriteGem rewrites metadata.gz of a .gem and regenerates
+// checksums.yaml.gz, it returns nil when the gemspec is unchanged. Signatures
+// no longer match the rewritten gem and are dropped, signed reports whether
+// there were any.
+func rewriteGem(content []byte, replacer *strings.Replacer) (rewritten []byte, signed bool, err error) {
+	type entry struct {
+		header *tar.Header
+		body   []byte
+	}
+	var entries []entry
+	reader := tar.NewReader(bytes.NewReader(content))
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		body, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, false, err
+		}
+		entries = append(entries, entry{header, body})
+	}
+
+	changed := false
+	var kept []entry
+	for _, e := range entries {
+		if strings.HasSuffix(e.header.Name, ".sig") {
+			signed = true
+			continue
+		}
+		if e.header.Name == "metadata.gz" {
+			spec, err := gunzip(e.body)
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to read metadata.gz: %w", err)
+			}
+			if updated := rewriteGemspec(spec, replacer); !bytes.Equal(updated, spec) {
+				if e.body, err = gzipBytes(updated); err != nil {
+					return nil, false, err
+				}
+				changed = true
+			}
+		}
+		kept = append(kept, e)
+	}
+	if !changed {
+		return nil, false, nil
+	}
+
+	// checksums.yaml.gz covers the other entries, regenerate it from them
+	sums := map[string]map[string]string{"SHA256": {}, "SHA512": {}}
+	for _, e := range kept {
+		if e.header.Name == "checksums.yaml.gz" {
+			continue
+		}
+		sha256sum, sha512sum := sha256.Sum256(e.body), sha512.Sum512(e.body)
+		sums["SHA256"][e.header.Name] = hex.EncodeToString(sha256sum[:])
+		sums["SHA512"][e.header.Name] = hex.EncodeToString(sha512sum[:])
+	}
+	var checksums bytes.Buffer
+	checksums.WriteString("---\n")
+	for _, algorithm := range []string{"SHA256", "SHA512"} {
+		fmt.Fprintf(&checksums, "%s:\n", algorithm)
+		for _, e := range kept {
+			if sum, ok := sums[algorithm][e.header.Name]; ok {
+				fmt.Fprintf(&checksums, "  %s: %s\n", e.header.Name, sum)
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	writer := tar.NewWriter(&out)
+	for _, e := range kept {
+		if e.header.Name == "checksums.yaml.gz" {
+			if e.body, err = gzipBytes(checksums.Bytes()); err != nil {
+				return nil, false, err
+			}
+		}
+		e.header.Size = int64(len(e.body))
+		if err := writer.WriteHeader(e.header); err != nil {
+			return nil, false, err
+		}
+		if _, err := writer.Write(e.body); err != nil {
+			return nil, false, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, false, err
+	}
+	return out.Bytes(), signed, nil
+}
+
+func gunzip(content []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func gzipBytes(content []byte) ([]byte, error) {
+	var out bytes.Buffer
+	writer := gzip.NewWriter(&out)
+	if _, err := writer.Write(content); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Prepare rewrites the gemspec of a gem for the target organization, it
+// returns the gem to publish
+func (p *RubyGemsProvider) Prepare(logger *zap.Logger, packageDir, packageName, version, filename string) (string, error) {
+	gem := filepath.Join(packageDir, filename)
+	if err := p.Rename(logger, gem); err != nil {
+		return "", fmt.Errorf("failed to rename %s: %w", gem, err)
+	}
+	return gem, nil
 }
 
 // ensureGemCredentials sets up the necessary credentials for gem operations
@@ -111,7 +305,7 @@ func (p *RubyGemsProvider) ensureGemCredentials(logger *zap.Logger) error {
 }
 
 // push publishes a gem to the target registry
-func (p *RubyGemsProvider) push(owner, dir, gemFile string) error {
+func (p *RubyGemsProvider) push(logger *zap.Logger, owner, packageName, version, dir, gemFile string) error {
 	// Ensure gem credentials are set up
 	if err := p.ensureGemCredentials(nil); err != nil {
 		return fmt.Errorf("failed to setup gem credentials: %w", err)
@@ -123,17 +317,7 @@ func (p *RubyGemsProvider) push(owner, dir, gemFile string) error {
 	pushCmd.Dir = dir
 	pushCmd.Env = append(os.Environ(), "HTTPS_PROXY=", "GITHUB_TOKEN="+viper.GetString("GHMPKG_TARGET_TOKEN"))
 
-	// Capture output to gemlog file
-	pushLogFile, err := os.Create(filepath.Join(pushCmd.Dir, "gempush.log"))
-	if err != nil {
-		return fmt.Errorf("failed to create log file: %w", err)
-	}
-	defer pushLogFile.Close()
-
-	pushCmd.Stdout = pushLogFile
-	pushCmd.Stderr = pushLogFile
-
-	if err := pushCmd.Run(); err != nil {
+	if err := runlog.Run(logger, pushCmd, p.PackageType, packageName, version, "gem-push"); err != nil {
 		return fmt.Errorf("failed to publish package: %w", err)
 	}
 	return nil
@@ -147,64 +331,14 @@ func (p *RubyGemsProvider) Upload(logger *zap.Logger, owner, repository, package
 			return p.GetUploadUrl(logger, owner, repository, packageName, version, filename)
 		},
 		func(uploadUrl, packageDir string) (ResultState, error) {
-			// Extract the gem file
-			cmd := exec.Command("gem", "unpack", filename)
-			cmd.Dir = packageDir
-			if err := cmd.Run(); err != nil {
-				return Failed, fmt.Errorf("failed to extract package: %w", err)
-			}
-
-			gemBasename := strings.TrimSuffix(filename, ".gem")
-			gemUnpackedDir := filepath.Join(packageDir, gemBasename)
-			possibleGemFiles := []string{
-				gemBasename,
-				packageName,
-			}
-			for _, possibleGemFile := range possibleGemFiles {
-				var gemSpecFileName = fmt.Sprintf("%s.gemspec", possibleGemFile)
-				gemspecFile := filepath.Join(gemUnpackedDir, gemSpecFileName)
-
-				if !utils.FileExists(gemspecFile) {
-					logger.Warn("Gemspec file not found", zap.String("gemFile", gemspecFile))
-					continue
-				}
-
-				if err := p.Rename(logger, repository, gemspecFile); err != nil {
-					return Failed, fmt.Errorf("failed to rename gemspec: %w", err)
-				}
-
-				// Run gem publish
-				buildCmd := exec.Command("gem", "build", gemSpecFileName)
-				buildCmd.Dir = gemUnpackedDir
-
-				// Capture output to gemlog file
-				buildLogFile, err := os.Create(filepath.Join(packageDir, "gembuild.log"))
-				if err != nil {
-					return Failed, fmt.Errorf("failed to create log file: %w", err)
-				}
-				defer buildLogFile.Close()
-
-				buildCmd.Stdout = buildLogFile
-				buildCmd.Stderr = buildLogFile
-
-				if err := buildCmd.Run(); err != nil {
-					return Failed, fmt.Errorf("failed to build package: %w", err)
-				}
-
-				if err = p.push(owner, gemUnpackedDir, fmt.Sprintf("%s-%s.gem", packageName, version)); err != nil {
-					logger.Error("Failed to push package", zap.Error(err))
-					return Failed, err
-				}
-
-				return Success, nil
+			gem, err := p.Prepare(logger, packageDir, packageName, version, filename)
+			if err != nil {
+				return Failed, err
 			}
-
-			logger.Warn("Gemspec file not found, pushing what was downloaded", zap.String("possibleGemFiles", fmt.Sprintf("%v", possibleGemFiles)))
-			if err := p.push(owner, packageDir, filename); err != nil {
+			if err := p.push(logger, owner, packageName, version, packageDir, filepath.Base(gem)); err != nil {
 				logger.Error("Failed to push package", zap.Error(err))
 				return Failed, err
 			}
-
 			return Success, nil
 		},
 	)
@@ -0,0 +1,78 @@
+package providers
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRewriteGem(t *testing.T) {
+	spec := `--- !ruby/object:Gem::Specification
+name: widgets
+homepage: https://github.com/acme/widgets
+description: Fork of https://github.com/acme/widgets
+metadata:
+  source_code_uri: "https://github.com/acme/widgets"
+  github_repo: ssh://github.com/acme/widgets
+  allowed_push_host: https://rubygems.pkg.github.com/acme
+  changelog_uri: https://github.com/acme-labs/widgets/releases
+`
+	metadata, _ := gzipBytes([]byte(spec))
+	data, _ := gzipBytes([]byte("lib/widgets.rb"))
+	checksums, _ := gzipBytes([]byte("---\nSHA256:\n  metadata.gz: stale\n"))
+	var gem bytes.Buffer
+	tw := tar.NewWriter(&gem)
+	for _, entry := range []struct {
+		name string
+		body []byte
+	}{{"metadata.gz", metadata}, {"metadata.gz.sig", []byte("sig")}, {"data.tar.gz", data}, {"checksums.yaml.gz", checksums}} {
+		tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0444, Size: int64(len(entry.body))})
+		tw.Write(entry.body)
+	}
+	tw.Close()
+
+	rewritten, signed, err := rewriteGem(gem.Bytes(), gemReplacer("github.com", "acme", "ghe.example.com", "octo"))
+	if err != nil || !signed {
+		t.Fatalf("rewriteGem: signed = %v, err = %v", signed, err)
+	}
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(bytes.NewReader(rewritten))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(tr)
+		files[header.Name] = body
+	}
+	if _, ok := files["metadata.gz.sig"]; ok {
+		t.Error("signature of the rewritten gem was kept")
+	}
+	got, _ := gunzip(files["metadata.gz"])
+	for _, want := range []string{
+		"homepage: https://ghe.example.com/octo/widgets\n",
+		`source_code_uri: "https://ghe.example.com/octo/widgets"`,
+		"github_repo: ssh://ghe.example.com/octo/widgets\n",
+		"allowed_push_host: https://rubygems.pkg.ghe.example.com/octo\n",
+		// Only the repository and registry fields change
+		"description: Fork of https://github.com/acme/widgets",
+		"changelog_uri: https://github.com/acme-labs/widgets/releases",
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("rewritten gemspec is missing %s:\n%s", want, got)
+		}
+	}
+	sums, _ := gunzip(files["checksums.yaml.gz"])
+	sum := sha256.Sum256(files["metadata.gz"])
+	if !strings.Contains(string(sums), "metadata.gz: "+hex.EncodeToString(sum[:])) {
+		t.Errorf("checksums.yaml.gz was not regenerated:\n%s", sums)
+	}
+}
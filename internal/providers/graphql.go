@@ -0,0 +1,119 @@
+package providers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v62/github"
+	"github.com/shurcooL/githubv4"
+	"go.uber.org/zap"
+)
+
+// GraphQLPackageTypes are the package types the GraphQL API lists, npm and
+// container packages are only listed by the REST API
+var GraphQLPackageTypes = []string{"maven", "rubygems", "nuget"}
+
+// versionListPage is a page of package versions listed without their files
+type versionListPage struct {
+	Nodes []struct {
+		Version githubv4.String
+		Summary githubv4.String
+	}
+	PageInfo struct {
+		EndCursor   githubv4.String
+		HasNextPage bool
+	}
+}
+
+type packageListQuery struct {
+	Organization struct {
+		Packages struct {
+			Nodes []struct {
+				ID       githubv4.ID
+				Name     githubv4.String
+				Versions versionListPage `graphql:"versions(first: 100)"`
+			}
+			PageInfo struct {
+				EndCursor   githubv4.String
+				HasNextPage bool
+			}
+		} `graphql:"packages(first: $packagesFirst, after: $packagesAfter, packageType: $packageType)"`
+	} `graphql:"organization(login: $owner)"`
+}
+
+type packageVersionsQuery struct {
+	Node struct {
+		Package struct {
+			Versions versionListPage `graphql:"versions(first: 100, after: $versionsAfter)"`
+		} `graphql:"... on Package"`
+	} `graphql:"node(id: $packageID)"`
+}
+
+// appendVersions adds the versions of a page as REST package versions
+func appendVersions(versions []*github.PackageVersion, page versionListPage) []*github.PackageVersion {
+	for _, node := range page.Nodes {
+		version := &github.PackageVersion{Name: github.String(string(node.Version))}
+		if node.Summary != "" {
+			version.Summary = github.String(string(node.Summary))
+		}
+		versions = append(versions, version)
+	}
+	return versions
+}
+
+// ListVersionsGraphQL lists the versions of every package of a type with the
+// GraphQL API, keyed by package name. A query returns 20 packages with their
+// first 100 versions, where the REST API needs a call per package, so only
+// packages with more versions take more queries. Versions are newest first.
+func ListVersionsGraphQL(logger *zap.Logger, owner, token, packageType string) (map[string][]*github.PackageVersion, error) {
+	client, ctx, err := newGraphQLClient(token)
+	if err != nil {
+		return nil, err
+	}
+	packages := map[string][]*github.PackageVersion{}
+	packagesAfter := (*githubv4.String)(nil)
+	queries := 0
+	for {
+		var query packageListQuery
+		variables := map[string]interface{}{
+			"owner":         githubv4.String(owner),
+			"packageType":   githubv4.PackageType(strings.ToUpper(packageType)),
+			"packagesFirst": githubv4.Int(20),
+			"packagesAfter": packagesAfter,
+		}
+		if err := client.Query(ctx, &query, variables); err != nil {
+			return nil, fmt.Errorf("error querying packages: %w", err)
+		}
+		queries++
+
+		for _, pkg := range query.Organization.Packages.Nodes {
+			// Skip deleted packages
+			if strings.HasPrefix(string(pkg.Name), "deleted_") {
+				continue
+			}
+			versions := appendVersions(nil, pkg.Versions)
+			page := pkg.Versions
+			for page.PageInfo.HasNextPage {
+				var versionQuery packageVersionsQuery
+				versionVariables := map[string]interface{}{
+					"packageID":     githubv4.ID(pkg.ID),
+					"versionsAfter": githubv4.String(page.PageInfo.EndCursor),
+				}
+				if err := client.Query(ctx, &versionQuery, versionVariables); err != nil {
+					return nil, fmt.Errorf("error querying versions of %s: %w", pkg.Name, err)
+				}
+				queries++
+				page = versionQuery.Node.Package.Versions
+				versions = appendVersions(versions, page)
+			}
+			packages[string(pkg.Name)] = versions
+		}
+
+		if !query.Organization.Packages.PageInfo.HasNextPage {
+			break
+		}
+		packagesAfter = &query.Organization.Packages.PageInfo.EndCursor
+	}
+	logger.Info("Listed package versions with the GraphQL API", zap.String("package_type", packageType), zap.Int("packages", len(packages)), zap.Int("queries", queries))
+	return packages, nil
+}
@@ -0,0 +1,23 @@
+package providers
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAppendVersions(t *testing.T) {
+	var page versionListPage
+	if err := json.Unmarshal([]byte(`{"nodes": [{"version": "2.0.0", "summary": "Widget library"}, {"version": "1.0.0", "summary": null}]}`), &page); err != nil {
+		t.Fatal(err)
+	}
+	versions := appendVersions(nil, page)
+	if len(versions) != 2 {
+		t.Fatalf("appendVersions = %d versions, expected 2", len(versions))
+	}
+	if versions[0].GetName() != "2.0.0" || versions[0].GetSummary() != "Widget library" {
+		t.Errorf("appendVersions[0] = %s %q", versions[0].GetName(), versions[0].GetSummary())
+	}
+	if versions[1].GetName() != "1.0.0" || versions[1].Summary != nil {
+		t.Errorf("appendVersions[1] = %s %v, expected no summary", versions[1].GetName(), versions[1].Summary)
+	}
+}
@@ -1,14 +1,18 @@
 package providers
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/go-github/v62/github"
 	"github.com/mark-humane/gh-migrate-packages/internal/utils"
@@ -22,10 +26,11 @@ import (
 // supporting download and upload of Maven artifacts.
 type MavenProvider struct {
 	BaseProvider
-	httpClient   *http.Client
-	client       *githubv4.Client
-	ctx          context.Context
-	packageFiles []PackageNode
+	httpClient *http.Client
+	client     *githubv4.Client
+	ctx        context.Context
+	renameMu   sync.Mutex
+	renamed    map[string]bool
 }
 
 // Constructor
@@ -51,27 +56,14 @@ func (p *MavenProvider) Connect(logger *zap.Logger) error {
 
 // FetchPackageFiles retrieves package files information from GitHub GraphQL API
 func (p *MavenProvider) FetchPackageFiles(logger *zap.Logger, owner, repository, packageType, packageName, version string, metadata *github.PackageMetadata) ([]string, ResultState, error) {
-	if p.packageFiles == nil || len(p.packageFiles) == 0 {
-		packageFiles, _, err := FetchFromGraphQL(logger, owner, viper.GetString("GHMPKG_SOURCE_TOKEN"), string(p.PackageType))
-		if err != nil {
-			return nil, Failed, err
-		}
-		p.packageFiles = packageFiles
+	filenames, err := p.VersionFiles(logger, owner, packageName, version)
+	if err != nil {
+		return nil, Failed, err
 	}
-
-	var filenames []string
-	for _, cachedPkg := range p.packageFiles {
-		if string(cachedPkg.Name) != packageName {
-			continue
-		}
-		for _, cachedVersion := range cachedPkg.Versions.Nodes {
-			if string(cachedVersion.Version) != version {
-				continue
-			}
-			for _, file := range cachedVersion.Files.Nodes {
-				filenames = append(filenames, string(file.Name))
-			}
-		}
+	// A version without files fails instead of being exported empty
+	if len(filenames) == 0 {
+		logger.Warn("No files found for version", zap.String("package_name", packageName), zap.String("package_version", version))
+		return nil, Failed, nil
 	}
 
 	return filenames, Success, nil
@@ -87,7 +79,13 @@ func (p *MavenProvider) Download(logger *zap.Logger, owner, repository, packageT
 		},
 		// Download function
 		func(downloadUrl, outputPath string) (ResultState, error) {
-			if err := utils.DownloadFile(downloadUrl, outputPath, viper.GetString("GHMPKG_SOURCE_TOKEN")); err != nil {
+			token := viper.GetString("GHMPKG_SOURCE_TOKEN")
+			// Validate artifacts against the .sha1 published next to them
+			var expected *utils.Checksum
+			if !isChecksumFile(filename) {
+				expected = utils.FetchChecksumFile(downloadUrl, token, "sha1")
+			}
+			if err := utils.DownloadFileWithChecksum(downloadUrl, outputPath, token, expected); err != nil {
 				return Failed, err
 			}
 			return Success, nil
@@ -95,13 +93,122 @@ func (p *MavenProvider) Download(logger *zap.Logger, owner, repository, packageT
 	)
 }
 
-// Rename processes Maven-specific files to update organization references
-func (p *MavenProvider) Rename(logger *zap.Logger, repository, packageName, version, filename string) error {
-	// Skip if source and target organizations are the same
-	if p.CheckOrganizationsMatch(logger) {
-		return nil
+// isChecksumFile reports whether a file is a checksum or signature companion
+func isChecksumFile(filename string) bool {
+	for _, ext := range []string{".md5", ".sha1", ".sha256", ".sha512", ".asc"} {
+		if strings.HasSuffix(filename, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// MavenFile is a file of a maven version split into its coordinates, e.g.
+// core-1.0-tests.jar or core-1.0-dist.tar.gz.sha1
+type MavenFile struct {
+	ArtifactID string
+	Version    string
+	Classifier string
+	// Extension is everything after the first dot following the version
+	// and classifier, like jar, tar.gz or pom.sha1
+	Extension string
+}
+
+// snapshotTimestamp matches the timestamp and build number unique snapshot
+// files carry instead of SNAPSHOT, e.g. -20240101.120000-1
+var snapshotTimestamp = regexp.MustCompile(`^-\d{8}\.\d{6}-\d+`)
+
+// ParseMavenFile splits a filename of a maven version into artifactId,
+// classifier and extension, which can be anything. Classifiers have no dots,
+// so the extension starts at the first dot after the version. The last
+// occurrence of the version that parses wins, artifactIds may contain it.
+// It returns false for files that don't follow the artifactId-version
+// naming.
+func ParseMavenFile(filename, version string) (MavenFile, bool) {
+	base := strings.TrimSuffix(version, "-SNAPSHOT")
+	for end := len(filename); ; {
+		start := strings.LastIndex(filename[:end], "-"+base)
+		if start <= 0 {
+			return MavenFile{}, false
+		}
+		if file, ok := parseMavenRest(filename[start+1+len(base):], base != version); ok {
+			file.ArtifactID, file.Version = filename[:start], version
+			return file, true
+		}
+		end = start
+	}
+}
+
+// parseMavenRest parses what follows the version in a maven filename
+func parseMavenRest(rest string, snapshot bool) (MavenFile, bool) {
+	var file MavenFile
+	if snapshot {
+		if timestamp := snapshotTimestamp.FindString(rest); timestamp != "" {
+			rest = rest[len(timestamp):]
+		} else if after, ok := strings.CutPrefix(rest, "-SNAPSHOT"); ok {
+			rest = after
+		} else {
+			return file, false
+		}
+	}
+	if after, ok := strings.CutPrefix(rest, "-"); ok {
+		if file.Classifier, rest, ok = strings.Cut(after, "."); !ok || file.Classifier == "" {
+			return file, false
+		}
+		rest = "." + rest
+	}
+	extension, ok := strings.CutPrefix(rest, ".")
+	if !ok || extension == "" {
+		return file, false
+	}
+	file.Extension = extension
+	return file, true
+}
+
+// pomSections matches the parts of a pom that point at the source
+// organization: the scm section, the distribution repositories and url
+// elements like the project url
+var pomSections = regexp.MustCompile(`(?s)<scm>.*?</scm>|<distributionManagement>.*?</distributionManagement>|<url>[^<]*</url>`)
+
+// hostnameOf returns the hostname of a hostname setting, github.com when unset
+func hostnameOf(key string) string {
+	if value := viper.GetString(key); value != "" {
+		if parsed, err := url.Parse(value); err == nil && parsed.Hostname() != "" {
+			return parsed.Hostname()
+		}
+		return strings.TrimSuffix(value, "/")
+	}
+	return "github.com"
+}
+
+// pomReplacer replaces repository, clone and maven registry URLs of the
+// source organization with the target's
+func pomReplacer(sourceHost, sourceOrg, targetHost, targetOrg string) *strings.Replacer {
+	var pairs []string
+	for _, format := range []string{
+		"https://maven.pkg.%s/%s/",
+		"https://%s/_registry/maven/%s/",
+		"https://%s/%s/",
+		"http://%s/%s/",
+		"git://%s/%s/",
+		"ssh://git@%s/%s/",
+		"git@%s:%s/",
+	} {
+		pairs = append(pairs, fmt.Sprintf(format, sourceHost, sourceOrg), fmt.Sprintf(format, targetHost, targetOrg))
 	}
+	return strings.NewReplacer(pairs...)
+}
+
+// rewritePom points the scm, url and distributionManagement sections of a
+// pom at the target organization, the rest of the pom is left untouched
+func rewritePom(content []byte, replacer *strings.Replacer) []byte {
+	return pomSections.ReplaceAllFunc(content, func(section []byte) []byte {
+		return []byte(replacer.Replace(string(section)))
+	})
+}
 
+// Rename rewrites the source organization and hostname in a pom file
+func (p *MavenProvider) Rename(logger *zap.Logger, repository, packageName, version, filename string) error {
 	// Check if the file is a pom.xml or any .pom file
 	if !strings.HasSuffix(filename, "pom.xml") && !strings.HasSuffix(filename, ".pom") {
 		logger.Debug("File is not a pom file, skipping",
@@ -109,7 +216,23 @@ func (p *MavenProvider) Rename(logger *zap.Logger, repository, packageName, vers
 		return nil
 	}
 
-	// Read the file content
+	sourceOrg := viper.GetString("GHMPKG_SOURCE_ORGANIZATION")
+	targetOrg := viper.GetString("GHMPKG_TARGET_ORGANIZATION")
+	sourceHost := hostnameOf("GHMPKG_SOURCE_HOSTNAME")
+	targetHost := hostnameOf("GHMPKG_TARGET_HOSTNAME")
+	// Skip if source and target organizations and hosts are the same
+	if p.CheckOrganizationsMatch(logger) && sourceHost == targetHost {
+		return nil
+	}
+
+	// Uploads run concurrently, a pom and its checksums are prepared by
+	// different goroutines and the pom must only be rewritten once
+	p.renameMu.Lock()
+	defer p.renameMu.Unlock()
+	if p.renamed[filename] {
+		return nil
+	}
+
 	content, err := os.ReadFile(filename)
 	if err != nil {
 		logger.Warn("Failed to read pom file",
@@ -117,16 +240,16 @@ func (p *MavenProvider) Rename(logger *zap.Logger, repository, packageName, vers
 			zap.Error(err))
 		return nil // Continue with warning
 	}
+	newContent := rewritePom(content, pomReplacer(sourceHost, sourceOrg, targetHost, targetOrg))
+	if p.renamed == nil {
+		p.renamed = make(map[string]bool)
+	}
+	p.renamed[filename] = true
+	if bytes.Equal(content, newContent) {
+		return nil
+	}
 
-	// Create the search and replace strings
-	sourceUrl := fmt.Sprintf("https://maven.pkg.github.com/%s/packages", viper.GetString("GHMPKG_SOURCE_ORGANIZATION"))
-	targetUrl := fmt.Sprintf("https://maven.pkg.github.com/%s/packages", viper.GetString("GHMPKG_TARGET_ORGANIZATION"))
-
-	// Replace the content
-	newContent := strings.ReplaceAll(string(content), sourceUrl, targetUrl)
-
-	// Write the file back
-	if err := os.WriteFile(filename, []byte(newContent), 0644); err != nil {
+	if err := utils.ReplaceFile(filename, newContent); err != nil {
 		logger.Warn("Failed to write updated pom file",
 			zap.String("filename", filename),
 			zap.Error(err))
@@ -135,12 +258,61 @@ func (p *MavenProvider) Rename(logger *zap.Logger, repository, packageName, vers
 
 	logger.Info("Successfully updated organization reference in file",
 		zap.String("filename", filename),
-		zap.String("sourceOrg", viper.GetString("GHMPKG_SOURCE_ORGANIZATION")),
-		zap.String("targetOrg", viper.GetString("GHMPKG_TARGET_ORGANIZATION")))
+		zap.String("sourceOrg", sourceOrg),
+		zap.String("targetOrg", targetOrg))
 
 	return nil
 }
 
+// Prepare rewrites organization references in a pom file, it returns the
+// file to publish. Checksum files are prepared by rewriting their artifact
+// first and regenerating the checksum when the artifact changed.
+func (p *MavenProvider) Prepare(logger *zap.Logger, packageDir, packageName, version, filename string) (string, error) {
+	inputPath := filepath.Join(packageDir, filename)
+	renamePath := inputPath
+	if isChecksumFile(filename) {
+		renamePath = strings.TrimSuffix(inputPath, filepath.Ext(inputPath))
+	}
+	if err := p.Rename(logger, "", packageName, version, renamePath); err != nil {
+		logger.Error("Failed to execute rename operation", zap.Error(err))
+		// Continue with upload even if rename fails
+	}
+	if isChecksumFile(filename) {
+		if err := refreshChecksum(logger, inputPath); err != nil {
+			return "", fmt.Errorf("failed to regenerate %s: %w", filename, err)
+		}
+	}
+	return inputPath, nil
+}
+
+// refreshChecksum regenerates a .md5/.sha1/.sha256/.sha512 file whose
+// artifact changed since it was downloaded, e.g. a rewritten pom or a file
+// edited by a pre-publish hook, consumers reject artifacts that do not
+// match their checksum
+func refreshChecksum(logger *zap.Logger, checksumPath string) error {
+	algorithm := strings.TrimPrefix(filepath.Ext(checksumPath), ".")
+	artifact := strings.TrimSuffix(checksumPath, "."+algorithm)
+	// Signatures cannot be regenerated
+	if algorithm == "asc" || !utils.FileExists(artifact) {
+		return nil
+	}
+	content, err := os.ReadFile(checksumPath)
+	if err != nil {
+		return err
+	}
+	actual, err := utils.FileChecksum(artifact, algorithm)
+	if err != nil {
+		return err
+	}
+	if expected := utils.ParseChecksumFile(string(content), algorithm); expected != nil && strings.EqualFold(expected.Value, actual) {
+		return nil
+	}
+	logger.Info("Regenerating checksum of changed artifact",
+		zap.String("filename", filepath.Base(checksumPath)),
+		zap.String(algorithm, actual))
+	return utils.ReplaceFile(checksumPath, []byte(actual))
+}
+
 // Upload sends a Maven artifact to the target registry
 func (p *MavenProvider) Upload(logger *zap.Logger, owner, repository, packageType, packageName, version, filename string) (ResultState, error) {
 
@@ -164,16 +336,19 @@ func (p *MavenProvider) Upload(logger *zap.Logger, owner, repository, packageTyp
 				return p.GetUploadUrl(logger, owner, repository, packageName, version, filename)
 			},
 			func(uploadUrl, packageDir string) (ResultState, error) {
-				inputPath := filepath.Join(packageDir, filename)
 				uploadPackageUrl, err := p.GetUploadUrl(logger, owner, repository, packageName, version, filename)
 				if err != nil {
 					return Failed, err
 				}
-				logger.Info("Uploading file", zap.String("url", uploadPackageUrl))
+				fields := []zap.Field{zap.String("url", uploadPackageUrl)}
+				if file, ok := ParseMavenFile(filename, version); ok {
+					fields = append(fields, zap.String("classifier", file.Classifier), zap.String("extension", file.Extension))
+				}
+				logger.Info("Uploading file", fields...)
 
-				if err := p.Rename(logger, repository, packageName, version, inputPath); err != nil {
-					logger.Error("Failed to execute rename operation", zap.Error(err))
-					// Continue with upload even if rename fails
+				inputPath, err := p.Prepare(logger, packageDir, packageName, version, filename)
+				if err != nil {
+					return Failed, err
 				}
 
 				response, err := utils.UploadFile(uploadPackageUrl, inputPath, viper.GetString("GHMPKG_TARGET_TOKEN"))
@@ -203,10 +378,12 @@ func (p *MavenProvider) Upload(logger *zap.Logger, owner, repository, packageTyp
 // Batch Operations
 // ---------------
 
-// UploadBatch handles concurrent upload of multiple Maven artifacts
-func (p *MavenProvider) UploadBatch(logger *zap.Logger, owner, repository, packageType, packageName, version string, filenames []string) ([]ResultState, error) {
+// UploadBatch handles concurrent upload of multiple Maven artifacts and
+// returns how long each file took
+func (p *MavenProvider) UploadBatch(logger *zap.Logger, owner, repository, packageType, packageName, version string, filenames []string) ([]ResultState, []time.Duration, error) {
 	const maxConcurrent = 5
 	results := make([]ResultState, len(filenames))
+	durations := make([]time.Duration, len(filenames))
 	errChan := make(chan error, len(filenames))
 	var wg sync.WaitGroup
 	sem := make(chan struct{}, maxConcurrent)
@@ -218,7 +395,9 @@ func (p *MavenProvider) UploadBatch(logger *zap.Logger, owner, repository, packa
 			sem <- struct{}{}        // Acquire semaphore
 			defer func() { <-sem }() // Release semaphore
 
+			start := time.Now()
 			state, err := p.Upload(logger, owner, repository, packageType, packageName, version, fname)
+			durations[idx] = time.Since(start)
 			if err != nil {
 				errChan <- err
 				return
@@ -233,11 +412,11 @@ func (p *MavenProvider) UploadBatch(logger *zap.Logger, owner, repository, packa
 	// Check for any errors
 	for err := range errChan {
 		if err != nil {
-			return results, err
+			return results, durations, err
 		}
 	}
 
-	return results, nil
+	return results, durations, nil
 }
 
 // URL Generation
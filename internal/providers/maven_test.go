@@ -0,0 +1,97 @@
+package providers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"go.uber.org/zap"
+)
+
+func TestRewritePom(t *testing.T) {
+	pom := `<project>
+  <url>https://github.com/acme/widgets/</url>
+  <scm>
+    <connection>scm:git:git://github.com/acme/widgets.git</connection>
+    <developerConnection>scm:git:git@github.com:acme/widgets.git</developerConnection>
+    <url>https://github.com/acme/widgets/tree/main</url>
+  </scm>
+  <dependencies>
+    <dependency><groupId>com.github.acme</groupId><artifactId>https://github.com/acme/</artifactId></dependency>
+  </dependencies>
+  <distributionManagement>
+    <repository>
+      <id>github</id>
+      <url>https://maven.pkg.github.com/acme/widgets</url>
+    </repository>
+  </distributionManagement>
+</project>`
+
+	got := string(rewritePom([]byte(pom), pomReplacer("github.com", "acme", "ghe.example.com", "octo")))
+	for _, want := range []string{
+		"<url>https://ghe.example.com/octo/widgets/</url>",
+		"scm:git:git://ghe.example.com/octo/widgets.git",
+		"scm:git:git@ghe.example.com:octo/widgets.git",
+		"<url>https://ghe.example.com/octo/widgets/tree/main</url>",
+		"<url>https://maven.pkg.ghe.example.com/octo/widgets</url>",
+		// Only the scm, url and distributionManagement sections change
+		"<artifactId>https://github.com/acme/</artifactId>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("rewritten pom is missing %s:\n%s", want, got)
+		}
+	}
+}
+
+func TestRefreshChecksum(t *testing.T) {
+	dir := t.TempDir()
+	pom := filepath.Join(dir, "widgets-1.0.pom")
+	os.WriteFile(pom, []byte("<project/>"), 0644)
+	// sha1 of the pom before it was rewritten
+	os.WriteFile(pom+".sha1", []byte("0123456789abcdef0123456789abcdef01234567  widgets-1.0.pom"), 0644)
+
+	if err := refreshChecksum(zap.NewNop(), pom+".sha1"); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(pom + ".sha1")
+	if want, _ := utils.FileChecksum(pom, "sha1"); string(content) != want {
+		t.Errorf("checksum = %s, want %s", content, want)
+	}
+
+	// Matching checksums are kept as published
+	published := "AEDFFC237DBF73096EC2AE39DBA0F041\n"
+	os.WriteFile(pom+".md5", []byte(published), 0644)
+	if err := refreshChecksum(zap.NewNop(), pom+".md5"); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ = os.ReadFile(pom + ".md5"); string(content) != published {
+		t.Errorf("md5 = %q, want %q", content, published)
+	}
+}
+
+func TestParseMavenFile(t *testing.T) {
+	tests := []struct {
+		filename, version string
+		want              MavenFile
+		ok                bool
+	}{
+		{"core-1.0.jar", "1.0", MavenFile{"core", "1.0", "", "jar"}, true},
+		{"core-1.0-tests.jar", "1.0", MavenFile{"core", "1.0", "tests", "jar"}, true},
+		{"core-1.0-dist.tar.gz", "1.0", MavenFile{"core", "1.0", "dist", "tar.gz"}, true},
+		{"core-1.0-bin.zip.sha1", "1.0", MavenFile{"core", "1.0", "bin", "zip.sha1"}, true},
+		{"core-1.0.pom.asc", "1.0", MavenFile{"core", "1.0", "", "pom.asc"}, true},
+		{"lib-1.0-compat-1.0-sources.jar", "1.0", MavenFile{"lib-1.0-compat", "1.0", "sources", "jar"}, true},
+		{"core-1-20240101.120000-3-javadoc.jar", "1-SNAPSHOT", MavenFile{"core", "1-SNAPSHOT", "javadoc", "jar"}, true},
+		{"core-2.0-SNAPSHOT.pom", "2.0-SNAPSHOT", MavenFile{"core", "2.0-SNAPSHOT", "", "pom"}, true},
+		{"core-1.0", "1.0", MavenFile{}, false},
+		{"notes.txt", "1.0", MavenFile{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseMavenFile(tt.filename, tt.version)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("ParseMavenFile(%q, %q) = %+v, %v, want %+v, %v", tt.filename, tt.version, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package providers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Names GitHub Packages accepts per package type, other registries allow
+// names it rejects
+var (
+	containerComponent = regexp.MustCompile(`^[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*$`)
+	npmName            = regexp.MustCompile(`^[a-z0-9~-][a-z0-9._~-]*$`)
+	nugetID            = regexp.MustCompile(`^\w+([_.-]\w+)*$`)
+	mavenName          = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	gemName            = regexp.MustCompile(`^[A-Za-z0-9._-]*[A-Za-z][A-Za-z0-9._-]*$`)
+
+	containerInvalid    = regexp.MustCompile(`[^a-z0-9._-]+`)
+	containerSeparators = regexp.MustCompile(`[._-]{2,}`)
+)
+
+// maxNameLengths are the longest names GitHub Packages accepts
+var maxNameLengths = map[string]int{
+	"container": 255,
+	"npm":       214,
+	"nuget":     100,
+	"maven":     255,
+	"rubygems":  255,
+}
+
+// NameProblem returns why GitHub Packages rejects a package name, or an
+// empty string when the name is accepted
+func NameProblem(packageType, name string) string {
+	if name == "" {
+		return "the name is empty"
+	}
+	if limit, ok := maxNameLengths[packageType]; ok && len(name) > limit {
+		return fmt.Sprintf("the name is %d characters long, at most %d are allowed", len(name), limit)
+	}
+	switch packageType {
+	case "container":
+		for _, component := range strings.Split(name, "/") {
+			if !containerComponent.MatchString(component) {
+				return fmt.Sprintf("%q must be lowercase letters and digits separated by ., _, __ or dashes", component)
+			}
+		}
+	case "npm":
+		// Scopes are rewritten to the target organization
+		if _, unscoped, ok := strings.Cut(name, "/"); ok && strings.HasPrefix(name, "@") {
+			name = unscoped
+		}
+		if !npmName.MatchString(name) {
+			return "npm names must be lowercase and URL safe, and can't start with . or _"
+		}
+	case "nuget":
+		if !nugetID.MatchString(name) {
+			return "NuGet IDs must be letters, digits and underscores separated by ., _ or -"
+		}
+	case "maven":
+		if !mavenName.MatchString(name) {
+			return "maven names must be letters, digits, ., _ or -"
+		}
+	case "rubygems":
+		if !gemName.MatchString(name) {
+			return "gem names must be letters, digits, ., _ or - with at least one letter"
+		}
+	}
+	return ""
+}
+
+// sanitizeContainerName turns a name into a valid container image name:
+// lowercase, other characters replaced with -, separators collapsed and
+// empty path components dropped. Image names are only the path pushed to,
+// so unlike the names of other package types they can be changed without
+// rewriting the package.
+func sanitizeContainerName(name string) string {
+	var components []string
+	for _, component := range strings.Split(strings.ToLower(name), "/") {
+		component = containerInvalid.ReplaceAllString(component, "-")
+		component = containerSeparators.ReplaceAllStringFunc(component, func(run string) string {
+			if run == "__" || strings.Trim(run, "-") == "" {
+				return run
+			}
+			return "-"
+		})
+		if component = strings.Trim(component, "._-"); component != "" {
+			components = append(components, component)
+		}
+	}
+	return strings.Join(components, "/")
+}
@@ -0,0 +1,48 @@
+package providers
+
+import "testing"
+
+func TestSanitizeContainerName(t *testing.T) {
+	tests := map[string]string{
+		"app":                 "app",
+		"Team/My App":         "team/my-app",
+		"tools/base..image":   "tools/base-image",
+		"legacy__build--tool": "legacy__build--tool",
+		"-edge_/.hidden":      "edge/hidden",
+		"a/ /b":               "a/b",
+		"!!!":                 "",
+	}
+	for name, expected := range tests {
+		sanitized := sanitizeContainerName(name)
+		if sanitized != expected {
+			t.Errorf("sanitizeContainerName(%q) = %q, expected %q", name, sanitized, expected)
+		}
+		if sanitized != "" && NameProblem("container", sanitized) != "" {
+			t.Errorf("sanitized name %q is invalid: %s", sanitized, NameProblem("container", sanitized))
+		}
+	}
+}
+
+func TestNameProblem(t *testing.T) {
+	tests := []struct {
+		packageType, name string
+		valid             bool
+	}{
+		{"npm", "@acme/ui-kit", true},
+		{"npm", "UI-Kit", false},
+		{"npm", "_private", false},
+		{"nuget", "Acme.Core", true},
+		{"nuget", "Acme Core", false},
+		{"maven", "com.acme.core-lib", true},
+		{"maven", "com.acme:core", false},
+		{"rubygems", "acme_tools", true},
+		{"rubygems", "1234", false},
+		{"container", "Team/App", false},
+		{"container", "", false},
+	}
+	for _, test := range tests {
+		if problem := NameProblem(test.packageType, test.name); (problem == "") != test.valid {
+			t.Errorf("NameProblem(%s, %q) = %q, expected valid %v", test.packageType, test.name, problem, test.valid)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package providers
 
 import (
+	"archive/tar"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,9 +13,14 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/google/go-github/v62/github"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/internal/runlog"
+	"github.com/mark-humane/gh-migrate-packages/internal/storage"
 	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/pterm/pterm"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
@@ -72,6 +79,16 @@ type BugsInfo struct {
 
 type NPMProvider struct {
 	BaseProvider
+	packumentsMu sync.Mutex
+	// packuments holds the versions of each packument fetched, by owner and
+	// package name
+	packuments map[string]map[string]packumentVersion
+}
+
+// packumentVersion is what the migration reads of a version in a packument
+type packumentVersion struct {
+	Deprecated string   `json:"deprecated"`
+	Dist       DistInfo `json:"dist"`
 }
 
 
@@ -88,43 +105,75 @@ func (p *NPMProvider) Connect(logger *zap.Logger) error {
 
 func (p *NPMProvider) FetchPackageFiles(logger *zap.Logger, owner, repository, packageType, packageName, version string, metadata *github.PackageMetadata) ([]string, ResultState, error) {
 	logger.Info("Loading package files from NPM package registry")
-	fetchUrl, err := p.GetFetchUrl(logger, owner, packageName, version)
+	found, err := p.packumentVersion(logger, owner, packageName, version)
 	if err != nil {
 		return nil, Failed, err
 	}
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", fetchUrl, nil)
+	tarballUrl, err := url.Parse(found.Dist.Tarball)
+	logger.Info("Tarball url", zap.String("tarballUrl", tarballUrl.String()))
 	if err != nil {
 		return nil, Failed, err
 	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", viper.GetString("GHMPKG_SOURCE_TOKEN")))
-	resp, err := client.Do(req)
+	filename := path.Base(tarballUrl.Path)
+	var filenames []string
+	filenames = append(filenames, filename)
+	logger.Info("Package files", zap.String("filename", filename))
+	return filenames, Success, nil
+}
+
+// packumentVersion returns a version from the packument of its package.
+// The packument lists every version, so it is fetched once per package for
+// the run and again only for versions published since.
+func (p *NPMProvider) packumentVersion(logger *zap.Logger, owner, packageName, version string) (packumentVersion, error) {
+	key := owner + "/" + packageName
+	p.packumentsMu.Lock()
+	found, ok := p.packuments[key][version]
+	p.packumentsMu.Unlock()
+	if ok {
+		return found, nil
+	}
+
+	versions, err := p.fetchPackument(logger, owner, packageName)
 	if err != nil {
-		return nil, Failed, err
+		return packumentVersion{}, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, Failed, fmt.Errorf("failed to fetch package %s, status: %d, message: %s", fetchUrl, resp.StatusCode, resp.Status)
+	p.packumentsMu.Lock()
+	if p.packuments == nil {
+		p.packuments = make(map[string]map[string]packumentVersion)
 	}
-	// print json response
-	body, err := io.ReadAll(resp.Body)
+	p.packuments[key] = versions
+	p.packumentsMu.Unlock()
+	return versions[version], nil
+}
+
+// fetchPackument fetches the versions of the packument of a package from
+// the source registry
+func (p *NPMProvider) fetchPackument(logger *zap.Logger, owner, packageName string) (map[string]packumentVersion, error) {
+	fetchUrl, err := p.GetFetchUrl(logger, owner, packageName, "")
 	if err != nil {
-		return nil, Failed, err
+		return nil, err
 	}
-	var npmPackage NpmPackage
-	if err := json.Unmarshal(body, &npmPackage); err != nil {
-		return nil, Failed, err
+	logger.Debug("Fetching packument", zap.String("url", fetchUrl))
+	req, err := http.NewRequest("GET", fetchUrl, nil)
+	if err != nil {
+		return nil, err
 	}
-	tarballUrl, err := url.Parse(npmPackage.Versions[version].Dist.Tarball)
-	logger.Info("Tarball url", zap.String("tarballUrl", tarballUrl.String()))
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", viper.GetString("GHMPKG_SOURCE_TOKEN")))
+	resp, err := utils.HTTPClient().Do(req)
 	if err != nil {
-		return nil, Failed, err
+		return nil, err
 	}
-	filename := path.Base(tarballUrl.Path)
-	var filenames []string
-	filenames = append(filenames, filename)
-	logger.Info("Package files", zap.String("filename", filename))
-	return filenames, Success, nil
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch package %s, status: %d, message: %s", fetchUrl, resp.StatusCode, resp.Status)
+	}
+	var packument struct {
+		Versions map[string]packumentVersion `json:"versions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&packument); err != nil {
+		return nil, fmt.Errorf("failed to parse package %s: %w", fetchUrl, err)
+	}
+	return packument.Versions, nil
 }
 
 func (p *NPMProvider) Export(logger *zap.Logger, owner string, content interface{}) error {
@@ -132,14 +181,14 @@ func (p *NPMProvider) Export(logger *zap.Logger, owner string, content interface
 }
 
 func (p *NPMProvider) Download(logger *zap.Logger, owner, repository, packageType, packageName, version, filename string) (ResultState, error) {
-	logger.Info("Downloading package", zap.String("packageName", packageName), zap.String("version", version), zap.String("filename", filename))
+	logger.Info("Downloading package", zap.String("filename", filename))
 	downloadedFilename := fmt.Sprintf("%s-%s.tgz", packageName, version)
 	logger.Info("Downloaded filename", zap.String("downloadedFilename", downloadedFilename))
 	return p.downloadPackage(
 		logger, owner, repository, packageType, packageName, version, filename, &downloadedFilename,
 		// URL generator function
 		func() (string, error) {
-			logger.Info("Getting download url", zap.String("packageName", packageName), zap.String("version", version), zap.String("filename", filename))
+			logger.Info("Getting download url", zap.String("filename", filename))
 			return p.GetDownloadUrl(logger, owner, repository, packageName, version, filename)
 		},
 		// Download function
@@ -152,10 +201,12 @@ func (p *NPMProvider) Download(logger *zap.Logger, owner, repository, packageTyp
 	)
 }
 
+// Rename rewrites the scope and repository URLs of a package.json for the
+// target organization
 func (p *NPMProvider) Rename(logger *zap.Logger, filename string) error {
-	// Skip if source and target organizations are the same
-	if p.CheckOrganizationsMatch(logger) {
-		return nil
+	scopes, err := NpmScopes()
+	if err != nil {
+		return err
 	}
 
 	// Read the file
@@ -163,14 +214,29 @@ func (p *NPMProvider) Rename(logger *zap.Logger, filename string) error {
 	if err != nil {
 		return fmt.Errorf("failed to read package.json: %w", err)
 	}
+	// Skip when no scope is renamed or added
+	if p.CheckOrganizationsMatch(logger) && scopes.Identity() && !needsScoping(content) {
+		return nil
+	}
+
+	// Write back to file
+	err = os.WriteFile(filename, renamePackageJson(content, scopes.Replacer()), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write package.json: %w", err)
+	}
 
+	return nil
+}
+
+// renamePackageJson replaces the mapped scopes and the repository URLs of
+// the source organization in a package.json
+func renamePackageJson(content []byte, scopes *strings.Replacer) []byte {
 	sourceOrg := viper.GetString("GHMPKG_SOURCE_ORGANIZATION")
 	targetOrg := viper.GetString("GHMPKG_TARGET_ORGANIZATION")
 
-	// Replace the organization name in the content, @sourceOrg -> @targetOrg
-	oldScope := fmt.Sprintf("@%s/", sourceOrg)
-	newScope := fmt.Sprintf("@%s/", targetOrg)
-	newContent := strings.Replace(string(content), oldScope, newScope, -1)
+	// Replace the scopes in the content, @sourceOrg -> @targetOrg unless
+	// GHMPKG_NPM_SCOPES maps them otherwise
+	newContent := scopes.Replace(string(content))
 
 	// Replace the repository url in the content
 	sourceHostname := "github.com"
@@ -189,15 +255,98 @@ func (p *NPMProvider) Rename(logger *zap.Logger, filename string) error {
 	}
 	oldRepoUrl := fmt.Sprintf("https://%s/%s/", sourceHostname, sourceOrg)
 	newRepoUrl := fmt.Sprintf("https://%s/%s/", targetHostname, targetOrg)
-	newContent = strings.Replace(newContent, oldRepoUrl, newRepoUrl, -1)
+	renamed := []byte(strings.Replace(newContent, oldRepoUrl, newRepoUrl, -1))
 
-	// Write back to file
-	err = os.WriteFile(filename, []byte(newContent), 0644)
+	// GitHub Packages only takes scoped packages
+	if scope, ok := npmScope(); ok {
+		renamed = scopePackageJson(renamed, scope, currentNpmPackages())
+	}
+	return renamed
+}
+
+// rewriteTgz rewrites the package.json of an npm tarball, the other entries
+// are copied unchanged. The tarball is rewritten in process, so no tar
+// binary is needed.
+func rewriteTgz(content []byte, rewrite func([]byte) []byte) ([]byte, error) {
+	archive, err := gunzip(content)
 	if err != nil {
-		return fmt.Errorf("failed to write package.json: %w", err)
+		return nil, err
 	}
+	var out bytes.Buffer
+	reader := tar.NewReader(bytes.NewReader(archive))
+	writer := tar.NewWriter(&out)
+	found := false
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		// Packages are published from a single top level directory, usually
+		// package/
+		if parts := strings.Split(header.Name, "/"); !found && len(parts) == 2 && parts[1] == "package.json" {
+			body = rewrite(body)
+			header.Size = int64(len(body))
+			found = true
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(body); err != nil {
+			return nil, err
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no package.json found")
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return gzipBytes(out.Bytes())
+}
 
-	return nil
+// Prepare rewrites the scope and repository URLs in package.json for the
+// target organization and repackages the tarball, it returns the tarball.
+func (p *NPMProvider) Prepare(logger *zap.Logger, packageDir, packageName, version, filename string) (string, error) {
+	tgz := filepath.Join(packageDir, fmt.Sprintf("%s-%s.tgz", packageName, version))
+	if err := os.MkdirAll(storage.WorkDir(packageDir), 0755); err != nil {
+		return "", fmt.Errorf("failed to create work directory: %w", err)
+	}
+	scopes, err := NpmScopes()
+	if err != nil {
+		return "", err
+	}
+	// Skip when no scope is renamed or added
+	if p.CheckOrganizationsMatch(logger) && scopes.Identity() {
+		manifest, err := readPackageJsonContent(tgz)
+		if err != nil {
+			return "", err
+		}
+		if !needsScoping(manifest) {
+			return tgz, nil
+		}
+	}
+
+	content, err := os.ReadFile(tgz)
+	if err != nil {
+		return "", fmt.Errorf("failed to read original package: %w", err)
+	}
+	replacer := scopes.Replacer()
+	if content, err = rewriteTgz(content, func(content []byte) []byte { return renamePackageJson(content, replacer) }); err != nil {
+		return "", fmt.Errorf("failed to rewrite package.json in %s: %w", tgz, err)
+	}
+	// Staged files may be hard links into the download cache, so the file
+	// is replaced rather than written in place
+	if err := utils.ReplaceFile(tgz, content); err != nil {
+		return "", fmt.Errorf("failed to replace package: %w", err)
+	}
+	return tgz, nil
 }
 
 func (p *NPMProvider) Upload(logger *zap.Logger, owner, repository, packageType, packageName, version, filename string) (ResultState, error) {
@@ -207,13 +356,25 @@ func (p *NPMProvider) Upload(logger *zap.Logger, owner, repository, packageType,
 			return p.GetUploadUrl(logger, owner, repository, packageName, version, filename)
 		},
 		func(uploadUrl, packageDir string) (ResultState, error) {
-			npmrcPath := filepath.Join(packageDir, ".npmrc")
+			// The .npmrc holds the target token and is kept out of the
+			// staged files, npm runs in the package directory so the path
+			// must be absolute
+			workDir, err := filepath.Abs(storage.WorkDir(packageDir))
+			if err != nil {
+				return Failed, err
+			}
+			if err := os.MkdirAll(workDir, 0755); err != nil {
+				return Failed, fmt.Errorf("failed to create work directory: %w", err)
+			}
+			npmrcPath := filepath.Join(workDir, ".npmrc")
 			tgz := fmt.Sprintf("%s-%s.tgz", packageName, version)
 
 			// Get registry hostname from target registry URL
 			registryHost := "npm.pkg.github.com"
+			registry := "https://npm.pkg.github.com"
 			if p.TargetRegistryUrl != nil {
 				registryHost = p.TargetRegistryUrl.Host
+				registry = strings.TrimSuffix(p.TargetRegistryUrl.String(), "/")
 			}
 
 			// Create .npmrc content with correct registry hostname
@@ -224,66 +385,66 @@ func (p *NPMProvider) Upload(logger *zap.Logger, owner, repository, packageType,
 				owner)
 
 			// Write .npmrc file
-			if err := os.WriteFile(npmrcPath, []byte(npmrcContent), 0644); err != nil {
+			if err := os.WriteFile(npmrcPath, []byte(npmrcContent), 0600); err != nil {
 				return Failed, fmt.Errorf("failed to write .npmrc: %w", err)
 			}
 
-			// Rename the original tgz file to .orig
-			origTgz := tgz + ".orig"
-			if err := os.Rename(filepath.Join(packageDir, tgz), filepath.Join(packageDir, origTgz)); err != nil {
-				return Failed, fmt.Errorf("failed to rename original package: %w", err)
-			}
-
-			// Extract the tgz file
-			cmd := exec.Command("tar", "-xzf", origTgz)
-			cmd.Dir = packageDir
-			if err := cmd.Run(); err != nil {
-				return Failed, fmt.Errorf("failed to extract package: %w", err)
-			}
-
-			// Rename package.json contents
-			packageJson := filepath.Join(packageDir, "package", "package.json")
-			if err := p.Rename(logger, packageJson); err != nil {
-				return Failed, fmt.Errorf("failed to rename package.json: %w", err)
-			}
-
-			// Repackage the modified contents
-			repackageCmd := exec.Command("tar", "-czf", tgz, "package/")
-			repackageCmd.Dir = packageDir
-			if err := repackageCmd.Run(); err != nil {
-				return Failed, fmt.Errorf("failed to repackage modified contents: %w", err)
-			}
-			// remove the package directory
-			if err := os.RemoveAll(filepath.Join(packageDir, "package")); err != nil {
-				return Failed, fmt.Errorf("failed to remove package directory: %w", err)
+			if _, err := p.Prepare(logger, packageDir, packageName, version, filename); err != nil {
+				return Failed, err
 			}
 
 			// Run npm publish with the repackaged file
-			publishCmd := exec.Command("npm", "publish", tgz, "--registry=https://npm.pkg.github.com", "--verbose", "--ignore-scripts", "--no-engine-strict", "--userconfig", npmrcPath)
+			publishCmd := exec.Command("npm", "publish", tgz, "--registry="+registry, "--verbose", "--ignore-scripts", "--no-engine-strict", "--userconfig", npmrcPath)
 			publishCmd.Dir = filepath.Join(packageDir)
 			publishCmd.Env = append(os.Environ(),
 				"HTTPS_PROXY=",
+				"npm_config_logs_dir="+workDir,
 			)
 
-			// Capture output to npmlog file
-			logFile, err := os.Create(filepath.Join(packageDir, "npmlog"))
-			if err != nil {
-				return Failed, fmt.Errorf("failed to create log file: %w", err)
-			}
-			defer logFile.Close()
-
-			publishCmd.Stdout = logFile
-			publishCmd.Stderr = logFile
-
-			if err := publishCmd.Run(); err != nil {
+			if err := runlog.Run(logger, publishCmd, p.PackageType, packageName, version, "npm-publish"); err != nil {
 				return Failed, fmt.Errorf("failed to publish package: %w", err)
 			}
+			p.deprecate(logger, owner, packageName, version, registry, npmrcPath, workDir)
 
 			return Success, nil
 		},
 	)
 }
 
+// deprecate applies the deprecation message of the source version to the
+// published version, npm keeps it in the registry rather than the tarball.
+// The version was published, so failures are only logged.
+func (p *NPMProvider) deprecate(logger *zap.Logger, owner, packageName, version, registry, npmrcPath, workDir string) {
+	if registries.SourceName() != registries.GitHub {
+		return
+	}
+	sourceOrg := viper.GetString("GHMPKG_SOURCE_ORGANIZATION")
+	found, err := p.packumentVersion(logger, sourceOrg, packageName, version)
+	if err != nil {
+		logger.Warn("Failed to look up the deprecation of the source version", zap.Error(err))
+		return
+	}
+	message := found.Deprecated
+	if message == "" {
+		return
+	}
+
+	spec := fmt.Sprintf("@%s/%s@%s", strings.ToLower(owner), packageName, version)
+	deprecateCmd := exec.Command("npm", "deprecate", spec, message, "--registry="+registry, "--userconfig", npmrcPath)
+	deprecateCmd.Dir = workDir
+	deprecateCmd.Env = append(os.Environ(),
+		"HTTPS_PROXY=",
+		"npm_config_logs_dir="+workDir,
+	)
+	if err := runlog.Run(logger, deprecateCmd, p.PackageType, packageName, version, "npm-deprecate"); err != nil {
+		logger.Warn("Failed to deprecate the published version", zap.Error(err))
+		pterm.Warning.Println(fmt.Sprintf("⚠️ %s is deprecated in the source but could not be deprecated in the target", spec))
+		return
+	}
+	logger.Info("Deprecated published version", zap.String("message", message))
+	pterm.Info.Println(fmt.Sprintf("🚫 Deprecated %s: %s", spec, message))
+}
+
 func (p *NPMProvider) GetFetchUrl(logger *zap.Logger, owner, packageName, version string) (string, error) {
 	fetchUrl := *p.SourceRegistryUrl
 	fetchUrl.Path = path.Join(fetchUrl.Path, fmt.Sprintf("@%s", owner), packageName)
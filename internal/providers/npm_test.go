@@ -0,0 +1,103 @@
+package providers
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"go.uber.org/zap"
+)
+
+func TestRewriteTgz(t *testing.T) {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for _, entry := range []struct{ name, body string }{
+		{"package/index.js", "module.exports = 1"},
+		{"package/package.json", `{"name":"@acme/widgets"}`},
+		{"package/lib/package.json", `{"name":"@acme/nested"}`},
+	} {
+		tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.body))})
+		tw.Write([]byte(entry.body))
+	}
+	tw.Close()
+	tgz, _ := gzipBytes(archive.Bytes())
+
+	rewritten, err := rewriteTgz(tgz, func(content []byte) []byte {
+		return bytes.ReplaceAll(content, []byte("@acme/"), []byte("@octo/"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := gunzip(rewritten)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"package/index.js":         "module.exports = 1",
+		"package/package.json":     `{"name":"@octo/widgets"}`,
+		"package/lib/package.json": `{"name":"@acme/nested"}`,
+	}
+	reader := tar.NewReader(bytes.NewReader(content))
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(reader)
+		if string(body) != expected[header.Name] {
+			t.Errorf("%s = %q, expected %q", header.Name, body, expected[header.Name])
+		}
+		delete(expected, header.Name)
+	}
+	if len(expected) > 0 {
+		t.Errorf("missing entries: %v", expected)
+	}
+
+	if _, err := rewriteTgz(tgz[:0], nil); err == nil {
+		t.Error("rewriteTgz of an empty file did not return an error")
+	}
+}
+
+func TestPackumentVersionFetchesOncePerPackage(t *testing.T) {
+	requests := 0
+	versions := `"1.0.0":{"dist":{"tarball":"https://npm.pkg.github.com/download/@acme/widgets/1.0.0/widgets-1.0.0.tgz"}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintf(w, `{"name":"@acme/widgets","versions":{%s}}`, versions)
+	}))
+	defer server.Close()
+	p := &NPMProvider{BaseProvider: BaseProvider{SourceRegistryUrl: utils.ParseUrl(server.URL)}}
+	logger := zap.NewNop()
+
+	for range 3 {
+		found, err := p.packumentVersion(logger, "acme", "widgets", "1.0.0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(found.Dist.Tarball, "/widgets-1.0.0.tgz") {
+			t.Errorf("tarball = %q", found.Dist.Tarball)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("packument fetched %d times, want once", requests)
+	}
+
+	// Versions published since the packument was fetched fetch it again
+	versions += `,"1.1.0":{"deprecated":"use 2.x","dist":{"tarball":"https://npm.pkg.github.com/download/@acme/widgets/1.1.0/widgets-1.1.0.tgz"}}`
+	found, err := p.packumentVersion(logger, "acme", "widgets", "1.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Deprecated != "use 2.x" || requests != 2 {
+		t.Errorf("deprecated = %q after %d requests, want the new version after 2", found.Deprecated, requests)
+	}
+}
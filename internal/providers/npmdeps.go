@@ -0,0 +1,147 @@
+package providers
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/storage"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// npmManifest is the part of a package.json naming the packages it depends
+// on. Development dependencies are not installed by consumers and left out.
+type npmManifest struct {
+	Dependencies         map[string]string `json:"dependencies"`
+	PeerDependencies     map[string]string `json:"peerDependencies"`
+	OptionalDependencies map[string]string `json:"optionalDependencies"`
+}
+
+func (m npmManifest) names() []string {
+	var names []string
+	for _, dependencies := range []map[string]string{m.Dependencies, m.PeerDependencies, m.OptionalDependencies} {
+		for name := range dependencies {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// readPackageJson reads package/package.json out of an npm tarball
+func readPackageJson(tgz string) (*npmManifest, error) {
+	content, err := readPackageJsonContent(tgz)
+	if err != nil {
+		return nil, err
+	}
+	var manifest npmManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse package.json in %s: %w", tgz, err)
+	}
+	return &manifest, nil
+}
+
+// readPackageJsonContent returns package/package.json of an npm tarball as
+// it is
+func readPackageJsonContent(tgz string) ([]byte, error) {
+	file, err := os.Open(tgz)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", tgz, err)
+	}
+	defer gz.Close()
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("no package.json in %s", tgz)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", tgz, err)
+		}
+		if header.Name != "package/package.json" {
+			continue
+		}
+		content, err := io.ReadAll(archive)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read package.json in %s: %w", tgz, err)
+		}
+		return content, nil
+	}
+}
+
+// fetchManifests fetches the package.json of every version of a package
+// from the source registry
+func (p *NPMProvider) fetchManifests(logger *zap.Logger, owner, packageName string) (map[string]npmManifest, error) {
+	fetchUrl, err := p.GetFetchUrl(logger, owner, packageName, "")
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", fetchUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", viper.GetString("GHMPKG_SOURCE_TOKEN")))
+	resp, err := utils.HTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch package %s, status: %d", fetchUrl, resp.StatusCode)
+	}
+	var packument struct {
+		Versions map[string]npmManifest `json:"versions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&packument); err != nil {
+		return nil, fmt.Errorf("failed to parse package %s: %w", fetchUrl, err)
+	}
+	return packument.Versions, nil
+}
+
+// Dependencies returns the packages any of the versions of an npm package
+// depend on. The package.json of staged tarballs is read, versions that
+// are not staged are looked up in the source registry.
+func (p *NPMProvider) Dependencies(logger *zap.Logger, owner, packageName string, versions []string) ([]string, error) {
+	seen := map[string]bool{}
+	var dependencies []string
+	add := func(manifest npmManifest) {
+		for _, name := range manifest.names() {
+			if !seen[name] {
+				seen[name] = true
+				dependencies = append(dependencies, name)
+			}
+		}
+	}
+
+	var fetched map[string]npmManifest
+	packageDir := filepath.Join(storage.PackagesRoot, owner, "npm", packageName)
+	for _, version := range versions {
+		tgz := filepath.Join(packageDir, version, fmt.Sprintf("%s-%s.tgz", packageName, version))
+		if manifest, err := readPackageJson(tgz); err == nil {
+			add(*manifest)
+			continue
+		} else if !errors.Is(err, os.ErrNotExist) {
+			logger.Warn("Failed to read staged package.json, using the registry", zap.String("file", tgz), zap.Error(err))
+		}
+		if fetched == nil {
+			var err error
+			if fetched, err = p.fetchManifests(logger, owner, packageName); err != nil {
+				return nil, err
+			}
+		}
+		add(fetched[version])
+	}
+	return dependencies, nil
+}
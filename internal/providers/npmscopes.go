@@ -0,0 +1,189 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/spf13/viper"
+)
+
+// ScopeMapping maps the npm scopes of the source to the scopes packages and
+// dependencies are published with, without their @
+type ScopeMapping map[string]string
+
+// normalizeScope returns a scope without its @ in lowercase, like npm keeps
+// scopes
+func normalizeScope(scope string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(scope), "@"))
+}
+
+// parseScopeMapping parses comma separated @source=@target pairs. Several
+// source scopes can map to the same target, and a scope mapped to itself is
+// kept.
+func parseScopeMapping(value string) (ScopeMapping, error) {
+	mapping := ScopeMapping{}
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		source, target, ok := strings.Cut(pair, "=")
+		source, target = normalizeScope(source), normalizeScope(target)
+		if !ok || source == "" || target == "" || strings.ContainsAny(source+target, "/@ ") {
+			return nil, fmt.Errorf("invalid npm scope mapping %q, expected @source=@target", strings.TrimSpace(pair))
+		}
+		if existing, ok := mapping[source]; ok && existing != target {
+			return nil, fmt.Errorf("npm scope @%s is mapped to both @%s and @%s", source, existing, target)
+		}
+		mapping[source] = target
+	}
+	return mapping, nil
+}
+
+// NpmScopes returns the scope mapping of GHMPKG_NPM_SCOPES. The scope of the
+// source organization maps to the target organization unless the mapping
+// says otherwise.
+func NpmScopes() (ScopeMapping, error) {
+	mapping, err := parseScopeMapping(viper.GetString("GHMPKG_NPM_SCOPES"))
+	if err != nil {
+		return nil, err
+	}
+	if sourceOrg := normalizeScope(viper.GetString("GHMPKG_SOURCE_ORGANIZATION")); sourceOrg != "" {
+		if _, ok := mapping[sourceOrg]; !ok {
+			mapping[sourceOrg] = normalizeScope(viper.GetString("GHMPKG_TARGET_ORGANIZATION"))
+		}
+	}
+	return mapping, nil
+}
+
+// Replacer rewrites the mapped scopes of package names in a single pass, so
+// scopes swapped with each other aren't replaced twice
+func (m ScopeMapping) Replacer() *strings.Replacer {
+	sources := make([]string, 0, len(m))
+	for source := range m {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	var pairs []string
+	for _, source := range sources {
+		if target := m[source]; target != source {
+			pairs = append(pairs, "@"+source+"/", "@"+target+"/")
+		}
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// Identity reports whether the mapping leaves every scope as it is
+func (m ScopeMapping) Identity() bool {
+	for source, target := range m {
+		if source != target {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	npmPackagesMu sync.Mutex
+	npmPackages   map[string]bool
+)
+
+// SetNpmPackages sets the npm packages of the migration, dependencies on
+// those that are unscoped are scoped together with the packages
+func SetNpmPackages(names []string) {
+	npmPackagesMu.Lock()
+	defer npmPackagesMu.Unlock()
+	npmPackages = map[string]bool{}
+	for _, name := range names {
+		if !strings.HasPrefix(name, "@") {
+			npmPackages[name] = true
+		}
+	}
+}
+
+// currentNpmPackages returns the unscoped npm packages of the migration
+func currentNpmPackages() map[string]bool {
+	npmPackagesMu.Lock()
+	defer npmPackagesMu.Unlock()
+	return npmPackages
+}
+
+// npmScoping are the fields of a package.json naming packages
+type npmScoping struct {
+	Name                 string            `json:"name"`
+	Dependencies         map[string]string `json:"dependencies"`
+	DevDependencies      map[string]string `json:"devDependencies"`
+	PeerDependencies     map[string]string `json:"peerDependencies"`
+	OptionalDependencies map[string]string `json:"optionalDependencies"`
+}
+
+// aliases returns the dependencies on unscoped packages of the migration
+// with the range they are aliased to, local, git and already aliased
+// dependencies are left alone
+func (s npmScoping) aliases(scope string, internal map[string]bool) map[string][2]string {
+	aliases := map[string][2]string{}
+	for _, dependencies := range []map[string]string{s.Dependencies, s.DevDependencies, s.PeerDependencies, s.OptionalDependencies} {
+		for name, version := range dependencies {
+			if !internal[name] || strings.ContainsAny(version, ":/") {
+				continue
+			}
+			if version == "" {
+				version = "*"
+			}
+			aliases[name] = [2]string{dependencies[name], fmt.Sprintf("npm:@%s/%s@%s", scope, name, version)}
+		}
+	}
+	return aliases
+}
+
+// scopePackageJson gives an unscoped package the scope of the target
+// organization, which GitHub Packages requires. Dependencies on unscoped
+// packages of the migration are aliased to their scoped names, so code
+// keeps requiring them by their old names. Content that can't be parsed is
+// returned unchanged.
+func scopePackageJson(content []byte, scope string, internal map[string]bool) []byte {
+	var manifest npmScoping
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return content
+	}
+	if manifest.Name != "" && !strings.HasPrefix(manifest.Name, "@") {
+		name := regexp.MustCompile(`("name"\s*:\s*)` + regexp.QuoteMeta(strconv.Quote(manifest.Name)))
+		if match := name.FindSubmatchIndex(content); match != nil {
+			scoped := fmt.Sprintf("%s%q", content[match[2]:match[3]], "@"+scope+"/"+manifest.Name)
+			content = append(append(append([]byte{}, content[:match[0]]...), scoped...), content[match[1]:]...)
+		}
+	}
+	for name, alias := range manifest.aliases(scope, internal) {
+		dependency := regexp.MustCompile(`(` + regexp.QuoteMeta(strconv.Quote(name)) + `\s*:\s*)` + regexp.QuoteMeta(strconv.Quote(alias[0])))
+		content = dependency.ReplaceAll(content, []byte("${1}"+strings.ReplaceAll(strconv.Quote(alias[1]), "$", "$$")))
+	}
+	return content
+}
+
+// npmScope returns the scope unscoped packages are given, the target
+// organization when publishing to GitHub Packages
+func npmScope() (string, bool) {
+	if registries.TargetName() != registries.GitHub {
+		return "", false
+	}
+	return strings.ToLower(viper.GetString("GHMPKG_TARGET_ORGANIZATION")), true
+}
+
+// needsScoping reports whether a package.json names unscoped packages that
+// are scoped for GitHub Packages
+func needsScoping(content []byte) bool {
+	scope, ok := npmScope()
+	if !ok {
+		return false
+	}
+	var manifest npmScoping
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return false
+	}
+	return (manifest.Name != "" && !strings.HasPrefix(manifest.Name, "@")) || len(manifest.aliases(scope, currentNpmPackages())) > 0
+}
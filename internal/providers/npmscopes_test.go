@@ -0,0 +1,59 @@
+package providers
+
+import "testing"
+
+func TestParseScopeMapping(t *testing.T) {
+	mapping, err := parseScopeMapping("@legacy=@acme, internal=@Acme ,@shared=@shared,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ScopeMapping{"legacy": "acme", "internal": "acme", "shared": "shared"}
+	if len(mapping) != len(expected) {
+		t.Fatalf("parseScopeMapping() = %v, expected %v", mapping, expected)
+	}
+	for source, target := range expected {
+		if mapping[source] != target {
+			t.Errorf("@%s maps to @%s, expected @%s", source, mapping[source], target)
+		}
+	}
+
+	for _, value := range []string{"@legacy", "@legacy=", "@a/b=@c", "@a=@b,@a=@c"} {
+		if _, err := parseScopeMapping(value); err == nil {
+			t.Errorf("parseScopeMapping(%q) succeeded", value)
+		}
+	}
+}
+
+func TestScopeMappingReplacer(t *testing.T) {
+	mapping := ScopeMapping{"legacy": "acme", "internal": "acme", "shared": "shared", "a": "b", "b": "a"}
+	content := `{"name":"@legacy/ui","dependencies":{"@internal/core":"1.0.0","@shared/icons":"2.0.0","@a/x":"1","@b/y":"1","@types/node":"20"}}`
+	expected := `{"name":"@acme/ui","dependencies":{"@acme/core":"1.0.0","@shared/icons":"2.0.0","@b/x":"1","@a/y":"1","@types/node":"20"}}`
+	if actual := mapping.Replacer().Replace(content); actual != expected {
+		t.Errorf("Replace() = %s, expected %s", actual, expected)
+	}
+	if mapping.Identity() || !(ScopeMapping{"shared": "shared"}).Identity() {
+		t.Error("Identity() is wrong")
+	}
+}
+
+func TestScopePackageJson(t *testing.T) {
+	internal := map[string]bool{"core": true, "icons": true}
+	content := `{
+  "name": "ui",
+  "dependencies": {"core": "^1.2.0", "icons": "", "left-pad": "1.3.0", "@acme/theme": "2.0.0"},
+  "devDependencies": {"core": "file:../core"}
+}`
+	expected := `{
+  "name": "@acme/ui",
+  "dependencies": {"core": "npm:@acme/core@^1.2.0", "icons": "npm:@acme/icons@*", "left-pad": "1.3.0", "@acme/theme": "2.0.0"},
+  "devDependencies": {"core": "file:../core"}
+}`
+	if actual := string(scopePackageJson([]byte(content), "acme", internal)); actual != expected {
+		t.Errorf("scopePackageJson() = %s, expected %s", actual, expected)
+	}
+
+	scoped := `{"name":"@acme/ui","dependencies":{"left-pad":"1.3.0"}}`
+	if actual := string(scopePackageJson([]byte(scoped), "acme", internal)); actual != scoped {
+		t.Errorf("scopePackageJson() changed a scoped package: %s", actual)
+	}
+}
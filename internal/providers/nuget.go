@@ -1,13 +1,21 @@
 package providers
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
 	"fmt"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
 
 	"github.com/google/go-github/v62/github"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/internal/runlog"
 	"github.com/mark-humane/gh-migrate-packages/internal/utils"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
@@ -15,11 +23,14 @@ import (
 
 type NugetProvider struct {
 	BaseProvider
+	mappingOnce sync.Once
+	mapping     PackageMapping
+	mappingErr  error
 }
 
 func NewNugetProvider(logger *zap.Logger, packageType string) Provider {
 	return &NugetProvider{
-		BaseProvider: NewBaseProvider(packageType, "", "", false),
+		BaseProvider: NewBaseProvider(packageType, "", viper.GetString("GHMPKG_TARGET_HOSTNAME"), false),
 	}
 }
 
@@ -29,9 +40,25 @@ func (p *NugetProvider) Connect(logger *zap.Logger) error {
 
 func (p *NugetProvider) FetchPackageFiles(logger *zap.Logger, owner, repository, packageType, packageName, version string, metadata *github.PackageMetadata) ([]string, ResultState, error) {
 	logger.Info("Loading package files from Nuget package registry")
-	var filenames []string
-	filenames = append(filenames, fmt.Sprintf("%s-%s.nupkg", packageName, version))
-	return filenames, Success, nil
+	files, err := p.VersionFiles(logger, owner, packageName, version)
+	if err != nil {
+		// The package itself can still be migrated without its symbols
+		logger.Warn("Failed to list version files, migrating the package without symbols",
+			zap.String("package_name", packageName), zap.String("package_version", version), zap.Error(err))
+	}
+	return nugetFiles(packageName, version, files), Success, nil
+}
+
+// nugetFiles returns the local filenames of a version, the .nupkg comes
+// first so it is published before the .snupkg symbol package it belongs to
+func nugetFiles(packageName, version string, files []string) []string {
+	filenames := []string{fmt.Sprintf("%s-%s.nupkg", packageName, version)}
+	for _, file := range files {
+		if registries.IsSymbolPackage(file) {
+			return append(filenames, fmt.Sprintf("%s-%s.snupkg", packageName, version))
+		}
+	}
+	return filenames
 }
 
 func (p *NugetProvider) Export(logger *zap.Logger, owner string, content interface{}) error {
@@ -61,16 +88,92 @@ func (p *NugetProvider) Rename(logger *zap.Logger, filename string) error {
 		return nil
 	}
 	
-	zipCmd := exec.Command("zip", "-d", filename, "_rels/.rels", "\\[Content_Types\\].xml")
-	if err := zipCmd.Run(); err != nil {
-		if err.Error() == "exit status 12" {
-			// ignore the error if the files are not found
-			logger.Info("No files to remove from zip archive")
-		} else {
-			return fmt.Errorf("failed to remove files from %s: %w", filename, err)
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	rewritten, err := removeZipEntries(content, "_rels/.rels", "[Content_Types].xml")
+	if err != nil {
+		return fmt.Errorf("failed to remove files from %s: %w", filename, err)
+	}
+	if rewritten == nil {
+		// ignore the error if the files are not found
+		logger.Info("No files to remove from zip archive")
+		return nil
+	}
+	return utils.ReplaceFile(filename, rewritten)
+}
+
+// removeZipEntries returns a zip archive without the named entries, or nil
+// when it has none of them
+func removeZipEntries(content []byte, names ...string) ([]byte, error) {
+	reader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	writer := zip.NewWriter(&out)
+	removed := false
+	for _, file := range reader.File {
+		if slices.Contains(names, file.Name) {
+			removed = true
+			continue
+		}
+		if err := writer.Copy(file); err != nil {
+			return nil, err
 		}
 	}
-	return nil
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	if !removed {
+		return nil, nil
+	}
+	return out.Bytes(), nil
+}
+
+// GprPath is where the gpr tool publishing nuget packages is installed
+func GprPath() string {
+	tool := filepath.Join("tool", "gpr")
+	if runtime.GOOS == "windows" {
+		return tool + ".exe"
+	}
+	return tool
+}
+
+// Prepare removes the packaging files that stop the package from being
+// republished under another owner, it returns the package to publish.
+// Symbol packages are published as they are.
+func (p *NugetProvider) Prepare(logger *zap.Logger, packageDir, packageName, version, filename string) (string, error) {
+	if registries.IsSymbolPackage(filename) {
+		return filepath.Join(packageDir, filename), nil
+	}
+	nupkg := filepath.Join(packageDir, fmt.Sprintf("%s-%s.nupkg", packageName, version))
+	if err := p.Rename(logger, nupkg); err != nil {
+		return "", fmt.Errorf("failed to rename %s: %w", nupkg, err)
+	}
+
+	// Packages renamed with the package mapping are published under their
+	// new ID, and so are the dependencies on them
+	p.mappingOnce.Do(func() {
+		p.mapping, p.mappingErr = LoadPackageMapping()
+	})
+	if p.mappingErr != nil {
+		return "", p.mappingErr
+	}
+	if len(p.mapping) > 0 {
+		renames, err := rewriteNupkgIDs(nupkg, packageName, version, p.mapping)
+		if err != nil {
+			return "", fmt.Errorf("failed to rewrite the nuspec of %s: %w", nupkg, err)
+		}
+		for _, rename := range renames {
+			logger.Info("Rewrote NuGet package ID",
+				zap.String("element", rename.Element),
+				zap.String("sourceId", rename.Source),
+				zap.String("targetId", rename.Target))
+		}
+	}
+	return nupkg, nil
 }
 
 func (p *NugetProvider) Upload(logger *zap.Logger, owner, repository, packageType, packageName, version, filename string) (ResultState, error) {
@@ -80,31 +183,27 @@ func (p *NugetProvider) Upload(logger *zap.Logger, owner, repository, packageTyp
 			return p.GetUploadUrl(logger, owner, repository, packageName, version, filename)
 		},
 		func(uploadUrl, packageDir string) (ResultState, error) {
-			nupkg := filepath.Join(packageDir, fmt.Sprintf("%s-%s.nupkg", packageName, version))
-
-			if err := p.Rename(logger, nupkg); err != nil {
-				return Failed, fmt.Errorf("failed to rename %s: %w", nupkg, err)
+			nupkg, err := p.Prepare(logger, packageDir, packageName, version, filename)
+			if err != nil {
+				return Failed, err
+			}
+			if registries.IsSymbolPackage(nupkg) {
+				return p.pushSymbols(logger, owner, nupkg)
 			}
 
-			uploadUrl, err := p.GetUploadUrl(logger, owner, repository, packageName, version, filename)
+			uploadUrl, err = p.GetUploadUrl(logger, owner, repository, packageName, version, filename)
 			if err != nil {
 				logger.Error("Error getting upload URL", zap.Error(err))
 				return Failed, err
 			}
 			// Run nuget publish
-			pushCmd := exec.Command("./tool/gpr", "push", nupkg, "--repository", uploadUrl, "-k", viper.GetString("GHMPKG_TARGET_TOKEN"))
+			pushCmd := exec.Command(GprPath(), "push", nupkg, "--repository", uploadUrl, "-k", viper.GetString("GHMPKG_TARGET_TOKEN"))
 
-			// // Capture output to nugetlog file
-			logFile, err := os.Create(filepath.Join(packageDir, "nugetlog"))
-			if err != nil {
-				return Failed, fmt.Errorf("failed to create log file: %w", err)
-			}
-			defer logFile.Close()
-
-			pushCmd.Stdout = logFile
-			pushCmd.Stderr = logFile
-
-			if err := pushCmd.Run(); err != nil {
+			if err := runlog.Run(logger, pushCmd, p.PackageType, packageName, version, "gpr-push"); err != nil {
+				// The target rejects versions it already has with 409 Conflict
+				if isConflict(err) {
+					return p.resolveConflict(logger, owner, packageName, version, filename, nupkg, err)
+				}
 				return Failed, fmt.Errorf("failed to publish package: %w", err)
 			}
 
@@ -113,6 +212,33 @@ func (p *NugetProvider) Upload(logger *zap.Logger, owner, repository, packageTyp
 	)
 }
 
+// pushSymbols pushes a .snupkg to the symbol endpoint of the target
+// registry, registries without one keep only the .nupkg
+func (p *NugetProvider) pushSymbols(logger *zap.Logger, owner, snupkg string) (ResultState, error) {
+	token := viper.GetString("GHMPKG_TARGET_TOKEN")
+	authorization := "Basic " + base64.StdEncoding.EncodeToString([]byte(owner+":"+token))
+	serviceIndex := *p.TargetRegistryUrl
+	serviceIndex.Path = path.Join(serviceIndex.Path, owner, "index.json")
+
+	pushUrl, err := registries.NugetSymbolEndpoint(serviceIndex.String(), authorization)
+	if err != nil {
+		return Failed, fmt.Errorf("failed to find the symbol endpoint: %w", err)
+	}
+	if pushUrl == "" {
+		logger.Warn("Target registry does not accept symbol packages", zap.String("file", filepath.Base(snupkg)))
+		return Skipped, nil
+	}
+	logger.Info("Publishing symbols", zap.String("url", pushUrl))
+	published, err := registries.NugetPush(pushUrl, authorization, token, snupkg)
+	if err != nil {
+		return Failed, fmt.Errorf("failed to publish symbols: %w", err)
+	}
+	if !published {
+		return Skipped, nil
+	}
+	return Success, nil
+}
+
 func (p *NugetProvider) GetFetchUrl(logger *zap.Logger, owner, packageName, version string) (string, error) {
 	fetchUrl := *p.SourceRegistryUrl
 	fetchUrl.Path = path.Join(fetchUrl.Path, owner, "download", packageName, version)
@@ -0,0 +1,70 @@
+package providers
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRemoveZipEntries(t *testing.T) {
+	var nupkg bytes.Buffer
+	zw := zip.NewWriter(&nupkg)
+	for _, name := range []string{"_rels/.rels", "Acme.Core.nuspec", "[Content_Types].xml", "lib/net8.0/Acme.Core.dll"} {
+		w, _ := zw.Create(name)
+		w.Write([]byte(name))
+	}
+	zw.Close()
+
+	rewritten, err := removeZipEntries(nupkg.Bytes(), "_rels/.rels", "[Content_Types].xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(rewritten), int64(len(rewritten)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+	if strings.Join(names, ",") != "Acme.Core.nuspec,lib/net8.0/Acme.Core.dll" {
+		t.Errorf("entries = %v, expected the nuspec and the dll", names)
+	}
+
+	if again, err := removeZipEntries(rewritten, "_rels/.rels"); err != nil || again != nil {
+		t.Errorf("removeZipEntries without matching entries = %d bytes, %v, expected nil", len(again), err)
+	}
+}
+
+func TestRewriteNuspec(t *testing.T) {
+	nuspec := `<package>
+  <metadata>
+    <id>Acme.Core</id>
+    <version>2.0.0</version>
+    <repository type="git" url="https://github.com/acme/core" />
+    <dependencies>
+      <group targetFramework="net8.0">
+        <dependency id="acme.logging" version="1.4.0" exclude="Build" />
+        <dependency id="Newtonsoft.Json" version="13.0.3" />
+      </group>
+    </dependencies>
+  </metadata>
+</package>`
+	mapping := PackageMapping{"acme.core": "Octo.Core", "acme.logging": "Octo.Logging"}
+
+	got, renames := rewriteNuspec([]byte(nuspec), mapping)
+	for _, want := range []string{
+		"<id>Octo.Core</id>",
+		`<dependency id="Octo.Logging" version="1.4.0" exclude="Build" />`,
+		`<dependency id="Newtonsoft.Json" version="13.0.3" />`,
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("rewritten nuspec is missing %s:\n%s", want, got)
+		}
+	}
+	want := []nuspecRename{{"id", "Acme.Core", "Octo.Core"}, {"dependency", "acme.logging", "Octo.Logging"}}
+	if len(renames) != len(want) || renames[0] != want[0] || renames[1] != want[1] {
+		t.Errorf("renames = %v, want %v", renames, want)
+	}
+}
@@ -0,0 +1,135 @@
+package providers
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/failures"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// What is done when nuget.pkg.github.com rejects a version that already
+// exists with 409 Conflict
+const (
+	// NugetConflictSkip skips versions the target has with the same
+	// content and fails those whose content differs
+	NugetConflictSkip = "skip"
+	// NugetConflictFail fails every version the target already has
+	NugetConflictFail = "fail"
+)
+
+// ErrContentConflict is returned for a version the target already has with
+// other content than the package being published
+var ErrContentConflict = errors.New("version exists in the target with conflicting content")
+
+// NugetConflictPolicy returns the conflict policy of GHMPKG_NUGET_CONFLICT,
+// skip when unset
+func NugetConflictPolicy() (string, error) {
+	policy := strings.ToLower(strings.TrimSpace(viper.GetString("GHMPKG_NUGET_CONFLICT")))
+	switch policy {
+	case "":
+		return NugetConflictSkip, nil
+	case NugetConflictSkip, NugetConflictFail:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid NuGet conflict policy %q, expected skip or fail", policy)
+}
+
+// nupkgPackaging reports whether a nupkg entry belongs to the packaging
+// rather than the package, registries and the rename rewrite these
+func nupkgPackaging(name string) bool {
+	return name == "[Content_Types].xml" || name == ".signature.p7s" ||
+		strings.HasPrefix(name, "_rels/") || strings.HasPrefix(name, "package/")
+}
+
+// nupkgDigest hashes the names and contents of the entries of a nupkg
+// except its packaging files, so a package republished under another owner
+// has the digest of its source
+func nupkgDigest(nupkg string) (string, error) {
+	reader, err := zip.OpenReader(nupkg)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	files := slices.Clone(reader.File)
+	slices.SortFunc(files, func(a, b *zip.File) int { return strings.Compare(a.Name, b.Name) })
+	digest := sha256.New()
+	for _, file := range files {
+		if nupkgPackaging(file.Name) || strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+		entry, err := file.Open()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(digest, "%s\x00%d\x00", file.Name, file.UncompressedSize64)
+		_, err = io.Copy(digest, entry)
+		entry.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", file.Name, err)
+		}
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// isConflict reports whether a push failed because the version exists
+func isConflict(err error) bool {
+	return err != nil && failures.Classify(err) == failures.Conflict
+}
+
+// resolveConflict decides what a push rejected because the target has the
+// version becomes. The version in the target is downloaded and compared
+// with the package, a version with the same content is skipped and one
+// with different content fails loudly as it can't be replaced by a rerun.
+func (p *NugetProvider) resolveConflict(logger *zap.Logger, owner, packageName, version, filename, nupkg string, pushErr error) (ResultState, error) {
+	fields := []zap.Field{zap.String("filename", filename)}
+	policy, err := NugetConflictPolicy()
+	if err != nil {
+		return Failed, err
+	}
+	if policy == NugetConflictFail {
+		return Failed, fmt.Errorf("%s %s already exists in the target: %w", packageName, version, pushErr)
+	}
+
+	local, err := nupkgDigest(nupkg)
+	if err != nil {
+		return Failed, fmt.Errorf("failed to hash %s: %w", filepath.Base(nupkg), err)
+	}
+	dir, err := os.MkdirTemp("", "nuget-conflict-*")
+	if err != nil {
+		return Failed, err
+	}
+	defer os.RemoveAll(dir)
+	targetUrl := *p.TargetRegistryUrl
+	targetUrl.Path = path.Join(targetUrl.Path, owner, "download", packageName, version, filename)
+	published := filepath.Join(dir, filename)
+	if err := utils.DownloadFile(targetUrl.String(), published, viper.GetString("GHMPKG_TARGET_TOKEN")); err != nil {
+		logger.Warn("Version exists in the target, its content could not be compared", append(fields, zap.Error(err))...)
+		return Skipped, nil
+	}
+	remote, err := nupkgDigest(published)
+	if err != nil {
+		logger.Warn("Version exists in the target, its content could not be compared", append(fields, zap.Error(err))...)
+		return Skipped, nil
+	}
+	if remote == local {
+		logger.Info("Version exists in the target with the same content, skipping", fields...)
+		return Skipped, nil
+	}
+
+	logger.Error("Version exists in the target with different content", append(fields, zap.String("sourceDigest", local), zap.String("targetDigest", remote))...)
+	pterm.Error.Println(fmt.Sprintf("❌ Conflict: %s %s exists in the target with different content", packageName, version))
+	return Failed, fmt.Errorf("%s %s: %w (source sha256:%s, target sha256:%s)", packageName, version, ErrContentConflict, local, remote)
+}
@@ -0,0 +1,76 @@
+package providers
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// writeNupkg writes a nupkg with entries in the given order
+func writeNupkg(t *testing.T, name string, entries ...[2]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	zw := zip.NewWriter(file)
+	for _, entry := range entries {
+		w, _ := zw.Create(entry[0])
+		w.Write([]byte(entry[1]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNupkgDigest(t *testing.T) {
+	source := writeNupkg(t, "source.nupkg",
+		[2]string{"_rels/.rels", "source rels"},
+		[2]string{"Acme.Core.nuspec", "<package/>"},
+		[2]string{"[Content_Types].xml", "types"},
+		[2]string{"package/services/metadata/core-properties/1.psmdcp", "source"},
+		[2]string{"lib/net8.0/Acme.Core.dll", "dll"})
+	// Republished without the packaging files and with its entries reordered
+	same := writeNupkg(t, "same.nupkg",
+		[2]string{"lib/net8.0/Acme.Core.dll", "dll"},
+		[2]string{"Acme.Core.nuspec", "<package/>"},
+		[2]string{"package/services/metadata/core-properties/2.psmdcp", "target"})
+	rebuilt := writeNupkg(t, "rebuilt.nupkg",
+		[2]string{"Acme.Core.nuspec", "<package/>"},
+		[2]string{"lib/net8.0/Acme.Core.dll", "rebuilt dll"})
+
+	digests := map[string]string{}
+	for _, path := range []string{source, same, rebuilt} {
+		digest, err := nupkgDigest(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests[filepath.Base(path)] = digest
+	}
+	if digests["source.nupkg"] != digests["same.nupkg"] {
+		t.Errorf("packages with the same content have different digests")
+	}
+	if digests["source.nupkg"] == digests["rebuilt.nupkg"] {
+		t.Errorf("packages with different content have the same digest")
+	}
+}
+
+func TestNugetConflictPolicy(t *testing.T) {
+	t.Cleanup(func() { viper.Set("GHMPKG_NUGET_CONFLICT", "") })
+	for value, want := range map[string]string{"": NugetConflictSkip, "skip": NugetConflictSkip, "FAIL": NugetConflictFail} {
+		viper.Set("GHMPKG_NUGET_CONFLICT", value)
+		if got, err := NugetConflictPolicy(); err != nil || got != want {
+			t.Errorf("NugetConflictPolicy() with %q = %q, %v, want %q", value, got, err, want)
+		}
+	}
+	viper.Set("GHMPKG_NUGET_CONFLICT", "overwrite")
+	if _, err := NugetConflictPolicy(); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
@@ -0,0 +1,183 @@
+package providers
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/runlog"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/spf13/viper"
+)
+
+// NugetRenamesReport lists the package and dependency IDs rewritten in
+// nuspec files, it is written to the run's log directory
+const NugetRenamesReport = "nuget-renames.csv"
+
+var nugetRenamesHeader = []string{"timestamp", "package_name", "package_version", "element", "source_id", "target_id"}
+
+// PackageMapping maps source package names to the names they are published
+// under, NuGet IDs are matched case insensitively
+type PackageMapping map[string]string
+
+// LoadPackageMapping reads the mapping file in GHMPKG_PACKAGE_MAPPING, one
+// source=target pair per line. Lines starting with # are comments.
+func LoadPackageMapping() (PackageMapping, error) {
+	mapping := PackageMapping{}
+	mappingFile := viper.GetString("GHMPKG_PACKAGE_MAPPING")
+	if mappingFile == "" {
+		return mapping, nil
+	}
+	file, err := os.Open(mappingFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open package mapping: %w", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		source, target, ok := strings.Cut(line, "=")
+		source, target = strings.TrimSpace(source), strings.TrimSpace(target)
+		if !ok || source == "" || target == "" {
+			return nil, fmt.Errorf("invalid package mapping %q, expected source=target", line)
+		}
+		mapping[strings.ToLower(source)] = target
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read package mapping: %w", err)
+	}
+	return mapping, nil
+}
+
+// Lookup returns the target name of a package
+func (m PackageMapping) Lookup(name string) (string, bool) {
+	target, ok := m[strings.ToLower(name)]
+	return target, ok
+}
+
+// TargetPackageName returns the name a package is published under in the
+// target organization, container names are sanitized and NuGet packages
+// can be renamed with the package mapping
+func TargetPackageName(packageType, packageName string) string {
+	switch packageType {
+	case "container":
+		return sanitizeContainerName(packageName)
+	case "nuget":
+		if mapping, err := LoadPackageMapping(); err == nil {
+			if name, ok := mapping.Lookup(packageName); ok {
+				return name
+			}
+		}
+	}
+	return packageName
+}
+
+// nuspecRename is one ID rewritten in a nuspec
+type nuspecRename struct {
+	Element string
+	Source  string
+	Target  string
+}
+
+var (
+	nuspecID           = regexp.MustCompile(`(<id>\s*)([^<\s]+)(\s*</id>)`)
+	nuspecDependencyID = regexp.MustCompile(`(<dependency\b[^>]*?\bid=")([^"]+)(")`)
+)
+
+// rewriteNuspec replaces the package ID and the dependency IDs found in the
+// mapping, it returns the new nuspec and what was changed
+func rewriteNuspec(content []byte, mapping PackageMapping) ([]byte, []nuspecRename) {
+	var renames []nuspecRename
+	replace := func(pattern *regexp.Regexp, element string, content []byte) []byte {
+		return pattern.ReplaceAllFunc(content, func(match []byte) []byte {
+			groups := pattern.FindSubmatch(match)
+			target, ok := mapping.Lookup(string(groups[2]))
+			if !ok || target == string(groups[2]) {
+				return match
+			}
+			renames = append(renames, nuspecRename{Element: element, Source: string(groups[2]), Target: target})
+			return []byte(string(groups[1]) + target + string(groups[3]))
+		})
+	}
+	content = replace(nuspecID, "id", content)
+	content = replace(nuspecDependencyID, "dependency", content)
+	return content, renames
+}
+
+// rewriteNupkgIDs rewrites the nuspec inside a .nupkg for the package
+// mapping and adds the changes to the renames report
+func rewriteNupkgIDs(nupkg, packageName, version string, mapping PackageMapping) ([]nuspecRename, error) {
+	reader, err := zip.OpenReader(nupkg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", nupkg, err)
+	}
+	defer reader.Close()
+
+	var nuspec *zip.File
+	for _, file := range reader.File {
+		if !strings.Contains(file.Name, "/") && strings.EqualFold(path.Ext(file.Name), ".nuspec") {
+			nuspec = file
+			break
+		}
+	}
+	if nuspec == nil {
+		return nil, fmt.Errorf("no nuspec found in %s", nupkg)
+	}
+	rc, err := nuspec.Open()
+	if err != nil {
+		return nil, err
+	}
+	content, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	rewritten, renames := rewriteNuspec(content, mapping)
+	if len(renames) == 0 {
+		return nil, nil
+	}
+
+	var out bytes.Buffer
+	writer := zip.NewWriter(&out)
+	for _, file := range reader.File {
+		if file != nuspec {
+			if err := writer.Copy(file); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		header := file.FileHeader
+		w, err := writer.CreateHeader(&header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(rewritten); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	if err := utils.ReplaceFile(nupkg, out.Bytes()); err != nil {
+		return nil, err
+	}
+
+	for _, rename := range renames {
+		if err := runlog.AppendCSV(NugetRenamesReport, nugetRenamesHeader, []string{
+			time.Now().UTC().Format(time.RFC3339), packageName, version, rename.Element, rename.Source, rename.Target,
+		}); err != nil {
+			return renames, fmt.Errorf("failed to update %s: %w", NugetRenamesReport, err)
+		}
+	}
+	return renames, nil
+}
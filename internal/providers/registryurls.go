@@ -0,0 +1,63 @@
+package providers
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/spf13/viper"
+)
+
+// registryUrlTypes are the package types whose registry URLs can be set
+var registryUrlTypes = []string{"npm", "maven", "nuget", "rubygems", "container"}
+
+// RegistryUrlKey returns the setting with the registry URL of a package type
+// on the source or target, e.g. GHMPKG_TARGET_NPM_REGISTRY_URL
+func RegistryUrlKey(side, packageType string) string {
+	return fmt.Sprintf("GHMPKG_%s_%s_REGISTRY_URL", strings.ToUpper(side), strings.ToUpper(packageType))
+}
+
+// parseRegistryUrl parses a registry URL setting, which needs a scheme and
+// a host. Paths are kept for registries served below a path, like
+// Artifactory virtual repositories.
+func parseRegistryUrl(value string) (*url.URL, error) {
+	parsed, err := url.Parse(strings.TrimSpace(value))
+	if err != nil {
+		return nil, err
+	}
+	if (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return nil, fmt.Errorf("%q is not an http(s) URL", value)
+	}
+	if !strings.HasSuffix(parsed.Path, "/") {
+		parsed.Path += "/"
+	}
+	return parsed, nil
+}
+
+// registryUrl returns the registry URL set for a package type, or the URL
+// derived from the hostname when none is set
+func registryUrl(side, packageType, derived string) *url.URL {
+	if value := viper.GetString(RegistryUrlKey(side, packageType)); value != "" {
+		if parsed, err := parseRegistryUrl(value); err == nil {
+			return parsed
+		}
+	}
+	return utils.ParseUrl(derived)
+}
+
+// CheckRegistryUrls validates the registry URLs set for the source and
+// target package types
+func CheckRegistryUrls() error {
+	for _, side := range []string{"source", "target"} {
+		for _, packageType := range registryUrlTypes {
+			key := RegistryUrlKey(side, packageType)
+			if value := viper.GetString(key); value != "" {
+				if _, err := parseRegistryUrl(value); err != nil {
+					return fmt.Errorf("invalid %s: %w", key, err)
+				}
+			}
+		}
+	}
+	return nil
+}
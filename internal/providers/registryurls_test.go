@@ -0,0 +1,24 @@
+package providers
+
+import "testing"
+
+func TestParseRegistryUrl(t *testing.T) {
+	for value, expected := range map[string]string{
+		"https://artifactory.example.com/api/npm/npm":  "https://artifactory.example.com/api/npm/npm/",
+		"https://containers.ghes.example.com":          "https://containers.ghes.example.com/",
+		"http://nexus.internal:8081/repository/maven/": "http://nexus.internal:8081/repository/maven/",
+	} {
+		parsed, err := parseRegistryUrl(value)
+		if err != nil {
+			t.Errorf("parseRegistryUrl(%q) failed: %v", value, err)
+		} else if parsed.String() != expected {
+			t.Errorf("parseRegistryUrl(%q) = %s, expected %s", value, parsed, expected)
+		}
+	}
+
+	for _, value := range []string{"ghcr.io", "npm.pkg.github.com/acme", "ftp://example.com", "https://"} {
+		if _, err := parseRegistryUrl(value); err == nil {
+			t.Errorf("parseRegistryUrl(%q) succeeded", value)
+		}
+	}
+}
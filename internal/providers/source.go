@@ -0,0 +1,61 @@
+package providers
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"go.uber.org/zap"
+)
+
+// sourceProvider downloads files from a registry other than GitHub Packages
+// into the usual local layout, everything else is left to the provider of
+// the package type
+type sourceProvider struct {
+	Provider
+	base   BaseProvider
+	source registries.Source
+}
+
+// WithSource makes a provider download from a source registry, it returns
+// the provider unchanged when packages come from GitHub Packages
+func WithSource(provider Provider, source registries.Source) Provider {
+	if source == nil {
+		return provider
+	}
+	return &sourceProvider{
+		Provider: provider,
+		base:     BaseProvider{PackageType: provider.GetPackageType()},
+		source:   source,
+	}
+}
+
+func (p *sourceProvider) Download(logger *zap.Logger, owner, repository, packageType, packageName, version, filename string) (ResultState, error) {
+	return p.base.downloadPackage(
+		logger, owner, repository, packageType, packageName, version, filename, nil,
+		func() (string, error) {
+			index, err := registries.LoadIndex(owner, packageType)
+			if err != nil {
+				return "", err
+			}
+			url, ok := index.URL(packageName, version, filename)
+			if !ok {
+				return "", fmt.Errorf("%s is missing from the source index, export again", filename)
+			}
+			return url, nil
+		},
+		func(downloadUrl, outputPath string) (ResultState, error) {
+			if err := utils.DownloadFile(downloadUrl, outputPath, p.source.Authorization()); err != nil {
+				return Failed, err
+			}
+			if rewriter, ok := p.source.(registries.Rewriter); ok {
+				if err := rewriter.Rewrite(logger, owner, packageType, outputPath); err != nil {
+					os.Remove(outputPath)
+					return Failed, fmt.Errorf("failed to rewrite %s: %w", filename, err)
+				}
+			}
+			return Success, nil
+		},
+	)
+}
@@ -0,0 +1,115 @@
+package providers
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"go.uber.org/zap"
+)
+
+// preparer is implemented by providers that rewrite package files for the
+// target organization before publishing
+type preparer interface {
+	// Prepare rewrites a file of a version and returns the file to publish
+	Prepare(logger *zap.Logger, packageDir, packageName, version, filename string) (string, error)
+}
+
+// targetProvider publishes to a registry other than GitHub Packages, the
+// provider of the package type still rewrites the files first
+type targetProvider struct {
+	Provider
+	base   BaseProvider
+	target registries.Target
+}
+
+// WithTarget makes a provider publish to a target registry, it returns the
+// provider unchanged when packages are published to GitHub Packages
+func WithTarget(provider Provider, target registries.Target) Provider {
+	if target == nil {
+		return provider
+	}
+	return &targetProvider{
+		Provider: provider,
+		base:     BaseProvider{PackageType: provider.GetPackageType()},
+		target:   target,
+	}
+}
+
+func (p *targetProvider) Upload(logger *zap.Logger, owner, repository, packageType, packageName, version, filename string) (ResultState, error) {
+	if !utils.Contains(p.target.PackageTypes(), packageType) {
+		return Failed, fmt.Errorf("%s target does not support %s packages", registries.TargetName(), packageType)
+	}
+	return p.base.uploadPackage(
+		logger, owner, repository, packageType, packageName, version, filename,
+		func() (string, error) {
+			return registries.TargetName(), nil
+		},
+		func(_, packageDir string) (ResultState, error) {
+			path := filepath.Join(packageDir, filename)
+			if preparer, ok := p.Provider.(preparer); ok {
+				var err error
+				if path, err = preparer.Prepare(logger, packageDir, packageName, version, filename); err != nil {
+					return Failed, err
+				}
+			}
+			published, err := p.target.Publish(logger, packageType, packageName, version, path)
+			if err != nil {
+				return Failed, err
+			}
+			if !published {
+				return Skipped, nil
+			}
+			return Success, nil
+		},
+	)
+}
+
+// Rewritten reports whether a file is rewritten for the target organization
+// before it is published, its digest differs from the source file
+func Rewritten(packageType, filename string) bool {
+	switch packageType {
+	case "npm":
+		return strings.HasSuffix(filename, ".tgz")
+	case "rubygems":
+		return strings.HasSuffix(filename, ".gem")
+	case "nuget":
+		return strings.HasSuffix(filename, ".nupkg")
+	case "maven":
+		// Checksums of a pom are recomputed along with it
+		for _, suffix := range []string{"pom.xml", ".pom"} {
+			if strings.HasSuffix(filename, suffix) || strings.Contains(filename, suffix+".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RetargetUrl moves a source URL to the target registry and organization
+func RetargetUrl(source, sourceRegistry, targetRegistry, sourceOrg, targetOrg string) string {
+	rest, ok := strings.CutPrefix(source, strings.TrimSuffix(sourceRegistry, "/"))
+	if !ok {
+		return ""
+	}
+	segments := strings.Split(rest, "/")
+	for i, segment := range segments {
+		scope, name := "", segment
+		if strings.HasPrefix(segment, "@") {
+			scope, name = "@", segment[1:]
+		}
+		if !strings.EqualFold(name, sourceOrg) {
+			continue
+		}
+		// Container and npm URLs use lower case organizations
+		if name == strings.ToLower(name) {
+			targetOrg = strings.ToLower(targetOrg)
+		}
+		segments[i] = scope + targetOrg
+		break
+	}
+	return strings.TrimSuffix(targetRegistry, "/") + path.Clean("/"+strings.Join(segments, "/"))
+}
@@ -0,0 +1,36 @@
+package providers
+
+import "testing"
+
+func TestRetargetUrl(t *testing.T) {
+	for _, test := range []struct {
+		source, sourceRegistry, targetRegistry, want string
+	}{
+		{"https://maven.pkg.github.com/Acme/app/com.acme.app/1.0/app-1.0.jar", "https://maven.pkg.github.com/", "https://maven.pkg.ghe.example.com/",
+			"https://maven.pkg.ghe.example.com/Octo-Corp/app/com.acme.app/1.0/app-1.0.jar"},
+		{"https://npm.pkg.github.com/download/@acme/widgets/1.0.0/widgets-1.0.0.tgz", "https://npm.pkg.github.com/", "https://npm.pkg.github.com/",
+			"https://npm.pkg.github.com/download/@octo-corp/widgets/1.0.0/widgets-1.0.0.tgz"},
+		{"ghcr.io/acme/api:1.2", "ghcr.io", "ghcr.io", "ghcr.io/octo-corp/api:1.2"},
+	} {
+		if got := RetargetUrl(test.source, test.sourceRegistry, test.targetRegistry, "Acme", "Octo-Corp"); got != test.want {
+			t.Errorf("RetargetUrl(%s) = %s, want %s", test.source, got, test.want)
+		}
+	}
+}
+
+func TestRewritten(t *testing.T) {
+	for _, test := range []struct {
+		packageType, filename string
+		want                  bool
+	}{
+		{"npm", "widgets-1.0.0.tgz", true},
+		{"maven", "app-1.0.pom.sha1", true},
+		{"maven", "app-1.0.jar", false},
+		{"nuget", "Acme.Widgets.1.0.0.snupkg", false},
+		{"container", "api:1.2", false},
+	} {
+		if got := Rewritten(test.packageType, test.filename); got != test.want {
+			t.Errorf("Rewritten(%s, %s) = %v, want %v", test.packageType, test.filename, got, test.want)
+		}
+	}
+}
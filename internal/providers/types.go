@@ -21,10 +21,15 @@ const (
 	Success ResultState = iota
 	Skipped
 	Failed
+	// SourceMissing is a file the source lists but no longer serves, like
+	// the tarball of a deleted version
+	SourceMissing
+	// TooLarge is a file over the maximum file size, it is not uploaded
+	TooLarge
 )
 
 func (r ResultState) String() string {
-	return [...]string{"Success", "Skipped", "Failed"}[r]
+	return [...]string{"Success", "Skipped", "Failed", "SourceMissing", "TooLarge"}[r]
 }
 
 type BaseProvider struct {
@@ -33,6 +38,8 @@ type BaseProvider struct {
 	TargetRegistryUrl *url.URL
 	SourceHostnameUrl *url.URL
 	TargetHostnameUrl *url.URL
+
+	files *versionFiles
 }
 
 type Provider interface {
@@ -146,6 +153,41 @@ type VersionQuery struct {
 	} `graphql:"node(id: $packageID)"`
 }
 
+type VersionFilesQuery struct {
+	Organization struct {
+		Packages struct {
+			Nodes []struct {
+				Version struct {
+					Files FilesNode `graphql:"files(first: $filesFirst, after: $filesAfter)"`
+				} `graphql:"version(version: $version)"`
+			}
+		} `graphql:"packages(first: 1, names: [$packageName], packageType: $packageType)"`
+	} `graphql:"organization(login: $owner)"`
+}
+
+// VersionFileSizesQuery lists the files of a package version with their
+// sizes
+type VersionFileSizesQuery struct {
+	Organization struct {
+		Packages struct {
+			Nodes []struct {
+				Version struct {
+					Files struct {
+						Nodes []struct {
+							Name githubv4.String
+							Size *int64
+						}
+						PageInfo struct {
+							EndCursor   githubv4.String
+							HasNextPage bool
+						}
+					} `graphql:"files(first: $filesFirst, after: $filesAfter)"`
+				} `graphql:"version(version: $version)"`
+			}
+		} `graphql:"packages(first: 1, names: [$packageName], packageType: $packageType)"`
+	} `graphql:"organization(login: $owner)"`
+}
+
 type FileQuery struct {
 	Node struct {
 		PackageVersion struct {
@@ -0,0 +1,269 @@
+package registries
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+type aqlItem struct {
+	Repo       string `json:"repo"`
+	Path       string `json:"path"`
+	Name       string `json:"name"`
+	Properties []struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	} `json:"properties"`
+}
+
+func (i aqlItem) property(key string) string {
+	for _, property := range i.Properties {
+		if property.Key == key {
+			return property.Value
+		}
+	}
+	return ""
+}
+
+// ArtifactorySource lists packages of Artifactory repositories with AQL and
+// downloads them from the repository paths
+type ArtifactorySource struct {
+	baseURL       string
+	authorization string
+	repositories  []string
+}
+
+func init() {
+	registerSource("artifactory", NewArtifactorySource)
+}
+
+// NewArtifactorySource creates an Artifactory source from
+// GHMPKG_SOURCE_HOSTNAME, GHMPKG_SOURCE_TOKEN, the optional
+// GHMPKG_SOURCE_USERNAME and GHMPKG_SOURCE_REPOSITORIES
+func NewArtifactorySource() (Source, error) {
+	base, err := baseURL("GHMPKG_SOURCE_HOSTNAME")
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(base, "/artifactory") {
+		base += "/artifactory"
+	}
+	return &ArtifactorySource{
+		baseURL:       base,
+		authorization: basicOrBearer(viper.GetString("GHMPKG_SOURCE_USERNAME"), viper.GetString("GHMPKG_SOURCE_TOKEN")),
+		repositories:  splitList("GHMPKG_SOURCE_REPOSITORIES"),
+	}, nil
+}
+
+func (s *ArtifactorySource) PackageTypes() []string {
+	return []string{"maven", "npm", "nuget"}
+}
+
+func (s *ArtifactorySource) Authorization() string {
+	return s.authorization
+}
+
+// List returns the packages of every configured repository, or of every
+// local repository of the package type when none are configured
+func (s *ArtifactorySource) List(logger *zap.Logger, packageType string) ([]Package, error) {
+	repositories := s.repositories
+	if len(repositories) == 0 {
+		var err error
+		if repositories, err = s.localRepositories(packageType); err != nil {
+			return nil, err
+		}
+	}
+
+	set := packageSet{}
+	for _, repository := range repositories {
+		logger.Info("Listing Artifactory repository", zap.String("repository", repository), zap.String("package_type", packageType))
+		items, err := s.search(repository)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			name, version, filename, ok := artifactoryFile(packageType, item)
+			if !ok {
+				continue
+			}
+			set.add(name, version, File{Name: filename, URL: s.fileURL(item)})
+		}
+	}
+	return set.packages(), nil
+}
+
+// artifactoryFile maps an artifact to its package, version and local
+// filename. npm and NuGet packages are identified by the properties
+// Artifactory indexes them with, maven by the repository layout.
+func artifactoryFile(packageType string, item aqlItem) (name, version, filename string, ok bool) {
+	switch packageType {
+	case "npm":
+		name, version = unscoped(item.property("npm.name")), item.property("npm.version")
+		if name == "" || version == "" || !strings.HasSuffix(item.Name, ".tgz") {
+			return "", "", "", false
+		}
+		return name, version, fmt.Sprintf("%s-%s.tgz", name, version), true
+	case "nuget":
+		name, version = item.property("nuget.id"), item.property("nuget.version")
+		if name == "" || version == "" || !strings.HasSuffix(item.Name, ".nupkg") {
+			return "", "", "", false
+		}
+		return name, version, fmt.Sprintf("%s-%s.nupkg", name, version), true
+	case "maven":
+		if isMavenMetadata(item.Name) {
+			return "", "", "", false
+		}
+		name, version, ok = mavenCoordinates(item.Path)
+		return name, version, item.Name, ok
+	}
+	return "", "", "", false
+}
+
+func (s *ArtifactorySource) fileURL(item aqlItem) string {
+	parts := []string{s.baseURL, item.Repo}
+	if item.Path != "" && item.Path != "." {
+		parts = append(parts, item.Path)
+	}
+	return strings.Join(append(parts, item.Name), "/")
+}
+
+func (s *ArtifactorySource) search(repository string) ([]aqlItem, error) {
+	query := fmt.Sprintf(`items.find({"repo":%q,"type":"file"}).include("repo","path","name","property")`, repository)
+	req, err := http.NewRequest(http.MethodPost, s.baseURL+"/api/search/aql", strings.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Authorization", s.authorization)
+	resp, err := utils.HTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("AQL search of %s failed, status: %s", repository, resp.Status)
+	}
+
+	var result struct {
+		Results []aqlItem `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode AQL results: %w", err)
+	}
+	return result.Results, nil
+}
+
+func (s *ArtifactorySource) localRepositories(packageType string) ([]string, error) {
+	url := fmt.Sprintf("%s/api/repositories?type=local&packageType=%s", s.baseURL, packageType)
+	resp, err := get(url, s.authorization)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var repositories []struct {
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&repositories); err != nil {
+		return nil, fmt.Errorf("failed to decode repositories: %w", err)
+	}
+	var keys []string
+	for _, repository := range repositories {
+		keys = append(keys, repository.Key)
+	}
+	return keys, nil
+}
+
+// ArtifactoryTarget deploys packages to Artifactory repositories, which
+// index npm, maven and NuGet packages deployed to their usual paths
+type ArtifactoryTarget struct {
+	baseURL       string
+	authorization string
+	repositories  map[string]string
+}
+
+func init() {
+	registerTarget("artifactory", NewArtifactoryTarget)
+}
+
+// NewArtifactoryTarget creates an Artifactory target from
+// GHMPKG_TARGET_HOSTNAME, GHMPKG_TARGET_TOKEN, the optional
+// GHMPKG_TARGET_USERNAME and GHMPKG_TARGET_REPOSITORIES
+func NewArtifactoryTarget() (Target, error) {
+	base, err := baseURL("GHMPKG_TARGET_HOSTNAME")
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(base, "/artifactory") {
+		base += "/artifactory"
+	}
+	return &ArtifactoryTarget{
+		baseURL:       base,
+		authorization: basicOrBearer(viper.GetString("GHMPKG_TARGET_USERNAME"), viper.GetString("GHMPKG_TARGET_TOKEN")),
+		repositories:  targetRepositories(),
+	}, nil
+}
+
+func (t *ArtifactoryTarget) PackageTypes() []string {
+	return []string{"maven", "npm", "nuget"}
+}
+
+// Publish deploys a file, existing files are left alone
+func (t *ArtifactoryTarget) Publish(logger *zap.Logger, packageType, packageName, version, path string) (bool, error) {
+	repository, err := repositoryFor(t.repositories, packageType)
+	if err != nil {
+		return false, err
+	}
+	url, err := t.deployURL(repository, packageType, packageName, version, path)
+	if err != nil {
+		return false, err
+	}
+
+	if found, err := exists(url, t.authorization); err != nil {
+		return false, err
+	} else if found {
+		logger.Info("File already exists in Artifactory", zap.String("url", url))
+		return false, nil
+	}
+
+	// Artifactory verifies the upload against the checksums it is given
+	headers := map[string]string{}
+	for header, algorithm := range map[string]string{"X-Checksum-Sha1": "sha1", "X-Checksum-Sha256": "sha256"} {
+		if sum, err := utils.FileChecksum(path, algorithm); err == nil {
+			headers[header] = sum
+		}
+	}
+	logger.Info("Deploying file to Artifactory", zap.String("url", url))
+	if err := put(url, path, t.authorization, headers); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (t *ArtifactoryTarget) deployURL(repository, packageType, packageName, version, path string) (string, error) {
+	filename := filepath.Base(path)
+	switch packageType {
+	case "npm":
+		// Scoped like on GitHub, the scope is the target organization
+		name := packageName
+		if scope := viper.GetString("GHMPKG_TARGET_ORGANIZATION"); scope != "" {
+			name = fmt.Sprintf("@%s/%s", scope, packageName)
+		}
+		return fmt.Sprintf("%s/%s/%s/-/%s", t.baseURL, repository, name, filename), nil
+	case "maven":
+		return fmt.Sprintf("%s/%s/%s/%s", t.baseURL, repository, mavenDir(packageName, version, path), filename), nil
+	case "nuget":
+		extension := "nupkg"
+		if IsSymbolPackage(path) {
+			extension = "snupkg"
+		}
+		return fmt.Sprintf("%s/%s/%s/%s.%s.%s", t.baseURL, repository, packageName, packageName, version, extension), nil
+	}
+	return "", fmt.Errorf("artifactory target does not support %s packages", packageType)
+}
@@ -0,0 +1,153 @@
+package registries
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/oci"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// artifactRegistryHost matches the repository hosts of a location, e.g.
+// us-east1-docker.pkg.dev, the format part is replaced per package type
+var artifactRegistryHost = regexp.MustCompile(`^([a-z0-9-]+)-(docker|npm|maven)\.pkg\.dev$`)
+
+// Artifact Registry repository formats of the GitHub package types
+var artifactRegistryFormats = map[string]string{
+	"container": "docker",
+	"maven":     "maven",
+	"npm":       "npm",
+}
+
+// ArtifactRegistryTarget publishes npm, maven and container packages to
+// Google Artifact Registry
+type ArtifactRegistryTarget struct {
+	location     string
+	project      string
+	repositories map[string]string
+	tokens       oauth2.TokenSource
+}
+
+func init() {
+	registerTarget("artifactregistry", NewArtifactRegistryTarget)
+	// Access tokens come from Application Default Credentials
+	tokenOptional["artifactregistry"] = true
+}
+
+// NewArtifactRegistryTarget creates an Artifact Registry target from a
+// repository host and project in GHMPKG_TARGET_HOSTNAME, e.g.
+// us-east1-docker.pkg.dev/acme-prod, and GHMPKG_TARGET_REPOSITORIES.
+// Application Default Credentials are used unless an access token is given
+// in GHMPKG_TARGET_TOKEN.
+func NewArtifactRegistryTarget() (Target, error) {
+	base, err := baseURL("GHMPKG_TARGET_HOSTNAME")
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	match := artifactRegistryHost.FindStringSubmatch(parsed.Hostname())
+	project := strings.Split(strings.Trim(parsed.Path, "/"), "/")[0]
+	if match == nil || project == "" {
+		return nil, fmt.Errorf("GHMPKG_TARGET_HOSTNAME must be an Artifact Registry host and project like us-east1-docker.pkg.dev/acme-prod")
+	}
+
+	target := &ArtifactRegistryTarget{
+		location:     match[1],
+		project:      project,
+		repositories: targetRepositories(),
+	}
+	if token := viper.GetString("GHMPKG_TARGET_TOKEN"); token != "" {
+		target.tokens = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+	} else {
+		credentials, err := google.FindDefaultCredentials(context.Background(), "https://www.googleapis.com/auth/cloud-platform")
+		if err != nil {
+			return nil, fmt.Errorf("failed to find Application Default Credentials, run gcloud auth application-default login: %w", err)
+		}
+		target.tokens = credentials.TokenSource
+	}
+	return target, nil
+}
+
+func (t *ArtifactRegistryTarget) PackageTypes() []string {
+	return []string{"container", "maven", "npm"}
+}
+
+// Publish pushes images with the registry API, publishes npm packages with
+// the npm protocol and deploys maven files to the maven2 layout
+func (t *ArtifactRegistryTarget) Publish(logger *zap.Logger, packageType, packageName, version, path string) (bool, error) {
+	repository, err := repositoryFor(t.repositories, packageType)
+	if err != nil {
+		return false, err
+	}
+	// The token source caches the access token and refreshes it when it
+	// expires
+	token, err := t.tokens.Token()
+	if err != nil {
+		return false, fmt.Errorf("failed to get a Google access token: %w", err)
+	}
+
+	switch packageType {
+	case "container":
+		archive, err := oci.OpenArchive(path)
+		if err != nil {
+			return false, err
+		}
+		defer archive.Close()
+		rules, err := oci.ParseRetagRules(viper.GetString("GHMPKG_RETAG"))
+		if err != nil {
+			return false, err
+		}
+		tag, err := rules.Apply(archive.Tag())
+		if err != nil {
+			return false, err
+		}
+		chunkSize, err := utils.ChunkSize()
+		if err != nil {
+			return false, err
+		}
+		client := oci.NewClientWithAuthorization(t.host(packageType), "Bearer "+token.AccessToken)
+		client.ChunkSize = chunkSize
+		image := client.Repository(fmt.Sprintf("%s/%s/%s", t.project, repository, packageName))
+		logger.Info("Pushing image to Artifact Registry", zap.String("image", image.Reference(tag)))
+		return oci.Push(logger, image, archive, tag)
+	case "npm":
+		registry := t.repositoryURL(packageType, repository) + "/"
+		logger.Info("Publishing to Artifact Registry", zap.String("registry", registry))
+		return npmPublish(registry, "Bearer "+token.AccessToken, path)
+	case "maven":
+		authorization := basicOrBearer("oauth2accesstoken", token.AccessToken)
+		fileURL := fmt.Sprintf("%s/%s/%s", t.repositoryURL(packageType, repository), mavenDir(packageName, version, path), filepath.Base(path))
+		if found, err := exists(fileURL, authorization); err != nil {
+			return false, err
+		} else if found {
+			logger.Info("File already exists in Artifact Registry", zap.String("url", fileURL))
+			return false, nil
+		}
+		logger.Info("Deploying file to Artifact Registry", zap.String("url", fileURL))
+		if err := put(fileURL, path, authorization, nil); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("artifactregistry target does not support %s packages", packageType)
+}
+
+// host returns the repository host of a package type in the location
+func (t *ArtifactRegistryTarget) host(packageType string) string {
+	return fmt.Sprintf("https://%s-%s.pkg.dev", t.location, artifactRegistryFormats[packageType])
+}
+
+func (t *ArtifactRegistryTarget) repositoryURL(packageType, repository string) string {
+	return fmt.Sprintf("%s/%s/%s", t.host(packageType), t.project, url.PathEscape(repository))
+}
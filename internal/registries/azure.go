@@ -0,0 +1,258 @@
+package registries
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// azureDefaultUser is sent with personal access tokens, Azure DevOps only
+// checks the token
+const azureDefaultUser = "ghmpkg"
+
+// Azure Artifacts protocol types of the GitHub package types
+var azureProtocols = map[string]string{
+	"npm":   "npm",
+	"nuget": "NuGet",
+}
+
+// azureScope holds the organization and optional project of a feed, feeds
+// are either organization or project scoped
+type azureScope struct {
+	organization string
+	project      string
+}
+
+// parseAzureScope reads the organization and project from a hostname
+// setting like https://dev.azure.com/acme/payments
+func parseAzureScope(key string) (azureScope, error) {
+	base, err := baseURL(key)
+	if err != nil {
+		return azureScope{}, err
+	}
+	parsed, err := url.Parse(base)
+	if err != nil {
+		return azureScope{}, err
+	}
+	parts := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	if parts[0] == "" {
+		return azureScope{}, fmt.Errorf("%s must include the Azure DevOps organization, e.g. https://dev.azure.com/acme", key)
+	}
+	scope := azureScope{organization: parts[0]}
+	if len(parts) > 1 {
+		scope.project = parts[1]
+	}
+	return scope, nil
+}
+
+// url returns an Azure DevOps service URL, e.g. for the feeds or pkgs host
+func (s azureScope) url(service string, segments ...string) string {
+	parts := []string{fmt.Sprintf("https://%s.dev.azure.com", service), s.organization}
+	if s.project != "" {
+		parts = append(parts, s.project)
+	}
+	return strings.Join(append(parts, segments...), "/")
+}
+
+func azureAuthorization(prefix string) string {
+	username := viper.GetString(prefix + "USERNAME")
+	if username == "" {
+		username = azureDefaultUser
+	}
+	return basicOrBearer(username, viper.GetString(prefix+"TOKEN"))
+}
+
+// AzureSource lists npm and NuGet packages of Azure Artifacts feeds
+type AzureSource struct {
+	scope         azureScope
+	authorization string
+	feeds         []string
+}
+
+func init() {
+	registerSource("azure", NewAzureSource)
+	registerTarget("azure", NewAzureTarget)
+}
+
+// NewAzureSource creates an Azure Artifacts source from
+// GHMPKG_SOURCE_HOSTNAME, the personal access token in GHMPKG_SOURCE_TOKEN
+// and the feeds in GHMPKG_SOURCE_REPOSITORIES
+func NewAzureSource() (Source, error) {
+	scope, err := parseAzureScope("GHMPKG_SOURCE_HOSTNAME")
+	if err != nil {
+		return nil, err
+	}
+	return &AzureSource{
+		scope:         scope,
+		authorization: azureAuthorization("GHMPKG_SOURCE_"),
+		feeds:         splitList("GHMPKG_SOURCE_REPOSITORIES"),
+	}, nil
+}
+
+func (s *AzureSource) PackageTypes() []string {
+	return []string{"npm", "nuget"}
+}
+
+func (s *AzureSource) Authorization() string {
+	return s.authorization
+}
+
+// List returns the packages of the configured feeds, or of every feed in
+// the organization or project when none are configured
+func (s *AzureSource) List(logger *zap.Logger, packageType string) ([]Package, error) {
+	feeds := s.feeds
+	if len(feeds) == 0 {
+		var err error
+		if feeds, err = s.allFeeds(); err != nil {
+			return nil, err
+		}
+	}
+
+	set := packageSet{}
+	for _, feed := range feeds {
+		logger.Info("Listing Azure Artifacts feed", zap.String("feed", feed), zap.String("package_type", packageType))
+		const pageSize = 1000
+		for skip := 0; ; skip += pageSize {
+			query := url.Values{
+				"protocolType":       {azureProtocols[packageType]},
+				"includeAllVersions": {"true"},
+				"$top":               {fmt.Sprint(pageSize)},
+				"$skip":              {fmt.Sprint(skip)},
+				"api-version":        {"7.1"},
+			}
+			resp, err := get(s.scope.url("feeds", "_apis/packaging/feeds", url.PathEscape(feed), "packages")+"?"+query.Encode(), s.authorization)
+			if err != nil {
+				return nil, err
+			}
+			var page struct {
+				Value []struct {
+					Name     string `json:"name"`
+					Versions []struct {
+						Version   string `json:"version"`
+						IsDeleted bool   `json:"isDeleted"`
+					} `json:"versions"`
+				} `json:"value"`
+			}
+			err = json.NewDecoder(resp.Body).Decode(&page)
+			resp.Body.Close()
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode packages of feed %s: %w", feed, err)
+			}
+
+			for _, pkg := range page.Value {
+				for _, version := range pkg.Versions {
+					if version.IsDeleted {
+						continue
+					}
+					name, filename := azureFile(packageType, pkg.Name, version.Version)
+					set.add(name, version.Version, File{Name: filename, URL: s.contentURL(feed, packageType, pkg.Name, version.Version)})
+				}
+			}
+			if len(page.Value) < pageSize {
+				break
+			}
+		}
+	}
+	return set.packages(), nil
+}
+
+// azureFile returns the GitHub package name and local filename of a version
+func azureFile(packageType, name, version string) (string, string) {
+	if packageType == "npm" {
+		name = unscoped(name)
+		return name, fmt.Sprintf("%s-%s.tgz", name, version)
+	}
+	return name, fmt.Sprintf("%s-%s.nupkg", name, version)
+}
+
+func (s *AzureSource) contentURL(feed, packageType, name, version string) string {
+	return s.scope.url("pkgs", "_apis/packaging/feeds", url.PathEscape(feed), packageType, "packages", name, "versions", url.PathEscape(version), "content") + "?api-version=7.1-preview.1"
+}
+
+func (s *AzureSource) allFeeds() ([]string, error) {
+	resp, err := get(s.scope.url("feeds", "_apis/packaging/feeds")+"?api-version=7.1", s.authorization)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var feeds struct {
+		Value []struct {
+			Name string `json:"name"`
+		} `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&feeds); err != nil {
+		return nil, fmt.Errorf("failed to decode feeds: %w", err)
+	}
+	var names []string
+	for _, feed := range feeds.Value {
+		names = append(names, feed.Name)
+	}
+	return names, nil
+}
+
+// AzureTarget publishes npm and NuGet packages to Azure Artifacts feeds
+type AzureTarget struct {
+	scope         azureScope
+	authorization string
+	feeds         map[string]string
+}
+
+// NewAzureTarget creates an Azure Artifacts target from
+// GHMPKG_TARGET_HOSTNAME, the personal access token in GHMPKG_TARGET_TOKEN
+// and the feeds in GHMPKG_TARGET_REPOSITORIES
+func NewAzureTarget() (Target, error) {
+	scope, err := parseAzureScope("GHMPKG_TARGET_HOSTNAME")
+	if err != nil {
+		return nil, err
+	}
+	return &AzureTarget{
+		scope:         scope,
+		authorization: azureAuthorization("GHMPKG_TARGET_"),
+		feeds:         targetRepositories(),
+	}, nil
+}
+
+func (t *AzureTarget) PackageTypes() []string {
+	return []string{"npm", "nuget"}
+}
+
+func (t *AzureTarget) Publish(logger *zap.Logger, packageType, packageName, version, path string) (bool, error) {
+	feed, err := repositoryFor(t.feeds, packageType)
+	if err != nil {
+		return false, err
+	}
+	switch packageType {
+	case "npm":
+		registry := t.scope.url("pkgs", "_packaging", url.PathEscape(feed), "npm/registry/")
+		logger.Info("Publishing to Azure Artifacts", zap.String("registry", registry))
+		return npmPublish(registry, t.authorization, path)
+	case "nuget":
+		if IsSymbolPackage(path) {
+			return t.publishSymbols(logger, feed, path)
+		}
+		push := t.scope.url("pkgs", "_packaging", url.PathEscape(feed), "nuget/v2/")
+		logger.Info("Publishing to Azure Artifacts", zap.String("registry", push))
+		return NugetPush(push, t.authorization, "AzureDevOps", path)
+	}
+	return false, fmt.Errorf("azure target does not support %s packages", packageType)
+}
+
+// publishSymbols pushes a .snupkg to the symbol endpoint the feed lists in
+// its service index, feeds without one keep only the .nupkg
+func (t *AzureTarget) publishSymbols(logger *zap.Logger, feed, path string) (bool, error) {
+	push, err := NugetSymbolEndpoint(t.scope.url("pkgs", "_packaging", url.PathEscape(feed), "nuget/v3/index.json"), t.authorization)
+	if err != nil {
+		return false, err
+	}
+	if push == "" {
+		logger.Warn("Feed does not accept symbol packages", zap.String("feed", feed), zap.String("file", filepath.Base(path)))
+		return false, nil
+	}
+	logger.Info("Publishing symbols to Azure Artifacts", zap.String("registry", push))
+	return NugetPush(push, t.authorization, "AzureDevOps", path)
+}
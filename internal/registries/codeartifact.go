@@ -0,0 +1,186 @@
+package registries
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// codeArtifactHost matches repository endpoints of a CodeArtifact domain,
+// e.g. acme-111122223333.d.codeartifact.us-east-1.amazonaws.com
+var codeArtifactHost = regexp.MustCompile(`^(.+)-(\d{12})\.d\.codeartifact\.([a-z0-9-]+)\.amazonaws\.com$`)
+
+// Authorization tokens are requested for an hour and renewed shortly
+// before they expire, migrations often run longer than that
+const (
+	codeArtifactTokenDuration = time.Hour
+	codeArtifactTokenRenewal  = 5 * time.Minute
+)
+
+// CodeArtifactTarget publishes npm and maven packages to AWS CodeArtifact
+// repositories
+type CodeArtifactTarget struct {
+	endpoint     string
+	api          string
+	domain       string
+	owner        string
+	region       string
+	repositories map[string]string
+
+	credentials aws.CredentialsProvider
+	mu          sync.Mutex
+	token       string
+	expires     time.Time
+}
+
+func init() {
+	registerTarget("codeartifact", NewCodeArtifactTarget)
+	// Tokens are requested with the AWS credentials
+	tokenOptional["codeartifact"] = true
+}
+
+// NewCodeArtifactTarget creates a CodeArtifact target from the domain
+// endpoint in GHMPKG_TARGET_HOSTNAME and GHMPKG_TARGET_REPOSITORIES.
+// Authorization tokens are requested with the default AWS credential
+// chain unless one is given in GHMPKG_TARGET_TOKEN.
+func NewCodeArtifactTarget() (Target, error) {
+	base, err := baseURL("GHMPKG_TARGET_HOSTNAME")
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	match := codeArtifactHost.FindStringSubmatch(parsed.Hostname())
+	if match == nil {
+		return nil, fmt.Errorf("GHMPKG_TARGET_HOSTNAME must be a CodeArtifact domain endpoint like acme-111122223333.d.codeartifact.us-east-1.amazonaws.com")
+	}
+
+	target := &CodeArtifactTarget{
+		endpoint:     "https://" + parsed.Host,
+		api:          fmt.Sprintf("https://codeartifact.%s.amazonaws.com", match[3]),
+		domain:       match[1],
+		owner:        match[2],
+		region:       match[3],
+		repositories: targetRepositories(),
+		token:        viper.GetString("GHMPKG_TARGET_TOKEN"),
+	}
+	if target.token == "" {
+		cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(target.region))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+		}
+		target.credentials = cfg.Credentials
+	}
+	return target, nil
+}
+
+func (t *CodeArtifactTarget) PackageTypes() []string {
+	return []string{"maven", "npm"}
+}
+
+// Publish publishes npm packages with the npm protocol and deploys maven
+// files to the maven2 layout of the repository
+func (t *CodeArtifactTarget) Publish(logger *zap.Logger, packageType, packageName, version, path string) (bool, error) {
+	repository, err := repositoryFor(t.repositories, packageType)
+	if err != nil {
+		return false, err
+	}
+	token, err := t.authorizationToken(logger)
+	if err != nil {
+		return false, err
+	}
+
+	switch packageType {
+	case "npm":
+		registry := t.repositoryEndpoint("npm", repository)
+		logger.Info("Publishing to CodeArtifact", zap.String("registry", registry))
+		return npmPublish(registry, "Bearer "+token, path)
+	case "maven":
+		authorization := basicOrBearer("aws", token)
+		fileURL := t.repositoryEndpoint("maven", repository) + mavenDir(packageName, version, path) + "/" + filepath.Base(path)
+		if found, err := exists(fileURL, authorization); err != nil {
+			return false, err
+		} else if found {
+			logger.Info("File already exists in CodeArtifact", zap.String("url", fileURL))
+			return false, nil
+		}
+		logger.Info("Deploying file to CodeArtifact", zap.String("url", fileURL))
+		if err := put(fileURL, path, authorization, nil); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("codeartifact target does not support %s packages", packageType)
+}
+
+// repositoryEndpoint returns the endpoint of a repository for a package
+// format, e.g. https://acme-111122223333.d.codeartifact.us-east-1.amazonaws.com/npm/shared/
+func (t *CodeArtifactTarget) repositoryEndpoint(format, repository string) string {
+	return fmt.Sprintf("%s/%s/%s/", t.endpoint, format, url.PathEscape(repository))
+}
+
+// authorizationToken returns a valid authorization token for the domain,
+// requesting a new one with GetAuthorizationToken when needed
+func (t *CodeArtifactTarget) authorizationToken(logger *zap.Logger) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.credentials == nil || (t.token != "" && time.Until(t.expires) > codeArtifactTokenRenewal) {
+		return t.token, nil
+	}
+
+	ctx := context.Background()
+	credentials, err := t.credentials.Retrieve(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	query := url.Values{
+		"domain":       {t.domain},
+		"domain-owner": {t.owner},
+		"duration":     {fmt.Sprint(int(codeArtifactTokenDuration.Seconds()))},
+	}
+	req, err := http.NewRequest(http.MethodPost, t.api+"/v1/authorization-token?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	emptyPayload := sha256.Sum256(nil)
+	if err := v4.NewSigner().SignHTTP(ctx, credentials, req, hex.EncodeToString(emptyPayload[:]), "codeartifact", t.region, time.Now()); err != nil {
+		return "", fmt.Errorf("failed to sign CodeArtifact request: %w", err)
+	}
+	resp, err := utils.HTTPClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GetAuthorizationToken for domain %s failed, status: %s", t.domain, resp.Status)
+	}
+
+	var result struct {
+		AuthorizationToken string  `json:"authorizationToken"`
+		Expiration         float64 `json:"expiration"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode CodeArtifact authorization token: %w", err)
+	}
+	t.token = result.AuthorizationToken
+	t.expires = time.Unix(int64(result.Expiration), 0)
+	logger.Info("Requested CodeArtifact authorization token", zap.String("domain", t.domain), zap.Time("expires", t.expires))
+	return t.token, nil
+}
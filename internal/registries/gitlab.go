@@ -0,0 +1,168 @@
+package registries
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const gitlabPageSize = 100
+
+type gitlabPackage struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	PackageType string `json:"package_type"`
+	Status      string `json:"status"`
+	ProjectID   int    `json:"project_id"`
+}
+
+type gitlabPackageFile struct {
+	FileName string `json:"file_name"`
+}
+
+// GitLabSource lists npm and maven packages of the GitLab package registry,
+// either of a group and its subgroups or of single projects
+type GitLabSource struct {
+	apiURL        string
+	authorization string
+	group         string
+	projects      []string
+}
+
+func init() {
+	registerSource("gitlab", NewGitLabSource)
+}
+
+// NewGitLabSource creates a GitLab source from GHMPKG_SOURCE_HOSTNAME and
+// GHMPKG_SOURCE_TOKEN. Packages of the projects in
+// GHMPKG_SOURCE_REPOSITORIES are listed, or of the group in
+// GHMPKG_SOURCE_ORGANIZATION when none are configured.
+func NewGitLabSource() (Source, error) {
+	base, err := baseURL("GHMPKG_SOURCE_HOSTNAME")
+	if err != nil {
+		return nil, err
+	}
+	return &GitLabSource{
+		apiURL:        strings.TrimSuffix(base, "/api/v4") + "/api/v4",
+		authorization: basicOrBearer(viper.GetString("GHMPKG_SOURCE_USERNAME"), viper.GetString("GHMPKG_SOURCE_TOKEN")),
+		group:         viper.GetString("GHMPKG_SOURCE_ORGANIZATION"),
+		projects:      splitList("GHMPKG_SOURCE_REPOSITORIES"),
+	}, nil
+}
+
+func (s *GitLabSource) PackageTypes() []string {
+	return []string{"maven", "npm"}
+}
+
+func (s *GitLabSource) Authorization() string {
+	return s.authorization
+}
+
+// List returns the packages of the group, or of the configured projects
+func (s *GitLabSource) List(logger *zap.Logger, packageType string) ([]Package, error) {
+	scopes := []string{"groups/" + url.PathEscape(s.group)}
+	if len(s.projects) > 0 {
+		scopes = nil
+		for _, project := range s.projects {
+			scopes = append(scopes, "projects/"+url.PathEscape(project))
+		}
+	}
+
+	set := packageSet{}
+	for _, scope := range scopes {
+		logger.Info("Listing GitLab packages", zap.String("scope", scope), zap.String("package_type", packageType))
+		for page := 1; ; page++ {
+			query := url.Values{
+				"package_type": {packageType},
+				"per_page":     {fmt.Sprint(gitlabPageSize)},
+				"page":         {fmt.Sprint(page)},
+			}
+			var packages []gitlabPackage
+			if err := s.getJSON(s.apiURL+"/"+scope+"/packages?"+query.Encode(), &packages); err != nil {
+				return nil, err
+			}
+			for _, pkg := range packages {
+				// Packages still being processed or failed have no usable files
+				if pkg.Status != "" && pkg.Status != "default" && pkg.Status != "hidden" {
+					logger.Warn("Skipping GitLab package", zap.String("package_name", pkg.Name), zap.String("package_version", pkg.Version), zap.String("status", pkg.Status))
+					continue
+				}
+				// Group listings return packages of many projects
+				project := s.apiURL + "/" + scope
+				if strings.HasPrefix(scope, "groups/") {
+					project = fmt.Sprintf("%s/projects/%d", s.apiURL, pkg.ProjectID)
+				}
+				if err := s.addFiles(set, project, packageType, pkg); err != nil {
+					return nil, err
+				}
+			}
+			if len(packages) < gitlabPageSize {
+				break
+			}
+		}
+	}
+	return set.packages(), nil
+}
+
+func (s *GitLabSource) addFiles(set packageSet, project, packageType string, pkg gitlabPackage) error {
+	for page := 1; ; page++ {
+		var files []gitlabPackageFile
+		if err := s.getJSON(fmt.Sprintf("%s/packages/%d/package_files?per_page=%d&page=%d", project, pkg.ID, gitlabPageSize, page), &files); err != nil {
+			return err
+		}
+		for _, file := range files {
+			name, filename, ok := gitlabFile(packageType, pkg.Name, pkg.Version, file.FileName)
+			if !ok {
+				continue
+			}
+			set.add(name, pkg.Version, File{Name: filename, URL: project + "/packages/" + gitlabFilePath(packageType, pkg.Name, pkg.Version, file.FileName)})
+		}
+		if len(files) < gitlabPageSize {
+			return nil
+		}
+	}
+}
+
+// gitlabFile maps a package file to the GitHub package name and the local
+// filename. GitLab names maven packages by their path, e.g. com/acme/core.
+func gitlabFile(packageType, name, version, filename string) (string, string, bool) {
+	switch packageType {
+	case "npm":
+		if !strings.HasSuffix(filename, ".tgz") {
+			return "", "", false
+		}
+		name = unscoped(name)
+		return name, fmt.Sprintf("%s-%s.tgz", name, version), true
+	case "maven":
+		if isMavenMetadata(filename) {
+			return "", "", false
+		}
+		return strings.ReplaceAll(strings.Trim(name, "/"), "/", "."), filename, true
+	}
+	return "", "", false
+}
+
+// gitlabFilePath returns the project level download path of a package file
+func gitlabFilePath(packageType, name, version, filename string) string {
+	if packageType == "npm" {
+		return fmt.Sprintf("npm/%s/-/%s", name, filename)
+	}
+	return fmt.Sprintf("maven/%s/%s/%s", strings.Trim(name, "/"), url.PathEscape(version), url.PathEscape(filename))
+}
+
+func (s *GitLabSource) getJSON(requestURL string, value interface{}) error {
+	resp, err := get(requestURL, s.authorization)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(value); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", requestURL, err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package registries
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Nexus repository formats of the GitHub package types. Raw repositories
+// are exported as maven packages, see nexusFile.
+var nexusFormats = map[string][]string{
+	"npm":   {"npm"},
+	"maven": {"maven2", "raw"},
+}
+
+type nexusComponent struct {
+	Repository string `json:"repository"`
+	Format     string `json:"format"`
+	Group      string `json:"group"`
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Assets     []struct {
+		DownloadURL string `json:"downloadUrl"`
+		Path        string `json:"path"`
+	} `json:"assets"`
+}
+
+// NexusSource lists packages with the components API of Nexus Repository
+// Manager and downloads the component assets
+type NexusSource struct {
+	baseURL       string
+	authorization string
+	repositories  []string
+}
+
+func init() {
+	registerSource("nexus", NewNexusSource)
+}
+
+// NewNexusSource creates a Nexus source from GHMPKG_SOURCE_HOSTNAME,
+// GHMPKG_SOURCE_USERNAME, GHMPKG_SOURCE_TOKEN and GHMPKG_SOURCE_REPOSITORIES
+func NewNexusSource() (Source, error) {
+	base, err := baseURL("GHMPKG_SOURCE_HOSTNAME")
+	if err != nil {
+		return nil, err
+	}
+	return &NexusSource{
+		baseURL:       base,
+		authorization: basicOrBearer(viper.GetString("GHMPKG_SOURCE_USERNAME"), viper.GetString("GHMPKG_SOURCE_TOKEN")),
+		repositories:  splitList("GHMPKG_SOURCE_REPOSITORIES"),
+	}, nil
+}
+
+func (s *NexusSource) PackageTypes() []string {
+	return []string{"maven", "npm"}
+}
+
+func (s *NexusSource) Authorization() string {
+	return s.authorization
+}
+
+// List returns the components of the configured repositories, or of every
+// hosted repository of the package type when none are configured
+func (s *NexusSource) List(logger *zap.Logger, packageType string) ([]Package, error) {
+	repositories, err := s.repositoriesOf(packageType)
+	if err != nil {
+		return nil, err
+	}
+
+	set := packageSet{}
+	for _, repository := range repositories {
+		logger.Info("Listing Nexus repository", zap.String("repository", repository), zap.String("package_type", packageType))
+		continuation := ""
+		for {
+			components, next, err := s.components(repository, continuation)
+			if err != nil {
+				return nil, err
+			}
+			for _, component := range components {
+				if !utils.Contains(nexusFormats[packageType], component.Format) {
+					continue
+				}
+				for _, asset := range component.Assets {
+					name, version, filename, ok := nexusFile(component, asset.Path)
+					if !ok {
+						continue
+					}
+					set.add(name, version, File{Name: filename, URL: asset.DownloadURL})
+				}
+			}
+			if next == "" {
+				break
+			}
+			continuation = next
+		}
+	}
+	return set.packages(), nil
+}
+
+// nexusFile maps a component asset to its package, version and local
+// filename. Raw assets have no coordinates, those stored in a maven2 like
+// group/name/version/file layout are exported as maven packages.
+func nexusFile(component nexusComponent, assetPath string) (name, version, filename string, ok bool) {
+	filename = path.Base(assetPath)
+	switch component.Format {
+	case "npm":
+		if !strings.HasSuffix(filename, ".tgz") {
+			return "", "", "", false
+		}
+		name = unscoped(component.Name)
+		return name, component.Version, fmt.Sprintf("%s-%s.tgz", name, component.Version), true
+	case "maven2":
+		if isMavenMetadata(filename) || component.Group == "" {
+			return "", "", "", false
+		}
+		return component.Group + "." + component.Name, component.Version, filename, true
+	case "raw":
+		name, version, ok = mavenCoordinates(path.Dir(strings.TrimPrefix(assetPath, "/")))
+		return name, version, filename, ok
+	}
+	return "", "", "", false
+}
+
+func (s *NexusSource) components(repository, continuation string) ([]nexusComponent, string, error) {
+	query := url.Values{"repository": {repository}}
+	if continuation != "" {
+		query.Set("continuationToken", continuation)
+	}
+	resp, err := get(s.baseURL+"/service/rest/v1/components?"+query.Encode(), s.authorization)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var page struct {
+		Items             []nexusComponent `json:"items"`
+		ContinuationToken string           `json:"continuationToken"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, "", fmt.Errorf("failed to decode components of %s: %w", repository, err)
+	}
+	return page.Items, page.ContinuationToken, nil
+}
+
+func (s *NexusSource) repositoriesOf(packageType string) ([]string, error) {
+	if len(s.repositories) > 0 {
+		return s.repositories, nil
+	}
+	resp, err := get(s.baseURL+"/service/rest/v1/repositories", s.authorization)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var repositories []struct {
+		Name   string `json:"name"`
+		Format string `json:"format"`
+		Type   string `json:"type"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&repositories); err != nil {
+		return nil, fmt.Errorf("failed to decode repositories: %w", err)
+	}
+	var names []string
+	for _, repository := range repositories {
+		// Raw repositories hold arbitrary files, they are only exported
+		// when listed explicitly
+		if repository.Type == "hosted" && repository.Format != "raw" && utils.Contains(nexusFormats[packageType], repository.Format) {
+			names = append(names, repository.Name)
+		}
+	}
+	return names, nil
+}
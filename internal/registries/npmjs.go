@@ -0,0 +1,285 @@
+package registries
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const npmjsRegistry = "https://registry.npmjs.org"
+
+// Rewriter is implemented by sources whose files have to be adjusted after
+// download before sync can publish them
+type Rewriter interface {
+	Rewrite(logger *zap.Logger, owner, packageType, path string) error
+}
+
+// npmjsSelector selects versions of a public package. The version is an
+// exact version, a dist-tag like latest, a wildcard like 4.x or * for all
+// versions.
+type npmjsSelector struct {
+	name    string
+	version string
+}
+
+var npmjsWildcard = regexp.MustCompile(`^\d+(\.\d+)?\.[x*]$`)
+
+// parseNpmjsSelector splits name@version, a missing version selects the
+// latest dist-tag
+func parseNpmjsSelector(entry string) npmjsSelector {
+	entry = strings.TrimSpace(entry)
+	if i := strings.LastIndex(entry, "@"); i > 0 {
+		return npmjsSelector{name: entry[:i], version: entry[i+1:]}
+	}
+	return npmjsSelector{name: entry, version: "latest"}
+}
+
+func (s npmjsSelector) matches(version string, distTags map[string]string) bool {
+	switch {
+	case s.version == "*":
+		return true
+	case npmjsWildcard.MatchString(s.version):
+		prefix := strings.TrimRight(s.version, "x*")
+		return strings.HasPrefix(version, prefix) && !strings.Contains(version, "-")
+	case distTags[s.version] != "":
+		return distTags[s.version] == version
+	}
+	return s.version == version
+}
+
+// npmjsName returns the GitHub package name of a public package. Scoped
+// names use the DefinitelyTyped convention, @babel/core becomes babel__core.
+func npmjsName(name string) string {
+	if strings.HasPrefix(name, "@") {
+		return strings.Replace(strings.TrimPrefix(name, "@"), "/", "__", 1)
+	}
+	return name
+}
+
+// NpmjsSource mirrors selected versions of public packages from
+// registry.npmjs.org, or another npm registry, into the source organization
+// scope
+type NpmjsSource struct {
+	registry      string
+	authorization string
+	selectors     []npmjsSelector
+}
+
+func init() {
+	registerSource("npmjs", NewNpmjsSource)
+	// Public packages are read anonymously
+	tokenOptional["npmjs"] = true
+}
+
+// NewNpmjsSource creates an npmjs source from the packages in
+// GHMPKG_SOURCE_PACKAGES and GHMPKG_SOURCE_PACKAGES_FILE.
+// GHMPKG_SOURCE_HOSTNAME and GHMPKG_SOURCE_TOKEN are only needed for other
+// or private registries.
+func NewNpmjsSource() (Source, error) {
+	registry := npmjsRegistry
+	if viper.GetString("GHMPKG_SOURCE_HOSTNAME") != "" {
+		var err error
+		if registry, err = baseURL("GHMPKG_SOURCE_HOSTNAME"); err != nil {
+			return nil, err
+		}
+	}
+
+	entries := splitList("GHMPKG_SOURCE_PACKAGES")
+	if packagesFile := viper.GetString("GHMPKG_SOURCE_PACKAGES_FILE"); packagesFile != "" {
+		file, err := os.Open(packagesFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open packages file: %w", err)
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				entries = append(entries, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read packages file: %w", err)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no packages to mirror, set GHMPKG_SOURCE_PACKAGES or GHMPKG_SOURCE_PACKAGES_FILE")
+	}
+
+	source := &NpmjsSource{
+		registry:      registry,
+		authorization: basicOrBearer(viper.GetString("GHMPKG_SOURCE_USERNAME"), viper.GetString("GHMPKG_SOURCE_TOKEN")),
+	}
+	for _, entry := range entries {
+		source.selectors = append(source.selectors, parseNpmjsSelector(entry))
+	}
+	return source, nil
+}
+
+func (s *NpmjsSource) PackageTypes() []string {
+	return []string{"npm"}
+}
+
+func (s *NpmjsSource) Authorization() string {
+	return s.authorization
+}
+
+// List returns the selected versions of the configured packages
+func (s *NpmjsSource) List(logger *zap.Logger, packageType string) ([]Package, error) {
+	set := packageSet{}
+	for _, selector := range s.selectors {
+		logger.Info("Resolving npm package", zap.String("package_name", selector.name), zap.String("package_version", selector.version))
+		resp, err := get(npmPackageURL(s.registry, selector.name), s.authorization)
+		if err != nil {
+			return nil, err
+		}
+		var document struct {
+			DistTags map[string]string `json:"dist-tags"`
+			Versions map[string]struct {
+				Dist struct {
+					Tarball string `json:"tarball"`
+				} `json:"dist"`
+			} `json:"versions"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&document)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", selector.name, err)
+		}
+
+		name := npmjsName(selector.name)
+		matched := 0
+		for version, manifest := range document.Versions {
+			if !selector.matches(version, document.DistTags) {
+				continue
+			}
+			set.add(name, version, File{Name: fmt.Sprintf("%s-%s.tgz", name, version), URL: manifest.Dist.Tarball})
+			matched++
+		}
+		if matched == 0 {
+			logger.Warn("No versions match", zap.String("package_name", selector.name), zap.String("package_version", selector.version))
+		}
+	}
+	return set.packages(), nil
+}
+
+// Rewrite publishes a mirrored tarball under the owner scope. The name is
+// scoped, dependencies on other mirrored packages become npm aliases of the
+// mirrored copies, and fields that point the publish at npmjs are dropped.
+func (s *NpmjsSource) Rewrite(logger *zap.Logger, owner, packageType, path string) error {
+	index, err := LoadIndex(owner, packageType)
+	if err != nil {
+		return err
+	}
+	return rewriteTarball(path, "package.json", func(content []byte) ([]byte, error) {
+		return mirrorManifest(content, owner, index.Has)
+	})
+}
+
+// mirrorManifest rewrites a package.json for publishing under the owner
+// scope, mirrored reports whether a GitHub package name is mirrored too
+func mirrorManifest(content []byte, owner string, mirrored func(string) bool) ([]byte, error) {
+	var manifest map[string]interface{}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode package.json: %w", err)
+	}
+	name, _ := manifest["name"].(string)
+	manifest["name"] = fmt.Sprintf("@%s/%s", owner, npmjsName(name))
+	delete(manifest, "publishConfig")
+	// GitHub links packages to the repository in package.json
+	delete(manifest, "repository")
+
+	for _, field := range []string{"dependencies", "optionalDependencies", "peerDependencies"} {
+		dependencies, _ := manifest[field].(map[string]interface{})
+		for dependency, value := range dependencies {
+			versionRange, _ := value.(string)
+			if strings.Contains(versionRange, ":") || !mirrored(npmjsName(dependency)) {
+				continue
+			}
+			dependencies[dependency] = fmt.Sprintf("npm:@%s/%s@%s", owner, npmjsName(dependency), versionRange)
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// rewriteTarball replaces a top level file of a gzipped tarball in place
+func rewriteTarball(path, filename string, rewrite func([]byte) ([]byte, error)) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	gzOut := gzip.NewWriter(out)
+	writer := tar.NewWriter(gzOut)
+
+	reader := tar.NewReader(gz)
+	found := false
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		if parts := strings.Split(header.Name, "/"); !found && len(parts) == 2 && parts[1] == filename {
+			if content, err = rewrite(content); err != nil {
+				return err
+			}
+			header.Size = int64(len(content))
+			found = true
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := writer.Write(content); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s not found in %s", filename, path)
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	if err := gzOut.Close(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), path)
+}
@@ -0,0 +1,276 @@
+package registries
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+)
+
+// npmManifest reads package/package.json from an npm tarball
+func npmManifest(tgz io.Reader) (map[string]interface{}, error) {
+	gz, err := gzip.NewReader(tgz)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("package.json not found in tarball")
+		}
+		if err != nil {
+			return nil, err
+		}
+		// npm packs into package/, some tools use other top level names
+		if parts := strings.Split(header.Name, "/"); len(parts) == 2 && parts[1] == "package.json" {
+			var manifest map[string]interface{}
+			if err := json.NewDecoder(reader).Decode(&manifest); err != nil {
+				return nil, fmt.Errorf("failed to decode package.json: %w", err)
+			}
+			return manifest, nil
+		}
+	}
+}
+
+// npmPackageURL returns the URL of a package document in an npm registry,
+// scoped names keep the @ and escape the slash
+func npmPackageURL(registryURL, name string) string {
+	return strings.TrimSuffix(registryURL, "/") + "/" + strings.Replace(url.PathEscape(name), "%40", "@", 1)
+}
+
+// npmVersionExists reports whether an npm registry already has a version
+func npmVersionExists(registryURL, authorization, name, version string) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, npmPackageURL(registryURL, name), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := utils.HTTPClient().Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("GET %s failed, status: %s", req.URL, resp.Status)
+	}
+	var document struct {
+		Versions map[string]json.RawMessage `json:"versions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&document); err != nil {
+		return false, err
+	}
+	_, found := document.Versions[version]
+	return found, nil
+}
+
+// base64Reader streams the base64 encoding of r
+func base64Reader(r io.Reader) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		encoder := base64.NewEncoder(base64.StdEncoding, writer)
+		_, err := io.Copy(encoder, r)
+		if err == nil {
+			err = encoder.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	return reader
+}
+
+// npmPublish publishes a tarball to an npm registry the way npm publish
+// does, it returns false when the version already exists
+func npmPublish(registryURL, authorization, path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	manifest, err := npmManifest(file)
+	if err != nil {
+		return false, err
+	}
+	name, _ := manifest["name"].(string)
+	version, _ := manifest["version"].(string)
+	if name == "" || version == "" {
+		return false, fmt.Errorf("package.json of %s has no name or version", path)
+	}
+
+	if found, err := npmVersionExists(registryURL, authorization, name, version); err != nil {
+		return false, err
+	} else if found {
+		return false, nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	shasum, integrity := sha1.New(), sha512.New()
+	size, err := io.Copy(io.MultiWriter(shasum, integrity), file)
+	if err != nil {
+		return false, err
+	}
+	filename := fmt.Sprintf("%s-%s.tgz", name[strings.LastIndex(name, "/")+1:], version)
+	manifest["_id"] = name + "@" + version
+	manifest["dist"] = map[string]interface{}{
+		"shasum":    hex.EncodeToString(shasum.Sum(nil)),
+		"integrity": "sha512-" + base64.StdEncoding.EncodeToString(integrity.Sum(nil)),
+		"tarball":   npmPackageURL(registryURL, name) + "/-/" + filename,
+	}
+	document := map[string]interface{}{
+		"_id":       name,
+		"name":      name,
+		"versions":  map[string]interface{}{version: manifest},
+		"dist-tags": map[string]string{"latest": version},
+		"_attachments": map[string]interface{}{
+			filename: map[string]interface{}{
+				"content_type": "application/octet-stream",
+				"data":         "",
+				"length":       size,
+			},
+		},
+	}
+	body, err := json.Marshal(document)
+	if err != nil {
+		return false, err
+	}
+	// The tarball is streamed base64 encoded into the empty data of the
+	// attachment, _attachments sorts first so it comes before the manifest
+	data := []byte(`"data":"`)
+	prefix, suffix, found := bytes.Cut(body, append(data, '"'))
+	if !found {
+		return false, fmt.Errorf("failed to build the publish document of %s@%s", name, version)
+	}
+	prefix = slices.Concat(prefix, data)
+	suffix = slices.Concat([]byte(`"`), suffix)
+
+	req, err := http.NewRequest(http.MethodPut, npmPackageURL(registryURL, name), nil)
+	if err != nil {
+		return false, err
+	}
+	length := int64(len(prefix)+len(suffix)) + int64(base64.StdEncoding.EncodedLen(int(size)))
+	err = utils.SetStreamBody(req, length, func() (io.ReadCloser, error) {
+		tgz, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64Reader(tgz)
+		return utils.StreamBody(io.MultiReader(bytes.NewReader(prefix), encoded, bytes.NewReader(suffix)), encoded, tgz), nil
+	})
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := utils.HTTPClient().Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusConflict {
+		return false, nil
+	}
+	if resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return false, fmt.Errorf("npm publish of %s@%s failed, status: %s %s", name, version, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return true, nil
+}
+
+// NugetPush pushes a package with the NuGet push protocol, it returns false
+// when the version already exists
+func NugetPush(pushURL, authorization, apiKey, path string) (bool, error) {
+	// Only the multipart framing is built in memory, the package is
+	// streamed between its header and trailer
+	var frame bytes.Buffer
+	writer := multipart.NewWriter(&frame)
+	if _, err := writer.CreateFormFile("package", filepath.Base(path)); err != nil {
+		return false, err
+	}
+	header := bytes.Clone(frame.Bytes())
+	frame.Reset()
+	if err := writer.Close(); err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, pushURL, nil)
+	if err != nil {
+		return false, err
+	}
+	if err := utils.SetFileBody(req, path, header, frame.Bytes()); err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if apiKey != "" {
+		req.Header.Set("X-NuGet-ApiKey", apiKey)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := utils.HTTPClient().Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusConflict {
+		return false, nil
+	}
+	if resp.StatusCode > 299 {
+		return false, fmt.Errorf("NuGet push of %s failed, status: %s", filepath.Base(path), resp.Status)
+	}
+	return true, nil
+}
+
+// IsSymbolPackage reports whether a NuGet file is a .snupkg symbol package
+func IsSymbolPackage(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".snupkg")
+}
+
+// NugetSymbolEndpoint returns the symbol package push URL of a NuGet v3
+// service index, it is empty when the feed does not accept symbol packages
+func NugetSymbolEndpoint(serviceIndex, authorization string) (string, error) {
+	resp, err := get(serviceIndex, authorization)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var index struct {
+		Resources []struct {
+			ID   string `json:"@id"`
+			Type string `json:"@type"`
+		} `json:"resources"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return "", fmt.Errorf("failed to decode NuGet service index %s: %w", serviceIndex, err)
+	}
+	for _, resource := range index.Resources {
+		if strings.HasPrefix(resource.Type, "SymbolPackagePublish") {
+			return resource.ID, nil
+		}
+	}
+	return "", nil
+}
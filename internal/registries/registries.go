@@ -0,0 +1,281 @@
+package registries
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/files"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// GitHub is the default registry, handled by the package providers
+const GitHub = "github"
+
+// File is a package file and where the source registry serves it from
+type File struct {
+	// Name is the local filename, it follows the naming the package
+	// providers use so sync can publish it unchanged
+	Name string
+	URL  string
+}
+
+// Version is a package version and its files
+type Version struct {
+	Name  string
+	Files []File
+}
+
+// Package is a package listed by a source registry
+type Package struct {
+	Name     string
+	Versions []Version
+}
+
+// Source lists and downloads packages from a registry other than GitHub
+// Packages. Export writes what it lists to the usual CSV files and pull
+// downloads from it, sync then publishes to GitHub as usual.
+type Source interface {
+	// PackageTypes returns the package types the registry can export
+	PackageTypes() []string
+	List(logger *zap.Logger, packageType string) ([]Package, error)
+	// Authorization returns the Authorization header value for downloads
+	Authorization() string
+}
+
+var sources = map[string]func() (Source, error){}
+
+// tokenOptional holds the registries that authenticate without a
+// configured token
+var tokenOptional = map[string]bool{}
+
+// TokenOptional reports whether a registry can be used without a token
+func TokenOptional(name string) bool {
+	return tokenOptional[name]
+}
+
+func registerSource(name string, factory func() (Source, error)) {
+	sources[name] = factory
+}
+
+// SourceName returns the configured source registry
+func SourceName() string {
+	if name := strings.ToLower(viper.GetString("GHMPKG_SOURCE_REGISTRY")); name != "" {
+		return name
+	}
+	return GitHub
+}
+
+// NewSource creates the configured source registry, it returns nil when
+// packages come from GitHub Packages
+func NewSource() (Source, error) {
+	name := SourceName()
+	if name == GitHub {
+		return nil, nil
+	}
+	factory, ok := sources[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported source registry: %s", name)
+	}
+	return factory()
+}
+
+// baseURL returns a registry URL from a hostname setting without a trailing
+// slash, https is assumed when no scheme is given
+func baseURL(key string) (string, error) {
+	hostname := strings.TrimSuffix(viper.GetString(key), "/")
+	if hostname == "" {
+		return "", fmt.Errorf("%s is required for this registry", key)
+	}
+	if !strings.Contains(hostname, "://") {
+		hostname = "https://" + hostname
+	}
+	return hostname, nil
+}
+
+// basicOrBearer returns Basic credentials when a username is configured and
+// a bearer token otherwise
+func basicOrBearer(username, token string) string {
+	if token == "" {
+		return ""
+	}
+	if username != "" {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+token))
+	}
+	return utils.Authorization(token, "Bearer")
+}
+
+// get performs an authorized GET request and returns the response when it
+// succeeded
+func get(url, authorization string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := utils.HTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s failed, status: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+// splitList splits a comma separated setting
+func splitList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(viper.GetString(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// packageSet collects listed files by package and version
+type packageSet map[string]map[string][]File
+
+func (s packageSet) add(name, version string, file File) {
+	if s[name] == nil {
+		s[name] = make(map[string][]File)
+	}
+	s[name][version] = append(s[name][version], file)
+}
+
+// packages returns the collected packages ordered by name and version so
+// exports are stable
+func (s packageSet) packages() []Package {
+	packages := make([]Package, 0, len(s))
+	for name, versions := range s {
+		pkg := Package{Name: name}
+		for version, versionFiles := range versions {
+			sort.Slice(versionFiles, func(i, j int) bool { return versionFiles[i].Name < versionFiles[j].Name })
+			pkg.Versions = append(pkg.Versions, Version{Name: version, Files: versionFiles})
+		}
+		sort.Slice(pkg.Versions, func(i, j int) bool { return pkg.Versions[i].Name < pkg.Versions[j].Name })
+		packages = append(packages, pkg)
+	}
+	sort.Slice(packages, func(i, j int) bool { return packages[i].Name < packages[j].Name })
+	return packages
+}
+
+// unscoped strips the scope from an npm package name, GitHub Packages names
+// npm packages without the scope, which is the organization
+func unscoped(name string) string {
+	if strings.HasPrefix(name, "@") {
+		if i := strings.Index(name, "/"); i >= 0 {
+			return name[i+1:]
+		}
+	}
+	return name
+}
+
+// mavenCoordinates splits a maven2 layout directory, e.g. com/example/lib/1.0,
+// into the GitHub package name com.example.lib and the version
+func mavenCoordinates(dir string) (name, version string, ok bool) {
+	parts := strings.Split(strings.Trim(dir, "/"), "/")
+	if len(parts) < 3 {
+		return "", "", false
+	}
+	version = parts[len(parts)-1]
+	name = strings.Join(parts[:len(parts)-1], ".")
+	return name, version, true
+}
+
+// isMavenMetadata reports whether a file is repository metadata rather than
+// part of a version
+func isMavenMetadata(filename string) bool {
+	return strings.HasPrefix(filename, "maven-metadata.xml")
+}
+
+// Index of file URLs
+// ------------------
+
+var indexHeader = []string{"package_name", "package_version", "package_filename", "url"}
+
+func indexPattern(owner, packageType string) string {
+	return filepath.Join("migration-packages", "export", packageType, fmt.Sprintf("*_%s_%s_sources.csv", owner, packageType))
+}
+
+// WriteIndex records where the files of the exported packages are downloaded
+// from, next to the export CSV
+func WriteIndex(owner, packageType string, packages []Package) (string, error) {
+	rows := [][]string{indexHeader}
+	for _, pkg := range packages {
+		for _, version := range pkg.Versions {
+			for _, file := range version.Files {
+				rows = append(rows, []string{pkg.Name, version.Name, file.Name, file.URL})
+			}
+		}
+	}
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
+	filename := filepath.Join("migration-packages", "export", packageType, fmt.Sprintf("%s_%s_%s_sources.csv", timestamp, owner, packageType))
+	return filename, files.CreateCSV(rows, filename)
+}
+
+// Index maps exported files to their download URLs
+type Index struct {
+	urls     map[string]string
+	packages map[string]bool
+}
+
+func indexKey(packageName, version, filename string) string {
+	return packageName + "\x00" + version + "\x00" + filename
+}
+
+// Has reports whether a package was exported
+func (i *Index) Has(packageName string) bool {
+	return i.packages[packageName]
+}
+
+// URL returns where a file is downloaded from
+func (i *Index) URL(packageName, version, filename string) (string, bool) {
+	url, ok := i.urls[indexKey(packageName, version, filename)]
+	return url, ok
+}
+
+var (
+	indexMu sync.Mutex
+	indexes = map[string]*Index{}
+)
+
+// LoadIndex reads the most recent index written by WriteIndex
+func LoadIndex(owner, packageType string) (*Index, error) {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	if index, ok := indexes[owner+"/"+packageType]; ok {
+		return index, nil
+	}
+
+	filename, err := utils.FindMostRecentFile(indexPattern(owner, packageType))
+	if err != nil {
+		return nil, fmt.Errorf("no source index found for %s packages, export again: %w", packageType, err)
+	}
+	rows, err := files.ReadCSV(filename)
+	if err != nil {
+		return nil, err
+	}
+	index := &Index{urls: make(map[string]string), packages: make(map[string]bool)}
+	for i, row := range rows {
+		if i == 0 || len(row) < len(indexHeader) {
+			continue
+		}
+		index.urls[indexKey(row[0], row[1], row[2])] = row[3]
+		index.packages[row[0]] = true
+	}
+	indexes[owner+"/"+packageType] = index
+	return index, nil
+}
@@ -0,0 +1,465 @@
+package registries
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+func TestArtifactoryFile(t *testing.T) {
+	var items []aqlItem
+	if err := json.Unmarshal([]byte(`[
+		{"repo":"npm-local","path":"@acme/widgets/-/@acme","name":"widgets-1.2.0.tgz","properties":[{"key":"npm.name","value":"@acme/widgets"},{"key":"npm.version","value":"1.2.0"}]},
+		{"repo":"nuget-local","path":"Acme.Core","name":"Acme.Core.2.0.0.nupkg","properties":[{"key":"nuget.id","value":"Acme.Core"},{"key":"nuget.version","value":"2.0.0"}]},
+		{"repo":"libs-release","path":"com/acme/core/1.0","name":"core-1.0.jar"},
+		{"repo":"libs-release","path":"com/acme/core","name":"maven-metadata.xml"}
+	]`), &items); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		packageType string
+		item        aqlItem
+		want        []string
+	}{
+		{"npm", items[0], []string{"widgets", "1.2.0", "widgets-1.2.0.tgz"}},
+		{"nuget", items[1], []string{"Acme.Core", "2.0.0", "Acme.Core-2.0.0.nupkg"}},
+		{"maven", items[2], []string{"com.acme.core", "1.0", "core-1.0.jar"}},
+		{"maven", items[3], nil},
+		{"npm", items[2], nil},
+	}
+	for _, tt := range tests {
+		name, version, filename, ok := artifactoryFile(tt.packageType, tt.item)
+		if tt.want == nil {
+			if ok {
+				t.Errorf("%s %s: got %s %s %s, want skipped", tt.packageType, tt.item.Name, name, version, filename)
+			}
+			continue
+		}
+		if !ok || name != tt.want[0] || version != tt.want[1] || filename != tt.want[2] {
+			t.Errorf("%s %s: got %s %s %s (%v), want %v", tt.packageType, tt.item.Name, name, version, filename, ok, tt.want)
+		}
+	}
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	set := packageSet{}
+	set.add("widgets", "1.2.0", File{Name: "widgets-1.2.0.tgz", URL: "https://acme.jfrog.io/artifactory/npm-local/widgets-1.2.0.tgz"})
+	set.add("widgets", "1.1.0", File{Name: "widgets-1.1.0.tgz", URL: "https://acme.jfrog.io/artifactory/npm-local/widgets-1.1.0.tgz"})
+	packages := set.packages()
+	if len(packages) != 1 || packages[0].Versions[0].Name != "1.1.0" {
+		t.Fatalf("packages = %+v, want one package with sorted versions", packages)
+	}
+
+	if _, err := WriteIndex("acme", "npm", packages); err != nil {
+		t.Fatal(err)
+	}
+	index, err := LoadIndex("acme", "npm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, ok := index.URL("widgets", "1.2.0", "widgets-1.2.0.tgz")
+	if !ok || url != "https://acme.jfrog.io/artifactory/npm-local/widgets-1.2.0.tgz" {
+		t.Errorf("URL = %q, %v", url, ok)
+	}
+	if _, ok := index.URL("widgets", "9.9.9", "widgets-9.9.9.tgz"); ok {
+		t.Error("unexpected URL for a file that was not exported")
+	}
+}
+
+func TestArtifactoryTargetPublish(t *testing.T) {
+	var deployed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodHead:
+			if strings.HasSuffix(r.URL.Path, "core-1.0.pom") {
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+		case http.MethodPut:
+			if r.Header.Get("X-Checksum-Sha256") == "" {
+				t.Errorf("PUT %s without checksum", r.URL.Path)
+			}
+			deployed = append(deployed, r.URL.Path)
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	viper.Set("GHMPKG_TARGET_HOSTNAME", server.URL)
+	viper.Set("GHMPKG_TARGET_TOKEN", "secret")
+	viper.Set("GHMPKG_TARGET_ORGANIZATION", "acme")
+	viper.Set("GHMPKG_TARGET_REPOSITORIES", "maven=libs-release,npm-local")
+	defer viper.Reset()
+
+	dir := t.TempDir()
+	pom := filepath.Join(dir, "core-1.0.pom")
+	jar := filepath.Join(dir, "core-1.0.jar")
+	tgz := filepath.Join(dir, "widgets-1.2.0.tgz")
+	os.WriteFile(pom, []byte(`<project><parent><groupId>com.acme.platform</groupId></parent><artifactId>core</artifactId></project>`), 0644)
+	os.WriteFile(jar, []byte("jar"), 0644)
+	os.WriteFile(tgz, []byte("tgz"), 0644)
+
+	// GitHub Packages is handled by the providers
+	target, err := NewTarget()
+	if target != nil || err != nil {
+		t.Fatalf("NewTarget() = %v, %v without a target registry, want nil", target, err)
+	}
+	viper.Set("GHMPKG_TARGET_REGISTRY", "artifactory")
+	if target, err = NewTarget(); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := zap.NewNop()
+	if published, err := target.Publish(logger, "maven", "com.acme.platform.core", "1.0", pom); err != nil || published {
+		t.Errorf("existing pom: published = %v, err = %v", published, err)
+	}
+	if published, err := target.Publish(logger, "maven", "com.acme.platform.core", "1.0", jar); err != nil || !published {
+		t.Errorf("jar: published = %v, err = %v", published, err)
+	}
+	if published, err := target.Publish(logger, "npm", "widgets", "1.2.0", tgz); err != nil || !published {
+		t.Errorf("tgz: published = %v, err = %v", published, err)
+	}
+
+	want := []string{
+		"/artifactory/libs-release/com/acme/platform/core/1.0/core-1.0.jar",
+		"/artifactory/npm-local/@acme/widgets/-/widgets-1.2.0.tgz",
+	}
+	if strings.Join(deployed, " ") != strings.Join(want, " ") {
+		t.Errorf("deployed %v, want %v", deployed, want)
+	}
+}
+
+func TestNexusFile(t *testing.T) {
+	tests := []struct {
+		component nexusComponent
+		assetPath string
+		want      []string
+	}{
+		{nexusComponent{Format: "npm", Group: "acme", Name: "widgets", Version: "1.2.0"}, "@acme/widgets/-/widgets-1.2.0.tgz", []string{"widgets", "1.2.0", "widgets-1.2.0.tgz"}},
+		{nexusComponent{Format: "maven2", Group: "com.acme", Name: "core", Version: "1.0"}, "com/acme/core/1.0/core-1.0.pom", []string{"com.acme.core", "1.0", "core-1.0.pom"}},
+		{nexusComponent{Format: "maven2", Group: "com.acme", Name: "core"}, "com/acme/core/maven-metadata.xml", nil},
+		{nexusComponent{Format: "raw", Name: "tools/installer/2.1/installer.zip"}, "/tools/installer/2.1/installer.zip", []string{"tools.installer", "2.1", "installer.zip"}},
+		{nexusComponent{Format: "raw", Name: "readme.txt"}, "/readme.txt", nil},
+	}
+	for _, tt := range tests {
+		name, version, filename, ok := nexusFile(tt.component, tt.assetPath)
+		if tt.want == nil {
+			if ok {
+				t.Errorf("%s: got %s %s %s, want skipped", tt.assetPath, name, version, filename)
+			}
+			continue
+		}
+		if !ok || name != tt.want[0] || version != tt.want[1] || filename != tt.want[2] {
+			t.Errorf("%s: got %s %s %s (%v), want %v", tt.assetPath, name, version, filename, ok, tt.want)
+		}
+	}
+}
+
+func TestAzureScope(t *testing.T) {
+	defer viper.Reset()
+	for hostname, want := range map[string]string{
+		"https://dev.azure.com/acme":          "https://pkgs.dev.azure.com/acme/_packaging",
+		"dev.azure.com/acme/payments/":        "https://pkgs.dev.azure.com/acme/payments/_packaging",
+		"https://dev.azure.com/acme/payments": "https://pkgs.dev.azure.com/acme/payments/_packaging",
+	} {
+		viper.Set("GHMPKG_TARGET_HOSTNAME", hostname)
+		scope, err := parseAzureScope("GHMPKG_TARGET_HOSTNAME")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := scope.url("pkgs", "_packaging"); got != want {
+			t.Errorf("%s: url() = %s, want %s", hostname, got, want)
+		}
+	}
+	viper.Set("GHMPKG_TARGET_HOSTNAME", "https://dev.azure.com")
+	if _, err := parseAzureScope("GHMPKG_TARGET_HOSTNAME"); err == nil {
+		t.Error("parseAzureScope() without an organization succeeded")
+	}
+}
+
+func TestNPMPublish(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	manifest := []byte(`{"name":"@acme/widgets","version":"1.2.0"}`)
+	tw.WriteHeader(&tar.Header{Name: "package/package.json", Mode: 0644, Size: int64(len(manifest))})
+	tw.Write(manifest)
+	tw.Close()
+	gz.Close()
+	tgz := filepath.Join(t.TempDir(), "widgets-1.2.0.tgz")
+	os.WriteFile(tgz, buf.Bytes(), 0644)
+
+	var published map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/npm/registry/@acme%2fwidgets" && r.URL.Path != "/npm/registry/@acme/widgets" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if published == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(published)
+		case http.MethodPut:
+			if r.Header.Get("Authorization") != "Basic token" {
+				t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+			}
+			json.NewDecoder(r.Body).Decode(&published)
+		}
+	}))
+	defer server.Close()
+
+	for i, want := range []bool{true, false} {
+		ok, err := npmPublish(server.URL+"/npm/registry/", "Basic token", tgz)
+		if err != nil || ok != want {
+			t.Fatalf("publish %d: published = %v, err = %v, want %v", i, ok, err, want)
+		}
+	}
+	versions, _ := published["versions"].(map[string]interface{})
+	version, _ := versions["1.2.0"].(map[string]interface{})
+	dist, _ := version["dist"].(map[string]interface{})
+	if dist["shasum"] == nil || !strings.HasSuffix(dist["tarball"].(string), "/-/widgets-1.2.0.tgz") {
+		t.Errorf("dist = %v", dist)
+	}
+	attachment, _ := published["_attachments"].(map[string]interface{})["widgets-1.2.0.tgz"].(map[string]interface{})
+	if data, _ := attachment["data"].(string); data != base64.StdEncoding.EncodeToString(buf.Bytes()) || attachment["length"] != float64(buf.Len()) {
+		t.Errorf("_attachments = %v", published["_attachments"])
+	}
+}
+
+func TestNugetPushStreamsLargePackages(t *testing.T) {
+	const size = 128 << 20
+	path := filepath.Join(t.TempDir(), "Acme.Core.1.0.0.nupkg")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteAt([]byte("end"), size-3)
+	file.Close()
+	want, _ := utils.FileChecksum(path, "sha256")
+
+	var received int64
+	var filename, digest string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		hash := sha256.New()
+		received, _ = io.Copy(hash, part)
+		filename, digest = part.FileName(), hex.EncodeToString(hash.Sum(nil))
+		if _, err := reader.NextPart(); err != io.EOF {
+			t.Errorf("expected the end of the form, got %v", err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	pushed, err := NugetPush(server.URL+"/api/v2/package", "", "key", path)
+	runtime.ReadMemStats(&after)
+	if err != nil || !pushed {
+		t.Fatalf("pushed = %v, err = %v", pushed, err)
+	}
+	if filename != "Acme.Core.1.0.0.nupkg" || received != size || digest != want {
+		t.Errorf("received %s of %d bytes with sha256 %s, want %d bytes with %s", filename, received, digest, size, want)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size/8 {
+		t.Errorf("pushing %s allocated %s, the package was not streamed", utils.FormatBytes(size), utils.FormatBytes(int64(allocated)))
+	}
+}
+
+func TestNugetSymbolEndpoint(t *testing.T) {
+	symbols := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resources := `{"@id":"https://nuget.example.com/api/v2/package","@type":"PackagePublish/2.0.0"}`
+		if symbols {
+			resources += `,{"@id":"https://nuget.example.com/api/v2/symbolpackage","@type":"SymbolPackagePublish/4.9.0"}`
+		}
+		w.Write([]byte(`{"version":"3.0.0","resources":[` + resources + `]}`))
+	}))
+	defer server.Close()
+
+	for _, want := range []string{"https://nuget.example.com/api/v2/symbolpackage", ""} {
+		got, err := NugetSymbolEndpoint(server.URL+"/index.json", "")
+		if err != nil || got != want {
+			t.Errorf("symbol endpoint = %q, %v, want %q", got, err, want)
+		}
+		symbols = false
+	}
+}
+
+func TestCodeArtifactTarget(t *testing.T) {
+	var tokens int
+	var deployed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/authorization-token":
+			if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256") || r.URL.Query().Get("domain-owner") != "111122223333" {
+				t.Errorf("unsigned token request %s", r.URL)
+			}
+			tokens++
+			// Expires right away so the next publish renews it
+			json.NewEncoder(w).Encode(map[string]interface{}{"authorizationToken": "token", "expiration": time.Now().Unix()})
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPut:
+			deployed = append(deployed, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	viper.Set("GHMPKG_TARGET_HOSTNAME", "https://acme-prod-111122223333.d.codeartifact.eu-west-1.amazonaws.com/")
+	viper.Set("GHMPKG_TARGET_TOKEN", "static")
+	viper.Set("GHMPKG_TARGET_REPOSITORIES", "shared")
+	defer viper.Reset()
+	created, err := NewCodeArtifactTarget()
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := created.(*CodeArtifactTarget)
+	if target.domain != "acme-prod" || target.owner != "111122223333" || target.region != "eu-west-1" {
+		t.Errorf("parsed domain %s, owner %s, region %s", target.domain, target.owner, target.region)
+	}
+
+	target.endpoint, target.api, target.token = server.URL, server.URL, ""
+	target.credentials = aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+	})
+	jar := filepath.Join(t.TempDir(), "core-1.0.jar")
+	os.WriteFile(jar, []byte("jar"), 0644)
+	for i := 0; i < 2; i++ {
+		if published, err := target.Publish(zap.NewNop(), "maven", "com.acme.core", "1.0", jar); err != nil || !published {
+			t.Fatalf("published = %v, err = %v", published, err)
+		}
+	}
+	if tokens != 2 {
+		t.Errorf("requested %d tokens, want 2", tokens)
+	}
+	if want := "/maven/shared/com/acme/core/1.0/core-1.0.jar"; len(deployed) != 2 || deployed[0] != want {
+		t.Errorf("deployed %v, want %s", deployed, want)
+	}
+}
+
+func TestGitLabFile(t *testing.T) {
+	tests := []struct {
+		packageType, name, version, filename string
+		wantName, wantFilename, wantPath     string
+	}{
+		{"npm", "@acme/widgets", "1.2.0", "widgets-1.2.0.tgz", "widgets", "widgets-1.2.0.tgz", "npm/@acme/widgets/-/widgets-1.2.0.tgz"},
+		{"maven", "com/acme/core", "1.0", "core-1.0.jar", "com.acme.core", "core-1.0.jar", "maven/com/acme/core/1.0/core-1.0.jar"},
+		{"maven", "com/acme/core", "1.0", "maven-metadata.xml", "", "", ""},
+	}
+	for _, test := range tests {
+		name, filename, ok := gitlabFile(test.packageType, test.name, test.version, test.filename)
+		if name != test.wantName || filename != test.wantFilename || ok != (test.wantName != "") {
+			t.Errorf("gitlabFile(%s, %s) = %s, %s, %v", test.packageType, test.filename, name, filename, ok)
+		}
+		if ok {
+			if path := gitlabFilePath(test.packageType, test.name, test.version, test.filename); path != test.wantPath {
+				t.Errorf("gitlabFilePath(%s, %s) = %s, want %s", test.packageType, test.filename, path, test.wantPath)
+			}
+		}
+	}
+}
+
+func TestNpmjsSelector(t *testing.T) {
+	distTags := map[string]string{"latest": "4.17.21", "next": "5.0.0-rc.1"}
+	tests := []struct {
+		entry, version string
+		want           bool
+	}{
+		{"lodash", "4.17.21", true},
+		{"lodash", "4.17.20", false},
+		{"lodash@next", "5.0.0-rc.1", true},
+		{"lodash@4.x", "4.17.20", true},
+		{"lodash@4.17.x", "4.16.0", false},
+		{"lodash@4.x", "4.18.0-beta.1", false},
+		{"lodash@*", "0.1.0", true},
+		{"@babel/core@7.24.0", "7.24.0", true},
+	}
+	for _, test := range tests {
+		if got := parseNpmjsSelector(test.entry).matches(test.version, distTags); got != test.want {
+			t.Errorf("%s matches %s = %v, want %v", test.entry, test.version, got, test.want)
+		}
+	}
+	if selector := parseNpmjsSelector("@babel/core"); selector.name != "@babel/core" || selector.version != "latest" {
+		t.Errorf("parseNpmjsSelector(@babel/core) = %+v", selector)
+	}
+}
+
+func TestMirrorManifest(t *testing.T) {
+	content := []byte(`{"name":"@babel/core","version":"7.24.0","repository":{"type":"git"},
+		"publishConfig":{"registry":"https://registry.npmjs.org"},
+		"dependencies":{"debug":"^4.1.0","semver":"^6.3.1","local":"file:../local"}}`)
+	mirrored := func(name string) bool { return name == "debug" || name == "local" }
+	rewritten, err := mirrorManifest(content, "acme", mirrored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest struct {
+		Name          string            `json:"name"`
+		Repository    interface{}       `json:"repository"`
+		PublishConfig interface{}       `json:"publishConfig"`
+		Dependencies  map[string]string `json:"dependencies"`
+	}
+	json.Unmarshal(rewritten, &manifest)
+	if manifest.Name != "@acme/babel__core" || manifest.Repository != nil || manifest.PublishConfig != nil {
+		t.Errorf("rewritten manifest %s", rewritten)
+	}
+	want := map[string]string{"debug": "npm:@acme/debug@^4.1.0", "semver": "^6.3.1", "local": "file:../local"}
+	for dependency, version := range want {
+		if manifest.Dependencies[dependency] != version {
+			t.Errorf("dependency %s = %s, want %s", dependency, manifest.Dependencies[dependency], version)
+		}
+	}
+}
+
+func TestArtifactRegistryTarget(t *testing.T) {
+	viper.Set("GHMPKG_TARGET_HOSTNAME", "us-east1-docker.pkg.dev/acme-prod")
+	viper.Set("GHMPKG_TARGET_TOKEN", "access")
+	viper.Set("GHMPKG_TARGET_REPOSITORIES", "container=images")
+	defer viper.Reset()
+	created, err := NewArtifactRegistryTarget()
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := created.(*ArtifactRegistryTarget)
+	if target.location != "us-east1" || target.project != "acme-prod" || target.host("npm") != "https://us-east1-npm.pkg.dev" {
+		t.Errorf("parsed location %s, project %s", target.location, target.project)
+	}
+}
@@ -0,0 +1,163 @@
+package registries
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Target publishes pulled packages to a registry other than GitHub Packages.
+// Files are rewritten for the target organization by the package providers
+// first, exactly like when publishing to GitHub.
+type Target interface {
+	// PackageTypes returns the package types the registry can publish
+	PackageTypes() []string
+	// Publish uploads a file of a package version, it returns false when
+	// the file already exists in the registry
+	Publish(logger *zap.Logger, packageType, packageName, version, path string) (bool, error)
+}
+
+var targets = map[string]func() (Target, error){}
+
+func registerTarget(name string, factory func() (Target, error)) {
+	targets[name] = factory
+}
+
+// TargetName returns the configured target registry
+func TargetName() string {
+	if name := strings.ToLower(viper.GetString("GHMPKG_TARGET_REGISTRY")); name != "" {
+		return name
+	}
+	return GitHub
+}
+
+// NewTarget creates the configured target registry, it returns nil when
+// packages are published to GitHub Packages
+func NewTarget() (Target, error) {
+	name := TargetName()
+	if name == GitHub {
+		return nil, nil
+	}
+	factory, ok := targets[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported target registry: %s", name)
+	}
+	return factory()
+}
+
+// targetRepositories parses GHMPKG_TARGET_REPOSITORIES, either one repository
+// for every package type or a list like npm=npm-local,maven=libs-release
+func targetRepositories() map[string]string {
+	repositories := make(map[string]string)
+	for _, entry := range splitList("GHMPKG_TARGET_REPOSITORIES") {
+		if packageType, repository, ok := strings.Cut(entry, "="); ok {
+			repositories[strings.TrimSpace(packageType)] = strings.TrimSpace(repository)
+		} else {
+			repositories[""] = entry
+		}
+	}
+	return repositories
+}
+
+// repositoryFor returns the target repository of a package type
+func repositoryFor(repositories map[string]string, packageType string) (string, error) {
+	if repository, ok := repositories[packageType]; ok {
+		return repository, nil
+	}
+	if repository, ok := repositories[""]; ok {
+		return repository, nil
+	}
+	return "", fmt.Errorf("no target repository configured for %s packages, set GHMPKG_TARGET_REPOSITORIES", packageType)
+}
+
+// exists reports whether a URL already serves a file
+func exists(url, authorization string) (bool, error) {
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return false, err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := utils.HTTPClient().Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// put uploads a file with a PUT request
+func put(url, path, authorization string, headers map[string]string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, url, utils.MeterReader(file, utils.Upload))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = stat.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := utils.HTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("PUT %s failed, status: %s", url, resp.Status)
+	}
+	return nil
+}
+
+type pom struct {
+	GroupID    string `xml:"groupId"`
+	ArtifactID string `xml:"artifactId"`
+	Parent     struct {
+		GroupID string `xml:"groupId"`
+	} `xml:"parent"`
+}
+
+// mavenDir returns the maven2 layout directory of a version, e.g.
+// com/acme/core/1.0. Coordinates are read from the pom next to the file,
+// the dotted GitHub package name is used when there is none.
+func mavenDir(packageName, version, path string) string {
+	poms, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.pom"))
+	for _, pomFile := range poms {
+		content, err := os.ReadFile(pomFile)
+		if err != nil {
+			continue
+		}
+		var project pom
+		if err := xml.Unmarshal(content, &project); err != nil {
+			continue
+		}
+		groupID := project.GroupID
+		if groupID == "" {
+			groupID = project.Parent.GroupID
+		}
+		if groupID != "" && project.ArtifactID != "" {
+			return strings.Join([]string{strings.ReplaceAll(groupID, ".", "/"), project.ArtifactID, version}, "/")
+		}
+	}
+	return strings.ReplaceAll(packageName, ".", "/") + "/" + version
+}
@@ -0,0 +1,159 @@
+package results
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+)
+
+// Lock is held by the run publishing to a target, so two operators sharing
+// the migration directory can't publish to the same organization at once
+type Lock struct {
+	Target    string    `json:"target"`
+	RunID     string    `json:"run_id"`
+	Phase     string    `json:"phase"`
+	Hostname  string    `json:"hostname"`
+	PID       int       `json:"pid"`
+	User      string    `json:"user,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+
+	path string
+}
+
+// LockedError is returned when another run holds the lock of a target
+type LockedError struct {
+	Path   string
+	Holder *Lock
+}
+
+func (e *LockedError) Error() string {
+	holder := e.Holder
+	by := holder.Hostname
+	if holder.User != "" {
+		by = holder.User + "@" + by
+	}
+	return fmt.Sprintf("%s is locked by %s run %s of %s (pid %d) since %s, see %s",
+		holder.Target, holder.Phase, holder.RunID, by, holder.PID, holder.StartedAt.Local().Format(time.DateTime), e.Path)
+}
+
+var unsafeLockChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// lockPath returns the lock file of a target
+func lockPath(dir, target string) string {
+	return filepath.Join(dir, unsafeLockChars.ReplaceAllString(target, "_")+".lock")
+}
+
+// stale reports whether a lock was left behind by a run of this host that
+// is no longer running
+func (l *Lock) stale(hostname string) bool {
+	return l.Hostname == hostname && !utils.ProcessRunning(l.PID)
+}
+
+// ReadLock reads a lock file
+func ReadLock(path string) (*Lock, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lock Lock
+	if err := json.Unmarshal(content, &lock); err != nil {
+		return nil, fmt.Errorf("failed to parse lock file %s: %w", path, err)
+	}
+	lock.path = path
+	return &lock, nil
+}
+
+// AcquireLock creates the lock file of a target in dir. A lock left behind
+// by a run of this host that is gone is taken over, breakLock takes over
+// any lock.
+func AcquireLock(dir, target, runID, phase string, breakLock bool) (*Lock, error) {
+	hostname, _ := os.Hostname()
+	lock := &Lock{
+		Target:    target,
+		RunID:     runID,
+		Phase:     phase,
+		Hostname:  hostname,
+		PID:       os.Getpid(),
+		StartedAt: time.Now(),
+		path:      lockPath(dir, target),
+	}
+	if current, err := user.Current(); err == nil {
+		lock.User = current.Username
+	}
+	content, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	// The lock is taken over at most once, a run that takes it in between
+	// keeps it
+	for takenOver := false; ; takenOver = true {
+		file, err := os.OpenFile(lock.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = file.Write(append(content, '\n'))
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(lock.path)
+				return nil, fmt.Errorf("failed to write lock file: %w", err)
+			}
+			return lock, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+
+		holder, err := ReadLock(lock.path)
+		if errors.Is(err, fs.ErrNotExist) && !takenOver {
+			// Released in between
+			continue
+		}
+		if takenOver {
+			if err == nil {
+				return nil, &LockedError{Path: lock.path, Holder: holder}
+			}
+			return nil, fmt.Errorf("failed to take over lock file %s: %w", lock.path, err)
+		}
+		if err != nil && !breakLock {
+			return nil, err
+		}
+		if err == nil && !breakLock && !holder.stale(hostname) {
+			return nil, &LockedError{Path: lock.path, Holder: holder}
+		}
+		if err := os.Remove(lock.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove lock file: %w", err)
+		}
+	}
+}
+
+// Path returns the lock file
+func (l *Lock) Path() string {
+	return l.path
+}
+
+// Release removes the lock file, unless another run took the lock over
+func (l *Lock) Release() error {
+	holder, err := ReadLock(l.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if holder.RunID != l.RunID || holder.PID != l.PID || holder.Hostname != l.Hostname {
+		return fmt.Errorf("lock file %s was taken over by run %s", l.path, holder.RunID)
+	}
+	return os.Remove(l.path)
+}
@@ -0,0 +1,73 @@
+package results
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAcquireLock(t *testing.T) {
+	dir := t.TempDir()
+	target := "github/https://ghes.example.com/org"
+	lock, err := AcquireLock(dir, target, "run-1", "sync", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var locked *LockedError
+	if _, err := AcquireLock(dir, target, "run-2", "sync", false); !errors.As(err, &locked) || locked.Holder.RunID != "run-1" {
+		t.Fatalf("second lock = %v, want held by run-1", err)
+	}
+	other, err := AcquireLock(dir, "github//other-org", "run-2", "sync", false)
+	if err != nil {
+		t.Fatalf("lock of another target = %v", err)
+	}
+	other.Release()
+
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = AcquireLock(dir, target, "run-2", "migrate", false)
+	if err != nil {
+		t.Fatalf("lock after release = %v", err)
+	}
+	lock.Release()
+}
+
+func TestAcquireLockTakesOver(t *testing.T) {
+	dir := t.TempDir()
+	target := "github//org"
+	hostname, _ := os.Hostname()
+	write := func(holder Lock) {
+		content, _ := json.Marshal(holder)
+		if err := os.WriteFile(lockPath(dir, target), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A run of this host that is gone
+	write(Lock{Target: target, RunID: "gone", Hostname: hostname, PID: math.MaxInt32, StartedAt: time.Now()})
+	lock, err := AcquireLock(dir, target, "run-1", "sync", false)
+	if err != nil {
+		t.Fatalf("stale lock not taken over: %v", err)
+	}
+
+	// A run of another host can only be broken
+	write(Lock{Target: target, RunID: "remote", Hostname: hostname + "-other", PID: 1, StartedAt: time.Now()})
+	if err := lock.Release(); err == nil {
+		t.Error("released a lock taken over by another run")
+	}
+	if _, err := AcquireLock(dir, target, "run-2", "sync", false); err == nil {
+		t.Fatal("took over the lock of another host")
+	}
+	lock, err = AcquireLock(dir, target, "run-2", "sync", true)
+	if err != nil {
+		t.Fatalf("broken lock = %v", err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,80 @@
+package results
+
+import (
+	"sort"
+	"strings"
+)
+
+// Merge combines the rows of several results files into one row per file,
+// the most recent row wins regardless of which file it came from
+func Merge(sets ...[]Row) []Row {
+	var rows []Row
+	for _, set := range sets {
+		rows = append(rows, set...)
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Timestamp.Before(rows[j].Timestamp) })
+	return Latest(rows)
+}
+
+// MergeTotals combines the totals of several runs. Retried runs share their
+// work and runs split by hand, like one per package type, split it, so the
+// largest total of every package type is kept and raised to what the merged
+// rows show was done. Runs split with --shard are combined by ShardTotals
+// first.
+func MergeTotals(rows []Row, totals ...map[string]Totals) map[string]Totals {
+	merged := make(map[string]Totals)
+	for _, total := range totals {
+		for packageType, t := range total {
+			m := merged[packageType]
+			m.Packages = max(m.Packages, t.Packages)
+			m.Versions = max(m.Versions, t.Versions)
+			m.Files = max(m.Files, t.Files)
+			merged[packageType] = m
+		}
+	}
+	for packageType, summary := range Summarize(nil, rows) {
+		m := merged[packageType]
+		m.Packages = max(m.Packages, summary.Packages.Done())
+		m.Versions = max(m.Versions, summary.Versions.Done())
+		m.Files = max(m.Files, summary.Files.Done())
+		merged[packageType] = m
+	}
+	return merged
+}
+
+// ShardTotals combines the totals of runs split with --shard, shards are
+// given as i/n and empty for runs that weren't sharded. Runs of the same
+// shard share their work and keep the largest totals, the totals of the
+// shards of a split are added up. Runs that weren't sharded are returned as
+// they are.
+func ShardTotals(shards []string, totals []map[string]Totals) []map[string]Totals {
+	var combined []map[string]Totals
+	var splits []string
+	byShard := make(map[string]map[string]map[string]Totals)
+	for i, total := range totals {
+		_, count, ok := strings.Cut(shards[i], "/")
+		if !ok {
+			combined = append(combined, total)
+			continue
+		}
+		if byShard[count] == nil {
+			byShard[count] = make(map[string]map[string]Totals)
+			splits = append(splits, count)
+		}
+		byShard[count][shards[i]] = MergeTotals(nil, byShard[count][shards[i]], total)
+	}
+	for _, count := range splits {
+		sum := make(map[string]Totals)
+		for _, shardTotals := range byShard[count] {
+			for packageType, t := range shardTotals {
+				s := sum[packageType]
+				s.Packages += t.Packages
+				s.Versions += t.Versions
+				s.Files += t.Files
+				sum[packageType] = s
+			}
+		}
+		combined = append(combined, sum)
+	}
+	return combined
+}
@@ -0,0 +1,262 @@
+package results
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+)
+
+// Dir is where results and state files of every run are written
+const Dir = "migration-packages/results"
+
+// Header is the column layout of a results file
+var Header = []string{
+	"timestamp",
+	"phase",
+	"organization",
+	"repository",
+	"package_type",
+	"package_name",
+	"package_version",
+	"package_filename",
+	"state",
+	"error",
+	"error_class",
+	"bytes",
+	"duration_ms",
+	"http_status",
+	"attempts",
+	"sha256",
+	"run_id",
+}
+
+// Row is the outcome of processing a single file
+type Row struct {
+	Timestamp    time.Time
+	Phase        string
+	Organization string
+	Repository   string
+	PackageType  string
+	PackageName  string
+	Version      string
+	Filename     string
+	State        string
+	Error        string
+	// ErrorClass is the cause of a failure, see the failures package
+	ErrorClass string
+	// Bytes, Duration, HTTPStatus and Attempts describe the transfer, they
+	// are zero when not known
+	Bytes      int64
+	Duration   time.Duration
+	HTTPStatus int
+	Attempts   int
+	// SHA256 is the digest of a file that was copied, when it is on disk
+	SHA256 string
+	// RunID is the run that wrote the row, rows of merged results keep the
+	// run they came from
+	RunID string
+}
+
+// Key identifies the file a row is about, regardless of when it was written
+func (r Row) Key() string {
+	return strings.Join([]string{r.Organization, r.PackageType, r.PackageName, r.Version, r.Filename}, "\x00")
+}
+
+func (r Row) record() []string {
+	return []string{
+		r.Timestamp.UTC().Format(time.RFC3339),
+		r.Phase,
+		r.Organization,
+		r.Repository,
+		r.PackageType,
+		r.PackageName,
+		r.Version,
+		r.Filename,
+		r.State,
+		r.Error,
+		r.ErrorClass,
+		optional(r.Bytes),
+		optional(r.Duration.Milliseconds()),
+		optional(int64(r.HTTPStatus)),
+		optional(int64(r.Attempts)),
+		r.SHA256,
+		r.RunID,
+	}
+}
+
+// optional leaves unknown values empty
+func optional(value int64) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.FormatInt(value, 10)
+}
+
+// Writer appends rows to a results file, every row is flushed and synced to
+// disk as soon as it is written so the file can be read while the run is
+// still going and a crash loses at most the row being written
+type Writer struct {
+	mu   sync.Mutex
+	file *os.File
+	csv  *csv.Writer
+	Path string
+}
+
+// Create opens a results file for appending, writing the header when the
+// file is new
+func Create(path string) (*Writer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	w := &Writer{file: file, csv: csv.NewWriter(file), Path: path}
+	if info.Size() == 0 {
+		if err := w.write(Header); err != nil {
+			file.Close()
+			return nil, err
+		}
+	} else if err := terminate(path, info.Size()); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write appends a row to the results file
+func (w *Writer) Write(row Row) error {
+	if row.Timestamp.IsZero() {
+		row.Timestamp = time.Now()
+	}
+	if row.RunID == "" {
+		row.RunID = utils.RunID()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.write(row.record())
+}
+
+func (w *Writer) write(record []string) error {
+	if err := w.csv.Write(record); err != nil {
+		return err
+	}
+	w.csv.Flush()
+	if err := w.csv.Error(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// terminate ends a partial last row left by a process that was killed while
+// writing, so appended rows start on a line of their own
+func terminate(path string, size int64) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, size-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+	_, err = file.WriteAt([]byte("\n"), size)
+	return err
+}
+
+// Close closes the underlying file
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.csv.Flush()
+	return w.file.Close()
+}
+
+// Read loads all rows of a results file. Columns are matched by header name
+// so files written by other versions of the tool can still be read.
+func Read(path string) ([]Row, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read results header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+	get := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	var rows []Row
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// A run that is still writing may leave a partial last line
+			if _, ok := err.(*csv.ParseError); ok {
+				break
+			}
+			return nil, err
+		}
+		timestamp, _ := time.Parse(time.RFC3339, get(record, "timestamp"))
+		rows = append(rows, Row{
+			Timestamp:    timestamp,
+			Phase:        get(record, "phase"),
+			Organization: get(record, "organization"),
+			Repository:   get(record, "repository"),
+			PackageType:  get(record, "package_type"),
+			PackageName:  get(record, "package_name"),
+			Version:      get(record, "package_version"),
+			Filename:     get(record, "package_filename"),
+			State:        get(record, "state"),
+			Error:        get(record, "error"),
+			ErrorClass:   get(record, "error_class"),
+			Bytes:        number(get(record, "bytes")),
+			Duration:     time.Duration(number(get(record, "duration_ms"))) * time.Millisecond,
+			HTTPStatus:   int(number(get(record, "http_status"))),
+			Attempts:     int(number(get(record, "attempts"))),
+			SHA256:       get(record, "sha256"),
+			RunID:        get(record, "run_id"),
+		})
+	}
+	return rows, nil
+}
+
+func number(value string) int64 {
+	n, _ := strconv.ParseInt(value, 10, 64)
+	return n
+}
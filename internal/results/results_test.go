@@ -0,0 +1,166 @@
+package results
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestWriteReadSummarize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.csv")
+	w, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := []Row{
+		{Organization: "org", PackageType: "npm", PackageName: "a", Version: "1.0.0", Filename: "a-1.0.0.tgz", State: "Failed", Error: "boom, with a comma"},
+		{Organization: "org", PackageType: "npm", PackageName: "a", Version: "1.0.0", Filename: "a-1.0.0.tgz", State: "Success"},
+		{Organization: "org", PackageType: "npm", PackageName: "a", Version: "2.0.0", Filename: "a-2.0.0.tgz", State: "Skipped"},
+		{Organization: "org", PackageType: "maven", PackageName: "b", Version: "1", Filename: "b-1.jar", State: "Success", Bytes: 2048, Duration: 1500 * time.Millisecond, HTTPStatus: 201, Attempts: 2},
+		{Organization: "org", PackageType: "maven", PackageName: "b", Version: "1", Filename: "b-1.pom", State: "Failed"},
+	}
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(rows) {
+		t.Fatalf("expected %d rows, got %d", len(rows), len(read))
+	}
+	if read[0].Error != "boom, with a comma" {
+		t.Errorf("unexpected error column: %q", read[0].Error)
+	}
+	if jar := read[3]; jar.Bytes != 2048 || jar.Duration != 1500*time.Millisecond || jar.HTTPStatus != 201 || jar.Attempts != 2 {
+		t.Errorf("unexpected transfer columns: %+v", jar)
+	}
+	if read[0].RunID == "" || read[0].RunID != read[4].RunID {
+		t.Errorf("rows are not tagged with the run ID: %q, %q", read[0].RunID, read[4].RunID)
+	}
+
+	summaries := Summarize(map[string]Totals{
+		"npm":   {Packages: 2, Versions: 3, Files: 3},
+		"maven": {Packages: 1, Versions: 1, Files: 2},
+	}, read)
+
+	npm := summaries["npm"]
+	if npm.Files != (Counts{Success: 1, Skipped: 1, Pending: 1}) {
+		t.Errorf("unexpected npm files: %+v", npm.Files)
+	}
+	if npm.Versions != (Counts{Success: 1, Skipped: 1, Pending: 1}) {
+		t.Errorf("unexpected npm versions: %+v", npm.Versions)
+	}
+	if npm.Packages != (Counts{Skipped: 1, Pending: 1}) {
+		t.Errorf("unexpected npm packages: %+v", npm.Packages)
+	}
+	maven := summaries["maven"]
+	if maven.Versions != (Counts{Failed: 1}) || maven.Packages != (Counts{Failed: 1}) {
+		t.Errorf("unexpected maven summary: %+v", maven)
+	}
+}
+
+func TestLoadCompleted(t *testing.T) {
+	dir := t.TempDir()
+	for i, target := range []string{"github//octo", "github//other", ""} {
+		state := NewState("sync", "org")
+		state.Target = target
+		state.ResultsFile = filepath.Join(dir, fmt.Sprintf("%d_results.csv", i))
+		state.path = filepath.Join(dir, fmt.Sprintf("2025-01-0%d_org_sync_state.json", i+1))
+		if err := state.Save(); err != nil {
+			t.Fatal(err)
+		}
+		w, err := Create(state.ResultsFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(Row{Organization: "org", PackageType: "maven", PackageName: "b", Version: "1", Filename: "b-1.jar", State: "Success"})
+		w.Write(Row{Organization: "org", PackageType: "maven", PackageName: "b", Version: "1", Filename: "b-1.pom", State: "Failed"})
+		if target != "github//octo" {
+			w.Write(Row{Organization: "org", PackageType: "maven", PackageName: "b", Version: "1", Filename: "b-1.pom", State: "Success"})
+		}
+		w.Close()
+	}
+
+	completed, err := LoadCompleted(zap.NewNop(), dir, "github//octo", "sync", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !completed.HasPackage("org", "maven", "b") || completed.HasPackage("org", "maven", "c") {
+		t.Error("unexpected packages")
+	}
+	pending := completed.Pending("org", "maven", "b", "1", []string{"b-1.jar", "b-1.pom", "b-1.pom.sha1"})
+	if strings.Join(pending, ",") != "b-1.pom,b-1.pom.sha1" {
+		t.Errorf("pending = %v", pending)
+	}
+}
+
+func TestCreateTerminatesPartialRow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.csv")
+	w, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(Row{Organization: "org", PackageType: "npm", PackageName: "a", Version: "1.0.0", Filename: "a-1.0.0.tgz", State: "Success"})
+	w.Close()
+	// A process killed while writing leaves half a row behind
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString("2025-01-01T00:00:00Z,sync,org,,npm,b")
+	file.Close()
+
+	if w, err = Create(path); err != nil {
+		t.Fatal(err)
+	}
+	w.Write(Row{Organization: "org", PackageType: "npm", PackageName: "c", Version: "1.0.0", Filename: "c-1.0.0.tgz", State: "Success"})
+	w.Close()
+
+	rows, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[2].PackageName != "c" || rows[2].State != "Success" {
+		t.Errorf("unexpected rows: %+v", rows)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	at := func(minute int) time.Time { return time.Date(2025, 1, 1, 0, minute, 0, 0, time.UTC) }
+	shard := []Row{
+		{Timestamp: at(1), Organization: "org", PackageType: "npm", PackageName: "a", Version: "1", Filename: "a-1.tgz", State: "Failed"},
+		{Timestamp: at(2), Organization: "org", PackageType: "npm", PackageName: "b", Version: "1", Filename: "b-1.tgz", State: "Success"},
+	}
+	retry := []Row{
+		{Timestamp: at(0), Organization: "org", PackageType: "npm", PackageName: "b", Version: "1", Filename: "b-1.tgz", State: "Failed"},
+		{Timestamp: at(3), Organization: "org", PackageType: "npm", PackageName: "a", Version: "1", Filename: "a-1.tgz", State: "Success"},
+	}
+
+	merged := Merge(shard, retry)
+	if len(merged) != 2 || merged[0].PackageName != "b" || merged[0].State != "Success" || merged[1].State != "Success" {
+		t.Errorf("unexpected merge: %+v", merged)
+	}
+	totals := MergeTotals(merged, map[string]Totals{"npm": {Packages: 1, Versions: 1, Files: 1}}, map[string]Totals{"npm": {Packages: 3, Versions: 3, Files: 3}})
+	if totals["npm"] != (Totals{Packages: 3, Versions: 3, Files: 3}) {
+		t.Errorf("unexpected totals: %+v", totals)
+	}
+
+	// Shards add up, a retried shard doesn't
+	shards := ShardTotals([]string{"1/2", "2/2", "2/2"}, []map[string]Totals{
+		{"npm": {Packages: 2, Versions: 2, Files: 2}},
+		{"npm": {Packages: 3, Versions: 3, Files: 3}},
+		{"npm": {Packages: 3, Versions: 3, Files: 3}},
+	})
+	if totals := MergeTotals(nil, shards...); totals["npm"] != (Totals{Packages: 5, Versions: 5, Files: 5}) {
+		t.Errorf("unexpected shard totals: %+v", totals)
+	}
+}
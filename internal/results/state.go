@@ -0,0 +1,220 @@
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"go.uber.org/zap"
+)
+
+// Totals counts the work a run was asked to do for one package type
+type Totals struct {
+	Packages int `json:"packages"`
+	Versions int `json:"versions"`
+	Files    int `json:"files"`
+}
+
+// State describes a run, it is written when the run starts and updated
+// when it finishes
+type State struct {
+	RunID        string            `json:"run_id,omitempty"`
+	Phase        string            `json:"phase"`
+	Organization string            `json:"organization"`
+	Target       string            `json:"target,omitempty"`
+	Shard        string            `json:"shard,omitempty"`
+	StartedAt    time.Time         `json:"started_at"`
+	FinishedAt   *time.Time        `json:"finished_at,omitempty"`
+	PID          int               `json:"pid"`
+	Hostname     string            `json:"hostname"`
+	ResultsFile  string            `json:"results_file"`
+	Totals       map[string]Totals `json:"totals"`
+	Error        string            `json:"error,omitempty"`
+	// MergedFrom lists the results files a merged run was made of
+	MergedFrom []string `json:"merged_from,omitempty"`
+
+	path string
+}
+
+// NewState prepares the state and results file names of a new run
+func NewState(phase, organization string) *State {
+	startedAt := time.Now()
+	hostname, _ := os.Hostname()
+	// Runs started in the same second, like parallel shards, are told
+	// apart by the random part of their run ID
+	runID := utils.RunID()
+	prefix := fmt.Sprintf("%s_%s_%s_%s", startedAt.Format("2006-01-02_15-04-05"), organization, runID[strings.LastIndex(runID, "-")+1:], strings.ToLower(phase))
+	return &State{
+		RunID:        runID,
+		Phase:        strings.ToLower(phase),
+		Organization: organization,
+		StartedAt:    startedAt,
+		PID:          os.Getpid(),
+		Hostname:     hostname,
+		ResultsFile:  filepath.Join(Dir, prefix+"_results.csv"),
+		Totals:       make(map[string]Totals),
+		path:         filepath.Join(Dir, prefix+"_state.json"),
+	}
+}
+
+// Path returns where the state file is stored
+func (s *State) Path() string {
+	return s.path
+}
+
+// Save writes the state file atomically
+func (s *State) Save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.ReplaceFile(s.path, content)
+}
+
+// Finish marks the run as finished and saves the state
+func (s *State) Finish(runErr error) error {
+	finishedAt := time.Now()
+	s.FinishedAt = &finishedAt
+	if runErr != nil {
+		s.Error = runErr.Error()
+	}
+	return s.Save()
+}
+
+// Running reports whether the run has not finished yet
+func (s *State) Running() bool {
+	return s.FinishedAt == nil
+}
+
+// Elapsed returns how long the run took, or has taken so far
+func (s *State) Elapsed() time.Duration {
+	if s.FinishedAt != nil {
+		return s.FinishedAt.Sub(s.StartedAt)
+	}
+	return time.Since(s.StartedAt)
+}
+
+// LoadState reads a state file
+func LoadState(path string) (*State, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	state := &State{path: path}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// FindStates returns the state files in dir, most recent first. An empty
+// phase matches every phase.
+func FindStates(dir, phase string) ([]string, error) {
+	pattern := filepath.Join(dir, "*_state.json")
+	if phase != "" {
+		pattern = filepath.Join(dir, fmt.Sprintf("*_%s_state.json", strings.ToLower(phase)))
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	// Names start with a sortable timestamp
+	sort.Sort(sort.Reverse(sort.StringSlice(matches)))
+	return matches, nil
+}
+
+// LatestStates loads the most recent run of every phase found in dir, or of
+// a single phase when one is given
+func LatestStates(logger *zap.Logger, dir, phase string) ([]*State, error) {
+	matches, err := FindStates(dir, phase)
+	if err != nil {
+		return nil, err
+	}
+	var states []*State
+	seen := make(map[string]bool)
+	for _, path := range matches {
+		state, err := LoadState(path)
+		if err != nil {
+			logger.Warn("Skipping unreadable state file", zap.String("file", path), zap.Error(err))
+			continue
+		}
+		if !seen[state.Phase] {
+			seen[state.Phase] = true
+			states = append(states, state)
+		}
+	}
+	return states, nil
+}
+
+// Completed holds the files earlier runs published successfully, so a run
+// only publishes the files of a version that are still missing
+type Completed struct {
+	files    map[string]bool
+	packages map[string]bool
+}
+
+// LoadCompleted reads the results of every earlier run of the phases that
+// published to target. Runs that recorded no target are ignored.
+func LoadCompleted(logger *zap.Logger, dir, target string, phases ...string) (*Completed, error) {
+	completed := &Completed{files: make(map[string]bool), packages: make(map[string]bool)}
+	for _, phase := range phases {
+		matches, err := FindStates(dir, phase)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range matches {
+			state, err := LoadState(path)
+			if err != nil {
+				logger.Warn("Skipping unreadable state file", zap.String("file", path), zap.Error(err))
+				continue
+			}
+			if state.Target == "" || state.Target != target {
+				continue
+			}
+			rows, err := Read(state.ResultsFile)
+			if err != nil {
+				logger.Warn("Skipping unreadable results file", zap.String("file", state.ResultsFile), zap.Error(err))
+				continue
+			}
+			for _, row := range rows {
+				completed.packages[packageKey(row.Organization, row.PackageType, row.PackageName)] = true
+				if row.State == "Success" {
+					completed.files[row.Key()] = true
+				}
+			}
+		}
+	}
+	return completed, nil
+}
+
+func packageKey(organization, packageType, packageName string) string {
+	return strings.Join([]string{organization, packageType, packageName}, "\x00")
+}
+
+// HasPackage reports whether an earlier run worked on a package
+func (c *Completed) HasPackage(organization, packageType, packageName string) bool {
+	return c != nil && c.packages[packageKey(organization, packageType, packageName)]
+}
+
+// Pending returns the files of a version no earlier run published
+func (c *Completed) Pending(organization, packageType, packageName, version string, filenames []string) []string {
+	if c == nil {
+		return filenames
+	}
+	var pending []string
+	for _, filename := range filenames {
+		row := Row{Organization: organization, PackageType: packageType, PackageName: packageName, Version: version, Filename: filename}
+		if !c.files[row.Key()] {
+			pending = append(pending, filename)
+		}
+	}
+	return pending
+}
@@ -0,0 +1,106 @@
+package results
+
+import "strings"
+
+// Counts tallies outcomes at one level (packages, versions or files)
+type Counts struct {
+	Success int
+	Skipped int
+	Failed  int
+	Pending int
+}
+
+// Done returns how many items have an outcome
+func (c Counts) Done() int {
+	return c.Success + c.Skipped + c.Failed
+}
+
+// TypeSummary holds the counts of one package type
+type TypeSummary struct {
+	Packages Counts
+	Versions Counts
+	Files    Counts
+}
+
+// Summarize combines the totals of a run with the rows recorded so far.
+// Later rows for the same file replace earlier ones. A version is failed
+// when any of its files failed and skipped when any was skipped, packages
+// are derived from their versions the same way.
+func Summarize(totals map[string]Totals, rows []Row) map[string]*TypeSummary {
+	summaries := make(map[string]*TypeSummary)
+	get := func(packageType string) *TypeSummary {
+		if summaries[packageType] == nil {
+			summaries[packageType] = &TypeSummary{}
+		}
+		return summaries[packageType]
+	}
+
+	versions := make(map[string]string)
+	packages := make(map[string]string)
+	versionTypes := make(map[string]string)
+	packageTypes := make(map[string]string)
+	for _, row := range Latest(rows) {
+		summary := get(row.PackageType)
+		tally(&summary.Files, row.State)
+
+		versionKey := strings.Join([]string{row.Organization, row.PackageType, row.PackageName, row.Version}, "\x00")
+		packageKey := strings.Join([]string{row.Organization, row.PackageType, row.PackageName}, "\x00")
+		versions[versionKey] = worst(versions[versionKey], row.State)
+		packages[packageKey] = worst(packages[packageKey], row.State)
+		versionTypes[versionKey] = row.PackageType
+		packageTypes[packageKey] = row.PackageType
+	}
+	for key, state := range versions {
+		tally(&get(versionTypes[key]).Versions, state)
+	}
+	for key, state := range packages {
+		tally(&get(packageTypes[key]).Packages, state)
+	}
+
+	for packageType, total := range totals {
+		summary := get(packageType)
+		summary.Packages.Pending = max(total.Packages-summary.Packages.Done(), 0)
+		summary.Versions.Pending = max(total.Versions-summary.Versions.Done(), 0)
+		summary.Files.Pending = max(total.Files-summary.Files.Done(), 0)
+	}
+	return summaries
+}
+
+// Latest keeps only the most recent row of every file, in the order the
+// files were first seen
+func Latest(rows []Row) []Row {
+	index := make(map[string]int)
+	var latest []Row
+	for _, row := range rows {
+		key := row.Key()
+		if i, ok := index[key]; ok {
+			latest[i] = row
+			continue
+		}
+		index[key] = len(latest)
+		latest = append(latest, row)
+	}
+	return latest
+}
+
+func tally(counts *Counts, state string) {
+	switch state {
+	case "Success":
+		counts.Success++
+	case "Skipped", "SourceMissing", "TooLarge":
+		// Files missing from the source or over the size limit can't be
+		// copied, like skipped files they need no further work
+		counts.Skipped++
+	case "Failed":
+		counts.Failed++
+	}
+}
+
+// worst keeps the most severe of two states
+func worst(current, state string) string {
+	rank := map[string]int{"": 0, "Success": 1, "Skipped": 2, "SourceMissing": 2, "TooLarge": 2, "Failed": 3}
+	if rank[state] > rank[current] {
+		return state
+	}
+	return current
+}
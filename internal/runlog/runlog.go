@@ -0,0 +1,238 @@
+package runlog
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Root holds one directory per run
+const Root = "migration-packages/logs"
+
+const (
+	// MainLog is the name of the tool's own log file within a run directory
+	MainLog = "ghmpkg.log"
+	// IndexFile lists every sub-process log of a run
+	IndexFile = "index.csv"
+
+	defaultMaxSizeMB   = 100
+	defaultMaxBackups  = 5
+	defaultRetainRuns  = 20
+	packagesLogsSubdir = "packages"
+	maxOutputTail      = 4096
+)
+
+var indexHeader = []string{"timestamp", "package_type", "package_name", "package_version", "tool", "exit_code", "log_file"}
+
+var (
+	indexMu   sync.Mutex
+	nameClean = strings.NewReplacer("/", "_", "\\", "_", ":", "_", "..", "_")
+)
+
+// Dir returns the log directory of the current run
+func Dir() string {
+	return filepath.Join(Root, utils.RunID())
+}
+
+// Init creates the log directory of the current run and removes the oldest
+// run directories beyond GHMPKG_LOG_RETAIN_RUNS. It returns the rotating
+// writer for the tool's own log.
+func Init() (io.WriteCloser, error) {
+	if err := os.MkdirAll(Dir(), 0755); err != nil {
+		return nil, err
+	}
+	prune(retain())
+
+	maxSize := viper.GetInt("GHMPKG_LOG_MAX_SIZE")
+	if maxSize <= 0 {
+		maxSize = defaultMaxSizeMB
+	}
+	return &lumberjack.Logger{
+		Filename:   filepath.Join(Dir(), MainLog),
+		MaxSize:    maxSize,
+		MaxBackups: defaultMaxBackups,
+		Compress:   true,
+	}, nil
+}
+
+func retain() int {
+	if viper.IsSet("GHMPKG_LOG_RETAIN_RUNS") {
+		return viper.GetInt("GHMPKG_LOG_RETAIN_RUNS")
+	}
+	return defaultRetainRuns
+}
+
+// prune keeps the newest runs, run IDs start with a timestamp so they sort
+// chronologically. Zero or less keeps everything.
+func prune(keep int) {
+	if keep <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(Root)
+	if err != nil {
+		return
+	}
+	var runs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			runs = append(runs, entry.Name())
+		}
+	}
+	sort.Strings(runs)
+	for len(runs) > keep {
+		os.RemoveAll(filepath.Join(Root, runs[0]))
+		runs = runs[1:]
+	}
+}
+
+// Run executes an external tool with its output captured in the run's log
+// directory, next to the other logs of the same package version, and adds
+// the log to the run's index
+func Run(logger *zap.Logger, cmd *exec.Cmd, packageType, packageName, version, tool string) error {
+	relPath := filepath.Join(packagesLogsSubdir, nameClean.Replace(packageType), nameClean.Replace(packageName), nameClean.Replace(version), tool+".log")
+	logPath := filepath.Join(Dir(), relPath)
+	if err := utils.EnsureDirExists(logPath); err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create log file: %w", err)
+	}
+	defer logFile.Close()
+
+	fmt.Fprintf(logFile, "==> %s %s (in %s)\n", time.Now().Format(time.RFC3339), redact(cmd.Args), cmd.Dir)
+	var start int64
+	if info, err := logFile.Stat(); err == nil {
+		start = info.Size()
+	}
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	runErr := cmd.Run()
+
+	exitCode := 0
+	if runErr != nil {
+		exitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(runErr, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		}
+	}
+	if err := appendIndex(packageType, packageName, version, tool, exitCode, relPath); err != nil {
+		logger.Warn("Failed to update log index", zap.Error(err))
+	}
+
+	if runErr != nil {
+		logger.Error("External tool failed",
+			zap.String("tool", tool),
+			zap.Int("exitCode", exitCode),
+			zap.String("log", logPath),
+			zap.Error(runErr))
+		return &ToolError{Tool: tool, Log: logPath, ExitCode: exitCode, output: tail(logPath, start), err: runErr}
+	}
+	logger.Info("External tool finished", zap.String("tool", tool), zap.String("log", logPath))
+	return nil
+}
+
+// ToolError is returned when an external tool fails, it keeps the end of
+// the tool's output so the failure can be classified
+type ToolError struct {
+	Tool     string
+	Log      string
+	ExitCode int
+	output   string
+	err      error
+}
+
+func (e *ToolError) Error() string {
+	return fmt.Sprintf("%s failed, see %s: %v", e.Tool, e.Log, e.err)
+}
+
+func (e *ToolError) Unwrap() error {
+	return e.err
+}
+
+// Output returns the end of what the tool printed
+func (e *ToolError) Output() string {
+	return e.output
+}
+
+// tail reads what was written to a log after offset, only the last few
+// kilobytes are kept
+func tail(path string, offset int64) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err == nil && info.Size()-offset > maxOutputTail {
+		offset = info.Size() - maxOutputTail
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return ""
+	}
+	content, _ := io.ReadAll(file)
+	return string(content)
+}
+
+func appendIndex(packageType, packageName, version, tool string, exitCode int, logFile string) error {
+	return AppendCSV(IndexFile, indexHeader, []string{
+		time.Now().UTC().Format(time.RFC3339),
+		packageType,
+		packageName,
+		version,
+		tool,
+		strconv.Itoa(exitCode),
+		logFile,
+	})
+}
+
+// AppendCSV appends a row to a CSV report in the run's log directory, the
+// header is written when the report is created
+func AppendCSV(name string, header, row []string) error {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+
+	path := filepath.Join(Dir(), name)
+	if err := utils.EnsureDirExists(path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		writer.Write(header)
+	}
+	writer.Write(row)
+	writer.Flush()
+	return writer.Error()
+}
+
+// redact hides values passed after flags that carry credentials
+func redact(args []string) string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		out[i] = arg
+		if i > 0 && (args[i-1] == "-k" || args[i-1] == "--key" || args[i-1] == "--api-key") {
+			out[i] = "***"
+		}
+	}
+	return strings.Join(out, " ")
+}
@@ -0,0 +1,71 @@
+package runlog
+
+import (
+	"encoding/csv"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestRunCapturesOutputAndIndexes(t *testing.T) {
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	cmd := exec.Command("sh", "-c", "echo publishing; exit 3")
+	err := Run(zap.NewNop(), cmd, "npm", "left-pad", "1.0.0", "npm-publish")
+	if err == nil || !strings.Contains(err.Error(), "npm-publish.log") {
+		t.Fatalf("expected error pointing at the log, got %v", err)
+	}
+	var toolErr *ToolError
+	if !errors.As(err, &toolErr) || toolErr.ExitCode != 3 || !strings.Contains(toolErr.Output(), "publishing") {
+		t.Errorf("expected the tool output in the error, got %#v", err)
+	}
+
+	logPath := filepath.Join(Dir(), "packages", "npm", "left-pad", "1.0.0", "npm-publish.log")
+	content, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "publishing") {
+		t.Errorf("output not captured: %q", content)
+	}
+
+	index, err := os.Open(filepath.Join(Dir(), IndexFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	rows, err := csv.NewReader(index).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[1][4] != "npm-publish" || rows[1][5] != "3" {
+		t.Errorf("unexpected index: %v", rows)
+	}
+}
+
+func TestPruneKeepsNewestRuns(t *testing.T) {
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	for _, run := range []string{"20250101T000000Z-a", "20250102T000000Z-b", "20250103T000000Z-c"} {
+		os.MkdirAll(filepath.Join(Root, run), 0755)
+	}
+	prune(2)
+
+	entries, _ := os.ReadDir(Root)
+	if len(entries) != 2 || entries[0].Name() != "20250102T000000Z-b" {
+		t.Errorf("unexpected runs after prune: %v", entries)
+	}
+}
@@ -0,0 +1,153 @@
+// Package signatures verifies the signatures of source artifacts before they
+// are published, so tampered artifacts are not copied into the target
+package signatures
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/runlog"
+	"github.com/mark-humane/gh-migrate-packages/internal/storage"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Policies applied to artifacts that do not verify
+const (
+	Off        = "off"
+	Warn       = "warn"
+	Fail       = "fail"
+	Quarantine = "quarantine"
+)
+
+// QuarantineDir holds the files of versions that failed verification
+const QuarantineDir = "migration-packages/quarantine"
+
+// ErrUnverified is returned for versions whose signatures do not verify
+var ErrUnverified = errors.New("signature verification failed")
+
+// Policy returns the configured policy, off unless set
+func Policy() (string, error) {
+	switch policy := strings.ToLower(viper.GetString("GHMPKG_SIGNATURE_POLICY")); policy {
+	case "", Off:
+		return Off, nil
+	case Warn, Fail, Quarantine:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unsupported signature policy %q, use off, warn, fail or quarantine", policy)
+	}
+}
+
+// Supported reports whether signatures of a package type are verified
+func Supported(packageType string) bool {
+	return packageType == "maven" || packageType == "container"
+}
+
+// unsignedSuffixes are maven files that are not signed themselves
+var unsignedSuffixes = []string{".asc", ".md5", ".sha1", ".sha256", ".sha512"}
+
+// Pairs returns the maven artifacts of a version with their detached
+// signature, artifacts without one map to an empty string
+func Pairs(filenames []string) map[string]string {
+	present := make(map[string]bool, len(filenames))
+	for _, filename := range filenames {
+		present[filename] = true
+	}
+	pairs := map[string]string{}
+	for _, filename := range filenames {
+		if hasSuffix(filename, unsignedSuffixes) || strings.HasPrefix(filename, "maven-metadata.xml") {
+			continue
+		}
+		pairs[filename] = ""
+		if present[filename+".asc"] {
+			pairs[filename] = filename + ".asc"
+		}
+	}
+	return pairs
+}
+
+func hasSuffix(filename string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(filename, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyMaven checks the GPG signature of every artifact in dir, missing
+// signatures fail verification
+func VerifyMaven(logger *zap.Logger, dir, packageName, version string, filenames []string) error {
+	var failed []string
+	for artifact, signature := range Pairs(filenames) {
+		if signature == "" {
+			failed = append(failed, artifact+" (unsigned)")
+			continue
+		}
+		args := []string{"--batch", "--verify"}
+		if keyring := viper.GetString("GHMPKG_GPG_KEYRING"); keyring != "" {
+			args = append([]string{"--no-default-keyring", "--keyring", keyring}, args...)
+		}
+		cmd := exec.Command("gpg", append(args, signature, artifact)...)
+		cmd.Dir = dir
+		if err := runlog.Run(logger, cmd, "maven", packageName, version, "gpg-verify"); err != nil {
+			logger.Warn("Signature did not verify", zap.String("file", artifact), zap.Error(err))
+			failed = append(failed, artifact)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %s", ErrUnverified, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// VerifyImage checks the cosign signature of an image in the source
+// registry, with a public key or a keyless certificate identity
+func VerifyImage(logger *zap.Logger, image, packageName, version string) error {
+	args := []string{"verify"}
+	if key := viper.GetString("GHMPKG_COSIGN_KEY"); key != "" {
+		args = append(args, "--key", key)
+	} else if identity := viper.GetString("GHMPKG_COSIGN_IDENTITY"); identity != "" {
+		args = append(args,
+			"--certificate-identity-regexp", identity,
+			"--certificate-oidc-issuer", viper.GetString("GHMPKG_COSIGN_ISSUER"))
+	} else {
+		return fmt.Errorf("verifying container signatures requires GHMPKG_COSIGN_KEY or GHMPKG_COSIGN_IDENTITY")
+	}
+	cmd := exec.Command("cosign", append(args, image)...)
+	// cosign reads GitHub registry credentials from GITHUB_TOKEN
+	cmd.Env = append(os.Environ(), "GITHUB_TOKEN="+viper.GetString("GHMPKG_SOURCE_TOKEN"))
+	if err := runlog.Run(logger, cmd, "container", packageName, version, "cosign-verify"); err != nil {
+		logger.Warn("Signature did not verify", zap.String("image", image), zap.Error(err))
+		return fmt.Errorf("%w: %s", ErrUnverified, image)
+	}
+	return nil
+}
+
+// Isolate moves the local files of a version out of the packages directory
+// so they can be inspected but are never published
+func Isolate(logger *zap.Logger, dirs []string) error {
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(filepath.Clean(storage.PackagesRoot), filepath.Clean(dir))
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("path %s is outside of %s", dir, storage.PackagesRoot)
+		}
+		target := filepath.Join(QuarantineDir, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create quarantine directory: %w", err)
+		}
+		// A version quarantined by an earlier run is replaced
+		if err := os.RemoveAll(target); err != nil {
+			return fmt.Errorf("failed to replace quarantined files: %w", err)
+		}
+		if err := os.Rename(dir, target); err != nil {
+			return fmt.Errorf("failed to quarantine %s: %w", dir, err)
+		}
+		logger.Warn("Quarantined package files", zap.String("dir", target))
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package signatures
+
+import "testing"
+
+func TestPairs(t *testing.T) {
+	pairs := Pairs([]string{
+		"lib-1.0.jar", "lib-1.0.jar.asc", "lib-1.0.jar.sha1",
+		"lib-1.0.pom", "lib-1.0.pom.md5",
+		"maven-metadata.xml", "maven-metadata.xml.sha1",
+	})
+	if len(pairs) != 2 {
+		t.Fatalf("unexpected pairs: %v", pairs)
+	}
+	if pairs["lib-1.0.jar"] != "lib-1.0.jar.asc" {
+		t.Errorf("jar signature = %q", pairs["lib-1.0.jar"])
+	}
+	if signature, ok := pairs["lib-1.0.pom"]; !ok || signature != "" {
+		t.Errorf("pom should be unsigned, got %q", signature)
+	}
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	gcsDefaultEndpoint = "https://storage.googleapis.com"
+	gcsScope           = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+// GCSStorage stages files in a Google Cloud Storage bucket using the JSON API.
+// Credentials are resolved through Application Default Credentials, which
+// covers service account keys, workload identity and the GCE metadata server.
+type GCSStorage struct {
+	client   *http.Client
+	endpoint string
+	bucket   string
+	prefix   string
+}
+
+type gcsListResponse struct {
+	Items []struct {
+		Name string `json:"name"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// NewGCSStorage creates a GCSStorage for the given bucket. An optional
+// endpoint overrides the public API, e.g. for a storage emulator.
+func NewGCSStorage(ctx context.Context, logger *zap.Logger, bucket, prefix, endpoint string) (*GCSStorage, error) {
+	client, err := google.DefaultClient(ctx, gcsScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load google application default credentials: %w", err)
+	}
+	if endpoint == "" {
+		endpoint = gcsDefaultEndpoint
+	}
+	logger.Info("Configured GCS storage",
+		zap.String("bucket", bucket),
+		zap.String("prefix", prefix),
+		zap.String("endpoint", endpoint))
+	return &GCSStorage{
+		client:   client,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		bucket:   bucket,
+		prefix:   strings.Trim(prefix, "/"),
+	}, nil
+}
+
+func (s *GCSStorage) Name() string {
+	return fmt.Sprintf("gcs://%s", s.bucket)
+}
+
+func (s *GCSStorage) IsLocal() bool {
+	return false
+}
+
+func (s *GCSStorage) object(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return path.Join(s.prefix, key)
+}
+
+func (s *GCSStorage) objectUrl(key string) string {
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s", s.endpoint, url.PathEscape(s.bucket), url.PathEscape(s.object(key)))
+}
+
+func (s *GCSStorage) do(req *http.Request) (*http.Response, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("gcs request %s %s failed, status: %d, message: %s", req.Method, req.URL.Path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func (s *GCSStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	uploadUrl := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s", s.endpoint, url.PathEscape(s.bucket), url.QueryEscape(s.object(key)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadUrl, utils.MeterReader(r, utils.Upload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("bucket not found: %s", s.bucket)
+	}
+	return nil
+}
+
+func (s *GCSStorage) Get(ctx context.Context, key string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectUrl(key)+"?alt=media", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("object not found: %s", s.object(key))
+	}
+	_, err = io.Copy(w, utils.MeterReader(resp.Body, utils.Download))
+	return err
+}
+
+func (s *GCSStorage) Exists(ctx context.Context, key string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectUrl(key), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode != http.StatusNotFound, nil
+}
+
+func (s *GCSStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("prefix", s.object(prefix))
+		if strings.HasSuffix(prefix, "/") && !strings.HasSuffix(query.Get("prefix"), "/") {
+			query.Set("prefix", query.Get("prefix")+"/")
+		}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		listUrl := fmt.Sprintf("%s/storage/v1/b/%s/o?%s", s.endpoint, url.PathEscape(s.bucket), query.Encode())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, listUrl, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page gcsListResponse
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode gcs list response: %w", err)
+		}
+		for _, item := range page.Items {
+			key := item.Name
+			if s.prefix != "" {
+				key = strings.TrimPrefix(key, s.prefix+"/")
+			}
+			keys = append(keys, key)
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+	return keys, nil
+}
+
+func (s *GCSStorage) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectUrl(key), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// PackagesRoot is the local directory pulled packages are written to and
+// read from. Keys used by the storage backends are relative to this path.
+const PackagesRoot = "migration-packages/packages"
+
+// Storage is a staging backend for pulled package files.
+type Storage interface {
+	Name() string
+	IsLocal() bool
+	Put(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string, w io.Writer) error
+	Exists(ctx context.Context, key string) (bool, error)
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var (
+	defaultOnce    sync.Once
+	defaultStorage Storage
+	defaultErr     error
+)
+
+// NewStorage creates the storage backend selected by GHMPKG_STORAGE_BACKEND
+func NewStorage(logger *zap.Logger) (Storage, error) {
+	backend := strings.ToLower(viper.GetString("GHMPKG_STORAGE_BACKEND"))
+	switch backend {
+	case "", "local":
+		return NewLocalStorage(PackagesRoot), nil
+	case "gcs":
+		bucket := viper.GetString("GHMPKG_STORAGE_BUCKET")
+		if bucket == "" {
+			return nil, fmt.Errorf("storage bucket is required for the gcs backend")
+		}
+		return NewGCSStorage(context.Background(), logger, bucket, viper.GetString("GHMPKG_STORAGE_PREFIX"), viper.GetString("GHMPKG_STORAGE_ENDPOINT"))
+	default:
+		return nil, fmt.Errorf("unsupported storage backend: %s", backend)
+	}
+}
+
+// Default returns the process wide storage backend, creating it on first use
+func Default(logger *zap.Logger) (Storage, error) {
+	defaultOnce.Do(func() {
+		defaultStorage, defaultErr = NewStorage(logger)
+		if defaultErr == nil {
+			logger.Info("Using storage backend", zap.String("backend", defaultStorage.Name()))
+		}
+	})
+	return defaultStorage, defaultErr
+}
+
+// KeyFor converts a local path under PackagesRoot into a storage key
+func KeyFor(localPath string) (string, error) {
+	rel, err := filepath.Rel(filepath.Clean(PackagesRoot), filepath.Clean(localPath))
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("path %s is outside of %s", localPath, PackagesRoot)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// Stage moves a freshly downloaded local file onto a remote backend.
+// It is a no-op for the local backend.
+func Stage(ctx context.Context, logger *zap.Logger, localPath string) error {
+	store, err := Default(logger)
+	if err != nil {
+		return err
+	}
+	if store.IsLocal() {
+		return nil
+	}
+
+	key, err := KeyFor(localPath)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", localPath, err)
+	}
+	defer file.Close()
+
+	if err := store.Put(ctx, key, file); err != nil {
+		return fmt.Errorf("failed to stage %s on %s: %w", key, store.Name(), err)
+	}
+	logger.Info("Staged file", zap.String("backend", store.Name()), zap.String("key", key))
+
+	file.Close()
+	return os.Remove(localPath)
+}
+
+// Exists reports whether a local path has already been staged, either on
+// local disk or on the remote backend.
+func Exists(ctx context.Context, logger *zap.Logger, localPath string) (bool, error) {
+	if _, err := os.Stat(localPath); err == nil {
+		return true, nil
+	}
+	store, err := Default(logger)
+	if err != nil {
+		return false, err
+	}
+	if store.IsLocal() {
+		return false, nil
+	}
+	key, err := KeyFor(localPath)
+	if err != nil {
+		return false, err
+	}
+	return store.Exists(ctx, key)
+}
+
+// Restore fetches every staged file below localDir back onto local disk so
+// that the upload tooling can work with it. Files already present are kept.
+func Restore(ctx context.Context, logger *zap.Logger, localDir string) error {
+	store, err := Default(logger)
+	if err != nil {
+		return err
+	}
+	if store.IsLocal() {
+		return nil
+	}
+
+	prefix, err := KeyFor(localDir)
+	if err != nil {
+		return err
+	}
+	keys, err := store.List(ctx, prefix+"/")
+	if err != nil {
+		return fmt.Errorf("failed to list %s on %s: %w", prefix, store.Name(), err)
+	}
+
+	for _, key := range keys {
+		localPath := filepath.Join(PackagesRoot, filepath.FromSlash(key))
+		if _, err := os.Stat(localPath); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			return err
+		}
+		out, err := os.Create(localPath)
+		if err != nil {
+			return err
+		}
+		err = store.Get(ctx, key, out)
+		out.Close()
+		if err != nil {
+			os.Remove(localPath)
+			return fmt.Errorf("failed to restore %s from %s: %w", key, store.Name(), err)
+		}
+		logger.Info("Restored file", zap.String("backend", store.Name()), zap.String("key", key))
+	}
+	return nil
+}
+
+// discard deletes the staged objects below localDir from a remote backend.
+// Directories outside of PackagesRoot, e.g. scratch files, are not staged.
+func discard(ctx context.Context, logger *zap.Logger, localDir string) error {
+	store, err := Default(logger)
+	if err != nil {
+		return err
+	}
+	if store.IsLocal() {
+		return nil
+	}
+	prefix, err := KeyFor(localDir)
+	if err != nil {
+		return nil
+	}
+	keys, err := store.List(ctx, prefix+"/")
+	if err != nil {
+		return fmt.Errorf("failed to list %s on %s: %w", prefix, store.Name(), err)
+	}
+	for _, key := range keys {
+		if err := store.Delete(ctx, key); err != nil {
+			return fmt.Errorf("failed to delete %s from %s: %w", key, store.Name(), err)
+		}
+		logger.Info("Deleted staged file", zap.String("backend", store.Name()), zap.String("key", key))
+	}
+	return nil
+}
+
+// Release removes a restored local directory once its content has been
+// uploaded. It is a no-op for the local backend.
+func Release(logger *zap.Logger, localDir string) error {
+	store, err := Default(logger)
+	if err != nil {
+		return err
+	}
+	if store.IsLocal() {
+		return nil
+	}
+	return os.RemoveAll(localDir)
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// fakeGCS serves the parts of the GCS JSON API the backend uses from memory
+type fakeGCS struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string][]byte
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	objects := "/storage/v1/b/" + f.bucket + "/o"
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/upload"+objects:
+		content, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Query().Get("name")] = content
+	case r.Method == http.MethodGet && r.URL.Path == objects:
+		var names []string
+		for name := range f.objects {
+			if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		// One object per page covers the paging of List
+		var page gcsListResponse
+		start := 0
+		if token := r.URL.Query().Get("pageToken"); token != "" {
+			start = slices.Index(names, token)
+		}
+		if start < len(names) {
+			page.Items = append(page.Items, struct {
+				Name string `json:"name"`
+			}{names[start]})
+		}
+		if start+1 < len(names) {
+			page.NextPageToken = names[start+1]
+		}
+		json.NewEncoder(w).Encode(page)
+	case strings.HasPrefix(r.URL.Path, objects+"/"):
+		name := strings.TrimPrefix(r.URL.Path, objects+"/")
+		content, ok := f.objects[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		switch {
+		case r.Method == http.MethodDelete:
+			delete(f.objects, name)
+		case r.URL.Query().Get("alt") == "media":
+			w.Write(content)
+		default:
+			json.NewEncoder(w).Encode(map[string]string{"name": name})
+		}
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func newTestGCS(t *testing.T) (*GCSStorage, *fakeGCS) {
+	fake := &fakeGCS{bucket: "migration", objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return &GCSStorage{client: server.Client(), endpoint: server.URL, bucket: "migration", prefix: "wave-1"}, fake
+}
+
+func TestGCSStorage(t *testing.T) {
+	store, fake := newTestGCS(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"acme/npm/ui/1.0.0/ui-1.0.0.tgz", "acme/npm/ui/1.1.0/ui-1.1.0.tgz", "acme/npm/core/1.0.0/core-1.0.0.tgz"} {
+		if err := store.Put(ctx, key, strings.NewReader("content of "+key)); err != nil {
+			t.Fatalf("Put(%s) failed: %v", key, err)
+		}
+	}
+	if _, ok := fake.objects["wave-1/acme/npm/ui/1.0.0/ui-1.0.0.tgz"]; !ok {
+		t.Errorf("objects = %v, expected the keys below the prefix", fake.objects)
+	}
+
+	var content bytes.Buffer
+	if err := store.Get(ctx, "acme/npm/ui/1.0.0/ui-1.0.0.tgz", &content); err != nil || content.String() != "content of acme/npm/ui/1.0.0/ui-1.0.0.tgz" {
+		t.Errorf("Get() = %q, %v", content.String(), err)
+	}
+	if err := store.Get(ctx, "acme/npm/ui/2.0.0/ui-2.0.0.tgz", io.Discard); err == nil {
+		t.Error("Get() of a missing object succeeded")
+	}
+
+	if exists, err := store.Exists(ctx, "acme/npm/ui/1.1.0/ui-1.1.0.tgz"); err != nil || !exists {
+		t.Errorf("Exists() of a staged object = %v, %v", exists, err)
+	}
+	if exists, err := store.Exists(ctx, "acme/npm/ui/2.0.0/ui-2.0.0.tgz"); err != nil || exists {
+		t.Errorf("Exists() of a missing object = %v, %v", exists, err)
+	}
+
+	keys, err := store.List(ctx, "acme/npm/ui/")
+	want := []string{"acme/npm/ui/1.0.0/ui-1.0.0.tgz", "acme/npm/ui/1.1.0/ui-1.1.0.tgz"}
+	if err != nil || !slices.Equal(keys, want) {
+		t.Errorf("List() = %v, %v, want %v", keys, err, want)
+	}
+
+	if err := store.Delete(ctx, "acme/npm/ui/1.0.0/ui-1.0.0.tgz"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.objects["wave-1/acme/npm/ui/1.0.0/ui-1.0.0.tgz"]; ok {
+		t.Error("Delete() kept the object")
+	}
+}
+
+func TestStageRestore(t *testing.T) {
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	store, fake := newTestGCS(t)
+	defaultOnce.Do(func() {})
+	defaultStorage, defaultErr = store, nil
+	defer func() { defaultStorage = nil }()
+	logger := zap.NewNop()
+	ctx := context.Background()
+
+	versionDir := filepath.Join(PackagesRoot, "acme", "npm", "ui", "1.0.0")
+	localPath := filepath.Join(versionDir, "ui-1.0.0.tgz")
+	if err := os.MkdirAll(versionDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(localPath, []byte("tarball"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Stage(ctx, logger, localPath); err != nil {
+		t.Fatalf("Stage() failed: %v", err)
+	}
+	if _, err := os.Stat(localPath); !os.IsNotExist(err) {
+		t.Error("Stage() kept the local copy")
+	}
+	if string(fake.objects["wave-1/acme/npm/ui/1.0.0/ui-1.0.0.tgz"]) != "tarball" {
+		t.Errorf("objects = %v, expected the staged file", fake.objects)
+	}
+	if exists, err := Exists(ctx, logger, localPath); err != nil || !exists {
+		t.Errorf("Exists() of a staged file = %v, %v", exists, err)
+	}
+
+	if err := Restore(ctx, logger, versionDir); err != nil {
+		t.Fatalf("Restore() failed: %v", err)
+	}
+	if content, err := os.ReadFile(localPath); err != nil || string(content) != "tarball" {
+		t.Errorf("restored file = %q, %v", content, err)
+	}
+
+	Clean(logger, versionDir)
+	if _, err := os.Stat(versionDir); !os.IsNotExist(err) {
+		t.Error("Clean() kept the restored files")
+	}
+	if len(fake.objects) != 0 {
+		t.Errorf("objects = %v, expected Clean() to delete the staged files", fake.objects)
+	}
+}
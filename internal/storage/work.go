@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// DefaultWorkRoot holds the scratch files written while packages are
+// rewritten for the target, e.g. extracted tarballs and .npmrc files
+const DefaultWorkRoot = "migration-packages/work"
+
+// WorkRoot returns the configured scratch directory
+func WorkRoot() string {
+	if root := viper.GetString("GHMPKG_WORK_DIR"); root != "" {
+		return root
+	}
+	return DefaultWorkRoot
+}
+
+// WorkDir returns the scratch directory of a staged version directory,
+// mirroring its path below PackagesRoot
+func WorkDir(packageDir string) string {
+	rel, err := filepath.Rel(filepath.Clean(PackagesRoot), filepath.Clean(packageDir))
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(packageDir)
+	}
+	return filepath.Join(WorkRoot(), rel)
+}
+
+// KeepArtifacts reports whether scratch and staged files are kept after a
+// successful upload
+func KeepArtifacts() bool {
+	return viper.GetBool("GHMPKG_KEEP_ARTIFACTS")
+}
+
+// Clean removes local directories once their content has been published,
+// unless artifacts are kept. Their objects in a remote backend are deleted
+// as well.
+func Clean(logger *zap.Logger, dirs ...string) {
+	if KeepArtifacts() {
+		return
+	}
+	for _, dir := range dirs {
+		if err := discard(context.Background(), logger, dir); err != nil {
+			logger.Warn("Failed to remove staged files", zap.String("dir", dir), zap.Error(err))
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Warn("Failed to remove local files", zap.String("dir", dir), zap.Error(err))
+			continue
+		}
+		// Parents left empty, e.g. the package once its last version is
+		// published, are removed up to the root they belong to
+		for parent := filepath.Dir(dir); below(parent); parent = filepath.Dir(parent) {
+			if os.Remove(parent) != nil {
+				break
+			}
+		}
+	}
+}
+
+// below reports whether dir is inside the packages or the work directory
+func below(dir string) bool {
+	dir = filepath.Clean(dir)
+	for _, root := range []string{PackagesRoot, WorkRoot()} {
+		if strings.HasPrefix(dir, filepath.Clean(root)+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
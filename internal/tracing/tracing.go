@@ -0,0 +1,128 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	serviceName = "gh-migrate-packages"
+	tracerName  = "github.com/mark-humane/gh-migrate-packages"
+)
+
+// The tool processes one package version at a time, so the active span is
+// kept here instead of threading a context through every call. HTTP
+// requests made without a span in their own context become children of it.
+var (
+	mu      sync.Mutex
+	current = context.Background()
+)
+
+// Enabled reports whether an OTLP endpoint is configured, either through
+// GHMPKG_OTEL_ENDPOINT or the standard OTEL_EXPORTER_OTLP_* variables
+func Enabled() bool {
+	return viper.GetString("GHMPKG_OTEL_ENDPOINT") != "" ||
+		os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" ||
+		os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Init sets up OTLP trace export when it is enabled and instruments the
+// default HTTP transport. The returned function flushes pending spans.
+func Init(logger *zap.Logger) func() {
+	if !Enabled() {
+		return func() {}
+	}
+
+	var options []otlptracehttp.Option
+	if endpoint := viper.GetString("GHMPKG_OTEL_ENDPOINT"); endpoint != "" {
+		options = append(options, otlptracehttp.WithEndpointURL(endpoint))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		logger.Warn("Failed to create OTLP trace exporter, tracing disabled", zap.Error(err))
+		return func() {}
+	}
+
+	res, _ := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	http.DefaultTransport = Transport(http.DefaultTransport)
+	logger.Info("OpenTelemetry tracing enabled")
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			logger.Warn("Failed to flush traces", zap.Error(err))
+		}
+	}
+}
+
+// Start opens a span as a child of the active span and makes it the active
+// one. Call the returned function with the outcome to end it.
+func Start(name string, attrs ...attribute.KeyValue) func(error) {
+	mu.Lock()
+	parent := current
+	ctx, span := otel.Tracer(tracerName).Start(parent, name, trace.WithAttributes(attrs...))
+	current = ctx
+	mu.Unlock()
+
+	return func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		mu.Lock()
+		current = parent
+		mu.Unlock()
+	}
+}
+
+// Context returns a context carrying the active span
+func Context() context.Context {
+	mu.Lock()
+	defer mu.Unlock()
+	return current
+}
+
+// Transport wraps an HTTP transport so every request gets a client span
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return &transport{next: otelhttp.NewTransport(base)}
+}
+
+type transport struct {
+	next http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !trace.SpanFromContext(req.Context()).SpanContext().IsValid() {
+		if span := trace.SpanFromContext(Context()); span.SpanContext().IsValid() {
+			req = req.WithContext(trace.ContextWithSpan(req.Context(), span))
+		}
+	}
+	return t.next.RoundTrip(req)
+}
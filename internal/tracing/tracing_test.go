@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSpansNestUnderActiveSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	client := &http.Client{Transport: Transport(http.DefaultTransport)}
+
+	endPhase := Start("sync")
+	endVersion := Start("version")
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	endVersion(errors.New("upload failed"))
+	endPhase(nil)
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+	httpSpan, version, phase := spans[0], spans[1], spans[2]
+	if httpSpan.Parent().SpanID() != version.SpanContext().SpanID() {
+		t.Error("HTTP span is not a child of the version span")
+	}
+	if version.Parent().SpanID() != phase.SpanContext().SpanID() {
+		t.Error("version span is not a child of the phase span")
+	}
+	if version.Status().Code != codes.Error {
+		t.Error("failed version span has no error status")
+	}
+	if trace.SpanFromContext(Context()).SpanContext().IsValid() {
+		t.Error("active span was not restored")
+	}
+}
@@ -0,0 +1,44 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+)
+
+func TestParseByteSize(t *testing.T) {
+	cases := map[string]int64{
+		"512":    512,
+		"10KB":   10 * 1000,
+		"10kib":  10 * 1024,
+		"1.5GiB": 3 << 29,
+		"50MB":   50 * 1000 * 1000,
+		"2G":     2 << 30,
+	}
+	for input, expected := range cases {
+		size, err := utils.ParseByteSize(input)
+		if err != nil {
+			t.Errorf("ParseByteSize(%q) returned an error: %v", input, err)
+			continue
+		}
+		if size != expected {
+			t.Errorf("ParseByteSize(%q) = %d, expected %d", input, size, expected)
+		}
+	}
+
+	for _, input := range []string{"", "abc", "-1MB"} {
+		if _, err := utils.ParseByteSize(input); err == nil {
+			t.Errorf("ParseByteSize(%q) did not return an error", input)
+		}
+	}
+}
+
+func TestParseBandwidth(t *testing.T) {
+	size, err := utils.ParseBandwidth("50MB/s")
+	if err != nil {
+		t.Fatalf("ParseBandwidth returned an error: %v", err)
+	}
+	if size != 50*1000*1000 {
+		t.Errorf("ParseBandwidth(50MB/s) = %d, expected %d", size, 50*1000*1000)
+	}
+}
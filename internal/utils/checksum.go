@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Checksum is the expected digest of a file. Value is hex encoded.
+type Checksum struct {
+	Algorithm string
+	Value     string
+}
+
+func newHash(algorithm string) (hash.Hash, error) {
+	switch strings.ToLower(algorithm) {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm: %s", algorithm)
+	}
+}
+
+// FileChecksum computes the hex digest of a file with the given algorithm
+func FileChecksum(path, algorithm string) (string, error) {
+	h, err := newHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	if _, err := io.Copy(h, in); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Verify checks the file at path against the checksum
+func (c *Checksum) Verify(path string) error {
+	actual, err := FileChecksum(path, c.Algorithm)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(actual, c.Value) {
+		return fmt.Errorf("%s checksum mismatch: expected %s, got %s", c.Algorithm, c.Value, actual)
+	}
+	return nil
+}
+
+// ChecksumFromHeaders extracts a checksum advertised by the registry, e.g.
+// the X-Checksum-* headers of Artifactory and Nexus or an RFC 3230 Digest
+func ChecksumFromHeaders(header http.Header) *Checksum {
+	for _, algorithm := range []string{"Sha256", "Sha1", "Md5"} {
+		if value := header.Get("X-Checksum-" + algorithm); value != "" {
+			return &Checksum{Algorithm: strings.ToLower(algorithm), Value: value}
+		}
+	}
+	for _, digest := range strings.Split(header.Get("Digest"), ",") {
+		algorithm, value, ok := strings.Cut(strings.TrimSpace(digest), "=")
+		if !ok {
+			continue
+		}
+		algorithm = strings.ToLower(strings.ReplaceAll(algorithm, "-", ""))
+		if _, err := newHash(algorithm); err != nil {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		return &Checksum{Algorithm: algorithm, Value: hex.EncodeToString(decoded)}
+	}
+	return nil
+}
+
+// ParseChecksumFile reads the digest out of a maven style .sha1/.md5 file,
+// which contains the hex digest optionally followed by a filename
+func ParseChecksumFile(content, algorithm string) *Checksum {
+	fields := strings.Fields(content)
+	if len(fields) == 0 {
+		return nil
+	}
+	return &Checksum{Algorithm: algorithm, Value: fields[0]}
+}
+
+// FetchChecksumFile downloads a companion checksum file such as
+// foo.jar.sha1. It returns nil when the registry does not provide one.
+func FetchChecksumFile(url, token, algorithm string) *Checksum {
+	req, err := http.NewRequest(http.MethodGet, url+"."+algorithm, nil)
+	if err != nil {
+		return nil
+	}
+	if token != "" {
+		req.Header.Set("Authorization", Authorization(token, "token"))
+	}
+	resp, err := HTTPClient().Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return nil
+	}
+	return ParseChecksumFile(string(content), algorithm)
+}
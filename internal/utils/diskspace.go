@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/credentials"
+	"github.com/spf13/viper"
+)
+
+// ErrLowDiskSpace is returned when a download would leave less free space
+// than the configured reserve. Runs stop instead of filling up the disk.
+var ErrLowDiskSpace = errors.New("low disk space")
+
+const defaultMinFreeSpace = "1GB"
+
+// MinFreeSpace returns the reserve configured with GHMPKG_MIN_FREE_SPACE
+func MinFreeSpace() (int64, error) {
+	value := viper.GetString("GHMPKG_MIN_FREE_SPACE")
+	if value == "" {
+		value = defaultMinFreeSpace
+	}
+	return ParseByteSize(value)
+}
+
+// CheckFreeSpace fails with ErrLowDiskSpace when fewer than required bytes
+// plus the configured reserve are available at path
+func CheckFreeSpace(path string, required int64) error {
+	reserve, err := MinFreeSpace()
+	if err != nil {
+		return err
+	}
+	free, err := FreeDiskSpace(existingParent(path))
+	if err != nil {
+		return fmt.Errorf("failed to determine free disk space: %w", err)
+	}
+	if free-required < reserve {
+		return fmt.Errorf("%w: %s free, %s required plus %s reserve", ErrLowDiskSpace, FormatBytes(free), FormatBytes(required), FormatBytes(reserve))
+	}
+	return nil
+}
+
+// ContentLength issues a HEAD request and returns the advertised size of the
+// resource, or -1 when the server does not report it
+func ContentLength(url, token string) (int64, error) {
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return -1, err
+	}
+	if authorization := credentials.ForURL(url, token); authorization != "" {
+		req.Header.Set("Authorization", Authorization(authorization, "token"))
+	}
+	resp, err := HTTPClient().Do(req)
+	if err != nil {
+		return -1, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return -1, fmt.Errorf("failed to get size of %s, status: %d", url, resp.StatusCode)
+	}
+	return resp.ContentLength, nil
+}
+
+func existingParent(path string) string {
+	for !FileExists(path) {
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+	return path
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// baseTransport is the standard library transport before it is wrapped,
+// its dial and TLS handshake timeouts are kept
+var baseTransport = http.DefaultTransport.(*http.Transport)
+
+// NewTransport returns a transport with the configured connection pool and
+// HTTP/2 settings, a nil proxy uses the proxy environment variables
+func NewTransport(proxy func(*http.Request) (*url.URL, error)) *http.Transport {
+	transport := baseTransport.Clone()
+	if proxy != nil {
+		transport.Proxy = proxy
+	}
+	// The standard library keeps only two idle connections per host, too few
+	// for concurrent transfers to the same registry
+	transport.MaxIdleConnsPerHost = transport.MaxIdleConns
+	if idle := viper.GetInt("GHMPKG_HTTP_MAX_IDLE_CONNS"); idle > 0 {
+		transport.MaxIdleConns = idle
+		transport.MaxIdleConnsPerHost = idle
+	}
+	if viper.GetBool("GHMPKG_HTTP_DISABLE_HTTP2") {
+		// A non-nil empty map turns off the HTTP/2 upgrade
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
+
+// HTTPTimeout returns the configured limit for a whole request including
+// its body, zero lets transfers of large files take as long as they need
+func HTTPTimeout() time.Duration {
+	timeout, err := time.ParseDuration(viper.GetString("GHMPKG_HTTP_TIMEOUT"))
+	if err != nil || timeout < 0 {
+		return 0
+	}
+	return timeout
+}
+
+var (
+	transportsMu sync.Mutex
+	// transports are the connection pools of the run by proxy setting
+	transports = map[string]*http.Transport{}
+	// client is the client of the transport set up at startup
+	client *http.Client
+)
+
+// SharedTransport returns the transport of a proxy setting, built with
+// NewTransport on first use. Clients created per call, like the API clients
+// of each token, reuse the connections of the calls before them.
+func SharedTransport(key string, proxy func(*http.Request) (*url.URL, error)) *http.Transport {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	if transport, ok := transports[key]; ok {
+		return transport
+	}
+	transport := NewTransport(proxy)
+	transports[key] = transport
+	return transport
+}
+
+// SetupHTTPClient builds the client every request shares from the default
+// transport, once it is wrapped at startup, and the configured timeout
+func SetupHTTPClient() {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	client = &http.Client{Transport: http.DefaultTransport, Timeout: HTTPTimeout()}
+}
+
+// HTTPClient returns the shared client, so connections to the registries
+// are kept alive and reused between files. Before it is set up a client of
+// the default transport is returned.
+func HTTPClient() *http.Client {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	if client != nil {
+		return client
+	}
+	return &http.Client{Transport: http.DefaultTransport, Timeout: HTTPTimeout()}
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestNewTransport(t *testing.T) {
+	viper.Set("GHMPKG_HTTP_MAX_IDLE_CONNS", 8)
+	viper.Set("GHMPKG_HTTP_DISABLE_HTTP2", true)
+	viper.Set("GHMPKG_HTTP_TIMEOUT", "90s")
+	defer viper.Reset()
+
+	transport := NewTransport(nil)
+	if transport.MaxIdleConnsPerHost != 8 || transport.ForceAttemptHTTP2 || transport.TLSNextProto == nil {
+		t.Errorf("unexpected transport settings: %d idle, http2 %v", transport.MaxIdleConnsPerHost, transport.ForceAttemptHTTP2)
+	}
+	if transport.TLSHandshakeTimeout != baseTransport.TLSHandshakeTimeout {
+		t.Error("expected the standard handshake timeout to be kept")
+	}
+	if timeout := HTTPClient().Timeout; timeout != 90*time.Second {
+		t.Errorf("timeout = %v", timeout)
+	}
+}
+
+func TestSharedTransport(t *testing.T) {
+	defer viper.Reset()
+	first := SharedTransport("test", nil)
+	if again := SharedTransport("test", nil); again != first {
+		t.Error("expected the transport of a key to be shared")
+	}
+	if other := SharedTransport("test proxy", nil); other == first {
+		t.Error("expected another key to get its own transport")
+	}
+	if first.MaxIdleConnsPerHost != first.MaxIdleConns || first.MaxIdleConnsPerHost <= 2 {
+		t.Errorf("idle connections per host = %d, expected the pool size %d", first.MaxIdleConnsPerHost, first.MaxIdleConns)
+	}
+
+	viper.Set("GHMPKG_HTTP_TIMEOUT", "30s")
+	SetupHTTPClient()
+	defer func() { client = nil }()
+	if HTTPClient() != HTTPClient() || HTTPClient().Timeout != 30*time.Second {
+		t.Error("expected the client set up to be shared")
+	}
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+var (
+	runID     string
+	runIDOnce sync.Once
+)
+
+// RunID identifies this invocation of the tool, it is generated once and
+// shared by everything the run writes
+func RunID() string {
+	runIDOnce.Do(func() {
+		suffix := make([]byte, 3)
+		rand.Read(suffix)
+		runID = time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+	})
+	return runID
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// DefaultChunkSize is the size of the chunks of resumable uploads when
+// GHMPKG_CHUNK_SIZE is unset
+const DefaultChunkSize = 100 << 20
+
+// ChunkSize returns the size of the chunks container layers are uploaded
+// in, set with GHMPKG_CHUNK_SIZE. Zero uploads every layer in one request.
+func ChunkSize() (int64, error) {
+	value := strings.TrimSpace(viper.GetString("GHMPKG_CHUNK_SIZE"))
+	if value == "" {
+		return DefaultChunkSize, nil
+	}
+	size, err := ParseByteSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid chunk size %q: %w", value, err)
+	}
+	return size, nil
+}
+
+// streamBody is a metered upload body, closing it closes the files and
+// pipes it reads from
+type streamBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// StreamBody returns an upload body reading r that closes closers when the
+// request is done with it
+func StreamBody(r io.Reader, closers ...io.Closer) io.ReadCloser {
+	return &streamBody{Reader: MeterReader(r, Upload), closers: closers}
+}
+
+func (b *streamBody) Close() error {
+	var err error
+	for _, closer := range b.closers {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// SetStreamBody makes a request send size bytes read from open as they are
+// sent instead of from memory, open is called again when the transport
+// retries the request
+func SetStreamBody(req *http.Request, size int64, open func() (io.ReadCloser, error)) error {
+	body, err := open()
+	if err != nil {
+		return err
+	}
+	req.Body = body
+	req.GetBody = open
+	req.ContentLength = size
+	return nil
+}
+
+// SetFileBody makes a request send the file at path between prefix and
+// suffix, streamed from disk so uploads take the same memory whatever the
+// size of the file
+func SetFileBody(req *http.Request, path string, prefix, suffix []byte) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to get file stats: %w", err)
+	}
+	size := int64(len(prefix)) + stat.Size() + int64(len(suffix))
+	return SetStreamBody(req, size, func() (io.ReadCloser, error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		reader := io.MultiReader(bytes.NewReader(prefix), file, bytes.NewReader(suffix))
+		return StreamBody(reader, file), nil
+	})
+}
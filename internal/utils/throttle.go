@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+)
+
+// Direction selects the bandwidth budget a transfer is charged against
+type Direction int
+
+const (
+	Download Direction = iota
+	Upload
+)
+
+var (
+	limitersOnce sync.Once
+	limiters     [2]*rate.Limiter
+)
+
+// ParseBandwidth parses a rate such as 50MB/s, 10MiB or 1G/s into bytes per
+// second. The /s suffix is optional.
+func ParseBandwidth(value string) (int64, error) {
+	str := strings.TrimSpace(value)
+	str = strings.TrimSuffix(strings.TrimSuffix(str, "/s"), "ps")
+	bytesPerSecond, err := ParseByteSize(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth: %s", value)
+	}
+	return bytesPerSecond, nil
+}
+
+// bandwidthLimiter returns the token bucket for the given direction, or nil
+// when GHMPKG_MAX_BANDWIDTH is not set
+func bandwidthLimiter(direction Direction) *rate.Limiter {
+	limitersOnce.Do(func() {
+		value := viper.GetString("GHMPKG_MAX_BANDWIDTH")
+		if value == "" {
+			return
+		}
+		bytesPerSecond, err := ParseBandwidth(value)
+		if err != nil || bytesPerSecond <= 0 {
+			return
+		}
+		// Allow bursts of up to a quarter second worth of data
+		burst := int(bytesPerSecond / 4)
+		if burst < 32*1024 {
+			burst = 32 * 1024
+		}
+		for i := range limiters {
+			limiters[i] = rate.NewLimiter(rate.Limit(bytesPerSecond), burst)
+		}
+	})
+	return limiters[direction]
+}
+
+type throttledReader struct {
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if burst := t.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if waitErr := t.limiter.WaitN(context.Background(), n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+type throttledWriter struct {
+	w       io.Writer
+	limiter *rate.Limiter
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if burst := t.limiter.Burst(); len(chunk) > burst {
+			chunk = chunk[:burst]
+		}
+		if err := t.limiter.WaitN(context.Background(), len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := t.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// ThrottleReader limits how fast r can be read to the configured bandwidth
+func ThrottleReader(r io.Reader, direction Direction) io.Reader {
+	limiter := bandwidthLimiter(direction)
+	if limiter == nil {
+		return r
+	}
+	return &throttledReader{r: r, limiter: limiter}
+}
+
+// ThrottleWriter limits how fast w can be written to the configured bandwidth
+func ThrottleWriter(w io.Writer, direction Direction) io.Writer {
+	limiter := bandwidthLimiter(direction)
+	if limiter == nil {
+		return w
+	}
+	return &throttledWriter{w: w, limiter: limiter}
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"sync/atomic"
+)
+
+var transferred [2]atomic.Int64
+
+// BytesTransferred returns the number of bytes moved in the given direction
+// by metered readers since the process started
+func BytesTransferred(direction Direction) int64 {
+	return transferred[direction].Load()
+}
+
+type meteredReader struct {
+	r         io.Reader
+	direction Direction
+}
+
+func (m *meteredReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	transferred[m.direction].Add(int64(n))
+	return n, err
+}
+
+// MeterReader counts the bytes read from r towards the transfer statistics
+// and applies the configured bandwidth limit
+func MeterReader(r io.Reader, direction Direction) io.Reader {
+	return &meteredReader{r: ThrottleReader(r, direction), direction: direction}
+}
+
+// FileTransfer is what was observed on the wire for a single file
+type FileTransfer struct {
+	Bytes    int64
+	Status   int
+	Attempts int
+}
+
+type fileTransfer struct {
+	bytes    atomic.Int64
+	status   atomic.Int64
+	attempts atomic.Int64
+}
+
+var fileTransfers sync.Map
+
+// TrackTransfers wraps an HTTP transport so every request is counted
+// towards the file named by the last segment of its URL path
+func TrackTransfers(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &trackingTransport{next: base}
+}
+
+type trackingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *trackingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	name, err := url.PathUnescape(path.Base(req.URL.EscapedPath()))
+	if err != nil || name == "/" || name == "." {
+		return t.next.RoundTrip(req)
+	}
+	value, _ := fileTransfers.LoadOrStore(name, &fileTransfer{})
+	stats := value.(*fileTransfer)
+	stats.attempts.Add(1)
+	if req.ContentLength > 0 {
+		stats.bytes.Add(req.ContentLength)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	stats.status.Store(int64(resp.StatusCode))
+	resp.Body = &countingBody{ReadCloser: resp.Body, stats: stats}
+	return resp, nil
+}
+
+type countingBody struct {
+	io.ReadCloser
+	stats *fileTransfer
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.stats.bytes.Add(int64(n))
+	return n, err
+}
+
+// TakeTransfer returns what was observed for a file and forgets it
+func TakeTransfer(filename string) FileTransfer {
+	value, ok := fileTransfers.LoadAndDelete(path.Base(filename))
+	if !ok {
+		return FileTransfer{}
+	}
+	stats := value.(*fileTransfer)
+	return FileTransfer{
+		Bytes:    stats.bytes.Load(),
+		Status:   int(stats.status.Load()),
+		Attempts: int(stats.attempts.Load()),
+	}
+}
+
+// ResetTransfers forgets every file, requests that were never taken would
+// otherwise be counted towards the next file with the same name
+func ResetTransfers() {
+	fileTransfers.Clear()
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTrackTransfers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		io.WriteString(w, "0123456789")
+	}))
+	defer server.Close()
+	ResetTransfers()
+	client := &http.Client{Transport: TrackTransfers(nil)}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(server.URL + "/org/repo/a/1.0/a-1.0.jar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	req, _ := http.NewRequest(http.MethodPut, server.URL+"/org/repo/a/1.0/a-1.0.pom", strings.NewReader("<project/>"))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got := TakeTransfer("a-1.0.jar"); got != (FileTransfer{Bytes: 20, Status: 200, Attempts: 2}) {
+		t.Errorf("jar: %+v", got)
+	}
+	if got := TakeTransfer("a-1.0.pom"); got != (FileTransfer{Bytes: 10, Status: 409, Attempts: 1}) {
+		t.Errorf("pom: %+v", got)
+	}
+	if got := TakeTransfer("a-1.0.jar"); got != (FileTransfer{}) {
+		t.Errorf("taken transfer was kept: %+v", got)
+	}
+}
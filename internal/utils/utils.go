@@ -1,7 +1,7 @@
 package utils
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,6 +14,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/mark-humane/gh-migrate-packages/internal/credentials"
 	"github.com/pterm/pterm"
 )
 
@@ -58,14 +59,43 @@ func RenameFileOccurances(filename, oldScope, newScope string, occurances int) e
 	newContent := strings.Replace(string(content), oldScope, newScope, occurances)
 
 	// Write back to file
-	err = os.WriteFile(filename, []byte(newContent), 0644)
-	if err != nil {
+	return ReplaceFile(filename, []byte(newContent))
+}
+
+// ReplaceFile writes content to a temporary file and renames it over path.
+// Unlike writing in place this never modifies other hard links to the same
+// file, such as objects in the download cache, and a crash leaves either the
+// old or the new content.
+func ReplaceFile(path string, content []byte) error {
+	tmpPath := path + ".tmp"
+	if err := writeSynced(tmpPath, content); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
 		return err
 	}
-
 	return nil
 }
 
+// writeSynced writes content and flushes it to disk before returning
+func writeSynced(path string, content []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
 func CacheFile(path, content string, overwrite bool) (string, error) {
 	path = filepath.Join(cachePath, path)
 	// Check if the file exists
@@ -172,14 +202,38 @@ func EnsureDirExists(path string) error {
 	return nil
 }
 
+// Authorization returns the Authorization header value for a token. Tokens
+// that already carry a scheme, e.g. "Basic dXNlcjpwYXNz", are used as is so
+// registries other than GitHub can pass their own credentials.
+func Authorization(token, scheme string) string {
+	if strings.Contains(token, " ") {
+		return token
+	}
+	return fmt.Sprintf("%s %s", scheme, token)
+}
+
+// ErrNotFound is returned when a file to download no longer exists, like
+// the tarball of a deleted version the API still lists
+var ErrNotFound = errors.New("the file does not exist")
+
 func DownloadFile(url, outputPath, token string) error {
+	return DownloadFileWithChecksum(url, outputPath, token, nil)
+}
+
+// DownloadFileWithChecksum downloads url to outputPath. Data is written to a
+// .part file first; if one is left over from an interrupted attempt, the
+// download resumes with a Range request when the server supports it. The
+// finished file is validated against expected, or against a checksum
+// advertised in the response headers when expected is nil.
+func DownloadFileWithChecksum(url, outputPath, token string, expected *Checksum) error {
 	// Create the directory if it doesn't exist
 	if err := EnsureDirExists(outputPath); err != nil {
 		pterm.Error.Println("Failed to create directories:", err)
 		return err
 	}
 
-	client := &http.Client{}
+	client := HTTPClient()
+	partPath := outputPath + ".part"
 
 	for {
 		// Check and update request count
@@ -189,66 +243,168 @@ func DownloadFile(url, outputPath, token string) error {
 			continue
 		}
 
-		// Create a new HTTP request
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return fmt.Errorf("failed to create request: %v", err)
-		}
-
-		if token != "" {
-			// Add the authorization header
-			req.Header.Set("Authorization", fmt.Sprintf("token %s", token))
+		header, err := downloadPart(client, url, partPath, token)
+		if errors.Is(err, errRestart) {
+			continue
 		}
-
-		// Perform the HTTP request
-		resp, err := client.Do(req)
 		if err != nil {
-			return fmt.Errorf("failed to perform request: %v", err)
+			return err
 		}
-		defer resp.Body.Close()
-		time.Sleep(500 * time.Millisecond)
-
-		// Check if the response status is OK
-		if resp.StatusCode == http.StatusOK {
-			// Create the file
-			out, err := os.Create(outputPath)
-			if err != nil {
-				return fmt.Errorf("failed to create file: %v", err)
-			}
-			defer out.Close()
 
-			// Write the response body to the file
-			_, err = io.Copy(out, resp.Body)
-			if err != nil {
-				return fmt.Errorf("failed to write to file: %v", err)
+		if expected == nil {
+			expected = ChecksumFromHeaders(header)
+		}
+		if expected != nil {
+			if err := expected.Verify(partPath); err != nil {
+				// Never resume from corrupted data
+				os.Remove(partPath)
+				return fmt.Errorf("failed to verify %s: %w", url, err)
 			}
-
-			return nil
 		}
 
-		return fmt.Errorf("failed to download file %s, status: %d, message: %s", url, resp.StatusCode, resp.Status)
+		return os.Rename(partPath, outputPath)
 	}
 }
 
-func UploadFile(url, inputPath, token string) (*http.Response, error) {
-	// Open the file
-	file, err := os.Open(inputPath)
+// errRestart is returned by downloadPart when the partial file was removed
+// and the download has to start over
+var errRestart = errors.New("the download has to start over")
+
+// downloadPart makes one attempt at downloading url to partPath, resuming
+// from the data already in partPath, and returns the response headers
+func downloadPart(client *http.Client, url, partPath, token string) (http.Header, error) {
+	// Create a new HTTP request
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %v", err)
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
-	defer file.Close()
-	stat, err := file.Stat()
+
+	// Hosts with their own credentials, like registry proxies, get them
+	// instead of the token
+	if authorization := credentials.ForURL(url, token); authorization != "" {
+		// Add the authorization header
+		req.Header.Set("Authorization", Authorization(authorization, "token"))
+	}
+
+	// Resume a previously interrupted download
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil && info.Size() > 0 {
+		offset = info.Size()
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	// Perform the HTTP request
+	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get file stats: %v", err)
+		return nil, fmt.Errorf("failed to perform request: %v", err)
 	}
+	defer resp.Body.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	var out *os.File
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		if start, ok := rangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			// The range doesn't continue the partial file, start over
+			if err := os.Remove(partPath); err != nil {
+				return nil, fmt.Errorf("failed to remove partial file: %v", err)
+			}
+			return nil, errRestart
+		}
+		out, err = os.OpenFile(partPath, os.O_WRONLY|os.O_APPEND, 0644)
+	case resp.StatusCode == http.StatusOK:
+		// Server ignored the range, start over
+		out, err = os.Create(partPath)
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file is stale or already complete, start over
+		if err := os.Remove(partPath); err != nil {
+			return nil, fmt.Errorf("failed to remove partial file: %v", err)
+		}
+		return nil, errRestart
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, fmt.Errorf("failed to download file %s, status: %d, message: %s: %w", url, resp.StatusCode, resp.Status, ErrNotFound)
+	default:
+		return nil, fmt.Errorf("failed to download file %s, status: %d, message: %s", url, resp.StatusCode, resp.Status)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %v", err)
+	}
+	defer out.Close()
 
-	// Read the entire file into memory
-	content, err := io.ReadAll(file)
+	// Write the response body to the file
+	written, err := io.Copy(out, MeterReader(resp.Body, Download))
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %v", err)
+		return nil, fmt.Errorf("failed to write to file: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write to file: %v", err)
+	}
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return nil, fmt.Errorf("incomplete download of %s: received %d of %d bytes", url, written, resp.ContentLength)
 	}
 
-	client := &http.Client{}
+	return resp.Header, nil
+}
+
+// rangeStart returns the first byte of a Content-Range header like
+// "bytes 100-199/200"
+func rangeStart(contentRange string) (int64, bool) {
+	rest, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	first, _, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	return start, err == nil
+}
+
+// contentTypes are the content types of uploaded files by extension, the
+// longest matching extension wins so dist.tar.gz is a gzip archive
+var contentTypes = map[string]string{
+	".jar":    "application/java-archive",
+	".war":    "application/java-archive",
+	".ear":    "application/java-archive",
+	".aar":    "application/java-archive",
+	".pom":    "application/xml",
+	".xml":    "application/xml",
+	".module": "application/json",
+	".json":   "application/json",
+	".zip":    "application/zip",
+	".tar":    "application/x-tar",
+	".gz":     "application/gzip",
+	".tgz":    "application/gzip",
+	".tar.gz": "application/gzip",
+	".bz2":    "application/x-bzip2",
+	".xz":     "application/x-xz",
+	".asc":    "application/pgp-signature",
+	".md5":    "text/plain",
+	".sha1":   "text/plain",
+	".sha256": "text/plain",
+	".sha512": "text/plain",
+}
+
+// ContentType returns the content type a file is uploaded with, files of
+// unknown extensions are sent as application/octet-stream
+func ContentType(filename string) string {
+	name := strings.ToLower(filepath.Base(filename))
+	for i := strings.Index(name, "."); i >= 0; {
+		if contentType, ok := contentTypes[name[i:]]; ok {
+			return contentType
+		}
+		next := strings.Index(name[i+1:], ".")
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return "application/octet-stream"
+}
+
+func UploadFile(url, inputPath, token string) (*http.Response, error) {
+	client := HTTPClient()
 
 	for {
 		// Check and update request count
@@ -258,22 +414,20 @@ func UploadFile(url, inputPath, token string) (*http.Response, error) {
 			continue
 		}
 
-		// Create a new HTTP request using the content buffer
-		req, err := http.NewRequest("PUT", url, bytes.NewReader(content))
+		// Create a new HTTP request streaming the file, large files are
+		// never held in memory
+		req, err := http.NewRequest("PUT", url, nil)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create request: %v", err)
 		}
+		if err := SetFileBody(req, inputPath, nil, nil); err != nil {
+			return nil, err
+		}
 
 		// Add the authorization header
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-		req.Header.Set("Content-Length", fmt.Sprintf("%d", stat.Size()))
-		if strings.HasSuffix(inputPath, ".jar") {
-			req.Header.Set("Content-Type", "application/java-archive")
-		} else if strings.HasSuffix(inputPath, ".pom") {
-			req.Header.Set("Content-Type", "application/xml")
-		} else {
-			req.Header.Set("Content-Type", "application/octet-stream")
-		}
+		req.Header.Set("Authorization", Authorization(token, "Bearer"))
+		req.Header.Set("Content-Length", fmt.Sprintf("%d", req.ContentLength))
+		req.Header.Set("Content-Type", ContentType(inputPath))
 
 		// Perform the HTTP request
 		resp, err := client.Do(req)
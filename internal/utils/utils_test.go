@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestDownloadFileNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone.tgz" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+
+	if err := DownloadFile(server.URL+"/gone.tgz", filepath.Join(dir, "gone.tgz"), ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := DownloadFile(server.URL+"/broken.tgz", filepath.Join(dir, "broken.tgz"), ""); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a server error, got %v", err)
+	}
+}
+
+func TestDownloadFileResumes(t *testing.T) {
+	content := "0123456789"
+	tests := []struct {
+		name  string
+		start int
+	}{
+		{"range continues the partial file", 4},
+		{"range doesn't continue the partial file", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Range") == "" {
+					w.Write([]byte(content))
+					return
+				}
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", tt.start, len(content)-1, len(content)))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte(content[tt.start:]))
+			}))
+			defer server.Close()
+			path := filepath.Join(t.TempDir(), "core.jar")
+			if err := os.WriteFile(path+".part", []byte(content[:4]), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := DownloadFile(server.URL+"/core.jar", path, ""); err != nil {
+				t.Fatalf("DownloadFile() failed: %v", err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != content {
+				t.Errorf("downloaded %q, want %q", data, content)
+			}
+		})
+	}
+}
+
+func TestContentType(t *testing.T) {
+	for filename, want := range map[string]string{
+		"core-1.0-tests.jar":       "application/java-archive",
+		"core-1.0.pom":             "application/xml",
+		"core-1.0-bin.zip":         "application/zip",
+		"core-1.0-dist.tar.gz":     "application/gzip",
+		"core-1.0-dist.tar.gz.md5": "text/plain",
+		"core-1.0.module":          "application/json",
+		"core-1.0-native.so":       "application/octet-stream",
+	} {
+		if got := ContentType(filename); got != want {
+			t.Errorf("ContentType(%q) = %q, want %q", filename, got, want)
+		}
+	}
+}
+
+func TestUploadFileStreamsLargeFiles(t *testing.T) {
+	const size = 256 << 20
+	path := filepath.Join(t.TempDir(), "core-1.0-dist.zip")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A sparse file with a marker at its end, nothing is written in between
+	file.WriteAt([]byte("end"), size-3)
+	file.Close()
+	want, err := FileChecksum(path, "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received int64
+	var digest string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hash := sha256.New()
+		received, _ = io.Copy(hash, r.Body)
+		digest = hex.EncodeToString(hash.Sum(nil))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	resp, err := UploadFile(server.URL+"/core-1.0-dist.zip", path, "token")
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if received != size || digest != want {
+		t.Errorf("received %d bytes with sha256 %s, want %d bytes with %s", received, digest, size, want)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size/8 {
+		t.Errorf("uploading %s allocated %s, the file was not streamed", FormatBytes(size), FormatBytes(int64(allocated)))
+	}
+}
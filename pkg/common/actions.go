@@ -0,0 +1,84 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/actions"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// jobSummary renders the outcome of a phase as Markdown for the summary of
+// a GitHub Actions job
+func jobSummary(phase PhaseSummary, targetOrganization string) string {
+	icon := map[string]string{ExitSuccess: "✅", ExitPartial: "⚠️"}[phase.ExitStatus]
+	if icon == "" {
+		icon = "❌"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "### %s %s %s\n\n", icon, phase.Phase, phase.ExitStatus)
+	organization := phase.Organization
+	if targetOrganization != "" && phase.Phase != "export" && phase.Phase != "pull" {
+		organization += " → " + targetOrganization
+	}
+	fmt.Fprintf(&b, "%s in %s", organization, (time.Duration(phase.DurationSeconds) * time.Second).Round(time.Second))
+	if phase.BytesDownloaded > 0 {
+		fmt.Fprintf(&b, ", %s downloaded", utils.FormatBytes(phase.BytesDownloaded))
+	}
+	if phase.BytesUploaded > 0 {
+		fmt.Fprintf(&b, ", %s uploaded", utils.FormatBytes(phase.BytesUploaded))
+	}
+	b.WriteString("\n\n")
+	if phase.Error != "" {
+		fmt.Fprintf(&b, "> **Error:** %s\n\n", strings.Join(strings.Fields(phase.Error), " "))
+	}
+
+	b.WriteString("| | Success | Skipped | Failed |\n|---|---:|---:|---:|\n")
+	for _, row := range []struct {
+		name   string
+		counts SummaryCounts
+	}{{"Packages", phase.Packages}, {"Versions", phase.Versions}, {"Files", phase.Files}} {
+		fmt.Fprintf(&b, "| %s | %d | %d | %d |\n", row.name, row.counts.Success, row.counts.Skipped, row.counts.Failed)
+	}
+
+	if len(phase.FailuresByClass) > 0 {
+		classes := make([]string, 0, len(phase.FailuresByClass))
+		for class := range phase.FailuresByClass {
+			classes = append(classes, class)
+		}
+		sort.Slice(classes, func(i, j int) bool {
+			if phase.FailuresByClass[classes[i]] != phase.FailuresByClass[classes[j]] {
+				return phase.FailuresByClass[classes[i]] > phase.FailuresByClass[classes[j]]
+			}
+			return classes[i] < classes[j]
+		})
+		b.WriteString("\n**Failures by class:** ")
+		for i, class := range classes {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "%s %d", class, phase.FailuresByClass[class])
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// reportToActions writes the outcome of a phase to the summary of a GitHub
+// Actions job and annotates a phase that stopped
+func reportToActions(logger *zap.Logger, phase PhaseSummary) {
+	if !actions.Enabled() {
+		return
+	}
+	if phase.ExitStatus == ExitFailed {
+		actions.Error(fmt.Sprintf("%s stopped", phase.Phase), phase.Error)
+	}
+	if err := actions.WriteSummary(jobSummary(phase, viper.GetString("GHMPKG_TARGET_ORGANIZATION"))); err != nil {
+		logger.Warn("Failed to write the job summary", zap.Error(err))
+	}
+}
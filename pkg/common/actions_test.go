@@ -0,0 +1,29 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJobSummary(t *testing.T) {
+	summary := jobSummary(PhaseSummary{
+		Phase:           "sync",
+		Organization:    "mark-humane",
+		DurationSeconds: 4407.2,
+		ExitStatus:      ExitPartial,
+		Versions:        SummaryCounts{Success: 1893, Skipped: 12, Failed: 3},
+		FailuresByClass: map[string]int{"timeout": 1, "conflict": 2},
+		BytesUploaded:   1536,
+	}, "mona-emu")
+
+	for _, expected := range []string{
+		"### ⚠️ sync partial",
+		"mark-humane → mona-emu in 1h13m27s, 1.5 KiB uploaded",
+		"| Versions | 1893 | 12 | 3 |",
+		"**Failures by class:** conflict 2, timeout 1",
+	} {
+		if !strings.Contains(summary, expected) {
+			t.Errorf("job summary does not contain %q:\n%s", expected, summary)
+		}
+	}
+}
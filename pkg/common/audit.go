@@ -0,0 +1,119 @@
+package common
+
+import (
+	"github.com/mark-humane/gh-migrate-packages/internal/api"
+	"github.com/mark-humane/gh-migrate-packages/internal/audit"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/internal/results"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// auditStarted records who started a phase and with which settings, the
+// logins of the GitHub tokens are looked up when they are set
+func auditStarted(logger *zap.Logger, phase string) {
+	actor := audit.CurrentActor()
+	if token := viper.GetString("GHMPKG_SOURCE_TOKEN"); token != "" && registries.SourceName() == registries.GitHub {
+		if login, err := api.AuthenticatedUser(token, viper.GetString("GHMPKG_SOURCE_HOSTNAME")); err == nil {
+			actor.SourceLogin = login
+		} else {
+			logger.Warn("Failed to look up the user of the source token", zap.Error(err))
+		}
+	}
+	if token := viper.GetString("GHMPKG_TARGET_TOKEN"); token != "" && registries.TargetName() == registries.GitHub {
+		if login, err := api.AuthenticatedUser(token, viper.GetString("GHMPKG_TARGET_HOSTNAME")); err == nil {
+			actor.TargetLogin = login
+		} else {
+			logger.Warn("Failed to look up the user of the target token", zap.Error(err))
+		}
+	}
+	if err := audit.Started(phase, actor); err != nil {
+		logger.Warn("Failed to write audit log", zap.String("file", audit.Path()), zap.Error(err))
+	}
+}
+
+// auditArtifact records a file the phase copied along with its digest
+func auditArtifact(phase, target string, row results.Row) {
+	record := audit.Record{
+		Event:        audit.Artifact,
+		Phase:        phase,
+		Organization: row.Organization,
+		Repository:   row.Repository,
+		PackageType:  row.PackageType,
+		PackageName:  row.PackageName,
+		Version:      row.Version,
+		Filename:     row.Filename,
+		SHA256:       row.SHA256,
+		Bytes:        row.Bytes,
+		Target:       target,
+	}
+	if err := audit.Write(record); err != nil {
+		zap.L().Warn("Failed to write audit log", zap.String("file", audit.Path()), zap.Error(err))
+	}
+}
+
+// RecordSourceDeleted counts a version deleted from the source organization
+// and records it in the audit log
+func (r *Report) RecordSourceDeleted(repository, packageType, packageName, version string) {
+	r.mu.Lock()
+	r.SourceVersionsDeleted++
+	phase := r.phase
+	r.mu.Unlock()
+
+	err := audit.Write(audit.Record{
+		Event:        audit.SourceDeleted,
+		Phase:        phase,
+		Organization: viper.GetString("GHMPKG_SOURCE_ORGANIZATION"),
+		Repository:   repository,
+		PackageType:  packageType,
+		PackageName:  packageName,
+		Version:      version,
+	})
+	if err != nil {
+		zap.L().Warn("Failed to write audit log", zap.String("file", audit.Path()), zap.Error(err))
+	}
+}
+
+// RecordTargetReplaced counts a version deleted from the target to be
+// published again and records it in the audit log
+func (r *Report) RecordTargetReplaced(repository, packageType, packageName, version string) {
+	r.mu.Lock()
+	r.TargetVersionsReplaced++
+	phase, target := r.phase, r.target
+	r.mu.Unlock()
+
+	err := audit.Write(audit.Record{
+		Event:        audit.Replaced,
+		Phase:        phase,
+		Organization: viper.GetString("GHMPKG_SOURCE_ORGANIZATION"),
+		Repository:   repository,
+		PackageType:  packageType,
+		PackageName:  packageName,
+		Version:      version,
+		Target:       target,
+	})
+	if err != nil {
+		zap.L().Warn("Failed to write audit log", zap.String("file", audit.Path()), zap.Error(err))
+	}
+}
+
+// auditFinished records the outcome of a phase
+func auditFinished(logger *zap.Logger, summary PhaseSummary) {
+	err := audit.Write(audit.Record{
+		Event:      audit.RunFinished,
+		Phase:      summary.Phase,
+		ExitStatus: summary.ExitStatus,
+		Error:      summary.Error,
+		Counts: map[string]int{
+			"packages_success": summary.Packages.Success,
+			"packages_skipped": summary.Packages.Skipped,
+			"packages_failed":  summary.Packages.Failed,
+			"files_success":    summary.Files.Success,
+			"files_skipped":    summary.Files.Skipped,
+			"files_failed":     summary.Files.Failed,
+		},
+	})
+	if err != nil {
+		logger.Warn("Failed to write audit log", zap.String("file", audit.Path()), zap.Error(err))
+	}
+}
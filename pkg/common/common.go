@@ -1,13 +1,29 @@
 package common
 
 import (
+	"errors"
 	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/mark-humane/gh-migrate-packages/internal/api"
+	"github.com/mark-humane/gh-migrate-packages/internal/failures"
+	"github.com/mark-humane/gh-migrate-packages/internal/metrics"
+	"github.com/mark-humane/gh-migrate-packages/internal/notify"
+	"github.com/mark-humane/gh-migrate-packages/internal/progress"
 	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/results"
+	"github.com/mark-humane/gh-migrate-packages/internal/storage"
+	"github.com/mark-humane/gh-migrate-packages/internal/tracing"
 	"github.com/mark-humane/gh-migrate-packages/internal/utils"
 	"github.com/pterm/pterm"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
@@ -26,7 +42,33 @@ type Report struct {
 	VersionsFailed     int
 	FilesFailed        int
 	PackagesByType     map[string]int
+	FailuresByClass    map[string]int
 	currentPackageType string
+
+	// SourceVersionsDeleted counts versions deleted from the source
+	// organization after they were migrated
+	SourceVersionsDeleted int
+
+	// TargetVersionsReplaced counts versions deleted from the target to be
+	// published again with --force
+	TargetVersionsReplaced int
+
+	// FilesSourceMissing counts files the source lists but no longer
+	// serves and FilesTooLarge files over the maximum file size, both are
+	// listed at the end of the run
+	FilesSourceMissing int
+	FilesTooLarge      int
+
+	mu       sync.Mutex
+	results  *results.Writer
+	phase    string
+	target   string
+	current  results.Row
+	recorded map[string]bool
+	failures []failure
+	missing  []results.Row
+	tooLarge []results.Row
+	samples  []transferSample
 }
 
 func NewReport() *Report {
@@ -41,6 +83,7 @@ func NewReport() *Report {
 		VersionsFailed:  0,
 		FilesFailed:     0,
 		PackagesByType:  make(map[string]int),
+		FailuresByClass: make(map[string]int),
 	}
 }
 
@@ -58,6 +101,9 @@ func (r *Report) Print(name string) {
 	pterm.Info.Println("Failed Packages:", r.PackagesFailed)
 	pterm.Info.Println("Failed Versions:", r.VersionsFailed)
 	pterm.Info.Println("Failed Files:", r.FilesFailed)
+	for _, class := range slices.Sorted(maps.Keys(r.FailuresByClass)) {
+		pterm.Info.Printf("  %s: %d\n", class, r.FailuresByClass[class])
+	}
 }
 
 func (r *Report) IncPackages(result providers.ResultState) {
@@ -93,7 +139,153 @@ func (r *Report) IncFiles(result providers.ResultState) {
 		r.FilesSkipped++
 	case providers.Failed:
 		r.FilesFailed++
+	case providers.SourceMissing:
+		r.FilesSourceMissing++
+	case providers.TooLarge:
+		r.FilesTooLarge++
+	}
+}
+
+// RecordFile counts the outcome of a single file of the version currently
+// being processed and appends it to the results file of the run
+func (r *Report) RecordFile(filename string, result providers.ResultState, err error) {
+	r.RecordTransfer(filename, result, err, 0)
+}
+
+// RecordTransfer records a file like RecordFile along with how long it took
+// to transfer
+func (r *Report) RecordTransfer(filename string, result providers.ResultState, err error, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.IncFiles(result)
+	r.writeResult(filename, result, err, elapsed)
+}
+
+func (r *Report) writeResult(filename string, result providers.ResultState, err error, elapsed time.Duration) {
+	if r.recorded != nil {
+		r.recorded[filename] = true
+	}
+	if r.phase != "" {
+		metrics.ObserveFile(r.phase, r.current.PackageType, result.String(), err)
+	}
+	var class string
+	if result == providers.Failed {
+		class = failures.Classify(err)
+		if r.FailuresByClass != nil {
+			r.FailuresByClass[class]++
+		}
+		f := failure{class: class, pkg: r.current.PackageType + "/" + r.current.PackageName}
+		if err != nil {
+			f.message = err.Error()
+		}
+		r.failures = append(r.failures, f)
+	}
+	row := r.current
+	row.Phase = r.phase
+	row.Filename = filename
+	row.State = result.String()
+	row.ErrorClass = class
+	if err != nil {
+		row.Error = err.Error()
+	}
+	transfer := utils.TakeTransfer(filename)
+	row.Bytes = transfer.Bytes
+	row.Duration = elapsed
+	row.HTTPStatus = transfer.Status
+	row.Attempts = transfer.Attempts
+	if result != providers.Skipped {
+		// Files published by external tools make no requests of their own
+		row.Attempts = max(row.Attempts, 1)
+		if row.Bytes == 0 && result == providers.Success {
+			row.Bytes = localFileSize(row)
+		}
+	}
+	if result == providers.Success || result == providers.Failed {
+		r.recordSample(row.Bytes, elapsed)
+	}
+	if result == providers.Success {
+		if path := localFilePath(row); path != "" && utils.FileExists(path) {
+			row.SHA256, _ = utils.FileChecksum(path, "sha256")
+		}
+	}
+	if result == providers.Success && r.phase != "" {
+		auditArtifact(r.phase, r.target, row)
+	}
+	switch result {
+	case providers.SourceMissing:
+		r.missing = append(r.missing, row)
+	case providers.TooLarge:
+		r.tooLarge = append(r.tooLarge, row)
+	}
+	if r.results == nil {
+		return
+	}
+	if werr := r.results.Write(row); werr != nil {
+		zap.L().Warn("Failed to write result", zap.String("file", r.results.Path), zap.Error(werr))
+	}
+}
+
+func (r *Report) setCurrent(owner, repository, packageType, packageName, version string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current = results.Row{
+		Organization: owner,
+		Repository:   repository,
+		PackageType:  packageType,
+		PackageName:  packageName,
+		Version:      version,
+	}
+	r.recorded = make(map[string]bool)
+}
+
+// localFilePath is where a pulled file is stored, container images are
+// stored as layouts and have no single file
+func localFilePath(row results.Row) string {
+	if row.PackageType == "container" {
+		return ""
+	}
+	return filepath.Join(storage.PackagesRoot, row.Organization, row.PackageType, row.PackageName, row.Version, row.Filename)
+}
+
+// localFileSize is the size of a pulled file, zero when it isn't on disk
+func localFileSize(row results.Row) int64 {
+	path := localFilePath(row)
+	if path == "" {
+		return 0
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// recordRemaining writes a result for every file of the current version the
+// callback did not report on itself
+func (r *Report) recordRemaining(filenames []string, result providers.ResultState, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, filename := range filenames {
+		if !r.recorded[filename] {
+			if result == providers.Failed {
+				r.IncFiles(result)
+			}
+			r.writeResult(filename, result, err, 0)
+		}
+	}
+}
+
+// checkThreshold notifies the webhooks the first time the share of failed
+// versions crosses the configured threshold
+func (r *Report) checkThreshold(logger *zap.Logger, threshold *notify.Threshold) {
+	processed := r.VersionSuccess + r.VersionsSkipped + r.VersionsFailed
+	if !threshold.Breached(r.VersionsFailed, processed) {
+		return
 	}
+	logger.Warn("Failure threshold crossed", zap.Int("failedVersions", r.VersionsFailed), zap.Int("processedVersions", processed))
+	event := newEvent(notify.FailureThreshold, r.phase, r)
+	event.Threshold = threshold.String()
+	notify.Send(logger, event)
 }
 
 type ProcessCallback func(
@@ -106,15 +298,66 @@ type ProcessCallback func(
 	version string,
 	filenames []string) error
 
-func ProcessPackages(logger *zap.Logger, packages [][]string, fn ProcessCallback, skipIfExists bool) (*Report, error) {
-	report := NewReport()
+// StopSpinner stops the spinner of a phase before its packages are
+// processed, the progress display takes over the terminal from then on and
+// the outcome is printed without the spinner. A stopped spinner is left
+// alone.
+func StopSpinner(spinner *pterm.SpinnerPrinter) {
+	if spinner.IsActive {
+		_ = spinner.Stop()
+	}
+}
+
+func ProcessPackages(logger *zap.Logger, phase string, packages [][]string, fn ProcessCallback, skipIfExists bool) (report *Report, err error) {
+	report = NewReport()
 	desiredPackageType := viper.GetString("GHMPKG_PACKAGE_TYPE")
 	var provider providers.Provider
 
 	pkgs := utils.GetListOfUniqueEntries(packages, []int{0, 1, 2, 3})
 
+	totals := countTotals(packages, desiredPackageType)
+	totalVersions := 0
+	for _, total := range totals {
+		totalVersions += total.Versions
+	}
+	tracker := progress.Start(phase, totalVersions)
+	defer tracker.Stop()
+	// Migrate downloads and uploads every file
+	if expected := exportedBytes(packages, desiredPackageType); phase == "Migrate" {
+		tracker.SetExpectedBytes(2 * expected)
+	} else {
+		tracker.SetExpectedBytes(expected)
+	}
+
+	// Publishing runs continue with the files earlier runs did not publish,
+	// forced runs publish every file again
+	var target string
+	var completed *results.Completed
+	force := skipIfExists && viper.GetBool("GHMPKG_FORCE")
+	if skipIfExists {
+		target = publishTarget()
+	}
+	if skipIfExists && !force {
+		var loadErr error
+		if completed, loadErr = results.LoadCompleted(logger, results.Dir, target, "sync", "migrate"); loadErr != nil {
+			logger.Warn("Failed to load earlier results, publishing every file", zap.Error(loadErr))
+		}
+	}
+
+	state, writer := startRun(logger, phase, target, totals)
+	defer func() { finishRun(logger, state, writer, err) }()
+	report.results = writer
+	report.phase = strings.ToLower(phase)
+	report.target = target
+
+	threshold, thresholdErr := notify.NewThreshold()
+	if thresholdErr != nil {
+		logger.Warn("Ignoring webhook failure threshold", zap.Error(thresholdErr))
+	}
+	failures := newFailFast()
+
 	for i, pkg := range pkgs {
-		logger.Info("Processing package", zap.Int("index", i), zap.String("org", pkg[0]), zap.String("repo", pkg[1]), zap.String("type", pkg[2]), zap.String("name", pkg[3]))
+		logger.Info("Processing package", zap.Int("index", i), zap.String("org", pkg[0]), zap.String("repo", pkg[1]), zap.String("package_type", pkg[2]), zap.String("package_name", pkg[3]))
 
 		owner := pkg[0]
 		repository := pkg[1]
@@ -125,55 +368,80 @@ func ProcessPackages(logger *zap.Logger, packages [][]string, fn ProcessCallback
 			continue
 		}
 
+		endPackage := tracing.Start("package",
+			attribute.String("ghmpkg.organization", owner),
+			attribute.String("ghmpkg.repository", repository),
+			attribute.String("ghmpkg.package_type", packageType),
+			attribute.String("ghmpkg.package_name", packageName))
+
 		if provider == nil || provider.GetPackageType() != packageType {
-			logger.Info("Creating provider", zap.String("packageType", packageType))
+			logger.Info("Creating provider", zap.String("package_type", packageType))
 			var err error
 			provider, err = providers.NewProvider(logger, packageType)
 			if err != nil {
 				logger.Error("Error creating provider", zap.Error(err))
 				report.IncPackages(providers.Failed)
+				endPackage(err)
 				return report, err
 			}
 
 			if provider == nil {
 				logger.Error("Provider is nil")
 				report.IncPackages(providers.Failed)
+				endPackage(fmt.Errorf("provider is nil"))
 				return report, fmt.Errorf("provider is nil")
 			}
 
 			if err = provider.Connect(logger); err != nil {
 				logger.Error("Error connecting to provider", zap.Error(err))
 				report.IncPackages(providers.Failed)
+				endPackage(err)
 				return report, err
 			}
 		}
 
+		versionFilters := map[string]string{
+			"0": owner,       // org
+			"1": repository,  // repo
+			"2": packageType, // package type
+			"3": packageName, // package name
+		}
+		versions := utils.GetFlatListOfColumn(packages, versionFilters, 4)
+
 		// Only check on upload
-		if skipIfExists {
-			exists, err := api.PackageExists(packageName, packageType)
+		if skipIfExists && !force {
+			exists, err := api.PackageExists(providers.TargetPackageName(packageType, packageName), packageType)
 			if err != nil {
 				logger.Error("Error checking if package exists", zap.Error(err))
 				report.IncPackages(providers.Failed)
+				endPackage(err)
 				return report, err
 			}
 
-			if exists {
+			// Packages an earlier run started on are resumed file by file
+			if exists && completed.HasPackage(owner, packageType, packageName) {
+				logger.Info("Package already exists, publishing its remaining files", zap.String("package_name", packageName))
+			} else if exists {
 				report.IncPackages(providers.Skipped)
-				logger.Info("Package already exists, skipping...", zap.String("package", packageName))
+				logger.Info("Package already exists, skipping...", zap.String("package_name", packageName))
+				for _, version := range versions {
+					report.setCurrent(owner, repository, packageType, packageName, version)
+					report.recordRemaining(utils.GetFlatListOfColumn(packages, map[string]string{
+						"0": owner,
+						"1": repository,
+						"2": packageType,
+						"3": packageName,
+						"4": version,
+					}, 5), providers.Skipped, nil)
+					tracker.Increment()
+				}
+				endPackage(nil)
 				continue
 			}
 		}
 
 		report.currentPackageType = packageType
 
-		versionFilters := map[string]string{
-			"0": owner,       // org
-			"1": repository,  // repo
-			"2": packageType, // package type
-			"3": packageName, // package name
-		}
-		versions := utils.GetFlatListOfColumn(packages, versionFilters, 4)
-
 		versionsSkipped := report.VersionsSkipped
 		versionsFailed := report.VersionsFailed
 		for i := len(versions) - 1; i >= 0; i-- {
@@ -188,33 +456,100 @@ func ProcessPackages(logger *zap.Logger, packages [][]string, fn ProcessCallback
 			filenames := utils.GetFlatListOfColumn(packages, fileFilters, 5)
 			filesSkipped := report.FilesSkipped
 			filesFailed := report.FilesFailed
-			err := fn(logger, provider, report, repository, packageType, packageName, version, filenames)
+			filesNotCopied := report.FilesSourceMissing + report.FilesTooLarge
+			tracker.SetCurrent(packageType, packageName, version)
+			report.setCurrent(owner, repository, packageType, packageName, version)
+
+			if pending := completed.Pending(owner, packageType, packageName, version, filenames); len(pending) < len(filenames) {
+				for _, filename := range filenames {
+					if !utils.Contains(pending, filename) {
+						report.RecordFile(filename, providers.Skipped, nil)
+					}
+				}
+				logger.Info("Files already published by an earlier run",
+					zap.String("package_name", packageName),
+					zap.String("package_version", version),
+					zap.Int("published", len(filenames)-len(pending)),
+					zap.Int("remaining", len(pending)))
+				if len(pending) == 0 {
+					report.IncVersions(providers.Skipped)
+					tracker.Increment()
+					continue
+				}
+				// The version is judged by the files published now
+				filenames, filesSkipped = pending, report.FilesSkipped
+			}
+			versionStart := time.Now()
+			utils.ResetTransfers()
+			endVersion := tracing.Start("version",
+				attribute.String("ghmpkg.version", version),
+				attribute.Int("ghmpkg.files", len(filenames)))
+			versionLogger := logger.With(
+				zap.String("package_type", packageType),
+				zap.String("package_name", packageName),
+				zap.String("package_version", version))
+			err := fn(versionLogger, provider, report, repository, packageType, packageName, version, filenames)
+			endVersion(err)
+			metrics.ObserveVersion(report.phase, packageType, time.Since(versionStart))
+			if err != nil {
+				report.recordRemaining(filenames, providers.Failed, err)
+			}
+			if errors.Is(err, utils.ErrLowDiskSpace) {
+				logger.Error("Stopping, disk space is running low",
+					zap.String("package_name", packageName),
+					zap.String("package_version", version),
+					zap.Error(err))
+				report.IncVersions(providers.Failed)
+				report.IncPackages(providers.Failed)
+				endPackage(err)
+				return report, err
+			}
 			if err != nil {
 				logger.Error("Error processing version",
-					zap.String("package", packageName),
-					zap.String("version", version),
+					zap.String("package_name", packageName),
+					zap.String("package_version", version),
 					zap.Error(err))
 				report.IncVersions(providers.Failed)
+				report.checkThreshold(logger, threshold)
+				tracker.Increment()
+				if stopErr := failures.record(true); stopErr != nil {
+					logger.Error("Stopping, too many versions failed in a row", zap.Error(stopErr))
+					report.IncPackages(providers.Failed)
+					endPackage(stopErr)
+					return report, stopErr
+				}
 				continue // Skip this version but continue with others
 			}
+			tracker.Increment()
 
-			if report.FilesFailed > filesFailed {
+			versionFailed := report.FilesFailed > filesFailed
+			if versionFailed {
 				report.IncVersions(providers.Failed)
-			} else if report.FilesSkipped > filesSkipped {
+			} else if report.FilesSkipped > filesSkipped || report.FilesSourceMissing+report.FilesTooLarge > filesNotCopied {
 				report.IncVersions(providers.Skipped)
 			} else {
 				report.IncVersions(providers.Success)
 			}
+			report.checkThreshold(logger, threshold)
+			if stopErr := failures.record(versionFailed); stopErr != nil {
+				logger.Error("Stopping, too many versions failed in a row", zap.Error(stopErr))
+				report.IncPackages(providers.Failed)
+				endPackage(stopErr)
+				return report, stopErr
+			}
 		}
 
 		// Determine package status based on version results
-		if report.VersionsFailed > versionsFailed {
+		var packageErr error
+		if failed := report.VersionsFailed - versionsFailed; failed > 0 {
 			report.IncPackages(providers.Failed)
+			packageErr = fmt.Errorf("%d version(s) failed", failed)
 		} else if report.VersionsSkipped > versionsSkipped {
 			report.IncPackages(providers.Skipped)
 		} else {
 			report.IncPackages(providers.Success)
 		}
+		endPackage(packageErr)
 	}
 
 	return report, nil
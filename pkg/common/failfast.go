@@ -0,0 +1,37 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// ErrFailFast stops a run after too many versions in a row failed, set
+// with GHMPKG_FAIL_FAST
+var ErrFailFast = errors.New("too many consecutive failures")
+
+// failFast counts the versions that failed in a row, a limit of zero never
+// stops the run
+type failFast struct {
+	limit       int
+	consecutive int
+}
+
+func newFailFast() *failFast {
+	return &failFast{limit: viper.GetInt("GHMPKG_FAIL_FAST")}
+}
+
+// record counts the outcome of a version and returns ErrFailFast once the
+// limit of consecutive failures is reached
+func (f *failFast) record(failed bool) error {
+	if !failed {
+		f.consecutive = 0
+		return nil
+	}
+	f.consecutive++
+	if f.limit > 0 && f.consecutive >= f.limit {
+		return fmt.Errorf("%w: %d versions failed in a row", ErrFailFast, f.consecutive)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package common
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFailFast(t *testing.T) {
+	f := &failFast{limit: 2}
+	for i, failed := range []bool{true, false, true} {
+		if err := f.record(failed); err != nil {
+			t.Fatalf("record %d returned %v, expected no error", i, err)
+		}
+	}
+	if err := f.record(true); !errors.Is(err, ErrFailFast) {
+		t.Errorf("record after 2 consecutive failures returned %v, expected ErrFailFast", err)
+	}
+
+	unlimited := &failFast{}
+	for i := 0; i < 10; i++ {
+		if err := unlimited.record(true); err != nil {
+			t.Fatalf("record without a limit returned %v", err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/actions"
+)
+
+// maxFailureGroups is how many classes and packages the summary lists
+const maxFailureGroups = 10
+
+// maxExampleLength keeps example messages on one terminal line
+const maxExampleLength = 160
+
+// failure is a failed file as shown in the run summary
+type failure struct {
+	class   string
+	pkg     string
+	message string
+}
+
+// failureGroup counts the failures sharing a class or a package
+type failureGroup struct {
+	Name    string
+	Count   int
+	Example string
+	Classes map[string]int
+}
+
+// groupFailures groups failures by class and by package, largest first. The
+// example of a class is its most common message.
+func groupFailures(failures []failure) (byClass, byPackage []failureGroup) {
+	classes := make(map[string]*failureGroup)
+	packages := make(map[string]*failureGroup)
+	messages := make(map[string]map[string]int)
+	for _, f := range failures {
+		if classes[f.class] == nil {
+			classes[f.class] = &failureGroup{Name: f.class}
+			messages[f.class] = make(map[string]int)
+		}
+		classes[f.class].Count++
+		messages[f.class][f.message]++
+
+		if packages[f.pkg] == nil {
+			packages[f.pkg] = &failureGroup{Name: f.pkg, Classes: make(map[string]int)}
+		}
+		packages[f.pkg].Count++
+		packages[f.pkg].Classes[f.class]++
+	}
+	for class, group := range classes {
+		best := 0
+		for message, count := range messages[class] {
+			if count > best || (count == best && message < group.Example) {
+				group.Example, best = message, count
+			}
+		}
+	}
+	return sortGroups(classes), sortGroups(packages)
+}
+
+func sortGroups(groups map[string]*failureGroup) []failureGroup {
+	var sorted []failureGroup
+	for _, group := range groups {
+		sorted = append(sorted, *group)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// PrintFailures lists the failed files of the run grouped by class and by
+// package, so a single cause behind many failures stands out
+func (r *Report) PrintFailures() {
+	r.mu.Lock()
+	byClass, byPackage := groupFailures(r.failures)
+	r.mu.Unlock()
+	if len(byClass) == 0 {
+		return
+	}
+
+	fmt.Println("\n❌ Failures by class:")
+	for _, group := range byClass {
+		fmt.Printf("  %5d  %-12s e.g. %s\n", group.Count, group.Name, truncate(group.Example))
+		actions.Error(fmt.Sprintf("%s failures: %s", r.phase, group.Name), fmt.Sprintf("%d files failed, e.g. %s", group.Count, truncate(group.Example)))
+	}
+	fmt.Println("❌ Failures by package:")
+	for i, group := range byPackage {
+		if i == maxFailureGroups {
+			fmt.Printf("  ... and %d more packages, see the results file or run the report command\n", len(byPackage)-i)
+			break
+		}
+		var classes []string
+		for _, class := range sortGroups(countGroups(group.Classes)) {
+			classes = append(classes, fmt.Sprintf("%s: %d", class.Name, class.Count))
+		}
+		fmt.Printf("  %5d  %s (%s)\n", group.Count, group.Name, strings.Join(classes, ", "))
+	}
+}
+
+func countGroups(counts map[string]int) map[string]*failureGroup {
+	groups := make(map[string]*failureGroup, len(counts))
+	for name, count := range counts {
+		groups[name] = &failureGroup{Name: name, Count: count}
+	}
+	return groups
+}
+
+func truncate(message string) string {
+	message = strings.Join(strings.Fields(message), " ")
+	if message == "" {
+		return "(no error message)"
+	}
+	if len(message) > maxExampleLength {
+		return message[:maxExampleLength-3] + "..."
+	}
+	return message
+}
@@ -0,0 +1,21 @@
+package common
+
+import "testing"
+
+func TestGroupFailures(t *testing.T) {
+	expired := "GET https://maven.pkg.github.com/org/a.jar failed, status: 401 Unauthorized"
+	failures := []failure{
+		{class: "auth", pkg: "maven/a", message: expired},
+		{class: "auth", pkg: "maven/a", message: expired},
+		{class: "auth", pkg: "npm/b", message: "npm-publish failed"},
+		{class: "conflict", pkg: "npm/b", message: "version already exists"},
+	}
+
+	byClass, byPackage := groupFailures(failures)
+	if len(byClass) != 2 || byClass[0].Name != "auth" || byClass[0].Count != 3 || byClass[0].Example != expired {
+		t.Errorf("unexpected classes: %+v", byClass)
+	}
+	if len(byPackage) != 2 || byPackage[0].Count != 2 || byPackage[1].Classes["conflict"] != 1 {
+		t.Errorf("unexpected packages: %+v", byPackage)
+	}
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v62/github"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"go.uber.org/zap"
+)
+
+// PackageMetadata is what an export records about a package besides its
+// versions and files
+type PackageMetadata struct {
+	Visibility  string    `json:"visibility,omitempty"`
+	Repository  string    `json:"repository,omitempty"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	HTMLURL     string    `json:"html_url,omitempty"`
+}
+
+// NewPackageMetadata returns the metadata of a GitHub package. Packages
+// have no description of their own, GitHub shows the summary of the newest
+// version that has one.
+func NewPackageMetadata(pkg *github.Package, versions []*github.PackageVersion) PackageMetadata {
+	metadata := PackageMetadata{
+		Visibility: pkg.GetVisibility(),
+		Repository: pkg.GetRepository().GetName(),
+		CreatedAt:  pkg.GetCreatedAt().Time,
+		UpdatedAt:  pkg.GetUpdatedAt().Time,
+		HTMLURL:    pkg.GetHTMLURL(),
+	}
+	for _, version := range versions {
+		if version.GetSummary() != "" {
+			metadata.Description = version.GetSummary()
+			break
+		}
+	}
+	return metadata
+}
+
+// MetadataFile returns the metadata file written next to an export CSV
+func MetadataFile(csvFile string) string {
+	return strings.TrimSuffix(csvFile, "_packages.csv") + "_metadata.json"
+}
+
+// WriteMetadata writes the metadata of exported packages, keyed by package
+// name
+func WriteMetadata(filename string, metadata map[string]PackageMetadata) error {
+	content, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, content, 0644)
+}
+
+// LoadExportedMetadata reads the metadata written with the most recent export
+// CSV of every package type, keyed by package type and name. Exports from
+// other registries and older exports have no metadata and are left out.
+func LoadExportedMetadata(logger *zap.Logger, owner string, packageTypes []string) (map[string]map[string]PackageMetadata, error) {
+	all := map[string]map[string]PackageMetadata{}
+	for _, pkgType := range packageTypes {
+		csvFile, err := utils.FindMostRecentFile(fmt.Sprintf("./migration-packages/export/%s/*_%s_%s_packages.csv", pkgType, owner, pkgType))
+		if err != nil {
+			continue
+		}
+		filename := MetadataFile(csvFile)
+		content, err := os.ReadFile(filename)
+		if os.IsNotExist(err) {
+			logger.Info("No package metadata exported", zap.String("package_type", pkgType), zap.String("file", csvFile))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var metadata map[string]PackageMetadata
+		if err := json.Unmarshal(content, &metadata); err != nil {
+			return nil, fmt.Errorf("error reading metadata file %s: %w", filename, err)
+		}
+		all[pkgType] = metadata
+	}
+	return all, nil
+}
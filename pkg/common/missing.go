@@ -0,0 +1,46 @@
+package common
+
+import (
+	"fmt"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/actions"
+	"github.com/mark-humane/gh-migrate-packages/internal/results"
+)
+
+// MissingFiles returns the files of the run the source lists but no longer
+// serves
+func (r *Report) MissingFiles() []results.Row {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]results.Row(nil), r.missing...)
+}
+
+// PrintSourceMissing lists the files of the run that no longer exist in the
+// source, they are recorded as SourceMissing instead of failing the run
+func (r *Report) PrintSourceMissing() {
+	printFiles("🕳️", "Missing from the source", r.MissingFiles())
+}
+
+// PrintTooLarge lists the files of the run that were not uploaded because
+// they are over the maximum file size
+func (r *Report) PrintTooLarge() {
+	r.mu.Lock()
+	rows := append([]results.Row(nil), r.tooLarge...)
+	r.mu.Unlock()
+	printFiles("📏", "Over the maximum file size", rows)
+}
+
+func printFiles(icon, title string, rows []results.Row) {
+	if len(rows) == 0 {
+		return
+	}
+	fmt.Printf("\n%s %s: %d files\n", icon, title, len(rows))
+	actions.Warning(title, fmt.Sprintf("%d files were not copied, see the results file or run the report command", len(rows)))
+	for i, row := range rows {
+		if i == maxFailureGroups {
+			fmt.Printf("  ... and %d more files, see the results file or run the report command\n", len(rows)-i)
+			break
+		}
+		fmt.Printf("  %s %s@%s %s\n", row.PackageType, row.PackageName, row.Version, row.Filename)
+	}
+}
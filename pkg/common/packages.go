@@ -0,0 +1,179 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/files"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// LoadExportedPackages reads the most recent export CSV of every package type
+// and returns the combined rows (without headers) together with the unique
+// package names found per type.
+func LoadExportedPackages(logger *zap.Logger, owner string, packageTypes []string) ([][]string, map[string][]string, error) {
+	var allPackages [][]string
+	packageStats := make(map[string][]string)
+	repositories, err := Repositories()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, pkgType := range packageTypes {
+		logger.Info("Processing package type", zap.String("package_type", pkgType))
+		pterm.Info.Println(fmt.Sprintf("Processing %s packages...", pkgType))
+
+		matches, ok := findExportFile(logger, owner, pkgType)
+		if !ok {
+			continue
+		}
+
+		packages, err := files.ReadCSV(matches)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading CSV file for %s: %w", pkgType, err)
+		}
+
+		// Log the content of the first few rows to verify data
+		logger.Info("CSV content sample",
+			zap.String("package_type", pkgType),
+			zap.Int("totalRows", len(packages)),
+			zap.Any("firstRows", packages[:min(len(packages), 3)]))
+
+		if len(packages) <= 1 {
+			pterm.Warning.Println(fmt.Sprintf("No package data found in CSV for %s", pkgType))
+			continue
+		}
+
+		rows := packages[1:]
+		if ExcludePrereleases() {
+			rows = skipPrereleases(logger, pkgType, rows)
+		}
+		if repositories != nil {
+			rows = repositories.rows(logger, pkgType, rows)
+		}
+		allPackages = append(allPackages, rows...)
+		for _, pkg := range rows {
+			if !utils.Contains(packageStats[pkgType], pkg[3]) {
+				packageStats[pkgType] = append(packageStats[pkgType], pkg[3])
+			}
+		}
+
+		pterm.Info.Println(fmt.Sprintf("Found %d packages in CSV for %s", len(packageStats[pkgType]), pkgType))
+	}
+
+	// A single package or version can be selected for re-migration
+	allPackages, err = selectPackage(allPackages)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Work items piped in select packages the same way
+	allPackages, err = selectWorkItems(allPackages)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Sharded runs process their part of the selected packages
+	allPackages, err = selectShard(logger, allPackages)
+	if err != nil {
+		return nil, nil, err
+	}
+	if name := viper.GetString("GHMPKG_PACKAGE_NAME"); name != "" || currentWorkItems() != nil || viper.GetString("GHMPKG_SHARD") != "" {
+		packageStats = make(map[string][]string)
+		for _, pkg := range allPackages {
+			if !utils.Contains(packageStats[pkg[2]], pkg[3]) {
+				packageStats[pkg[2]] = append(packageStats[pkg[2]], pkg[3])
+			}
+		}
+		logger.Info("Selected packages", zap.String("package_name", name), zap.String("package_version", viper.GetString("GHMPKG_PACKAGE_VERSION")), zap.Int("workItems", len(currentWorkItems())), zap.Int("files", len(allPackages)))
+	}
+
+	return allPackages, packageStats, nil
+}
+
+// findExportFile returns the most recent export CSV of a package type
+func findExportFile(logger *zap.Logger, owner, pkgType string) (string, bool) {
+	// Check if package type directory exists
+	pkgTypeDir := fmt.Sprintf("./migration-packages/export/%s", pkgType)
+	if _, err := os.Stat(pkgTypeDir); os.IsNotExist(err) {
+		logger.Warn("Package type directory not found",
+			zap.String("package_type", pkgType),
+			zap.String("directory", pkgTypeDir))
+		return "", false
+	}
+
+	// Look for the most recent CSV file in the package type directory
+	pattern := fmt.Sprintf("./migration-packages/export/%s/*_%s_%s_packages.csv", pkgType, owner, pkgType)
+	logger.Info("Searching for CSV with pattern", zap.String("pattern", pattern))
+
+	matches, err := utils.FindMostRecentFile(pattern)
+	if err != nil {
+		// Try alternate pattern without owner in filename
+		altPattern := fmt.Sprintf("./migration-packages/export/%s/*_%s_packages.csv", pkgType, pkgType)
+		logger.Info("Trying alternate pattern",
+			zap.String("altPattern", altPattern))
+
+		matches, err = utils.FindMostRecentFile(altPattern)
+		if err != nil {
+			logger.Warn("No export file found for package type",
+				zap.String("package_type", pkgType),
+				zap.Error(err))
+			return "", false
+		}
+	}
+
+	logger.Info("Found CSV file",
+		zap.String("package_type", pkgType),
+		zap.String("file", matches))
+	return matches, true
+}
+
+// ExportedPackageNames returns the names of every package of a type in the
+// most recent export, whatever packages the current filters select
+func ExportedPackageNames(logger *zap.Logger, owner, pkgType string) ([]string, error) {
+	matches, ok := findExportFile(logger, owner, pkgType)
+	if !ok {
+		return nil, nil
+	}
+	packages, err := files.ReadCSV(matches)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CSV file for %s: %w", pkgType, err)
+	}
+	var names []string
+	for _, pkg := range packages[min(len(packages), 1):] {
+		if len(pkg) > 3 && !utils.Contains(names, pkg[3]) {
+			names = append(names, pkg[3])
+		}
+	}
+	return names, nil
+}
+
+// ExportedSize returns the size of a file recorded by the export. Exports
+// made without --file-sizes and files of unknown size have none.
+func ExportedSize(pkg []string) (int64, bool) {
+	if len(pkg) < 7 || pkg[6] == "" {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(pkg[6], 10, 64)
+	return size, err == nil && size >= 0
+}
+
+// skipPrereleases removes the files of prerelease versions from export rows
+func skipPrereleases(logger *zap.Logger, pkgType string, rows [][]string) [][]string {
+	var kept [][]string
+	skipped := map[string]bool{}
+	for _, row := range rows {
+		if IsPrerelease(row[2], row[4]) {
+			skipped[row[3]+"@"+row[4]] = true
+			continue
+		}
+		kept = append(kept, row)
+	}
+	if len(skipped) > 0 {
+		logger.Info("Skipped prerelease versions", zap.String("package_type", pkgType), zap.Int("versions", len(skipped)))
+		pterm.Info.Println(fmt.Sprintf("⏭️ Skipped %d %s prerelease versions", len(skipped), pkgType))
+	}
+	return kept
+}
@@ -0,0 +1,19 @@
+package common
+
+import "testing"
+
+func TestExportedBytes(t *testing.T) {
+	packages := [][]string{
+		{"org", "repo", "maven", "lib", "1.0", "lib-1.0.jar", "2048"},
+		{"org", "repo", "maven", "lib", "1.0", "lib-1.0.pom", ""},
+		{"org", "repo", "npm", "widgets", "1.0.0", "widgets-1.0.0.tgz", "512"},
+		{"org", "repo", "nuget", "Widgets", "1.0.0", "Widgets.1.0.0.nupkg"},
+		{"org", "repo", "npm", "widgets", "1.0.1", "widgets-1.0.1.tgz", "unknown"},
+	}
+	if total := exportedBytes(packages, ""); total != 2560 {
+		t.Errorf("exportedBytes = %d, expected 2560", total)
+	}
+	if total := exportedBytes(packages, "npm"); total != 512 {
+		t.Errorf("exportedBytes of npm = %d, expected 512", total)
+	}
+}
@@ -0,0 +1,130 @@
+package common
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/pterm/pterm"
+)
+
+// transferSample is a file the run transferred or failed to transfer
+type transferSample struct {
+	packageType string
+	bytes       int64
+	duration    time.Duration
+}
+
+// Performance is how fast the files of a package type, or of the whole
+// phase, were transferred. Throughput divides the bytes by the wall-clock
+// time of the phase, so it grows with the concurrency settings while the
+// durations of single files show when the registries start to slow down.
+type Performance struct {
+	Files                    int     `json:"files"`
+	Bytes                    int64   `json:"bytes"`
+	AverageSeconds           float64 `json:"average_seconds"`
+	P50Seconds               float64 `json:"p50_seconds"`
+	P95Seconds               float64 `json:"p95_seconds"`
+	MaxSeconds               float64 `json:"max_seconds"`
+	ThroughputBytesPerSecond float64 `json:"throughput_bytes_per_second"`
+}
+
+// recordSample keeps the size and duration of a transferred file, files the
+// run skipped or never sent are left out
+func (r *Report) recordSample(bytes int64, elapsed time.Duration) {
+	if elapsed <= 0 {
+		return
+	}
+	r.samples = append(r.samples, transferSample{packageType: r.current.PackageType, bytes: bytes, duration: elapsed})
+}
+
+// Performance returns the performance of the run per package type and for
+// all package types together, elapsed is the wall-clock time of the phase
+func (r *Report) Performance(elapsed time.Duration) (map[string]Performance, Performance) {
+	r.mu.Lock()
+	samples := slices.Clone(r.samples)
+	r.mu.Unlock()
+	return performanceOf(samples, elapsed)
+}
+
+func performanceOf(samples []transferSample, elapsed time.Duration) (map[string]Performance, Performance) {
+	byType := make(map[string][]transferSample)
+	for _, sample := range samples {
+		byType[sample.packageType] = append(byType[sample.packageType], sample)
+	}
+	performance := make(map[string]Performance, len(byType))
+	for packageType, typeSamples := range byType {
+		performance[packageType] = summarize(typeSamples, elapsed)
+	}
+	return performance, summarize(samples, elapsed)
+}
+
+func summarize(samples []transferSample, elapsed time.Duration) Performance {
+	var performance Performance
+	if len(samples) == 0 {
+		return performance
+	}
+	durations := make([]float64, 0, len(samples))
+	var total float64
+	for _, sample := range samples {
+		performance.Bytes += sample.bytes
+		seconds := sample.duration.Seconds()
+		durations = append(durations, seconds)
+		total += seconds
+	}
+	slices.Sort(durations)
+	performance.Files = len(samples)
+	performance.AverageSeconds = total / float64(len(samples))
+	performance.P50Seconds = percentile(durations, 50)
+	performance.P95Seconds = percentile(durations, 95)
+	performance.MaxSeconds = durations[len(durations)-1]
+	if elapsed > 0 {
+		performance.ThroughputBytesPerSecond = float64(performance.Bytes) / elapsed.Seconds()
+	}
+	return performance
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// PrintPerformance prints a table of the files, bytes, durations and
+// throughput of each package type, to tune the concurrency settings
+// between runs
+func (r *Report) PrintPerformance(elapsed time.Duration) {
+	byType, total := r.Performance(elapsed)
+	if total.Files == 0 {
+		return
+	}
+	table := pterm.TableData{{"Type", "Files", "Bytes", "Avg", "p50", "p95", "Max", "Throughput"}}
+	for _, packageType := range slices.Sorted(maps.Keys(byType)) {
+		table = append(table, performanceRow(packageType, byType[packageType]))
+	}
+	if len(byType) > 1 {
+		table = append(table, performanceRow("total", total))
+	}
+	fmt.Println("\n⏱️ Performance:")
+	pterm.DefaultTable.WithHasHeader().WithData(table).Render()
+}
+
+func performanceRow(name string, performance Performance) []string {
+	return []string{
+		name,
+		fmt.Sprint(performance.Files),
+		utils.FormatBytes(performance.Bytes),
+		formatSeconds(performance.AverageSeconds),
+		formatSeconds(performance.P50Seconds),
+		formatSeconds(performance.P95Seconds),
+		formatSeconds(performance.MaxSeconds),
+		utils.FormatBytes(int64(performance.ThroughputBytesPerSecond)) + "/s",
+	}
+}
+
+func formatSeconds(seconds float64) string {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Millisecond).String()
+}
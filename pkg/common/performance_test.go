@@ -0,0 +1,29 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPerformanceOf(t *testing.T) {
+	var samples []transferSample
+	for i := 1; i <= 20; i++ {
+		samples = append(samples, transferSample{packageType: "npm", bytes: 100, duration: time.Duration(i) * time.Second})
+	}
+	samples = append(samples, transferSample{packageType: "maven", bytes: 1000, duration: 3 * time.Second})
+
+	byType, total := performanceOf(samples, 10*time.Second)
+	npm := byType["npm"]
+	if npm.Files != 20 || npm.Bytes != 2000 || npm.AverageSeconds != 10.5 || npm.P50Seconds != 10 || npm.P95Seconds != 19 || npm.MaxSeconds != 20 {
+		t.Errorf("unexpected npm performance: %+v", npm)
+	}
+	if npm.ThroughputBytesPerSecond != 200 {
+		t.Errorf("expected 200 bytes/s for npm, got %v", npm.ThroughputBytesPerSecond)
+	}
+	if maven := byType["maven"]; maven.Files != 1 || maven.P50Seconds != 3 || maven.P95Seconds != 3 {
+		t.Errorf("unexpected maven performance: %+v", maven)
+	}
+	if total.Files != 21 || total.Bytes != 3000 || total.ThroughputBytesPerSecond != 300 {
+		t.Errorf("unexpected total performance: %+v", total)
+	}
+}
@@ -0,0 +1,66 @@
+package common
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/spf13/viper"
+)
+
+// mavenQualifiers are the maven version qualifiers of releases made before
+// the final one
+var mavenQualifiers = map[string]bool{
+	"alpha": true, "beta": true, "milestone": true, "rc": true, "cr": true,
+	"snapshot": true, "preview": true, "pre": true, "dev": true, "ea": true,
+}
+
+// mavenShortQualifiers stand for alpha, beta and milestone when directly
+// followed by a number, as in 1.0a1 or 2.0-M3
+var mavenShortQualifiers = map[string]bool{"a": true, "b": true, "m": true}
+
+// ExcludePrereleases returns whether prerelease versions are skipped, with
+// GHMPKG_EXCLUDE_PRERELEASES unless GHMPKG_INCLUDE_PRERELEASES overrides it
+func ExcludePrereleases() bool {
+	return viper.GetBool("GHMPKG_EXCLUDE_PRERELEASES") && !viper.GetBool("GHMPKG_INCLUDE_PRERELEASES")
+}
+
+// IsPrerelease returns whether a version is a prerelease by the versioning
+// rules of its package type. npm and NuGet follow semver, where a hyphen
+// after the version number starts the prerelease label. RubyGems versions
+// with a letter are prereleases. Maven versions are prereleases when they
+// carry a qualifier such as alpha, beta, rc or SNAPSHOT. Container tags have
+// no versioning rules and are never prereleases.
+func IsPrerelease(packageType, version string) bool {
+	switch packageType {
+	case "npm", "nuget":
+		version, _, _ = strings.Cut(version, "+")
+		return strings.Contains(version, "-")
+	case "rubygems":
+		return strings.IndexFunc(version, unicode.IsLetter) >= 0
+	case "maven":
+		return mavenPrerelease(version)
+	}
+	return false
+}
+
+// mavenPrerelease looks for a prerelease qualifier among the letter runs of
+// a maven version, which maven compares as separate tokens
+func mavenPrerelease(version string) bool {
+	runes := []rune(strings.ToLower(version))
+	for i := 0; i < len(runes); {
+		if !unicode.IsLetter(runes[i]) {
+			i++
+			continue
+		}
+		end := i
+		for end < len(runes) && unicode.IsLetter(runes[end]) {
+			end++
+		}
+		token := string(runes[i:end])
+		if mavenQualifiers[token] || mavenShortQualifiers[token] && end < len(runes) && unicode.IsDigit(runes[end]) {
+			return true
+		}
+		i = end
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package common
+
+import "testing"
+
+func TestIsPrerelease(t *testing.T) {
+	tests := []struct {
+		packageType, version string
+		expected             bool
+	}{
+		{"npm", "1.2.3", false},
+		{"npm", "1.2.3-beta.1", true},
+		{"npm", "1.2.3+build-5", false},
+		{"nuget", "4.0.0.1", false},
+		{"nuget", "4.0.0-rc1", true},
+		{"rubygems", "2.1.0", false},
+		{"rubygems", "2.1.0.pre", true},
+		{"maven", "1.0.0", false},
+		{"maven", "1.0.0.Final", false},
+		{"maven", "3.2-jre", false},
+		{"maven", "1.0-SNAPSHOT", true},
+		{"maven", "2.0.0.RC2", true},
+		{"maven", "5.0-M3", true},
+		{"maven", "1.0b2", true},
+		{"maven", "1.0-beta", true},
+		{"container", "v1.0-rc1", false},
+	}
+	for _, test := range tests {
+		if actual := IsPrerelease(test.packageType, test.version); actual != test.expected {
+			t.Errorf("IsPrerelease(%s, %s) = %v, expected %v", test.packageType, test.version, actual, test.expected)
+		}
+	}
+}
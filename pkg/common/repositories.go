@@ -0,0 +1,106 @@
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// RepositoryFilter holds the repositories whose packages are migrated, by
+// lowercase name or owner/name. A nil filter allows every repository.
+type RepositoryFilter map[string]bool
+
+// repositoryKey returns the name or owner/name of a repository entry,
+// accepting repository URLs as well
+func repositoryKey(entry string) string {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+	if _, path, ok := strings.Cut(entry, "://"); ok {
+		// Keep the owner and name of https://github.com/owner/name
+		parts := strings.Split(strings.Trim(path, "/"), "/")
+		entry = strings.Join(parts[max(len(parts)-2, 1):], "/")
+	}
+	return strings.TrimSuffix(entry, ".git")
+}
+
+// parseRepositories returns the filter of the repositories in values, each
+// of which may be a comma separated list, and in the first column of list.
+// Blank lines, lines starting with # and a repository header are ignored.
+func parseRepositories(values []string, list io.Reader) (RepositoryFilter, error) {
+	filter := RepositoryFilter{}
+	add := func(entry string) {
+		if key := repositoryKey(entry); key != "" && !strings.HasPrefix(key, "#") {
+			filter[key] = true
+		}
+	}
+	for _, value := range values {
+		for _, entry := range strings.Split(value, ",") {
+			add(entry)
+		}
+	}
+	if list != nil {
+		scanner := bufio.NewScanner(list)
+		first := true
+		for scanner.Scan() {
+			entry, _, _ := strings.Cut(scanner.Text(), ",")
+			if first && utils.Contains([]string{"repository", "repo", "name"}, repositoryKey(entry)) {
+				first = false
+				continue
+			}
+			first = false
+			add(entry)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(filter) == 0 {
+		return nil, nil
+	}
+	return filter, nil
+}
+
+// Repositories returns the filter of GHMPKG_REPOSITORIES and
+// GHMPKG_REPOSITORIES_FILE, nil when neither is set
+func Repositories() (RepositoryFilter, error) {
+	var list io.Reader
+	if repositoriesFile := viper.GetString("GHMPKG_REPOSITORIES_FILE"); repositoriesFile != "" {
+		file, err := os.Open(repositoriesFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open repositories file: %w", err)
+		}
+		defer file.Close()
+		list = file
+	}
+	filter, err := parseRepositories(viper.GetStringSlice("GHMPKG_REPOSITORIES"), list)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repositories file: %w", err)
+	}
+	return filter, nil
+}
+
+// Allows returns whether the packages of a repository are migrated
+func (f RepositoryFilter) Allows(owner, repository string) bool {
+	if f == nil {
+		return true
+	}
+	repository = strings.ToLower(repository)
+	return f[repository] || f[strings.ToLower(owner)+"/"+repository]
+}
+
+// rows returns the export rows of packages linked to the repositories
+func (f RepositoryFilter) rows(logger *zap.Logger, pkgType string, rows [][]string) [][]string {
+	var kept [][]string
+	for _, row := range rows {
+		if f.Allows(row[0], row[1]) {
+			kept = append(kept, row)
+		}
+	}
+	logger.Info("Filtered exported files by repository", zap.String("package_type", pkgType), zap.Int("files", len(rows)), zap.Int("kept", len(kept)))
+	return kept
+}
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRepositories(t *testing.T) {
+	list := strings.NewReader("repository,wave\n# first wave\nacme/Web,1\n\nhttps://github.com/acme/api.git,1\n")
+	filter, err := parseRepositories([]string{"tools, docs"}, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		owner, repository string
+		expected          bool
+	}{
+		{"acme", "tools", true},
+		{"other", "Docs", true},
+		{"acme", "web", true},
+		{"other", "web", false},
+		{"acme", "api", true},
+		{"acme", "repository", false},
+		{"acme", "", false},
+	}
+	for _, test := range tests {
+		if actual := filter.Allows(test.owner, test.repository); actual != test.expected {
+			t.Errorf("Allows(%s, %s) = %v, expected %v", test.owner, test.repository, actual, test.expected)
+		}
+	}
+
+	filter, err = parseRepositories(nil, nil)
+	if err != nil || filter != nil || !filter.Allows("acme", "anything") {
+		t.Errorf("parseRepositories() without repositories = %v, %v, expected no filter", filter, err)
+	}
+}
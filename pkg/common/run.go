@@ -0,0 +1,162 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/internal/results"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// countTotals counts the packages, versions and files per package type
+func countTotals(packages [][]string, desiredPackageType string) map[string]results.Totals {
+	totals := make(map[string]results.Totals)
+	levels := []struct {
+		columns []int
+		inc     func(*results.Totals)
+	}{
+		{[]int{0, 1, 2, 3}, func(t *results.Totals) { t.Packages++ }},
+		{[]int{0, 1, 2, 3, 4}, func(t *results.Totals) { t.Versions++ }},
+		{[]int{0, 1, 2, 3, 4, 5}, func(t *results.Totals) { t.Files++ }},
+	}
+	for _, level := range levels {
+		for _, entry := range utils.GetListOfUniqueEntries(packages, level.columns) {
+			packageType := entry[2]
+			if desiredPackageType != "" && packageType != desiredPackageType {
+				continue
+			}
+			total := totals[packageType]
+			level.inc(&total)
+			totals[packageType] = total
+		}
+	}
+	return totals
+}
+
+// exportedBytes sums the exported sizes of the files of the desired package
+// type, files without a size are left out
+func exportedBytes(packages [][]string, desiredPackageType string) int64 {
+	var total int64
+	for _, pkg := range packages {
+		if desiredPackageType != "" && pkg[2] != desiredPackageType {
+			continue
+		}
+		if size, ok := ExportedSize(pkg); ok {
+			total += size
+		}
+	}
+	return total
+}
+
+// publishTarget identifies the registry and organization a run publishes
+// to, files are only resumed against the same target
+func publishTarget() string {
+	hostname := strings.TrimSuffix(viper.GetString("GHMPKG_TARGET_HOSTNAME"), "/")
+	return strings.Join([]string{registries.TargetName(), hostname, viper.GetString("GHMPKG_TARGET_ORGANIZATION")}, "/")
+}
+
+// ErrInterrupted is recorded for runs stopped by a signal
+var ErrInterrupted = errors.New("interrupted")
+
+// activeRuns are the runs of this process that have not finished yet
+var activeRuns sync.Map
+
+// startRun writes the state file of a run and opens its results file so
+// progress can be followed with the status command. Failing to do so is
+// logged but doesn't stop the run.
+func startRun(logger *zap.Logger, phase, target string, totals map[string]results.Totals) (*results.State, *results.Writer) {
+	state := results.NewState(phase, viper.GetString("GHMPKG_SOURCE_ORGANIZATION"))
+	state.Target = target
+	state.Shard = viper.GetString("GHMPKG_SHARD")
+	state.Totals = totals
+
+	writer, err := results.Create(state.ResultsFile)
+	if err != nil {
+		logger.Warn("Failed to create results file", zap.String("file", state.ResultsFile), zap.Error(err))
+		return nil, nil
+	}
+	if err := state.Save(); err != nil {
+		logger.Warn("Failed to write state file", zap.String("file", state.Path()), zap.Error(err))
+	}
+	logger.Info("Recording results", zap.String("results", state.ResultsFile), zap.String("state", state.Path()))
+	activeRuns.Store(state, writer)
+	return state, writer
+}
+
+// finishRun marks the run as finished and closes its results file
+func finishRun(logger *zap.Logger, state *results.State, writer *results.Writer, runErr error) {
+	if state == nil {
+		return
+	}
+	// A run is finished once, either here or by InterruptRuns
+	if _, active := activeRuns.LoadAndDelete(state); !active {
+		return
+	}
+	if err := writer.Close(); err != nil {
+		logger.Warn("Failed to close results file", zap.String("file", writer.Path), zap.Error(err))
+	}
+	if err := state.Finish(runErr); err != nil {
+		logger.Warn("Failed to write state file", zap.String("file", state.Path()), zap.Error(err))
+	}
+}
+
+// InterruptRuns finishes the runs still in progress as interrupted, so their
+// state doesn't claim they are running after the process was stopped, and
+// releases their target locks
+func InterruptRuns(logger *zap.Logger) {
+	activeRuns.Range(func(key, value any) bool {
+		finishRun(logger, key.(*results.State), value.(*results.Writer), ErrInterrupted)
+		return true
+	})
+	activeLocks.Range(func(key, _ any) bool {
+		releaseLock(logger, key.(*results.Lock))
+		return true
+	})
+}
+
+// activeLocks are the target locks this process holds
+var activeLocks sync.Map
+
+// LockTarget takes the lock of the target a phase publishes to, so runs
+// of other operators can't publish to the same organization until the
+// returned function releases it. GHMPKG_BREAK_LOCK takes over the lock of
+// a run that is gone from another host.
+func LockTarget(logger *zap.Logger, phase string) (func(), error) {
+	target := publishTarget()
+	// Shards publish different packages, each takes a lock of its own
+	shard, err := CurrentShard()
+	if err != nil {
+		return nil, err
+	}
+	if shard != nil {
+		target += " shard " + shard.String()
+	}
+	lock, err := results.AcquireLock(results.Dir, target, utils.RunID(), phase, viper.GetBool("GHMPKG_BREAK_LOCK"))
+	var locked *results.LockedError
+	if errors.As(err, &locked) {
+		logger.Error("Target is locked by another run", zap.String("target", target), zap.String("lockRunID", locked.Holder.RunID),
+			zap.String("lockHostname", locked.Holder.Hostname), zap.Int("lockPID", locked.Holder.PID), zap.String("file", locked.Path))
+		return nil, fmt.Errorf("%w, pass --break-lock if that run is no longer running", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock %s: %w", target, err)
+	}
+	logger.Info("Locked target", zap.String("target", target), zap.String("file", lock.Path()))
+	activeLocks.Store(lock, struct{}{})
+	return func() { releaseLock(logger, lock) }, nil
+}
+
+// releaseLock releases a target lock once
+func releaseLock(logger *zap.Logger, lock *results.Lock) {
+	if _, active := activeLocks.LoadAndDelete(lock); !active {
+		return
+	}
+	if err := lock.Release(); err != nil {
+		logger.Warn("Failed to release target lock", zap.String("file", lock.Path()), zap.Error(err))
+	}
+}
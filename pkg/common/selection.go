@@ -0,0 +1,48 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// selectedVersion returns whether an export row belongs to the package
+// GHMPKG_PACKAGE_NAME and, when set, the version GHMPKG_PACKAGE_VERSION.
+// Container versions are digests, they are selected by tag as well.
+func selectedVersion(row []string, name, version string) bool {
+	if !strings.EqualFold(row[3], name) {
+		return false
+	}
+	if version == "" || row[4] == version {
+		return true
+	}
+	return row[2] == "container" && row[5][strings.LastIndex(row[5], ":")+1:] == version
+}
+
+// selectPackage keeps the export rows of the package and version given
+// with --package-name and --package-version, for re-migrating a single
+// artifact. Rows are returned unchanged when no package is selected.
+func selectPackage(rows [][]string) ([][]string, error) {
+	name := viper.GetString("GHMPKG_PACKAGE_NAME")
+	version := viper.GetString("GHMPKG_PACKAGE_VERSION")
+	if name == "" {
+		if version != "" {
+			return nil, fmt.Errorf("--package-version needs --package-name")
+		}
+		return rows, nil
+	}
+	var kept [][]string
+	for _, row := range rows {
+		if selectedVersion(row, name, version) {
+			kept = append(kept, row)
+		}
+	}
+	if len(kept) == 0 {
+		if version != "" {
+			return nil, fmt.Errorf("no exported files of %s@%s", name, version)
+		}
+		return nil, fmt.Errorf("no exported files of %s", name)
+	}
+	return kept, nil
+}
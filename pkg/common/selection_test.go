@@ -0,0 +1,24 @@
+package common
+
+import "testing"
+
+func TestSelectedVersion(t *testing.T) {
+	tests := []struct {
+		row           []string
+		name, version string
+		expected      bool
+	}{
+		{[]string{"acme", "web", "npm", "@acme/ui", "1.0.0", "ui-1.0.0.tgz"}, "@acme/ui", "", true},
+		{[]string{"acme", "web", "npm", "@acme/ui", "1.0.0", "ui-1.0.0.tgz"}, "@acme/ui", "1.0.0", true},
+		{[]string{"acme", "web", "npm", "@acme/ui", "1.0.0", "ui-1.0.0.tgz"}, "@acme/ui", "1.0.1", false},
+		{[]string{"acme", "web", "npm", "@acme/ui", "1.0.0", "ui-1.0.0.tgz"}, "@acme/core", "", false},
+		{[]string{"acme", "web", "container", "App", "sha256:abc", "App:v2"}, "app", "v2", true},
+		{[]string{"acme", "web", "container", "App", "sha256:abc", "App:v2"}, "app", "sha256:abc", true},
+		{[]string{"acme", "web", "maven", "lib", "v2", "lib-v2.jar:v2"}, "lib", "v3", false},
+	}
+	for _, test := range tests {
+		if actual := selectedVersion(test.row, test.name, test.version); actual != test.expected {
+			t.Errorf("selectedVersion(%v, %s, %s) = %v, expected %v", test.row, test.name, test.version, actual, test.expected)
+		}
+	}
+}
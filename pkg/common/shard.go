@@ -0,0 +1,83 @@
+package common
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Shard is one of the parts a migration is split into with --shard, so
+// several runners can each process their part of the packages
+type Shard struct {
+	Index int
+	Count int
+}
+
+// ParseShard parses a shard given as i/n, shards are numbered from 1 like
+// the jobs of a matrix
+func ParseShard(value string) (Shard, error) {
+	index, count, ok := strings.Cut(strings.TrimSpace(value), "/")
+	if !ok {
+		return Shard{}, fmt.Errorf("invalid shard %q, expected i/n like 1/4", value)
+	}
+	var shard Shard
+	var err error
+	if shard.Index, err = strconv.Atoi(index); err != nil {
+		return Shard{}, fmt.Errorf("invalid shard %q, expected i/n like 1/4", value)
+	}
+	if shard.Count, err = strconv.Atoi(count); err != nil {
+		return Shard{}, fmt.Errorf("invalid shard %q, expected i/n like 1/4", value)
+	}
+	if shard.Count < 1 || shard.Index < 1 || shard.Index > shard.Count {
+		return Shard{}, fmt.Errorf("invalid shard %q, i must be between 1 and n", value)
+	}
+	return shard, nil
+}
+
+func (s Shard) String() string {
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+// Owns reports whether a package belongs to the shard. Packages are
+// assigned by a hash of their type and name, so every run with the same
+// number of shards splits them the same way and the versions of a package
+// stay together.
+func (s Shard) Owns(packageType, packageName string) bool {
+	hash := fnv.New32a()
+	hash.Write([]byte(packageType + "/" + packageName))
+	return int(hash.Sum32()%uint32(s.Count)) == s.Index-1
+}
+
+// CurrentShard returns the shard of GHMPKG_SHARD, nil when the run isn't
+// sharded
+func CurrentShard() (*Shard, error) {
+	value := viper.GetString("GHMPKG_SHARD")
+	if value == "" {
+		return nil, nil
+	}
+	shard, err := ParseShard(value)
+	if err != nil {
+		return nil, err
+	}
+	return &shard, nil
+}
+
+// selectShard keeps the export rows of the packages of the current shard
+func selectShard(logger *zap.Logger, rows [][]string) ([][]string, error) {
+	shard, err := CurrentShard()
+	if err != nil || shard == nil {
+		return rows, err
+	}
+	var kept [][]string
+	for _, row := range rows {
+		if shard.Owns(row[2], row[3]) {
+			kept = append(kept, row)
+		}
+	}
+	logger.Info("Selected shard", zap.String("shard", shard.String()), zap.Int("files", len(kept)), zap.Int("exported", len(rows)))
+	return kept, nil
+}
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseShard(t *testing.T) {
+	if shard, err := ParseShard(" 2/4 "); err != nil || shard != (Shard{Index: 2, Count: 4}) {
+		t.Errorf("ParseShard(2/4) = %v, %v", shard, err)
+	}
+	for _, value := range []string{"", "2", "0/4", "5/4", "1/0", "a/4", "1/b"} {
+		if _, err := ParseShard(value); err == nil {
+			t.Errorf("ParseShard(%q) succeeded", value)
+		}
+	}
+}
+
+func TestShardOwns(t *testing.T) {
+	// Every package belongs to exactly one shard
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("package-%d", i)
+		owners := 0
+		for index := 1; index <= 3; index++ {
+			if (Shard{Index: index, Count: 3}).Owns("npm", name) {
+				owners++
+			}
+		}
+		if owners != 1 {
+			t.Errorf("%s belongs to %d shards", name, owners)
+		}
+	}
+	if !(Shard{Index: 1, Count: 1}).Owns("maven", "lib") {
+		t.Error("a single shard doesn't own every package")
+	}
+}
@@ -0,0 +1,53 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// splitVersion splits a package name with an optional @version, npm scopes
+// start with @ so the version follows the last one
+func splitVersion(value string) (string, string) {
+	if at := strings.LastIndex(value, "@"); at > 0 {
+		return value[:at], value[at+1:]
+	}
+	return value, ""
+}
+
+// lastFile returns the index of the last row of a package, or version, -1
+// when the rows have none
+func lastFile(rows [][]string, name, version string) int {
+	last := -1
+	for i, row := range rows {
+		if selectedVersion(row, name, version) {
+			last = i
+		}
+	}
+	return last
+}
+
+// StartAfter drops the rows of the ordered work list up to the last file of
+// the package, or version, given with --start-after, so a run can continue
+// where an earlier one stopped. Rows are returned unchanged without a
+// marker.
+func StartAfter(logger *zap.Logger, rows [][]string) ([][]string, error) {
+	marker := strings.TrimSpace(viper.GetString("GHMPKG_START_AFTER"))
+	if marker == "" {
+		return rows, nil
+	}
+	name, version := splitVersion(marker)
+	last := lastFile(rows, name, version)
+	if last < 0 {
+		return nil, fmt.Errorf("no exported files of %s to start after", marker)
+	}
+	if last == len(rows)-1 {
+		return nil, fmt.Errorf("%s is the last package of the work list, nothing to start after it", marker)
+	}
+	logger.Info("Starting after", zap.String("marker", marker), zap.Int("skipped", last+1), zap.Int("remaining", len(rows)-last-1))
+	pterm.Info.Println(fmt.Sprintf("⏩ Starting after %s, skipping %d of %d files", marker, last+1, len(rows)))
+	return rows[last+1:], nil
+}
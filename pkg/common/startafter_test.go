@@ -0,0 +1,27 @@
+package common
+
+import "testing"
+
+func TestLastFile(t *testing.T) {
+	rows := [][]string{
+		{"acme", "web", "npm", "@acme/ui", "1.0.0", "ui-1.0.0.tgz"},
+		{"acme", "web", "npm", "@acme/ui", "1.1.0", "ui-1.1.0.tgz"},
+		{"acme", "web", "npm", "@acme/core", "2.0.0", "core-2.0.0.tgz"},
+	}
+	tests := []struct {
+		marker   string
+		expected int
+	}{
+		{"@acme/ui", 1},
+		{"@acme/ui@1.0.0", 0},
+		{"@acme/core@2.0.0", 2},
+		{"@acme/ui@3.0.0", -1},
+		{"lodash", -1},
+	}
+	for _, test := range tests {
+		name, version := splitVersion(test.marker)
+		if actual := lastFile(rows, name, version); actual != test.expected {
+			t.Errorf("lastFile(%s) = %d, expected %d", test.marker, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package common
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/metrics"
+	"github.com/mark-humane/gh-migrate-packages/internal/notify"
+	"github.com/mark-humane/gh-migrate-packages/internal/tracing"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+// DefaultSummaryFile is where the machine-readable run summary is written
+const DefaultSummaryFile = "migration-packages/summary.json"
+
+// Exit statuses of a phase
+const (
+	ExitSuccess = "success"
+	ExitPartial = "partial"
+	ExitFailed  = "failed"
+)
+
+// Process exit codes of the phases, a run that completed with failed
+// versions exits differently from one that stopped
+const (
+	ExitCodeSuccess = 0
+	ExitCodeAborted = 1
+	ExitCodePartial = 2
+)
+
+// ExitCode returns the process exit code of an exit status
+func ExitCode(status string) int {
+	switch status {
+	case ExitSuccess:
+		return ExitCodeSuccess
+	case ExitPartial:
+		return ExitCodePartial
+	}
+	return ExitCodeAborted
+}
+
+// SummaryCounts holds the outcomes at one level
+type SummaryCounts struct {
+	Success int `json:"success"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+}
+
+// PhaseSummary is the outcome of the last run of a phase
+type PhaseSummary struct {
+	RunID           string         `json:"run_id"`
+	Phase           string         `json:"phase"`
+	Organization    string         `json:"organization"`
+	StartedAt       time.Time      `json:"started_at"`
+	FinishedAt      time.Time      `json:"finished_at"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	ExitStatus      string         `json:"exit_status"`
+	Error           string         `json:"error,omitempty"`
+	Packages        SummaryCounts  `json:"packages"`
+	Versions        SummaryCounts  `json:"versions"`
+	Files           SummaryCounts  `json:"files"`
+	PackagesByType  map[string]int `json:"packages_by_type"`
+	FailuresByClass map[string]int `json:"failures_by_class,omitempty"`
+	BytesDownloaded int64          `json:"bytes_downloaded"`
+	BytesUploaded   int64          `json:"bytes_uploaded"`
+	// Performance is how fast the files of each package type were
+	// transferred, and PerformanceTotal of all of them
+	Performance      map[string]Performance `json:"performance,omitempty"`
+	PerformanceTotal *Performance           `json:"performance_total,omitempty"`
+}
+
+// Summary is the content of summary.json, one entry per phase. Running a
+// phase again replaces its entry and keeps the others.
+type Summary struct {
+	UpdatedAt time.Time               `json:"updated_at"`
+	Phases    map[string]PhaseSummary `json:"phases"`
+}
+
+// lastSummaries keeps the summary of every phase run by this process
+var lastSummaries sync.Map
+
+// LastSummary returns the summary of the most recent run of a phase in this
+// process
+func LastSummary(phase string) (PhaseSummary, bool) {
+	value, ok := lastSummaries.Load(phase)
+	if !ok {
+		return PhaseSummary{}, false
+	}
+	return value.(PhaseSummary), true
+}
+
+// PhaseRun measures a phase for the run summary
+type PhaseRun struct {
+	stopMetrics func()
+	endSpan     func(error)
+	phase       string
+	startedAt   time.Time
+	downloaded  int64
+	uploaded    int64
+}
+
+// StartPhase starts measuring a phase and announces it to the configured
+// webhooks
+func StartPhase(logger *zap.Logger, phase string) *PhaseRun {
+	notify.Send(logger, newEvent(notify.Started, phase, nil))
+	auditStarted(logger, phase)
+	return &PhaseRun{
+		stopMetrics: metrics.Serve(logger),
+		endSpan: tracing.Start(phase,
+			attribute.String("ghmpkg.source_organization", viper.GetString("GHMPKG_SOURCE_ORGANIZATION")),
+			attribute.String("ghmpkg.target_organization", viper.GetString("GHMPKG_TARGET_ORGANIZATION"))),
+		phase:      phase,
+		startedAt:  time.Now(),
+		downloaded: utils.BytesTransferred(utils.Download),
+		uploaded:   utils.BytesTransferred(utils.Upload),
+	}
+}
+
+// newEvent describes a phase for webhook notifications
+func newEvent(eventType, phase string, report *Report) notify.Event {
+	event := notify.Event{
+		Type:               eventType,
+		Phase:              phase,
+		Organization:       viper.GetString("GHMPKG_SOURCE_ORGANIZATION"),
+		TargetOrganization: viper.GetString("GHMPKG_TARGET_ORGANIZATION"),
+	}
+	if report != nil {
+		event.Packages = notify.Counts{Success: report.PackageSuccess, Skipped: report.PackagesSkipped, Failed: report.PackagesFailed}
+		event.Versions = notify.Counts{Success: report.VersionSuccess, Skipped: report.VersionsSkipped, Failed: report.VersionsFailed}
+		event.Files = notify.Counts{Success: report.FileSuccess, Skipped: report.FilesSkipped, Failed: report.FilesFailed}
+	}
+	return event
+}
+
+// Finish records the outcome of the phase in the summary file. The report
+// may be nil when the phase stopped before processing anything.
+func (p *PhaseRun) Finish(logger *zap.Logger, report *Report, runErr error) {
+	defer p.stopMetrics()
+	defer p.endSpan(runErr)
+	finishedAt := time.Now()
+	phase := PhaseSummary{
+		RunID:           utils.RunID(),
+		Phase:           p.phase,
+		Organization:    viper.GetString("GHMPKG_SOURCE_ORGANIZATION"),
+		StartedAt:       p.startedAt,
+		FinishedAt:      finishedAt,
+		DurationSeconds: finishedAt.Sub(p.startedAt).Seconds(),
+		ExitStatus:      ExitSuccess,
+		PackagesByType:  make(map[string]int),
+		BytesDownloaded: utils.BytesTransferred(utils.Download) - p.downloaded,
+		BytesUploaded:   utils.BytesTransferred(utils.Upload) - p.uploaded,
+	}
+	if report != nil {
+		phase.Packages = SummaryCounts{report.PackageSuccess, report.PackagesSkipped, report.PackagesFailed}
+		phase.Versions = SummaryCounts{report.VersionSuccess, report.VersionsSkipped, report.VersionsFailed}
+		phase.Files = SummaryCounts{report.FileSuccess, report.FilesSkipped, report.FilesFailed}
+		for packageType, count := range report.PackagesByType {
+			phase.PackagesByType[packageType] = count
+		}
+		if len(report.FailuresByClass) > 0 {
+			phase.FailuresByClass = maps.Clone(report.FailuresByClass)
+		}
+		if byType, total := report.Performance(finishedAt.Sub(p.startedAt)); total.Files > 0 {
+			phase.Performance, phase.PerformanceTotal = byType, &total
+		}
+		if report.PackagesFailed > 0 || report.VersionsFailed > 0 || report.FilesFailed > 0 {
+			phase.ExitStatus = ExitPartial
+		}
+	}
+	if runErr != nil {
+		phase.ExitStatus = ExitFailed
+		phase.Error = runErr.Error()
+	}
+
+	event := newEvent(notify.Completed, p.phase, report)
+	event.ExitStatus = phase.ExitStatus
+	event.Error = phase.Error
+	event.Duration = finishedAt.Sub(p.startedAt).Round(time.Second).String()
+	notify.Send(logger, event)
+
+	lastSummaries.Store(p.phase, phase)
+	auditFinished(logger, phase)
+	reportToActions(logger, phase)
+
+	path := viper.GetString("GHMPKG_SUMMARY_FILE")
+	if path == "" {
+		path = DefaultSummaryFile
+	}
+	if err := updateSummary(path, phase); err != nil {
+		logger.Warn("Failed to write run summary", zap.String("file", path), zap.Error(err))
+		return
+	}
+	logger.Info("Run summary written", zap.String("file", path), zap.String("phase", p.phase), zap.String("exitStatus", phase.ExitStatus))
+}
+
+func updateSummary(path string, phase PhaseSummary) error {
+	summary := Summary{Phases: make(map[string]PhaseSummary)}
+	if content, err := os.ReadFile(path); err == nil {
+		// A corrupt summary is replaced rather than blocking the run
+		if json.Unmarshal(content, &summary) != nil || summary.Phases == nil {
+			summary = Summary{Phases: make(map[string]PhaseSummary)}
+		}
+	}
+	summary.UpdatedAt = phase.FinishedAt
+	summary.Phases[phase.Phase] = phase
+
+	content, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := utils.EnsureDirExists(path); err != nil {
+		return err
+	}
+	return utils.ReplaceFile(path, content)
+}
@@ -0,0 +1,107 @@
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// WorkItem selects a package, or a version of it, of the export
+type WorkItem struct {
+	PackageType string
+	Name        string
+	Version     string
+}
+
+var (
+	workItemsMu sync.Mutex
+	workItems   []WorkItem
+)
+
+// SetWorkItems limits the packages read from the export to the given work
+// items, nil reads every package
+func SetWorkItems(items []WorkItem) {
+	workItemsMu.Lock()
+	defer workItemsMu.Unlock()
+	workItems = items
+}
+
+// currentWorkItems returns the work items set, nil when every package is read
+func currentWorkItems() []WorkItem {
+	workItemsMu.Lock()
+	defer workItemsMu.Unlock()
+	return workItems
+}
+
+// ReadWorkItems parses one work item per line. A line is a package name
+// with an optional @version, a package_type,package_name,package_version
+// line or a row of an export CSV, so filtered exports can be piped in.
+// Blank lines, lines starting with # and header lines are ignored.
+func ReadWorkItems(input io.Reader) ([]WorkItem, error) {
+	var items []WorkItem
+	scanner := bufio.NewScanner(input)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, ",")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		var item WorkItem
+		switch {
+		case len(fields) == 1:
+			item.Name, item.Version = splitVersion(fields[0])
+		case len(fields) == 3:
+			if fields[0] == "package_type" {
+				continue
+			}
+			item = WorkItem{fields[0], fields[1], fields[2]}
+		case len(fields) >= 6:
+			if fields[0] == "organization" {
+				continue
+			}
+			item = WorkItem{fields[2], fields[3], fields[4]}
+		default:
+			return nil, fmt.Errorf("line %d: expected a package name, package_type,package_name,package_version or an export row: %s", line, text)
+		}
+		if item.Name == "" {
+			return nil, fmt.Errorf("line %d: missing package name: %s", line, text)
+		}
+		items = append(items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("no work items in the input")
+	}
+	return items, nil
+}
+
+// selectWorkItems keeps the export rows of the work items. Rows are
+// returned unchanged when no work items were set.
+func selectWorkItems(rows [][]string) ([][]string, error) {
+	items := currentWorkItems()
+	if items == nil {
+		return rows, nil
+	}
+	var kept [][]string
+	for _, row := range rows {
+		for _, item := range items {
+			if (item.PackageType == "" || item.PackageType == row[2]) && selectedVersion(row, item.Name, item.Version) {
+				kept = append(kept, row)
+				break
+			}
+		}
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("no exported files match the %d work items", len(items))
+	}
+	return kept, nil
+}
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadWorkItems(t *testing.T) {
+	input := strings.NewReader(`# failed yesterday
+lodash
+@acme/ui@1.2.0
+
+package_type,package_name,package_version
+maven,com.acme.core,2.1.0
+organization,repository,package_type,package_name,package_version,package_filename,package_size
+acme,web,container,app,sha256:abc,app:v2,
+`)
+	items, err := ReadWorkItems(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []WorkItem{
+		{Name: "lodash"},
+		{Name: "@acme/ui", Version: "1.2.0"},
+		{"maven", "com.acme.core", "2.1.0"},
+		{"container", "app", "sha256:abc"},
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Errorf("ReadWorkItems() = %v, expected %v", items, expected)
+	}
+
+	if _, err := ReadWorkItems(strings.NewReader("npm,lodash\n")); err == nil {
+		t.Error("ReadWorkItems() accepted a line with two columns")
+	}
+}
@@ -0,0 +1,359 @@
+package compare
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/oci"
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/internal/results"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/mark-humane/gh-migrate-packages/pkg/report"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Outcomes of comparing a file
+const (
+	Match         = "match"
+	Differs       = "differs"
+	Missing       = "missing"
+	SourceMissing = "source_missing"
+	Rewritten     = "rewritten"
+	Failed        = "failed"
+)
+
+// Header is the column layout of a compare report
+var Header = []string{
+	"organization",
+	"repository",
+	"package_type",
+	"package_name",
+	"package_version",
+	"package_filename",
+	"status",
+	"source_digest",
+	"target_digest",
+	"error",
+}
+
+// Summary counts the outcomes of a compare
+type Summary struct {
+	Counts map[string]int
+	File   string
+	// Sample is the extrapolated drift of a sampled compare
+	Sample *Estimate
+}
+
+// Drift is the number of files whose content differs or that are missing
+// from the target
+func (s *Summary) Drift() int {
+	return s.Counts[Differs] + s.Counts[Missing]
+}
+
+// Status compares the digests of a file. Files rewritten for the target
+// organization are compared with the digest recorded when they were
+// published, they can't match the source.
+func Status(source, target, recorded string, rewritten bool) string {
+	switch {
+	case target == "":
+		return Missing
+	case rewritten && recorded == "":
+		return Rewritten
+	case rewritten:
+		if strings.EqualFold(recorded, target) {
+			return Match
+		}
+		return Differs
+	case source == "":
+		return SourceMissing
+	case strings.EqualFold(source, target):
+		return Match
+	}
+	return Differs
+}
+
+// recordedDigests returns the digests of the files published by the latest
+// sync and migrate runs
+func recordedDigests(logger *zap.Logger) (map[string]string, error) {
+	digests := map[string]string{}
+	for _, phase := range []string{"sync", "migrate"} {
+		states, err := results.LatestStates(logger, results.Dir, phase)
+		if err != nil {
+			return nil, err
+		}
+		for _, state := range states {
+			rows, err := results.Read(state.ResultsFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read results file %s: %w", state.ResultsFile, err)
+			}
+			for _, row := range results.Latest(rows) {
+				if row.State == "Success" && row.SHA256 != "" {
+					digests[row.Key()] = row.SHA256
+				}
+			}
+		}
+	}
+	return digests, nil
+}
+
+// fileDigest returns the sha256 digest of a file, taken from the headers of
+// a HEAD request when the registry advertises it and computed from the
+// downloaded content otherwise. It is empty when the file doesn't exist.
+func fileDigest(url, token string) (string, error) {
+	client := utils.HTTPClient()
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			return "", err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", utils.Authorization(token, "token"))
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			return "", nil
+		}
+		if method == http.MethodHead {
+			resp.Body.Close()
+			// Some registries don't answer HEAD requests, the GET decides
+			if checksum := utils.ChecksumFromHeaders(resp.Header); resp.StatusCode == http.StatusOK && checksum != nil && checksum.Algorithm == "sha256" {
+				return strings.ToLower(checksum.Value), nil
+			}
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("failed to download %s, status: %d", url, resp.StatusCode)
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, utils.ThrottleReader(resp.Body, utils.Download)); err != nil {
+			return "", fmt.Errorf("failed to download %s: %w", url, err)
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	return "", nil
+}
+
+// comparer looks up the digests of exported files on both sides
+type comparer struct {
+	logger    *zap.Logger
+	sourceOrg string
+	targetOrg string
+	target    string
+	providers map[string]providers.Provider
+	source    *oci.Client
+	targetOCI *oci.Client
+	retag     oci.RetagRules
+}
+
+// compare returns the digests of a file in the source and the target
+func (c *comparer) compare(repository, packageType, packageName, version, filename string) (string, string, error) {
+	if packageType == "container" {
+		// Images are compared by manifest digest, filenames are name:tag
+		// or the digest of untagged versions, which are not retagged
+		tag, byDigest := providers.ImageReference(filename)
+		targetTag := tag
+		if !byDigest {
+			var err error
+			if targetTag, err = c.retag.Apply(tag); err != nil {
+				return "", "", err
+			}
+		}
+		name := strings.ToLower(packageName)
+		source, _, err := c.source.Repository(path.Join(strings.ToLower(c.sourceOrg), name)).ManifestDigest(tag)
+		if err != nil {
+			return "", "", err
+		}
+		target, _, err := c.targetOCI.Repository(path.Join(strings.ToLower(c.targetOrg), name)).ManifestDigest(targetTag)
+		return source, target, err
+	}
+
+	provider, ok := c.providers[packageType]
+	if !ok {
+		var err error
+		if provider, err = providers.NewProvider(c.logger, packageType); err != nil {
+			return "", "", err
+		}
+		c.providers[packageType] = provider
+	}
+	sourceUrl, err := provider.GetDownloadUrl(c.logger, c.sourceOrg, repository, packageName, version, filename)
+	if err != nil {
+		return "", "", err
+	}
+	// Providers download from github.com, like NewBaseProvider does here
+	base := providers.NewBaseProvider(packageType, "", c.target, false)
+	targetUrl := providers.RetargetUrl(sourceUrl, base.SourceRegistryUrl.String(), base.TargetRegistryUrl.String(), c.sourceOrg, c.targetOrg)
+	if targetUrl == "" {
+		return "", "", fmt.Errorf("no target URL for %s", sourceUrl)
+	}
+
+	var source string
+	if !providers.Rewritten(packageType, filename) {
+		if source, err = fileDigest(sourceUrl, viper.GetString("GHMPKG_SOURCE_TOKEN")); err != nil {
+			return "", "", err
+		}
+	}
+	target, err := fileDigest(targetUrl, viper.GetString("GHMPKG_TARGET_TOKEN"))
+	return source, target, err
+}
+
+// Options are the settings of a compare
+type Options struct {
+	PackageType string
+	Output      string
+	// Sample is the percentage of versions compared, all versions when
+	// zero, picked at random with Seed
+	Sample float64
+	Seed   uint64
+}
+
+// Run compares the content of every exported file in the source
+// organization with its copy in the target organization and writes the
+// outcome of each file to a CSV report. A sample compares the files of
+// randomly picked versions and extrapolates how many versions drifted.
+func Run(logger *zap.Logger, options Options) (*Summary, error) {
+	packageType, output := options.PackageType, options.Output
+	logger = logger.With(zap.String("phase", "compare"))
+	if registries.SourceName() != registries.GitHub || registries.TargetName() != registries.GitHub {
+		return nil, fmt.Errorf("compare only supports GitHub Packages sources and targets")
+	}
+	sourceOrg := viper.GetString("GHMPKG_SOURCE_ORGANIZATION")
+	targetOrg := viper.GetString("GHMPKG_TARGET_ORGANIZATION")
+	sourceToken := viper.GetString("GHMPKG_SOURCE_TOKEN")
+	targetToken := viper.GetString("GHMPKG_TARGET_TOKEN")
+	if sourceOrg == "" || targetOrg == "" || sourceToken == "" || targetToken == "" {
+		return nil, fmt.Errorf("compare requires the source and target organizations and tokens")
+	}
+
+	packageTypes := common.SUPPORTED_PACKAGE_TYPES
+	if packageType != "" {
+		if !utils.Contains(common.SUPPORTED_PACKAGE_TYPES, packageType) {
+			return nil, fmt.Errorf("unsupported package type: %s", packageType)
+		}
+		packageTypes = []string{packageType}
+	}
+	packages, _, err := common.LoadExportedPackages(logger, sourceOrg, packageTypes)
+	if err != nil {
+		return nil, err
+	}
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("no package export files found")
+	}
+	var sampled, total int
+	if options.Sample > 0 {
+		packages, sampled, total = sampleVersions(packages, options.Sample, options.Seed)
+		logger.Info("Sampling versions", zap.Int("sampled", sampled), zap.Int("total", total), zap.Uint64("seed", options.Seed))
+		pterm.Info.Println(fmt.Sprintf("🎲 Comparing %d of %d versions (seed %d)", sampled, total, options.Seed))
+	}
+	drifted := map[string]bool{}
+	recorded, err := recordedDigests(logger)
+	if err != nil {
+		return nil, err
+	}
+	retag, err := oci.ParseRetagRules(viper.GetString("GHMPKG_RETAG"))
+	if err != nil {
+		return nil, err
+	}
+	containers := providers.NewBaseProvider("container", "", "", true)
+	c := &comparer{
+		logger:    logger,
+		sourceOrg: sourceOrg,
+		targetOrg: targetOrg,
+		target:    viper.GetString("GHMPKG_TARGET_HOSTNAME"),
+		providers: map[string]providers.Provider{},
+		source:    oci.NewClient(containers.SourceRegistryUrl.String(), sourceOrg, sourceToken),
+		targetOCI: oci.NewClient(containers.TargetRegistryUrl.String(), targetOrg, targetToken),
+		retag:     retag,
+	}
+
+	if output == "" {
+		output = filepath.Join(report.Dir, fmt.Sprintf("%s_compare.csv", time.Now().Format("2006-01-02_15-04-05")))
+	}
+	if err := utils.EnsureDirExists(output); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(output)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	if err := writer.Write(Header); err != nil {
+		return nil, err
+	}
+
+	summary := &Summary{Counts: map[string]int{}, File: output}
+	for _, pkg := range packages {
+		if len(pkg) < 6 {
+			continue
+		}
+		row := results.Row{Organization: pkg[0], Repository: pkg[1], PackageType: pkg[2], PackageName: pkg[3], Version: pkg[4], Filename: pkg[5]}
+		fields := []zap.Field{zap.String("package_type", row.PackageType), zap.String("package_name", row.PackageName), zap.String("package_version", row.Version), zap.String("filename", row.Filename)}
+
+		var status, message, source, target string
+		var err error
+		if row.PackageType == "nuget" && providers.TargetPackageName(row.PackageType, row.PackageName) != row.PackageName {
+			// Renamed packages are published under other filenames
+			status = Rewritten
+		} else if source, target, err = c.compare(row.Repository, row.PackageType, row.PackageName, row.Version, row.Filename); err != nil {
+			status, message = Failed, err.Error()
+			logger.Error("Failed to compare file", append(fields, zap.Error(err))...)
+		} else {
+			status = Status(source, target, recorded[row.Key()], providers.Rewritten(row.PackageType, row.Filename))
+		}
+		summary.Counts[status]++
+		if status == Differs || status == Missing {
+			drifted[versionKey(pkg)] = true
+		}
+		logger.Info("Compared file", append(fields, zap.String("status", status), zap.String("sourceDigest", source), zap.String("targetDigest", target))...)
+
+		name := fmt.Sprintf("%s %s@%s %s", row.PackageType, row.PackageName, row.Version, row.Filename)
+		switch status {
+		case Differs:
+			pterm.Error.Println(fmt.Sprintf("❌ %s differs: %s in the source, %s in the target", name, source, target))
+		case Missing:
+			pterm.Warning.Println(fmt.Sprintf("⚠️ %s is missing from the target", name))
+		case Failed:
+			pterm.Error.Println(fmt.Sprintf("❌ Failed to compare %s: %s", name, message))
+		}
+		if err := writer.Write([]string{row.Organization, row.Repository, row.PackageType, row.PackageName, row.Version, row.Filename, status, source, target, message}); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, err
+	}
+
+	fmt.Println("\n📊 Compare Summary:")
+	fmt.Printf("✅ Identical: %d files\n", summary.Counts[Match])
+	fmt.Printf("❌ Different: %d files\n", summary.Counts[Differs])
+	fmt.Printf("⚠️ Missing from the target: %d files\n", summary.Counts[Missing])
+	fmt.Printf("⏭️ Rewritten, no published digest recorded: %d files\n", summary.Counts[Rewritten])
+	fmt.Printf("⏭️ Missing from the source: %d files\n", summary.Counts[SourceMissing])
+	fmt.Printf("❌ Failed: %d files\n", summary.Counts[Failed])
+	fmt.Printf("📁 Report: %s\n", output)
+	if options.Sample > 0 {
+		e := estimate(len(drifted), sampled, total)
+		summary.Sample = &e
+		printEstimate(e)
+	}
+	return summary, nil
+}
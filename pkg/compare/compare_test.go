@@ -0,0 +1,23 @@
+package compare
+
+import "testing"
+
+func TestStatus(t *testing.T) {
+	for _, test := range []struct {
+		source, target, recorded string
+		rewritten                bool
+		want                     string
+	}{
+		{"abc", "ABC", "", false, Match},
+		{"abc", "def", "", false, Differs},
+		{"abc", "", "", false, Missing},
+		{"", "abc", "", false, SourceMissing},
+		{"", "def", "", true, Rewritten},
+		{"", "def", "def", true, Match},
+		{"", "def", "abc", true, Differs},
+	} {
+		if got := Status(test.source, test.target, test.recorded, test.rewritten); got != test.want {
+			t.Errorf("Status(%q, %q, %q, %v) = %s, want %s", test.source, test.target, test.recorded, test.rewritten, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package compare
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+// confidenceZ is the z-score of the 95% confidence interval of a sample
+const confidenceZ = 1.96
+
+// ParseSample parses a sample size, a percentage of the exported versions
+// like 5% or 0.5%
+func ParseSample(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || percent <= 0 || percent > 100 {
+		return 0, fmt.Errorf("invalid sample %q, expected a percentage like 5%%", value)
+	}
+	return percent, nil
+}
+
+// versionKey identifies the version of an export row
+func versionKey(row []string) string {
+	return strings.Join(row[:5], "\x00")
+}
+
+// sampleVersions picks percent of the versions of export rows at random and
+// returns the rows of the picked versions, at least one version is picked.
+// The same seed picks the same versions.
+func sampleVersions(rows [][]string, percent float64, seed uint64) ([][]string, int, int) {
+	var versions []string
+	seen := map[string]bool{}
+	for _, row := range rows {
+		if len(row) < 6 {
+			continue
+		}
+		if key := versionKey(row); !seen[key] {
+			seen[key] = true
+			versions = append(versions, key)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, 0, 0
+	}
+	size := min(max(int(math.Ceil(percent/100*float64(len(versions)))), 1), len(versions))
+	random := rand.New(rand.NewPCG(seed, seed))
+	picked := map[string]bool{}
+	for _, i := range random.Perm(len(versions))[:size] {
+		picked[versions[i]] = true
+	}
+	var sampled [][]string
+	for _, row := range rows {
+		if len(row) >= 6 && picked[versionKey(row)] {
+			sampled = append(sampled, row)
+		}
+	}
+	return sampled, size, len(versions)
+}
+
+// Estimate extrapolates the drift found in a sample of versions to all
+// exported versions
+type Estimate struct {
+	Sampled int
+	Total   int
+	Drifted int
+	// Rate is the share of drifted versions in the sample, Low and High
+	// bound the share of all versions with 95% confidence
+	Rate float64
+	Low  float64
+	High float64
+}
+
+// estimate returns the Wilson score interval of the drifted versions of a
+// sample, narrowed by the finite population correction so that sampling
+// every version gives the exact rate
+func estimate(drifted, sampled, total int) Estimate {
+	e := Estimate{Sampled: sampled, Total: total, Drifted: drifted}
+	if sampled == 0 {
+		e.High = 1
+		return e
+	}
+	n := float64(sampled)
+	p := float64(drifted) / n
+	e.Rate = p
+	z2 := confidenceZ * confidenceZ
+	if total > 1 {
+		z2 *= float64(total-sampled) / float64(total-1)
+	}
+	center := (p + z2/(2*n)) / (1 + z2/n)
+	margin := math.Sqrt(z2*p*(1-p)/n+z2*z2/(4*n*n)) / (1 + z2/n)
+	// The drifted versions found are a lower bound of their own
+	e.Low = max(center-margin, float64(drifted)/float64(max(total, 1)))
+	e.High = min(center+margin, 1)
+	return e
+}
+
+// Versions returns the number of versions a share of all versions is
+func (e Estimate) Versions(share float64) int {
+	return int(math.Round(share * float64(e.Total)))
+}
+
+func printEstimate(e Estimate) {
+	fmt.Println("\n🎲 Sample Confidence:")
+	fmt.Printf("🔍 Sampled: %d of %d versions (%.1f%%)\n", e.Sampled, e.Total, float64(e.Sampled)*100/float64(max(e.Total, 1)))
+	fmt.Printf("❌ Drifted in the sample: %d versions (%.1f%%)\n", e.Drifted, e.Rate*100)
+	fmt.Printf("📈 Estimated drifted versions: %d, between %d and %d with 95%% confidence (%.1f%% to %.1f%%)\n",
+		e.Versions(e.Rate), e.Versions(e.Low), e.Versions(e.High), e.Low*100, e.High*100)
+}
@@ -0,0 +1,44 @@
+package compare
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSampleVersions(t *testing.T) {
+	var rows [][]string
+	for i := 0; i < 100; i++ {
+		version := fmt.Sprintf("1.0.%d", i)
+		rows = append(rows,
+			[]string{"acme", "web", "maven", "lib", version, "lib-" + version + ".jar", "10"},
+			[]string{"acme", "web", "maven", "lib", version, "lib-" + version + ".pom", "1"})
+	}
+
+	sampled, size, total := sampleVersions(rows, 5, 42)
+	if size != 5 || total != 100 || len(sampled) != 10 {
+		t.Fatalf("expected 5 of 100 versions with both files, got %d of %d in %d rows", size, total, len(sampled))
+	}
+	again, _, _ := sampleVersions(rows, 5, 42)
+	if fmt.Sprint(again) != fmt.Sprint(sampled) {
+		t.Errorf("expected the same seed to pick the same versions")
+	}
+	if _, size, _ := sampleVersions(rows, 0.1, 42); size != 1 {
+		t.Errorf("expected at least one version, got %d", size)
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	e := estimate(0, 100, 2000)
+	if e.Rate != 0 || e.Low != 0 || e.High < 0.03 || e.High > 0.04 {
+		t.Errorf("unexpected estimate without drift: %+v", e)
+	}
+	if all := estimate(3, 50, 50); all.Low != 0.06 || all.High != 0.06 {
+		t.Errorf("expected sampling every version to be exact, got %+v", all)
+	}
+	if _, err := ParseSample("5%"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := ParseSample("150%"); err == nil {
+		t.Errorf("expected an error for a sample over 100%%")
+	}
+}
@@ -0,0 +1,75 @@
+package export
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/bundle"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/mark-humane/gh-migrate-packages/pkg/pull"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Bundle pulls every exported package and writes the export CSVs together
+// with the pulled artifacts into a single self-describing tar archive.
+func Bundle(logger *zap.Logger, bundlePath string) error {
+	owner := viper.GetString("GHMPKG_SOURCE_ORGANIZATION")
+	if organizations, err := Organizations(); err == nil && len(organizations) > 1 {
+		return fmt.Errorf("a bundle holds a single source organization, bundle %s one at a time", strings.Join(organizations, ", "))
+	}
+	if backend := viper.GetString("GHMPKG_STORAGE_BACKEND"); backend != "" && backend != "local" {
+		return fmt.Errorf("bundles require the local storage backend, got: %s", backend)
+	}
+
+	if err := pull.Pull(logger); err != nil {
+		return fmt.Errorf("failed to pull packages for bundle: %w", err)
+	}
+
+	spinner, _ := pterm.DefaultSpinner.Start(fmt.Sprintf("Writing bundle: %s", bundlePath))
+
+	baseDir := "migration-packages"
+	var paths []string
+	var packageTypes []string
+	for _, packageType := range common.SUPPORTED_PACKAGE_TYPES {
+		pattern := filepath.Join(baseDir, "export", packageType, fmt.Sprintf("*_%s_%s_packages.csv", owner, packageType))
+		csvFile, err := utils.FindMostRecentFile(pattern)
+		if err != nil {
+			continue
+		}
+		paths = append(paths, csvFile)
+		packageTypes = append(packageTypes, packageType)
+	}
+	if len(paths) == 0 {
+		spinner.Fail("No export files found to bundle")
+		return fmt.Errorf("no export files found for %s", owner)
+	}
+
+	packagesDir := filepath.Join(baseDir, "packages", owner)
+	if utils.FileExists(packagesDir) {
+		paths = append(paths, packagesDir)
+	}
+
+	manifest, err := bundle.Create(logger, bundlePath, baseDir, paths, bundle.Manifest{
+		SourceHostname:     viper.GetString("GHMPKG_SOURCE_HOSTNAME"),
+		SourceOrganization: owner,
+		PackageTypes:       packageTypes,
+	})
+	if err != nil {
+		spinner.Fail(fmt.Sprintf("Error writing bundle: %v", err))
+		return err
+	}
+
+	var totalSize int64
+	for _, file := range manifest.Files {
+		totalSize += file.Size
+	}
+	spinner.Success(fmt.Sprintf("Bundle written: %s", bundlePath))
+	fmt.Printf("📦 Package types: %s\n", strings.Join(packageTypes, ", "))
+	fmt.Printf("🗃️ Files: %d (%d bytes)\n", len(manifest.Files), totalSize)
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package export
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/go-github/v62/github"
+	"github.com/mark-humane/gh-migrate-packages/internal/api"
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// versionFiles are the files found for a package version
+type versionFiles struct {
+	version   *github.PackageVersion
+	filenames []string
+	sizes     []int64
+	result    providers.ResultState
+	err       error
+}
+
+// packageFiles are the versions and files found for a package
+type packageFiles struct {
+	versions []*github.PackageVersion
+	files    []versionFiles
+	err      error
+}
+
+// parallel calls fn for every index below count, at most concurrency at a
+// time, and returns once all calls returned
+func parallel(count, concurrency int, fn func(int)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// releases returns the versions that are not prereleases
+func releases(packageType string, versions []*github.PackageVersion) []*github.PackageVersion {
+	var kept []*github.PackageVersion
+	for _, version := range versions {
+		if !common.IsPrerelease(packageType, version.GetName()) {
+			kept = append(kept, version)
+		}
+	}
+	return kept
+}
+
+// fileSizes returns the sizes the registry advertises for the files of a
+// version, -1 for files whose size is unknown. Container images are made of
+// layers shared between tags and have no size of their own.
+func fileSizes(logger *zap.Logger, provider providers.Provider, owner, repository, packageName, version string, filenames []string) []int64 {
+	token := viper.GetString("GHMPKG_SOURCE_TOKEN")
+	sizes := make([]int64, len(filenames))
+	for i, filename := range filenames {
+		sizes[i] = -1
+		downloadUrl, err := provider.GetDownloadUrl(logger, owner, repository, packageName, version, filename)
+		if err == nil {
+			sizes[i], err = utils.ContentLength(downloadUrl, token)
+		}
+		if err != nil {
+			sizes[i] = -1
+			logger.Warn("Unable to determine file size", zap.String("package_name", packageName), zap.String("package_version", version), zap.String("filename", filename), zap.Error(err))
+		}
+	}
+	return sizes
+}
+
+// untaggedFiles splits the files of container versions into the tags and the
+// digests of untagged versions
+func untaggedFiles(files []versionFiles) ([]string, bool) {
+	var tagged []string
+	untagged := false
+	for _, version := range files {
+		for _, filename := range version.filenames {
+			if _, byDigest := providers.ImageReference(filename); byDigest {
+				untagged = true
+			} else {
+				tagged = append(tagged, filename)
+			}
+		}
+	}
+	return tagged, untagged
+}
+
+// keepReferenced leaves out the untagged versions of files that are not
+// referenced
+func keepReferenced(files []versionFiles, referenced map[string]bool) {
+	for i := range files {
+		var kept []string
+		for _, filename := range files[i].filenames {
+			if reference, byDigest := providers.ImageReference(filename); !byDigest || referenced[reference] {
+				kept = append(kept, filename)
+			}
+		}
+		files[i].filenames = kept
+	}
+}
+
+// enumerate lists the versions and files of packages, GHMPKG_CONCURRENCY
+// packages at a time. API calls of every worker share the rate limiter of
+// the token. Results keep the order of the packages, a package stops at
+// the first version failing with an error and packages not started once
+// one failed are left empty.
+func enumerate(logger *zap.Logger, provider providers.Provider, owner, packageType string, packages []*github.Package, listed map[string][]*github.PackageVersion) []packageFiles {
+	concurrency := viper.GetInt("GHMPKG_CONCURRENCY")
+	if concurrency < 1 {
+		concurrency = 5
+	}
+	found := make([]packageFiles, len(packages))
+	var failed atomic.Bool
+	parallel(len(packages), concurrency, func(i int) {
+		if failed.Load() {
+			return
+		}
+		pkg := packages[i]
+		versions, ok := listed[pkg.GetName()]
+		if !ok {
+			var err error
+			if versions, err = api.FetchPackageVersions(pkg); err != nil {
+				found[i].err = err
+				failed.Store(true)
+				return
+			}
+		}
+		if common.ExcludePrereleases() {
+			versions = releases(packageType, versions)
+		}
+		found[i].versions = versions
+		for _, version := range versions {
+			filenames, result, err := provider.FetchPackageFiles(logger, owner, pkg.Repository.GetName(), packageType, pkg.GetName(), version.GetName(), version.Metadata)
+			var sizes []int64
+			if err == nil && viper.GetBool("GHMPKG_FILE_SIZES") && packageType != "container" {
+				sizes = fileSizes(logger, provider, owner, pkg.Repository.GetName(), pkg.GetName(), version.GetName(), filenames)
+			}
+			found[i].files = append(found[i].files, versionFiles{version, filenames, sizes, result, err})
+			if err != nil {
+				failed.Store(true)
+				return
+			}
+		}
+		// Untagged versions are only kept when an index of an exported tag
+		// lists them
+		if packageType != "container" {
+			return
+		}
+		if policy, _ := providers.UntaggedPolicy(); policy != providers.UntaggedReferenced {
+			return
+		}
+		if tagged, untagged := untaggedFiles(found[i].files); untagged {
+			referenced, err := providers.ReferencedDigests(owner, pkg.GetName(), tagged)
+			if err != nil {
+				found[i].err = err
+				failed.Store(true)
+				return
+			}
+			keepReferenced(found[i].files, referenced)
+			logger.Debug("Kept untagged versions referenced by exported tags", zap.String("package_name", pkg.GetName()), zap.Int("referenced", len(referenced)))
+		}
+	})
+	return found
+}
@@ -0,0 +1,52 @@
+package export
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParallel(t *testing.T) {
+	var mu sync.Mutex
+	running, peak := 0, 0
+	done := make([]bool, 20)
+	parallel(len(done), 3, func(i int) {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		running--
+		done[i] = true
+		mu.Unlock()
+	})
+	for i, ok := range done {
+		if !ok {
+			t.Errorf("parallel skipped %d", i)
+		}
+	}
+	if peak > 3 {
+		t.Errorf("parallel ran %d at a time, expected at most 3", peak)
+	}
+}
+
+func TestKeepReferenced(t *testing.T) {
+	platform := "sha256:" + strings.Repeat("a", 64)
+	orphan := "sha256:" + strings.Repeat("b", 64)
+	files := []versionFiles{
+		{filenames: []string{"api:1.0", "api:latest"}},
+		{filenames: []string{"api:@sha256-" + strings.Repeat("a", 64)}},
+		{filenames: []string{"api:@sha256-" + strings.Repeat("b", 64)}},
+	}
+
+	tagged, untagged := untaggedFiles(files)
+	if !untagged || len(tagged) != 2 {
+		t.Fatalf("tagged = %v, untagged = %v", tagged, untagged)
+	}
+	keepReferenced(files, map[string]bool{platform: true})
+	if len(files[0].filenames) != 2 || len(files[1].filenames) != 1 || len(files[2].filenames) != 0 {
+		t.Errorf("expected only the untagged version %s to be kept and not %s, got %v", platform, orphan, files)
+	}
+}
@@ -3,11 +3,17 @@ package export
 import (
 	"fmt"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/google/go-github/v62/github"
 	"github.com/mark-humane/gh-migrate-packages/internal/api"
+	"github.com/mark-humane/gh-migrate-packages/internal/credentials"
 	"github.com/mark-humane/gh-migrate-packages/internal/files"
 	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
 	"github.com/mark-humane/gh-migrate-packages/pkg/common"
 
 	"github.com/pterm/pterm"
@@ -17,17 +23,40 @@ import (
 
 // var SUPPORTED_PACKAGE_TYPES = []string{"maven", "npm", "container", "rubygems", "nuget"}
 
-func Export(logger *zap.Logger) error {
+func Export(logger *zap.Logger) (err error) {
+	logger = logger.With(zap.String("phase", "export"))
 	startTime := time.Now()
 	report := common.NewReport()
+	phase := common.StartPhase(logger, "export")
+	defer func() { phase.Finish(logger, report, err) }()
 	packageStats := make(map[string]int)
 	totalPackages := 0
 	reposWithPackages := make(map[string]bool)
-	owner := viper.GetString("GHMPKG_SOURCE_ORGANIZATION")
 	desiredPackageTypes := viper.GetStringSlice("GHMPKG_PACKAGE_TYPES")
+	organizations, err := Organizations()
+	if err != nil {
+		return err
+	}
+	repositories, err := common.Repositories()
+	if err != nil {
+		return err
+	}
+	// Every organization is exported in turn, the organization setting is
+	// read by the API and the providers, and so is the token of its
+	// credential
+	original := viper.GetString("GHMPKG_SOURCE_ORGANIZATION")
+	defer viper.Set("GHMPKG_SOURCE_ORGANIZATION", original)
+	defer credentials.Use("source", original)
+
+	// List responses are revalidated with their ETags, unchanged lists of
+	// earlier exports don't count against the rate limit
+	if !viper.GetBool("GHMPKG_NO_CACHE") {
+		api.SetETagCache(api.ETagCacheDir)
+		defer api.SetETagCache("")
+	}
 
 	pterm.Info.Println("Starting export to csv...")
-	spinner, _ := pterm.DefaultSpinner.Start(fmt.Sprintf("Exporting packages from source org: %s", owner))
+	spinner, _ := pterm.DefaultSpinner.Start(fmt.Sprintf("Exporting packages from source org: %s", strings.Join(organizations, ", ")))
 
 	// Create base export directory
 	baseDir := "./migration-packages/export"
@@ -36,6 +65,23 @@ func Export(logger *zap.Logger) error {
 		return err
 	}
 
+	// Packages come from GitHub Packages unless another source registry is
+	// configured
+	source, err := registries.NewSource()
+	if err != nil {
+		spinner.Fail(err.Error())
+		return err
+	}
+	supportedTypes := common.SUPPORTED_PACKAGE_TYPES
+	if source != nil {
+		if len(organizations) > 1 {
+			spinner.Fail("Several source organizations are only supported for GitHub Packages")
+			return fmt.Errorf("several source organizations are only supported for GitHub Packages")
+		}
+		supportedTypes = source.PackageTypes()
+		pterm.Info.Println(fmt.Sprintf("📥 Exporting from %s", registries.SourceName()))
+	}
+
 	// Validate and filter package types
 	packageTypes := make([]string, 0)
 	if len(desiredPackageTypes) > 0 {
@@ -43,7 +89,7 @@ func Export(logger *zap.Logger) error {
 		// Validate each desired package type against supported types
 		for _, desired := range desiredPackageTypes {
 			isSupported := false
-			for _, supported := range common.SUPPORTED_PACKAGE_TYPES {
+			for _, supported := range supportedTypes {
 				if desired == supported {
 					isSupported = true
 					packageTypes = append(packageTypes, desired)
@@ -56,88 +102,146 @@ func Export(logger *zap.Logger) error {
 			}
 		}
 	} else {
-		packageTypes = common.SUPPORTED_PACKAGE_TYPES // Use all supported types if none specified
+		packageTypes = supportedTypes // Use all supported types if none specified
 		pterm.Info.Println("📦 Exporting all supported package types")
 	}
 
-	for _, packageType := range packageTypes {
-		pterm.Info.Println(fmt.Sprintf("📦 Processing %s packages...", packageType))
-
-		// Initialize CSV data for this package type
-		packagesCSV := [][]string{
-			{"organization", "repository", "package_type", "package_name", "package_version", "package_filename"},
+	for _, owner := range organizations {
+		if len(organizations) > 1 {
+			pterm.Info.Println(fmt.Sprintf("🏢 Exporting organization %s", owner))
 		}
+		viper.Set("GHMPKG_SOURCE_ORGANIZATION", owner)
+		credentials.Use("source", owner)
+		for _, packageType := range packageTypes {
+			pterm.Info.Println(fmt.Sprintf("📦 Processing %s packages...", packageType))
 
-		provider, err := providers.NewProvider(logger, packageType)
-		if err != nil {
-			spinner.Fail(fmt.Sprintf("❌ Error creating provider: %v", err))
-			return err
-		}
-
-		packages, err := api.FetchPackages(packageType)
-		if err != nil {
-			spinner.Fail(fmt.Sprintf("❌ Error getting packages: %v", err))
-			return err
-		}
-
-		packageStats[packageType] = len(packages)
-		totalPackages += len(packages)
-		pterm.Info.Println(fmt.Sprintf("📊 Found %d %s packages", len(packages), packageType))
-
-		// Process packages and add to packagesCSV
-		for i, pkg := range packages {
-			reposWithPackages[pkg.Repository.GetName()] = true
-			pterm.Info.Printf("  package %d/%d: %s\n", i+1, len(packages), pkg.GetName())
-
-			versions, err := api.FetchPackageVersions(pkg)
-			spinner.UpdateText(fmt.Sprintf("Exporting %s package(%s) from %s/%s", pkg.GetName(), packageType, owner, pkg.Repository.GetName()))
-			if err != nil {
-				spinner.Fail(fmt.Sprintf("❌ Error getting versions: %v", err))
-				return err
+			// Initialize CSV data for this package type
+			packagesCSV := [][]string{
+				{"organization", "repository", "package_type", "package_name", "package_version", "package_filename", "package_size"},
 			}
-			pterm.Info.Printf("    Found %d versions\n", len(versions))
-
-			for _, version := range versions {
-				filenames, result, err := provider.FetchPackageFiles(logger, owner, pkg.Repository.GetName(), packageType, pkg.GetName(), version.GetName(), version.Metadata)
-				if result != providers.Success {
-					report.IncPackages(result)
-					report.IncVersions(result)
-					pterm.Warning.Printf("    ⚠️  Version %s: %s\n", version.GetName(), result)
+			// Only GitHub Packages have metadata to restore
+			var metadata map[string]common.PackageMetadata
+
+			if source != nil {
+				rows, count, err := exportFromSource(logger, source, owner, packageType, report)
+				if err != nil {
+					spinner.Fail(fmt.Sprintf("❌ Error listing %s packages: %v", packageType, err))
+					return err
 				}
+				packagesCSV = append(packagesCSV, rows...)
+				packageStats[packageType] += count
+				totalPackages += count
+			} else {
+				provider, err := providers.NewProvider(logger, packageType)
 				if err != nil {
-					spinner.Fail(fmt.Sprintf("❌ Error fetching package files: %v", err))
+					spinner.Fail(fmt.Sprintf("❌ Error creating provider: %v", err))
 					return err
 				}
 
-				for _, filename := range filenames {
-					report.IncFiles(result)
-					packagesCSV = append(packagesCSV, []string{owner, pkg.Repository.GetName(), packageType, pkg.GetName(), version.GetName(), filename})
-					if result == providers.Success {
-						pterm.Success.Printf(" ✅ %s", filename)
+				packages, err := api.FetchPackages(packageType)
+				if err != nil {
+					spinner.Fail(fmt.Sprintf("❌ Error getting packages: %v", err))
+					return err
+				}
+				// Only packages linked to the selected repositories are
+				// listed further
+				if repositories != nil {
+					var linked []*github.Package
+					for _, pkg := range packages {
+						if repositories.Allows(owner, pkg.Repository.GetName()) {
+							linked = append(linked, pkg)
+						}
+					}
+					logger.Info("Filtered packages by repository", zap.String("package_type", packageType), zap.Int("packages", len(packages)), zap.Int("linked", len(linked)))
+					packages = linked
+				}
+
+				packageStats[packageType] += len(packages)
+				totalPackages += len(packages)
+				pterm.Info.Println(fmt.Sprintf("📊 Found %d %s packages", len(packages), packageType))
+				metadata = make(map[string]common.PackageMetadata, len(packages))
+
+				// The GraphQL API lists the versions of many packages per
+				// query, packages it doesn't list fall back to the REST API
+				var listed map[string][]*github.PackageVersion
+				if viper.GetBool("GHMPKG_GRAPHQL") && utils.Contains(providers.GraphQLPackageTypes, packageType) {
+					spinner.UpdateText(fmt.Sprintf("Listing %s package versions from %s", packageType, owner))
+					if listed, err = providers.ListVersionsGraphQL(logger, owner, viper.GetString("GHMPKG_SOURCE_TOKEN"), packageType); err != nil {
+						spinner.Fail(fmt.Sprintf("❌ Error listing versions: %v", err))
+						return err
+					}
+				}
+
+				// Versions and files are listed for several packages at once,
+				// then added to packagesCSV in order
+				spinner.UpdateText(fmt.Sprintf("Exporting %d %s packages from %s", len(packages), packageType, owner))
+				found := enumerate(logger, provider, owner, packageType, packages, listed)
+				for i, pkg := range packages {
+					reposWithPackages[owner+"/"+pkg.Repository.GetName()] = true
+					pterm.Info.Printf("  package %d/%d: %s\n", i+1, len(packages), pkg.GetName())
+
+					if err := found[i].err; err != nil {
+						spinner.Fail(fmt.Sprintf("❌ Error getting versions: %v", err))
+						return err
+					}
+					versions := found[i].versions
+					pterm.Info.Printf("    Found %d versions\n", len(versions))
+					metadata[pkg.GetName()] = common.NewPackageMetadata(pkg, versions)
+
+					for _, files := range found[i].files {
+						version, filenames, result := files.version, files.filenames, files.result
+						if result != providers.Success {
+							report.IncPackages(result)
+							report.IncVersions(result)
+							pterm.Warning.Printf("    ⚠️  Version %s: %s\n", version.GetName(), result)
+						}
+						if files.err != nil {
+							spinner.Fail(fmt.Sprintf("❌ Error fetching package files: %v", files.err))
+							return files.err
+						}
+
+						for j, filename := range filenames {
+							report.IncFiles(result)
+							size := ""
+							if j < len(files.sizes) && files.sizes[j] >= 0 {
+								size = strconv.FormatInt(files.sizes[j], 10)
+							}
+							packagesCSV = append(packagesCSV, []string{owner, pkg.Repository.GetName(), packageType, pkg.GetName(), version.GetName(), filename, size})
+							if result == providers.Success {
+								pterm.Success.Printf(" ✅ %s", filename)
+							}
+						}
+						report.IncVersions(providers.Success)
 					}
+					report.IncPackages(providers.Success)
 				}
-				report.IncVersions(providers.Success)
 			}
-			report.IncPackages(providers.Success)
-		}
 
-		// Create package type directory
-		packageDir := filepath.Join(baseDir, packageType)
-		if err := files.EnsureDir(packageDir); err != nil {
-			spinner.Fail(fmt.Sprintf("❌ Error creating package directory: %v", err))
-			return err
-		}
+			// Create package type directory
+			packageDir := filepath.Join(baseDir, packageType)
+			if err := files.EnsureDir(packageDir); err != nil {
+				spinner.Fail(fmt.Sprintf("❌ Error creating package directory: %v", err))
+				return err
+			}
 
-		// Create CSV file for this package type
-		timestamp := time.Now().Format("2006-01-02_15-04-05")
-		csvName := fmt.Sprintf("%s_%s_%s_packages.csv", timestamp, owner, packageType)
-		filename := filepath.Join(packageDir, csvName)
-		if err := files.CreateCSV(packagesCSV, filename); err != nil {
-			spinner.Fail(fmt.Sprintf("❌ Error creating CSV: %v", err))
-			return err
+			// Create CSV file for this package type
+			timestamp := time.Now().Format("2006-01-02_15-04-05")
+			csvName := fmt.Sprintf("%s_%s_%s_packages.csv", timestamp, owner, packageType)
+			filename := filepath.Join(packageDir, csvName)
+			if err := files.CreateCSV(packagesCSV, filename); err != nil {
+				spinner.Fail(fmt.Sprintf("❌ Error creating CSV: %v", err))
+				return err
+			}
+			pterm.Success.Printf("✅ Created CSV file: %s", csvName)
+			fmt.Println()
+			if metadata != nil {
+				if err := common.WriteMetadata(common.MetadataFile(filename), metadata); err != nil {
+					spinner.Fail(fmt.Sprintf("❌ Error writing package metadata: %v", err))
+					return err
+				}
+				logger.Info("Exported package metadata", zap.String("package_type", packageType), zap.String("file", common.MetadataFile(filename)))
+			}
 		}
-		pterm.Success.Printf("✅ Created CSV file: %s", csvName)
-		fmt.Println()
 	}
 
 	spinner.Success("Packages exported successfully")
@@ -150,6 +254,9 @@ func Export(logger *zap.Logger) error {
 
 	// Print detailed report
 	fmt.Println("\n📊 Export Summary:")
+	if len(organizations) > 1 {
+		fmt.Printf("🏢 Organizations: %d\n", len(organizations))
+	}
 	fmt.Printf("Total packages found: %d\n", totalPackages)
 	fmt.Printf("✅ Successfully processed: %d packages\n", report.GetPackages(providers.Success))
 
@@ -164,6 +271,9 @@ func Export(logger *zap.Logger) error {
 
 	fmt.Printf("❌ Failed to process: %d packages\n", report.GetPackages(providers.Failed))
 	fmt.Printf("🔍 Repositories with packages: %d\n", len(reposWithPackages))
+	if hits := api.ETagCacheHits(); hits > 0 {
+		fmt.Printf("♻️ Unchanged API responses served from cache: %d\n", hits)
+	}
 	fmt.Printf("📁 Output directory: %s\n", baseDir)
 	fmt.Printf("🕐 Total time: %dh %dm %ds\n\n", hours, minutes, seconds)
 	fmt.Println("✅ Export completed successfully!")
@@ -0,0 +1,62 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// parseOrganizations returns the comma separated organizations of value
+// followed by the organizations listed one per line in list, without
+// duplicates. Blank lines and lines starting with # are ignored.
+func parseOrganizations(value string, list io.Reader) ([]string, error) {
+	var organizations []string
+	seen := map[string]bool{}
+	add := func(organization string) {
+		organization = strings.TrimSpace(organization)
+		if organization == "" || strings.HasPrefix(organization, "#") || seen[strings.ToLower(organization)] {
+			return
+		}
+		seen[strings.ToLower(organization)] = true
+		organizations = append(organizations, organization)
+	}
+	for _, organization := range strings.Split(value, ",") {
+		add(organization)
+	}
+	if list != nil {
+		scanner := bufio.NewScanner(list)
+		for scanner.Scan() {
+			add(scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return organizations, nil
+}
+
+// Organizations returns the source organizations to export, from
+// GHMPKG_SOURCE_ORGANIZATION and GHMPKG_SOURCE_ORGANIZATIONS_FILE
+func Organizations() ([]string, error) {
+	var list io.Reader
+	if organizationsFile := viper.GetString("GHMPKG_SOURCE_ORGANIZATIONS_FILE"); organizationsFile != "" {
+		file, err := os.Open(organizationsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open organizations file: %w", err)
+		}
+		defer file.Close()
+		list = file
+	}
+	organizations, err := parseOrganizations(viper.GetString("GHMPKG_SOURCE_ORGANIZATION"), list)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read organizations file: %w", err)
+	}
+	if len(organizations) == 0 {
+		return nil, fmt.Errorf("no source organization, set GHMPKG_SOURCE_ORGANIZATION or GHMPKG_SOURCE_ORGANIZATIONS_FILE")
+	}
+	return organizations, nil
+}
@@ -0,0 +1,23 @@
+package export
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOrganizations(t *testing.T) {
+	list := strings.NewReader("# platform teams\nplatform\n\nWidgets\n  tools  \n")
+	organizations, err := parseOrganizations("widgets, mark-humane,", list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"widgets", "mark-humane", "platform", "tools"}
+	if !reflect.DeepEqual(organizations, expected) {
+		t.Errorf("parseOrganizations = %v, expected %v", organizations, expected)
+	}
+
+	if organizations, _ := parseOrganizations("", nil); len(organizations) != 0 {
+		t.Errorf("parseOrganizations of nothing = %v, expected none", organizations)
+	}
+}
@@ -0,0 +1,47 @@
+package export
+
+import (
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// exportFromSource lists the packages of a source registry other than GitHub
+// Packages as export CSV rows, and records where each file is downloaded
+// from for pull. It returns the rows and the number of packages.
+func exportFromSource(logger *zap.Logger, source registries.Source, owner, packageType string, report *common.Report) ([][]string, int, error) {
+	packages, err := source.List(logger, packageType)
+	if err != nil {
+		return nil, 0, err
+	}
+	pterm.Info.Printf("📊 Found %d %s packages\n", len(packages), packageType)
+
+	// Other registries have no GitHub repositories, maven and NuGet packages
+	// are published to the configured one
+	repository := viper.GetString("GHMPKG_REPOSITORY")
+
+	var rows [][]string
+	for _, pkg := range packages {
+		for _, version := range pkg.Versions {
+			if common.ExcludePrereleases() && common.IsPrerelease(packageType, version.Name) {
+				continue
+			}
+			for _, file := range version.Files {
+				rows = append(rows, []string{owner, repository, packageType, pkg.Name, version.Name, file.Name, ""})
+				report.IncFiles(providers.Success)
+			}
+			report.IncVersions(providers.Success)
+		}
+		report.IncPackages(providers.Success)
+	}
+
+	indexFile, err := registries.WriteIndex(owner, packageType, packages)
+	if err != nil {
+		return nil, 0, err
+	}
+	logger.Info("Wrote source index", zap.String("file", indexFile), zap.String("package_type", packageType))
+	return rows, len(packages), nil
+}
@@ -0,0 +1,251 @@
+package manifest
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/internal/results"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/mark-humane/gh-migrate-packages/pkg/report"
+	"github.com/pterm/pterm"
+	"go.uber.org/zap"
+)
+
+// phases whose runs copy packages to a target
+var phases = []string{"sync", "migrate"}
+
+// BOM is a CycloneDX 1.5 software bill of materials
+type BOM struct {
+	BOMFormat    string      `json:"bomFormat"`
+	SpecVersion  string      `json:"specVersion"`
+	SerialNumber string      `json:"serialNumber"`
+	Version      int         `json:"version"`
+	Metadata     Metadata    `json:"metadata"`
+	Components   []Component `json:"components"`
+}
+
+// Metadata describes when and by what the BOM was made
+type Metadata struct {
+	Timestamp  time.Time  `json:"timestamp"`
+	Tools      Tools      `json:"tools"`
+	Properties []Property `json:"properties,omitempty"`
+}
+
+// Tools lists the tools that made the BOM
+type Tools struct {
+	Components []Component `json:"components"`
+}
+
+// Component is one migrated file
+type Component struct {
+	Type               string              `json:"type"`
+	BOMRef             string              `json:"bom-ref,omitempty"`
+	Group              string              `json:"group,omitempty"`
+	Name               string              `json:"name"`
+	Version            string              `json:"version,omitempty"`
+	PURL               string              `json:"purl,omitempty"`
+	Hashes             []Hash              `json:"hashes,omitempty"`
+	ExternalReferences []ExternalReference `json:"externalReferences,omitempty"`
+	Properties         []Property          `json:"properties,omitempty"`
+}
+
+// Hash is a digest of a component
+type Hash struct {
+	Algorithm string `json:"alg"`
+	Content   string `json:"content"`
+}
+
+// ExternalReference points at where a component can be found
+type ExternalReference struct {
+	Type    string `json:"type"`
+	URL     string `json:"url"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// Property is a name and value pair
+type Property struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// target is where a run published to, see common.publishTarget
+type target struct {
+	registry     string
+	hostname     string
+	organization string
+}
+
+func parseTarget(value string) target {
+	first := strings.Index(value, "/")
+	last := strings.LastIndex(value, "/")
+	if first < 0 || first == last {
+		return target{registry: value}
+	}
+	return target{registry: value[:first], hostname: value[first+1 : last], organization: value[last+1:]}
+}
+
+// Generate writes a CycloneDX manifest of every file the latest sync and
+// migrate runs published, or the run of statePath, and returns its path
+func Generate(logger *zap.Logger, phase, statePath, output string) (string, error) {
+	var states []*results.State
+	if statePath != "" {
+		state, err := results.LoadState(statePath)
+		if err != nil {
+			return "", err
+		}
+		states = []*results.State{state}
+	} else {
+		selected := phases
+		if phase != "" {
+			selected = []string{phase}
+		}
+		for _, phase := range selected {
+			latest, err := results.LatestStates(logger, results.Dir, phase)
+			if err != nil {
+				return "", err
+			}
+			states = append(states, latest...)
+		}
+	}
+	if len(states) == 0 {
+		return "", fmt.Errorf("no sync or migrate runs found in %s", results.Dir)
+	}
+
+	bom := BOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: serialNumber(),
+		Version:      1,
+		Metadata: Metadata{
+			Timestamp: time.Now().UTC(),
+			Tools:     Tools{Components: []Component{{Type: "application", Name: "gh-migrate-packages"}}},
+		},
+	}
+	cache := make(map[string]providers.Provider)
+	for _, state := range states {
+		rows, err := results.Read(state.ResultsFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read results file %s: %w", state.ResultsFile, err)
+		}
+		bom.Metadata.Properties = append(bom.Metadata.Properties,
+			Property{Name: "ghmpkg:run", Value: state.Path()},
+			Property{Name: "ghmpkg:run_id", Value: state.RunID},
+			Property{Name: "ghmpkg:target", Value: state.Target})
+		for _, row := range results.Latest(rows) {
+			if row.State != "Success" {
+				continue
+			}
+			bom.Components = append(bom.Components, component(logger, cache, row, parseTarget(state.Target)))
+		}
+	}
+
+	if output == "" {
+		output = filepath.Join(report.Dir, fmt.Sprintf("%s_manifest.cdx.json", bom.Metadata.Timestamp.Format("2006-01-02_15-04-05")))
+	}
+	content, err := json.MarshalIndent(bom, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := utils.EnsureDirExists(output); err != nil {
+		return "", err
+	}
+	if err := utils.ReplaceFile(output, content); err != nil {
+		return "", err
+	}
+
+	logger.Info("Manifest written", zap.String("file", output), zap.Int("components", len(bom.Components)))
+	pterm.Success.Printf("✅ Manifest of %d files written to %s\n", len(bom.Components), output)
+	return output, nil
+}
+
+// component describes a published file with where it came from and where
+// it was published to
+func component(logger *zap.Logger, cache map[string]providers.Provider, row results.Row, to target) Component {
+	c := Component{
+		Type:    "library",
+		BOMRef:  strings.Join([]string{row.PackageType, row.Organization, row.PackageName, row.Version, row.Filename}, "/"),
+		Name:    row.PackageName,
+		Version: row.Version,
+		PURL:    purl(row),
+		Properties: []Property{
+			{Name: "ghmpkg:package_type", Value: row.PackageType},
+			{Name: "ghmpkg:filename", Value: row.Filename},
+			{Name: "ghmpkg:source_organization", Value: row.Organization},
+			{Name: "ghmpkg:repository", Value: row.Repository},
+			{Name: "ghmpkg:migrated_at", Value: row.Timestamp.UTC().Format(time.RFC3339)},
+		},
+	}
+	if row.PackageType == "container" {
+		c.Type = "container"
+	}
+	if row.SHA256 != "" {
+		c.Hashes = []Hash{{Algorithm: "SHA-256", Content: row.SHA256}}
+	}
+
+	source, destination := urls(logger, cache, row, to)
+	if source != "" {
+		c.ExternalReferences = append(c.ExternalReferences, ExternalReference{Type: "distribution", URL: source, Comment: "source"})
+	}
+	if destination != "" {
+		c.ExternalReferences = append(c.ExternalReferences, ExternalReference{Type: "distribution", URL: destination, Comment: "target"})
+	}
+	return c
+}
+
+// purl is the package URL of a file in its source registry. Maven packages
+// on GitHub are named group.artifact, which can't be split reliably, and
+// containers have no digest in the results, so both have none.
+func purl(row results.Row) string {
+	version := url.PathEscape(row.Version)
+	switch row.PackageType {
+	case "npm":
+		return fmt.Sprintf("pkg:npm/%%40%s/%s@%s", strings.ToLower(row.Organization), url.PathEscape(row.PackageName), version)
+	case "nuget":
+		return fmt.Sprintf("pkg:nuget/%s@%s", url.PathEscape(row.PackageName), version)
+	case "rubygems":
+		return fmt.Sprintf("pkg:gem/%s@%s", url.PathEscape(row.PackageName), version)
+	}
+	return ""
+}
+
+// urls returns where a file was downloaded from and where the same file is
+// found on the target. Only GitHub Packages URLs are known.
+func urls(logger *zap.Logger, cache map[string]providers.Provider, row results.Row, to target) (string, string) {
+	if registries.SourceName() != registries.GitHub {
+		return "", ""
+	}
+	provider, ok := cache[row.PackageType]
+	if !ok {
+		var err error
+		if provider, err = providers.NewProvider(logger, row.PackageType); err != nil {
+			return "", ""
+		}
+		cache[row.PackageType] = provider
+	}
+	source, err := provider.GetDownloadUrl(logger, row.Organization, row.Repository, row.PackageName, row.Version, row.Filename)
+	if err != nil {
+		return "", ""
+	}
+	if to.registry != registries.GitHub || to.organization == "" {
+		return source, ""
+	}
+	container := row.PackageType == "container"
+	// Providers download from github.com, like NewBaseProvider does here
+	base := providers.NewBaseProvider(row.PackageType, "", to.hostname, container)
+	return source, providers.RetargetUrl(source, base.SourceRegistryUrl.String(), base.TargetRegistryUrl.String(), row.Organization, to.organization)
+}
+
+func serialNumber() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,17 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/results"
+)
+
+func TestParseTargetAndPURL(t *testing.T) {
+	if got := parseTarget("github/https://ghe.example.com/octo"); got != (target{"github", "https://ghe.example.com", "octo"}) {
+		t.Errorf("parseTarget = %+v", got)
+	}
+	row := results.Row{Organization: "Acme", PackageType: "npm", PackageName: "widgets", Version: "1.0.0"}
+	if got := purl(row); got != "pkg:npm/%40acme/widgets@1.0.0" {
+		t.Errorf("purl = %s", got)
+	}
+}
@@ -0,0 +1,165 @@
+package merge
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/results"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/pterm/pterm"
+	"go.uber.org/zap"
+)
+
+// input is a results file with the state of its run when one is known
+type input struct {
+	path  string
+	state *results.State
+}
+
+// Results merges the results of several runs into a new run with one row
+// per file, the most recent outcome of each file wins. Paths can be state or
+// results files, without paths every run of the phase in results.Dir is
+// merged. The merged run is written to results.Dir so the status and report
+// commands pick it up as the latest run of its phase.
+func Results(logger *zap.Logger, phase string, paths []string) (*results.State, error) {
+	inputs, err := resolve(logger, phase, paths)
+	if err != nil {
+		return nil, err
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no runs to merge found in %s", results.Dir)
+	}
+
+	var sets [][]results.Row
+	var totals []map[string]results.Totals
+	var shards []string
+	var phases, organizations, targets []string
+	var startedAt, finishedAt time.Time
+	for _, in := range inputs {
+		rows, err := results.Read(in.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read results file %s: %w", in.path, err)
+		}
+		logger.Info("Merging results", zap.String("file", in.path), zap.Int("rows", len(rows)))
+		sets = append(sets, rows)
+
+		if in.state == nil {
+			// Without a state the run is described by its rows
+			for _, row := range rows {
+				phases = appendUnique(phases, row.Phase)
+				organizations = appendUnique(organizations, row.Organization)
+				if startedAt.IsZero() || row.Timestamp.Before(startedAt) {
+					startedAt = row.Timestamp
+				}
+				if row.Timestamp.After(finishedAt) {
+					finishedAt = row.Timestamp
+				}
+			}
+			continue
+		}
+		if in.state.Running() {
+			pterm.Warning.Printf("Run %s is still in progress, merging the rows written so far\n", in.state.Path())
+		}
+		totals = append(totals, in.state.Totals)
+		shards = append(shards, in.state.Shard)
+		phases = appendUnique(phases, in.state.Phase)
+		organizations = appendUnique(organizations, in.state.Organization)
+		targets = appendUnique(targets, in.state.Target)
+		if startedAt.IsZero() || in.state.StartedAt.Before(startedAt) {
+			startedAt = in.state.StartedAt
+		}
+		if in.state.FinishedAt != nil && in.state.FinishedAt.After(finishedAt) {
+			finishedAt = *in.state.FinishedAt
+		}
+	}
+
+	rows := results.Merge(sets...)
+	state := results.NewState(single(phases, "merged"), single(organizations, "merged"))
+	state.Target = single(targets, "")
+	state.Totals = results.MergeTotals(rows, results.ShardTotals(shards, totals)...)
+	state.MergedFrom = make([]string, len(inputs))
+	for i, in := range inputs {
+		state.MergedFrom[i] = in.path
+	}
+	if !startedAt.IsZero() {
+		state.StartedAt = startedAt
+	}
+	if finishedAt.IsZero() {
+		finishedAt = time.Now()
+	}
+	state.FinishedAt = &finishedAt
+
+	writer, err := results.Create(state.ResultsFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if err := writer.Write(row); err != nil {
+			writer.Close()
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	if err := state.Save(); err != nil {
+		return nil, err
+	}
+
+	logger.Info("Results merged",
+		zap.Int("runs", len(inputs)),
+		zap.Int("rows", len(rows)),
+		zap.String("results", state.ResultsFile),
+		zap.String("state", state.Path()))
+	pterm.Success.Printf("✅ Merged %d runs into %d files: %s\n", len(inputs), len(rows), state.Path())
+	return state, nil
+}
+
+// resolve turns the given paths into inputs, or finds every run of the phase
+func resolve(logger *zap.Logger, phase string, paths []string) ([]input, error) {
+	if len(paths) == 0 {
+		matches, err := results.FindStates(results.Dir, phase)
+		if err != nil {
+			return nil, err
+		}
+		paths = matches
+	}
+
+	var inputs []input
+	for _, path := range paths {
+		if !strings.HasSuffix(path, "_state.json") {
+			if _, err := os.Stat(path); err != nil {
+				return nil, err
+			}
+			inputs = append(inputs, input{path: path})
+			continue
+		}
+		state, err := results.LoadState(path)
+		if err != nil {
+			return nil, err
+		}
+		if phase != "" && state.Phase != strings.ToLower(phase) {
+			logger.Info("Skipping run of another phase", zap.String("state", path), zap.String("phase", state.Phase))
+			continue
+		}
+		inputs = append(inputs, input{path: state.ResultsFile, state: state})
+	}
+	return inputs, nil
+}
+
+func appendUnique(values []string, value string) []string {
+	if utils.Contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
+
+// single returns the only value, or fallback when the runs disagree
+func single(values []string, fallback string) string {
+	if len(values) == 1 && values[0] != "" {
+		return values[0]
+	}
+	return fallback
+}
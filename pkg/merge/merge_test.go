@@ -0,0 +1,189 @@
+package merge
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/results"
+	"go.uber.org/zap"
+)
+
+func at(minute int) time.Time {
+	return time.Date(2026, 1, 2, 3, minute, 0, 0, time.UTC)
+}
+
+func row(minute int, packageName, state string) results.Row {
+	return results.Row{
+		Timestamp:    at(minute),
+		Phase:        "sync",
+		Organization: "acme",
+		PackageType:  "npm",
+		PackageName:  packageName,
+		Version:      "1.0.0",
+		Filename:     packageName + "-1.0.0.tgz",
+		State:        state,
+	}
+}
+
+// run is a fixture run, without a shard and totals only its results file is
+// written
+type run struct {
+	name   string
+	shard  string
+	totals map[string]results.Totals
+	rows   []results.Row
+}
+
+// write writes the results file of a run to dir, and its state file when
+// it has totals. It returns the path to merge.
+func (r run) write(t *testing.T, dir string) string {
+	t.Helper()
+	resultsFile := filepath.Join(dir, r.name+"_results.csv")
+	writer, err := results.Create(resultsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range r.rows {
+		if err := writer.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if r.totals == nil {
+		return resultsFile
+	}
+
+	finished := r.rows[len(r.rows)-1].Timestamp
+	content, err := json.Marshal(results.State{
+		Phase:        "sync",
+		Organization: "acme",
+		Shard:        r.shard,
+		StartedAt:    r.rows[0].Timestamp,
+		FinishedAt:   &finished,
+		ResultsFile:  resultsFile,
+		Totals:       r.totals,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, r.name+"_state.json")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestResults(t *testing.T) {
+	tests := []struct {
+		name   string
+		runs   []run
+		states map[string]string
+		totals results.Totals
+	}{
+		{
+			name: "overlapping shards",
+			runs: []run{
+				{name: "shard-1", shard: "1/2", totals: map[string]results.Totals{"npm": {Packages: 2, Versions: 2, Files: 2}}, rows: []results.Row{row(0, "a", "Success"), row(1, "b", "Failed")}},
+				{name: "shard-2", shard: "2/2", totals: map[string]results.Totals{"npm": {Packages: 1, Versions: 1, Files: 1}}, rows: []results.Row{row(2, "b", "Success"), row(3, "c", "Success")}},
+			},
+			states: map[string]string{"a": "Success", "b": "Success", "c": "Success"},
+			totals: results.Totals{Packages: 3, Versions: 3, Files: 3},
+		},
+		{
+			name: "later failure wins over an earlier success",
+			runs: []run{
+				{name: "retry", totals: map[string]results.Totals{"npm": {Packages: 2, Versions: 2, Files: 2}}, rows: []results.Row{row(5, "a", "Failed"), row(6, "b", "Skipped")}},
+				{name: "first", totals: map[string]results.Totals{"npm": {Packages: 2, Versions: 2, Files: 2}}, rows: []results.Row{row(0, "a", "Success"), row(1, "b", "Failed")}},
+			},
+			states: map[string]string{"a": "Failed", "b": "Skipped"},
+			totals: results.Totals{Packages: 2, Versions: 2, Files: 2},
+		},
+		{
+			name: "results file without a state",
+			runs: []run{
+				{name: "first", totals: map[string]results.Totals{"npm": {Packages: 1, Versions: 1, Files: 1}}, rows: []results.Row{row(0, "a", "Failed")}},
+				{name: "copied", rows: []results.Row{row(4, "a", "Success"), row(4, "d", "Success")}},
+			},
+			states: map[string]string{"a": "Success", "d": "Success"},
+			totals: results.Totals{Packages: 2, Versions: 2, Files: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Merges of one process share the run ID and with it the
+			// name of the merged run
+			wd, _ := os.Getwd()
+			if err := os.Chdir(t.TempDir()); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Chdir(wd)
+
+			dir := t.TempDir()
+			var paths []string
+			for _, r := range tt.runs {
+				paths = append(paths, r.write(t, dir))
+			}
+
+			state, err := Results(zap.NewNop(), "sync", paths)
+			if err != nil {
+				t.Fatalf("Results() failed: %v", err)
+			}
+			rows, err := results.Read(state.ResultsFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			states := make(map[string]string)
+			for _, row := range rows {
+				if _, ok := states[row.PackageName]; ok {
+					t.Errorf("merged rows have %s more than once", row.Filename)
+				}
+				states[row.PackageName] = row.State
+			}
+			if len(states) != len(tt.states) {
+				t.Errorf("merged rows = %v, want %v", states, tt.states)
+			}
+			for packageName, want := range tt.states {
+				if states[packageName] != want {
+					t.Errorf("%s = %s, want %s", packageName, states[packageName], want)
+				}
+			}
+			if state.Totals["npm"] != tt.totals {
+				t.Errorf("totals = %+v, want %+v", state.Totals["npm"], tt.totals)
+			}
+			if state.Phase != "sync" || state.Organization != "acme" || len(state.MergedFrom) != len(tt.runs) {
+				t.Errorf("state = %+v", state)
+			}
+			if _, err := results.LoadState(state.Path()); err != nil {
+				t.Errorf("merged state was not saved: %v", err)
+			}
+		})
+	}
+}
+
+func TestResultsMissingInput(t *testing.T) {
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	existing := run{name: "first", totals: map[string]results.Totals{"npm": {Packages: 1, Versions: 1, Files: 1}}, rows: []results.Row{row(0, "a", "Success")}}.write(t, dir)
+	for _, paths := range [][]string{
+		{existing, filepath.Join(dir, "missing_results.csv")},
+		{existing, filepath.Join(dir, "missing_state.json")},
+		nil,
+	} {
+		if _, err := Results(zap.NewNop(), "sync", paths); err == nil {
+			t.Errorf("Results(%v) succeeded", paths)
+		}
+	}
+	if states, _ := results.FindStates(results.Dir, ""); len(states) != 0 {
+		t.Errorf("failed merges wrote %v", states)
+	}
+}
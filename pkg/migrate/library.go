@@ -0,0 +1,248 @@
+package migrate
+
+import (
+	"fmt"
+	gosync "sync"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/hooks"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/mark-humane/gh-migrate-packages/pkg/export"
+	"github.com/mark-humane/gh-migrate-packages/pkg/pull"
+	"github.com/mark-humane/gh-migrate-packages/pkg/sync"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Config holds the settings of a migration for programmatic use. Empty
+// fields fall back to the matching GHMPKG_* environment variable or .env
+// value, exactly like the command line does.
+type Config struct {
+	SourceHostname     string
+	SourceOrganization string
+	SourceToken        string
+	TargetHostname     string
+	TargetOrganization string
+	TargetToken        string
+
+	// PackageTypes limits the migration to these package types, all
+	// supported types are processed when empty. Pull, sync and migrate
+	// process one type after the other
+	PackageTypes []string
+
+	// Settings sets any other option by its environment variable name,
+	// e.g. "GHMPKG_STORAGE_BACKEND": "gcs"
+	Settings map[string]string
+
+	// Hooks are Go callbacks run for every package version at the given
+	// hook point, in addition to any configured hook commands
+	Hooks map[string]HookFunc
+
+	// Logger receives the log output, the global zap logger is used when nil
+	Logger *zap.Logger
+}
+
+// Hook points
+const (
+	HookPreDownload = hooks.PreDownload
+	HookPrePublish  = hooks.PrePublish
+	HookPostPublish = hooks.PostPublish
+)
+
+// HookEvent describes the package version a hook runs for
+type HookEvent = hooks.Event
+
+// HookFunc is a hook callback, returning an error from a pre hook fails the
+// package version
+type HookFunc = hooks.Func
+
+// Result is the outcome of a phase
+type Result = common.PhaseSummary
+
+// The phases read their settings from process-wide configuration, so only
+// one of them runs at a time
+var running gosync.Mutex
+
+func (c Config) apply() {
+	values := map[string]string{
+		"GHMPKG_SOURCE_HOSTNAME":     c.SourceHostname,
+		"GHMPKG_SOURCE_ORGANIZATION": c.SourceOrganization,
+		"GHMPKG_SOURCE_TOKEN":        c.SourceToken,
+		"GHMPKG_TARGET_HOSTNAME":     c.TargetHostname,
+		"GHMPKG_TARGET_ORGANIZATION": c.TargetOrganization,
+		"GHMPKG_TARGET_TOKEN":        c.TargetToken,
+	}
+	for key, value := range c.Settings {
+		values[key] = value
+	}
+	for key, value := range values {
+		if value != "" {
+			viper.Set(key, value)
+		}
+	}
+	hooks.SetCallbacks(c.Hooks)
+}
+
+func (c Config) logger() *zap.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return zap.L()
+}
+
+// run applies the configuration and runs a phase once per package type,
+// combining the results
+func (c Config) run(phase string, fn func(*zap.Logger) error) (*Result, error) {
+	running.Lock()
+	defer running.Unlock()
+	c.apply()
+
+	// Without explicit types the phase uses whatever is configured already
+	if len(c.PackageTypes) == 0 {
+		err := fn(c.logger())
+		if summary, ok := common.LastSummary(phase); ok {
+			return &summary, err
+		}
+		return nil, err
+	}
+
+	// Pull reads the plural key, sync and migrate the singular one
+	previousType := viper.Get("GHMPKG_PACKAGE_TYPE")
+	previousTypes := viper.Get("GHMPKG_PACKAGE_TYPES")
+	defer func() {
+		viper.Set("GHMPKG_PACKAGE_TYPE", previousType)
+		viper.Set("GHMPKG_PACKAGE_TYPES", previousTypes)
+	}()
+
+	var combined *Result
+	for _, packageType := range c.PackageTypes {
+		viper.Set("GHMPKG_PACKAGE_TYPE", packageType)
+		viper.Set("GHMPKG_PACKAGE_TYPES", packageType)
+		err := fn(c.logger())
+		if summary, ok := common.LastSummary(phase); ok {
+			combined = combine(combined, summary)
+		}
+		if err != nil {
+			return combined, err
+		}
+	}
+	return combined, nil
+}
+
+func combine(total *Result, next Result) *Result {
+	if total == nil {
+		return &next
+	}
+	add := func(a *common.SummaryCounts, b common.SummaryCounts) {
+		a.Success += b.Success
+		a.Skipped += b.Skipped
+		a.Failed += b.Failed
+	}
+	add(&total.Packages, next.Packages)
+	add(&total.Versions, next.Versions)
+	add(&total.Files, next.Files)
+	for packageType, count := range next.PackagesByType {
+		total.PackagesByType[packageType] += count
+	}
+	total.FinishedAt = next.FinishedAt
+	total.DurationSeconds = next.FinishedAt.Sub(total.StartedAt).Seconds()
+	total.BytesDownloaded += next.BytesDownloaded
+	total.BytesUploaded += next.BytesUploaded
+	if next.ExitStatus == common.ExitFailed || (next.ExitStatus == common.ExitPartial && total.ExitStatus == common.ExitSuccess) {
+		total.ExitStatus = next.ExitStatus
+		total.Error = next.Error
+	}
+	return total
+}
+
+// Exporter lists the packages of the source organization into CSV files
+type Exporter struct {
+	Config Config
+}
+
+// NewExporter creates an Exporter
+func NewExporter(config Config) *Exporter {
+	return &Exporter{Config: config}
+}
+
+// Export writes the export CSV files, one per package type
+func (e *Exporter) Export() (*Result, error) {
+	running.Lock()
+	defer running.Unlock()
+	e.Config.apply()
+
+	// Export handles several package types in one pass
+	if len(e.Config.PackageTypes) > 0 {
+		viper.Set("GHMPKG_PACKAGE_TYPES", e.Config.PackageTypes)
+	}
+	err := export.Export(e.Config.logger())
+	summary, ok := common.LastSummary("export")
+	if !ok {
+		return nil, err
+	}
+	return &summary, err
+}
+
+// ExportBundle pulls every exported package and writes them to a single
+// archive for air-gapped transfers
+func (e *Exporter) ExportBundle(path string) error {
+	if path == "" {
+		return fmt.Errorf("bundle path is required")
+	}
+	running.Lock()
+	defer running.Unlock()
+	e.Config.apply()
+	return export.Bundle(e.Config.logger(), path)
+}
+
+// Puller downloads the exported packages to local storage
+type Puller struct {
+	Config Config
+}
+
+// NewPuller creates a Puller
+func NewPuller(config Config) *Puller {
+	return &Puller{Config: config}
+}
+
+// Pull downloads every exported package version
+func (p *Puller) Pull() (*Result, error) {
+	return p.Config.run("pull", pull.Pull)
+}
+
+// Syncer publishes pulled packages to the target organization
+type Syncer struct {
+	Config Config
+}
+
+// NewSyncer creates a Syncer
+func NewSyncer(config Config) *Syncer {
+	return &Syncer{Config: config}
+}
+
+// Sync publishes every pulled package version
+func (s *Syncer) Sync() (*Result, error) {
+	return s.Config.run("sync", sync.Sync)
+}
+
+// ExtractBundle unpacks a bundle created by ExportBundle so it can be synced
+func (s *Syncer) ExtractBundle(path string) error {
+	running.Lock()
+	defer running.Unlock()
+	s.Config.apply()
+	return sync.ExtractBundle(s.Config.logger(), path)
+}
+
+// Migrator downloads and publishes packages version by version
+type Migrator struct {
+	Config Config
+}
+
+// NewMigrator creates a Migrator
+func NewMigrator(config Config) *Migrator {
+	return &Migrator{Config: config}
+}
+
+// Migrate transfers every exported package version to the target
+func (m *Migrator) Migrate() (*Result, error) {
+	return m.Config.run("migrate", Migrate)
+}
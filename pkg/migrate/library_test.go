@@ -0,0 +1,46 @@
+package migrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+)
+
+func TestCombine(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := Result{
+		StartedAt:      start,
+		FinishedAt:     start.Add(time.Minute),
+		ExitStatus:     common.ExitSuccess,
+		Versions:       common.SummaryCounts{Success: 3},
+		PackagesByType: map[string]int{"npm": 2},
+		BytesUploaded:  100,
+	}
+	second := Result{
+		StartedAt:      start.Add(time.Minute),
+		FinishedAt:     start.Add(3 * time.Minute),
+		ExitStatus:     common.ExitPartial,
+		Error:          "1 version failed",
+		Versions:       common.SummaryCounts{Success: 1, Failed: 1},
+		PackagesByType: map[string]int{"nuget": 1},
+		BytesUploaded:  50,
+	}
+
+	total := combine(combine(nil, first), second)
+	if total.Versions.Success != 4 || total.Versions.Failed != 1 {
+		t.Errorf("versions = %+v, want 4 success and 1 failed", total.Versions)
+	}
+	if total.PackagesByType["npm"] != 2 || total.PackagesByType["nuget"] != 1 {
+		t.Errorf("packages by type = %v", total.PackagesByType)
+	}
+	if total.BytesUploaded != 150 {
+		t.Errorf("bytes uploaded = %d, want 150", total.BytesUploaded)
+	}
+	if total.DurationSeconds != 180 {
+		t.Errorf("duration = %v, want 180", total.DurationSeconds)
+	}
+	if total.ExitStatus != common.ExitPartial || total.Error != "1 version failed" {
+		t.Errorf("exit status = %s (%q), want partial", total.ExitStatus, total.Error)
+	}
+}
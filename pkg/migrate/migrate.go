@@ -0,0 +1,208 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/storage"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/mark-humane/gh-migrate-packages/pkg/pull"
+	"github.com/mark-humane/gh-migrate-packages/pkg/sync"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Transfer downloads a single package version from the source and publishes
+// it to the target straight away. Local files of the version are removed
+// afterwards so only one version at a time occupies local storage.
+func Transfer(logger *zap.Logger, provider providers.Provider, report *common.Report, repository, packageType, packageName, version string, filenames []string) error {
+	defer cleanup(logger, packageType, packageName, version, filenames)
+
+	// Downloads are tracked separately so files are only counted once, by
+	// the upload that follows. Files of a failed download are recorded as
+	// failed by ProcessPackages.
+	downloadReport := common.NewReport()
+	if err := pull.Download(logger, provider, downloadReport, repository, packageType, packageName, version, filenames); err != nil {
+		return err
+	}
+	if downloadReport.FilesFailed > 0 {
+		return fmt.Errorf("failed to download %d file(s)", downloadReport.FilesFailed)
+	}
+
+	// Files the source no longer serves are recorded as missing and the rest
+	// of the version is published
+	for _, row := range downloadReport.MissingFiles() {
+		report.RecordFile(row.Filename, providers.SourceMissing, errors.New(row.Error))
+		filenames = slices.DeleteFunc(slices.Clone(filenames), func(filename string) bool { return filename == row.Filename })
+	}
+	if len(filenames) == 0 {
+		return nil
+	}
+
+	return sync.Upload(logger, provider, report, repository, packageType, packageName, version, filenames)
+}
+
+// cleanup removes the local copy of a transferred version unless artifacts
+// are kept
+func cleanup(logger *zap.Logger, packageType, packageName, version string, filenames []string) {
+	owner := viper.GetString("GHMPKG_SOURCE_ORGANIZATION")
+	versionDirs := []string{version}
+	if packageType == "container" {
+		// Container images are stored per tag rather than per version
+		versionDirs = nil
+		for _, filename := range filenames {
+			versionDirs = append(versionDirs, strings.Split(filename, ":")[1])
+		}
+		owner = strings.ToLower(owner)
+		packageName = strings.ToLower(packageName)
+	}
+
+	for _, dir := range versionDirs {
+		storage.Clean(logger, filepath.Join(storage.PackagesRoot, owner, packageType, packageName, dir))
+	}
+}
+
+// Migrate streams every exported package version from the source to the
+// target organization without staging the whole migration locally.
+func Migrate(logger *zap.Logger) (err error) {
+	logger = logger.With(zap.String("phase", "migrate"))
+	startTime := time.Now()
+	var report *common.Report
+	phase := common.StartPhase(logger, "migrate")
+	defer func() { phase.Finish(logger, report, err) }()
+	utils.ResetRequestCounters()
+	sync.CheckPath(logger)
+	owner := viper.GetString("GHMPKG_SOURCE_ORGANIZATION")
+	targetOwner := viper.GetString("GHMPKG_TARGET_ORGANIZATION")
+	desiredPackageType := viper.GetString("GHMPKG_PACKAGE_TYPE")
+
+	if err := sync.CheckDeleteSource(logger); err != nil {
+		return err
+	}
+	if err := sync.CheckForce(logger); err != nil {
+		return err
+	}
+	if _, err := sync.MaxFileSize(); err != nil {
+		return err
+	}
+	if _, err := utils.ChunkSize(); err != nil {
+		return err
+	}
+	if _, err := providers.NpmScopes(); err != nil {
+		return err
+	}
+	if _, err := providers.NugetConflictPolicy(); err != nil {
+		return err
+	}
+	unlock, err := common.LockTarget(logger, "migrate")
+	if err != nil {
+		pterm.Error.Println(fmt.Sprintf("❌ Migrate stopped: %v", err))
+		return err
+	}
+	defer unlock()
+
+	pterm.Info.Println("Starting migrate process...")
+	spinner, _ := pterm.DefaultSpinner.Start(fmt.Sprintf("Migrating packages from %s to %s", owner, targetOwner))
+
+	packageTypes := common.SUPPORTED_PACKAGE_TYPES
+	if desiredPackageType != "" {
+		if !utils.Contains(common.SUPPORTED_PACKAGE_TYPES, desiredPackageType) {
+			spinner.Fail(fmt.Sprintf("Unsupported package type: %s", desiredPackageType))
+			return fmt.Errorf("unsupported package type: %s", desiredPackageType)
+		}
+		packageTypes = []string{desiredPackageType}
+	}
+
+	allPackages, packageStats, err := common.LoadExportedPackages(logger, owner, packageTypes)
+	if err != nil {
+		spinner.Fail(err.Error())
+		return err
+	}
+	if len(allPackages) == 0 {
+		spinner.Fail("No package export files found")
+		return fmt.Errorf("no package export files found")
+	}
+	allPackages = sync.OrderNpmPackages(logger, allPackages)
+	if allPackages, err = common.StartAfter(logger, allPackages); err != nil {
+		spinner.Fail(err.Error())
+		return err
+	}
+
+	common.StopSpinner(spinner)
+
+	if allPackages, err = sync.CheckTargetNames(logger, allPackages); err != nil {
+		pterm.Error.Println(fmt.Sprintf("❌ Migrate stopped: %v", err))
+		return err
+	}
+	if err := sync.ScopeNpmPackages(logger, owner, allPackages); err != nil {
+		pterm.Error.Println(fmt.Sprintf("❌ Migrate stopped: %v", err))
+		return err
+	}
+	if err := sync.CheckStorageBudget(logger, allPackages); err != nil {
+		pterm.Error.Println(fmt.Sprintf("❌ Migrate stopped: %v", err))
+		return err
+	}
+
+	report, err = common.ProcessPackages(logger, "Migrate", allPackages, Transfer, true)
+	if errors.Is(err, common.ErrFailFast) {
+		pterm.Error.Println(fmt.Sprintf("Migrate stopped: %v", err))
+		report.PrintFailures()
+		return err
+	}
+	if err != nil {
+		pterm.Error.Println(fmt.Sprintf("Error migrating package: %v", err))
+		return err
+	}
+	if report.PackageSuccess == 0 && report.PackagesFailed > 0 {
+		pterm.Error.Println("No packages were migrated")
+	} else if report.PackagesFailed > 0 {
+		pterm.Warning.Println("Migrate completed with some errors, Please check the logs for more details")
+	} else {
+		pterm.Success.Println("Migrate completed")
+	}
+
+	// Calculate duration
+	duration := time.Since(startTime)
+	hours := int(duration.Hours())
+	minutes := int(duration.Minutes()) % 60
+	seconds := int(duration.Seconds()) % 60
+
+	fmt.Println("\n📊 Migrate Summary:")
+	fmt.Printf("✅ Successfully processed: %d packages\n", report.PackageSuccess)
+	fmt.Printf("⏭️ Skipped: %d packages\n", report.PackagesSkipped)
+	fmt.Printf("❌ Failed: %d packages\n", report.PackagesFailed)
+
+	for _, pkgType := range common.SUPPORTED_PACKAGE_TYPES {
+		if count := len(packageStats[pkgType]); count > 0 {
+			fmt.Printf("  📦 %s: %d\n", pkgType, count)
+		}
+	}
+
+	if report.SourceVersionsDeleted > 0 {
+		fmt.Printf("🗑️ Deleted from source: %d versions\n", report.SourceVersionsDeleted)
+	}
+	if report.TargetVersionsReplaced > 0 {
+		fmt.Printf("♻️ Replaced in the target: %d versions\n", report.TargetVersionsReplaced)
+	}
+	if published := sync.PublishMavenMetadata(logger, allPackages); published > 0 {
+		fmt.Printf("🧭 Regenerated maven-metadata.xml: %d artifacts\n", published)
+	}
+	if differing := sync.CheckMetadata(logger, owner, packageStats); differing > 0 {
+		fmt.Printf("🏷️ Metadata differs from the source: %d packages\n", differing)
+	}
+	report.PrintFailures()
+	report.PrintSourceMissing()
+	report.PrintTooLarge()
+	report.PrintPerformance(duration)
+	fmt.Printf("🕐 Total time: %dh %dm %ds\n\n", hours, minutes, seconds)
+	fmt.Println("✅ Migrate completed successfully!")
+
+	return nil
+}
@@ -0,0 +1,43 @@
+// Package providers lets tools embedding the migration compile in their own
+// package providers, e.g. to publish npm packages to an internal registry
+// flavor, without changing this module
+package providers
+
+import (
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+)
+
+// Provider lists, downloads and publishes the files of one package type
+type Provider = providers.Provider
+
+// BaseProvider holds the registry URLs shared by providers, embed it to get
+// default Export and GetPackageType implementations
+type BaseProvider = providers.BaseProvider
+
+// ResultState is the outcome of a provider operation
+type ResultState = providers.ResultState
+
+const (
+	Success = providers.Success
+	Skipped = providers.Skipped
+	Failed  = providers.Failed
+)
+
+// Factory creates the provider for a package type
+type Factory = providers.Factory
+
+// NewBaseProvider resolves the source and target registry URLs for a package
+// type from the source and target hostnames
+var NewBaseProvider = providers.NewBaseProvider
+
+// Register sets the provider used for a package type. Registering a built-in
+// type replaces its provider, any other name becomes a supported package
+// type. Call it before running a phase, typically from an init function.
+func Register(packageType string, factory Factory) {
+	providers.Register(packageType, factory)
+	if !utils.Contains(common.SUPPORTED_PACKAGE_TYPES, packageType) {
+		common.SUPPORTED_PACKAGE_TYPES = append(common.SUPPORTED_PACKAGE_TYPES, packageType)
+	}
+}
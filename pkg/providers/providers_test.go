@@ -0,0 +1,40 @@
+package providers
+
+import (
+	"testing"
+
+	internal "github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"go.uber.org/zap"
+)
+
+type customProvider struct {
+	internal.NPMProvider
+}
+
+func TestRegister(t *testing.T) {
+	Register("artifactory-npm", func(logger *zap.Logger, packageType string) Provider {
+		return &customProvider{}
+	})
+
+	provider, err := internal.NewProvider(zap.NewNop(), "artifactory-npm")
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	if _, ok := provider.(*customProvider); !ok {
+		t.Errorf("provider = %T, want *customProvider", provider)
+	}
+	if !utils.Contains(common.SUPPORTED_PACKAGE_TYPES, "artifactory-npm") {
+		t.Errorf("artifactory-npm missing from supported package types %v", common.SUPPORTED_PACKAGE_TYPES)
+	}
+
+	// Registering again must not list the type twice
+	before := len(common.SUPPORTED_PACKAGE_TYPES)
+	Register("artifactory-npm", func(logger *zap.Logger, packageType string) Provider {
+		return &customProvider{}
+	})
+	if len(common.SUPPORTED_PACKAGE_TYPES) != before {
+		t.Errorf("supported package types grew on re-registration")
+	}
+}
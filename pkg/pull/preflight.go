@@ -0,0 +1,132 @@
+package pull
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/storage"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// estimateDownloadSize sums the advertised size of every file that has not
+// been downloaded yet. Files whose size cannot be determined (e.g. container
+// images) are counted separately.
+func estimateDownloadSize(logger *zap.Logger, packages [][]string) (total int64, largest int64, unknown int, err error) {
+	owner := viper.GetString("GHMPKG_SOURCE_ORGANIZATION")
+	token := viper.GetString("GHMPKG_SOURCE_TOKEN")
+	providerCache := make(map[string]providers.Provider)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 10)
+
+	for _, pkg := range packages {
+		repository, packageType, packageName, version, filename := pkg[1], pkg[2], pkg[3], pkg[4], pkg[5]
+
+		if packageType == "container" {
+			unknown++
+			continue
+		}
+		if isDownloaded(owner, packageType, packageName, version, filename) {
+			continue
+		}
+		// Sizes recorded by the export save a request per file
+		if size, ok := common.ExportedSize(pkg); ok {
+			mu.Lock()
+			total += size
+			largest = max(largest, size)
+			mu.Unlock()
+			continue
+		}
+
+		provider, ok := providerCache[packageType]
+		if !ok {
+			provider, err = providers.NewProvider(logger, packageType)
+			if err != nil {
+				return 0, 0, 0, err
+			}
+			providerCache[packageType] = provider
+		}
+
+		downloadUrl, err := provider.GetDownloadUrl(logger, owner, repository, packageName, version, filename)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+
+		wg.Add(1)
+		go func(downloadUrl string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			size, err := utils.ContentLength(downloadUrl, token)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil || size < 0 {
+				logger.Warn("Unable to determine file size", zap.String("url", downloadUrl), zap.Error(err))
+				unknown++
+				return
+			}
+			total += size
+			if size > largest {
+				largest = size
+			}
+		}(downloadUrl)
+	}
+	wg.Wait()
+
+	return total, largest, unknown, nil
+}
+
+// isDownloaded reports whether a file is already present locally. npm
+// tarballs are stored as <name>-<version>.tgz rather than the registry name.
+func isDownloaded(owner, packageType, packageName, version, filename string) bool {
+	versionDir := filepath.Join(storage.PackagesRoot, owner, packageType, packageName, version)
+	if utils.FileExists(filepath.Join(versionDir, filename)) {
+		return true
+	}
+	if packageType == "npm" {
+		return utils.FileExists(filepath.Join(versionDir, fmt.Sprintf("%s-%s.tgz", packageName, version)))
+	}
+	return false
+}
+
+// checkDiskSpace refuses to start a pull that would not fit on disk. With a
+// remote storage backend only the largest file needs to fit at a time.
+func checkDiskSpace(logger *zap.Logger, packages [][]string) error {
+	spinner, _ := pterm.DefaultSpinner.Start("Estimating download size...")
+
+	total, largest, unknown, err := estimateDownloadSize(logger, packages)
+	if err != nil {
+		spinner.Fail(fmt.Sprintf("Error estimating download size: %v", err))
+		return err
+	}
+
+	required := total
+	if backend := viper.GetString("GHMPKG_STORAGE_BACKEND"); backend != "" && backend != "local" {
+		required = largest
+	}
+
+	logger.Info("Estimated download size",
+		zap.Int64("totalBytes", total),
+		zap.Int64("largestBytes", largest),
+		zap.Int64("requiredBytes", required),
+		zap.Int("unknownFiles", unknown))
+
+	if err := utils.CheckFreeSpace(storage.PackagesRoot, required); err != nil {
+		spinner.Fail(fmt.Sprintf("Not enough disk space for %s of downloads: %v", utils.FormatBytes(total), err))
+		return err
+	}
+
+	spinner.Success(fmt.Sprintf("Estimated download size: %s", utils.FormatBytes(total)))
+	if unknown > 0 {
+		pterm.Warning.Printf("Size of %d file(s) could not be determined and is not included in the estimate\n", unknown)
+	}
+	return nil
+}
@@ -1,14 +1,17 @@
 package pull
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/mark-humane/gh-migrate-packages/internal/files"
+	"github.com/mark-humane/gh-migrate-packages/internal/hooks"
+	"github.com/mark-humane/gh-migrate-packages/internal/metrics"
 	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
 	"github.com/mark-humane/gh-migrate-packages/internal/utils"
 	"github.com/mark-humane/gh-migrate-packages/pkg/common"
 	"github.com/pterm/pterm"
@@ -23,32 +26,60 @@ func Download(logger *zap.Logger, provider providers.Provider, report *common.Re
 	zapFields := []zap.Field{
 		zap.String("owner", owner),
 		zap.String("repository", repository),
-		zap.String("packageType", packageType),
-		zap.String("packageName", packageName),
-		zap.String("version", version),
 	}
 
 	logger.Info("Download function entry",
-		zap.String("packageType", packageType),
-		zap.String("packageName", packageName),
-		zap.String("version", version),
 		zap.Strings("filenames", filenames),
 		zap.String("owner", owner),
 		zap.String("repository", repository))
 
 	// Add provider type logging
 	logger.Info("Using provider",
-		zap.String("packageType", packageType),
 		zap.String("providerType", fmt.Sprintf("%T", provider)))
 
 	pterm.Info.Println(fmt.Sprintf("📦 package: %s", packageName))
 	pterm.Info.Println(fmt.Sprintf("🗃️ version: %s", version))
 
+	// Packages exported from another registry are downloaded from there
+	source, err := registries.NewSource()
+	if err != nil {
+		return err
+	}
+	provider = providers.WithSource(provider, source)
+
+	if err := hooks.Run(logger, hooks.Event{
+		Hook:               hooks.PreDownload,
+		SourceOrganization: owner,
+		Repository:         repository,
+		PackageType:        packageType,
+		PackageName:        packageName,
+		PackageVersion:     version,
+		Files:              filenames,
+	}); err != nil {
+		logger.Error("Pre-download hook failed", append(zapFields, zap.Error(err))...)
+		pterm.Error.Println(fmt.Sprintf("❌ Pre-download hook rejected %s@%s", packageName, version))
+		return err
+	}
+
+	// Files of versions deleted from the source are recorded as missing,
+	// they don't fail the version
+	recordMissing := func(filename string, err error, elapsed time.Duration) {
+		logger.Warn("File no longer exists in the source", append(zapFields,
+			zap.String("filename", filename),
+			zap.Error(err))...)
+		pterm.Warning.Println(fmt.Sprintf("🕳️ Missing from the source: %s", filename))
+		report.RecordTransfer(filename, providers.SourceMissing, err, elapsed)
+	}
+
 	// Create error channel to collect errors from workers
 	errChan := make(chan error, len(filenames))
 
 	// Create semaphore channel for concurrency control
-	sem := make(chan struct{}, 5)
+	concurrency := viper.GetInt("GHMPKG_CONCURRENCY")
+	if concurrency < 1 {
+		concurrency = 5
+	}
+	sem := make(chan struct{}, concurrency)
 
 	// Create wait group to track when all downloads are complete
 	var wg sync.WaitGroup
@@ -65,63 +96,62 @@ func Download(logger *zap.Logger, provider providers.Provider, report *common.Re
 				// Release semaphore
 				<-sem
 			}()
+			defer metrics.TrackInFlight("download")()
 
 			logger.Info("Starting download for file",
-				zap.String("packageType", packageType),
-				zap.String("packageName", packageName),
-				zap.String("version", version),
 				zap.String("filename", filename))
 
 			if packageType == "container" {
 				// Extract semantic version from filename for containers
 				semanticVersion := strings.Split(filename, ":")[1]
 				logger.Info("Processing container package",
-					zap.String("packageName", packageName),
-					zap.String("version", version),
 					zap.String("semanticVersion", semanticVersion),
 					zap.String("filename", filename),
 					zap.String("owner", owner),
 					zap.String("repository", repository))
 
-				if result, err := provider.Download(logger, owner, repository, packageType, packageName, semanticVersion, filename); err != nil {
+				start := time.Now()
+				result, err := provider.Download(logger, owner, repository, packageType, packageName, semanticVersion, filename)
+				if errors.Is(err, utils.ErrNotFound) {
+					recordMissing(filename, err, time.Since(start))
+				} else if err != nil {
 					logger.Error("Failed to download package", append(zapFields,
 						zap.String("filename", filename),
 						zap.String("semanticVersion", semanticVersion),
 						zap.Error(err))...)
 					pterm.Error.Println(fmt.Sprintf("    ❌ Failed to download: %s", filename))
+					report.RecordTransfer(filename, providers.Failed, err, time.Since(start))
 					errChan <- fmt.Errorf("failed to download %s: %w", filename, err)
 				} else {
 					logger.Info("Download result",
-						zap.String("packageName", packageName),
-						zap.String("version", semanticVersion),
+						zap.String("semanticVersion", semanticVersion),
 						zap.String("filename", filename),
 						zap.Any("result", result))
-					report.IncFiles(result)
+					report.RecordTransfer(filename, result, nil, time.Since(start))
 					if result == providers.Success {
 						pterm.Success.Println(fmt.Sprintf("✅ %s", filename))
 					}
 				}
 			} else {
 				logger.Info("Attempting non-container download",
-					zap.String("packageType", packageType),
-					zap.String("packageName", packageName),
-					zap.String("version", version),
 					zap.String("filename", filename))
 
+				start := time.Now()
 				result, err := provider.Download(logger, owner, repository, packageType, packageName, version, filename)
-				if err != nil {
+				if errors.Is(err, utils.ErrNotFound) {
+					recordMissing(filename, err, time.Since(start))
+				} else if err != nil {
 					logger.Error("Failed to download package", append(zapFields,
 						zap.String("filename", filename),
 						zap.Error(err))...)
 					pterm.Error.Println(fmt.Sprintf("❌ Failed to download: %s", filename))
+					report.RecordTransfer(filename, providers.Failed, err, time.Since(start))
 					errChan <- fmt.Errorf("failed to download %s: %w", filename, err)
 				} else {
 					logger.Info("Download completed",
-						zap.String("packageName", packageName),
-						zap.String("version", version),
 						zap.String("filename", filename),
 						zap.Any("result", result))
-					report.IncFiles(result)
+					report.RecordTransfer(filename, result, nil, time.Since(start))
 					if result == providers.Success {
 						pterm.Success.Println(fmt.Sprintf("✅ %s", filename))
 					}
@@ -136,10 +166,19 @@ func Download(logger *zap.Logger, provider providers.Provider, report *common.Re
 
 	// Check for any errors
 	var errs []string
+	var lowSpaceErr error
 	for err := range errChan {
+		if errors.Is(err, utils.ErrLowDiskSpace) {
+			lowSpaceErr = err
+		}
 		errs = append(errs, err.Error())
 	}
 
+	// Low disk space stops the whole run, not just this version
+	if lowSpaceErr != nil {
+		return lowSpaceErr
+	}
+
 	if len(errs) > 0 {
 		return fmt.Errorf("download errors: %s", strings.Join(errs, "; "))
 	}
@@ -147,8 +186,12 @@ func Download(logger *zap.Logger, provider providers.Provider, report *common.Re
 	return nil
 }
 
-func Pull(logger *zap.Logger) error {
+func Pull(logger *zap.Logger) (err error) {
+	logger = logger.With(zap.String("phase", "pull"))
 	startTime := time.Now()
+	var report *common.Report
+	phase := common.StartPhase(logger, "pull")
+	defer func() { phase.Finish(logger, report, err) }()
 	owner := viper.GetString("GHMPKG_SOURCE_ORGANIZATION")
 	desiredPackageType := viper.GetString("GHMPKG_PACKAGE_TYPES")
 
@@ -166,86 +209,23 @@ func Pull(logger *zap.Logger) error {
 	}
 
 	// Handle either specific package type or all package types
-	packageTypes := SUPPORTED_PACKAGE_TYPES
+	packageTypes := common.SUPPORTED_PACKAGE_TYPES
 	if desiredPackageType != "" {
-		if !utils.Contains(SUPPORTED_PACKAGE_TYPES, desiredPackageType) {
+		if !utils.Contains(common.SUPPORTED_PACKAGE_TYPES, desiredPackageType) {
 			spinner.Fail(fmt.Sprintf("Unsupported package type: %s", desiredPackageType))
 			return fmt.Errorf("unsupported package type: %s", desiredPackageType)
 		}
 		packageTypes = []string{desiredPackageType}
 	}
 
-	var allPackages [][]string
-	packageStats := make(map[string][]string)
-
-	for _, pkgType := range packageTypes {
-		logger.Info("Processing package type", zap.String("type", pkgType))
-		pterm.Info.Println(fmt.Sprintf("Processing %s packages...", pkgType))
-
-		// Check if package type directory exists
-		pkgTypeDir := fmt.Sprintf("./migration-packages/export/%s", pkgType)
-		if _, err := os.Stat(pkgTypeDir); os.IsNotExist(err) {
-			logger.Warn("Package type directory not found",
-				zap.String("packageType", pkgType),
-				zap.String("directory", pkgTypeDir))
-			continue
-		}
-
-		// Look for the most recent CSV file in the package type directory
-		pattern := fmt.Sprintf("./migration-packages/export/%s/*_%s_%s_packages.csv", pkgType, owner, pkgType)
-		logger.Info("Searching for CSV with pattern", zap.String("pattern", pattern))
-
-		matches, err := utils.FindMostRecentFile(pattern)
-		if err != nil {
-			// Try alternate pattern without owner in filename
-			altPattern := fmt.Sprintf("./migration-packages/export/%s/*_%s_packages.csv", pkgType, pkgType)
-			logger.Info("Trying alternate pattern",
-				zap.String("altPattern", altPattern))
-
-			matches, err = utils.FindMostRecentFile(altPattern)
-			if err != nil {
-				logger.Warn("No export file found for package type",
-					zap.String("packageType", pkgType),
-					zap.Error(err))
-				continue
-			}
-		}
-
-		logger.Info("Found CSV file",
-			zap.String("packageType", pkgType),
-			zap.String("file", matches))
-
-		packages, err := files.ReadCSV(matches)
-		if err != nil {
-			spinner.Fail(fmt.Sprintf("Error reading CSV file for %s: %v", pkgType, err))
-			return err
-		}
-
-		// Log the content of the first few rows to verify data
-		logger.Info("CSV content sample",
-			zap.String("packageType", pkgType),
-			zap.Int("totalRows", len(packages)),
-			zap.Any("firstRows", packages[:min(len(packages), 3)]))
-
-		if len(packages) <= 1 {
-			pterm.Warning.Println(fmt.Sprintf("No package data found in CSV for %s", pkgType))
-			continue
-		}
-
-		allPackages = append(allPackages, packages[1:]...)
-		for _, pkg := range packages[1:] {
-			if _, ok := packageStats[pkgType]; ok {
-				if utils.Contains(packageStats[pkgType], pkg[3]) {
-					continue
-				} else {
-					packageStats[pkgType] = append(packageStats[pkgType], pkg[3])
-				}
-			} else {
-				packageStats[pkgType] = []string{pkg[3]}
-			}
-		}
-
-		pterm.Info.Println(fmt.Sprintf("Found %d packages in CSV for %s", len(packageStats[pkgType]), pkgType))
+	allPackages, packageStats, err := common.LoadExportedPackages(logger, owner, packageTypes)
+	if err != nil {
+		spinner.Fail(err.Error())
+		return err
+	}
+	if allPackages, err = common.StartAfter(logger, allPackages); err != nil {
+		spinner.Fail(err.Error())
+		return err
 	}
 
 	// Debug logging before processing
@@ -257,9 +237,9 @@ func Pull(logger *zap.Logger) error {
 			zap.Int("index", i),
 			zap.String("org", pkg[0]),
 			zap.String("repo", pkg[1]),
-			zap.String("type", pkg[2]),
-			zap.String("name", pkg[3]),
-			zap.String("version", pkg[4]),
+			zap.String("package_type", pkg[2]),
+			zap.String("package_name", pkg[3]),
+			zap.String("package_version", pkg[4]),
 			zap.String("filename", pkg[5]))
 	}
 
@@ -268,13 +248,32 @@ func Pull(logger *zap.Logger) error {
 		return fmt.Errorf("no package export files found")
 	}
 
-	report, err := common.ProcessPackages(logger, allPackages, Download, false)
+	if !viper.GetBool("GHMPKG_SKIP_SPACE_CHECK") {
+		if err := checkDiskSpace(logger, allPackages); err != nil {
+			spinner.Fail("Not enough disk space to pull packages")
+			return err
+		}
+	}
+
+	common.StopSpinner(spinner)
+
+	report, err = common.ProcessPackages(logger, "Pull", allPackages, Download, false)
+	if errors.Is(err, common.ErrFailFast) {
+		pterm.Error.Println(fmt.Sprintf("Pull stopped: %v", err))
+		report.PrintFailures()
+		return err
+	}
+	if errors.Is(err, utils.ErrLowDiskSpace) {
+		pterm.Warning.Println("Pull stopped early, disk space is running low")
+		pterm.Warning.Println("Free up disk space and run pull again, files already downloaded are skipped.")
+		return err
+	}
 	if err != nil {
-		spinner.Fail(fmt.Sprintf("Error pulling package: %v", err))
+		pterm.Error.Println(fmt.Sprintf("Error pulling package: %v", err))
 		return err
 	}
 
-	spinner.Success("Pull completed")
+	pterm.Success.Println("Pull completed")
 
 	// Calculate duration
 	duration := time.Since(startTime)
@@ -287,7 +286,7 @@ func Pull(logger *zap.Logger) error {
 	fmt.Printf("✅ Successfully processed: %d packages\n", report.PackageSuccess)
 	fmt.Printf("❌ Failed: %d packages\n", report.PackagesFailed)
 
-	for _, pkgType := range SUPPORTED_PACKAGE_TYPES {
+	for _, pkgType := range common.SUPPORTED_PACKAGE_TYPES {
 		if count := len(packageStats[pkgType]); count > 0 {
 			emoji := "📦"
 			name := pkgType
@@ -296,16 +295,11 @@ func Pull(logger *zap.Logger) error {
 	}
 
 	fmt.Println("📁 Output directory: migration-packages/packages")
+	report.PrintFailures()
+	report.PrintSourceMissing()
+	report.PrintPerformance(duration)
 	fmt.Printf("🕐 Total time: %dh %dm %ds\n\n", hours, minutes, seconds)
 	fmt.Println("✅ Pull completed successfully!")
 
 	return nil
 }
-
-// Helper function for min
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
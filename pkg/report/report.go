@@ -0,0 +1,235 @@
+package report
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/failures"
+	"github.com/mark-humane/gh-migrate-packages/internal/results"
+	"github.com/mark-humane/gh-migrate-packages/internal/storage"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/pterm/pterm"
+	"go.uber.org/zap"
+)
+
+// Dir is where reports are written by default
+const Dir = "migration-packages/reports"
+
+// largestPackages is how many packages the size chart shows
+const largestPackages = 15
+
+//go:embed report.html.tmpl
+var reportTemplate string
+
+type typeRow struct {
+	PackageType string
+	Summary     *results.TypeSummary
+}
+
+type failureGroup struct {
+	Class string
+	Error string
+	Rows  []results.Row
+}
+
+type run struct {
+	State      *results.State
+	Status     string
+	Elapsed    string
+	Types      []typeRow
+	Failures   []failureGroup
+	FailedRows int
+	// Missing are the files the source lists but no longer serves
+	Missing []results.Row
+	// TooLarge are the files over the maximum file size, not published
+	TooLarge []results.Row
+}
+
+type packageSize struct {
+	PackageType string
+	PackageName string
+	Size        int64
+	Percent     float64
+}
+
+type reportData struct {
+	GeneratedAt time.Time
+	Runs        []run
+	Largest     []packageSize
+}
+
+// Generate writes a standalone HTML report of the latest runs, or of a
+// single run when statePath is set, and returns the path it was written to
+func Generate(logger *zap.Logger, phase, statePath, output string) (string, error) {
+	var states []*results.State
+	if statePath != "" {
+		state, err := results.LoadState(statePath)
+		if err != nil {
+			return "", err
+		}
+		states = []*results.State{state}
+	} else {
+		var err error
+		if states, err = results.LatestStates(logger, results.Dir, phase); err != nil {
+			return "", err
+		}
+	}
+	if len(states) == 0 {
+		return "", fmt.Errorf("no runs found in %s", results.Dir)
+	}
+
+	data := reportData{GeneratedAt: time.Now()}
+	packages := make(map[string]packageSize)
+	for _, state := range states {
+		rows, err := results.Read(state.ResultsFile)
+		if err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to read results file %s: %w", state.ResultsFile, err)
+		}
+		rows = results.Latest(rows)
+		data.Runs = append(data.Runs, buildRun(state, rows))
+
+		for _, row := range rows {
+			key := row.Organization + "/" + row.PackageType + "/" + row.PackageName
+			if _, ok := packages[key]; !ok {
+				packages[key] = packageSize{
+					PackageType: row.PackageType,
+					PackageName: row.PackageName,
+					Size:        localSize(row.Organization, row.PackageType, row.PackageName),
+				}
+			}
+		}
+	}
+	data.Largest = largest(packages)
+
+	if output == "" {
+		output = filepath.Join(Dir, fmt.Sprintf("%s_report.html", data.GeneratedAt.Format("2006-01-02_15-04-05")))
+	}
+	if err := utils.EnsureDirExists(output); err != nil {
+		return "", err
+	}
+
+	tmpl, err := template.New("report").Funcs(template.FuncMap{
+		"bytes": utils.FormatBytes,
+		"time":  func(t time.Time) string { return t.Format(time.RFC1123) },
+	}).Parse(reportTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if err := tmpl.Execute(file, data); err != nil {
+		return "", fmt.Errorf("failed to render report: %w", err)
+	}
+
+	logger.Info("Report written", zap.String("file", output), zap.Int("runs", len(data.Runs)))
+	pterm.Success.Printf("✅ Report written to %s\n", output)
+	return output, nil
+}
+
+func buildRun(state *results.State, rows []results.Row) run {
+	r := run{State: state, Status: "Finished"}
+	if state.Running() {
+		r.Status = "In progress"
+	} else if state.Error != "" {
+		r.Status = "Stopped: " + state.Error
+	}
+	elapsed := state.Elapsed()
+	r.Elapsed = fmt.Sprintf("%dh %dm %ds", int(elapsed.Hours()), int(elapsed.Minutes())%60, int(elapsed.Seconds())%60)
+
+	summaries := results.Summarize(state.Totals, rows)
+	for packageType, summary := range summaries {
+		r.Types = append(r.Types, typeRow{PackageType: packageType, Summary: summary})
+	}
+	sort.Slice(r.Types, func(i, j int) bool { return r.Types[i].PackageType < r.Types[j].PackageType })
+
+	groups := make(map[string]*failureGroup)
+	for _, row := range rows {
+		switch row.State {
+		case "SourceMissing":
+			r.Missing = append(r.Missing, row)
+		case "TooLarge":
+			r.TooLarge = append(r.TooLarge, row)
+		}
+		if row.State != "Failed" {
+			continue
+		}
+		message := strings.TrimSpace(row.Error)
+		if message == "" {
+			message = "(no error message)"
+		}
+		// Results written before failures were classified have no class
+		class := row.ErrorClass
+		if class == "" {
+			class = failures.Unknown
+		}
+		key := class + "\x00" + message
+		if groups[key] == nil {
+			groups[key] = &failureGroup{Class: class, Error: message}
+		}
+		groups[key].Rows = append(groups[key].Rows, row)
+		r.FailedRows++
+	}
+	for _, group := range groups {
+		r.Failures = append(r.Failures, *group)
+	}
+	// Most common failures first
+	sort.Slice(r.Failures, func(i, j int) bool {
+		if len(r.Failures[i].Rows) != len(r.Failures[j].Rows) {
+			return len(r.Failures[i].Rows) > len(r.Failures[j].Rows)
+		}
+		if r.Failures[i].Class != r.Failures[j].Class {
+			return r.Failures[i].Class < r.Failures[j].Class
+		}
+		return r.Failures[i].Error < r.Failures[j].Error
+	})
+	return r
+}
+
+// localSize sums the size of the pulled files of a package, packages that
+// are not on local disk count as zero
+func localSize(owner, packageType, packageName string) int64 {
+	if packageType == "container" {
+		owner = strings.ToLower(owner)
+		packageName = strings.ToLower(packageName)
+	}
+	var size int64
+	root := filepath.Join(storage.PackagesRoot, owner, packageType, packageName)
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func largest(packages map[string]packageSize) []packageSize {
+	var sizes []packageSize
+	for _, size := range packages {
+		if size.Size > 0 {
+			sizes = append(sizes, size)
+		}
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i].Size > sizes[j].Size })
+	if len(sizes) > largestPackages {
+		sizes = sizes[:largestPackages]
+	}
+	for i := range sizes {
+		sizes[i].Percent = float64(sizes[i].Size) * 100 / float64(sizes[0].Size)
+	}
+	return sizes
+}
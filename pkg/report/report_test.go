@@ -0,0 +1,172 @@
+package report
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/results"
+	"github.com/mark-humane/gh-migrate-packages/internal/storage"
+	"go.uber.org/zap"
+)
+
+var fixtureRows = []results.Row{
+	{Organization: "acme", PackageType: "npm", PackageName: "ui", Version: "1.0.0", Filename: "ui-1.0.0.tgz", State: "Failed", Error: "timeout", ErrorClass: "network"},
+	{Organization: "acme", PackageType: "npm", PackageName: "ui", Version: "1.0.0", Filename: "ui-1.0.0.tgz", State: "Success"},
+	{Organization: "acme", PackageType: "npm", PackageName: "ui", Version: "1.1.0", Filename: "ui-1.1.0.tgz", State: "Failed", Error: "403 Forbidden", ErrorClass: "auth"},
+	{Organization: "acme", PackageType: "npm", PackageName: "core", Version: "2.0.0", Filename: "core-2.0.0.tgz", State: "Failed", Error: "403 Forbidden", ErrorClass: "auth"},
+	{Organization: "acme", PackageType: "maven", PackageName: "com.acme.lib", Version: "1.0", Filename: "lib-1.0.jar", State: "Success"},
+	{Organization: "acme", PackageType: "maven", PackageName: "com.acme.lib", Version: "1.0", Filename: "lib-1.0.pom", State: "SourceMissing", Error: "404 Not Found"},
+	{Organization: "acme", PackageType: "maven", PackageName: "com.acme.big", Version: "1.0", Filename: "big-1.0.jar", State: "TooLarge", Error: "over 2 GB"},
+}
+
+// writeFixture writes a sync run of acme to the results directory below the
+// current directory and returns the path of its state file
+func writeFixture(t *testing.T) string {
+	t.Helper()
+	state := results.State{
+		RunID:        "run-1",
+		Phase:        "sync",
+		Organization: "acme",
+		StartedAt:    time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC),
+		ResultsFile:  filepath.Join(results.Dir, "2026-01-02_03-00-00_acme_run_sync_results.csv"),
+		Totals: map[string]results.Totals{
+			"npm":   {Packages: 3, Versions: 4, Files: 4},
+			"maven": {Packages: 2, Versions: 2, Files: 3},
+		},
+	}
+	finished := state.StartedAt.Add(90 * time.Minute)
+	state.FinishedAt = &finished
+
+	writer, err := results.Create(state.ResultsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range fixtureRows {
+		if err := writer.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(results.Dir, "2026-01-02_03-00-00_acme_run_sync_state.json")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBuildRun(t *testing.T) {
+	finished := time.Date(2026, 1, 2, 4, 30, 5, 0, time.UTC)
+	state := &results.State{
+		StartedAt:  time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC),
+		FinishedAt: &finished,
+		Error:      "interrupted",
+		Totals:     map[string]results.Totals{"npm": {Packages: 3, Versions: 4, Files: 4}},
+	}
+	r := buildRun(state, results.Latest(fixtureRows))
+
+	if r.Status != "Stopped: interrupted" || r.Elapsed != "1h 30m 5s" {
+		t.Errorf("status = %q, elapsed = %q", r.Status, r.Elapsed)
+	}
+	if len(r.Types) != 2 || r.Types[0].PackageType != "maven" || r.Types[1].PackageType != "npm" {
+		t.Fatalf("types = %+v, want maven and npm in order", r.Types)
+	}
+	if npm := r.Types[1].Summary; npm.Versions != (results.Counts{Success: 1, Failed: 2, Pending: 1}) {
+		t.Errorf("npm versions = %+v", npm.Versions)
+	}
+	if r.FailedRows != 2 || len(r.Failures) != 1 || r.Failures[0].Class != "auth" || len(r.Failures[0].Rows) != 2 {
+		t.Errorf("failures = %+v, want the retried timeout left out and both 403s grouped", r.Failures)
+	}
+	if len(r.Missing) != 1 || r.Missing[0].Filename != "lib-1.0.pom" {
+		t.Errorf("missing = %+v", r.Missing)
+	}
+	if len(r.TooLarge) != 1 || r.TooLarge[0].Filename != "big-1.0.jar" {
+		t.Errorf("too large = %+v", r.TooLarge)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	statePath := writeFixture(t)
+	for path, size := range map[string]int{
+		filepath.Join(storage.PackagesRoot, "acme", "npm", "ui", "1.0.0", "ui-1.0.0.tgz"):          400,
+		filepath.Join(storage.PackagesRoot, "acme", "maven", "com.acme.lib", "1.0", "lib-1.0.jar"): 100,
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output, err := Generate(zap.NewNop(), "", statePath, filepath.Join("out", "report.html"))
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cells are compared without the whitespace of the template
+	html := regexp.MustCompile(`>\s+<`).ReplaceAllString(string(content), "><")
+
+	for _, want := range []string{
+		"<h2>sync: acme</h2>",
+		"Run run-1.",
+		"elapsed 1h 30m 0s.",
+		"Status: Finished.",
+		// Type, then succeeded, skipped, failed and pending packages,
+		// versions and files
+		`<td>maven</td><td class="num success">0</td><td class="num">2</td><td class="num failed">0</td><td class="num">0</td>` +
+			`<td class="num success">0</td><td class="num">2</td><td class="num failed">0</td><td class="num">0</td>` +
+			`<td class="num success">1</td><td class="num">2</td><td class="num failed">0</td><td class="num">0</td>`,
+		`<td>npm</td><td class="num success">0</td><td class="num">0</td><td class="num failed">2</td><td class="num">1</td>` +
+			`<td class="num success">1</td><td class="num">0</td><td class="num failed">2</td><td class="num">1</td>` +
+			`<td class="num success">1</td><td class="num">0</td><td class="num failed">2</td><td class="num">1</td>`,
+		"<h3>Failures (2 files)</h3>",
+		`<strong>2</strong> &times; <span class="failed">[auth]</span><code>403 Forbidden</code>`,
+		"<td>npm</td><td>ui</td><td>1.1.0</td><td>ui-1.1.0.tgz</td>",
+		"<td>npm</td><td>core</td><td>2.0.0</td><td>core-2.0.0.tgz</td>",
+		"<h3>Missing from the source (1 files)</h3>",
+		"<td>maven</td><td>com.acme.lib</td><td>1.0</td><td>lib-1.0.pom</td><td><code>404 Not Found</code></td>",
+		"<h3>Over the maximum file size (1 files)</h3>",
+		"<td>maven</td><td>com.acme.big</td><td>1.0</td><td>big-1.0.jar</td><td><code>over 2 GB</code></td>",
+		`<td>npm</td><td>ui</td><td class="num">400 B</td><td><div class="bar" style="width: 100.0%">`,
+		`<td>maven</td><td>com.acme.lib</td><td class="num">100 B</td><td><div class="bar" style="width: 25.0%">`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("report is missing %s", want)
+		}
+	}
+	if strings.Contains(html, "timeout") {
+		t.Error("report lists a failure that a later row retried successfully")
+	}
+}
+
+func TestGenerateWithoutRuns(t *testing.T) {
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	if _, err := Generate(zap.NewNop(), "sync", "", ""); err == nil {
+		t.Error("Generate() without runs succeeded")
+	}
+}
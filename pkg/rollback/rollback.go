@@ -0,0 +1,189 @@
+package rollback
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/api"
+	"github.com/mark-humane/gh-migrate-packages/internal/audit"
+	"github.com/mark-humane/gh-migrate-packages/internal/failures"
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/internal/results"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Version is a package version recorded in a results file
+type Version struct {
+	Organization string
+	Repository   string
+	PackageType  string
+	PackageName  string
+	Version      string
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%s %s@%s", v.PackageType, v.PackageName, v.Version)
+}
+
+// Versions returns the versions a run published. Versions with files that
+// already existed in the target were only completed by the run, they are
+// returned as partial and never deleted.
+func Versions(rows []results.Row) (published, partial []Version) {
+	type outcome struct {
+		version  Version
+		uploaded bool
+		existed  bool
+	}
+	var order []string
+	outcomes := map[string]*outcome{}
+	for _, row := range results.Latest(rows) {
+		key := strings.Join([]string{row.Organization, row.PackageType, row.PackageName, row.Version}, "\x00")
+		o, ok := outcomes[key]
+		if !ok {
+			o = &outcome{version: Version{row.Organization, row.Repository, row.PackageType, row.PackageName, row.Version}}
+			outcomes[key] = o
+			order = append(order, key)
+		}
+		switch row.State {
+		case "Success":
+			o.uploaded = true
+		case "Skipped":
+			o.existed = true
+		}
+	}
+	for _, key := range order {
+		switch o := outcomes[key]; {
+		case o.uploaded && o.existed:
+			partial = append(partial, o.version)
+		case o.uploaded:
+			published = append(published, o.version)
+		}
+	}
+	return published, partial
+}
+
+// Summary counts what a rollback did
+type Summary struct {
+	Deleted int
+	Missing int
+	Partial int
+	Failed  int
+}
+
+// Run deletes from the target organization the package versions published
+// by the run of a state or results file. Nothing is deleted unless confirm
+// is set, the versions that would be deleted are listed instead.
+func Run(logger *zap.Logger, path string, confirm bool) (*Summary, error) {
+	rowsPath, hostname, owner := path, viper.GetString("GHMPKG_TARGET_HOSTNAME"), viper.GetString("GHMPKG_TARGET_ORGANIZATION")
+	if strings.HasSuffix(path, "_state.json") {
+		state, err := results.LoadState(path)
+		if err != nil {
+			return nil, err
+		}
+		if state.Phase != "sync" && state.Phase != "migrate" {
+			return nil, fmt.Errorf("only sync and migrate runs publish packages, %s is a %s run", path, state.Phase)
+		}
+		if state.Running() {
+			return nil, fmt.Errorf("run %s is still in progress", path)
+		}
+		// The target of the run is registry/hostname/organization, where
+		// the hostname can be a URL. A configured organization must match.
+		if state.Target != "" {
+			first, last := strings.Index(state.Target, "/"), strings.LastIndex(state.Target, "/")
+			if first < 0 || first == last || state.Target[:first] != registries.GitHub {
+				return nil, fmt.Errorf("rollback only supports runs that published to GitHub Packages, %s published to %s", path, state.Target)
+			}
+			runOwner := state.Target[last+1:]
+			if owner != "" && !strings.EqualFold(owner, runOwner) {
+				return nil, fmt.Errorf("run %s published to %s, not to %s", path, runOwner, owner)
+			}
+			hostname, owner = state.Target[first+1:last], runOwner
+		}
+		rowsPath = state.ResultsFile
+	} else if registries.TargetName() != registries.GitHub {
+		return nil, fmt.Errorf("rollback only supports GitHub Packages targets")
+	}
+	if owner == "" {
+		return nil, fmt.Errorf("the target organization is unknown, set GHMPKG_TARGET_ORGANIZATION")
+	}
+	token := viper.GetString("GHMPKG_TARGET_TOKEN")
+	if token == "" {
+		return nil, fmt.Errorf("rollback requires GHMPKG_TARGET_TOKEN")
+	}
+
+	rows, err := results.Read(rowsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read results file %s: %w", rowsPath, err)
+	}
+	published, partial := Versions(rows)
+	summary := &Summary{Partial: len(partial)}
+	for _, version := range partial {
+		logger.Warn("Keeping version that existed before the run", zap.String("package_version", version.String()))
+		pterm.Warning.Println(fmt.Sprintf("⚠️ Kept %s, some of its files existed before the run", version))
+	}
+	if !confirm {
+		pterm.Info.Println(fmt.Sprintf("Dry run: %d versions would be deleted from %s, pass --confirm to delete them", len(published), owner))
+	}
+
+	for _, version := range published {
+		packageName := providers.TargetPackageName(version.PackageType, version.PackageName)
+		fields := []zap.Field{zap.String("owner", owner), zap.String("package_type", version.PackageType), zap.String("package_name", packageName), zap.String("package_version", version.Version)}
+
+		versions, err := api.PackageVersions(token, hostname, owner, version.PackageType, packageName)
+		// Packages deleted since the run are not found
+		if err != nil && failures.Classify(err) != failures.NotFound {
+			logger.Error("Failed to list target versions", append(fields, zap.Error(err))...)
+			pterm.Error.Println(fmt.Sprintf("❌ Failed to look up %s: %v", version, err))
+			summary.Failed++
+			continue
+		}
+		var id int64
+		for _, candidate := range versions {
+			if candidate.GetName() == version.Version {
+				id = candidate.GetID()
+			}
+		}
+		if id == 0 {
+			logger.Info("Version is not in the target", fields...)
+			summary.Missing++
+			continue
+		}
+
+		if !confirm {
+			pterm.Info.Println(fmt.Sprintf("🗑️ Would delete %s", version))
+			continue
+		}
+		// GitHub refuses to delete the last version of a package
+		if err := api.DeletePackageVersion(token, hostname, owner, version.PackageType, packageName, id, len(versions) == 1); err != nil {
+			logger.Error("Failed to delete version", append(fields, zap.Error(err))...)
+			pterm.Error.Println(fmt.Sprintf("❌ Failed to delete %s: %v", version, err))
+			summary.Failed++
+			continue
+		}
+		logger.Info("Deleted version", fields...)
+		pterm.Success.Println(fmt.Sprintf("🗑️ Deleted %s", version))
+		summary.Deleted++
+		if err := audit.Write(audit.Record{
+			Event:        audit.RolledBack,
+			Phase:        "rollback",
+			Organization: version.Organization,
+			Repository:   version.Repository,
+			PackageType:  version.PackageType,
+			PackageName:  version.PackageName,
+			Version:      version.Version,
+			Target:       strings.Join([]string{registries.GitHub, hostname, owner}, "/"),
+		}); err != nil {
+			logger.Warn("Failed to write audit log", zap.String("file", audit.Path()), zap.Error(err))
+		}
+	}
+
+	fmt.Println("\n📊 Rollback Summary:")
+	fmt.Printf("🗑️ Deleted: %d versions\n", summary.Deleted)
+	fmt.Printf("⏭️ Not in the target: %d versions\n", summary.Missing)
+	fmt.Printf("⚠️ Kept, partially published before the run: %d versions\n", summary.Partial)
+	fmt.Printf("❌ Failed: %d versions\n", summary.Failed)
+	return summary, nil
+}
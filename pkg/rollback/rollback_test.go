@@ -0,0 +1,27 @@
+package rollback
+
+import (
+	"testing"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/results"
+)
+
+func TestVersions(t *testing.T) {
+	rows := []results.Row{
+		{Organization: "org", PackageType: "npm", PackageName: "a", Version: "1.0.0", Filename: "a-1.0.0.tgz", State: "Failed"},
+		{Organization: "org", PackageType: "npm", PackageName: "a", Version: "1.0.0", Filename: "a-1.0.0.tgz", State: "Success"},
+		{Organization: "org", PackageType: "npm", PackageName: "b", Version: "1.0.0", Filename: "b-1.0.0.tgz", State: "Skipped"},
+		{Organization: "org", PackageType: "maven", PackageName: "c", Version: "1", Filename: "c-1.jar", State: "Success"},
+		{Organization: "org", PackageType: "maven", PackageName: "c", Version: "1", Filename: "c-1.pom", State: "Skipped"},
+		{Organization: "org", PackageType: "maven", PackageName: "d", Version: "1", Filename: "d-1.jar", State: "Success"},
+		{Organization: "org", PackageType: "maven", PackageName: "d", Version: "1", Filename: "d-1.pom", State: "Failed"},
+	}
+
+	published, partial := Versions(rows)
+	if len(published) != 2 || published[0].PackageName != "a" || published[1].PackageName != "d" {
+		t.Errorf("unexpected published versions: %v", published)
+	}
+	if len(partial) != 1 || partial[0].PackageName != "c" {
+		t.Errorf("unexpected partial versions: %v", partial)
+	}
+}
@@ -0,0 +1,125 @@
+package status
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/results"
+	"github.com/pterm/pterm"
+	"go.uber.org/zap"
+)
+
+// Status prints the progress of the most recent run of every phase, or of a
+// single run when statePath is set. Results are read from disk so this works
+// while another process is still migrating.
+func Status(logger *zap.Logger, phase, statePath string) error {
+	states, err := loadStates(logger, results.Dir, phase, statePath)
+	if err != nil {
+		return err
+	}
+
+	if len(states) == 0 {
+		pterm.Warning.Printf("No runs found in %s\n", results.Dir)
+		return nil
+	}
+
+	for _, state := range states {
+		if err := printRun(logger, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadStates returns the run described by statePath, or the latest run of
+// every phase in dir
+func loadStates(logger *zap.Logger, dir, phase, statePath string) ([]*results.State, error) {
+	if statePath != "" {
+		state, err := results.LoadState(statePath)
+		if err != nil {
+			return nil, err
+		}
+		return []*results.State{state}, nil
+	}
+	return results.LatestStates(logger, dir, phase)
+}
+
+func printRun(logger *zap.Logger, state *results.State) error {
+	rows, err := results.Read(state.ResultsFile)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read results file %s: %w", state.ResultsFile, err)
+	}
+	logger.Info("Loaded run",
+		zap.String("state", state.Path()),
+		zap.String("results", state.ResultsFile),
+		zap.Int("rows", len(rows)))
+
+	elapsed := state.Elapsed()
+	fmt.Printf("\n📊 %s status (%s)\n", state.Phase, runStatus(state))
+	fmt.Printf("🏢 Organization: %s\n", state.Organization)
+	if state.RunID != "" {
+		fmt.Printf("🔖 Run: %s\n", state.RunID)
+	}
+	if state.Shard != "" {
+		fmt.Printf("🧩 Shard: %s\n", state.Shard)
+	}
+	fmt.Printf("🕐 Started: %s, elapsed %dh %dm %ds\n",
+		state.StartedAt.Format(time.RFC1123),
+		int(elapsed.Hours()), int(elapsed.Minutes())%60, int(elapsed.Seconds())%60)
+	if state.Running() {
+		if info, err := os.Stat(state.ResultsFile); err == nil {
+			fmt.Printf("📝 Last result: %s ago\n", time.Since(info.ModTime()).Round(time.Second))
+		}
+	}
+
+	return pterm.DefaultTable.WithHasHeader().WithData(statusTable(state, rows)).Render()
+}
+
+// runStatus describes whether a run is still going, finished or stopped
+func runStatus(state *results.State) string {
+	if state.Running() {
+		return "⏳ in progress"
+	}
+	if state.Error != "" {
+		return "❌ stopped: " + state.Error
+	}
+	return "✅ finished"
+}
+
+// statusTable counts the packages, versions and files of every package type
+// of a run by their outcome
+func statusTable(state *results.State, rows []results.Row) pterm.TableData {
+	summaries := results.Summarize(state.Totals, rows)
+	packageTypes := make([]string, 0, len(summaries))
+	for packageType := range summaries {
+		packageTypes = append(packageTypes, packageType)
+	}
+	sort.Strings(packageTypes)
+
+	table := pterm.TableData{{"Type", "Level", "Succeeded", "Skipped", "Failed", "Pending"}}
+	for _, packageType := range packageTypes {
+		summary := summaries[packageType]
+		levels := []struct {
+			name   string
+			counts results.Counts
+		}{
+			{"packages", summary.Packages},
+			{"versions", summary.Versions},
+			{"files", summary.Files},
+		}
+		for _, level := range levels {
+			table = append(table, []string{
+				packageType,
+				level.name,
+				strconv.Itoa(level.counts.Success),
+				strconv.Itoa(level.counts.Skipped),
+				strconv.Itoa(level.counts.Failed),
+				strconv.Itoa(level.counts.Pending),
+			})
+		}
+	}
+	return table
+}
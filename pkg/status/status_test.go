@@ -0,0 +1,164 @@
+package status
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/results"
+	"go.uber.org/zap"
+)
+
+// writeRun writes the state and results files of a run to dir
+func writeRun(t *testing.T, dir, name string, state results.State, rows []results.Row) string {
+	t.Helper()
+	state.ResultsFile = filepath.Join(dir, name+"_results.csv")
+	writer, err := results.Create(state.ResultsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := writer.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name+"_state.json")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStatusTable(t *testing.T) {
+	finished := time.Date(2026, 1, 2, 4, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		state  results.State
+		rows   []results.Row
+		status string
+		want   [][]string
+	}{
+		{
+			name:  "retried file",
+			state: results.State{FinishedAt: &finished, Totals: map[string]results.Totals{"npm": {Packages: 1, Versions: 2, Files: 2}}},
+			rows: []results.Row{
+				{Organization: "acme", PackageType: "npm", PackageName: "ui", Version: "1.0.0", Filename: "ui-1.0.0.tgz", State: "Failed"},
+				{Organization: "acme", PackageType: "npm", PackageName: "ui", Version: "1.0.0", Filename: "ui-1.0.0.tgz", State: "Success"},
+				{Organization: "acme", PackageType: "npm", PackageName: "ui", Version: "1.1.0", Filename: "ui-1.1.0.tgz", State: "Success"},
+			},
+			status: "✅ finished",
+			want: [][]string{
+				{"npm", "packages", "1", "0", "0", "0"},
+				{"npm", "versions", "2", "0", "0", "0"},
+				{"npm", "files", "2", "0", "0", "0"},
+			},
+		},
+		{
+			name:  "run in progress",
+			state: results.State{Totals: map[string]results.Totals{"maven": {Packages: 2, Versions: 3, Files: 6}}},
+			rows: []results.Row{
+				{Organization: "acme", PackageType: "maven", PackageName: "com.acme.core", Version: "1.0", Filename: "core-1.0.jar", State: "Success"},
+				{Organization: "acme", PackageType: "maven", PackageName: "com.acme.core", Version: "1.0", Filename: "core-1.0.pom", State: "TooLarge"},
+			},
+			status: "⏳ in progress",
+			want: [][]string{
+				{"maven", "packages", "0", "1", "0", "1"},
+				{"maven", "versions", "0", "1", "0", "2"},
+				{"maven", "files", "1", "1", "0", "4"},
+			},
+		},
+		{
+			name:  "stopped run",
+			state: results.State{FinishedAt: &finished, Error: "rate limited", Totals: map[string]results.Totals{"nuget": {Packages: 1, Versions: 1, Files: 1}}},
+			rows: []results.Row{
+				{Organization: "acme", PackageType: "nuget", PackageName: "Acme.Core", Version: "2.0.0", Filename: "Acme.Core.2.0.0.nupkg", State: "Failed"},
+				{Organization: "acme", PackageType: "container", PackageName: "app", Version: "v1", Filename: "app:v1", State: "Success"},
+			},
+			status: "❌ stopped: rate limited",
+			want: [][]string{
+				{"container", "packages", "1", "0", "0", "0"},
+				{"container", "versions", "1", "0", "0", "0"},
+				{"container", "files", "1", "0", "0", "0"},
+				{"nuget", "packages", "0", "0", "1", "0"},
+				{"nuget", "versions", "0", "0", "1", "0"},
+				{"nuget", "files", "0", "0", "1", "0"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := writeRun(t, dir, "2026-01-02_03-04-05_acme_sync", tt.state, tt.rows)
+			state, err := results.LoadState(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows, err := results.Read(state.ResultsFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if status := runStatus(state); status != tt.status {
+				t.Errorf("runStatus() = %q, want %q", status, tt.status)
+			}
+			table := statusTable(state, rows)
+			if len(table) != len(tt.want)+1 {
+				t.Fatalf("statusTable() = %v, want %v", table, tt.want)
+			}
+			for i, want := range tt.want {
+				if !slices.Equal(table[i+1], want) {
+					t.Errorf("row %d = %v, want %v", i, table[i+1], want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadStates(t *testing.T) {
+	dir := t.TempDir()
+	writeRun(t, dir, "2026-01-01_00-00-00_acme_a_sync", results.State{Phase: "sync", RunID: "old-sync"}, nil)
+	writeRun(t, dir, "2026-01-02_00-00-00_acme_b_sync", results.State{Phase: "sync", RunID: "new-sync"}, nil)
+	pull := writeRun(t, dir, "2026-01-01_12-00-00_acme_c_pull", results.State{Phase: "pull", RunID: "pull"}, nil)
+	if err := os.WriteFile(filepath.Join(dir, "2026-01-03_00-00-00_acme_d_sync_state.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		phase     string
+		statePath string
+		want      []string
+	}{
+		{"", "", []string{"new-sync", "pull"}},
+		{"sync", "", []string{"new-sync"}},
+		{"migrate", "", nil},
+		{"sync", pull, []string{"pull"}},
+	}
+	for _, tt := range tests {
+		states, err := loadStates(zap.NewNop(), dir, tt.phase, tt.statePath)
+		if err != nil {
+			t.Fatalf("loadStates(%q, %q) failed: %v", tt.phase, tt.statePath, err)
+		}
+		var runs []string
+		for _, state := range states {
+			runs = append(runs, state.RunID)
+		}
+		if !slices.Equal(runs, tt.want) {
+			t.Errorf("loadStates(%q, %q) = %v, want %v", tt.phase, tt.statePath, runs, tt.want)
+		}
+	}
+
+	if _, err := loadStates(zap.NewNop(), dir, "", filepath.Join(dir, "missing_state.json")); err == nil || !strings.Contains(err.Error(), "missing_state.json") {
+		t.Errorf("loadStates() of a missing state file = %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package sync
+
+import (
+	"fmt"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/bundle"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// ExtractBundle unpacks a bundle created with `export --bundle` into the
+// migration-packages directory so that it can be synced like a regular pull.
+// Source details missing from the configuration are taken from the manifest.
+func ExtractBundle(logger *zap.Logger, bundlePath string) error {
+	spinner, _ := pterm.DefaultSpinner.Start(fmt.Sprintf("Extracting bundle: %s", bundlePath))
+
+	manifest, err := bundle.Extract(logger, bundlePath, "migration-packages")
+	if err != nil {
+		spinner.Fail(fmt.Sprintf("Error extracting bundle: %v", err))
+		return err
+	}
+
+	if viper.GetString("GHMPKG_SOURCE_ORGANIZATION") == "" {
+		viper.Set("GHMPKG_SOURCE_ORGANIZATION", manifest.SourceOrganization)
+	} else if viper.GetString("GHMPKG_SOURCE_ORGANIZATION") != manifest.SourceOrganization {
+		spinner.Fail("Source organization does not match the bundle")
+		return fmt.Errorf("bundle was created for %s, not %s", manifest.SourceOrganization, viper.GetString("GHMPKG_SOURCE_ORGANIZATION"))
+	}
+	if viper.GetString("GHMPKG_SOURCE_HOSTNAME") == "" {
+		viper.Set("GHMPKG_SOURCE_HOSTNAME", manifest.SourceHostname)
+	}
+
+	logger.Info("Extracted bundle",
+		zap.String("bundle", bundlePath),
+		zap.String("sourceOrganization", manifest.SourceOrganization),
+		zap.Strings("package_types", manifest.PackageTypes),
+		zap.Int("files", len(manifest.Files)))
+	spinner.Success(fmt.Sprintf("Extracted %d files created at %s from %s", len(manifest.Files), manifest.CreatedAt.Format("2006-01-02 15:04:05"), manifest.SourceOrganization))
+
+	return nil
+}
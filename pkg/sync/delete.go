@@ -0,0 +1,170 @@
+package sync
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v62/github"
+	"github.com/mark-humane/gh-migrate-packages/internal/api"
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// deleteSourceTypes parses the package types opted in to deleting migrated
+// versions from the source organization
+func deleteSourceTypes(value string) (map[string]bool, error) {
+	types := map[string]bool{}
+	for _, packageType := range strings.Split(value, ",") {
+		packageType = strings.ToLower(strings.TrimSpace(packageType))
+		if packageType == "" {
+			continue
+		}
+		if !utils.Contains(common.SUPPORTED_PACKAGE_TYPES, packageType) {
+			return nil, fmt.Errorf("unsupported package type to delete from the source: %s", packageType)
+		}
+		types[packageType] = true
+	}
+	return types, nil
+}
+
+// CheckDeleteSource validates the delete source settings before a run
+// starts, deleting needs a GitHub source and target and the source token
+func CheckDeleteSource(logger *zap.Logger) error {
+	types, err := deleteSourceTypes(viper.GetString("GHMPKG_DELETE_SOURCE"))
+	if err != nil || len(types) == 0 {
+		return err
+	}
+	if registries.SourceName() != registries.GitHub || registries.TargetName() != registries.GitHub {
+		return fmt.Errorf("deleting from the source is only supported between GitHub organizations")
+	}
+	if viper.GetString("GHMPKG_SOURCE_TOKEN") == "" {
+		return fmt.Errorf("deleting from the source requires GHMPKG_SOURCE_TOKEN")
+	}
+	if viper.GetBool("GHMPKG_CONFIRM_DELETE") {
+		pterm.Warning.Println(fmt.Sprintf("🗑️ Migrated %s versions will be deleted from %s", strings.Join(slices.Sorted(maps.Keys(types)), ", "), viper.GetString("GHMPKG_SOURCE_ORGANIZATION")))
+	} else {
+		pterm.Info.Println("🗑️ Dry run: versions that would be deleted from the source are logged, pass --confirm to delete them")
+	}
+	logger.Info("Deleting migrated versions from the source", zap.Strings("package_types", slices.Sorted(maps.Keys(types))), zap.Bool("confirmed", viper.GetBool("GHMPKG_CONFIRM_DELETE")))
+	return nil
+}
+
+// findVersion returns the version with the given name
+func findVersion(versions []*github.PackageVersion, name string) *github.PackageVersion {
+	for _, version := range versions {
+		if version.GetName() == name {
+			return version
+		}
+	}
+	return nil
+}
+
+// verifyTarget checks that the target lists every published file of a
+// version with the size it was published with, a version left by an earlier
+// partial upload or published by someone else doesn't match. Container
+// versions are matched by digest already. Rewritten files are compared as
+// they were published.
+func verifyTarget(logger *zap.Logger, packageType, targetName, version string, versionDirs, filenames []string) error {
+	if packageType == "container" {
+		return nil
+	}
+	files, err := providers.FetchVersionFileSizes(logger, viper.GetString("GHMPKG_TARGET_HOSTNAME"), viper.GetString("GHMPKG_TARGET_ORGANIZATION"), viper.GetString("GHMPKG_TARGET_TOKEN"), packageType, targetName, version)
+	if err != nil {
+		return fmt.Errorf("failed to list the files of the target version: %w", err)
+	}
+	sizes := make(map[string]int64, len(files))
+	for _, file := range files {
+		sizes[file.Name] = file.Size
+	}
+	for _, filename := range filenames {
+		// Regenerated for the whole package, not part of the version
+		if strings.HasPrefix(filename, mavenMetadataFile) {
+			continue
+		}
+		size, ok := sizes[filename]
+		if !ok {
+			return fmt.Errorf("%s is missing from the target", filename)
+		}
+		info, err := os.Stat(filepath.Join(versionDirs[0], filename))
+		if err != nil {
+			return fmt.Errorf("failed to read the published size of %s: %w", filename, err)
+		}
+		if size != info.Size() {
+			return fmt.Errorf("%s has %d bytes in the target, %d were published", filename, size, info.Size())
+		}
+	}
+	return nil
+}
+
+// recordDeleteFailure records the files of a version that could not be
+// deleted from the source as failed, so the run summary and exit code show
+// it and the next run tries again
+func recordDeleteFailure(report *common.Report, filenames []string, err error) {
+	err = fmt.Errorf("failed to delete from the source: %w", err)
+	for _, filename := range filenames {
+		report.RecordFile(filename, providers.Failed, err)
+	}
+}
+
+// deleteSource deletes a published version from the source organization
+// once its copy in the target organization has been verified. Versions
+// whose copy can't be verified are kept, failed deletions are recorded.
+func deleteSource(logger *zap.Logger, report *common.Report, repository, packageType, packageName, version string, versionDirs, filenames []string) {
+	types, err := deleteSourceTypes(viper.GetString("GHMPKG_DELETE_SOURCE"))
+	if err != nil || !types[packageType] {
+		return
+	}
+	// Container versions are matched by digest
+	targetName := providers.TargetPackageName(packageType, packageName)
+	targetVersions, err := api.PackageVersions(viper.GetString("GHMPKG_TARGET_TOKEN"), viper.GetString("GHMPKG_TARGET_HOSTNAME"), viper.GetString("GHMPKG_TARGET_ORGANIZATION"), packageType, targetName)
+	if err != nil || findVersion(targetVersions, version) == nil {
+		logger.Warn("Not deleting from the source, the version was not found in the target", zap.Error(err))
+		pterm.Warning.Println(fmt.Sprintf("⚠️ Kept %s@%s in the source, it was not found in the target", packageName, version))
+		return
+	}
+	if err := verifyTarget(logger, packageType, targetName, version, versionDirs, filenames); err != nil {
+		logger.Warn("Not deleting from the source, the target copy could not be verified", zap.Error(err))
+		pterm.Warning.Println(fmt.Sprintf("⚠️ Kept %s@%s in the source, the target copy could not be verified: %v", packageName, version, err))
+		return
+	}
+
+	sourceToken := viper.GetString("GHMPKG_SOURCE_TOKEN")
+	sourceHostname := viper.GetString("GHMPKG_SOURCE_HOSTNAME")
+	sourceOrg := viper.GetString("GHMPKG_SOURCE_ORGANIZATION")
+	sourceVersions, err := api.PackageVersions(sourceToken, sourceHostname, sourceOrg, packageType, packageName)
+	if err != nil {
+		logger.Error("Failed to list source versions", zap.Error(err))
+		recordDeleteFailure(report, filenames, err)
+		return
+	}
+	sourceVersion := findVersion(sourceVersions, version)
+	if sourceVersion == nil {
+		logger.Info("Version no longer exists in the source")
+		return
+	}
+
+	if !viper.GetBool("GHMPKG_CONFIRM_DELETE") {
+		logger.Info("Would delete version from the source", zap.Int64("versionId", sourceVersion.GetID()))
+		pterm.Info.Println(fmt.Sprintf("🗑️ Would delete %s@%s from the source", packageName, version))
+		return
+	}
+	last := len(sourceVersions) == 1
+	if err := api.DeletePackageVersion(sourceToken, sourceHostname, sourceOrg, packageType, packageName, sourceVersion.GetID(), last); err != nil {
+		logger.Error("Failed to delete version from the source", zap.Error(err))
+		pterm.Error.Println(fmt.Sprintf("❌ Failed to delete %s@%s from the source: %v", packageName, version, err))
+		recordDeleteFailure(report, filenames, err)
+		return
+	}
+	logger.Info("Deleted version from the source", zap.Int64("versionId", sourceVersion.GetID()), zap.Bool("package_deleted", last))
+	pterm.Success.Println(fmt.Sprintf("🗑️ Deleted %s@%s from the source", packageName, version))
+	report.RecordSourceDeleted(repository, packageType, packageName, version)
+}
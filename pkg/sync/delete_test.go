@@ -0,0 +1,118 @@
+package sync
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/google/go-github/v62/github"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+func TestDeleteSourceTypes(t *testing.T) {
+	types, err := deleteSourceTypes(" npm, Maven,,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 2 || !types["npm"] || !types["maven"] {
+		t.Errorf("unexpected types: %v", types)
+	}
+	if _, err := deleteSourceTypes("npm,pypi"); err == nil {
+		t.Error("expected an error for an unsupported type")
+	}
+}
+
+func TestFindVersion(t *testing.T) {
+	versions := []*github.PackageVersion{
+		{ID: github.Int64(1), Name: github.String("1.0.0")},
+		{ID: github.Int64(2), Name: github.String("sha256:abc")},
+	}
+	if version := findVersion(versions, "sha256:abc"); version == nil || version.GetID() != 2 {
+		t.Errorf("unexpected version: %v", version)
+	}
+	if findVersion(versions, "2.0.0") != nil {
+		t.Error("expected no match")
+	}
+}
+
+// stage writes a published file of three bytes to a version directory and
+// returns the directory
+func stage(t *testing.T, filename string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, filename), []byte("tgz"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestDeleteSource(t *testing.T) {
+	keys := []string{"GHMPKG_DELETE_SOURCE", "GHMPKG_CONFIRM_DELETE", "GHMPKG_SOURCE_TOKEN", "GHMPKG_SOURCE_HOSTNAME", "GHMPKG_SOURCE_ORGANIZATION", "GHMPKG_TARGET_TOKEN", "GHMPKG_TARGET_HOSTNAME", "GHMPKG_TARGET_ORGANIZATION", "GHMPKG_AUDIT_LOG", "RETRY_DELAY"}
+	defer func() {
+		for _, key := range keys {
+			viper.Set(key, nil)
+		}
+	}()
+
+	versions := []map[string]any{{"id": 1, "name": "1.0.0"}, {"id": 2, "name": "1.1.0"}}
+	tests := []struct {
+		name       string
+		files      []map[string]any
+		failDelete bool
+		deleted    []string
+		failed     int
+	}{
+		{
+			name:    "verified target copy",
+			files:   []map[string]any{{"name": "ui-1.1.0.tgz", "size": 3}},
+			deleted: []string{"/api/v3/orgs/acme/packages/npm/ui/versions/2"},
+		},
+		{
+			name:  "file missing from the target",
+			files: []map[string]any{{"name": "package.json", "size": 3}},
+		},
+		{
+			name:  "partial upload in the target",
+			files: []map[string]any{{"name": "ui-1.1.0.tgz", "size": 1}},
+		},
+		{
+			name:       "failed deletion",
+			files:      []map[string]any{{"name": "ui-1.1.0.tgz", "size": 3}},
+			failDelete: true,
+			failed:     1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &fakeTarget{versions: versions, files: tt.files, failDelete: tt.failDelete}
+			httpServer := httptest.NewServer(server)
+			defer httpServer.Close()
+			viper.Set("GHMPKG_DELETE_SOURCE", "npm")
+			viper.Set("GHMPKG_CONFIRM_DELETE", true)
+			for _, side := range []string{"SOURCE", "TARGET"} {
+				viper.Set("GHMPKG_"+side+"_TOKEN", "ghp_token")
+				viper.Set("GHMPKG_"+side+"_HOSTNAME", httpServer.URL)
+			}
+			viper.Set("GHMPKG_SOURCE_ORGANIZATION", "acme")
+			viper.Set("GHMPKG_TARGET_ORGANIZATION", "acme-new")
+			viper.Set("GHMPKG_AUDIT_LOG", filepath.Join(t.TempDir(), "audit.jsonl"))
+			viper.Set("RETRY_DELAY", "1ms")
+
+			report := common.NewReport()
+			deleteSource(zap.NewNop(), report, "web", "npm", "ui", "1.1.0", []string{stage(t, "ui-1.1.0.tgz")}, []string{"ui-1.1.0.tgz"})
+			if !slices.Equal(server.deleted, tt.deleted) {
+				t.Errorf("deleted %v, want %v", server.deleted, tt.deleted)
+			}
+			if report.SourceVersionsDeleted != len(tt.deleted) {
+				t.Errorf("source versions deleted = %d, want %d", report.SourceVersionsDeleted, len(tt.deleted))
+			}
+			if report.FilesFailed != tt.failed {
+				t.Errorf("failed files = %d, want %d", report.FilesFailed, tt.failed)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package sync
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v62/github"
+	"github.com/mark-humane/gh-migrate-packages/internal/api"
+	"github.com/mark-humane/gh-migrate-packages/internal/failures"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Actions of a version in a plan diff
+const (
+	DiffCreate  = "+"
+	DiffChange  = "~"
+	DiffPresent = "="
+)
+
+// VersionChange is what a plan does to a version in the target
+type VersionChange struct {
+	Action      string
+	PackageType string
+	PackageName string
+	Version     string
+	Files       int
+	Bytes       int64
+	Details     []string
+}
+
+// diffAction returns the action of a version, versions already in the target
+// are changed when the metadata of their package differs from the source
+func diffAction(present bool, drift []metadataDrift) string {
+	switch {
+	case !present:
+		return DiffCreate
+	case len(drift) > 0:
+		return DiffChange
+	}
+	return DiffPresent
+}
+
+// targetVersions returns the version names of a target package, with the
+// tags of container versions
+func targetVersions(versions []*github.PackageVersion) map[string]bool {
+	names := map[string]bool{}
+	for _, version := range versions {
+		names[version.GetName()] = true
+		if container := version.GetMetadata().GetContainer(); container != nil {
+			for _, tag := range container.Tags {
+				names[tag] = true
+			}
+		}
+	}
+	return names
+}
+
+// targetPackage is what the diff knows of a package in the target
+type targetPackage struct {
+	checked  bool
+	versions map[string]bool
+	drift    []metadataDrift
+}
+
+// lookupTarget lists the versions of a package in the target and compares
+// its metadata with the export. Packages that can't be looked up are
+// reported as not checked.
+func lookupTarget(logger *zap.Logger, packageType, packageName, targetName string, exported map[string]map[string]common.PackageMetadata) targetPackage {
+	token := viper.GetString("GHMPKG_TARGET_TOKEN")
+	hostname := viper.GetString("GHMPKG_TARGET_HOSTNAME")
+	targetOrg := viper.GetString("GHMPKG_TARGET_ORGANIZATION")
+	fields := []zap.Field{zap.String("package_type", packageType), zap.String("package_name", targetName)}
+	pkg, err := api.GetPackage(token, hostname, targetOrg, packageType, targetName)
+	if err != nil {
+		if failures.Classify(err) == failures.NotFound {
+			return targetPackage{checked: true, versions: map[string]bool{}}
+		}
+		logger.Warn("Failed to look up target package", append(fields, zap.Error(err))...)
+		return targetPackage{}
+	}
+	versions, err := api.PackageVersions(token, hostname, targetOrg, packageType, targetName)
+	if err != nil {
+		logger.Warn("Failed to list target versions", append(fields, zap.Error(err))...)
+		return targetPackage{}
+	}
+	target := targetPackage{checked: true, versions: targetVersions(versions)}
+	if metadata, ok := exported[packageType][packageName]; ok {
+		target.drift = compareMetadata(metadata, common.NewPackageMetadata(pkg, versions))
+	}
+	return target
+}
+
+// DiffPlan compares the versions of a plan with the target organization.
+// Only GitHub Packages targets are looked up with GHMPKG_TARGET_TOKEN, the
+// versions of other targets and of packages that can't be looked up are
+// all to be created.
+func DiffPlan(logger *zap.Logger, plan *Plan) []VersionChange {
+	lookup := registries.TargetName() == registries.GitHub && viper.GetString("GHMPKG_TARGET_TOKEN") != ""
+	var exported map[string]map[string]common.PackageMetadata
+	if lookup && registries.SourceName() == registries.GitHub {
+		var packageTypes []string
+		for _, item := range plan.Items {
+			if !utils.Contains(packageTypes, item.PackageType) {
+				packageTypes = append(packageTypes, item.PackageType)
+			}
+		}
+		var err error
+		if exported, err = common.LoadExportedMetadata(logger, plan.Source.Organization, packageTypes); err != nil {
+			logger.Warn("Failed to load exported package metadata", zap.Error(err))
+		}
+	}
+
+	var changes []VersionChange
+	index := map[string]int{}
+	targets := map[string]targetPackage{}
+	for _, item := range plan.Items {
+		key := item.PackageType + " " + item.TargetName + "@" + item.TargetVersion
+		if i, ok := index[key]; ok {
+			changes[i].Files++
+			changes[i].Bytes += max(item.Size, 0)
+			continue
+		}
+		packageKey := item.PackageType + " " + item.TargetName
+		target, ok := targets[packageKey]
+		if !ok && lookup {
+			target = lookupTarget(logger, item.PackageType, item.PackageName, item.TargetName, exported)
+			targets[packageKey] = target
+		}
+		change := VersionChange{
+			Action:      diffAction(target.versions[item.TargetVersion], target.drift),
+			PackageType: item.PackageType,
+			PackageName: item.TargetName,
+			Version:     item.TargetVersion,
+			Files:       1,
+			Bytes:       max(item.Size, 0),
+		}
+		if lookup && !target.checked {
+			change.Details = append(change.Details, "target not checked")
+		}
+		if change.Action == DiffChange {
+			for _, d := range target.drift {
+				change.Details = append(change.Details, fmt.Sprintf("%s: %q -> %q", d.property, d.target, d.exported))
+			}
+		}
+		index[key] = len(changes)
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// PrintDiff prints the changes of a plan like a terraform plan and returns
+// the number of versions per action
+func PrintDiff(changes []VersionChange) map[string]int {
+	counts := map[string]int{}
+	for _, change := range changes {
+		counts[change.Action]++
+		line := fmt.Sprintf("  %s %s %s@%s (%d files", change.Action, change.PackageType, change.PackageName, change.Version, change.Files)
+		if change.Bytes > 0 {
+			line += ", " + utils.FormatBytes(change.Bytes)
+		}
+		line += ")"
+		if len(change.Details) > 0 {
+			line += " " + strings.Join(change.Details, ", ")
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("\nPlan: %d to create, %d to change, %d already present.\n", counts[DiffCreate], counts[DiffChange], counts[DiffPresent])
+	return counts
+}
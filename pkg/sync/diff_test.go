@@ -0,0 +1,33 @@
+package sync
+
+import (
+	"testing"
+
+	"github.com/google/go-github/v62/github"
+)
+
+func TestDiffAction(t *testing.T) {
+	drift := []metadataDrift{{"visibility", "private", "public"}}
+	if action := diffAction(false, drift); action != DiffCreate {
+		t.Errorf("diffAction(missing) = %s, expected %s", action, DiffCreate)
+	}
+	if action := diffAction(true, drift); action != DiffChange {
+		t.Errorf("diffAction(present, drift) = %s, expected %s", action, DiffChange)
+	}
+	if action := diffAction(true, nil); action != DiffPresent {
+		t.Errorf("diffAction(present) = %s, expected %s", action, DiffPresent)
+	}
+}
+
+func TestTargetVersions(t *testing.T) {
+	versions := []*github.PackageVersion{
+		{Name: github.String("1.0.0")},
+		{Name: github.String("sha256:abc"), Metadata: &github.PackageMetadata{Container: &github.PackageContainerMetadata{Tags: []string{"v1", "latest"}}}},
+	}
+	names := targetVersions(versions)
+	for _, name := range []string{"1.0.0", "sha256:abc", "v1", "latest"} {
+		if !names[name] {
+			t.Errorf("targetVersions() is missing %s", name)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package sync
+
+import (
+	"fmt"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/api"
+	"github.com/mark-humane/gh-migrate-packages/internal/failures"
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// CheckForce validates --force before a run starts, versions can only be
+// replaced in GitHub Packages targets and deleting them must be confirmed
+func CheckForce(logger *zap.Logger) error {
+	if !viper.GetBool("GHMPKG_FORCE") {
+		return nil
+	}
+	if registries.TargetName() != registries.GitHub {
+		return fmt.Errorf("--force only supports GitHub Packages targets")
+	}
+	if !viper.GetBool("GHMPKG_CONFIRM_FORCE") {
+		return fmt.Errorf("--force deletes versions from the target, pass --confirm-force to delete them")
+	}
+	pterm.Warning.Println(fmt.Sprintf("♻️ Versions that exist in %s will be deleted and published again", viper.GetString("GHMPKG_TARGET_ORGANIZATION")))
+	logger.Warn("Replacing versions that exist in the target")
+	return nil
+}
+
+// replaceVersion deletes a version from the target when --force is set and
+// confirmed, so it can be published again. Versions not in the target are
+// left alone.
+func replaceVersion(logger *zap.Logger, report *common.Report, repository, packageType, packageName, version string) error {
+	if !viper.GetBool("GHMPKG_FORCE") || !viper.GetBool("GHMPKG_CONFIRM_FORCE") {
+		return nil
+	}
+	token := viper.GetString("GHMPKG_TARGET_TOKEN")
+	hostname := viper.GetString("GHMPKG_TARGET_HOSTNAME")
+	owner := viper.GetString("GHMPKG_TARGET_ORGANIZATION")
+	targetName := providers.TargetPackageName(packageType, packageName)
+
+	// Container versions are matched by digest
+	versions, err := api.PackageVersions(token, hostname, owner, packageType, targetName)
+	if failures.Classify(err) == failures.NotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list target versions: %w", err)
+	}
+	existing := findVersion(versions, version)
+	if existing == nil {
+		return nil
+	}
+	// GitHub refuses to delete the last version of a package
+	last := len(versions) == 1
+	if err := api.DeletePackageVersion(token, hostname, owner, packageType, targetName, existing.GetID(), last); err != nil {
+		return fmt.Errorf("failed to delete %s@%s from the target: %w", targetName, version, err)
+	}
+	logger.Info("Deleted version from the target to publish it again", zap.Int64("versionId", existing.GetID()), zap.Bool("package_deleted", last))
+	pterm.Warning.Println(fmt.Sprintf("♻️ Deleted %s@%s from the target, publishing it again", targetName, version))
+	report.RecordTargetReplaced(repository, packageType, packageName, version)
+	return nil
+}
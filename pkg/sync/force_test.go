@@ -0,0 +1,173 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// fakeTarget serves the versions of the npm package ui in every
+// organization, and the files of a version to the GraphQL API, and records
+// the deletions it receives
+type fakeTarget struct {
+	versions   []map[string]any
+	files      []map[string]any
+	failDelete bool
+	deleted    []string
+}
+
+func (f *fakeTarget) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/packages/npm/ui/versions"):
+		json.NewEncoder(w).Encode(f.versions)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/graphql":
+		files := map[string]any{"nodes": f.files, "pageInfo": map[string]any{"hasNextPage": false}}
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"organization": map[string]any{"packages": map[string]any{
+			"nodes": []any{map[string]any{"version": map[string]any{"files": files}}},
+		}}}})
+	case r.Method == http.MethodDelete && f.failDelete:
+		w.WriteHeader(http.StatusInternalServerError)
+	case r.Method == http.MethodDelete:
+		f.deleted = append(f.deleted, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestReplaceVersion(t *testing.T) {
+	keys := []string{"GHMPKG_FORCE", "GHMPKG_CONFIRM_FORCE", "GHMPKG_TARGET_TOKEN", "GHMPKG_TARGET_HOSTNAME", "GHMPKG_TARGET_ORGANIZATION", "GHMPKG_AUDIT_LOG"}
+	defer func() {
+		for _, key := range keys {
+			viper.Set(key, nil)
+		}
+	}()
+
+	tests := []struct {
+		name      string
+		confirmed bool
+		versions  []map[string]any
+		deleted   []string
+	}{
+		{
+			name:      "version exists",
+			confirmed: true,
+			versions:  []map[string]any{{"id": 1, "name": "1.0.0"}, {"id": 2, "name": "1.1.0"}},
+			deleted:   []string{"/api/v3/orgs/acme-new/packages/npm/ui/versions/2"},
+		},
+		{
+			name:      "version missing",
+			confirmed: true,
+			versions:  []map[string]any{{"id": 1, "name": "1.0.0"}},
+		},
+		{
+			name:      "last version deletes the package",
+			confirmed: true,
+			versions:  []map[string]any{{"id": 2, "name": "1.1.0"}},
+			deleted:   []string{"/api/v3/orgs/acme-new/packages/npm/ui"},
+		},
+		{
+			name:     "not confirmed",
+			versions: []map[string]any{{"id": 1, "name": "1.0.0"}, {"id": 2, "name": "1.1.0"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &fakeTarget{versions: tt.versions}
+			server := httptest.NewServer(target)
+			defer server.Close()
+			viper.Set("GHMPKG_FORCE", true)
+			viper.Set("GHMPKG_CONFIRM_FORCE", tt.confirmed)
+			viper.Set("GHMPKG_TARGET_TOKEN", "ghp_target")
+			viper.Set("GHMPKG_TARGET_HOSTNAME", server.URL)
+			viper.Set("GHMPKG_TARGET_ORGANIZATION", "acme-new")
+			viper.Set("GHMPKG_AUDIT_LOG", filepath.Join(t.TempDir(), "audit.jsonl"))
+
+			report := common.NewReport()
+			if err := replaceVersion(zap.NewNop(), report, "web", "npm", "ui", "1.1.0"); err != nil {
+				t.Fatalf("replaceVersion() failed: %v", err)
+			}
+			if !slices.Equal(target.deleted, tt.deleted) {
+				t.Errorf("deleted %v, want %v", target.deleted, tt.deleted)
+			}
+			if replaced := report.TargetVersionsReplaced; replaced != len(tt.deleted) {
+				t.Errorf("replaced versions = %d, want %d", replaced, len(tt.deleted))
+			}
+			_, err := os.Stat(viper.GetString("GHMPKG_AUDIT_LOG"))
+			if written := err == nil; written != (len(tt.deleted) > 0) {
+				t.Errorf("audit log written = %v, want %v", written, !written)
+			}
+		})
+	}
+}
+
+func TestCheckForce(t *testing.T) {
+	defer viper.Set("GHMPKG_FORCE", nil)
+	defer viper.Set("GHMPKG_CONFIRM_FORCE", nil)
+	defer viper.Set("GHMPKG_CONFIRM_DELETE", nil)
+
+	viper.Set("GHMPKG_FORCE", true)
+	if err := CheckForce(zap.NewNop()); err == nil {
+		t.Error("CheckForce() accepted --force without --confirm-force")
+	}
+	// --confirm confirms deleting from the source only
+	viper.Set("GHMPKG_CONFIRM_DELETE", true)
+	if err := CheckForce(zap.NewNop()); err == nil {
+		t.Error("CheckForce() accepted --force with --confirm")
+	}
+	viper.Set("GHMPKG_CONFIRM_DELETE", nil)
+	viper.Set("GHMPKG_CONFIRM_FORCE", true)
+	if err := CheckForce(zap.NewNop()); err != nil {
+		t.Errorf("CheckForce() with --confirm-force failed: %v", err)
+	}
+}
+
+func TestForceKeepsSourceDeletionDryRun(t *testing.T) {
+	keys := []string{"GHMPKG_FORCE", "GHMPKG_CONFIRM_FORCE", "GHMPKG_DELETE_SOURCE", "GHMPKG_CONFIRM_DELETE", "GHMPKG_SOURCE_TOKEN", "GHMPKG_SOURCE_HOSTNAME", "GHMPKG_SOURCE_ORGANIZATION", "GHMPKG_TARGET_TOKEN", "GHMPKG_TARGET_HOSTNAME", "GHMPKG_TARGET_ORGANIZATION", "GHMPKG_AUDIT_LOG"}
+	defer func() {
+		for _, key := range keys {
+			viper.Set(key, nil)
+		}
+	}()
+
+	// The source and the target both have ui 1.0.0 and 1.1.0
+	server := &fakeTarget{
+		versions: []map[string]any{{"id": 1, "name": "1.0.0"}, {"id": 2, "name": "1.1.0"}},
+		files:    []map[string]any{{"name": "ui-1.1.0.tgz", "size": 3}},
+	}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	viper.Set("GHMPKG_FORCE", true)
+	viper.Set("GHMPKG_CONFIRM_FORCE", true)
+	// Source deletion set up in a profile, but not confirmed
+	viper.Set("GHMPKG_DELETE_SOURCE", "npm")
+	for _, side := range []string{"SOURCE", "TARGET"} {
+		viper.Set("GHMPKG_"+side+"_TOKEN", "ghp_token")
+		viper.Set("GHMPKG_"+side+"_HOSTNAME", httpServer.URL)
+	}
+	viper.Set("GHMPKG_SOURCE_ORGANIZATION", "acme")
+	viper.Set("GHMPKG_TARGET_ORGANIZATION", "acme-new")
+	viper.Set("GHMPKG_AUDIT_LOG", filepath.Join(t.TempDir(), "audit.jsonl"))
+
+	report := common.NewReport()
+	if err := replaceVersion(zap.NewNop(), report, "web", "npm", "ui", "1.1.0"); err != nil {
+		t.Fatalf("replaceVersion() failed: %v", err)
+	}
+	deleteSource(zap.NewNop(), report, "web", "npm", "ui", "1.1.0", []string{stage(t, "ui-1.1.0.tgz")}, []string{"ui-1.1.0.tgz"})
+	if want := []string{"/api/v3/orgs/acme-new/packages/npm/ui/versions/2"}; !slices.Equal(server.deleted, want) {
+		t.Errorf("deleted %v, want only the target version %v", server.deleted, want)
+	}
+	if report.SourceVersionsDeleted != 0 {
+		t.Errorf("source versions deleted = %d, want 0", report.SourceVersionsDeleted)
+	}
+}
@@ -0,0 +1,287 @@
+package sync
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/api"
+	"github.com/mark-humane/gh-migrate-packages/internal/failures"
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// mavenMetadataFile is the version listing maven resolves version ranges,
+// LATEST and RELEASE with
+const mavenMetadataFile = "maven-metadata.xml"
+
+// mavenArtifact is a maven package of the work list with the coordinates
+// found from its pom filename
+type mavenArtifact struct {
+	repository  string
+	packageName string
+	groupID     string
+	artifactID  string
+}
+
+// mavenArtifacts returns the maven packages of export rows. The artifactId
+// is the pom filename up to its version, the groupId the rest of the
+// package name, which GitHub names groupId.artifactId.
+func mavenArtifacts(rows [][]string) []mavenArtifact {
+	var artifacts []mavenArtifact
+	seen := map[string]bool{}
+	for _, row := range rows {
+		if len(row) < 6 || row[2] != "maven" || seen[row[3]] {
+			continue
+		}
+		packageName := row[3]
+		file, ok := providers.ParseMavenFile(row[5], row[4])
+		if !ok || file.Extension != "pom" || file.Classifier != "" {
+			continue
+		}
+		artifactID := file.ArtifactID
+		groupID, ok := strings.CutSuffix(packageName, "."+artifactID)
+		if !ok {
+			dot := strings.LastIndex(packageName, ".")
+			if dot <= 0 {
+				continue
+			}
+			groupID, artifactID = packageName[:dot], packageName[dot+1:]
+		}
+		seen[packageName] = true
+		artifacts = append(artifacts, mavenArtifact{repository: row[1], packageName: packageName, groupID: groupID, artifactID: artifactID})
+	}
+	return artifacts
+}
+
+// mavenMetadata is the content of a maven-metadata.xml file
+type mavenMetadata struct {
+	XMLName    xml.Name `xml:"metadata"`
+	GroupID    string   `xml:"groupId"`
+	ArtifactID string   `xml:"artifactId"`
+	Versioning struct {
+		Latest      string   `xml:"latest,omitempty"`
+		Release     string   `xml:"release,omitempty"`
+		Versions    []string `xml:"versions>version"`
+		LastUpdated string   `xml:"lastUpdated"`
+	} `xml:"versioning"`
+}
+
+// buildMavenMetadata returns the maven-metadata.xml of an artifact listing
+// its versions in maven order. Latest is the highest version and release
+// the highest version that isn't a snapshot.
+func buildMavenMetadata(groupID, artifactID string, versions []string, now time.Time) ([]byte, error) {
+	var metadata mavenMetadata
+	metadata.GroupID = groupID
+	metadata.ArtifactID = artifactID
+	versions = slices.Clone(versions)
+	slices.SortFunc(versions, compareMavenVersions)
+	versions = slices.Compact(versions)
+	metadata.Versioning.Versions = versions
+	for _, version := range versions {
+		metadata.Versioning.Latest = version
+		if !strings.HasSuffix(strings.ToUpper(version), "-SNAPSHOT") {
+			metadata.Versioning.Release = version
+		}
+	}
+	metadata.Versioning.LastUpdated = now.UTC().Format("20060102150405")
+	content, err := xml.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(content, '\n')...), nil
+}
+
+// mavenQualifierRanks orders the qualifiers of maven versions, unknown
+// qualifiers come after them in lexical order
+var mavenQualifierRanks = map[string]int{
+	"alpha": 1, "a": 1, "beta": 2, "b": 2, "milestone": 3, "m": 3, "rc": 4, "cr": 4,
+	"snapshot": 5, "": 6, "ga": 6, "final": 6, "release": 6, "sp": 7,
+}
+
+// mavenTokens splits a maven version into its numbers and qualifiers,
+// separated by dots, hyphens and changes between digits and letters
+func mavenTokens(version string) []string {
+	var tokens []string
+	var current []rune
+	for _, r := range strings.ToLower(version) {
+		if r == '.' || r == '-' || r == '_' {
+			tokens = append(tokens, string(current))
+			current = nil
+			continue
+		}
+		if len(current) > 0 && unicode.IsDigit(r) != unicode.IsDigit(current[len(current)-1]) {
+			tokens = append(tokens, string(current))
+			current = nil
+		}
+		current = append(current, r)
+	}
+	return append(tokens, string(current))
+}
+
+// compareMavenTokens compares two tokens of maven versions, a missing token
+// compares as 0 against a number and as a release against a qualifier
+func compareMavenTokens(a, b string) int {
+	na, errA := strconv.ParseUint(a, 10, 64)
+	nb, errB := strconv.ParseUint(b, 10, 64)
+	switch {
+	case errA == nil && errB == nil:
+		return cmpUint(na, nb)
+	case errA == nil && b == "":
+		return cmpUint(na, 0)
+	case errB == nil && a == "":
+		return cmpUint(0, nb)
+	case errA == nil:
+		// Numbers come after qualifiers, 1-1 is newer than 1-rc
+		return 1
+	case errB == nil:
+		return -1
+	}
+	rankA, knownA := mavenQualifierRanks[a]
+	rankB, knownB := mavenQualifierRanks[b]
+	switch {
+	case knownA && knownB:
+		return rankA - rankB
+	case knownA:
+		return -1
+	case knownB:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func cmpUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareMavenVersions orders maven versions the way maven resolves them,
+// closely enough for version listings: 1.0-alpha < 1.0-rc1 < 1.0-SNAPSHOT <
+// 1.0 = 1.0.0 < 1.0-sp1 < 1.0.1 < 1.10
+func compareMavenVersions(a, b string) int {
+	tokensA, tokensB := mavenTokens(a), mavenTokens(b)
+	for i := range max(len(tokensA), len(tokensB)) {
+		var tokenA, tokenB string
+		if i < len(tokensA) {
+			tokenA = tokensA[i]
+		}
+		if i < len(tokensB) {
+			tokenB = tokensB[i]
+		}
+		if c := compareMavenTokens(tokenA, tokenB); c != 0 {
+			return c
+		}
+	}
+	// Equal versions written differently keep a stable order
+	return strings.Compare(a, b)
+}
+
+// PublishMavenMetadata regenerates the maven-metadata.xml of every maven
+// artifact of the work list from the versions in the target organization,
+// files published one by one leave it stale or missing, which breaks
+// version ranges. The registries other than GitHub maintain it themselves.
+// It returns the number of artifacts whose metadata was published.
+func PublishMavenMetadata(logger *zap.Logger, rows [][]string) int {
+	if registries.TargetName() != registries.GitHub || viper.GetBool("GHMPKG_SKIP_MAVEN_METADATA") {
+		return 0
+	}
+	artifacts := mavenArtifacts(rows)
+	if len(artifacts) == 0 {
+		return 0
+	}
+
+	token := viper.GetString("GHMPKG_TARGET_TOKEN")
+	hostname := viper.GetString("GHMPKG_TARGET_HOSTNAME")
+	owner := viper.GetString("GHMPKG_TARGET_ORGANIZATION")
+	provider := providers.NewMavenProvider(logger, "maven").(*providers.MavenProvider)
+	published := 0
+	for _, artifact := range artifacts {
+		fields := []zap.Field{zap.String("package_name", artifact.packageName), zap.String("groupId", artifact.groupID), zap.String("artifactId", artifact.artifactID)}
+		versions, err := api.PackageVersions(token, hostname, owner, "maven", artifact.packageName)
+		if err != nil {
+			// Packages that failed to publish are not found
+			if failures.Classify(err) != failures.NotFound {
+				logger.Warn("Failed to list target versions", append(fields, zap.Error(err))...)
+			}
+			continue
+		}
+		var names []string
+		for _, version := range versions {
+			names = append(names, version.GetName())
+		}
+		if len(names) == 0 {
+			continue
+		}
+		content, err := buildMavenMetadata(artifact.groupID, artifact.artifactID, names, time.Now())
+		if err == nil {
+			err = uploadMavenMetadata(logger, provider, owner, artifact, content)
+		}
+		if err != nil {
+			logger.Warn("Failed to publish maven metadata", append(fields, zap.Error(err))...)
+			pterm.Warning.Println(fmt.Sprintf("⚠️ Failed to publish %s of %s: %v", mavenMetadataFile, artifact.packageName, err))
+			continue
+		}
+		logger.Info("Published maven metadata", append(fields, zap.Int("versions", len(names)))...)
+		published++
+	}
+	return published
+}
+
+// uploadMavenMetadata publishes a maven-metadata.xml next to the versions of
+// an artifact with its .sha1 and .md5 checksums
+func uploadMavenMetadata(logger *zap.Logger, provider *providers.MavenProvider, owner string, artifact mavenArtifact, content []byte) error {
+	dir, err := os.MkdirTemp("", "maven-metadata-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	metadataPath := filepath.Join(dir, mavenMetadataFile)
+	if err := os.WriteFile(metadataPath, content, 0o644); err != nil {
+		return err
+	}
+	files := []string{metadataPath}
+	for _, algorithm := range []string{"sha1", "md5"} {
+		checksum, err := utils.FileChecksum(metadataPath, algorithm)
+		if err != nil {
+			return err
+		}
+		checksumPath := metadataPath + "." + algorithm
+		if err := os.WriteFile(checksumPath, []byte(checksum), 0o644); err != nil {
+			return err
+		}
+		files = append(files, checksumPath)
+	}
+
+	token := viper.GetString("GHMPKG_TARGET_TOKEN")
+	for _, file := range files {
+		uploadUrl, err := provider.GetUploadUrl(logger, owner, artifact.repository, artifact.packageName, "", filepath.Base(file))
+		if err != nil {
+			return err
+		}
+		response, err := utils.UploadFile(uploadUrl, file, token)
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		if response.StatusCode >= http.StatusMultipleChoices {
+			return fmt.Errorf("error uploading %s: %s", filepath.Base(file), response.Status)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package sync
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompareMavenVersions(t *testing.T) {
+	versions := []string{"1.10", "1.0.1", "1.0-sp1", "1.0", "1.0-SNAPSHOT", "1.0-rc1", "1.0-beta2", "1.0-alpha", "2.0-M3"}
+	slices.SortFunc(versions, compareMavenVersions)
+	want := []string{"1.0-alpha", "1.0-beta2", "1.0-rc1", "1.0-SNAPSHOT", "1.0", "1.0-sp1", "1.0.1", "1.10", "2.0-M3"}
+	if !slices.Equal(versions, want) {
+		t.Errorf("sorted = %v, want %v", versions, want)
+	}
+}
+
+func TestMavenArtifacts(t *testing.T) {
+	rows := [][]string{
+		{"org", "repo", "maven", "com.example.core-lib", "1.0", "core-lib-1.0.jar"},
+		{"org", "repo", "maven", "com.example.core-lib", "1.0", "core-lib-1.0.pom"},
+		{"org", "repo", "maven", "com.example.core-lib", "1.1", "core-lib-1.1.pom"},
+		{"org", "tools", "maven", "io.acme.cli", "2.0-SNAPSHOT", "cli-2.0-20240101.120000-1.pom"},
+		{"org", "repo", "npm", "left-pad", "1.0.0", "left-pad-1.0.0.tgz"},
+	}
+	artifacts := mavenArtifacts(rows)
+	want := []mavenArtifact{
+		{repository: "repo", packageName: "com.example.core-lib", groupID: "com.example", artifactID: "core-lib"},
+		{repository: "tools", packageName: "io.acme.cli", groupID: "io.acme", artifactID: "cli"},
+	}
+	if !slices.Equal(artifacts, want) {
+		t.Errorf("artifacts = %+v, want %+v", artifacts, want)
+	}
+}
+
+func TestBuildMavenMetadata(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	content, err := buildMavenMetadata("com.example", "core-lib", []string{"1.1", "2.0-SNAPSHOT", "1.0", "1.1"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<groupId>com.example</groupId>",
+		"<artifactId>core-lib</artifactId>",
+		"<latest>2.0-SNAPSHOT</latest>",
+		"<release>1.1</release>",
+		"<version>1.0</version>\n      <version>1.1</version>\n      <version>2.0-SNAPSHOT</version>\n    </versions>",
+		"<lastUpdated>20240501123000</lastUpdated>",
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("metadata is missing %q:\n%s", want, content)
+		}
+	}
+}
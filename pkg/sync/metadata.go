@@ -0,0 +1,134 @@
+package sync
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/api"
+	"github.com/mark-humane/gh-migrate-packages/internal/failures"
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/mark-humane/gh-migrate-packages/pkg/report"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// metadataDrift is an exported property of a package its copy in the
+// target organization doesn't have
+type metadataDrift struct {
+	property string
+	exported string
+	target   string
+}
+
+// compareMetadata lists the exported properties that differ in the target.
+// Repositories are compared by name, the organizations differ.
+func compareMetadata(exported, target common.PackageMetadata) []metadataDrift {
+	var drift []metadataDrift
+	if exported.Visibility != "" && !strings.EqualFold(exported.Visibility, target.Visibility) {
+		drift = append(drift, metadataDrift{"visibility", exported.Visibility, target.Visibility})
+	}
+	if exported.Repository != "" && !strings.EqualFold(exported.Repository, target.Repository) {
+		drift = append(drift, metadataDrift{"repository", exported.Repository, target.Repository})
+	}
+	if exported.Description != "" && exported.Description != target.Description {
+		drift = append(drift, metadataDrift{"description", exported.Description, target.Description})
+	}
+	return drift
+}
+
+// CheckMetadata compares the exported metadata of the processed packages
+// with their copies in the target organization. The repository link and
+// description are restored from the published content, GitHub has no API
+// to set them or the visibility, so differences are reported to be fixed
+// in the package settings. It returns the number of packages that differ.
+func CheckMetadata(logger *zap.Logger, owner string, packageStats map[string][]string) int {
+	if registries.SourceName() != registries.GitHub || registries.TargetName() != registries.GitHub {
+		return 0
+	}
+	var packageTypes []string
+	for packageType := range packageStats {
+		packageTypes = append(packageTypes, packageType)
+	}
+	exported, err := common.LoadExportedMetadata(logger, owner, packageTypes)
+	if err != nil {
+		logger.Warn("Failed to load exported package metadata", zap.Error(err))
+		return 0
+	}
+	if len(exported) == 0 {
+		return 0
+	}
+
+	token := viper.GetString("GHMPKG_TARGET_TOKEN")
+	hostname := viper.GetString("GHMPKG_TARGET_HOSTNAME")
+	targetOrg := viper.GetString("GHMPKG_TARGET_ORGANIZATION")
+	rows := [][]string{{"package_type", "package_name", "property", "exported", "target"}}
+	differing := 0
+	for _, packageType := range common.SUPPORTED_PACKAGE_TYPES {
+		for _, packageName := range packageStats[packageType] {
+			metadata, ok := exported[packageType][packageName]
+			if !ok {
+				continue
+			}
+			targetName := providers.TargetPackageName(packageType, packageName)
+			fields := []zap.Field{zap.String("package_type", packageType), zap.String("package_name", targetName)}
+			pkg, err := api.GetPackage(token, hostname, targetOrg, packageType, targetName)
+			if err != nil {
+				// Packages that failed to publish are not found
+				if failures.Classify(err) != failures.NotFound {
+					logger.Warn("Failed to look up target package metadata", append(fields, zap.Error(err))...)
+				}
+				continue
+			}
+			versions, err := api.PackageVersions(token, hostname, targetOrg, packageType, targetName)
+			if err != nil {
+				logger.Warn("Failed to list target versions", append(fields, zap.Error(err))...)
+				continue
+			}
+			drift := compareMetadata(metadata, common.NewPackageMetadata(pkg, versions))
+			if len(drift) == 0 {
+				continue
+			}
+			differing++
+			for _, d := range drift {
+				logger.Warn("Package metadata differs from the source", append(fields, zap.String("property", d.property), zap.String("exported", d.exported), zap.String("target", d.target))...)
+				pterm.Warning.Println(fmt.Sprintf("⚠️ %s %s: %s is %q in the source, %q in the target", packageType, targetName, d.property, d.exported, d.target))
+				rows = append(rows, []string{packageType, targetName, d.property, d.exported, d.target})
+			}
+		}
+	}
+	if differing == 0 {
+		return 0
+	}
+
+	output := filepath.Join(report.Dir, fmt.Sprintf("%s_metadata.csv", time.Now().Format("2006-01-02_15-04-05")))
+	if err := writeMetadataReport(output, rows); err != nil {
+		logger.Warn("Failed to write the metadata report", zap.String("file", output), zap.Error(err))
+		return differing
+	}
+	pterm.Info.Println(fmt.Sprintf("🏷️ Package metadata differences written to %s", output))
+	return differing
+}
+
+func writeMetadataReport(output string, rows [][]string) error {
+	if err := utils.EnsureDirExists(output); err != nil {
+		return err
+	}
+	file, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,36 @@
+package sync
+
+import (
+	"testing"
+
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+)
+
+func TestCompareMetadata(t *testing.T) {
+	exported := common.PackageMetadata{Visibility: "public", Repository: "Widgets", Description: "Widget library"}
+	tests := []struct {
+		target     common.PackageMetadata
+		properties []string
+	}{
+		{common.PackageMetadata{Visibility: "public", Repository: "widgets", Description: "Widget library"}, nil},
+		{common.PackageMetadata{Visibility: "private", Repository: "widgets", Description: "Widget library"}, []string{"visibility"}},
+		{common.PackageMetadata{Visibility: "internal"}, []string{"visibility", "repository", "description"}},
+	}
+	for _, test := range tests {
+		drift := compareMetadata(exported, test.target)
+		if len(drift) != len(test.properties) {
+			t.Errorf("compareMetadata(%+v) = %+v, expected %v", test.target, drift, test.properties)
+			continue
+		}
+		for i, d := range drift {
+			if d.property != test.properties[i] {
+				t.Errorf("compareMetadata(%+v) = %+v, expected %v", test.target, drift, test.properties)
+			}
+		}
+	}
+
+	// Properties the export didn't record are not compared
+	if drift := compareMetadata(common.PackageMetadata{}, common.PackageMetadata{Visibility: "private"}); len(drift) != 0 {
+		t.Errorf("compareMetadata of an empty export = %+v, expected none", drift)
+	}
+}
@@ -0,0 +1,54 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/results"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"go.uber.org/zap"
+)
+
+// pulledMissing returns the files the latest pull found missing from the
+// source, they were never staged and can't be published
+func pulledMissing(logger *zap.Logger) map[string]string {
+	missing := map[string]string{}
+	states, err := results.LatestStates(logger, results.Dir, "pull")
+	if err != nil {
+		logger.Warn("Failed to load the results of the latest pull", zap.Error(err))
+		return missing
+	}
+	for _, state := range states {
+		rows, err := results.Read(state.ResultsFile)
+		if err != nil {
+			logger.Warn("Failed to read results file", zap.String("file", state.ResultsFile), zap.Error(err))
+			continue
+		}
+		for _, row := range results.Latest(rows) {
+			if row.State == providers.SourceMissing.String() {
+				missing[row.Key()] = row.Error
+			}
+		}
+	}
+	return missing
+}
+
+// skipMissing records the files of a version the pull found missing from
+// the source and returns the files left to publish
+func skipMissing(report *common.Report, missing map[string]string, owner, packageType, packageName, version string, filenames []string) []string {
+	var remaining []string
+	for _, filename := range filenames {
+		key := results.Row{Organization: owner, PackageType: packageType, PackageName: packageName, Version: version, Filename: filename}.Key()
+		message, ok := missing[key]
+		if !ok {
+			remaining = append(remaining, filename)
+			continue
+		}
+		if message == "" {
+			message = fmt.Sprintf("%s was missing from the source when pulled", filename)
+		}
+		report.RecordFile(filename, providers.SourceMissing, errors.New(message))
+	}
+	return remaining
+}
@@ -0,0 +1,90 @@
+package sync
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// nameCheck is a package published under a sanitized name, or whose name
+// GitHub Packages rejects
+type nameCheck struct {
+	packageType string
+	name        string
+	target      string
+	problem     string
+}
+
+func (c nameCheck) String() string {
+	return c.packageType + " " + c.name
+}
+
+// checkNames checks the target names of the packages of export rows. It
+// returns the container images published under a sanitized name and the
+// packages whose names can't be published.
+func checkNames(rows [][]string) (renamed, invalid []nameCheck) {
+	seen := map[string]bool{}
+	for _, row := range rows {
+		key := row[2] + " " + row[3]
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		check := nameCheck{packageType: row[2], name: row[3], target: providers.TargetPackageName(row[2], row[3])}
+		if check.problem = providers.NameProblem(check.packageType, check.target); check.problem != "" {
+			invalid = append(invalid, check)
+		} else if check.packageType == "container" && check.target != strings.ToLower(check.name) {
+			renamed = append(renamed, check)
+		}
+	}
+	return renamed, invalid
+}
+
+// CheckTargetNames validates the names packages are published under before
+// anything is transferred. Container images are renamed to valid names,
+// other packages can't be renamed without rewriting them, so a run with
+// names GitHub Packages rejects stops unless GHMPKG_SKIP_INVALID_NAMES
+// leaves those packages out.
+func CheckTargetNames(logger *zap.Logger, rows [][]string) ([][]string, error) {
+	if registries.TargetName() != registries.GitHub {
+		return rows, nil
+	}
+	renamed, invalid := checkNames(rows)
+	for _, check := range renamed {
+		logger.Info("Publishing under a sanitized name", zap.String("package_type", check.packageType), zap.String("package_name", check.name), zap.String("targetName", check.target))
+		pterm.Info.Println(fmt.Sprintf("🔤 %s is published as %s", check, check.target))
+	}
+	if len(invalid) == 0 {
+		return rows, nil
+	}
+
+	rejected := map[string]bool{}
+	for _, check := range invalid {
+		rejected[check.packageType+" "+check.name] = true
+		logger.Error("Invalid target package name", zap.String("package_type", check.packageType), zap.String("package_name", check.name), zap.String("targetName", check.target), zap.String("problem", check.problem))
+	}
+	pterm.Error.Println(fmt.Sprintf("❌ GitHub Packages rejects the names of %d packages:", len(invalid)))
+	for _, check := range invalid[:min(len(invalid), 10)] {
+		fmt.Printf("  %s: %s\n", check, check.problem)
+	}
+	if len(invalid) > 10 {
+		fmt.Printf("  ... and %d more, see the log\n", len(invalid)-10)
+	}
+	if !viper.GetBool("GHMPKG_SKIP_INVALID_NAMES") {
+		return nil, fmt.Errorf("%d packages have names GitHub Packages rejects, rename NuGet packages with --package-mapping or pass --skip-invalid-names to leave them out", len(invalid))
+	}
+
+	var kept [][]string
+	for _, row := range rows {
+		if !rejected[row[2]+" "+row[3]] {
+			kept = append(kept, row)
+		}
+	}
+	pterm.Warning.Println(fmt.Sprintf("⚠️ Leaving out %d packages with invalid names", len(invalid)))
+	return kept, nil
+}
@@ -0,0 +1,52 @@
+package sync
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// unscopedNpmPackages returns the npm packages of export rows without a
+// scope
+func unscopedNpmPackages(rows [][]string) []string {
+	var names []string
+	for _, row := range rows {
+		if row[2] == "npm" && !strings.HasPrefix(row[3], "@") && !utils.Contains(names, row[3]) {
+			names = append(names, row[3])
+		}
+	}
+	return names
+}
+
+// ScopeNpmPackages gives unscoped npm packages the scope of the target
+// organization, which GitHub Packages requires. Dependencies on every
+// unscoped package of the export are scoped the same way, including
+// packages the current filters leave out, so packages published by
+// different runs depend on each other by the same names.
+func ScopeNpmPackages(logger *zap.Logger, owner string, rows [][]string) error {
+	if registries.TargetName() != registries.GitHub {
+		return nil
+	}
+	names, err := common.ExportedPackageNames(logger, owner, "npm")
+	if err != nil {
+		return err
+	}
+	names = append(names, unscopedNpmPackages(rows)...)
+	providers.SetNpmPackages(names)
+
+	unscoped := unscopedNpmPackages(rows)
+	if len(unscoped) == 0 {
+		return nil
+	}
+	scope := strings.ToLower(viper.GetString("GHMPKG_TARGET_ORGANIZATION"))
+	logger.Info("Scoping unscoped npm packages", zap.String("scope", scope), zap.Strings("packages", unscoped))
+	pterm.Info.Println(fmt.Sprintf("🏷️ %d unscoped npm packages are published as @%s/<name>", len(unscoped), scope))
+	return nil
+}
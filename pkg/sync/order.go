@@ -0,0 +1,128 @@
+package sync
+
+import (
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// dependencyOrder orders packages after the packages they depend on, names
+// keep their order otherwise. Packages in a dependency cycle can't be
+// ordered, they follow in their original order and are returned as cyclic.
+func dependencyOrder(names []string, dependencies map[string][]string) (ordered, cyclic []string) {
+	placed := map[string]bool{}
+	known := map[string]bool{}
+	for _, name := range names {
+		known[name] = true
+	}
+	ready := func(name string) bool {
+		for _, dependency := range dependencies[name] {
+			if known[dependency] && dependency != name && !placed[dependency] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for len(ordered) < len(names) {
+		next := ""
+		for _, name := range names {
+			if !placed[name] && ready(name) {
+				next = name
+				break
+			}
+		}
+		if next == "" {
+			break
+		}
+		placed[next] = true
+		ordered = append(ordered, next)
+	}
+	for _, name := range names {
+		if !placed[name] {
+			cyclic = append(cyclic, name)
+		}
+	}
+	return append(ordered, cyclic...), cyclic
+}
+
+// OrderNpmPackages moves exported npm packages after the packages of the
+// source organization they depend on, so installs of a dependent never
+// fail on a dependency that wasn't published yet. Other rows keep their
+// place.
+func OrderNpmPackages(logger *zap.Logger, packages [][]string) [][]string {
+	if !viper.GetBool("GHMPKG_NPM_DEPENDENCY_ORDER") {
+		return packages
+	}
+	var names []string
+	rows := map[string][][]string{}
+	first := -1
+	for i, pkg := range packages {
+		if pkg[2] != "npm" {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		if _, ok := rows[pkg[3]]; !ok {
+			names = append(names, pkg[3])
+		}
+		rows[pkg[3]] = append(rows[pkg[3]], pkg)
+	}
+	if len(names) < 2 {
+		return packages
+	}
+
+	provider, err := providers.NewProvider(logger, "npm")
+	if err != nil {
+		logger.Warn("Not ordering npm packages by dependencies", zap.Error(err))
+		return packages
+	}
+	npmProvider, ok := provider.(*providers.NPMProvider)
+	if !ok {
+		return packages
+	}
+
+	// Only dependencies in the scope of the source organization are published
+	// by the migration
+	dependencies := map[string][]string{}
+	for _, name := range names {
+		owner := rows[name][0][0]
+		versions := utils.GetFlatListOfColumn(rows[name], map[string]string{"3": name}, 4)
+		found, err := npmProvider.Dependencies(logger, owner, name, versions)
+		if err != nil {
+			logger.Warn("Failed to read npm dependencies, publishing the package in export order", zap.String("package_name", name), zap.Error(err))
+			continue
+		}
+		scope := "@" + strings.ToLower(owner) + "/"
+		for _, dependency := range found {
+			if strings.HasPrefix(strings.ToLower(dependency), scope) {
+				dependencies[name] = append(dependencies[name], dependency[len(scope):])
+			}
+		}
+	}
+
+	ordered, cyclic := dependencyOrder(names, dependencies)
+	if len(cyclic) > 0 {
+		logger.Warn("npm packages depend on each other in a cycle, publishing them in export order", zap.Strings("packages", cyclic))
+		pterm.Warning.Println("⚠️ Some npm packages depend on each other in a cycle, they are published in export order: " + strings.Join(cyclic, ", "))
+	}
+	logger.Info("Ordered npm packages by dependencies", zap.Strings("packages", ordered))
+
+	result := make([][]string, 0, len(packages))
+	for i, pkg := range packages {
+		if i == first {
+			for _, name := range ordered {
+				result = append(result, rows[name]...)
+			}
+		}
+		if pkg[2] != "npm" {
+			result = append(result, pkg)
+		}
+	}
+	return result
+}
@@ -0,0 +1,23 @@
+package sync
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDependencyOrder(t *testing.T) {
+	names := []string{"app", "ui", "core", "a", "b"}
+	dependencies := map[string][]string{
+		"app": {"ui", "core", "left-pad"},
+		"ui":  {"core"},
+		"a":   {"b"},
+		"b":   {"a"},
+	}
+	ordered, cyclic := dependencyOrder(names, dependencies)
+	if want := []string{"core", "ui", "app", "a", "b"}; !slices.Equal(ordered, want) {
+		t.Errorf("ordered = %v, want %v", ordered, want)
+	}
+	if want := []string{"a", "b"}; !slices.Equal(cyclic, want) {
+		t.Errorf("cyclic = %v, want %v", cyclic, want)
+	}
+}
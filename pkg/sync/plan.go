@@ -0,0 +1,308 @@
+package sync
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/audit"
+	"github.com/mark-humane/gh-migrate-packages/internal/oci"
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/internal/storage"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// PlanFormat is the version of the plan file format
+const PlanFormat = 1
+
+// DefaultPlanFile is where plan writes the plan unless an output is given
+const DefaultPlanFile = "migration-packages/plan.json"
+
+// PlanEndpoint is the registry and organization packages are read from or
+// published to
+type PlanEndpoint struct {
+	Registry     string `json:"registry"`
+	Hostname     string `json:"hostname,omitempty"`
+	Organization string `json:"organization"`
+}
+
+// PlanItem is a file the plan publishes, with the name and version it is
+// published under
+type PlanItem struct {
+	Organization  string `json:"organization"`
+	Repository    string `json:"repository"`
+	PackageType   string `json:"package_type"`
+	PackageName   string `json:"package_name"`
+	Version       string `json:"package_version"`
+	Filename      string `json:"package_filename"`
+	Size          int64  `json:"size"`
+	TargetName    string `json:"target_name"`
+	TargetVersion string `json:"target_version"`
+}
+
+// Plan is the exact list of files a sync publishes. The digest covers
+// everything but itself and the signature, the signature is an HMAC of
+// the digest with GHMPKG_PLAN_SECRET.
+type Plan struct {
+	Format    int               `json:"format"`
+	RunID     string            `json:"run_id"`
+	CreatedAt time.Time         `json:"created_at"`
+	Source    PlanEndpoint      `json:"source"`
+	Target    PlanEndpoint      `json:"target"`
+	Settings  map[string]string `json:"settings"`
+	Bytes     int64             `json:"bytes"`
+	Items     []PlanItem        `json:"items"`
+	Digest    string            `json:"digest"`
+	Signature string            `json:"signature,omitempty"`
+}
+
+// row returns the export row of a plan item
+func (i PlanItem) row() []string {
+	size := ""
+	if i.Size >= 0 {
+		size = strconv.FormatInt(i.Size, 10)
+	}
+	return []string{i.Organization, i.Repository, i.PackageType, i.PackageName, i.Version, i.Filename, size}
+}
+
+// key identifies the file of a plan item
+func (i PlanItem) key() string {
+	return strings.Join([]string{i.PackageType, i.PackageName, i.Version, i.Filename}, " ")
+}
+
+// digest returns the SHA-256 of the canonical JSON of a plan without its
+// digest and signature
+func (p Plan) digest() (string, error) {
+	p.Digest, p.Signature = "", ""
+	content, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// sign returns the HMAC-SHA256 of a digest with a secret
+func sign(digest, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(digest))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// currentEndpoints returns the source and target of the configuration
+func currentEndpoints() (PlanEndpoint, PlanEndpoint) {
+	source := PlanEndpoint{registries.SourceName(), viper.GetString("GHMPKG_SOURCE_HOSTNAME"), viper.GetString("GHMPKG_SOURCE_ORGANIZATION")}
+	target := PlanEndpoint{registries.TargetName(), viper.GetString("GHMPKG_TARGET_HOSTNAME"), viper.GetString("GHMPKG_TARGET_ORGANIZATION")}
+	return source, target
+}
+
+// planItems resolves the export rows to the names and versions their files
+// are published under with the current settings
+func planItems(root, owner string, rows [][]string) ([]PlanItem, error) {
+	retag, err := oci.ParseRetagRules(viper.GetString("GHMPKG_RETAG"))
+	if err != nil {
+		return nil, err
+	}
+	items := make([]PlanItem, 0, len(rows))
+	for _, pkg := range rows {
+		item := PlanItem{
+			Organization: pkg[0],
+			Repository:   pkg[1],
+			PackageType:  pkg[2],
+			PackageName:  pkg[3],
+			Version:      pkg[4],
+			Filename:     pkg[5],
+			Size:         -1,
+			TargetName:   providers.TargetPackageName(pkg[2], pkg[3]),
+			// Container versions are published under their retagged tag
+			TargetVersion: pkg[4],
+		}
+		if size, ok := fileSize(root, owner, pkg); ok {
+			item.Size = size
+		}
+		if item.PackageType == "container" {
+			if item.TargetVersion, err = retag.Apply(item.Filename[strings.LastIndex(item.Filename, ":")+1:]); err != nil {
+				return nil, err
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// WritePlan resolves the exported packages selected by the current filters
+// to the exact files a sync publishes and writes them to a plan file,
+// signed when GHMPKG_PLAN_SECRET is set
+func WritePlan(logger *zap.Logger, output string) (*Plan, error) {
+	owner := viper.GetString("GHMPKG_SOURCE_ORGANIZATION")
+	packageTypes := common.SUPPORTED_PACKAGE_TYPES
+	if desiredPackageType := viper.GetString("GHMPKG_PACKAGE_TYPE"); desiredPackageType != "" {
+		if !utils.Contains(common.SUPPORTED_PACKAGE_TYPES, desiredPackageType) {
+			return nil, fmt.Errorf("unsupported package type: %s", desiredPackageType)
+		}
+		packageTypes = []string{desiredPackageType}
+	}
+	rows, _, err := common.LoadExportedPackages(logger, owner, packageTypes)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no package export files found")
+	}
+	rows = OrderNpmPackages(logger, rows)
+	if rows, err = CheckTargetNames(logger, rows); err != nil {
+		return nil, err
+	}
+
+	items, err := planItems(storage.PackagesRoot, owner, rows)
+	if err != nil {
+		return nil, err
+	}
+	source, target := currentEndpoints()
+	plan := &Plan{
+		Format:    PlanFormat,
+		RunID:     utils.RunID(),
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Source:    source,
+		Target:    target,
+		Settings:  audit.Settings(),
+		Items:     items,
+	}
+	for _, item := range items {
+		if item.Size > 0 {
+			plan.Bytes += item.Size
+		}
+	}
+	if plan.Digest, err = plan.digest(); err != nil {
+		return nil, err
+	}
+	if secret := viper.GetString("GHMPKG_PLAN_SECRET"); secret != "" {
+		plan.Signature = sign(plan.Digest, secret)
+	}
+
+	if output == "" {
+		output = DefaultPlanFile
+	}
+	content, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := utils.EnsureDirExists(output); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(output, append(content, '\n'), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write plan: %w", err)
+	}
+	logger.Info("Wrote plan", zap.String("file", output), zap.Int("files", len(items)), zap.String("digest", plan.Digest), zap.Bool("signed", plan.Signature != ""))
+	return plan, nil
+}
+
+// LoadPlan reads a plan file and checks that it wasn't edited since it was
+// written. A signed plan needs GHMPKG_PLAN_SECRET, and with the secret set
+// only plans signed with it are accepted.
+func LoadPlan(path string) (*Plan, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan: %w", err)
+	}
+	var plan Plan
+	if err := json.Unmarshal(content, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan: %w", err)
+	}
+	if plan.Format != PlanFormat {
+		return nil, fmt.Errorf("unsupported plan format %d", plan.Format)
+	}
+	digest, err := plan.digest()
+	if err != nil {
+		return nil, err
+	}
+	if digest != plan.Digest {
+		return nil, fmt.Errorf("plan %s was modified, its digest doesn't match its content", path)
+	}
+	secret := viper.GetString("GHMPKG_PLAN_SECRET")
+	switch {
+	case secret == "" && plan.Signature != "":
+		return nil, fmt.Errorf("plan %s is signed, set GHMPKG_PLAN_SECRET to verify it", path)
+	case secret != "" && plan.Signature == "":
+		return nil, fmt.Errorf("plan %s is not signed", path)
+	case secret != "" && !hmac.Equal([]byte(sign(plan.Digest, secret)), []byte(plan.Signature)):
+		return nil, fmt.Errorf("plan %s has an invalid signature", path)
+	}
+	return &plan, nil
+}
+
+// planDrift compares a plan with the current configuration and staged files
+// and describes every difference: another source or target, files
+// published under other names, or staged files of another size
+func planDrift(plan *Plan, source, target PlanEndpoint, current []PlanItem) []string {
+	var drift []string
+	if source.Organization != plan.Source.Organization || source.Registry != plan.Source.Registry {
+		drift = append(drift, fmt.Sprintf("source is %s %s, the plan has %s %s", source.Registry, source.Organization, plan.Source.Registry, plan.Source.Organization))
+	}
+	if target != plan.Target {
+		drift = append(drift, fmt.Sprintf("target is %s %s %s, the plan has %s %s %s", target.Registry, target.Hostname, target.Organization, plan.Target.Registry, plan.Target.Hostname, plan.Target.Organization))
+	}
+	for i, item := range plan.Items {
+		now := current[i]
+		if now.TargetName != item.TargetName || now.TargetVersion != item.TargetVersion {
+			drift = append(drift, fmt.Sprintf("%s would be published as %s@%s, the plan has %s@%s", item.key(), now.TargetName, now.TargetVersion, item.TargetName, item.TargetVersion))
+		}
+		if item.Size >= 0 && now.Size >= 0 && now.Size != item.Size {
+			drift = append(drift, fmt.Sprintf("%s is %d bytes, the plan has %d", item.key(), now.Size, item.Size))
+		}
+	}
+	return drift
+}
+
+// planRows returns the export rows of a plan after checking that the
+// current settings and staged files still match it
+func planRows(logger *zap.Logger, path string) ([][]string, error) {
+	plan, err := LoadPlan(path)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([][]string, len(plan.Items))
+	for i, item := range plan.Items {
+		rows[i] = item.row()
+	}
+	current, err := planItems(storage.PackagesRoot, plan.Source.Organization, rows)
+	if err != nil {
+		return nil, err
+	}
+	// Sizes are compared with the staged files only, files not pulled yet
+	// are checked when they are published
+	for i, row := range rows {
+		current[i].Size = -1
+		if size, ok := stagedSize(storage.PackagesRoot, plan.Source.Organization, row); ok {
+			current[i].Size = size
+		}
+	}
+	source, target := currentEndpoints()
+	if drift := planDrift(plan, source, target, current); len(drift) > 0 {
+		for _, difference := range drift {
+			logger.Error("Plan drift", zap.String("difference", difference))
+		}
+		pterm.Error.Println(fmt.Sprintf("❌ The current settings or staged files differ from plan %s:", path))
+		for _, difference := range drift[:min(len(drift), 10)] {
+			fmt.Printf("  %s\n", difference)
+		}
+		if len(drift) > 10 {
+			fmt.Printf("  ... and %d more, see the log\n", len(drift)-10)
+		}
+		return nil, fmt.Errorf("refusing to sync, %d differences from plan %s", len(drift), path)
+	}
+	logger.Info("Syncing plan", zap.String("file", path), zap.String("digest", plan.Digest), zap.String("planRunID", plan.RunID), zap.Int("files", len(rows)))
+	pterm.Info.Println(fmt.Sprintf("📋 Syncing %d files of plan %s (%s)", len(rows), path, plan.Digest))
+	return rows, nil
+}
@@ -0,0 +1,64 @@
+package sync
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestLoadPlan(t *testing.T) {
+	defer viper.Set("GHMPKG_PLAN_SECRET", "")
+	plan := Plan{
+		Format:    PlanFormat,
+		CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Target:    PlanEndpoint{Registry: "github", Organization: "acme-new"},
+		Items:     []PlanItem{{PackageType: "npm", PackageName: "@acme/ui", Version: "1.0.0", Filename: "ui-1.0.0.tgz", Size: 120, TargetName: "@acme/ui", TargetVersion: "1.0.0"}},
+	}
+	plan.Digest, _ = plan.digest()
+	plan.Signature = sign(plan.Digest, "approved")
+	write := func(plan Plan) string {
+		content, _ := json.MarshalIndent(plan, "", "  ")
+		path := filepath.Join(t.TempDir(), "plan.json")
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	viper.Set("GHMPKG_PLAN_SECRET", "approved")
+	if _, err := LoadPlan(write(plan)); err != nil {
+		t.Errorf("LoadPlan() of a signed plan failed: %v", err)
+	}
+	edited := plan
+	edited.Items = []PlanItem{plan.Items[0], plan.Items[0]}
+	if _, err := LoadPlan(write(edited)); err == nil {
+		t.Error("LoadPlan() accepted a plan with an added item")
+	}
+	viper.Set("GHMPKG_PLAN_SECRET", "other")
+	if _, err := LoadPlan(write(plan)); err == nil {
+		t.Error("LoadPlan() accepted a plan signed with another secret")
+	}
+	viper.Set("GHMPKG_PLAN_SECRET", "")
+	if _, err := LoadPlan(write(plan)); err == nil {
+		t.Error("LoadPlan() accepted a signed plan without the secret")
+	}
+}
+
+func TestPlanDrift(t *testing.T) {
+	plan := &Plan{
+		Target: PlanEndpoint{Registry: "github", Organization: "acme-new"},
+		Items:  []PlanItem{{PackageType: "container", PackageName: "app", Filename: "app:v1", Size: 10, TargetName: "app", TargetVersion: "v1"}},
+	}
+	current := []PlanItem{{Size: -1, TargetName: "app", TargetVersion: "v1"}}
+	if drift := planDrift(plan, PlanEndpoint{}, plan.Target, current); len(drift) != 0 {
+		t.Errorf("planDrift() = %v, expected none", drift)
+	}
+	current = []PlanItem{{Size: 12, TargetName: "app", TargetVersion: "legacy-v1"}}
+	if drift := planDrift(plan, PlanEndpoint{}, PlanEndpoint{Registry: "github", Organization: "acme"}, current); len(drift) != 3 {
+		t.Errorf("planDrift() = %v, expected a target, name and size difference", drift)
+	}
+}
@@ -0,0 +1,104 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/api"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/internal/storage"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// gigabyte is the unit GitHub bills storage in
+const gigabyte = 1000 * 1000 * 1000
+
+// stagedSize returns the size of the staged file of an export row
+func stagedSize(root, owner string, pkg []string) (int64, bool) {
+	packageType, packageName, version, filename := pkg[2], pkg[3], pkg[4], pkg[5]
+	versionDir := filepath.Join(root, owner, packageType, packageName, version)
+	if packageType == "container" {
+		// Image archives are staged per tag under lowercase names
+		versionDir = filepath.Join(strings.ToLower(filepath.Join(root, owner, packageType, packageName)), filename[strings.LastIndex(filename, ":")+1:])
+	}
+	info, err := os.Stat(stagedFile([]string{versionDir}, packageType, packageName, version, filename, 0))
+	if err != nil {
+		return 0, false
+	}
+	return info.Size(), true
+}
+
+// fileSize returns the size of a file, taken from the staged file when it
+// was pulled and from the export otherwise
+func fileSize(root, owner string, pkg []string) (int64, bool) {
+	if size, ok := stagedSize(root, owner, pkg); ok {
+		return size, true
+	}
+	return common.ExportedSize(pkg)
+}
+
+// plannedBytes sums the size of the exported files of the desired package
+// type. Files of unknown size are counted.
+func plannedBytes(root, owner, desiredPackageType string, packages [][]string) (total int64, unknown int) {
+	for _, pkg := range packages {
+		if desiredPackageType != "" && pkg[2] != desiredPackageType {
+			continue
+		}
+		if size, ok := fileSize(root, owner, pkg); ok {
+			total += size
+		} else {
+			unknown++
+		}
+	}
+	return total, unknown
+}
+
+// CheckStorageBudget compares the storage the exported files will take in
+// the target organization, added to the storage it already uses, with
+// GHMPKG_STORAGE_BUDGET. Going over the budget is a warning unless
+// GHMPKG_STORAGE_BUDGET_ABORT is set.
+func CheckStorageBudget(logger *zap.Logger, packages [][]string) error {
+	value := viper.GetString("GHMPKG_STORAGE_BUDGET")
+	if value == "" {
+		return nil
+	}
+	budget, err := utils.ParseByteSize(value)
+	if err != nil {
+		return fmt.Errorf("invalid storage budget %q: %w", value, err)
+	}
+
+	planned, unknown := plannedBytes(storage.PackagesRoot, viper.GetString("GHMPKG_SOURCE_ORGANIZATION"), viper.GetString("GHMPKG_PACKAGE_TYPE"), packages)
+	var used int64
+	if registries.TargetName() == registries.GitHub {
+		// Reading billing needs an organization owner or billing manager
+		gb, err := api.StorageUsed(viper.GetString("GHMPKG_TARGET_TOKEN"), viper.GetString("GHMPKG_TARGET_HOSTNAME"), viper.GetString("GHMPKG_TARGET_ORGANIZATION"))
+		if err != nil {
+			logger.Warn("Failed to read the storage used by the target organization", zap.Error(err))
+			pterm.Warning.Println(fmt.Sprintf("⚠️ Storage used by the target organization is unknown, only the planned uploads are compared with the budget: %v", err))
+		} else {
+			used = int64(gb * gigabyte)
+		}
+	}
+	logger.Info("Checked storage budget", zap.Int64("plannedBytes", planned), zap.Int("unknownFiles", unknown), zap.Int64("usedBytes", used), zap.Int64("budgetBytes", budget))
+	if unknown > 0 {
+		pterm.Warning.Println(fmt.Sprintf("⚠️ Size of %d file(s) is unknown and not included in the storage estimate", unknown))
+	}
+
+	if used+planned <= budget {
+		pterm.Info.Println(fmt.Sprintf("💾 Storage: %s used + %s planned of a %s budget", utils.FormatBytes(used), utils.FormatBytes(planned), utils.FormatBytes(budget)))
+		return nil
+	}
+	message := fmt.Sprintf("%s used + %s planned exceeds the storage budget of %s", utils.FormatBytes(used), utils.FormatBytes(planned), utils.FormatBytes(budget))
+	if viper.GetBool("GHMPKG_STORAGE_BUDGET_ABORT") {
+		return fmt.Errorf("%s", message)
+	}
+	logger.Warn("Storage budget exceeded", zap.String("message", message))
+	pterm.Warning.Println("💾 " + message + ", storage over the allowance is billed")
+	return nil
+}
@@ -0,0 +1,33 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlannedBytes(t *testing.T) {
+	root := t.TempDir()
+	versionDir := filepath.Join(root, "acme", "maven", "lib", "1.0")
+	if err := os.MkdirAll(versionDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(versionDir, "lib-1.0.jar"), make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	packages := [][]string{
+		{"acme", "repo", "maven", "lib", "1.0", "lib-1.0.jar", "999"},
+		{"acme", "repo", "maven", "lib", "1.0", "lib-1.0.pom", "20"},
+		{"acme", "repo", "maven", "lib", "1.0", "lib-1.0-sources.jar", ""},
+		{"acme", "repo", "npm", "widgets", "1.0.3", "widgets-1.0.3.tgz", "50"},
+	}
+
+	total, unknown := plannedBytes(root, "acme", "", packages)
+	if total != 170 || unknown != 1 {
+		t.Errorf("plannedBytes() = %d, %d, expected 170, 1", total, unknown)
+	}
+	total, unknown = plannedBytes(root, "acme", "npm", packages)
+	if total != 50 || unknown != 0 {
+		t.Errorf("plannedBytes(npm) = %d, %d, expected 50, 0", total, unknown)
+	}
+}
@@ -0,0 +1,63 @@
+package sync
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/oci"
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/signatures"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// verifySignatures applies the signature policy to a version before it is
+// published, an error keeps the version from being published
+func verifySignatures(logger *zap.Logger, provider providers.Provider, repository, packageType, packageName, version string, filenames, versionDirs []string) error {
+	policy, err := signatures.Policy()
+	if err != nil {
+		return err
+	}
+	if policy == signatures.Off || !signatures.Supported(packageType) {
+		return nil
+	}
+
+	if packageType == "maven" {
+		err = signatures.VerifyMaven(logger, versionDirs[0], packageName, version, filenames)
+	} else {
+		owner := viper.GetString("GHMPKG_SOURCE_ORGANIZATION")
+		for _, filename := range filenames {
+			// Signatures and attestations copied as referrers are not images
+			if oci.IsArtifactTag(filename[strings.LastIndex(filename, ":")+1:]) {
+				continue
+			}
+			image, urlErr := provider.GetDownloadUrl(logger, owner, repository, packageName, version, filename)
+			if urlErr != nil {
+				return urlErr
+			}
+			image = strings.TrimPrefix(strings.TrimPrefix(image, "https://"), "http://")
+			if err = signatures.VerifyImage(logger, image, packageName, version); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		logger.Info("Signatures verified")
+		return nil
+	}
+
+	switch policy {
+	case signatures.Warn:
+		pterm.Warning.Println(fmt.Sprintf("⚠️ Publishing %s@%s without a valid signature: %v", packageName, version, err))
+		return nil
+	case signatures.Quarantine:
+		if qErr := signatures.Isolate(logger, versionDirs); qErr != nil {
+			logger.Error("Failed to quarantine package files", zap.Error(qErr))
+		}
+		pterm.Error.Println(fmt.Sprintf("🔒 Quarantined %s@%s: %v", packageName, version, err))
+	default:
+		pterm.Error.Println(fmt.Sprintf("❌ Not publishing %s@%s: %v", packageName, version, err))
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/utils"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/pterm/pterm"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// MaxFileSize returns the largest file published, set with
+// GHMPKG_MAX_FILE_SIZE. Zero means files of any size are published.
+func MaxFileSize() (int64, error) {
+	value := viper.GetString("GHMPKG_MAX_FILE_SIZE")
+	if value == "" {
+		return 0, nil
+	}
+	size, err := utils.ParseByteSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid maximum file size %q: %w", value, err)
+	}
+	return size, nil
+}
+
+// stagedFile returns the path a file of a version is staged at, images are
+// staged per tag and npm tarballs under the package name and version
+func stagedFile(versionDirs []string, packageType, packageName, version, filename string, index int) string {
+	switch packageType {
+	case "container":
+		tag := filename[strings.LastIndex(filename, ":")+1:]
+		return filepath.Join(versionDirs[index], fmt.Sprintf("%s-%s.tar", strings.ToLower(packageName), tag))
+	case "npm":
+		return filepath.Join(versionDirs[0], fmt.Sprintf("%s-%s.tgz", packageName, version))
+	}
+	return filepath.Join(versionDirs[0], filename)
+}
+
+// skipTooLarge records the staged files of a version over the maximum file
+// size and returns the files left to publish. Files that can't be found
+// are left to the provider.
+func skipTooLarge(logger *zap.Logger, report *common.Report, versionDirs []string, packageType, packageName, version string, filenames []string) ([]string, error) {
+	limit, err := MaxFileSize()
+	if err != nil || limit == 0 {
+		return filenames, err
+	}
+	var remaining []string
+	for i, filename := range filenames {
+		info, err := os.Stat(stagedFile(versionDirs, packageType, packageName, version, filename, i))
+		if err != nil || info.Size() <= limit {
+			remaining = append(remaining, filename)
+			continue
+		}
+		logger.Warn("Not publishing file over the maximum file size", zap.String("filename", filename), zap.Int64("size", info.Size()), zap.Int64("maxFileSize", limit))
+		pterm.Warning.Println(fmt.Sprintf("📏 Skipped %s, %s is over the maximum file size of %s", filename, utils.FormatBytes(info.Size()), utils.FormatBytes(limit)))
+		report.RecordFile(filename, providers.TooLarge, fmt.Errorf("size %s exceeds the maximum file size %s", utils.FormatBytes(info.Size()), utils.FormatBytes(limit)))
+	}
+	return remaining, nil
+}
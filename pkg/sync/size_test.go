@@ -0,0 +1,24 @@
+package sync
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStagedFile(t *testing.T) {
+	dirs := []string{filepath.Join("pkgs", "v1"), filepath.Join("pkgs", "v2")}
+	tests := []struct {
+		packageType, packageName, version, filename string
+		index                                       int
+		expected                                    string
+	}{
+		{"maven", "lib", "1.0", "lib-1.0.jar", 0, filepath.Join("pkgs", "v1", "lib-1.0.jar")},
+		{"npm", "widgets", "1.0.3", "widgets-1.0.3.tgz", 0, filepath.Join("pkgs", "v1", "widgets-1.0.3.tgz")},
+		{"container", "API", "sha256:abc", "API:v2", 1, filepath.Join("pkgs", "v2", "api-v2.tar")},
+	}
+	for _, test := range tests {
+		if actual := stagedFile(dirs, test.packageType, test.packageName, test.version, test.filename, test.index); actual != test.expected {
+			t.Errorf("stagedFile(%s %s) = %s, expected %s", test.packageType, test.filename, actual, test.expected)
+		}
+	}
+}
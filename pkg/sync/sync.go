@@ -1,13 +1,20 @@
 package sync
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"time"
 
-	"github.com/mark-humane/gh-migrate-packages/internal/files"
+	"github.com/mark-humane/gh-migrate-packages/internal/hooks"
+	"github.com/mark-humane/gh-migrate-packages/internal/metrics"
 	"github.com/mark-humane/gh-migrate-packages/internal/providers"
+	"github.com/mark-humane/gh-migrate-packages/internal/registries"
+	"github.com/mark-humane/gh-migrate-packages/internal/storage"
 	"github.com/mark-humane/gh-migrate-packages/internal/utils"
 	"github.com/mark-humane/gh-migrate-packages/pkg/common"
 	"github.com/pterm/pterm"
@@ -17,10 +24,11 @@ import (
 
 var SUPPORTED_PACKAGE_TYPES = common.SUPPORTED_PACKAGE_TYPES
 
-func checkPath(logger *zap.Logger) {
-	if !utils.FileExists("./tool/gpr") {
-		utils.EnsureDirExists("./tool")
-		installCmd := exec.Command("dotnet", "tool", "install", "gpr", "--add-source", "https://api.nuget.org/v3/index.json", "--tool-path", "./tool")
+// CheckPath installs the gpr tool used to publish nuget packages when missing
+func CheckPath(logger *zap.Logger) {
+	if !utils.FileExists(providers.GprPath()) {
+		utils.EnsureDirExists(providers.GprPath())
+		installCmd := exec.Command("dotnet", "tool", "install", "gpr", "--add-source", "https://api.nuget.org/v3/index.json", "--tool-path", filepath.Dir(providers.GprPath()))
 		installCmd.Stdout = os.Stdout
 		installCmd.Stderr = os.Stderr
 		if err := installCmd.Run(); err != nil {
@@ -30,14 +38,11 @@ func checkPath(logger *zap.Logger) {
 	}
 }
 
-func Upload(logger *zap.Logger, provider providers.Provider, report *common.Report, repository, packageType, packageName, version string, filenames []string) error {
+func Upload(logger *zap.Logger, provider providers.Provider, report *common.Report, repository, packageType, packageName, version string, filenames []string) (err error) {
 	owner := viper.GetString("GHMPKG_TARGET_ORGANIZATION")
 	zapFields := []zap.Field{
 		zap.String("owner", owner),
 		zap.String("repository", repository),
-		zap.String("packageType", packageType),
-		zap.String("packageName", packageName),
-		zap.String("version", version),
 	}
 
 	pterm.Info.Println(fmt.Sprintf("📦 package: %s", packageName))
@@ -49,14 +54,116 @@ func Upload(logger *zap.Logger, provider providers.Provider, report *common.Repo
 		pterm.Info.Println("📂 repository: (n/a, org scoped)")
 	}
 
+	event := hooks.Event{
+		SourceOrganization: viper.GetString("GHMPKG_SOURCE_ORGANIZATION"),
+		TargetOrganization: owner,
+		Repository:         repository,
+		PackageType:        packageType,
+		PackageName:        packageName,
+		PackageVersion:     version,
+		Files:              filenames,
+	}
+
+	// Packages can be published to another registry instead of GitHub
+	target, err := registries.NewTarget()
+	if err != nil {
+		return err
+	}
+
+	// Fetch staged files back from a remote storage backend
+	packageDir := filepath.Join(storage.PackagesRoot, viper.GetString("GHMPKG_SOURCE_ORGANIZATION"), packageType, packageName)
+	versionDirs := []string{filepath.Join(packageDir, version)}
+	if packageType == "container" {
+		// Image archives are staged per tag under lowercase names
+		packageDir = strings.ToLower(packageDir)
+		versionDirs = nil
+		for _, filename := range filenames {
+			versionDirs = append(versionDirs, filepath.Join(packageDir, filename[strings.LastIndex(filename, ":")+1:]))
+		}
+	}
+	for _, versionDir := range versionDirs {
+		if err := storage.Restore(context.Background(), logger, versionDir); err != nil {
+			logger.Error("Failed to restore staged files", append(zapFields, zap.Error(err))...)
+			return err
+		}
+		defer func(versionDir string) {
+			if err := storage.Release(logger, versionDir); err != nil {
+				logger.Warn("Failed to remove restored files", append(zapFields, zap.Error(err))...)
+			}
+		}(versionDir)
+	}
+	if len(versionDirs) > 0 {
+		event.Dir = versionDirs[0]
+	}
+
+	// The version is deleted from the source when opted in, checked against
+	// the staged files it was published from, then staged files of a version
+	// that was published completely are removed to free disk space. Failed
+	// versions are kept for the next run.
+	incomplete := false
+	defer func() {
+		if err == nil && !incomplete {
+			deleteSource(logger, report, repository, packageType, packageName, version, versionDirs, filenames)
+			storage.Clean(logger, versionDirs...)
+		}
+	}()
+
+	// Files over the maximum file size would be rejected by the registry,
+	// their version stays staged for a rerun with a higher limit
+	remaining, err := skipTooLarge(logger, report, versionDirs, packageType, packageName, version, filenames)
+	if err != nil {
+		return err
+	}
+	if len(remaining) < len(filenames) {
+		incomplete = true
+		filenames = remaining
+		event.Files = remaining
+	}
+	if len(filenames) == 0 {
+		return nil
+	}
+
+	if err := verifySignatures(logger, provider, repository, packageType, packageName, version, filenames, versionDirs); err != nil {
+		logger.Error("Signature verification failed", append(zapFields, zap.Error(err))...)
+		return err
+	}
+
+	event.Hook = hooks.PrePublish
+	if err := hooks.Run(logger, event); err != nil {
+		logger.Error("Pre-publish hook failed", append(zapFields, zap.Error(err))...)
+		pterm.Error.Println(fmt.Sprintf("❌ Pre-publish hook rejected %s@%s", packageName, version))
+		return err
+	}
+	defer func() {
+		event.Hook = hooks.PostPublish
+		event.Result = strings.ToLower(providers.Success.String())
+		if err != nil {
+			event.Result = strings.ToLower(providers.Failed.String())
+			event.Error = err.Error()
+		}
+		hooks.Run(logger, event)
+	}()
+
+	// Versions already in the target are deleted first with --force
+	if err := replaceVersion(logger, report, repository, packageType, packageName, version); err != nil {
+		logger.Error("Failed to replace target version", append(zapFields, zap.Error(err))...)
+		pterm.Error.Println(fmt.Sprintf("❌ Failed to replace %s@%s: %v", packageName, version, err))
+		return err
+	}
+
+	provider = providers.WithTarget(provider, target)
+
 	// Special case for Maven packages
 	if mavenProvider, ok := provider.(*providers.MavenProvider); ok {
-		results, err := mavenProvider.UploadBatch(logger, owner, repository, packageType, packageName, version, filenames)
+		done := metrics.TrackInFlight("upload")
+		results, durations, err := mavenProvider.UploadBatch(logger, owner, repository, packageType, packageName, version, filenames)
+		done()
 		if err != nil {
 			return err
 		}
 		for i, result := range results {
-			report.IncFiles(result)
+			incomplete = incomplete || result == providers.Failed
+			report.RecordTransfer(filenames[i], result, nil, durations[i])
 			if result == providers.Success {
 				pterm.Success.Println(fmt.Sprintf("✅ %s", filenames[i]))
 			}
@@ -65,39 +172,71 @@ func Upload(logger *zap.Logger, provider providers.Provider, report *common.Repo
 	}
 
 	// Regular sequential upload for other package types
-	var err error
 	for _, filename := range filenames {
+		done := metrics.TrackInFlight("upload")
+		start := time.Now()
 		result, err := provider.Upload(logger, owner, repository, packageType, packageName, version, filename)
+		done()
 		if err != nil {
 			logger.Error("Failed to upload package", append(zapFields,
 				zap.String("filename", filename),
 				zap.Error(err))...)
 			pterm.Error.Println(fmt.Sprintf("❌ Failed to upload: %s", filename))
+			report.RecordTransfer(filename, providers.Failed, err, time.Since(start))
 			return err
 		}
-		report.IncFiles(result)
+		report.RecordTransfer(filename, result, nil, time.Since(start))
 		if result == providers.Success {
 			pterm.Success.Println(fmt.Sprintf("✅ %s", filename))
 		}
 	}
 
-	return err
+	return nil
 }
 
-func Sync(logger *zap.Logger) error {
+func Sync(logger *zap.Logger) (err error) {
+	logger = logger.With(zap.String("phase", "sync"))
 	startTime := time.Now()
+	var report *common.Report
+	phase := common.StartPhase(logger, "sync")
+	defer func() { phase.Finish(logger, report, err) }()
 	utils.ResetRequestCounters()
-	checkPath(logger)
+	CheckPath(logger)
 	owner := viper.GetString("GHMPKG_SOURCE_ORGANIZATION")
 	targetOwner := viper.GetString("GHMPKG_TARGET_ORGANIZATION")
 	desiredPackageType := viper.GetString("GHMPKG_PACKAGE_TYPE")
 
+	if err := CheckDeleteSource(logger); err != nil {
+		return err
+	}
+	if err := CheckForce(logger); err != nil {
+		return err
+	}
+	if _, err := MaxFileSize(); err != nil {
+		return err
+	}
+	if _, err := utils.ChunkSize(); err != nil {
+		return err
+	}
+	if _, err := providers.NpmScopes(); err != nil {
+		return err
+	}
+	if _, err := providers.NugetConflictPolicy(); err != nil {
+		return err
+	}
+	unlock, err := common.LockTarget(logger, "sync")
+	if err != nil {
+		pterm.Error.Println(fmt.Sprintf("❌ Sync stopped: %v", err))
+		return err
+	}
+	defer unlock()
+
 	pterm.Info.Println("Starting sync process...")
 	spinner, _ := pterm.DefaultSpinner.Start(fmt.Sprintf("Syncing packages to target org: %s", targetOwner))
 
-	packageTypes := SUPPORTED_PACKAGE_TYPES
+	packageTypes := common.SUPPORTED_PACKAGE_TYPES
 	if desiredPackageType != "" {
-		if !utils.Contains(SUPPORTED_PACKAGE_TYPES, desiredPackageType) {
+		if !utils.Contains(common.SUPPORTED_PACKAGE_TYPES, desiredPackageType) {
 			spinner.Fail(fmt.Sprintf("Unsupported package type: %s", desiredPackageType))
 			return fmt.Errorf("unsupported package type: %s", desiredPackageType)
 		}
@@ -106,85 +245,71 @@ func Sync(logger *zap.Logger) error {
 
 	var allPackages [][]string
 	packageStats := make(map[string][]string)
-
-	for _, pkgType := range packageTypes {
-		logger.Info("Processing package type", zap.String("type", pkgType))
-		pterm.Info.Println(fmt.Sprintf("Processing %s packages...", pkgType))
-		pkgTypeDir := fmt.Sprintf("./migration-packages/export/%s", pkgType)
-		if _, err := os.Stat(pkgTypeDir); os.IsNotExist(err) {
-			logger.Warn("Package type directory not found",
-				zap.String("packageType", pkgType),
-				zap.String("directory", pkgTypeDir))
-			continue
+	if planFile := viper.GetString("GHMPKG_PLAN"); planFile != "" {
+		// A plan publishes exactly its files, in its order, instead of the
+		// packages the filters select from the export
+		common.StopSpinner(spinner)
+		if allPackages, err = planRows(logger, planFile); err != nil {
+			return err
 		}
-
-		pattern := fmt.Sprintf("./migration-packages/export/%s/*_%s_%s_packages.csv", desiredPackageType, owner, desiredPackageType)
-		logger.Info("Searching for CSV with pattern", zap.String("pattern", pattern))
-
-		matches, err := utils.FindMostRecentFile(pattern)
-		if err != nil {
-			// Try alternate pattern without owner in filename
-			altPattern := fmt.Sprintf("./migration-packages/export/%s/*_%s_packages.csv", pkgType, pkgType)
-			logger.Info("Trying alternate pattern",
-				zap.String("altPattern", altPattern))
-
-			matches, err = utils.FindMostRecentFile(altPattern)
-			if err != nil {
-				logger.Warn("No export file found for package type",
-					zap.String("packageType", pkgType),
-					zap.Error(err))
-				continue
+		for _, pkg := range allPackages {
+			if !utils.Contains(packageStats[pkg[2]], pkg[3]) {
+				packageStats[pkg[2]] = append(packageStats[pkg[2]], pkg[3])
 			}
 		}
-
-		logger.Info("Found CSV file",
-			zap.String("packageType", pkgType),
-			zap.String("file", matches))
-
-		packages, err := files.ReadCSV(matches)
+	} else {
+		allPackages, packageStats, err = common.LoadExportedPackages(logger, owner, packageTypes)
 		if err != nil {
-			spinner.Fail(fmt.Sprintf("Error reading CSV file: %v", err))
+			spinner.Fail(err.Error())
 			return err
 		}
+		allPackages = OrderNpmPackages(logger, allPackages)
+	}
+	allPackages, err = common.StartAfter(logger, allPackages)
+	common.StopSpinner(spinner)
+	if err != nil {
+		pterm.Error.Println(err.Error())
+		return err
+	}
 
-		logger.Info("CSV content sample",
-			zap.String("packageType", pkgType),
-			zap.Int("totalRows", len(packages)),
-			zap.Any("firstRows", packages[:min(len(packages), 3)]))
-
-		if len(packages) <= 1 {
-			pterm.Warning.Println(fmt.Sprintf("No package data found in CSV for %s", pkgType))
-			continue
-		}
+	if allPackages, err = CheckTargetNames(logger, allPackages); err != nil {
+		pterm.Error.Println(fmt.Sprintf("❌ Sync stopped: %v", err))
+		return err
+	}
+	if err := ScopeNpmPackages(logger, owner, allPackages); err != nil {
+		pterm.Error.Println(fmt.Sprintf("❌ Sync stopped: %v", err))
+		return err
+	}
+	if err := CheckStorageBudget(logger, allPackages); err != nil {
+		pterm.Error.Println(fmt.Sprintf("❌ Sync stopped: %v", err))
+		return err
+	}
 
-		allPackages = append(allPackages, packages[1:]...)
-		for _, pkg := range packages[1:] {
-			if _, ok := packageStats[pkgType]; ok {
-				if utils.Contains(packageStats[pkgType], pkg[3]) {
-					continue
-				} else {
-					packageStats[pkgType] = append(packageStats[pkgType], pkg[3])
-				}
-			} else {
-				packageStats[pkgType] = []string{pkg[3]}
-			}
+	// Files the pull found missing from the source are recorded again
+	missing := pulledMissing(logger)
+	upload := func(logger *zap.Logger, provider providers.Provider, report *common.Report, repository, packageType, packageName, version string, filenames []string) error {
+		if filenames = skipMissing(report, missing, owner, packageType, packageName, version, filenames); len(filenames) == 0 {
+			return nil
 		}
-
-		pterm.Info.Println(fmt.Sprintf("Found %d packages in CSV for %s", len(packageStats[pkgType]), pkgType))
+		return Upload(logger, provider, report, repository, packageType, packageName, version, filenames)
 	}
 
-	var report *common.Report
-	var err error
-	if report, err = common.ProcessPackages(logger, allPackages, Upload, true); err != nil {
-		spinner.Fail(fmt.Sprintf("Error syncing package: %v", err))
+	report, err = common.ProcessPackages(logger, "Sync", allPackages, upload, true)
+	if errors.Is(err, common.ErrFailFast) {
+		pterm.Error.Println(fmt.Sprintf("Sync stopped: %v", err))
+		report.PrintFailures()
+		return err
+	}
+	if err != nil {
+		pterm.Error.Println(fmt.Sprintf("Error syncing package: %v", err))
 		return err
 	}
 	if report.PackageSuccess == 0 {
-		spinner.Fail("No packages were synced")
+		pterm.Error.Println("No packages were synced")
 	} else if report.PackagesFailed > 0 {
-		spinner.Warning("Sync completed with some errors, Please check the logs for more details")
+		pterm.Warning.Println("Sync completed with some errors, Please check the logs for more details")
 	} else {
-		spinner.Success("Sync completed")
+		pterm.Success.Println("Sync completed")
 	}
 
 	// Calculate duration
@@ -197,7 +322,7 @@ func Sync(logger *zap.Logger) error {
 	fmt.Printf("✅ Successfully processed: %d packages\n", report.PackageSuccess)
 	fmt.Printf("❌ Failed: %d packages\n", report.PackagesFailed)
 
-	for _, pkgType := range SUPPORTED_PACKAGE_TYPES {
+	for _, pkgType := range common.SUPPORTED_PACKAGE_TYPES {
 		if count := len(packageStats[pkgType]); count > 0 {
 			emoji := "📦"
 			name := pkgType
@@ -206,6 +331,22 @@ func Sync(logger *zap.Logger) error {
 	}
 
 	//fmt.Printf("📁 Output directory: migration-packages/packages/(%s)\n", strings.Join(packageTypes, ", "))
+	if report.SourceVersionsDeleted > 0 {
+		fmt.Printf("🗑️ Deleted from source: %d versions\n", report.SourceVersionsDeleted)
+	}
+	if report.TargetVersionsReplaced > 0 {
+		fmt.Printf("♻️ Replaced in the target: %d versions\n", report.TargetVersionsReplaced)
+	}
+	if published := PublishMavenMetadata(logger, allPackages); published > 0 {
+		fmt.Printf("🧭 Regenerated maven-metadata.xml: %d artifacts\n", published)
+	}
+	if differing := CheckMetadata(logger, owner, packageStats); differing > 0 {
+		fmt.Printf("🏷️ Metadata differs from the source: %d packages\n", differing)
+	}
+	report.PrintFailures()
+	report.PrintSourceMissing()
+	report.PrintTooLarge()
+	report.PrintPerformance(duration)
 	fmt.Printf("🕐 Total time: %dh %dm %ds\n\n", hours, minutes, seconds)
 	fmt.Println("✅ Sync completed successfully!")
 
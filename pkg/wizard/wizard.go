@@ -0,0 +1,189 @@
+package wizard
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mark-humane/gh-migrate-packages/internal/api"
+	"github.com/mark-humane/gh-migrate-packages/internal/profile"
+	"github.com/mark-humane/gh-migrate-packages/pkg/common"
+	"github.com/pterm/pterm"
+	"go.uber.org/zap"
+	"golang.org/x/term"
+)
+
+// Authentication methods offered for tokens
+const (
+	authGitHubCLI = "GitHub CLI (gh auth token)"
+	authEnv       = "Environment variable"
+	authFile      = "Token file"
+	authKeychain  = "OS keychain"
+	authGit       = "Git credential helper"
+)
+
+// allTypes migrates every supported package type
+const allTypes = "all"
+
+// tokenFrom returns the token_from of an authentication method, the GitHub
+// CLI is asked for the token of the hostname
+func tokenFrom(method, value, hostname string) string {
+	host := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(hostname, "https://"), "http://"), "/")
+	switch method {
+	case authGitHubCLI:
+		if host != "" {
+			return "command:gh auth token --hostname " + host
+		}
+		return "command:gh auth token"
+	case authFile:
+		return "file:" + value
+	case authKeychain:
+		return "keychain:" + value
+	case authGit:
+		if host == "" {
+			host = "github.com"
+		}
+		return "git-credential:" + host
+	}
+	return "env:" + value
+}
+
+// promptSide asks for the hostname, organization and authentication of the
+// source or target and checks the token can see the organization
+func promptSide(logger *zap.Logger, label string, current profile.Side) (profile.Side, error) {
+	for {
+		hostname, err := pterm.DefaultInteractiveTextInput.WithDefaultValue(current.Hostname).Show(fmt.Sprintf("%s GitHub Enterprise Server URL, empty for GitHub.com", label))
+		if err != nil {
+			return current, err
+		}
+		organization, err := pterm.DefaultInteractiveTextInput.WithDefaultValue(current.Organization).Show(fmt.Sprintf("%s organization", label))
+		if err != nil {
+			return current, err
+		}
+		method, err := pterm.DefaultInteractiveSelect.WithOptions([]string{authGitHubCLI, authEnv, authFile, authKeychain, authGit}).Show(fmt.Sprintf("%s token", label))
+		if err != nil {
+			return current, err
+		}
+		var value string
+		switch method {
+		case authEnv:
+			value, err = pterm.DefaultInteractiveTextInput.WithDefaultValue(fmt.Sprintf("GHMPKG_%s_TOKEN", strings.ToUpper(label))).Show("Environment variable holding the token")
+		case authFile:
+			value, err = pterm.DefaultInteractiveTextInput.Show("Path of the file holding the token")
+		case authKeychain:
+			value, err = pterm.DefaultInteractiveTextInput.WithDefaultValue("gh-migrate-packages/" + strings.ToLower(label)).Show("Keychain service holding the token, optionally followed by /account")
+		}
+		if err != nil {
+			return current, err
+		}
+		side := profile.Side{
+			Hostname:     strings.TrimSpace(hostname),
+			Organization: strings.TrimSpace(organization),
+			TokenFrom:    tokenFrom(method, strings.TrimSpace(value), strings.TrimSpace(hostname)),
+		}
+
+		checkErr := check(side)
+		if checkErr == nil {
+			pterm.Success.Println(fmt.Sprintf("✅ %s organization %s is reachable", label, side.Organization))
+			return side, nil
+		}
+		logger.Warn("Failed to validate settings", zap.String("side", label), zap.Error(checkErr))
+		pterm.Error.Println(fmt.Sprintf("❌ %s: %v", label, checkErr))
+		keep, err := pterm.DefaultInteractiveConfirm.WithDefaultValue(false).Show("Keep these settings anyway?")
+		if err != nil {
+			return current, err
+		}
+		if keep {
+			return side, nil
+		}
+		current = side
+	}
+}
+
+// check reads the token of a side and looks up its organization
+func check(side profile.Side) error {
+	if side.Organization == "" {
+		return errors.New("the organization is required")
+	}
+	token, err := profile.ReadToken(side.TokenFrom)
+	if err != nil {
+		return err
+	}
+	if _, err := api.Organization(token, side.Hostname, side.Organization); err != nil {
+		return fmt.Errorf("the token can't access %s: %w", side.Organization, err)
+	}
+	return nil
+}
+
+// Run asks for the settings of a migration and writes them as a profile to
+// the config file, other profiles of the file are kept
+func Run(logger *zap.Logger, path, name string) error {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return errors.New("init is interactive and needs a terminal, write the config file by hand instead")
+	}
+	if path == "" {
+		path = profile.DefaultFile
+	}
+	file, err := profile.Load(path)
+	if errors.Is(err, os.ErrNotExist) {
+		file, err = &profile.File{}, nil
+	}
+	if err != nil {
+		return err
+	}
+	if file.Profiles == nil {
+		file.Profiles = map[string]profile.Profile{}
+	}
+
+	if name == "" {
+		if name, err = pterm.DefaultInteractiveTextInput.WithDefaultValue("default").Show("Profile name"); err != nil {
+			return err
+		}
+		name = strings.TrimSpace(name)
+	}
+	current, exists := file.Profiles[name]
+	if exists {
+		replace, err := pterm.DefaultInteractiveConfirm.WithDefaultValue(false).Show(fmt.Sprintf("Profile %s exists in %s, replace it?", name, path))
+		if err != nil {
+			return err
+		}
+		if !replace {
+			return errors.New("kept the existing profile")
+		}
+	}
+
+	source, err := promptSide(logger, "Source", current.Source)
+	if err != nil {
+		return err
+	}
+	target, err := promptSide(logger, "Target", current.Target)
+	if err != nil {
+		return err
+	}
+	packageType, err := pterm.DefaultInteractiveSelect.WithOptions(append([]string{allTypes}, common.SUPPORTED_PACKAGE_TYPES...)).Show("Package types to migrate")
+	if err != nil {
+		return err
+	}
+	if packageType == allTypes {
+		packageType = ""
+	}
+
+	// Settings the wizard doesn't ask for are kept from the replaced profile
+	current.Source, current.Target, current.PackageType = source, target, packageType
+	file.Profiles[name] = current
+	if file.DefaultProfile == "" {
+		file.DefaultProfile = name
+	}
+	if err := file.Save(path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	logger.Info("Wrote profile", zap.String("file", path), zap.String("profile", name))
+	pterm.Success.Println(fmt.Sprintf("📝 Wrote profile %s to %s", name, path))
+	if file.DefaultProfile == name {
+		pterm.Info.Println("Run gh migrate-packages export to start, the profile is the default")
+	} else {
+		pterm.Info.Println(fmt.Sprintf("Run gh migrate-packages export --profile %s to start", name))
+	}
+	return nil
+}
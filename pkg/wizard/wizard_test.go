@@ -0,0 +1,22 @@
+package wizard
+
+import "testing"
+
+func TestTokenFrom(t *testing.T) {
+	tests := []struct {
+		method, value, hostname, expected string
+	}{
+		{authGitHubCLI, "", "", "command:gh auth token"},
+		{authGitHubCLI, "", "https://ghes.example.com/", "command:gh auth token --hostname ghes.example.com"},
+		{authEnv, "SOURCE_TOKEN", "", "env:SOURCE_TOKEN"},
+		{authFile, "/run/secrets/token", "", "file:/run/secrets/token"},
+		{authKeychain, "gh-migrate-packages/source", "", "keychain:gh-migrate-packages/source"},
+		{authGit, "", "https://ghes.example.com/", "git-credential:ghes.example.com"},
+		{authGit, "", "", "git-credential:github.com"},
+	}
+	for _, test := range tests {
+		if actual := tokenFrom(test.method, test.value, test.hostname); actual != test.expected {
+			t.Errorf("tokenFrom(%q, %q, %q) = %q, expected %q", test.method, test.value, test.hostname, actual, test.expected)
+		}
+	}
+}